@@ -20,7 +20,7 @@ export default function Profile() {
       .catch()
       .then((response) => {
         response.json().then((jRsp) => {
-          setHistory(jRsp);
+          setHistory(jRsp.links);
         });
       });
   }, [cookies.auth]);
@@ -0,0 +1,392 @@
+// Command allinone runs all the services in one process, for demos, CI and
+// small deployments. Events go over the in-process bus, the cache is an
+// in-process redis and data is stored in SQLite, so nothing else has to be
+// running. Events that haven't been stored yet are lost on exit.
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"shortener/internal/authenticator"
+	"shortener/internal/blackbox"
+	"shortener/internal/redirector"
+	"shortener/internal/shortener"
+	"shortener/internal/storage"
+	"shortener/internal/viewer"
+	"shortener/pkg/bus"
+	"shortener/pkg/bus/memory"
+	"shortener/pkg/config"
+	"shortener/pkg/dlq"
+	"shortener/pkg/health"
+	"shortener/pkg/mailer"
+	"shortener/pkg/metrics"
+	"shortener/pkg/middleware"
+	"shortener/pkg/models/attempts"
+	"shortener/pkg/models/sqlite"
+	"shortener/pkg/models/tokens"
+	"shortener/pkg/renewal"
+	"shortener/pkg/shutdown"
+	"shortener/pkg/tracing"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/justinas/alice"
+	"github.com/redis/go-redis/extra/redisotel/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	pbblackbox "shortener/proto/blackbox"
+)
+
+func main() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).
+		With().
+		Timestamp().
+		Logger()
+
+	conf := config.Default()
+	// short links are served by this process
+	conf.Links.RedirectorHost = "localhost:8080"
+	if err := config.Load(conf, os.Args[1:]); err != nil {
+		log.Fatal().Err(err).Msg("couldn't load config")
+	}
+	if err := conf.Require("blackbox.secret"); err != nil {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+	conf.Log(&log)
+
+	shutdownTracing, err := tracing.Setup(
+		context.TODO(),
+		"allinone",
+		conf.Tracing.Exporter,
+		conf.Tracing.Endpoint,
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't set up tracing")
+	}
+	defer shutdownTracing(context.TODO())
+
+	ctx, cancel := signal.NotifyContext(
+		context.Background(),
+		syscall.SIGTERM,
+		syscall.SIGINT,
+	)
+	defer cancel()
+
+	topics := conf.Topics
+
+	db, err := sqlite.Open(ctx, conf.AllInOne.SqlitePath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't open sqlite database")
+	}
+	defer db.Close()
+	log.Info().Msg("successfully opened sqlite database")
+
+	cache, err := miniredis.Run()
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't start in-process redis")
+	}
+	defer cache.Close()
+	go expireKeys(ctx, cache)
+
+	rdb := redis.NewClient(&redis.Options{Addr: cache.Addr()})
+	if err := redisotel.InstrumentTracing(rdb); err != nil {
+		log.Fatal().Err(err).Msg("couldn't instrument redis client")
+	}
+	defer rdb.Close()
+
+	hc, err := health.New(
+		health.WithCheck("sqlite", health.Ping(db)),
+		health.WithCheck("redis", health.Redis(rdb)),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate health checks")
+	}
+	go metrics.Serve(ctx, conf.Admin.Addr, &log, hc.Mount)
+
+	b, err := memory.New()
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate in-process bus")
+	}
+	defer b.Close()
+	publisher := tracing.NewPublisher(b)
+
+	box, stopBlackbox := startBlackbox(&log, rdb, conf.Blackbox.Secret)
+	defer stopBlackbox()
+
+	attemptsModel, err := attempts.New(attempts.WithRedis(rdb))
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate login attempts model")
+	}
+
+	var m authenticator.Mailer
+	if conf.SMTP.Addr != "" {
+		m, err = mailer.NewSMTP(
+			mailer.WithServer(conf.SMTP.Addr),
+			mailer.WithSender(conf.SMTP.From),
+			mailer.WithCredentials(conf.SMTP.Username, conf.SMTP.Password),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate smtp mailer")
+		}
+	} else {
+		m = mailer.NewLog(&log)
+	}
+
+	a, err := authenticator.New(
+		authenticator.WithUsersDB(sqlite.NewUsers(db)),
+		authenticator.WithBlackboxClient(box),
+		authenticator.WithPublisher(topics.Users, publisher),
+		authenticator.WithMailer(m),
+		authenticator.WithAttempts(attemptsModel),
+		authenticator.WithAuditLog(sqlite.NewAuditLog(db)),
+		authenticator.WithResetLink(conf.Links.PasswordReset),
+		authenticator.WithWorkspaces(sqlite.NewWorkspaces(db)),
+		authenticator.WithInviteLink(conf.Links.WorkspaceInvite),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate authenticator")
+	}
+	defer a.Wait()
+
+	urlsModel := sqlite.NewUrls(db)
+	s, err := shortener.New(
+		shortener.WithUrlsModel(urlsModel),
+		shortener.WithBlackboxClient(box),
+		shortener.WithPublisher(publisher, topics.Urls),
+		shortener.WithRedirectorHost(conf.Links.RedirectorHost),
+		shortener.WithRenewalPolicy(conf.Renewal.Policy()),
+		// without jobs every bulk upload is created in its request
+		shortener.WithBulkMaxRows(conf.Bulk.MaxRows),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate shortener")
+	}
+
+	v, err := viewer.New(
+		viewer.WithUrls(urlsModel),
+		viewer.WithBlackboxClient(box),
+		viewer.WithRedirectorHost(conf.Links.RedirectorHost),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate viewer")
+	}
+
+	autoRenewer, err := renewal.NewAutoRenewer(
+		renewal.WithUrls(urlsModel),
+		renewal.WithPolicy(conf.Renewal.Policy()),
+		renewal.WithCheckEvery(conf.Renewal.CheckEvery),
+		renewal.WithLogger(&log),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate auto-renewer")
+	}
+	go autoRenewer.Run(ctx)
+
+	re, err := redirector.New(
+		redirector.WithUrlsModel(urlsModel),
+		redirector.WithAutoRenewer(autoRenewer),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate redirector")
+	}
+
+	// consumers stop once the servers have stopped, so that events of the
+	// last requests are stored
+	consumeCtx, stopConsuming := context.WithCancel(context.Background())
+	defer stopConsuming()
+
+	h, err := storage.New(
+		storage.WithLogger(&log),
+		storage.WithContext(consumeCtx),
+		storage.WithUrlsTopic(topics.Urls),
+		storage.WithUrlsModel(urlsModel),
+		storage.WithUsersTopic(topics.Users),
+		storage.WithUsersModel(sqlite.NewUsers(db)),
+		storage.WithDeadLetterQueue(publisher, topics.DLQ),
+		storage.WithResultTopic(publisher, topics.Results),
+		storage.WithShutdownTimeout(conf.Shutdown.Timeout),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate storage handler")
+	}
+
+	var consumers sync.WaitGroup
+	consumers.Add(2)
+	go func() {
+		defer consumers.Done()
+		if err := b.Subscribe(consumeCtx, h.Topics(), h); err != nil {
+			log.Error().Err(err).Msg("storage consumption exited with an error")
+		}
+	}()
+	go func() {
+		defer consumers.Done()
+		// nobody else reads these topics, and the bus keeps messages until
+		// they are acknowledged
+		d := &drain{dlqTopic: topics.DLQ, log: &log}
+		if err := b.Subscribe(consumeCtx, []string{topics.DLQ, topics.Results}, d); err != nil {
+			log.Error().Err(err).Msg("draining exited with an error")
+		}
+	}()
+	defer func() {
+		stopConsuming()
+		consumers.Wait()
+	}()
+
+	// services without an address of their own share http.addr
+	services := []struct {
+		addr  string
+		mount func(mux *http.ServeMux, c alice.Chain, cors middleware.Cors)
+	}{
+		{conf.AllInOne.AuthenticatorAddr, a.Mount},
+		{conf.AllInOne.ShortenerAddr, s.Mount},
+		{conf.AllInOne.ViewerAddr, v.Mount},
+		{conf.AllInOne.RedirectorAddr, re.Mount},
+	}
+	chain := middleware.RequestTracing(&log)
+	cors := middleware.Cors{Origin: conf.Cors.Origin}
+	muxes := map[string]*http.ServeMux{}
+	for _, svc := range services {
+		addr := svc.addr
+		if addr == "" {
+			addr = conf.HTTP.Addr
+		}
+		mux, ok := muxes[addr]
+		if !ok {
+			mux = http.NewServeMux()
+			hc.Mount(mux)
+			muxes[addr] = mux
+		}
+		svc.mount(mux, chain, cors)
+	}
+
+	var servers sync.WaitGroup
+	for addr, mux := range muxes {
+		server := &http.Server{
+			Addr:         addr,
+			Handler:      tracing.Handler(metrics.Instrument(mux), mux),
+			ReadTimeout:  conf.HTTP.ReadTimeout,
+			WriteTimeout: conf.HTTP.WriteTimeout,
+			IdleTimeout:  conf.HTTP.IdleTimeout,
+		}
+
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			log.Info().Str("addr", server.Addr).Msg("listening for connections")
+			err := shutdown.Serve(
+				ctx,
+				server,
+				hc,
+				conf.Shutdown.DrainDelay,
+				conf.Shutdown.Timeout,
+			)
+			if err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Str("addr", server.Addr).Msg("server failed")
+				cancel()
+			} else if err != nil {
+				log.Error().Err(err).Str("addr", server.Addr).Msg("error during shutdown")
+			}
+		}()
+	}
+	servers.Wait()
+	log.Info().Msg("servers have stopped")
+}
+
+// startBlackbox serves the blackbox service on an in-process listener and
+// returns a client of it
+func startBlackbox(
+	log *zerolog.Logger,
+	rdb *redis.Client,
+	secret string,
+) (pbblackbox.BlackboxServiceClient, func()) {
+	generations, err := tokens.New(tokens.WithRedis(rdb))
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate tokens model")
+	}
+
+	service, err := blackbox.New(
+		blackbox.WithSecret(secret),
+		blackbox.WithGenerations(generations),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate blackbox service")
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(metrics.UnaryServerInterceptor),
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+	)
+	pbblackbox.RegisterBlackboxServiceServer(s, service)
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Error().Err(err).Msg("blackbox serve error")
+		}
+	}()
+
+	conn, err := grpc.NewClient(
+		"passthrough:///blackbox",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(metrics.UnaryClientInterceptor),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't dial blackbox service")
+	}
+
+	return pbblackbox.NewBlackboxServiceClient(conn), func() {
+		conn.Close()
+		s.GracefulStop()
+	}
+}
+
+// expireKeys advances the clock of the in-process redis, which doesn't
+// expire keys on its own
+func expireKeys(ctx context.Context, cache *miniredis.Miniredis) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			cache.FastForward(now.Sub(last))
+			last = now
+		}
+	}
+}
+
+// drain acknowledges dead letters, logging them, and storage results
+type drain struct {
+	dlqTopic string
+	log      *zerolog.Logger
+}
+
+func (d *drain) Consume(c bus.Claim) error {
+	for m := range c.Messages() {
+		if c.Topic() == d.dlqTopic {
+			d.log.Warn().
+				Str("topic", m.Header(dlq.HeaderTopic)).
+				Str("error", m.Header(dlq.HeaderError)).
+				Msg("message has been dead-lettered")
+		}
+		c.Ack(m)
+	}
+	return nil
+}
@@ -2,20 +2,36 @@ package main
 
 import (
 	"context"
-	"errors"
 	"net/http"
 	"os"
 	"os/signal"
 	"shortener/internal/authenticator"
+	"shortener/pkg/bus"
+	"shortener/pkg/bus/kafka"
+	natsbus "shortener/pkg/bus/nats"
+	"shortener/pkg/config"
+	"shortener/pkg/health"
+	"shortener/pkg/mailer"
+	"shortener/pkg/metrics"
 	"shortener/pkg/middleware"
+	"shortener/pkg/models/attempts"
+	"shortener/pkg/models/audit"
+	"shortener/pkg/models/reminders"
 	"shortener/pkg/models/users"
+	"shortener/pkg/models/workspaces"
+	"shortener/pkg/outbox"
+	"shortener/pkg/shutdown"
+	"shortener/pkg/tracing"
 	"shortener/proto/blackbox"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/extra/redisotel/v9"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -27,9 +43,31 @@ func main() {
 		Timestamp().
 		Logger()
 
+	conf := config.Default()
+	if err := config.Load(conf, os.Args[1:]); err != nil {
+		log.Fatal().Err(err).Msg("couldn't load config")
+	}
+	if err := conf.Require("postgres.dsn"); err != nil {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+	conf.Log(&log)
+
+	shutdownTracing, err := tracing.Setup(
+		context.TODO(),
+		"authenticator",
+		conf.Tracing.Exporter,
+		conf.Tracing.Endpoint,
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't set up tracing")
+	}
+	defer shutdownTracing(context.TODO())
+
 	conn, err := grpc.NewClient(
-		"blackbox:8080",
+		conf.Blackbox.Addr,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(metrics.UnaryClientInterceptor),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("couldn't dial blackbox service")
@@ -39,80 +77,237 @@ func main() {
 	box := blackbox.NewBlackboxServiceClient(conn)
 
 	usersModel, err := users.NewUsers(
-		users.WithPool(context.TODO(), os.Getenv("POSTGRES_DSN")),
+		users.WithPool(context.TODO(), conf.Postgres.DSN),
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("couldn't instantiate users model")
 	}
 	defer usersModel.Close()
+	metrics.RegisterPool("users", usersModel)
 
-	conf := sarama.NewConfig()
-	conf.Producer.RequiredAcks = sarama.WaitForAll
-	conf.Producer.Flush.Frequency = 500 * time.Millisecond
-	conf.Producer.Return.Errors = false
+	auditModel, err := audit.New(
+		audit.WithPool(context.TODO(), conf.Postgres.DSN),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate audit log model")
+	}
+	defer auditModel.Close()
+	metrics.RegisterPool("audit", auditModel)
 
-	if err = conf.Validate(); err != nil {
-		log.Fatal().Err(err).Msg("invalid kafka config")
+	workspacesModel, err := workspaces.New(
+		workspaces.WithPool(context.TODO(), conf.Postgres.DSN),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate workspaces model")
 	}
+	defer workspacesModel.Close()
+	metrics.RegisterPool("workspaces", workspacesModel)
 
-	p, err := sarama.NewAsyncProducer(
-		strings.Split(os.Getenv("KAFKA_BROKERS"), ","),
-		conf,
+	remindersModel, err := reminders.New(
+		reminders.WithPool(context.TODO(), conf.Postgres.DSN),
 	)
 	if err != nil {
-		log.Fatal().Err(err).Msg("couldn't instantiate kafka producer")
+		log.Fatal().Err(err).Msg("couldn't instantiate reminders model")
+	}
+	defer remindersModel.Close()
+	metrics.RegisterPool("reminders", remindersModel)
+
+	rdb := redis.NewClient(&redis.Options{Addr: conf.Redis.Addr})
+	if err := redisotel.InstrumentTracing(rdb); err != nil {
+		log.Fatal().Err(err).Msg("couldn't instrument redis client")
+	}
+	defer rdb.Close()
+
+	attemptsModel, err := attempts.New(attempts.WithRedis(rdb))
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate login attempts model")
+	}
+
+	// the outbox relay needs a publisher that waits for delivery
+	outboxEnabled := conf.Outbox.Enabled
+	var publisher bus.Publisher
+	var busCheck health.Check
+	if conf.Bus.Kind == "nats" {
+		nc, js, err := natsbus.Connect(
+			context.TODO(),
+			conf.Nats.URL,
+			conf.Nats.Stream,
+			conf.Topics.All(),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't connect to nats")
+		}
+		defer nc.Close()
+		busCheck = health.Nats(nc)
+
+		publisher = natsbus.NewPublisher(js)
+		log.Info().Msg("successfully connected to nats")
+	} else if outboxEnabled {
+		saramaConf := sarama.NewConfig()
+		saramaConf.Producer.RequiredAcks = sarama.WaitForAll
+		saramaConf.Producer.Return.Successes = true
+		if err = saramaConf.Validate(); err != nil {
+			log.Fatal().Err(err).Msg("invalid kafka config")
+		}
+		sp, err := sarama.NewSyncProducer(
+			conf.Kafka.Brokers,
+			saramaConf,
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate kafka producer")
+		}
+		defer sp.Close()
+
+		publisher = kafka.NewPublisher(sp)
+		log.Info().Msg("successfully instantiated topic producer")
+	} else {
+		saramaConf := sarama.NewConfig()
+		saramaConf.Producer.RequiredAcks = sarama.WaitForAll
+		saramaConf.Producer.Flush.Frequency = conf.Kafka.FlushFrequency
+		saramaConf.Producer.Return.Successes = true
+		saramaConf.Producer.Return.Errors = true
+		if err = saramaConf.Validate(); err != nil {
+			log.Fatal().Err(err).Msg("invalid kafka config")
+		}
+		p, err := sarama.NewAsyncProducer(
+			conf.Kafka.Brokers,
+			saramaConf,
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate kafka producer")
+		}
+		ap := kafka.NewAsyncPublisher(p, &log)
+		// runs once the server has stopped, so no message is published
+		// while buffered ones are flushed
+		defer func() {
+			ctx, cancel := context.WithTimeout(
+				context.Background(),
+				conf.Shutdown.Timeout,
+			)
+			defer cancel()
+			if err := ap.Close(ctx); err != nil {
+				log.Error().Err(err).Msg("couldn't flush kafka producer")
+				return
+			}
+			log.Info().
+				Int64("published", ap.Published()).
+				Int64("failed", ap.Failed()).
+				Msg("flushed kafka producer")
+		}()
+
+		publisher = ap
+		log.Info().Msg("successfully instantiated topic producer")
+	}
+
+	if conf.Bus.Kind == "kafka" {
+		kc, err := sarama.NewClient(conf.Kafka.Brokers, sarama.NewConfig())
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't connect to kafka")
+		}
+		defer kc.Close()
+		busCheck = health.Kafka(kc)
+	}
+
+	publisher = tracing.NewPublisher(publisher)
+	publishing := authenticator.WithPublisher(conf.Topics.Users, publisher)
+	if outboxEnabled {
+		o, err := outbox.New(
+			outbox.WithPool(context.TODO(), conf.Postgres.DSN),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate outbox")
+		}
+		defer o.Close()
+		metrics.RegisterPool("outbox", o)
+
+		relay, err := outbox.NewRelay(
+			outbox.WithOutbox(o),
+			outbox.WithPublisher(publisher),
+			outbox.WithLogger(&log),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate outbox relay")
+		}
+		relayCtx, stopRelay := context.WithCancel(context.Background())
+		relayDone := make(chan struct{})
+		go func() {
+			defer close(relayDone)
+			relay.Run(relayCtx)
+		}()
+		// the relay finishes publishing its current batch before the outbox
+		// and the producer are closed
+		defer func() {
+			stopRelay()
+			select {
+			case <-relayDone:
+			case <-time.After(conf.Shutdown.Timeout):
+				log.Warn().Msg("outbox relay hasn't stopped in time")
+			}
+		}()
+
+		publishing = authenticator.WithOutbox(conf.Topics.Users, o)
+		log.Info().Msg("successfully instantiated outbox")
+	}
+
+	var m authenticator.Mailer
+	if conf.SMTP.Addr != "" {
+		m, err = mailer.NewSMTP(
+			mailer.WithServer(conf.SMTP.Addr),
+			mailer.WithSender(conf.SMTP.From),
+			mailer.WithCredentials(conf.SMTP.Username, conf.SMTP.Password),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate smtp mailer")
+		}
+	} else {
+		log.Warn().Msg("smtp.addr is not set. emails will only be logged")
+		m = mailer.NewLog(&log)
+	}
+
+	providers, err := oidcProviders(conf.Oidc)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't configure oidc providers")
 	}
-	defer p.Close()
 
 	a, err := authenticator.New(
 		authenticator.WithUsersDB(usersModel),
 		authenticator.WithBlackboxClient(box),
-		authenticator.WithProducer(os.Getenv("KAFKA_USERS_TOPIC"), p),
+		publishing,
+		authenticator.WithMailer(m),
+		authenticator.WithAttempts(attemptsModel),
+		authenticator.WithAuditLog(auditModel),
+		authenticator.WithResetLink(conf.Links.PasswordReset),
+		authenticator.WithWorkspaces(workspacesModel),
+		authenticator.WithInviteLink(conf.Links.WorkspaceInvite),
+		authenticator.WithNotificationPreferences(remindersModel, conf.Reminders.Days),
+		authenticator.WithOidcProviders(providers...),
+		authenticator.WithOidcLanding(conf.Oidc.Landing),
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("couldn't instantiate authenticator")
 	}
+	defer a.Wait()
 
-	stdMiddleware := middleware.RequestTracing(&log)
+	hc, err := health.New(
+		health.WithCheck("postgres", health.Ping(usersModel)),
+		health.WithCheck("redis", health.Redis(rdb)),
+		health.WithCheck("bus", busCheck),
+		health.WithCheck("blackbox", health.Grpc(conn, blackbox.BlackboxService_ServiceDesc.ServiceName)),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate health checks")
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle(
-		"OPTIONS /signup",
-		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().
-				Add("Access-Control-Allow-Origin", "http://localhost:8001")
-			w.Header().Add("Access-Control-Allow-Credentials", "true")
-			w.Header().Add("Access-Control-Allow-Headers", "Content-Type")
-		}),
-	)
-	mux.Handle(
-		"OPTIONS /login",
-		stdMiddleware.ThenFunc(
-			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				w.Header().
-					Add("Access-Control-Allow-Origin", "http://localhost:8001")
-				w.Header().Add("Access-Control-Allow-Credentials", "true")
-				w.Header().Add("Access-Control-Allow-Headers", "Content-Type")
-			}),
-		),
-	)
-	mux.Handle(
-		"POST /signup",
-		stdMiddleware.Append(middleware.CorsHeaders).ThenFunc(a.Register),
-	)
-	mux.Handle(
-		"POST /login",
-		stdMiddleware.
-			Append(middleware.CorsHeaders).
-			ThenFunc(a.Login),
-	)
+	a.Mount(mux, middleware.RequestTracing(&log), middleware.Cors{Origin: conf.Cors.Origin})
+	hc.Mount(mux)
 
 	server := http.Server{
-		Addr:         ":8080",
-		Handler:      mux,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  time.Minute,
+		Addr:         conf.HTTP.Addr,
+		Handler:      tracing.Handler(metrics.Instrument(mux), mux),
+		ReadTimeout:  conf.HTTP.ReadTimeout,
+		WriteTimeout: conf.HTTP.WriteTimeout,
+		IdleTimeout:  conf.HTTP.IdleTimeout,
 	}
 
 	ctx, cancel := signal.NotifyContext(
@@ -122,16 +317,47 @@ func main() {
 	)
 	defer cancel()
 
-	go func() {
-		<-ctx.Done()
-		err = server.Shutdown(context.TODO())
+	go metrics.Serve(ctx, conf.Admin.Addr, &log)
+
+	err = shutdown.Serve(
+		ctx,
+		&server,
+		hc,
+		conf.Shutdown.DrainDelay,
+		conf.Shutdown.Timeout,
+	)
+	if err != nil && ctx.Err() == nil {
+		log.Fatal().Err(err).Msg("couldn't serve")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("error during shutdown")
+	}
+	log.Info().Msg("server has stopped")
+}
+
+// oidcProviders configures the enabled providers. Their clients are
+// redirected back to <redirect base url>/oidc/<name>/callback.
+func oidcProviders(conf config.Oidc) ([]*authenticator.OidcProvider, error) {
+	baseUrl := strings.TrimSuffix(conf.RedirectBaseUrl, "/")
+
+	var providers []*authenticator.OidcProvider
+	for _, name := range conf.Providers {
+		client := conf.Clients[name]
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		p, err := authenticator.NewOidcProvider(ctx, authenticator.OidcConfig{
+			Name:         name,
+			Issuer:       client.Issuer,
+			ClientId:     client.ClientId,
+			ClientSecret: client.ClientSecret,
+			RedirectUrl:  baseUrl + "/oidc/" + name + "/callback",
+			Scopes:       client.Scopes,
+		})
+		cancel()
 		if err != nil {
-			log.Error().Err(err).Msg("error occured on Shutdown()")
+			return nil, err
 		}
-	}()
-
-	err = server.ListenAndServe()
-	if err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatal().Err(err).Msg("error during shutdown")
+		providers = append(providers, p)
 	}
+	return providers, nil
 }
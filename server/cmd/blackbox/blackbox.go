@@ -2,24 +2,65 @@ package main
 
 import (
 	"context"
-	"log"
 	"net"
 	"os"
 	"os/signal"
 	"shortener/internal/blackbox"
+	"shortener/pkg/config"
+	"shortener/pkg/health"
+	"shortener/pkg/metrics"
+	"shortener/pkg/models/tokens"
+	"shortener/pkg/shutdown"
+	"shortener/pkg/tracing"
 	"syscall"
+	"time"
 
+	"github.com/redis/go-redis/extra/redisotel/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	pbblackbox "shortener/proto/blackbox"
 )
 
 func main() {
-	s := grpc.NewServer(grpc.ChainUnaryInterceptor())
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).
+		With().
+		Timestamp().
+		Logger()
 
-	lis, err := net.Listen("tcp", ":8080")
+	conf := config.Default()
+	if err := config.Load(conf, os.Args[1:]); err != nil {
+		log.Fatal().Err(err).Msg("couldn't load config")
+	}
+	if err := conf.Require("blackbox.secret"); err != nil {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+	conf.Log(&log)
+
+	shutdownTracing, err := tracing.Setup(
+		context.TODO(),
+		"blackbox",
+		conf.Tracing.Exporter,
+		conf.Tracing.Endpoint,
+	)
 	if err != nil {
-		log.Fatalln("couldn't start listening for connections. error:", err)
+		log.Fatal().Err(err).Msg("couldn't set up tracing")
+	}
+	defer shutdownTracing(context.TODO())
+
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(metrics.UnaryServerInterceptor),
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+	)
+
+	lis, err := net.Listen("tcp", conf.GRPC.Addr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't start listening for connections")
 	}
 
 	ctx, cancel := signal.NotifyContext(
@@ -29,20 +70,83 @@ func main() {
 	)
 	defer cancel()
 
+	rdb := redis.NewClient(&redis.Options{Addr: conf.Redis.Addr})
+	if err := redisotel.InstrumentTracing(rdb); err != nil {
+		log.Fatal().Err(err).Msg("couldn't instrument redis client")
+	}
+	defer rdb.Close()
+
+	hc, err := health.New(health.WithCheck("redis", health.Redis(rdb)))
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate health checks")
+	}
+	go metrics.Serve(ctx, conf.Admin.Addr, &log, hc.Mount)
+
+	generations, err := tokens.New(tokens.WithRedis(rdb))
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate tokens model")
+	}
+
 	service, err := blackbox.New(
-		blackbox.WithSecret(os.Getenv("BLACKBOX_SECRET")),
+		blackbox.WithSecret(conf.Blackbox.Secret),
+		blackbox.WithGenerations(generations),
 	)
 	if err != nil {
-		log.Fatalln("couldn't instantiate service impl. error:", err)
+		log.Fatal().Err(err).Msg("couldn't instantiate service impl")
 	}
 	pbblackbox.RegisterBlackboxServiceServer(s, service)
 
+	hs := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(s, hs)
+	go watchHealth(ctx, hc, hs, pbblackbox.BlackboxService_ServiceDesc.ServiceName)
+
+	stopped := make(chan struct{})
 	go func() {
+		defer close(stopped)
 		<-ctx.Done()
-		s.GracefulStop()
+		hc.Drain()
+		hs.Shutdown()
+		time.Sleep(conf.Shutdown.DrainDelay)
+		if err := shutdown.StopGRPC(s, conf.Shutdown.Timeout); err != nil {
+			log.Error().Err(err).Msg("error during shutdown")
+		}
 	}()
 
+	log.Info().Str("addr", conf.GRPC.Addr).Msg("listening for connections")
 	if err := s.Serve(lis); err != nil {
-		log.Fatalln("fatal serve error:", err)
+		log.Fatal().Err(err).Msg("fatal serve error")
+	}
+	// Serve returns as soon as the listener is closed, in-flight calls may
+	// still use redis
+	<-stopped
+	log.Info().Msg("server has stopped")
+}
+
+// watchHealth reports the result of the checks to the gRPC health service
+// until ctx is done
+func watchHealth(
+	ctx context.Context,
+	hc *health.Health,
+	hs *grpchealth.Server,
+	service string,
+) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		if err := hc.Check(ctx); err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		hs.SetServingStatus("", status)
+		hs.SetServingStatus(service, status)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
 }
@@ -0,0 +1,221 @@
+// Command dlq inspects and replays the dead-letter topic of the storage
+// service.
+//
+//	dlq list   [-brokers ...] [-topic ...] [-partition n] [-from n] [-to n]
+//	dlq replay [-brokers ...] [-topic ...] [-partition n] (-from n -to n | -all)
+//
+// list prints entries as JSON lines. replay sends entries back to the topics
+// they came from. Offsets are the ones of the dead-letter topic, the range
+// is inclusive.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"shortener/pkg/bus/kafka"
+	"shortener/pkg/dlq"
+	"strings"
+
+	"github.com/IBM/sarama"
+)
+
+type options struct {
+	brokers   string
+	topic     string
+	partition int
+	from      int64
+	to        int64
+	all       bool
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dlq list|replay [flags]")
+	fmt.Fprintln(os.Stderr, "run 'dlq <command> -h' for the list of flags")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd := os.Args[1]
+	if cmd != "list" && cmd != "replay" {
+		usage()
+		os.Exit(2)
+	}
+
+	var opts options
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.StringVar(
+		&opts.brokers,
+		"brokers",
+		os.Getenv("KAFKA_BROKERS"),
+		"comma separated kafka brokers",
+	)
+	fs.StringVar(
+		&opts.topic,
+		"topic",
+		os.Getenv("KAFKA_DLQ_TOPIC"),
+		"dead-letter topic",
+	)
+	fs.IntVar(&opts.partition, "partition", -1, "partition to read, -1 for all")
+	fs.Int64Var(&opts.from, "from", -1, "first offset, -1 for the oldest")
+	fs.Int64Var(&opts.to, "to", -1, "last offset, -1 for the newest")
+	if cmd == "replay" {
+		fs.BoolVar(&opts.all, "all", false, "replay the whole topic")
+	}
+	fs.Parse(os.Args[2:])
+
+	if opts.brokers == "" || opts.topic == "" {
+		fmt.Fprintln(os.Stderr, "brokers and topic are required")
+		os.Exit(2)
+	}
+	if cmd == "replay" && !opts.all && (opts.from < 0 || opts.to < 0) {
+		fmt.Fprintln(os.Stderr, "either -from and -to or -all are required")
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd {
+	case "list":
+		err = list(opts)
+	case "replay":
+		err = replay(opts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func list(opts options) error {
+	enc := json.NewEncoder(os.Stdout)
+	return read(opts, func(e *dlq.Entry) error {
+		return enc.Encode(e)
+	})
+}
+
+func replay(opts options) error {
+	conf := sarama.NewConfig()
+	conf.Producer.RequiredAcks = sarama.WaitForAll
+	conf.Producer.Return.Successes = true
+	p, err := sarama.NewSyncProducer(strings.Split(opts.brokers, ","), conf)
+	if err != nil {
+		return fmt.Errorf("couldn't instantiate producer: %w", err)
+	}
+	defer p.Close()
+	publisher := kafka.NewPublisher(p)
+
+	replayed := 0
+	err = read(opts, func(e *dlq.Entry) error {
+		if e.OriginalTopic == "" {
+			return fmt.Errorf(
+				"entry %d/%d has no original topic",
+				e.Partition,
+				e.Offset,
+			)
+		}
+		if err := publisher.Publish(context.TODO(), e.Replay()); err != nil {
+			return fmt.Errorf(
+				"couldn't replay entry %d/%d: %w",
+				e.Partition,
+				e.Offset,
+				err,
+			)
+		}
+		replayed++
+		return nil
+	})
+	fmt.Fprintf(os.Stderr, "replayed %d entries\n", replayed)
+	return err
+}
+
+// read calls fn for every entry in the requested range. Messages produced
+// after the start aren't read.
+func read(opts options, fn func(e *dlq.Entry) error) error {
+	conf := sarama.NewConfig()
+	client, err := sarama.NewClient(strings.Split(opts.brokers, ","), conf)
+	if err != nil {
+		return fmt.Errorf("couldn't connect to kafka: %w", err)
+	}
+	defer client.Close()
+
+	partitions, err := client.Partitions(opts.topic)
+	if err != nil {
+		return fmt.Errorf("couldn't get partitions of %s: %w", opts.topic, err)
+	}
+	if opts.partition >= 0 {
+		partitions = []int32{int32(opts.partition)}
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	for _, partition := range partitions {
+		err := readPartition(client, consumer, opts, partition, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readPartition(
+	client sarama.Client,
+	consumer sarama.Consumer,
+	opts options,
+	partition int32,
+	fn func(e *dlq.Entry) error,
+) error {
+	oldest, err := client.GetOffset(opts.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return err
+	}
+	newest, err := client.GetOffset(opts.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return err
+	}
+
+	from, to := oldest, newest-1
+	if !opts.all {
+		if opts.from > from {
+			from = opts.from
+		}
+		if opts.to >= 0 && opts.to < to {
+			to = opts.to
+		}
+	}
+	if from > to {
+		return nil
+	}
+
+	pc, err := consumer.ConsumePartition(opts.topic, partition, from)
+	if err != nil {
+		return fmt.Errorf("couldn't consume partition %d: %w", partition, err)
+	}
+	defer pc.Close()
+
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if msg.Offset > to {
+				return nil
+			}
+			if err := fn(dlq.Parse(kafka.FromConsumerMessage(msg))); err != nil {
+				return err
+			}
+			if msg.Offset == to {
+				return nil
+			}
+		case err := <-pc.Errors():
+			return err
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Command migrate manages the schema of the Postgres database.
+//
+//	migrate up     [-dsn ...]
+//	migrate down   [-dsn ...] [-steps n]
+//	migrate status [-dsn ...]
+//	migrate create [-dir migrations] <name>
+//
+// up applies the pending migrations embedded in the binary, down reverts
+// the latest ones, one by default. create writes files of a new migration
+// to the source directory. The dsn defaults to POSTGRES_DSN.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"shortener/migrations"
+	"shortener/pkg/migrate"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up|down|status|create [flags]")
+	fmt.Fprintln(os.Stderr, "run 'migrate <command> -h' for the list of flags")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd := os.Args[1]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	var dsn, dir *string
+	var steps *int
+	switch cmd {
+	case "up", "down", "status":
+		dsn = fs.String("dsn", os.Getenv("POSTGRES_DSN"), "postgres connection string")
+		if cmd == "down" {
+			steps = fs.Int("steps", 1, "number of migrations to revert")
+		}
+	case "create":
+		dir = fs.String("dir", "migrations", "directory of the migration files")
+	default:
+		usage()
+		os.Exit(2)
+	}
+	fs.Parse(os.Args[2:])
+
+	if cmd == "create" {
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "name of the migration is required")
+			os.Exit(2)
+		}
+		up, down, err := migrate.Create(*dir, fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(up)
+		fmt.Println(down)
+		return
+	}
+
+	if *dsn == "" {
+		fmt.Fprintln(os.Stderr, "dsn is required")
+		os.Exit(2)
+	}
+	if steps != nil && *steps < 1 {
+		fmt.Fprintln(os.Stderr, "steps must be positive")
+		os.Exit(2)
+	}
+
+	if err := run(cmd, *dsn, steps); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(cmd string, dsn string, steps *int) error {
+	log := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).
+		With().
+		Timestamp().
+		Logger()
+
+	ctx, cancel := signal.NotifyContext(
+		context.Background(),
+		syscall.SIGTERM,
+		syscall.SIGINT,
+	)
+	defer cancel()
+
+	m, err := migrate.New(
+		migrate.WithPool(ctx, dsn),
+		migrate.WithMigrations(migrations.FS),
+		migrate.WithLogger(&log),
+	)
+	if err != nil {
+		return fmt.Errorf("couldn't instantiate migrator: %w", err)
+	}
+	defer m.Close()
+
+	switch cmd {
+	case "up":
+		n, err := m.Up(ctx)
+		fmt.Fprintf(os.Stderr, "applied %d migrations\n", n)
+		return err
+	case "down":
+		n, err := m.Down(ctx, *steps)
+		fmt.Fprintf(os.Stderr, "reverted %d migrations\n", n)
+		return err
+	default:
+		return status(ctx, m)
+	}
+}
+
+func status(ctx context.Context, m *migrate.Migrator) error {
+	ss, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range ss {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	return w.Flush()
+}
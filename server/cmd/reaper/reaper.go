@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"shortener/internal/reaper"
+	"shortener/pkg/bus"
+	"shortener/pkg/bus/kafka"
+	natsbus "shortener/pkg/bus/nats"
+	"shortener/pkg/config"
+	"shortener/pkg/health"
+	"shortener/pkg/metrics"
+	"shortener/pkg/models/urls"
+	"shortener/pkg/tracing"
+	"syscall"
+
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/extra/redisotel/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+func main() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).
+		With().
+		Timestamp().
+		Logger()
+
+	conf := config.Default()
+	if err := config.Load(conf, os.Args[1:]); err != nil {
+		log.Fatal().Err(err).Msg("couldn't load config")
+	}
+	if err := conf.Require("postgres.dsn"); err != nil {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+	conf.Log(&log)
+
+	shutdownTracing, err := tracing.Setup(
+		context.TODO(),
+		"reaper",
+		conf.Tracing.Exporter,
+		conf.Tracing.Endpoint,
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't set up tracing")
+	}
+	defer shutdownTracing(context.TODO())
+
+	ctx, cancel := signal.NotifyContext(
+		context.Background(),
+		syscall.SIGTERM,
+		syscall.SIGINT,
+	)
+	defer cancel()
+
+	rdb := redis.NewClient(&redis.Options{Addr: conf.Redis.Addr})
+	if err := redisotel.InstrumentTracing(rdb); err != nil {
+		log.Fatal().Err(err).Msg("couldn't instrument redis client")
+	}
+
+	u, err := urls.New(
+		urls.WithPool(context.TODO(), conf.Postgres.DSN),
+		urls.WithRedis(rdb),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate urls model")
+	}
+	defer u.Close()
+	log.Info().Msg("successfully instantiated urls model")
+	metrics.RegisterPool("urls", u)
+
+	// expirations are published for webhooks, see cmd/webhooks
+	var publisher bus.Publisher
+	switch {
+	case conf.Reaper.DryRun:
+		// nothing is archived, so nothing is published
+	case conf.Bus.Kind == "nats":
+		nc, js, err := natsbus.Connect(
+			context.TODO(),
+			conf.Nats.URL,
+			conf.Nats.Stream,
+			conf.Topics.All(),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't connect to nats")
+		}
+		defer nc.Close()
+		publisher = natsbus.NewPublisher(js)
+		log.Info().Msg("successfully connected to nats")
+	default:
+		saramaConf := sarama.NewConfig()
+		saramaConf.Producer.RequiredAcks = sarama.WaitForAll
+		saramaConf.Producer.Return.Successes = true
+		if err := saramaConf.Validate(); err != nil {
+			log.Fatal().Err(err).Msg("invalid kafka config")
+		}
+		sp, err := sarama.NewSyncProducer(conf.Kafka.Brokers, saramaConf)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate kafka producer")
+		}
+		defer sp.Close()
+		publisher = kafka.NewPublisher(sp)
+		log.Info().Msg("successfully instantiated topic producer")
+	}
+
+	r, err := reaper.New(
+		reaper.WithUrls(u),
+		reaper.WithPublisher(publisher, conf.Topics.Events),
+		reaper.WithInterval(conf.Reaper.Interval),
+		reaper.WithBatchSize(conf.Reaper.BatchSize),
+		reaper.WithGrace(conf.Reaper.Grace),
+		reaper.WithReleaseAfter(conf.Reaper.ReleaseAfter),
+		reaper.WithDryRun(conf.Reaper.DryRun),
+		reaper.WithLogger(&log),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate reaper")
+	}
+
+	if conf.Reaper.Once {
+		res, err := r.Pass(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("reaper pass failed")
+		}
+		log.Info().
+			Int64("archived", res.Archived).
+			Int64("released", res.Released).
+			Msg("reaper pass has finished")
+		return
+	}
+
+	hc, err := health.New(
+		health.WithCheck("postgres", health.Ping(u)),
+		health.WithCheck("redis", health.Redis(rdb)),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate health checks")
+	}
+	go metrics.Serve(ctx, conf.Admin.Addr, &log, hc.Mount)
+	go func() {
+		<-ctx.Done()
+		hc.Drain()
+	}()
+
+	log.Info().Dur("interval", conf.Reaper.Interval).Msg("reaper has started")
+	r.Run(ctx)
+	log.Info().Msg("reaper has stopped")
+}
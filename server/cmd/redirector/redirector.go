@@ -2,16 +2,27 @@ package main
 
 import (
 	"context"
-	"errors"
 	"net/http"
 	"os"
 	"os/signal"
 	"shortener/internal/redirector"
+	"shortener/pkg/bus"
+	"shortener/pkg/bus/kafka"
+	natsbus "shortener/pkg/bus/nats"
+	"shortener/pkg/clicks"
+	"shortener/pkg/config"
+	"shortener/pkg/health"
+	"shortener/pkg/metrics"
 	"shortener/pkg/middleware"
 	"shortener/pkg/models/urls"
+	"shortener/pkg/renewal"
+	"shortener/pkg/shutdown"
+	"shortener/pkg/tracing"
 	"syscall"
 	"time"
 
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
 )
@@ -23,28 +34,127 @@ func main() {
 		Timestamp().
 		Logger()
 
-	rdb := redis.NewClient(&redis.Options{Addr: "redis:6379"})
+	conf := config.Default()
+	if err := config.Load(conf, os.Args[1:]); err != nil {
+		log.Fatal().Err(err).Msg("couldn't load config")
+	}
+	if err := conf.Require("postgres.dsn"); err != nil {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+	conf.Log(&log)
+
+	shutdownTracing, err := tracing.Setup(
+		context.TODO(),
+		"redirector",
+		conf.Tracing.Exporter,
+		conf.Tracing.Endpoint,
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't set up tracing")
+	}
+	defer shutdownTracing(context.TODO())
+
+	rdb := redis.NewClient(&redis.Options{Addr: conf.Redis.Addr})
+	if err := redisotel.InstrumentTracing(rdb); err != nil {
+		log.Fatal().Err(err).Msg("couldn't instrument redis client")
+	}
 	u, err := urls.New(
 		urls.WithRedis(rdb),
-		urls.WithPool(context.TODO(), os.Getenv("POSTGRES_DSN")),
+		urls.WithPool(context.TODO(), conf.Postgres.DSN),
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("couldn't instantiate urls model")
 	}
 	defer u.Close()
+	metrics.RegisterPool("urls", u)
 
-	re, err := redirector.New(redirector.WithUrlsModel(u))
+	autoRenewer, err := renewal.NewAutoRenewer(
+		renewal.WithUrls(u),
+		renewal.WithPolicy(conf.Renewal.Policy()),
+		renewal.WithCheckEvery(conf.Renewal.CheckEvery),
+		renewal.WithLogger(&log),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate auto-renewer")
+	}
+
+	// clicks are published for webhooks, see cmd/webhooks. Redirects never
+	// wait for the bus
+	var publisher bus.Publisher
+	if conf.Bus.Kind == "nats" {
+		nc, js, err := natsbus.Connect(
+			context.TODO(),
+			conf.Nats.URL,
+			conf.Nats.Stream,
+			conf.Topics.All(),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't connect to nats")
+		}
+		defer nc.Close()
+		publisher = natsbus.NewPublisher(js)
+		log.Info().Msg("successfully connected to nats")
+	} else {
+		saramaConf := sarama.NewConfig()
+		saramaConf.Producer.RequiredAcks = sarama.WaitForLocal
+		saramaConf.Producer.Flush.Frequency = conf.Kafka.FlushFrequency
+		saramaConf.Producer.Return.Successes = true
+		saramaConf.Producer.Return.Errors = true
+		if err = saramaConf.Validate(); err != nil {
+			log.Fatal().Err(err).Msg("invalid kafka config")
+		}
+		p, err := sarama.NewAsyncProducer(conf.Kafka.Brokers, saramaConf)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate kafka producer")
+		}
+		ap := kafka.NewAsyncPublisher(p, &log)
+		defer func() {
+			ctx, cancel := context.WithTimeout(
+				context.Background(),
+				conf.Shutdown.Timeout,
+			)
+			defer cancel()
+			if err := ap.Close(ctx); err != nil {
+				log.Error().Err(err).Msg("couldn't flush kafka producer")
+			}
+		}()
+		publisher = ap
+		log.Info().Msg("successfully instantiated topic producer")
+	}
+
+	clickReporter, err := clicks.New(
+		clicks.WithPublisher(publisher, conf.Topics.Events),
+		clicks.WithBatching(clicks.DefaultBatchSize, conf.Kafka.FlushFrequency),
+		clicks.WithFlushTimeout(conf.Shutdown.Timeout),
+		clicks.WithLogger(&log),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate click reporter")
+	}
+
+	re, err := redirector.New(
+		redirector.WithUrlsModel(u),
+		redirector.WithAutoRenewer(autoRenewer),
+		redirector.WithClickReporter(clickReporter),
+	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("couldn't instantiate redirector")
 	}
 
-	c := middleware.RequestTracing(&log)
+	hc, err := health.New(
+		health.WithCheck("postgres", health.Ping(u)),
+		health.WithCheck("redis", health.Redis(rdb)),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate health checks")
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle("GET /", c.ThenFunc(re.Redirect))
+	re.Mount(mux, middleware.RequestTracing(&log), middleware.Cors{Origin: conf.Cors.Origin})
+	hc.Mount(mux)
 	server := http.Server{
 		Addr:         ":8080",
-		Handler:      mux,
+		Handler:      tracing.Handler(metrics.Instrument(mux), mux),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 		IdleTimeout:  time.Minute,
@@ -57,16 +167,31 @@ func main() {
 	)
 	defer cancel()
 
+	go metrics.Serve(ctx, conf.Admin.Addr, &log)
+	go autoRenewer.Run(ctx)
+	// queued clicks are published before the producer is closed
+	reporterDone := make(chan struct{})
 	go func() {
-		<-ctx.Done()
-		err = server.Shutdown(context.TODO())
-		if err != nil {
-			log.Error().Err(err).Msg("error occured on Shutdown()")
-		}
+		defer close(reporterDone)
+		clickReporter.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-reporterDone
 	}()
 
-	err = server.ListenAndServe()
-	if err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatal().Err(err).Msg("error during shutdown")
+	err = shutdown.Serve(
+		ctx,
+		&server,
+		hc,
+		conf.Shutdown.DrainDelay,
+		conf.Shutdown.Timeout,
+	)
+	if err != nil && ctx.Err() == nil {
+		log.Fatal().Err(err).Msg("couldn't serve")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("error during shutdown")
 	}
+	log.Info().Msg("server has stopped")
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"shortener/internal/reminder"
+	"shortener/pkg/config"
+	"shortener/pkg/domain"
+	"shortener/pkg/health"
+	"shortener/pkg/mailer"
+	"shortener/pkg/metrics"
+	"shortener/pkg/models/reminders"
+	"shortener/pkg/tracing"
+	"syscall"
+
+	"github.com/rs/zerolog"
+)
+
+func main() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).
+		With().
+		Timestamp().
+		Logger()
+
+	conf := config.Default()
+	if err := config.Load(conf, os.Args[1:]); err != nil {
+		log.Fatal().Err(err).Msg("couldn't load config")
+	}
+	if err := conf.Require("postgres.dsn", "links.redirector_host"); err != nil {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+	conf.Log(&log)
+
+	shutdownTracing, err := tracing.Setup(
+		context.TODO(),
+		"reminder",
+		conf.Tracing.Exporter,
+		conf.Tracing.Endpoint,
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't set up tracing")
+	}
+	defer shutdownTracing(context.TODO())
+
+	ctx, cancel := signal.NotifyContext(
+		context.Background(),
+		syscall.SIGTERM,
+		syscall.SIGINT,
+	)
+	defer cancel()
+
+	r, err := reminders.New(
+		reminders.WithPool(context.TODO(), conf.Postgres.DSN),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate reminders model")
+	}
+	defer r.Close()
+	log.Info().Msg("successfully instantiated reminders model")
+	metrics.RegisterPool("reminders", r)
+
+	var m reminder.Mailer
+	if conf.SMTP.Addr != "" {
+		m, err = mailer.NewSMTP(
+			mailer.WithServer(conf.SMTP.Addr),
+			mailer.WithSender(conf.SMTP.From),
+			mailer.WithCredentials(conf.SMTP.Username, conf.SMTP.Password),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate smtp mailer")
+		}
+	} else {
+		log.Warn().Msg("smtp.addr is not set. emails will only be logged")
+		m = mailer.NewLog(&log)
+	}
+	mail, err := reminder.NewMailNotifier(m, conf.Links.RedirectorHost)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate mail notifier")
+	}
+
+	s, err := reminder.New(
+		reminder.WithReminders(r),
+		reminder.WithNotifier(domain.ChannelEmail, mail),
+		reminder.WithNotifier(
+			domain.ChannelWebhook,
+			reminder.NewWebhookNotifier(conf.Reminders.WebhookTimeout),
+		),
+		reminder.WithInterval(conf.Reminders.Interval),
+		reminder.WithBatchSize(conf.Reminders.BatchSize),
+		reminder.WithDefaultDays(conf.Reminders.Days),
+		reminder.WithLogger(&log),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate reminder scheduler")
+	}
+
+	hc, err := health.New(
+		health.WithCheck("postgres", health.Ping(r)),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate health checks")
+	}
+	go metrics.Serve(ctx, conf.Admin.Addr, &log, hc.Mount)
+	go func() {
+		<-ctx.Done()
+		hc.Drain()
+	}()
+
+	log.Info().Dur("interval", conf.Reminders.Interval).Msg("reminder has started")
+	s.Run(ctx)
+	log.Info().Msg("reminder has stopped")
+}
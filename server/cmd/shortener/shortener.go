@@ -2,21 +2,32 @@ package main
 
 import (
 	"context"
-	"errors"
 	"net/http"
 	"os"
 	"os/signal"
 	"shortener/internal/shortener"
+	"shortener/pkg/bus"
+	"shortener/pkg/bus/kafka"
+	natsbus "shortener/pkg/bus/nats"
+	"shortener/pkg/config"
+	"shortener/pkg/health"
+	"shortener/pkg/metrics"
 	"shortener/pkg/middleware"
+	"shortener/pkg/models/bulk"
 	"shortener/pkg/models/urls"
+	"shortener/pkg/models/webhooks"
+	"shortener/pkg/outbox"
+	"shortener/pkg/shutdown"
+	"shortener/pkg/tracing"
 	"shortener/proto/blackbox"
-	"strings"
 	"syscall"
 	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -28,9 +39,31 @@ func main() {
 		Timestamp().
 		Logger()
 
+	conf := config.Default()
+	if err := config.Load(conf, os.Args[1:]); err != nil {
+		log.Fatal().Err(err).Msg("couldn't load config")
+	}
+	if err := conf.Require("postgres.dsn", "links.redirector_host"); err != nil {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+	conf.Log(&log)
+
+	shutdownTracing, err := tracing.Setup(
+		context.TODO(),
+		"shortener",
+		conf.Tracing.Exporter,
+		conf.Tracing.Endpoint,
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't set up tracing")
+	}
+	defer shutdownTracing(context.TODO())
+
 	conn, err := grpc.NewClient(
-		"blackbox:8080",
+		conf.Blackbox.Addr,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(metrics.UnaryClientInterceptor),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("couldn't dial auth service")
@@ -38,10 +71,13 @@ func main() {
 	log.Info().Msg("successfully instantiated blackbox client")
 	defer conn.Close()
 
-	rdb := redis.NewClient(&redis.Options{Addr: "redis:6379"})
+	rdb := redis.NewClient(&redis.Options{Addr: conf.Redis.Addr})
+	if err := redisotel.InstrumentTracing(rdb); err != nil {
+		log.Fatal().Err(err).Msg("couldn't instrument redis client")
+	}
 
 	u, err := urls.New(
-		urls.WithPool(context.TODO(), os.Getenv("POSTGRES_DSN")),
+		urls.WithPool(context.TODO(), conf.Postgres.DSN),
 		urls.WithRedis(rdb),
 	)
 	if err != nil {
@@ -49,59 +85,200 @@ func main() {
 	}
 	defer u.Close()
 	log.Info().Msg("successfully instantiated user model")
+	metrics.RegisterPool("urls", u)
+
+	// the outbox relay needs a publisher that waits for delivery
+	outboxEnabled := conf.Outbox.Enabled
+	var publisher bus.Publisher
+	var busCheck health.Check
+	if conf.Bus.Kind == "nats" {
+		nc, js, err := natsbus.Connect(
+			context.TODO(),
+			conf.Nats.URL,
+			conf.Nats.Stream,
+			conf.Topics.All(),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't connect to nats")
+		}
+		defer nc.Close()
+		busCheck = health.Nats(nc)
 
-	conf := sarama.NewConfig()
-	conf.Producer.RequiredAcks = sarama.WaitForAll
-	conf.Producer.Flush.Frequency = 500 * time.Millisecond
-	conf.Producer.Return.Errors = false
-	if err = conf.Validate(); err != nil {
-		log.Fatal().Err(err).Msg("invalid kafka config")
+		publisher = natsbus.NewPublisher(js)
+		log.Info().Msg("successfully connected to nats")
+	} else if outboxEnabled {
+		saramaConf := sarama.NewConfig()
+		saramaConf.Producer.RequiredAcks = sarama.WaitForAll
+		saramaConf.Producer.Return.Successes = true
+		if err = saramaConf.Validate(); err != nil {
+			log.Fatal().Err(err).Msg("invalid kafka config")
+		}
+		sp, err := sarama.NewSyncProducer(
+			conf.Kafka.Brokers,
+			saramaConf,
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate kafka producer")
+		}
+		defer sp.Close()
+
+		publisher = kafka.NewPublisher(sp)
+		log.Info().Msg("successfully instantiated topic producer")
+	} else {
+		saramaConf := sarama.NewConfig()
+		saramaConf.Producer.RequiredAcks = sarama.WaitForAll
+		saramaConf.Producer.Flush.Frequency = conf.Kafka.FlushFrequency
+		saramaConf.Producer.Return.Successes = true
+		saramaConf.Producer.Return.Errors = true
+		if err = saramaConf.Validate(); err != nil {
+			log.Fatal().Err(err).Msg("invalid kafka config")
+		}
+		p, err := sarama.NewAsyncProducer(
+			conf.Kafka.Brokers,
+			saramaConf,
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate kafka producer")
+		}
+		ap := kafka.NewAsyncPublisher(p, &log)
+		// runs once the server has stopped, so no message is published
+		// while buffered ones are flushed
+		defer func() {
+			ctx, cancel := context.WithTimeout(
+				context.Background(),
+				conf.Shutdown.Timeout,
+			)
+			defer cancel()
+			if err := ap.Close(ctx); err != nil {
+				log.Error().Err(err).Msg("couldn't flush kafka producer")
+				return
+			}
+			log.Info().
+				Int64("published", ap.Published()).
+				Int64("failed", ap.Failed()).
+				Msg("flushed kafka producer")
+		}()
+
+		publisher = ap
+		log.Info().Msg("successfully instantiated topic producer")
 	}
-	p, err := sarama.NewAsyncProducer(
-		strings.Split(os.Getenv("KAFKA_BROKERS"), ","),
-		conf,
+
+	if conf.Bus.Kind == "kafka" {
+		kc, err := sarama.NewClient(conf.Kafka.Brokers, sarama.NewConfig())
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't connect to kafka")
+		}
+		defer kc.Close()
+		busCheck = health.Kafka(kc)
+	}
+
+	publisher = tracing.NewPublisher(publisher)
+	publishing := shortener.WithPublisher(publisher, conf.Topics.Urls)
+	if outboxEnabled {
+		o, err := outbox.New(
+			outbox.WithPool(context.TODO(), conf.Postgres.DSN),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate outbox")
+		}
+		defer o.Close()
+		metrics.RegisterPool("outbox", o)
+
+		relay, err := outbox.NewRelay(
+			outbox.WithOutbox(o),
+			outbox.WithPublisher(publisher),
+			outbox.WithLogger(&log),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate outbox relay")
+		}
+		relayCtx, stopRelay := context.WithCancel(context.Background())
+		relayDone := make(chan struct{})
+		go func() {
+			defer close(relayDone)
+			relay.Run(relayCtx)
+		}()
+		// the relay finishes publishing its current batch before the outbox
+		// and the producer are closed
+		defer func() {
+			stopRelay()
+			select {
+			case <-relayDone:
+			case <-time.After(conf.Shutdown.Timeout):
+				log.Warn().Msg("outbox relay hasn't stopped in time")
+			}
+		}()
+
+		publishing = shortener.WithOutbox(o, conf.Topics.Urls)
+		log.Info().Msg("successfully instantiated outbox")
+	}
+
+	w, err := webhooks.New(
+		webhooks.WithPool(context.TODO(), conf.Postgres.DSN),
 	)
 	if err != nil {
-		log.Fatal().Err(err).Msg("couldn't instantiate kafka producer")
+		log.Fatal().Err(err).Msg("couldn't instantiate webhooks model")
+	}
+	defer w.Close()
+	metrics.RegisterPool("webhooks", w)
+
+	b, err := bulk.New(bulk.WithPool(context.TODO(), conf.Postgres.DSN))
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate bulk jobs model")
 	}
-	defer p.Close()
-	log.Info().Msg("successfully instantiated topic producer")
+	defer b.Close()
+	metrics.RegisterPool("bulk", b)
 
 	log.Info().Msg("instantiating shortener")
 	s, err := shortener.New(
 		shortener.WithUrlsModel(u),
 		shortener.WithBlackboxClient(blackbox.NewBlackboxServiceClient(conn)),
-		shortener.WithKafkaProducer(p, os.Getenv("KAFKA_URLS_TOPIC")),
-		shortener.WithRedirectorHost(os.Getenv("REDIRECTOR_HOST")),
+		publishing,
+		shortener.WithRedirectorHost(conf.Links.RedirectorHost),
+		shortener.WithRenewalPolicy(conf.Renewal.Policy()),
+		shortener.WithEventsTopic(conf.Topics.Events),
+		shortener.WithWebhooks(w),
+		shortener.WithBulkJobs(b, conf.Bulk.SyncLimit),
+		shortener.WithBulkMaxRows(conf.Bulk.MaxRows),
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("couldn't instantiate shortener")
 	}
 	log.Info().Msg("successfully instantiated shortener")
 
-	mux := http.NewServeMux()
-	mux.HandleFunc(
-		"OPTIONS /create_short_url",
-		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().
-				Add("Access-Control-Allow-Origin", "http://localhost:8001")
-			w.Header().Add("Access-Control-Allow-Credentials", "true")
-			w.Header().Add("Access-Control-Allow-Headers", "Content-Type")
-		}),
+	importer, err := shortener.NewImporter(
+		shortener.WithQueue(b),
+		shortener.WithShortener(s),
+		shortener.WithPollInterval(conf.Bulk.PollInterval),
+		shortener.WithJobTimeout(conf.Bulk.JobTimeout),
+		shortener.WithRetention(conf.Bulk.Retention),
+		shortener.WithImporterLogger(&log),
 	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate importer")
+	}
 
-	m := middleware.RequestTracing(&log)
-	mux.Handle(
-		"POST /create_short_url",
-		m.Append(middleware.CorsHeaders).ThenFunc(s.ShortenUrl),
+	hc, err := health.New(
+		health.WithCheck("postgres", health.Ping(u)),
+		health.WithCheck("bulk", health.Ping(b)),
+		health.WithCheck("redis", health.Redis(rdb)),
+		health.WithCheck("bus", busCheck),
+		health.WithCheck("blackbox", health.Grpc(conn, blackbox.BlackboxService_ServiceDesc.ServiceName)),
 	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate health checks")
+	}
+
+	mux := http.NewServeMux()
+	s.Mount(mux, middleware.RequestTracing(&log), middleware.Cors{Origin: conf.Cors.Origin})
+	hc.Mount(mux)
 
 	server := http.Server{
-		Addr:         ":8080",
-		Handler:      mux,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  time.Minute,
+		Addr:         conf.HTTP.Addr,
+		Handler:      tracing.Handler(metrics.Instrument(mux), mux),
+		ReadTimeout:  conf.HTTP.ReadTimeout,
+		WriteTimeout: conf.HTTP.WriteTimeout,
+		IdleTimeout:  conf.HTTP.IdleTimeout,
 	}
 
 	ctx, cancel := signal.NotifyContext(
@@ -111,17 +288,33 @@ func main() {
 	)
 	defer cancel()
 
+	go metrics.Serve(ctx, conf.Admin.Addr, &log)
+
+	// the importer stops before the outbox and the producer are closed, its
+	// current job is resumed after a restart
+	importerDone := make(chan struct{})
 	go func() {
-		<-ctx.Done()
-		err = server.Shutdown(context.TODO())
-		if err != nil {
-			log.Error().Err(err).Msg("error occured on Shutdown()")
-		}
+		defer close(importerDone)
+		importer.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-importerDone
 	}()
 
 	log.Info().Msg("listening for connections")
-	err = server.ListenAndServe()
-	if err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatal().Err(err).Msg("error during shutdown")
+	err = shutdown.Serve(
+		ctx,
+		&server,
+		hc,
+		conf.Shutdown.DrainDelay,
+		conf.Shutdown.Timeout,
+	)
+	if err != nil && ctx.Err() == nil {
+		log.Fatal().Err(err).Msg("couldn't serve")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("error during shutdown")
 	}
+	log.Info().Msg("server has stopped")
 }
@@ -5,12 +5,21 @@ import (
 	"os"
 	"os/signal"
 	"shortener/internal/storage"
+	"shortener/migrations"
+	"shortener/pkg/bus"
+	"shortener/pkg/bus/kafka"
+	natsbus "shortener/pkg/bus/nats"
+	"shortener/pkg/config"
+	"shortener/pkg/health"
+	"shortener/pkg/metrics"
+	"shortener/pkg/migrate"
 	"shortener/pkg/models/urls"
 	"shortener/pkg/models/users"
-	"strings"
+	"shortener/pkg/tracing"
 	"syscall"
 
 	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
 )
@@ -22,22 +31,25 @@ func main() {
 		Timestamp().
 		Logger()
 
-	conf := sarama.NewConfig()
-	conf.Consumer.Offsets.AutoCommit.Enable = false
-	conf.Consumer.Offsets.Initial = sarama.OffsetOldest
-	if err := conf.Validate(); err != nil {
-		log.Fatal().Err(err).Msg("invalid kafka config")
+	conf := config.Default()
+	if err := config.Load(conf, os.Args[1:]); err != nil {
+		log.Fatal().Err(err).Msg("couldn't load config")
 	}
-	group, err := sarama.NewConsumerGroup(
-		strings.Split(os.Getenv("KAFKA_BROKERS"), ","),
+	if err := conf.Require("postgres.dsn"); err != nil {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+	conf.Log(&log)
+
+	shutdownTracing, err := tracing.Setup(
+		context.TODO(),
 		"storage",
-		conf,
+		conf.Tracing.Exporter,
+		conf.Tracing.Endpoint,
 	)
 	if err != nil {
-		log.Fatal().Err(err).Msg("couldn't start consuming kafka topic")
+		log.Fatal().Err(err).Msg("couldn't set up tracing")
 	}
-	defer group.Close()
-	log.Info().Msg("successfully instantiated topic consumer group")
+	defer shutdownTracing(context.TODO())
 
 	ctx, cancel := signal.NotifyContext(
 		context.Background(),
@@ -46,34 +58,145 @@ func main() {
 	)
 	defer cancel()
 
-	rdb := redis.NewClient(&redis.Options{Addr: "redis:6379"})
+	var publisher bus.Publisher
+	var subscriber bus.Subscriber
+	var busCheck health.Check
+	if conf.Bus.Kind == "nats" {
+		nc, js, err := natsbus.Connect(
+			context.TODO(),
+			conf.Nats.URL,
+			conf.Nats.Stream,
+			conf.Topics.All(),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't connect to nats")
+		}
+		defer nc.Close()
+		busCheck = health.Nats(nc)
+
+		publisher = natsbus.NewPublisher(js)
+		subscriber, err = natsbus.NewSubscriber(
+			natsbus.WithJetStream(js),
+			natsbus.WithStream(conf.Nats.Stream),
+			natsbus.WithDurable(conf.Nats.Durable),
+			natsbus.WithLogger(&log),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate nats subscriber")
+		}
+		log.Info().Msg("successfully connected to nats")
+	} else {
+		saramaConf := sarama.NewConfig()
+		saramaConf.Consumer.Offsets.AutoCommit.Enable = false
+		saramaConf.Consumer.Offsets.Initial = sarama.OffsetOldest
+		if err := saramaConf.Validate(); err != nil {
+			log.Fatal().Err(err).Msg("invalid kafka config")
+		}
+		group, err := sarama.NewConsumerGroup(
+			conf.Kafka.Brokers,
+			conf.Kafka.ConsumerGroup,
+			saramaConf,
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't start consuming kafka topic")
+		}
+		defer group.Close()
+		log.Info().Msg("successfully instantiated topic consumer group")
+
+		producerConf := sarama.NewConfig()
+		producerConf.Producer.RequiredAcks = sarama.WaitForAll
+		producerConf.Producer.Return.Successes = true
+		if err := producerConf.Validate(); err != nil {
+			log.Fatal().Err(err).Msg("invalid kafka producer config")
+		}
+		producer, err := sarama.NewSyncProducer(
+			conf.Kafka.Brokers,
+			producerConf,
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate kafka producer")
+		}
+		defer producer.Close()
+		log.Info().Msg("successfully instantiated kafka producer")
+
+		publisher = kafka.NewPublisher(producer)
+		subscriber = kafka.NewSubscriber(group, &log)
+
+		kc, err := sarama.NewClient(conf.Kafka.Brokers, sarama.NewConfig())
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't connect to kafka")
+		}
+		defer kc.Close()
+		busCheck = health.Kafka(kc)
+	}
+
+	publisher = tracing.NewPublisher(publisher)
+
+	rdb := redis.NewClient(&redis.Options{Addr: conf.Redis.Addr})
+	if err := redisotel.InstrumentTracing(rdb); err != nil {
+		log.Fatal().Err(err).Msg("couldn't instrument redis client")
+	}
+
+	if conf.Storage.Migrate {
+		migrator, err := migrate.New(
+			migrate.WithPool(ctx, conf.Postgres.DSN),
+			migrate.WithMigrations(migrations.FS),
+			migrate.WithLogger(&log),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate migrator")
+		}
+		n, err := migrator.Up(ctx)
+		migrator.Close()
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't migrate database schema")
+		}
+		log.Info().Int("applied", n).Msg("database schema is up to date")
+	}
 
 	u, err := urls.New(
-		urls.WithPool(context.TODO(), os.Getenv("POSTGRES_DSN")),
+		urls.WithPool(context.TODO(), conf.Postgres.DSN),
 		urls.WithRedis(rdb),
+		urls.WithCopyThreshold(copyThreshold(conf.Storage.CopyThreshold, urls.DefaultCopyThreshold)),
 	)
 	if err != nil {
 		log.Fatal().Msg("couldn't instantiate urls model")
 	}
 	defer u.Close()
 	log.Info().Msg("successfully instantiated urls model")
+	metrics.RegisterPool("urls", u)
 
 	users, err := users.NewUsers(
-		users.WithPool(context.TODO(), os.Getenv("POSTGRES_DSN")),
+		users.WithPool(context.TODO(), conf.Postgres.DSN),
+		users.WithCopyThreshold(copyThreshold(conf.Storage.CopyThreshold, users.DefaultCopyThreshold)),
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("couldn't instantiate users model")
 	}
 	defer users.Close()
 	log.Info().Msg("successfully instantiated users model")
+	metrics.RegisterPool("users", users)
 
 	h, err := storage.New(
 		storage.WithLogger(&log),
 		storage.WithContext(ctx),
-		storage.WithUrlsTopic(os.Getenv("KAFKA_URLS_TOPIC")),
+		storage.WithUrlsTopic(conf.Topics.Urls),
 		storage.WithUrlsModel(u),
-		storage.WithUsersTopic(os.Getenv("KAFKA_USERS_TOPIC")),
+		storage.WithUsersTopic(conf.Topics.Users),
 		storage.WithUsersModel(users),
+		storage.WithDeadLetterQueue(publisher, conf.Topics.DLQ),
+		storage.WithResultTopic(publisher, conf.Topics.Results),
+		storage.WithUrlsBatchPolicy(
+			batchPolicy(conf.Storage.UrlsBatch, storage.DefaultUrlsBatchPolicy),
+		),
+		storage.WithUsersBatchPolicy(
+			batchPolicy(conf.Storage.UsersBatch, storage.DefaultUsersBatchPolicy),
+		),
+		storage.WithClicks(u, conf.Topics.Events),
+		storage.WithClicksBatchPolicy(
+			batchPolicy(conf.Storage.ClicksBatch, storage.DefaultClicksBatchPolicy),
+		),
+		storage.WithShutdownTimeout(conf.Shutdown.Timeout),
 	)
 	if err != nil {
 		log.Fatal().
@@ -82,19 +205,48 @@ func main() {
 	}
 	log.Info().Msg("successfully instantiated group handler")
 
+	hc, err := health.New(
+		health.WithCheck("postgres", health.Ping(u)),
+		health.WithCheck("redis", health.Redis(rdb)),
+		health.WithCheck("bus", busCheck),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate health checks")
+	}
+	go metrics.Serve(ctx, conf.Admin.Addr, &log, hc.Mount)
 	go func() {
 		<-ctx.Done()
-		if err = group.Close(); err != nil {
-			log.Error().Err(err).Msg("error occured on group Close()")
-		}
+		hc.Drain()
 	}()
 
-	err = group.Consume(
-		ctx,
-		[]string{os.Getenv("KAFKA_URLS_TOPIC"), os.Getenv("KAFKA_USERS_TOPIC")},
-		h,
-	)
+	// returns once the handlers have written their current batches and the
+	// offsets have been committed
+	err = subscriber.Subscribe(ctx, h.Topics(), h)
 	if err != nil {
-		log.Fatal().Err(err).Msg("group.Consume() exited with an error")
+		log.Fatal().Err(err).Msg("consumption exited with an error")
+	}
+	log.Info().Msg("consumption has stopped")
+}
+
+// batchPolicy overrides limits of the default policy with the configured
+// ones
+func batchPolicy(b config.Batch, def storage.BatchPolicy) storage.BatchPolicy {
+	p := def
+	if b.MaxSize > 0 {
+		p.MaxSize = b.MaxSize
+	}
+	if b.MaxBytes > 0 {
+		p.MaxBytes = b.MaxBytes
+	}
+	if b.MaxLatency > 0 {
+		p.MaxLatency = b.MaxLatency
+	}
+	return p
+}
+
+func copyThreshold(configured int, def int) int {
+	if configured > 0 {
+		return configured
 	}
+	return def
 }
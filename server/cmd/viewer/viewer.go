@@ -2,18 +2,24 @@ package main
 
 import (
 	"context"
-	"errors"
 	"net/http"
 	"os"
 	"os/signal"
 	"shortener/internal/viewer"
+	"shortener/pkg/config"
+	"shortener/pkg/health"
+	"shortener/pkg/metrics"
 	"shortener/pkg/middleware"
+	"shortener/pkg/models/exports"
 	"shortener/pkg/models/urls"
+	"shortener/pkg/shutdown"
+	"shortener/pkg/tracing"
 	"syscall"
-	"time"
 
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 
@@ -27,9 +33,31 @@ func main() {
 		Timestamp().
 		Logger()
 
+	conf := config.Default()
+	if err := config.Load(conf, os.Args[1:]); err != nil {
+		log.Fatal().Err(err).Msg("couldn't load config")
+	}
+	if err := conf.Require("postgres.dsn", "links.redirector_host"); err != nil {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+	conf.Log(&log)
+
+	shutdownTracing, err := tracing.Setup(
+		context.TODO(),
+		"viewer",
+		conf.Tracing.Exporter,
+		conf.Tracing.Endpoint,
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't set up tracing")
+	}
+	defer shutdownTracing(context.TODO())
+
 	conn, err := grpc.NewClient(
-		"blackbox:8080",
+		conf.Blackbox.Addr,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(metrics.UnaryClientInterceptor),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("couldn't dial blackbox service")
@@ -38,47 +66,72 @@ func main() {
 	c := pbblackbox.NewBlackboxServiceClient(conn)
 	log.Info().Msg("instantiated blackbox client")
 
-	rdb := redis.NewClient(&redis.Options{Addr: "redis:6379"})
+	rdb := redis.NewClient(&redis.Options{Addr: conf.Redis.Addr})
+	if err := redisotel.InstrumentTracing(rdb); err != nil {
+		log.Fatal().Err(err).Msg("couldn't instrument redis client")
+	}
 	u, err := urls.New(
-		urls.WithPool(context.TODO(), os.Getenv("POSTGRES_DSN")),
+		urls.WithPool(context.TODO(), conf.Postgres.DSN),
 		urls.WithRedis(rdb),
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("couldn't instantiate urls model")
 	}
 	log.Info().Msg("instantiated urls model")
+	metrics.RegisterPool("urls", u)
+
+	e, err := exports.New(exports.WithPool(context.TODO(), conf.Postgres.DSN))
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate exports model")
+	}
+	defer e.Close()
+	log.Info().Msg("instantiated exports model")
+	metrics.RegisterPool("exports", e)
 
 	v, err := viewer.New(
 		viewer.WithUrls(u),
 		viewer.WithBlackboxClient(c),
-		viewer.WithRedirectorHost(os.Getenv("REDIRECTOR_HOST")),
+		viewer.WithRedirectorHost(conf.Links.RedirectorHost),
+		viewer.WithExports(e, conf.Exports.SyncLimit),
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("couldn't instantiate urls model")
 	}
 	log.Info().Msg("instantiated viewer service")
 
-	m := middleware.RequestTracing(&log)
-	mux := http.NewServeMux()
-	mux.HandleFunc(
-		"OPTIONS /history",
-		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().
-				Add("Access-Control-Allow-Origin", "http://localhost:8001")
-			w.Header().Add("Access-Control-Allow-Credentials", "true")
-		}),
+	exporter, err := viewer.NewExporter(
+		viewer.WithJobs(e),
+		viewer.WithExporterUrls(u),
+		viewer.WithExporterRedirectorHost(conf.Links.RedirectorHost),
+		viewer.WithPollInterval(conf.Exports.PollInterval),
+		viewer.WithJobTimeout(conf.Exports.JobTimeout),
+		viewer.WithRetention(conf.Exports.Retention),
+		viewer.WithExporterLogger(&log),
 	)
-	mux.Handle(
-		"GET /history",
-		m.Append(middleware.CorsHeaders).ThenFunc(v.HandleHistory),
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate exporter")
+	}
+
+	hc, err := health.New(
+		health.WithCheck("postgres", health.Ping(u)),
+		health.WithCheck("exports", health.Ping(e)),
+		health.WithCheck("redis", health.Redis(rdb)),
+		health.WithCheck("blackbox", health.Grpc(conn, pbblackbox.BlackboxService_ServiceDesc.ServiceName)),
 	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate health checks")
+	}
+
+	mux := http.NewServeMux()
+	v.Mount(mux, middleware.RequestTracing(&log), middleware.Cors{Origin: conf.Cors.Origin})
+	hc.Mount(mux)
 
 	server := http.Server{
-		Addr:         ":8080",
-		Handler:      mux,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  time.Minute,
+		Addr:         conf.HTTP.Addr,
+		Handler:      tracing.Handler(metrics.Instrument(mux), mux),
+		ReadTimeout:  conf.HTTP.ReadTimeout,
+		WriteTimeout: conf.HTTP.WriteTimeout,
+		IdleTimeout:  conf.HTTP.IdleTimeout,
 	}
 
 	ctx, cancel := signal.NotifyContext(
@@ -88,17 +141,31 @@ func main() {
 	)
 	defer cancel()
 
+	go metrics.Serve(ctx, conf.Admin.Addr, &log)
+	// a job interrupted by the shutdown is started again after its lease
+	exporterDone := make(chan struct{})
 	go func() {
-		<-ctx.Done()
-		err = server.Shutdown(context.TODO())
-		if err != nil {
-			log.Err(err).Msg("error occured on Shutdown()")
-		}
+		defer close(exporterDone)
+		exporter.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-exporterDone
 	}()
 
 	log.Info().Msg("started listening")
-	err = server.ListenAndServe()
-	if err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatal().Err(err).Msg("error during shutdown")
+	err = shutdown.Serve(
+		ctx,
+		&server,
+		hc,
+		conf.Shutdown.DrainDelay,
+		conf.Shutdown.Timeout,
+	)
+	if err != nil && ctx.Err() == nil {
+		log.Fatal().Err(err).Msg("couldn't serve")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("error during shutdown")
 	}
+	log.Info().Msg("server has stopped")
 }
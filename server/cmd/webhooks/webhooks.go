@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"shortener/internal/webhooks"
+	"shortener/pkg/bus"
+	"shortener/pkg/bus/kafka"
+	natsbus "shortener/pkg/bus/nats"
+	"shortener/pkg/config"
+	"shortener/pkg/health"
+	"shortener/pkg/metrics"
+	webhooksModel "shortener/pkg/models/webhooks"
+	"shortener/pkg/tracing"
+	"sync"
+	"syscall"
+
+	"github.com/IBM/sarama"
+	"github.com/rs/zerolog"
+)
+
+func main() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).
+		With().
+		Timestamp().
+		Logger()
+
+	conf := config.Default()
+	if err := config.Load(conf, os.Args[1:]); err != nil {
+		log.Fatal().Err(err).Msg("couldn't load config")
+	}
+	if err := conf.Require("postgres.dsn", "topics.urls", "topics.events"); err != nil {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+	conf.Log(&log)
+
+	shutdownTracing, err := tracing.Setup(
+		context.TODO(),
+		"webhooks",
+		conf.Tracing.Exporter,
+		conf.Tracing.Endpoint,
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't set up tracing")
+	}
+	defer shutdownTracing(context.TODO())
+
+	ctx, cancel := signal.NotifyContext(
+		context.Background(),
+		syscall.SIGTERM,
+		syscall.SIGINT,
+	)
+	defer cancel()
+
+	var subscriber bus.Subscriber
+	var busCheck health.Check
+	if conf.Bus.Kind == "nats" {
+		nc, js, err := natsbus.Connect(
+			context.TODO(),
+			conf.Nats.URL,
+			conf.Nats.Stream,
+			conf.Topics.All(),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't connect to nats")
+		}
+		defer nc.Close()
+		busCheck = health.Nats(nc)
+
+		subscriber, err = natsbus.NewSubscriber(
+			natsbus.WithJetStream(js),
+			natsbus.WithStream(conf.Nats.Stream),
+			natsbus.WithDurable(conf.Nats.Durable),
+			natsbus.WithLogger(&log),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't instantiate nats subscriber")
+		}
+		log.Info().Msg("successfully connected to nats")
+	} else {
+		saramaConf := sarama.NewConfig()
+		saramaConf.Consumer.Offsets.AutoCommit.Enable = false
+		saramaConf.Consumer.Offsets.Initial = sarama.OffsetOldest
+		if err := saramaConf.Validate(); err != nil {
+			log.Fatal().Err(err).Msg("invalid kafka config")
+		}
+		group, err := sarama.NewConsumerGroup(
+			conf.Kafka.Brokers,
+			conf.Kafka.ConsumerGroup,
+			saramaConf,
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't start consuming kafka topics")
+		}
+		defer group.Close()
+		subscriber = kafka.NewSubscriber(group, &log)
+		log.Info().Msg("successfully instantiated topic consumer group")
+
+		kc, err := sarama.NewClient(conf.Kafka.Brokers, sarama.NewConfig())
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't connect to kafka")
+		}
+		defer kc.Close()
+		busCheck = health.Kafka(kc)
+	}
+
+	w, err := webhooksModel.New(
+		webhooksModel.WithPool(context.TODO(), conf.Postgres.DSN),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate webhooks model")
+	}
+	defer w.Close()
+	log.Info().Msg("successfully instantiated webhooks model")
+	metrics.RegisterPool("webhooks", w)
+
+	consumer, err := webhooks.NewConsumer(
+		webhooks.WithContext(ctx),
+		webhooks.WithSubscriptions(w),
+		webhooks.WithTopics(conf.Topics.Urls, conf.Topics.Events),
+		webhooks.WithConsumerLogger(&log),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate webhooks consumer")
+	}
+
+	dispatcher, err := webhooks.NewDispatcher(
+		webhooks.WithDeliveries(w),
+		webhooks.WithRetryPolicy(webhooks.RetryPolicy{
+			Attempts:  conf.Webhooks.MaxAttempts,
+			BaseDelay: conf.Webhooks.BaseDelay,
+			MaxDelay:  conf.Webhooks.MaxDelay,
+		}),
+		webhooks.WithTimeout(conf.Webhooks.Timeout),
+		webhooks.WithBatchSize(conf.Webhooks.BatchSize),
+		webhooks.WithPollInterval(conf.Webhooks.PollInterval),
+		webhooks.WithConcurrency(conf.Webhooks.Concurrency),
+		webhooks.WithRetention(conf.Webhooks.Retention),
+		webhooks.WithDispatcherLogger(&log),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate webhooks dispatcher")
+	}
+
+	hc, err := health.New(
+		health.WithCheck("postgres", health.Ping(w)),
+		health.WithCheck("bus", busCheck),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't instantiate health checks")
+	}
+	go metrics.Serve(ctx, conf.Admin.Addr, &log, hc.Mount)
+	go func() {
+		<-ctx.Done()
+		hc.Drain()
+	}()
+
+	// the dispatcher finishes the deliveries it's posting before the model
+	// is closed
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		dispatcher.Run(ctx)
+	}()
+
+	log.Info().Msg("webhooks have started")
+	err = subscriber.Subscribe(ctx, consumer.Topics(), consumer)
+	if err != nil && ctx.Err() == nil {
+		log.Fatal().Err(err).Msg("consumption exited with an error")
+	}
+	wg.Wait()
+	log.Info().Msg("webhooks have stopped")
+}
@@ -5,11 +5,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"shortener/pkg/bus"
+	"shortener/pkg/domain"
+	"shortener/pkg/events"
+	"shortener/pkg/models/audit"
 	"shortener/pkg/models/users"
 	"shortener/pkg/responses"
+	"strconv"
+	"sync"
+	"time"
 
-	"github.com/IBM/sarama"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
@@ -18,6 +26,7 @@ import (
 	"google.golang.org/grpc/status"
 
 	pbblackbox "shortener/proto/blackbox"
+	eventsv1 "shortener/proto/events/v1"
 )
 
 type Users interface {
@@ -28,6 +37,152 @@ type Users interface {
 	) (string, error)
 
 	CheckExistence(ctx context.Context, email string) (bool, error)
+
+	GetIdByEmail(ctx context.Context, email string) (string, error)
+
+	CheckPassword(ctx context.Context, userId string, password string) error
+
+	UpdatePassword(
+		ctx context.Context,
+		userId string,
+		hashedPassword string,
+	) error
+
+	CreateResetToken(
+		ctx context.Context,
+		userId string,
+		token string,
+		ttl time.Duration,
+	) error
+
+	// ResetPassword consumes the reset token and stores the password of its
+	// owner at once, and returns id of the owner
+	ResetPassword(ctx context.Context, token string, hashedPassword string) (string, error)
+
+	GetEmail(ctx context.Context, userId string) (string, error)
+
+	GetTotp(ctx context.Context, userId string) (users.Totp, error)
+
+	SetPendingTotpSecret(ctx context.Context, userId string, secret string) error
+
+	EnableTotp(ctx context.Context, userId string, recoveryCodes []string) error
+
+	DisableTotp(ctx context.Context, userId string) error
+
+	UseTotpStep(ctx context.Context, userId string, step int64) (bool, error)
+
+	ConsumeRecoveryCode(
+		ctx context.Context,
+		userId string,
+		code string,
+	) (bool, error)
+
+	GetIdByIdentity(
+		ctx context.Context,
+		provider string,
+		subject string,
+	) (string, error)
+
+	LinkIdentity(
+		ctx context.Context,
+		userId string,
+		provider string,
+		subject string,
+	) error
+
+	CreateWithIdentity(
+		ctx context.Context,
+		user *responses.Authenticator,
+		provider string,
+		subject string,
+	) error
+}
+
+type Attempts interface {
+	LockedFor(ctx context.Context, email string, ip string) (time.Duration, error)
+
+	Fail(ctx context.Context, email string, ip string) (time.Duration, error)
+
+	Succeed(ctx context.Context, email string) error
+}
+
+type AuditLog interface {
+	Record(
+		ctx context.Context,
+		event string,
+		email string,
+		ip string,
+		details string,
+	) error
+}
+
+type Workspaces interface {
+	Create(ctx context.Context, ownerId string, name string) (string, error)
+
+	List(ctx context.Context, userId string) ([]*domain.Workspace, error)
+
+	Role(
+		ctx context.Context,
+		workspaceId string,
+		userId string,
+	) (domain.Role, error)
+
+	Members(ctx context.Context, workspaceId string) ([]*domain.Member, error)
+
+	SetRole(
+		ctx context.Context,
+		workspaceId string,
+		userId string,
+		role domain.Role,
+	) error
+
+	RemoveMember(ctx context.Context, workspaceId string, userId string) error
+
+	CreateInvite(
+		ctx context.Context,
+		workspaceId string,
+		email string,
+		role domain.Role,
+		invitedBy string,
+		token string,
+		ttl time.Duration,
+	) error
+
+	AcceptInvite(
+		ctx context.Context,
+		token string,
+		userId string,
+		email string,
+	) (string, domain.Role, error)
+}
+
+// NotificationPreferences keeps how users are reminded about their links
+// expiring
+type NotificationPreferences interface {
+	Preferences(
+		ctx context.Context,
+		userId string,
+	) (*domain.NotificationPreferences, error)
+	SetPreferences(
+		ctx context.Context,
+		userId string,
+		p *domain.NotificationPreferences,
+	) error
+}
+
+type Mailer interface {
+	Send(ctx context.Context, to string, subject string, body string) error
+}
+
+// Outbox durably stores events until they are published
+type Outbox interface {
+	Add(
+		ctx context.Context,
+		topic string,
+		key []byte,
+		payload []byte,
+		headers map[string]string,
+	) error
 }
 
 type Authentitor struct {
@@ -35,8 +190,29 @@ type Authentitor struct {
 	users          Users
 	blackboxClient pbblackbox.BlackboxServiceClient
 
-	topic    string
-	producer sarama.AsyncProducer
+	topic     string
+	publisher bus.Publisher
+	outbox    Outbox
+
+	mailer     Mailer
+	resetLink  string
+	inviteLink string
+
+	attempts Attempts
+	audit    AuditLog
+
+	workspaces Workspaces
+
+	preferences NotificationPreferences
+	// reminder days of users who haven't set their preferences
+	defaultReminderDays []int
+
+	oidcProviders map[string]*OidcProvider
+	// the page users are sent to after signing in with an OIDC provider
+	oidcLanding string
+
+	// tracks work that outlives its request, e.g. sending emails
+	background sync.WaitGroup
 }
 
 type authenticatorOption func(*Authentitor) error
@@ -57,19 +233,115 @@ func WithBlackboxClient(
 	}
 }
 
-func WithProducer(
+func WithPublisher(
 	topic string,
-	producer sarama.AsyncProducer,
+	publisher bus.Publisher,
 ) authenticatorOption {
 	return func(a *Authentitor) error {
 		a.topic = topic
-		a.producer = producer
+		a.publisher = publisher
+		return nil
+	}
+}
+
+// WithOutbox makes registrations be written to the outbox rather than
+// straight to the bus
+func WithOutbox(topic string, o Outbox) authenticatorOption {
+	return func(a *Authentitor) error {
+		a.topic = topic
+		a.outbox = o
+		return nil
+	}
+}
+
+func WithAttempts(at Attempts) authenticatorOption {
+	return func(a *Authentitor) error {
+		a.attempts = at
+		return nil
+	}
+}
+
+func WithAuditLog(l AuditLog) authenticatorOption {
+	return func(a *Authentitor) error {
+		a.audit = l
+		return nil
+	}
+}
+
+func WithMailer(m Mailer) authenticatorOption {
+	return func(a *Authentitor) error {
+		a.mailer = m
+		return nil
+	}
+}
+
+// WithResetLink sets the page users are sent to in order to reset their
+// password. The reset token is appended as the "token" query parameter. If no
+// link is provided, emails contain only the token itself.
+func WithResetLink(link string) authenticatorOption {
+	return func(a *Authentitor) error {
+		a.resetLink = link
+		return nil
+	}
+}
+
+func WithWorkspaces(ws Workspaces) authenticatorOption {
+	return func(a *Authentitor) error {
+		a.workspaces = ws
+		return nil
+	}
+}
+
+// WithNotificationPreferences lets users manage reminders about their links
+// expiring. Users without preferences are shown the default days.
+func WithNotificationPreferences(
+	p NotificationPreferences,
+	defaultDays []int,
+) authenticatorOption {
+	return func(a *Authentitor) error {
+		a.preferences = p
+		a.defaultReminderDays = defaultDays
+		return nil
+	}
+}
+
+// WithInviteLink sets the page users are sent to in order to accept an
+// invitation to a workspace. The invite token is appended as the "token"
+// query parameter.
+func WithInviteLink(link string) authenticatorOption {
+	return func(a *Authentitor) error {
+		a.inviteLink = link
+		return nil
+	}
+}
+
+// WithOidcProviders lets users sign in with the providers
+func WithOidcProviders(providers ...*OidcProvider) authenticatorOption {
+	return func(a *Authentitor) error {
+		for _, p := range providers {
+			if _, ok := a.oidcProviders[p.name]; ok {
+				return fmt.Errorf("duplicate oidc provider %q", p.name)
+			}
+			a.oidcProviders[p.name] = p
+		}
+		return nil
+	}
+}
+
+// WithOidcLanding sets the page users are redirected to after signing in
+// with an OIDC provider
+func WithOidcLanding(url string) authenticatorOption {
+	return func(a *Authentitor) error {
+		a.oidcLanding = url
 		return nil
 	}
 }
 
 func New(opts ...authenticatorOption) (*Authentitor, error) {
-	a := new(Authentitor)
+	a := &Authentitor{
+		oidcProviders: make(map[string]*OidcProvider),
+		oidcLanding:   "/",
+	}
 	for _, opt := range opts {
 		err := opt(a)
 		if err != nil {
@@ -85,8 +357,20 @@ func New(opts ...authenticatorOption) (*Authentitor, error) {
 	if a.topic == "" {
 		return nil, fmt.Errorf("no topic provided")
 	}
-	if a.producer == nil {
-		return nil, fmt.Errorf("no producer provided")
+	if a.publisher == nil && a.outbox == nil {
+		return nil, fmt.Errorf("no publisher or outbox provided")
+	}
+	if a.mailer == nil {
+		return nil, fmt.Errorf("no mailer provided")
+	}
+	if a.attempts == nil {
+		return nil, fmt.Errorf("no login attempts tracker provided")
+	}
+	if a.audit == nil {
+		return nil, fmt.Errorf("no audit log provided")
+	}
+	if a.workspaces == nil {
+		return nil, fmt.Errorf("no workspaces model provided")
 	}
 
 	return a, nil
@@ -94,6 +378,151 @@ func New(opts ...authenticatorOption) (*Authentitor, error) {
 
 const hashCost = 12
 
+// Wait blocks until all background jobs spawned by handlers are finished
+func (a *Authentitor) Wait() {
+	a.background.Wait()
+}
+
+func setSessionCookies(w http.ResponseWriter, token string) {
+	authCookie := http.Cookie{
+		Name:     "auth",
+		Value:    "pass",
+		Path:     "/",
+		MaxAge:   3600,
+		Secure:   true,
+		HttpOnly: false,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	jwtCookie := http.Cookie{
+		Name:     "JWT",
+		Value:    token,
+		Path:     "/",
+		MaxAge:   3600,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	http.SetCookie(w, &authCookie)
+	http.SetCookie(w, &jwtCookie)
+}
+
+// authorize extracts id of the user from the JWT cookie. On failure it writes
+// an error response and returns false.
+func (a *Authentitor) authorize(
+	w http.ResponseWriter,
+	r *http.Request,
+) (string, bool) {
+	log := hlog.FromRequest(r)
+
+	JWTCookie, err := r.Cookie("JWT")
+	if err != nil {
+		if errors.Is(err, http.ErrNoCookie) {
+			log.Info().Msg("no JWT cookie provided")
+			pkg, _ := json.Marshal(&responses.Server{
+				Message: "no JWT cookie provided",
+			})
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write(pkg)
+			return "", false
+		}
+
+		log.Error().
+			Err(err).
+			Msg("caught error during JWT cookie processing")
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "caught error during JWT cookie processing",
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(pkg)
+		return "", false
+	}
+
+	log.Info().Msg("validating JWT")
+	tokenInfo, err := a.blackboxClient.ValidateToken(
+		context.TODO(),
+		&pbblackbox.ValidateTokenReq{
+			Token: JWTCookie.Value,
+		},
+	)
+	if err != nil {
+		writeValidationError(w, log, err, "invalid JWT")
+		return "", false
+	}
+
+	return tokenInfo.GetUserId(), true
+}
+
+// writeValidationError maps an error returned by ValidateToken to a response.
+// invalidMessage is sent when the token itself is rejected.
+func writeValidationError(
+	w http.ResponseWriter,
+	log *zerolog.Logger,
+	err error,
+	invalidMessage string,
+) {
+	log.Error().Err(err).Msg("couldn't validate jwt")
+	s, ok := status.FromError(err)
+	if !ok {
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't validate JWT",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	switch s.Code() {
+	case codes.DeadlineExceeded:
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "deadline exceeded",
+		})
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(pkg)
+	case codes.InvalidArgument:
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: invalidMessage,
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(pkg)
+	default:
+		log.Error().
+			Uint32("grpc_code", uint32(s.Code())).
+			Msg("unknown code from grpc")
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't validate JWT",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+	}
+}
+
+// publish sends the registration to the Storage service. An asynchronous
+// publisher reports delivery errors on its own.
+func (a *Authentitor) publish(ctx context.Context, e *eventsv1.UserRegistered) error {
+	p, contentType, err := events.Encode(e)
+	if err != nil {
+		return err
+	}
+
+	if a.outbox != nil {
+		return a.outbox.Add(
+			ctx,
+			a.topic,
+			nil,
+			p,
+			map[string]string{events.HeaderContentType: contentType},
+		)
+	}
+
+	return a.publisher.Publish(ctx, &bus.Message{
+		Topic:   a.topic,
+		Value:   p,
+		Headers: map[string]string{events.HeaderContentType: contentType},
+	})
+}
+
 func (a *Authentitor) Register(w http.ResponseWriter, r *http.Request) {
 	log := hlog.FromRequest(r)
 
@@ -148,12 +577,13 @@ func (a *Authentitor) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userId := uuid.New().String()
-	p, _ := json.Marshal(&responses.Authenticator{
+	e := &eventsv1.UserRegistered{
+		IdempotencyKey: uuid.New().String(),
 		Id:             userId,
 		Name:           regForm.Name,
 		Email:          regForm.Email,
 		HashedPassword: string(hashedPwd),
-	})
+	}
 
 	log.Info().
 		Err(err).
@@ -183,9 +613,14 @@ func (a *Authentitor) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a.producer.Input() <- &sarama.ProducerMessage{
-		Topic: a.topic,
-		Value: sarama.ByteEncoder(p),
+	if err := a.publish(r.Context(), e); err != nil {
+		log.Error().Err(err).Msg("couldn't publish registration")
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't register user. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
 	}
 	log.Info().Msg("sent registration request to Storage service")
 
@@ -241,28 +676,7 @@ func (a *Authentitor) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authCookie := http.Cookie{
-		Name:     "auth",
-		Value:    "pass",
-		Path:     "/",
-		MaxAge:   3600,
-		Secure:   true,
-		HttpOnly: false,
-		SameSite: http.SameSiteLaxMode,
-	}
-
-	jwtCookie := http.Cookie{
-		Name:     "JWT",
-		Value:    signedToken.GetToken(),
-		Path:     "/",
-		MaxAge:   3600,
-		Secure:   true,
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-	}
-
-	http.SetCookie(w, &authCookie)
-	http.SetCookie(w, &jwtCookie)
+	setSessionCookies(w, signedToken.GetToken())
 	w.WriteHeader(http.StatusOK)
 
 	pkg, _ := json.Marshal(&responses.Server{
@@ -310,6 +724,22 @@ func (a *Authentitor) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := clientIp(r)
+	lockedFor, err := a.attempts.LockedFor(context.TODO(), loginForm.Email, ip)
+	if err != nil {
+		// failing open: an outage of the attempts storage shouldn't
+		// prevent everyone from logging in
+		log.Error().Err(err).Msg("couldn't check login lockout")
+	} else if lockedFor > 0 {
+		log.Info().
+			Str("email", loginForm.Email).
+			Dur("locked_for", lockedFor).
+			Msg("login attempt during lockout")
+
+		writeTooManyAttempts(w, lockedFor)
+		return
+	}
+
 	log.Info().Str("email", loginForm.Email).Msg("trying to authenticate")
 	userId, err := a.users.Authenticate(
 		context.TODO(),
@@ -317,11 +747,16 @@ func (a *Authentitor) Login(w http.ResponseWriter, r *http.Request) {
 		loginForm.Password,
 	)
 	if err != nil {
-		if errors.Is(err, users.ErrWrongCredentials) {
+		// unknown emails are treated exactly as wrong passwords, so that
+		// the response doesn't reveal whether an account exists
+		if errors.Is(err, users.ErrWrongCredentials) ||
+			errors.Is(err, users.ErrNotFound) {
 			log.Error().
 				Err(err).
 				Msg("wrong credentials")
 
+			a.registerFailure(log, loginForm.Email, ip)
+
 			pkg, _ := json.Marshal(&responses.Server{
 				Message: "wrong email or password",
 			})
@@ -344,6 +779,28 @@ func (a *Authentitor) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := a.attempts.Succeed(context.TODO(), loginForm.Email); err != nil {
+		log.Error().Err(err).Msg("couldn't reset failed login attempts")
+	}
+
+	log.Info().Msg("checking whether second factor is required")
+	totpInfo, err := a.users.GetTotp(context.TODO(), userId)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't get two-factor settings")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't check credentials",
+		})
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+	if totpInfo.Enabled {
+		a.requireSecondFactor(w, r, userId)
+		return
+	}
+
 	log.Info().Msg("Issuing JWT")
 	signedToken, err := a.blackboxClient.IssueToken(
 		context.TODO(),
@@ -361,28 +818,7 @@ func (a *Authentitor) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authCookie := http.Cookie{
-		Name:     "auth",
-		Value:    "pass",
-		Path:     "/",
-		MaxAge:   3600,
-		Secure:   true,
-		HttpOnly: false,
-		SameSite: http.SameSiteLaxMode,
-	}
-
-	jwtCookie := http.Cookie{
-		Name:     "JWT",
-		Value:    signedToken.GetToken(),
-		Path:     "/",
-		MaxAge:   3600,
-		Secure:   true,
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-	}
-
-	http.SetCookie(w, &authCookie)
-	http.SetCookie(w, &jwtCookie)
+	setSessionCookies(w, signedToken.GetToken())
 	w.WriteHeader(http.StatusOK)
 
 	pkg, _ := json.Marshal(&responses.Server{
@@ -393,3 +829,54 @@ func (a *Authentitor) Login(w http.ResponseWriter, r *http.Request) {
 
 	log.Info().Msg("successful login")
 }
+
+func writeTooManyAttempts(w http.ResponseWriter, lockedFor time.Duration) {
+	w.Header().Set(
+		"Retry-After",
+		strconv.Itoa(int(math.Ceil(lockedFor.Seconds()))),
+	)
+	pkg, _ := json.Marshal(&responses.Server{
+		Message: "too many failed login attempts. try again later",
+	})
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(pkg)
+}
+
+// clientIp returns the address the request came from without port
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (a *Authentitor) registerFailure(
+	log *zerolog.Logger,
+	email string,
+	ip string,
+) {
+	lockout, err := a.attempts.Fail(context.TODO(), email, ip)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't register failed login attempt")
+		return
+	}
+	if lockout == 0 {
+		return
+	}
+
+	log.Warn().
+		Str("email", email).
+		Dur("lockout", lockout).
+		Msg("locking login attempts")
+	err = a.audit.Record(
+		context.TODO(),
+		audit.EventLoginLockout,
+		email,
+		ip,
+		fmt.Sprintf("locked for %s", lockout),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't record lockout in audit log")
+	}
+}
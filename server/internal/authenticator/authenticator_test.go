@@ -3,31 +3,40 @@ package authenticator
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"shortener/pkg/domain"
 	"shortener/pkg/middleware"
+	"shortener/pkg/models/audit"
+	"shortener/pkg/models/reminders"
 	"shortener/pkg/models/users"
+	"shortener/pkg/models/workspaces"
 	"shortener/pkg/responses"
 	"shortener/proto/blackbox"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/IBM/sarama"
-	"github.com/IBM/sarama/mocks"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	bus_mocks "shortener/mocks/shortener/pkg/bus"
 	pbblackbox_mocks "shortener/mocks/shortener/proto/blackbox"
 )
 
 func TestNewSignUp(t *testing.T) {
-	conf := sarama.NewConfig()
-	conf.Producer.RequiredAcks = sarama.WaitForAll
-	conf.Producer.Flush.Frequency = 500 * time.Millisecond
-	conf.Producer.Return.Errors = false
-	p := mocks.NewAsyncProducer(t, conf).ExpectInputAndSucceed()
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().Publish(mock.Anything, mock.Anything).Return(nil).Once()
 
 	email := "some@mail.ru"
 
@@ -52,9 +61,13 @@ func TestNewSignUp(t *testing.T) {
 		}, nil)
 
 	authenticator, err := New(
-		WithProducer("topic", p),
+		WithPublisher("topic", p),
 		WithUsersDB(uMock),
 		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(NewMockAttempts(t)),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
 	)
 	assert.Nil(t, err)
 
@@ -80,12 +93,48 @@ func TestNewSignUp(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestSignUpOutboxFailure(t *testing.T) {
+	email := "some@mail.ru"
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		CheckExistence(context.TODO(), email).
+		Return(false, nil)
+
+	oMock := NewMockOutbox(t)
+	oMock.EXPECT().
+		Add(mock.Anything, "topic", []byte(nil), mock.Anything, mock.Anything).
+		Return(errors.New("connection refused"))
+
+	authenticator, err := New(
+		WithOutbox("topic", oMock),
+		WithUsersDB(uMock),
+		// no token must be issued for a user that hasn't been stored
+		WithBlackboxClient(pbblackbox_mocks.NewMockBlackboxServiceClient(t)),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(NewMockAttempts(t)),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&registerRequest{
+		Name:     "name",
+		Email:    email,
+		Password: "password",
+	})
+
+	request, err := http.NewRequest("POST", "/signup", bytes.NewReader(req))
+	assert.Nil(t, err)
+
+	authenticator.Register(rr, request)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Result().StatusCode)
+}
+
 func TestSignUpUserAlreadyExists(t *testing.T) {
-	conf := sarama.NewConfig()
-	conf.Producer.RequiredAcks = sarama.WaitForAll
-	conf.Producer.Flush.Frequency = 500 * time.Millisecond
-	conf.Producer.Return.Errors = false
-	p := mocks.NewAsyncProducer(t, conf)
+	p := bus_mocks.NewMockPublisher(t)
 
 	email := "some@mail.ru"
 
@@ -97,9 +146,13 @@ func TestSignUpUserAlreadyExists(t *testing.T) {
 	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
 
 	authenticator, err := New(
-		WithProducer("topic", p),
+		WithPublisher("topic", p),
 		WithUsersDB(uMock),
 		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(NewMockAttempts(t)),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
 	)
 	assert.Nil(t, err)
 
@@ -126,11 +179,7 @@ func TestSignUpUserAlreadyExists(t *testing.T) {
 }
 
 func TestLoginSuccess(t *testing.T) {
-	conf := sarama.NewConfig()
-	conf.Producer.RequiredAcks = sarama.WaitForAll
-	conf.Producer.Flush.Frequency = 500 * time.Millisecond
-	conf.Producer.Return.Errors = false
-	p := mocks.NewAsyncProducer(t, conf)
+	p := bus_mocks.NewMockPublisher(t)
 
 	userId := "id"
 	email := "some@mail.ru"
@@ -139,6 +188,17 @@ func TestLoginSuccess(t *testing.T) {
 	uMock.EXPECT().
 		Authenticate(context.TODO(), email, "password").
 		Return(userId, nil)
+	uMock.EXPECT().
+		GetTotp(context.TODO(), userId).
+		Return(users.Totp{}, nil)
+
+	aMock := NewMockAttempts(t)
+	aMock.EXPECT().
+		LockedFor(context.TODO(), email, "").
+		Return(0, nil)
+	aMock.EXPECT().
+		Succeed(context.TODO(), email).
+		Return(nil)
 
 	token := jwt.NewWithClaims(
 		jwt.SigningMethodHS256,
@@ -155,9 +215,13 @@ func TestLoginSuccess(t *testing.T) {
 		}, nil)
 
 	authenticator, err := New(
-		WithProducer("topic", p),
+		WithPublisher("topic", p),
 		WithUsersDB(uMock),
 		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(aMock),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
 	)
 	assert.Nil(t, err)
 
@@ -183,11 +247,7 @@ func TestLoginSuccess(t *testing.T) {
 }
 
 func TestLoginFailedCredentials(t *testing.T) {
-	conf := sarama.NewConfig()
-	conf.Producer.RequiredAcks = sarama.WaitForAll
-	conf.Producer.Flush.Frequency = 500 * time.Millisecond
-	conf.Producer.Return.Errors = false
-	p := mocks.NewAsyncProducer(t, conf)
+	p := bus_mocks.NewMockPublisher(t)
 
 	email := "some@mail.ru"
 
@@ -196,12 +256,24 @@ func TestLoginFailedCredentials(t *testing.T) {
 		Authenticate(context.TODO(), email, "password").
 		Return("", users.ErrWrongCredentials)
 
+	aMock := NewMockAttempts(t)
+	aMock.EXPECT().
+		LockedFor(context.TODO(), email, "").
+		Return(0, nil)
+	aMock.EXPECT().
+		Fail(context.TODO(), email, "").
+		Return(0, nil)
+
 	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
 
 	authenticator, err := New(
-		WithProducer("topic", p),
+		WithPublisher("topic", p),
 		WithUsersDB(uMock),
 		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(aMock),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
 	)
 	assert.Nil(t, err)
 
@@ -225,3 +297,1569 @@ func TestLoginFailedCredentials(t *testing.T) {
 	err = json.NewDecoder(rsp.Body).Decode(&body)
 	assert.Nil(t, err)
 }
+
+func TestForgotPasswordSendsToken(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+
+	email := "some@mail.ru"
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		GetIdByEmail(mock.Anything, email).
+		Return("id", nil)
+
+	var token string
+	uMock.EXPECT().
+		CreateResetToken(mock.Anything, "id", mock.AnythingOfType("string"), resetTokenTTL).
+		Run(func(_ context.Context, _ string, t string, _ time.Duration) {
+			token = t
+		}).
+		Return(nil)
+
+	mMock := NewMockMailer(t)
+	mMock.EXPECT().
+		Send(mock.Anything, email, "Password reset", mock.MatchedBy(func(body string) bool {
+			return token != "" && strings.Contains(body, token)
+		})).
+		Return(nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", p),
+		WithUsersDB(uMock),
+		WithBlackboxClient(pbblackbox_mocks.NewMockBlackboxServiceClient(t)),
+		WithMailer(mMock),
+		WithAttempts(NewMockAttempts(t)),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&forgotPasswordRequest{
+		Email: email,
+	})
+
+	request, err := http.NewRequest(
+		"POST",
+		"/password/forgot",
+		bytes.NewReader(req),
+	)
+	assert.Nil(t, err)
+
+	authenticator.ForgotPassword(rr, request)
+	authenticator.Wait()
+
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+
+	email := "some@mail.ru"
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		GetIdByEmail(mock.Anything, email).
+		Return("", users.ErrNotFound)
+
+	authenticator, err := New(
+		WithPublisher("topic", p),
+		WithUsersDB(uMock),
+		WithBlackboxClient(pbblackbox_mocks.NewMockBlackboxServiceClient(t)),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(NewMockAttempts(t)),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&forgotPasswordRequest{
+		Email: email,
+	})
+
+	request, err := http.NewRequest(
+		"POST",
+		"/password/forgot",
+		bytes.NewReader(req),
+	)
+	assert.Nil(t, err)
+
+	authenticator.ForgotPassword(rr, request)
+	authenticator.Wait()
+
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+}
+
+func TestResetPasswordSuccess(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		ResetPassword(context.TODO(), "token", mock.AnythingOfType("string")).
+		Return("id", nil)
+
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		RevokeTokens(context.TODO(), &blackbox.RevokeTokensReq{UserId: "id"}).
+		Return(&blackbox.RevokeTokensRsp{}, nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", p),
+		WithUsersDB(uMock),
+		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(NewMockAttempts(t)),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&resetPasswordRequest{
+		Token:    "token",
+		Password: "new password",
+	})
+
+	request, err := http.NewRequest(
+		"POST",
+		"/password/reset",
+		bytes.NewReader(req),
+	)
+	assert.Nil(t, err)
+
+	authenticator.ResetPassword(rr, request)
+
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+}
+
+func TestResetPasswordFailure(t *testing.T) {
+	for _, data := range []struct {
+		Name   string
+		Err    error
+		Status int
+	}{
+		{
+			Name:   "invalid token",
+			Err:    users.ErrInvalidResetToken,
+			Status: http.StatusForbidden,
+		},
+		{
+			// the token isn't consumed, so the user can try again
+			Name:   "database failure",
+			Err:    errors.New("connection refused"),
+			Status: http.StatusInternalServerError,
+		},
+	} {
+		t.Run(data.Name, func(t *testing.T) {
+			uMock := NewMockUsers(t)
+			uMock.EXPECT().
+				ResetPassword(context.TODO(), "token", mock.AnythingOfType("string")).
+				Return("", data.Err)
+
+			// sessions aren't revoked
+			authenticator, err := New(
+				WithPublisher("topic", bus_mocks.NewMockPublisher(t)),
+				WithUsersDB(uMock),
+				WithBlackboxClient(pbblackbox_mocks.NewMockBlackboxServiceClient(t)),
+				WithMailer(NewMockMailer(t)),
+				WithAttempts(NewMockAttempts(t)),
+				WithAuditLog(NewMockAuditLog(t)),
+				WithWorkspaces(NewMockWorkspaces(t)),
+			)
+			assert.Nil(t, err)
+
+			rr := httptest.NewRecorder()
+			req, _ := json.Marshal(&resetPasswordRequest{
+				Token:    "token",
+				Password: "new password",
+			})
+
+			request, err := http.NewRequest(
+				"POST",
+				"/password/reset",
+				bytes.NewReader(req),
+			)
+			assert.Nil(t, err)
+
+			authenticator.ResetPassword(rr, request)
+
+			assert.Equal(t, data.Status, rr.Result().StatusCode)
+		})
+	}
+}
+
+func TestChangePasswordSuccess(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		CheckPassword(context.TODO(), "id", "old password").
+		Return(nil)
+	uMock.EXPECT().
+		UpdatePassword(context.TODO(), "id", mock.AnythingOfType("string")).
+		Return(nil)
+
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		ValidateToken(context.TODO(), &blackbox.ValidateTokenReq{Token: "token"}).
+		Return(&blackbox.ValidateTokenRsp{UserId: "id"}, nil)
+	cMock.EXPECT().
+		RevokeTokens(context.TODO(), &blackbox.RevokeTokensReq{UserId: "id"}).
+		Return(&blackbox.RevokeTokensRsp{}, nil)
+	cMock.EXPECT().
+		IssueToken(context.TODO(), &blackbox.IssueTokenReq{UserId: "id"}).
+		Return(&blackbox.IssueTokenRsp{Token: "new token"}, nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", p),
+		WithUsersDB(uMock),
+		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(NewMockAttempts(t)),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&changePasswordRequest{
+		OldPassword: "old password",
+		NewPassword: "new password",
+	})
+
+	request, err := http.NewRequest(
+		"POST",
+		"/password/change",
+		bytes.NewReader(req),
+	)
+	assert.Nil(t, err)
+	request.AddCookie(&http.Cookie{Name: "JWT", Value: "token"})
+
+	authenticator.ChangePassword(rr, request)
+
+	rsp := rr.Result()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	var newToken string
+	for _, c := range rsp.Cookies() {
+		if c.Name == "JWT" {
+			newToken = c.Value
+		}
+	}
+	assert.Equal(t, "new token", newToken)
+}
+
+func TestChangePasswordWrongOldPassword(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		CheckPassword(context.TODO(), "id", "old password").
+		Return(users.ErrWrongCredentials)
+
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		ValidateToken(context.TODO(), &blackbox.ValidateTokenReq{Token: "token"}).
+		Return(&blackbox.ValidateTokenRsp{UserId: "id"}, nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", p),
+		WithUsersDB(uMock),
+		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(NewMockAttempts(t)),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&changePasswordRequest{
+		OldPassword: "old password",
+		NewPassword: "new password",
+	})
+
+	request, err := http.NewRequest(
+		"POST",
+		"/password/change",
+		bytes.NewReader(req),
+	)
+	assert.Nil(t, err)
+	request.AddCookie(&http.Cookie{Name: "JWT", Value: "token"})
+
+	authenticator.ChangePassword(rr, request)
+
+	assert.Equal(t, http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func TestLoginUnknownEmail(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+
+	email := "some@mail.ru"
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		Authenticate(context.TODO(), email, "password").
+		Return("", users.ErrNotFound)
+
+	aMock := NewMockAttempts(t)
+	aMock.EXPECT().
+		LockedFor(context.TODO(), email, "127.0.0.1").
+		Return(0, nil)
+	aMock.EXPECT().
+		Fail(context.TODO(), email, "127.0.0.1").
+		Return(0, nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", p),
+		WithUsersDB(uMock),
+		WithBlackboxClient(pbblackbox_mocks.NewMockBlackboxServiceClient(t)),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(aMock),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&loginRequest{
+		Email:    email,
+		Password: "password",
+	})
+
+	request, err := http.NewRequest("POST", "/login", bytes.NewReader(req))
+	assert.Nil(t, err)
+	request.RemoteAddr = "127.0.0.1:4242"
+
+	authenticator.Login(rr, request)
+
+	rsp := rr.Result()
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+
+	var body responses.Server
+	err = json.NewDecoder(rsp.Body).Decode(&body)
+	assert.Nil(t, err)
+	assert.Equal(t, "wrong email or password", body.Message)
+}
+
+func TestLoginLockoutIsAudited(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+
+	email := "some@mail.ru"
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		Authenticate(context.TODO(), email, "password").
+		Return("", users.ErrWrongCredentials)
+
+	aMock := NewMockAttempts(t)
+	aMock.EXPECT().
+		LockedFor(context.TODO(), email, "127.0.0.1").
+		Return(0, nil)
+	aMock.EXPECT().
+		Fail(context.TODO(), email, "127.0.0.1").
+		Return(time.Minute, nil)
+
+	auditMock := NewMockAuditLog(t)
+	auditMock.EXPECT().
+		Record(context.TODO(), audit.EventLoginLockout, email, "127.0.0.1", mock.AnythingOfType("string")).
+		Return(nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", p),
+		WithUsersDB(uMock),
+		WithBlackboxClient(pbblackbox_mocks.NewMockBlackboxServiceClient(t)),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(aMock),
+		WithAuditLog(auditMock),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&loginRequest{
+		Email:    email,
+		Password: "password",
+	})
+
+	request, err := http.NewRequest("POST", "/login", bytes.NewReader(req))
+	assert.Nil(t, err)
+	request.RemoteAddr = "127.0.0.1:4242"
+
+	authenticator.Login(rr, request)
+
+	assert.Equal(t, http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func TestLoginLocked(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+
+	email := "some@mail.ru"
+
+	aMock := NewMockAttempts(t)
+	aMock.EXPECT().
+		LockedFor(context.TODO(), email, "127.0.0.1").
+		Return(90*time.Second+time.Millisecond, nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", p),
+		WithUsersDB(NewMockUsers(t)),
+		WithBlackboxClient(pbblackbox_mocks.NewMockBlackboxServiceClient(t)),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(aMock),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&loginRequest{
+		Email:    email,
+		Password: "password",
+	})
+
+	request, err := http.NewRequest("POST", "/login", bytes.NewReader(req))
+	assert.Nil(t, err)
+	request.RemoteAddr = "127.0.0.1:4242"
+
+	authenticator.Login(rr, request)
+
+	rsp := rr.Result()
+	assert.Equal(t, http.StatusTooManyRequests, rsp.StatusCode)
+	assert.Equal(t, "91", rsp.Header.Get("Retry-After"))
+}
+
+func TestLoginRequiresSecondFactor(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+
+	userId := "id"
+	email := "some@mail.ru"
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		Authenticate(context.TODO(), email, "password").
+		Return(userId, nil)
+	uMock.EXPECT().
+		GetTotp(context.TODO(), userId).
+		Return(users.Totp{Secret: "secret", Enabled: true}, nil)
+
+	aMock := NewMockAttempts(t)
+	aMock.EXPECT().
+		LockedFor(context.TODO(), email, "").
+		Return(0, nil)
+	aMock.EXPECT().
+		Succeed(context.TODO(), email).
+		Return(nil)
+
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		IssueToken(context.TODO(), &blackbox.IssueTokenReq{
+			UserId: userId,
+			Scope:  blackbox.Scope_SCOPE_MFA_PENDING,
+		}).
+		Return(&blackbox.IssueTokenRsp{
+			Token: "mfa-token",
+		}, nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", p),
+		WithUsersDB(uMock),
+		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(aMock),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&loginRequest{
+		Email:    email,
+		Password: "password",
+	})
+
+	request, err := http.NewRequest("POST", "/login", bytes.NewReader(req))
+	assert.Nil(t, err)
+
+	authenticator.Login(rr, request)
+
+	rsp := rr.Result()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	// the session must not be established before the second factor
+	assert.Empty(t, rsp.Cookies())
+
+	var body responses.MfaRequired
+	err = json.NewDecoder(rsp.Body).Decode(&body)
+	assert.Nil(t, err)
+	assert.Equal(t, "mfa-token", body.MfaToken)
+}
+
+func TestLoginMfaWithTotp(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+
+	userId := "id"
+	secret := "JBSWY3DPEHPK3PXP"
+	code, err := totp.GenerateCode(secret, time.Now())
+	assert.Nil(t, err)
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		GetTotp(context.TODO(), userId).
+		Return(users.Totp{Secret: secret, Enabled: true}, nil)
+	uMock.EXPECT().
+		UseTotpStep(context.TODO(), userId, mock.AnythingOfType("int64")).
+		Return(true, nil)
+
+	aMock := NewMockAttempts(t)
+	aMock.EXPECT().
+		LockedFor(context.TODO(), "mfa:"+userId, "").
+		Return(0, nil)
+	aMock.EXPECT().
+		Succeed(context.TODO(), "mfa:"+userId).
+		Return(nil)
+
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		ValidateToken(context.TODO(), &blackbox.ValidateTokenReq{
+			Token: "mfa-token",
+			Scope: blackbox.Scope_SCOPE_MFA_PENDING,
+		}).
+		Return(&blackbox.ValidateTokenRsp{
+			UserId: userId,
+		}, nil)
+	cMock.EXPECT().
+		IssueToken(context.TODO(), &blackbox.IssueTokenReq{
+			UserId: userId,
+		}).
+		Return(&blackbox.IssueTokenRsp{
+			Token: "session-token",
+		}, nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", p),
+		WithUsersDB(uMock),
+		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(aMock),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&loginMfaRequest{
+		MfaToken: "mfa-token",
+		Code:     code,
+	})
+
+	request, err := http.NewRequest("POST", "/login/mfa", bytes.NewReader(req))
+	assert.Nil(t, err)
+
+	authenticator.LoginMfa(rr, request)
+
+	rsp := rr.Result()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	var jwtCookie string
+	for _, c := range rsp.Cookies() {
+		if c.Name == "JWT" {
+			jwtCookie = c.Value
+		}
+	}
+	assert.Equal(t, "session-token", jwtCookie)
+}
+
+func TestLoginMfaReplayedCode(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+
+	userId := "id"
+	secret := "JBSWY3DPEHPK3PXP"
+	code, err := totp.GenerateCode(secret, time.Now())
+	assert.Nil(t, err)
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		GetTotp(context.TODO(), userId).
+		Return(users.Totp{Secret: secret, Enabled: true}, nil)
+	uMock.EXPECT().
+		UseTotpStep(context.TODO(), userId, mock.AnythingOfType("int64")).
+		Return(false, nil)
+
+	aMock := NewMockAttempts(t)
+	aMock.EXPECT().
+		LockedFor(context.TODO(), "mfa:"+userId, "").
+		Return(0, nil)
+	aMock.EXPECT().
+		Fail(context.TODO(), "mfa:"+userId, "").
+		Return(0, nil)
+
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		ValidateToken(context.TODO(), mock.AnythingOfType("*blackbox.ValidateTokenReq")).
+		Return(&blackbox.ValidateTokenRsp{
+			UserId: userId,
+		}, nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", p),
+		WithUsersDB(uMock),
+		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(aMock),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&loginMfaRequest{
+		MfaToken: "mfa-token",
+		Code:     code,
+	})
+
+	request, err := http.NewRequest("POST", "/login/mfa", bytes.NewReader(req))
+	assert.Nil(t, err)
+
+	authenticator.LoginMfa(rr, request)
+
+	assert.Equal(t, http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func TestLoginMfaWithRecoveryCode(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+
+	userId := "id"
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		ConsumeRecoveryCode(context.TODO(), userId, "abcd-efgh").
+		Return(true, nil)
+
+	aMock := NewMockAttempts(t)
+	aMock.EXPECT().
+		LockedFor(context.TODO(), "mfa:"+userId, "").
+		Return(0, nil)
+	aMock.EXPECT().
+		Succeed(context.TODO(), "mfa:"+userId).
+		Return(nil)
+
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		ValidateToken(context.TODO(), mock.AnythingOfType("*blackbox.ValidateTokenReq")).
+		Return(&blackbox.ValidateTokenRsp{
+			UserId: userId,
+		}, nil)
+	cMock.EXPECT().
+		IssueToken(context.TODO(), mock.AnythingOfType("*blackbox.IssueTokenReq")).
+		Return(&blackbox.IssueTokenRsp{
+			Token: "session-token",
+		}, nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", p),
+		WithUsersDB(uMock),
+		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(aMock),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&loginMfaRequest{
+		MfaToken:     "mfa-token",
+		RecoveryCode: "abcd-efgh",
+	})
+
+	request, err := http.NewRequest("POST", "/login/mfa", bytes.NewReader(req))
+	assert.Nil(t, err)
+
+	authenticator.LoginMfa(rr, request)
+
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+}
+
+func TestConfirmMfa(t *testing.T) {
+	for _, data := range []struct {
+		Name      string
+		Fresh     bool
+		EnableErr error
+		Status    int
+	}{
+		{
+			Name:   "enabled",
+			Fresh:  true,
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "replayed code",
+			Status: http.StatusForbidden,
+		},
+		{
+			Name:      "enabled meanwhile",
+			Fresh:     true,
+			EnableErr: users.ErrTotpEnabled,
+			Status:    http.StatusConflict,
+		},
+		{
+			Name:      "database failure",
+			Fresh:     true,
+			EnableErr: errors.New("connection refused"),
+			Status:    http.StatusInternalServerError,
+		},
+	} {
+		t.Run(data.Name, func(t *testing.T) {
+			p := bus_mocks.NewMockPublisher(t)
+
+			userId := "id"
+			subject := mfaAttemptsSubject(userId)
+			secret := "JBSWY3DPEHPK3PXP"
+			code, err := totp.GenerateCode(secret, time.Now())
+			assert.Nil(t, err)
+
+			uMock := NewMockUsers(t)
+			uMock.EXPECT().
+				GetTotp(context.TODO(), userId).
+				Return(users.Totp{Secret: secret}, nil)
+			uMock.EXPECT().
+				UseTotpStep(context.TODO(), userId, mock.AnythingOfType("int64")).
+				Return(data.Fresh, nil)
+
+			aMock := NewMockAttempts(t)
+			aMock.EXPECT().
+				LockedFor(context.TODO(), subject, "").
+				Return(0, nil)
+			if data.Fresh {
+				uMock.EXPECT().
+					EnableTotp(context.TODO(), userId, mock.AnythingOfType("[]string")).
+					Return(data.EnableErr)
+				aMock.EXPECT().
+					Succeed(context.TODO(), subject).
+					Return(nil)
+			} else {
+				aMock.EXPECT().
+					Fail(context.TODO(), subject, "").
+					Return(0, nil)
+			}
+
+			cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+			cMock.EXPECT().
+				ValidateToken(context.TODO(), mock.AnythingOfType("*blackbox.ValidateTokenReq")).
+				Return(&blackbox.ValidateTokenRsp{
+					UserId: userId,
+				}, nil)
+
+			authenticator, err := New(
+				WithPublisher("topic", p),
+				WithUsersDB(uMock),
+				WithBlackboxClient(cMock),
+				WithMailer(NewMockMailer(t)),
+				WithAttempts(aMock),
+				WithAuditLog(NewMockAuditLog(t)),
+				WithWorkspaces(NewMockWorkspaces(t)),
+			)
+			assert.Nil(t, err)
+
+			rr := httptest.NewRecorder()
+			req, _ := json.Marshal(&totpCodeRequest{
+				Code: code,
+			})
+
+			request, err := http.NewRequest("POST", "/mfa/confirm", bytes.NewReader(req))
+			assert.Nil(t, err)
+			request.AddCookie(&http.Cookie{Name: "JWT", Value: "token"})
+
+			authenticator.ConfirmMfa(rr, request)
+
+			rsp := rr.Result()
+			assert.Equal(t, data.Status, rsp.StatusCode)
+			if data.Status != http.StatusOK {
+				return
+			}
+
+			var body responses.RecoveryCodes
+			err = json.NewDecoder(rsp.Body).Decode(&body)
+			assert.Nil(t, err)
+			assert.Len(t, body.RecoveryCodes, recoveryCodesCount)
+		})
+	}
+}
+
+func TestConfirmMfaLockedOut(t *testing.T) {
+	userId := "id"
+	aMock := NewMockAttempts(t)
+	aMock.EXPECT().
+		LockedFor(context.TODO(), mfaAttemptsSubject(userId), "").
+		Return(time.Minute, nil)
+
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		ValidateToken(context.TODO(), mock.AnythingOfType("*blackbox.ValidateTokenReq")).
+		Return(&blackbox.ValidateTokenRsp{
+			UserId: userId,
+		}, nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", bus_mocks.NewMockPublisher(t)),
+		WithUsersDB(NewMockUsers(t)),
+		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(aMock),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+	)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	req, _ := json.Marshal(&totpCodeRequest{
+		Code: "123456",
+	})
+	request, err := http.NewRequest("POST", "/mfa/confirm", bytes.NewReader(req))
+	assert.Nil(t, err)
+	request.AddCookie(&http.Cookie{Name: "JWT", Value: "token"})
+
+	authenticator.ConfirmMfa(rr, request)
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Result().StatusCode)
+}
+
+func TestVerifyTotpSkew(t *testing.T) {
+	secret := "JBSWY3DPEHPK3PXP"
+	now := time.Now()
+
+	previous, err := totp.GenerateCode(secret, now.Add(-totpPeriod*time.Second))
+	assert.Nil(t, err)
+	_, ok := verifyTotp(secret, previous, now)
+	assert.True(t, ok)
+
+	stale, err := totp.GenerateCode(secret, now.Add(-3*totpPeriod*time.Second))
+	assert.Nil(t, err)
+	_, ok = verifyTotp(secret, stale, now)
+	assert.False(t, ok)
+}
+
+// mockOidcIdp is a minimal OpenID Connect provider. It issues an ID token
+// with the given claims for the code "code" if PKCE verification passes.
+type mockOidcIdp struct {
+	t      *testing.T
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+
+	// taken from the authorization request
+	challenge string
+	nonce     string
+}
+
+func newMockOidcIdp(t *testing.T, claims jwt.MapClaims) *mockOidcIdp {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	idp := &mockOidcIdp{t: t, key: key, claims: claims}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"GET /.well-known/openid-configuration",
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]any{
+				"issuer":                                idp.srv.URL,
+				"authorization_endpoint":                idp.srv.URL + "/authorize",
+				"token_endpoint":                        idp.srv.URL + "/token",
+				"jwks_uri":                              idp.srv.URL + "/jwks",
+				"id_token_signing_alg_values_supported": []string{"RS256"},
+			})
+		},
+	)
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key",
+				"alg": "RS256",
+				"use": "sig",
+				"n": base64.RawURLEncoding.EncodeToString(
+					key.PublicKey.N.Bytes(),
+				),
+				"e": base64.RawURLEncoding.EncodeToString(
+					big.NewInt(int64(key.PublicKey.E)).Bytes(),
+				),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != "code" ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   idp.srv.URL,
+			"aud":   "client",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": idp.nonce,
+		}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "key"
+		idToken, err := token.SignedString(key)
+		assert.Nil(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+
+	idp.srv = httptest.NewServer(mux)
+	t.Cleanup(idp.srv.Close)
+	return idp
+}
+
+// authorize starts login at the authenticator and returns the callback
+// request the provider would redirect the user with
+func (idp *mockOidcIdp) authorize(a *Authentitor) *http.Request {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /oidc/{provider}/login", a.OidcLogin)
+
+	rr := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/oidc/mock/login", nil)
+	mux.ServeHTTP(rr, request)
+
+	rsp := rr.Result()
+	assert.Equal(idp.t, http.StatusFound, rsp.StatusCode)
+
+	location, err := url.Parse(rsp.Header.Get("Location"))
+	assert.Nil(idp.t, err)
+	assert.Equal(idp.t, "S256", location.Query().Get("code_challenge_method"))
+	idp.challenge = location.Query().Get("code_challenge")
+	idp.nonce = location.Query().Get("nonce")
+
+	callback := httptest.NewRequest(
+		"GET",
+		"/oidc/mock/callback?code=code&state="+
+			url.QueryEscape(location.Query().Get("state")),
+		nil,
+	)
+	for _, c := range rsp.Cookies() {
+		callback.AddCookie(c)
+	}
+	callback.SetPathValue("provider", "mock")
+	return callback
+}
+
+func newOidcAuthenticator(
+	t *testing.T,
+	idp *mockOidcIdp,
+	uMock *MockUsers,
+	cMock *pbblackbox_mocks.MockBlackboxServiceClient,
+	auditMock *MockAuditLog,
+) *Authentitor {
+	provider, err := NewOidcProvider(context.TODO(), OidcConfig{
+		Name:         "mock",
+		Issuer:       idp.srv.URL,
+		ClientId:     "client",
+		ClientSecret: "secret",
+		RedirectUrl:  "http://localhost/oidc/mock/callback",
+	})
+	assert.Nil(t, err)
+
+	authenticator, err := New(
+		WithPublisher("topic", bus_mocks.NewMockPublisher(t)),
+		WithUsersDB(uMock),
+		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(NewMockAttempts(t)),
+		WithAuditLog(auditMock),
+		WithWorkspaces(NewMockWorkspaces(t)),
+		WithOidcProviders(provider),
+		WithOidcLanding("http://localhost:8001/"),
+	)
+	assert.Nil(t, err)
+	return authenticator
+}
+
+func TestOidcLoginCreatesUser(t *testing.T) {
+	idp := newMockOidcIdp(t, jwt.MapClaims{
+		"sub":            "subject",
+		"email":          "some@mail.ru",
+		"email_verified": true,
+		"name":           "name",
+	})
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		GetIdByIdentity(mock.Anything, "mock", "subject").
+		Return("", users.ErrNotFound)
+	uMock.EXPECT().
+		GetIdByEmail(mock.Anything, "some@mail.ru").
+		Return("", users.ErrNotFound)
+
+	var userId string
+	uMock.EXPECT().
+		CreateWithIdentity(
+			mock.Anything,
+			mock.MatchedBy(func(u *responses.Authenticator) bool {
+				userId = u.Id
+				return u.Email == "some@mail.ru" && u.Name == "name"
+			}),
+			"mock",
+			"subject",
+		).
+		Return(nil)
+	uMock.EXPECT().
+		GetTotp(context.TODO(), mock.AnythingOfType("string")).
+		Return(users.Totp{}, nil)
+
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		IssueToken(context.TODO(), mock.AnythingOfType("*blackbox.IssueTokenReq")).
+		Return(&blackbox.IssueTokenRsp{
+			Token: "session-token",
+		}, nil)
+
+	authenticator := newOidcAuthenticator(t, idp, uMock, cMock, NewMockAuditLog(t))
+
+	rr := httptest.NewRecorder()
+	authenticator.OidcCallback(rr, idp.authorize(authenticator))
+
+	rsp := rr.Result()
+	assert.Equal(t, http.StatusFound, rsp.StatusCode)
+	assert.Equal(t, "http://localhost:8001/", rsp.Header.Get("Location"))
+	assert.NotEmpty(t, userId)
+
+	var jwtCookie string
+	for _, c := range rsp.Cookies() {
+		if c.Name == "JWT" {
+			jwtCookie = c.Value
+		}
+	}
+	assert.Equal(t, "session-token", jwtCookie)
+}
+
+func TestOidcLoginRequiresSecondFactor(t *testing.T) {
+	idp := newMockOidcIdp(t, jwt.MapClaims{
+		"sub":            "subject",
+		"email":          "some@mail.ru",
+		"email_verified": true,
+	})
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		GetIdByIdentity(mock.Anything, "mock", "subject").
+		Return("id", nil)
+	uMock.EXPECT().
+		GetTotp(context.TODO(), "id").
+		Return(users.Totp{Secret: "secret", Enabled: true}, nil)
+
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		IssueToken(context.TODO(), &blackbox.IssueTokenReq{
+			UserId: "id",
+			Scope:  blackbox.Scope_SCOPE_MFA_PENDING,
+		}).
+		Return(&blackbox.IssueTokenRsp{Token: "mfa-token"}, nil)
+
+	authenticator := newOidcAuthenticator(t, idp, uMock, cMock, NewMockAuditLog(t))
+
+	rr := httptest.NewRecorder()
+	authenticator.OidcCallback(rr, idp.authorize(authenticator))
+
+	rsp := rr.Result()
+	assert.Equal(t, http.StatusFound, rsp.StatusCode)
+	// the token isn't sent to servers in the query
+	assert.Equal(t, "http://localhost:8001/#mfa_token=mfa-token", rsp.Header.Get("Location"))
+	for _, c := range rsp.Cookies() {
+		assert.NotEqual(t, "JWT", c.Name)
+		assert.NotEqual(t, "auth", c.Name)
+	}
+}
+
+func TestOidcLoginLinksVerifiedEmail(t *testing.T) {
+	idp := newMockOidcIdp(t, jwt.MapClaims{
+		"sub":            "subject",
+		"email":          "some@mail.ru",
+		"email_verified": true,
+	})
+
+	userId := "id"
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		GetIdByIdentity(mock.Anything, "mock", "subject").
+		Return("", users.ErrNotFound)
+	uMock.EXPECT().
+		GetIdByEmail(mock.Anything, "some@mail.ru").
+		Return(userId, nil)
+	uMock.EXPECT().
+		LinkIdentity(mock.Anything, userId, "mock", "subject").
+		Return(nil)
+	uMock.EXPECT().
+		GetTotp(context.TODO(), userId).
+		Return(users.Totp{}, nil)
+
+	auditMock := NewMockAuditLog(t)
+	auditMock.EXPECT().
+		Record(
+			mock.Anything,
+			audit.EventIdentityLinked,
+			"some@mail.ru",
+			mock.AnythingOfType("string"),
+			mock.AnythingOfType("string"),
+		).
+		Return(nil)
+
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		IssueToken(context.TODO(), &blackbox.IssueTokenReq{UserId: userId}).
+		Return(&blackbox.IssueTokenRsp{
+			Token: "session-token",
+		}, nil)
+
+	authenticator := newOidcAuthenticator(t, idp, uMock, cMock, auditMock)
+
+	rr := httptest.NewRecorder()
+	authenticator.OidcCallback(rr, idp.authorize(authenticator))
+
+	assert.Equal(t, http.StatusFound, rr.Result().StatusCode)
+}
+
+func TestOidcLoginUnverifiedEmail(t *testing.T) {
+	idp := newMockOidcIdp(t, jwt.MapClaims{
+		"sub":            "subject",
+		"email":          "some@mail.ru",
+		"email_verified": false,
+	})
+
+	uMock := NewMockUsers(t)
+	uMock.EXPECT().
+		GetIdByIdentity(mock.Anything, "mock", "subject").
+		Return("", users.ErrNotFound)
+
+	authenticator := newOidcAuthenticator(
+		t,
+		idp,
+		uMock,
+		pbblackbox_mocks.NewMockBlackboxServiceClient(t),
+		NewMockAuditLog(t),
+	)
+
+	rr := httptest.NewRecorder()
+	authenticator.OidcCallback(rr, idp.authorize(authenticator))
+
+	assert.Equal(t, http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func TestOidcCallbackWrongState(t *testing.T) {
+	idp := newMockOidcIdp(t, jwt.MapClaims{"sub": "subject"})
+
+	authenticator := newOidcAuthenticator(
+		t,
+		idp,
+		NewMockUsers(t),
+		pbblackbox_mocks.NewMockBlackboxServiceClient(t),
+		NewMockAuditLog(t),
+	)
+
+	callback := idp.authorize(authenticator)
+	q := callback.URL.Query()
+	q.Set("state", "forged")
+	callback.URL.RawQuery = q.Encode()
+
+	rr := httptest.NewRecorder()
+	authenticator.OidcCallback(rr, callback)
+
+	assert.Equal(t, http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func TestOidcCallbackWrongVerifier(t *testing.T) {
+	idp := newMockOidcIdp(t, jwt.MapClaims{"sub": "subject"})
+
+	authenticator := newOidcAuthenticator(
+		t,
+		idp,
+		NewMockUsers(t),
+		pbblackbox_mocks.NewMockBlackboxServiceClient(t),
+		NewMockAuditLog(t),
+	)
+
+	callback := idp.authorize(authenticator)
+	// as if the code has been intercepted by another client
+	idp.challenge = "intercepted"
+
+	rr := httptest.NewRecorder()
+	authenticator.OidcCallback(rr, callback)
+
+	assert.Equal(t, http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func newWorkspacesAuthenticator(
+	t *testing.T,
+	cMock *pbblackbox_mocks.MockBlackboxServiceClient,
+	wsMock *MockWorkspaces,
+) *Authentitor {
+	cMock.EXPECT().
+		ValidateToken(context.TODO(), &blackbox.ValidateTokenReq{Token: "token"}).
+		Return(&blackbox.ValidateTokenRsp{UserId: workspaceUserId}, nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", bus_mocks.NewMockPublisher(t)),
+		WithUsersDB(NewMockUsers(t)),
+		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(NewMockAttempts(t)),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(wsMock),
+	)
+	assert.Nil(t, err)
+	return authenticator
+}
+
+const (
+	workspaceId     = "5f0cbe2c-2b4b-4a43-a4a5-5b3d43b1c1a0"
+	workspaceUserId = "9a3c7e45-0d8e-4b55-9d0e-8e4f5a6b7c8d"
+	memberId        = "0b1e9c2d-3f4a-4b5c-8d6e-7f8a9b0c1d2e"
+)
+
+func workspaceRequest(method string, target string, body any) *http.Request {
+	req, _ := json.Marshal(body)
+	request := httptest.NewRequest(method, target, bytes.NewReader(req))
+	request.AddCookie(&http.Cookie{Name: "JWT", Value: "token"})
+	return request
+}
+
+func TestSwitchWorkspace(t *testing.T) {
+	wsMock := NewMockWorkspaces(t)
+	wsMock.EXPECT().
+		Role(context.TODO(), workspaceId, workspaceUserId).
+		Return(domain.RoleEditor, nil)
+
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		IssueToken(context.TODO(), &blackbox.IssueTokenReq{
+			UserId:      workspaceUserId,
+			WorkspaceId: workspaceId,
+			Role:        string(domain.RoleEditor),
+		}).
+		Return(&blackbox.IssueTokenRsp{Token: "workspace-token"}, nil)
+
+	authenticator := newWorkspacesAuthenticator(t, cMock, wsMock)
+
+	rr := httptest.NewRecorder()
+	authenticator.SwitchWorkspace(rr, workspaceRequest(
+		"POST",
+		"/workspaces/switch",
+		&switchWorkspaceRequest{WorkspaceId: workspaceId},
+	))
+
+	rsp := rr.Result()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	var jwtCookie string
+	for _, c := range rsp.Cookies() {
+		if c.Name == "JWT" {
+			jwtCookie = c.Value
+		}
+	}
+	assert.Equal(t, "workspace-token", jwtCookie)
+}
+
+func TestSwitchWorkspaceNotMember(t *testing.T) {
+	wsMock := NewMockWorkspaces(t)
+	wsMock.EXPECT().
+		Role(context.TODO(), workspaceId, workspaceUserId).
+		Return("", workspaces.ErrNotMember)
+
+	authenticator := newWorkspacesAuthenticator(
+		t,
+		pbblackbox_mocks.NewMockBlackboxServiceClient(t),
+		wsMock,
+	)
+
+	rr := httptest.NewRecorder()
+	authenticator.SwitchWorkspace(rr, workspaceRequest(
+		"POST",
+		"/workspaces/switch",
+		&switchWorkspaceRequest{WorkspaceId: workspaceId},
+	))
+
+	assert.Equal(t, http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func TestInviteMemberEditorForbidden(t *testing.T) {
+	wsMock := NewMockWorkspaces(t)
+	wsMock.EXPECT().
+		Role(context.TODO(), workspaceId, workspaceUserId).
+		Return(domain.RoleEditor, nil)
+
+	authenticator := newWorkspacesAuthenticator(
+		t,
+		pbblackbox_mocks.NewMockBlackboxServiceClient(t),
+		wsMock,
+	)
+
+	request := workspaceRequest(
+		"POST",
+		"/workspaces/"+workspaceId+"/invites",
+		&inviteMemberRequest{Email: "some@mail.ru", Role: "viewer"},
+	)
+	request.SetPathValue("workspace_id", workspaceId)
+
+	rr := httptest.NewRecorder()
+	authenticator.InviteMember(rr, request)
+
+	assert.Equal(t, http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func TestInviteMemberAdminCantInviteOwner(t *testing.T) {
+	wsMock := NewMockWorkspaces(t)
+	wsMock.EXPECT().
+		Role(context.TODO(), workspaceId, workspaceUserId).
+		Return(domain.RoleAdmin, nil)
+
+	authenticator := newWorkspacesAuthenticator(
+		t,
+		pbblackbox_mocks.NewMockBlackboxServiceClient(t),
+		wsMock,
+	)
+
+	request := workspaceRequest(
+		"POST",
+		"/workspaces/"+workspaceId+"/invites",
+		&inviteMemberRequest{Email: "some@mail.ru", Role: "owner"},
+	)
+	request.SetPathValue("workspace_id", workspaceId)
+
+	rr := httptest.NewRecorder()
+	authenticator.InviteMember(rr, request)
+
+	assert.Equal(t, http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func TestRemoveMemberRevokesTokens(t *testing.T) {
+	wsMock := NewMockWorkspaces(t)
+	wsMock.EXPECT().
+		Role(context.TODO(), workspaceId, workspaceUserId).
+		Return(domain.RoleAdmin, nil)
+	wsMock.EXPECT().
+		Role(context.TODO(), workspaceId, memberId).
+		Return(domain.RoleEditor, nil)
+	wsMock.EXPECT().
+		RemoveMember(context.TODO(), workspaceId, memberId).
+		Return(nil)
+
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		RevokeTokens(context.TODO(), &blackbox.RevokeTokensReq{UserId: memberId}).
+		Return(&blackbox.RevokeTokensRsp{}, nil)
+
+	authenticator := newWorkspacesAuthenticator(t, cMock, wsMock)
+
+	request := workspaceRequest(
+		"DELETE",
+		"/workspaces/"+workspaceId+"/members/"+memberId,
+		nil,
+	)
+	request.SetPathValue("workspace_id", workspaceId)
+	request.SetPathValue("user_id", memberId)
+
+	rr := httptest.NewRecorder()
+	authenticator.RemoveMember(rr, request)
+
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+}
+
+func TestRemoveMemberAdminCantRemoveOwner(t *testing.T) {
+	wsMock := NewMockWorkspaces(t)
+	wsMock.EXPECT().
+		Role(context.TODO(), workspaceId, workspaceUserId).
+		Return(domain.RoleAdmin, nil)
+	wsMock.EXPECT().
+		Role(context.TODO(), workspaceId, memberId).
+		Return(domain.RoleOwner, nil)
+
+	authenticator := newWorkspacesAuthenticator(
+		t,
+		pbblackbox_mocks.NewMockBlackboxServiceClient(t),
+		wsMock,
+	)
+
+	request := workspaceRequest(
+		"DELETE",
+		"/workspaces/"+workspaceId+"/members/"+memberId,
+		nil,
+	)
+	request.SetPathValue("workspace_id", workspaceId)
+	request.SetPathValue("user_id", memberId)
+
+	rr := httptest.NewRecorder()
+	authenticator.RemoveMember(rr, request)
+
+	assert.Equal(t, http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func TestLeaveWorkspaceAsLastOwner(t *testing.T) {
+	wsMock := NewMockWorkspaces(t)
+	wsMock.EXPECT().
+		RemoveMember(context.TODO(), workspaceId, workspaceUserId).
+		Return(workspaces.ErrLastOwner)
+
+	authenticator := newWorkspacesAuthenticator(
+		t,
+		pbblackbox_mocks.NewMockBlackboxServiceClient(t),
+		wsMock,
+	)
+
+	request := workspaceRequest(
+		"DELETE",
+		"/workspaces/"+workspaceId+"/members/"+workspaceUserId,
+		nil,
+	)
+	request.SetPathValue("workspace_id", workspaceId)
+	request.SetPathValue("user_id", workspaceUserId)
+
+	rr := httptest.NewRecorder()
+	authenticator.RemoveMember(rr, request)
+
+	assert.Equal(t, http.StatusConflict, rr.Result().StatusCode)
+}
+
+func newPreferencesAuthenticator(
+	t *testing.T,
+	pMock *MockNotificationPreferences,
+) *Authentitor {
+	cMock := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	cMock.EXPECT().
+		ValidateToken(context.TODO(), &blackbox.ValidateTokenReq{Token: "token"}).
+		Return(&blackbox.ValidateTokenRsp{UserId: workspaceUserId}, nil)
+
+	authenticator, err := New(
+		WithPublisher("topic", bus_mocks.NewMockPublisher(t)),
+		WithUsersDB(NewMockUsers(t)),
+		WithBlackboxClient(cMock),
+		WithMailer(NewMockMailer(t)),
+		WithAttempts(NewMockAttempts(t)),
+		WithAuditLog(NewMockAuditLog(t)),
+		WithWorkspaces(NewMockWorkspaces(t)),
+		WithNotificationPreferences(pMock, []int{7, 1}),
+	)
+	assert.Nil(t, err)
+	return authenticator
+}
+
+func TestGetNotificationPreferencesDefaults(t *testing.T) {
+	pMock := NewMockNotificationPreferences(t)
+	pMock.EXPECT().
+		Preferences(context.TODO(), workspaceUserId).
+		Return(nil, reminders.ErrNoPreferences)
+
+	authenticator := newPreferencesAuthenticator(t, pMock)
+
+	rr := httptest.NewRecorder()
+	authenticator.GetNotificationPreferences(rr, workspaceRequest(
+		"GET",
+		"/notifications/preferences",
+		nil,
+	))
+
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	var p domain.NotificationPreferences
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&p))
+	assert.Equal(t, domain.NotificationPreferences{
+		Enabled:      true,
+		Channel:      domain.ChannelEmail,
+		ReminderDays: []int{7, 1},
+	}, p)
+}
+
+func TestSetNotificationPreferences(t *testing.T) {
+	pMock := NewMockNotificationPreferences(t)
+	pMock.EXPECT().
+		SetPreferences(context.TODO(), workspaceUserId, &domain.NotificationPreferences{
+			Enabled:      true,
+			Channel:      domain.ChannelWebhook,
+			WebhookUrl:   "https://example.com/hook",
+			ReminderDays: []int{3},
+		}).
+		Return(nil)
+
+	authenticator := newPreferencesAuthenticator(t, pMock)
+
+	rr := httptest.NewRecorder()
+	authenticator.SetNotificationPreferences(rr, workspaceRequest(
+		"PUT",
+		"/notifications/preferences",
+		&notificationPreferencesRequest{
+			Enabled:      true,
+			Channel:      "webhook",
+			WebhookUrl:   "https://example.com/hook",
+			ReminderDays: []int{3},
+		},
+	))
+
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+}
+
+func TestSetNotificationPreferencesInvalid(t *testing.T) {
+	for _, data := range []struct {
+		Name string
+		Form notificationPreferencesRequest
+	}{
+		{
+			Name: "unknown channel",
+			Form: notificationPreferencesRequest{Channel: "sms", ReminderDays: []int{1}},
+		},
+		{
+			Name: "webhook without url",
+			Form: notificationPreferencesRequest{Channel: "webhook", ReminderDays: []int{1}},
+		},
+		{
+			Name: "webhook url not http",
+			Form: notificationPreferencesRequest{
+				Channel:      "webhook",
+				WebhookUrl:   "ftp://example.com",
+				ReminderDays: []int{1},
+			},
+		},
+		{
+			Name: "webhook url of internal host",
+			Form: notificationPreferencesRequest{
+				Channel:      "webhook",
+				WebhookUrl:   "http://10.0.0.5:9100/metrics",
+				ReminderDays: []int{1},
+			},
+		},
+		{
+			Name: "no reminder days",
+			Form: notificationPreferencesRequest{Channel: "email"},
+		},
+		{
+			Name: "reminder day not positive",
+			Form: notificationPreferencesRequest{Channel: "email", ReminderDays: []int{0}},
+		},
+	} {
+		t.Run(data.Name, func(t *testing.T) {
+			authenticator := newPreferencesAuthenticator(t, NewMockNotificationPreferences(t))
+
+			rr := httptest.NewRecorder()
+			authenticator.SetNotificationPreferences(rr, workspaceRequest(
+				"PUT",
+				"/notifications/preferences",
+				&data.Form,
+			))
+
+			assert.Equal(t, http.StatusBadRequest, rr.Result().StatusCode)
+		})
+	}
+}
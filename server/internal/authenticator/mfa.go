@@ -0,0 +1,564 @@
+package authenticator
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"shortener/pkg/models/users"
+	"shortener/pkg/responses"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/rs/zerolog/hlog"
+
+	pbblackbox "shortener/proto/blackbox"
+)
+
+const (
+	totpIssuer = "Link Shortener"
+	totpPeriod = 30
+	// number of neighbouring time steps accepted to tolerate clock drift
+	totpSkew = 1
+
+	recoveryCodesCount = 10
+)
+
+// verifyTotp returns the time step the code has been generated for
+func verifyTotp(secret string, code string, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totp.GenerateCodeCustom(
+			secret,
+			time.Unix(step*totpPeriod, 0),
+			totp.ValidateOpts{
+				Period:    totpPeriod,
+				Digits:    otp.DigitsSix,
+				Algorithm: otp.AlgorithmSHA1,
+			},
+		)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodesCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+	return codes, nil
+}
+
+// checkTotp verifies the code against the enabled secret of the user and
+// makes sure that it hasn't been used before
+func (a *Authentitor) checkTotp(
+	ctx context.Context,
+	userId string,
+	code string,
+) (bool, error) {
+	totpInfo, err := a.users.GetTotp(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	if !totpInfo.Enabled {
+		return false, nil
+	}
+
+	step, ok := verifyTotp(totpInfo.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return a.users.UseTotpStep(ctx, userId, step)
+}
+
+func mfaAttemptsSubject(userId string) string {
+	return "mfa:" + userId
+}
+
+// requireSecondFactor answers a login request with a short-lived token that
+// has to be exchanged for a session one at /login/mfa
+func (a *Authentitor) requireSecondFactor(
+	w http.ResponseWriter,
+	r *http.Request,
+	userId string,
+) {
+	log := hlog.FromRequest(r).With().Str("user_id", userId).Logger()
+
+	log.Info().Msg("issuing mfa pending token")
+	mfaToken, err := a.blackboxClient.IssueToken(
+		context.TODO(),
+		&pbblackbox.IssueTokenReq{
+			UserId: userId,
+			Scope:  pbblackbox.Scope_SCOPE_MFA_PENDING,
+		},
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't issue mfa pending token")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't issue user token",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	pkg, _ := json.Marshal(&responses.MfaRequired{
+		Message:  "mfa required",
+		MfaToken: mfaToken.GetToken(),
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+}
+
+func (a *Authentitor) LoginMfa(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got second factor login request")
+	d := json.NewDecoder(r.Body)
+	var form loginMfaRequest
+	if err := d.Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't parse second factor login request")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "Couldn't parse login request",
+		})
+
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(pkg)
+		return
+	}
+
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid second factor login form")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid login form",
+		})
+
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(pkg)
+		return
+	}
+
+	log.Info().Msg("validating mfa pending token")
+	tokenInfo, err := a.blackboxClient.ValidateToken(
+		context.TODO(),
+		&pbblackbox.ValidateTokenReq{
+			Token: form.MfaToken,
+			Scope: pbblackbox.Scope_SCOPE_MFA_PENDING,
+		},
+	)
+	if err != nil {
+		writeValidationError(w, log, err, "invalid or expired mfa token")
+		return
+	}
+	userId := tokenInfo.GetUserId()
+
+	tmp := log.With().Str("user_id", userId).Logger()
+	log = &tmp
+
+	subject := mfaAttemptsSubject(userId)
+	ip := clientIp(r)
+	lockedFor, err := a.attempts.LockedFor(context.TODO(), subject, ip)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't check login lockout")
+	} else if lockedFor > 0 {
+		log.Info().Dur("locked_for", lockedFor).Msg("mfa attempt during lockout")
+
+		writeTooManyAttempts(w, lockedFor)
+		return
+	}
+
+	var ok bool
+	if form.Code != "" {
+		log.Info().Msg("checking TOTP code")
+		ok, err = a.checkTotp(context.TODO(), userId, form.Code)
+	} else {
+		log.Info().Msg("checking recovery code")
+		ok, err = a.users.ConsumeRecoveryCode(
+			context.TODO(),
+			userId,
+			form.RecoveryCode,
+		)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't check second factor")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't check credentials",
+		})
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+	if !ok {
+		log.Info().Msg("wrong second factor")
+		a.registerFailure(log, subject, ip)
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "wrong code",
+		})
+
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(pkg)
+		return
+	}
+
+	if err := a.attempts.Succeed(context.TODO(), subject); err != nil {
+		log.Error().Err(err).Msg("couldn't reset failed mfa attempts")
+	}
+
+	log.Info().Msg("Issuing JWT")
+	signedToken, err := a.blackboxClient.IssueToken(
+		context.TODO(),
+		&pbblackbox.IssueTokenReq{
+			UserId: userId,
+		},
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't sign token on mfa login")
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't issue user token",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	setSessionCookies(w, signedToken.GetToken())
+	w.WriteHeader(http.StatusOK)
+
+	pkg, _ := json.Marshal(&responses.Server{
+		Message: "success",
+	})
+	w.Write(pkg)
+
+	log.Info().Msg("successful mfa login")
+}
+
+func (a *Authentitor) EnrollMfa(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got mfa enrollment request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	tmp := log.With().Str("user_id", userId).Logger()
+	log = &tmp
+
+	email, err := a.users.GetEmail(context.TODO(), userId)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't get email of the user")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't start enrollment",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: email,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't generate TOTP secret")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't start enrollment",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	log.Info().Msg("saving pending TOTP secret")
+	err = a.users.SetPendingTotpSecret(context.TODO(), userId, key.Secret())
+	if err != nil {
+		if errors.Is(err, users.ErrTotpEnabled) {
+			log.Info().Msg("two-factor authentication is already enabled")
+
+			pkg, _ := json.Marshal(&responses.Server{
+				Message: "two-factor authentication is already enabled",
+			})
+			w.WriteHeader(http.StatusConflict)
+			w.Write(pkg)
+			return
+		}
+
+		log.Error().Err(err).Msg("couldn't save pending TOTP secret")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't start enrollment",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	pkg, _ := json.Marshal(&responses.MfaEnrollment{
+		Secret:     key.Secret(),
+		OtpauthUri: key.URL(),
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+}
+
+func (a *Authentitor) ConfirmMfa(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got mfa confirmation request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	tmp := log.With().Str("user_id", userId).Logger()
+	log = &tmp
+
+	d := json.NewDecoder(r.Body)
+	var form totpCodeRequest
+	if err := d.Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't parse mfa confirmation request")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "Couldn't parse mfa confirmation request",
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(pkg)
+		return
+	}
+
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid mfa confirmation form")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid mfa confirmation form",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(pkg)
+		return
+	}
+
+	subject := mfaAttemptsSubject(userId)
+	ip := clientIp(r)
+	lockedFor, err := a.attempts.LockedFor(context.TODO(), subject, ip)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't check mfa lockout")
+	} else if lockedFor > 0 {
+		writeTooManyAttempts(w, lockedFor)
+		return
+	}
+
+	totpInfo, err := a.users.GetTotp(context.TODO(), userId)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't get two-factor settings")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't confirm enrollment",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+	if totpInfo.Enabled {
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "two-factor authentication is already enabled",
+		})
+		w.WriteHeader(http.StatusConflict)
+		w.Write(pkg)
+		return
+	}
+	if totpInfo.Secret == "" {
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "enrollment hasn't been started",
+		})
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write(pkg)
+		return
+	}
+
+	step, ok := verifyTotp(totpInfo.Secret, form.Code, time.Now())
+	if ok {
+		// a code is accepted once, even if it's still valid
+		ok, err = a.users.UseTotpStep(context.TODO(), userId, step)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't use TOTP step")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't confirm enrollment",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+	if !ok {
+		log.Info().Msg("wrong confirmation code")
+		a.registerFailure(log, subject, ip)
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "wrong code",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(pkg)
+		return
+	}
+
+	if err := a.attempts.Succeed(context.TODO(), subject); err != nil {
+		log.Error().Err(err).Msg("couldn't reset failed mfa attempts")
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't generate recovery codes")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't confirm enrollment",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	log.Info().Msg("enabling two-factor authentication")
+	if err := a.users.EnableTotp(context.TODO(), userId, codes); err != nil {
+		if errors.Is(err, users.ErrTotpEnabled) {
+			pkg, _ := json.Marshal(&responses.Server{
+				Message: "two-factor authentication is already enabled",
+			})
+			w.WriteHeader(http.StatusConflict)
+			w.Write(pkg)
+			return
+		}
+
+		log.Error().Err(err).Msg("couldn't enable two-factor authentication")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't confirm enrollment",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	pkg, _ := json.Marshal(&responses.RecoveryCodes{
+		RecoveryCodes: codes,
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+	log.Info().Msg("two-factor authentication has been enabled")
+}
+
+func (a *Authentitor) DisableMfa(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got mfa disabling request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	tmp := log.With().Str("user_id", userId).Logger()
+	log = &tmp
+
+	d := json.NewDecoder(r.Body)
+	var form totpCodeRequest
+	if err := d.Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't parse mfa disabling request")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "Couldn't parse mfa disabling request",
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(pkg)
+		return
+	}
+
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid mfa disabling form")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid mfa disabling form",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(pkg)
+		return
+	}
+
+	subject := mfaAttemptsSubject(userId)
+	ip := clientIp(r)
+	lockedFor, err := a.attempts.LockedFor(context.TODO(), subject, ip)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't check mfa lockout")
+	} else if lockedFor > 0 {
+		writeTooManyAttempts(w, lockedFor)
+		return
+	}
+
+	ok, err = a.checkTotp(context.TODO(), userId, form.Code)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't check TOTP code")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't disable two-factor authentication",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+	if !ok {
+		log.Info().Msg("wrong TOTP code")
+		a.registerFailure(log, subject, ip)
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "wrong code",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(pkg)
+		return
+	}
+
+	log.Info().Msg("disabling two-factor authentication")
+	if err := a.users.DisableTotp(context.TODO(), userId); err != nil {
+		log.Error().Err(err).Msg("couldn't disable two-factor authentication")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't disable two-factor authentication",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	pkg, _ := json.Marshal(&responses.Server{
+		Message: "success",
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+	log.Info().Msg("two-factor authentication has been disabled")
+}
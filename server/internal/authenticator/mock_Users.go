@@ -4,6 +4,9 @@ package authenticator
 
 import (
 	context "context"
+	users "shortener/pkg/models/users"
+	responses "shortener/pkg/responses"
+	time "time"
 
 	mock "github.com/stretchr/testify/mock"
 )
@@ -136,6 +139,795 @@ func (_c *MockUsers_CheckExistence_Call) RunAndReturn(run func(context.Context,
 	return _c
 }
 
+// CheckPassword provides a mock function with given fields: ctx, userId, password
+func (_m *MockUsers) CheckPassword(ctx context.Context, userId string, password string) error {
+	ret := _m.Called(ctx, userId, password)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CheckPassword")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, userId, password)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockUsers_CheckPassword_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CheckPassword'
+type MockUsers_CheckPassword_Call struct {
+	*mock.Call
+}
+
+// CheckPassword is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+//   - password string
+func (_e *MockUsers_Expecter) CheckPassword(ctx interface{}, userId interface{}, password interface{}) *MockUsers_CheckPassword_Call {
+	return &MockUsers_CheckPassword_Call{Call: _e.mock.On("CheckPassword", ctx, userId, password)}
+}
+
+func (_c *MockUsers_CheckPassword_Call) Run(run func(ctx context.Context, userId string, password string)) *MockUsers_CheckPassword_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *MockUsers_CheckPassword_Call) Return(_a0 error) *MockUsers_CheckPassword_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockUsers_CheckPassword_Call) RunAndReturn(run func(context.Context, string, string) error) *MockUsers_CheckPassword_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ConsumeRecoveryCode provides a mock function with given fields: ctx, userId, code
+func (_m *MockUsers) ConsumeRecoveryCode(ctx context.Context, userId string, code string) (bool, error) {
+	ret := _m.Called(ctx, userId, code)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ConsumeRecoveryCode")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (bool, error)); ok {
+		return rf(ctx, userId, code)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) bool); ok {
+		r0 = rf(ctx, userId, code)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, userId, code)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockUsers_ConsumeRecoveryCode_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ConsumeRecoveryCode'
+type MockUsers_ConsumeRecoveryCode_Call struct {
+	*mock.Call
+}
+
+// ConsumeRecoveryCode is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+//   - code string
+func (_e *MockUsers_Expecter) ConsumeRecoveryCode(ctx interface{}, userId interface{}, code interface{}) *MockUsers_ConsumeRecoveryCode_Call {
+	return &MockUsers_ConsumeRecoveryCode_Call{Call: _e.mock.On("ConsumeRecoveryCode", ctx, userId, code)}
+}
+
+func (_c *MockUsers_ConsumeRecoveryCode_Call) Run(run func(ctx context.Context, userId string, code string)) *MockUsers_ConsumeRecoveryCode_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *MockUsers_ConsumeRecoveryCode_Call) Return(_a0 bool, _a1 error) *MockUsers_ConsumeRecoveryCode_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockUsers_ConsumeRecoveryCode_Call) RunAndReturn(run func(context.Context, string, string) (bool, error)) *MockUsers_ConsumeRecoveryCode_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// CreateResetToken provides a mock function with given fields: ctx, userId, token, ttl
+func (_m *MockUsers) CreateResetToken(ctx context.Context, userId string, token string, ttl time.Duration) error {
+	ret := _m.Called(ctx, userId, token, ttl)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateResetToken")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Duration) error); ok {
+		r0 = rf(ctx, userId, token, ttl)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockUsers_CreateResetToken_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CreateResetToken'
+type MockUsers_CreateResetToken_Call struct {
+	*mock.Call
+}
+
+// CreateResetToken is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+//   - token string
+//   - ttl time.Duration
+func (_e *MockUsers_Expecter) CreateResetToken(ctx interface{}, userId interface{}, token interface{}, ttl interface{}) *MockUsers_CreateResetToken_Call {
+	return &MockUsers_CreateResetToken_Call{Call: _e.mock.On("CreateResetToken", ctx, userId, token, ttl)}
+}
+
+func (_c *MockUsers_CreateResetToken_Call) Run(run func(ctx context.Context, userId string, token string, ttl time.Duration)) *MockUsers_CreateResetToken_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string), args[3].(time.Duration))
+	})
+	return _c
+}
+
+func (_c *MockUsers_CreateResetToken_Call) Return(_a0 error) *MockUsers_CreateResetToken_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockUsers_CreateResetToken_Call) RunAndReturn(run func(context.Context, string, string, time.Duration) error) *MockUsers_CreateResetToken_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// CreateWithIdentity provides a mock function with given fields: ctx, user, provider, subject
+func (_m *MockUsers) CreateWithIdentity(ctx context.Context, user *responses.Authenticator, provider string, subject string) error {
+	ret := _m.Called(ctx, user, provider, subject)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateWithIdentity")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *responses.Authenticator, string, string) error); ok {
+		r0 = rf(ctx, user, provider, subject)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockUsers_CreateWithIdentity_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CreateWithIdentity'
+type MockUsers_CreateWithIdentity_Call struct {
+	*mock.Call
+}
+
+// CreateWithIdentity is a helper method to define mock.On call
+//   - ctx context.Context
+//   - user *responses.Authenticator
+//   - provider string
+//   - subject string
+func (_e *MockUsers_Expecter) CreateWithIdentity(ctx interface{}, user interface{}, provider interface{}, subject interface{}) *MockUsers_CreateWithIdentity_Call {
+	return &MockUsers_CreateWithIdentity_Call{Call: _e.mock.On("CreateWithIdentity", ctx, user, provider, subject)}
+}
+
+func (_c *MockUsers_CreateWithIdentity_Call) Run(run func(ctx context.Context, user *responses.Authenticator, provider string, subject string)) *MockUsers_CreateWithIdentity_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(*responses.Authenticator), args[2].(string), args[3].(string))
+	})
+	return _c
+}
+
+func (_c *MockUsers_CreateWithIdentity_Call) Return(_a0 error) *MockUsers_CreateWithIdentity_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockUsers_CreateWithIdentity_Call) RunAndReturn(run func(context.Context, *responses.Authenticator, string, string) error) *MockUsers_CreateWithIdentity_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// DisableTotp provides a mock function with given fields: ctx, userId
+func (_m *MockUsers) DisableTotp(ctx context.Context, userId string) error {
+	ret := _m.Called(ctx, userId)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DisableTotp")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, userId)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockUsers_DisableTotp_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'DisableTotp'
+type MockUsers_DisableTotp_Call struct {
+	*mock.Call
+}
+
+// DisableTotp is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+func (_e *MockUsers_Expecter) DisableTotp(ctx interface{}, userId interface{}) *MockUsers_DisableTotp_Call {
+	return &MockUsers_DisableTotp_Call{Call: _e.mock.On("DisableTotp", ctx, userId)}
+}
+
+func (_c *MockUsers_DisableTotp_Call) Run(run func(ctx context.Context, userId string)) *MockUsers_DisableTotp_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockUsers_DisableTotp_Call) Return(_a0 error) *MockUsers_DisableTotp_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockUsers_DisableTotp_Call) RunAndReturn(run func(context.Context, string) error) *MockUsers_DisableTotp_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// EnableTotp provides a mock function with given fields: ctx, userId, recoveryCodes
+func (_m *MockUsers) EnableTotp(ctx context.Context, userId string, recoveryCodes []string) error {
+	ret := _m.Called(ctx, userId, recoveryCodes)
+
+	if len(ret) == 0 {
+		panic("no return value specified for EnableTotp")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string) error); ok {
+		r0 = rf(ctx, userId, recoveryCodes)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockUsers_EnableTotp_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'EnableTotp'
+type MockUsers_EnableTotp_Call struct {
+	*mock.Call
+}
+
+// EnableTotp is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+//   - recoveryCodes []string
+func (_e *MockUsers_Expecter) EnableTotp(ctx interface{}, userId interface{}, recoveryCodes interface{}) *MockUsers_EnableTotp_Call {
+	return &MockUsers_EnableTotp_Call{Call: _e.mock.On("EnableTotp", ctx, userId, recoveryCodes)}
+}
+
+func (_c *MockUsers_EnableTotp_Call) Run(run func(ctx context.Context, userId string, recoveryCodes []string)) *MockUsers_EnableTotp_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].([]string))
+	})
+	return _c
+}
+
+func (_c *MockUsers_EnableTotp_Call) Return(_a0 error) *MockUsers_EnableTotp_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockUsers_EnableTotp_Call) RunAndReturn(run func(context.Context, string, []string) error) *MockUsers_EnableTotp_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// GetEmail provides a mock function with given fields: ctx, userId
+func (_m *MockUsers) GetEmail(ctx context.Context, userId string) (string, error) {
+	ret := _m.Called(ctx, userId)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetEmail")
+	}
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (string, error)); ok {
+		return rf(ctx, userId)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, userId)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, userId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockUsers_GetEmail_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetEmail'
+type MockUsers_GetEmail_Call struct {
+	*mock.Call
+}
+
+// GetEmail is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+func (_e *MockUsers_Expecter) GetEmail(ctx interface{}, userId interface{}) *MockUsers_GetEmail_Call {
+	return &MockUsers_GetEmail_Call{Call: _e.mock.On("GetEmail", ctx, userId)}
+}
+
+func (_c *MockUsers_GetEmail_Call) Run(run func(ctx context.Context, userId string)) *MockUsers_GetEmail_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockUsers_GetEmail_Call) Return(_a0 string, _a1 error) *MockUsers_GetEmail_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockUsers_GetEmail_Call) RunAndReturn(run func(context.Context, string) (string, error)) *MockUsers_GetEmail_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// GetIdByEmail provides a mock function with given fields: ctx, email
+func (_m *MockUsers) GetIdByEmail(ctx context.Context, email string) (string, error) {
+	ret := _m.Called(ctx, email)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetIdByEmail")
+	}
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (string, error)); ok {
+		return rf(ctx, email)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, email)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, email)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockUsers_GetIdByEmail_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetIdByEmail'
+type MockUsers_GetIdByEmail_Call struct {
+	*mock.Call
+}
+
+// GetIdByEmail is a helper method to define mock.On call
+//   - ctx context.Context
+//   - email string
+func (_e *MockUsers_Expecter) GetIdByEmail(ctx interface{}, email interface{}) *MockUsers_GetIdByEmail_Call {
+	return &MockUsers_GetIdByEmail_Call{Call: _e.mock.On("GetIdByEmail", ctx, email)}
+}
+
+func (_c *MockUsers_GetIdByEmail_Call) Run(run func(ctx context.Context, email string)) *MockUsers_GetIdByEmail_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockUsers_GetIdByEmail_Call) Return(_a0 string, _a1 error) *MockUsers_GetIdByEmail_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockUsers_GetIdByEmail_Call) RunAndReturn(run func(context.Context, string) (string, error)) *MockUsers_GetIdByEmail_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// GetIdByIdentity provides a mock function with given fields: ctx, provider, subject
+func (_m *MockUsers) GetIdByIdentity(ctx context.Context, provider string, subject string) (string, error) {
+	ret := _m.Called(ctx, provider, subject)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetIdByIdentity")
+	}
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (string, error)); ok {
+		return rf(ctx, provider, subject)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) string); ok {
+		r0 = rf(ctx, provider, subject)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, provider, subject)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockUsers_GetIdByIdentity_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetIdByIdentity'
+type MockUsers_GetIdByIdentity_Call struct {
+	*mock.Call
+}
+
+// GetIdByIdentity is a helper method to define mock.On call
+//   - ctx context.Context
+//   - provider string
+//   - subject string
+func (_e *MockUsers_Expecter) GetIdByIdentity(ctx interface{}, provider interface{}, subject interface{}) *MockUsers_GetIdByIdentity_Call {
+	return &MockUsers_GetIdByIdentity_Call{Call: _e.mock.On("GetIdByIdentity", ctx, provider, subject)}
+}
+
+func (_c *MockUsers_GetIdByIdentity_Call) Run(run func(ctx context.Context, provider string, subject string)) *MockUsers_GetIdByIdentity_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *MockUsers_GetIdByIdentity_Call) Return(_a0 string, _a1 error) *MockUsers_GetIdByIdentity_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockUsers_GetIdByIdentity_Call) RunAndReturn(run func(context.Context, string, string) (string, error)) *MockUsers_GetIdByIdentity_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// GetTotp provides a mock function with given fields: ctx, userId
+func (_m *MockUsers) GetTotp(ctx context.Context, userId string) (users.Totp, error) {
+	ret := _m.Called(ctx, userId)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetTotp")
+	}
+
+	var r0 users.Totp
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (users.Totp, error)); ok {
+		return rf(ctx, userId)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) users.Totp); ok {
+		r0 = rf(ctx, userId)
+	} else {
+		r0 = ret.Get(0).(users.Totp)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, userId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockUsers_GetTotp_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetTotp'
+type MockUsers_GetTotp_Call struct {
+	*mock.Call
+}
+
+// GetTotp is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+func (_e *MockUsers_Expecter) GetTotp(ctx interface{}, userId interface{}) *MockUsers_GetTotp_Call {
+	return &MockUsers_GetTotp_Call{Call: _e.mock.On("GetTotp", ctx, userId)}
+}
+
+func (_c *MockUsers_GetTotp_Call) Run(run func(ctx context.Context, userId string)) *MockUsers_GetTotp_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockUsers_GetTotp_Call) Return(_a0 users.Totp, _a1 error) *MockUsers_GetTotp_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockUsers_GetTotp_Call) RunAndReturn(run func(context.Context, string) (users.Totp, error)) *MockUsers_GetTotp_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// LinkIdentity provides a mock function with given fields: ctx, userId, provider, subject
+func (_m *MockUsers) LinkIdentity(ctx context.Context, userId string, provider string, subject string) error {
+	ret := _m.Called(ctx, userId, provider, subject)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LinkIdentity")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) error); ok {
+		r0 = rf(ctx, userId, provider, subject)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockUsers_LinkIdentity_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'LinkIdentity'
+type MockUsers_LinkIdentity_Call struct {
+	*mock.Call
+}
+
+// LinkIdentity is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+//   - provider string
+//   - subject string
+func (_e *MockUsers_Expecter) LinkIdentity(ctx interface{}, userId interface{}, provider interface{}, subject interface{}) *MockUsers_LinkIdentity_Call {
+	return &MockUsers_LinkIdentity_Call{Call: _e.mock.On("LinkIdentity", ctx, userId, provider, subject)}
+}
+
+func (_c *MockUsers_LinkIdentity_Call) Run(run func(ctx context.Context, userId string, provider string, subject string)) *MockUsers_LinkIdentity_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string), args[3].(string))
+	})
+	return _c
+}
+
+func (_c *MockUsers_LinkIdentity_Call) Return(_a0 error) *MockUsers_LinkIdentity_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockUsers_LinkIdentity_Call) RunAndReturn(run func(context.Context, string, string, string) error) *MockUsers_LinkIdentity_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ResetPassword provides a mock function with given fields: ctx, token, hashedPassword
+func (_m *MockUsers) ResetPassword(ctx context.Context, token string, hashedPassword string) (string, error) {
+	ret := _m.Called(ctx, token, hashedPassword)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ResetPassword")
+	}
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (string, error)); ok {
+		return rf(ctx, token, hashedPassword)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) string); ok {
+		r0 = rf(ctx, token, hashedPassword)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, token, hashedPassword)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockUsers_ResetPassword_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ResetPassword'
+type MockUsers_ResetPassword_Call struct {
+	*mock.Call
+}
+
+// ResetPassword is a helper method to define mock.On call
+//   - ctx context.Context
+//   - token string
+//   - hashedPassword string
+func (_e *MockUsers_Expecter) ResetPassword(ctx interface{}, token interface{}, hashedPassword interface{}) *MockUsers_ResetPassword_Call {
+	return &MockUsers_ResetPassword_Call{Call: _e.mock.On("ResetPassword", ctx, token, hashedPassword)}
+}
+
+func (_c *MockUsers_ResetPassword_Call) Run(run func(ctx context.Context, token string, hashedPassword string)) *MockUsers_ResetPassword_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *MockUsers_ResetPassword_Call) Return(_a0 string, _a1 error) *MockUsers_ResetPassword_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockUsers_ResetPassword_Call) RunAndReturn(run func(context.Context, string, string) (string, error)) *MockUsers_ResetPassword_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// SetPendingTotpSecret provides a mock function with given fields: ctx, userId, secret
+func (_m *MockUsers) SetPendingTotpSecret(ctx context.Context, userId string, secret string) error {
+	ret := _m.Called(ctx, userId, secret)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SetPendingTotpSecret")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, userId, secret)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockUsers_SetPendingTotpSecret_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SetPendingTotpSecret'
+type MockUsers_SetPendingTotpSecret_Call struct {
+	*mock.Call
+}
+
+// SetPendingTotpSecret is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+//   - secret string
+func (_e *MockUsers_Expecter) SetPendingTotpSecret(ctx interface{}, userId interface{}, secret interface{}) *MockUsers_SetPendingTotpSecret_Call {
+	return &MockUsers_SetPendingTotpSecret_Call{Call: _e.mock.On("SetPendingTotpSecret", ctx, userId, secret)}
+}
+
+func (_c *MockUsers_SetPendingTotpSecret_Call) Run(run func(ctx context.Context, userId string, secret string)) *MockUsers_SetPendingTotpSecret_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *MockUsers_SetPendingTotpSecret_Call) Return(_a0 error) *MockUsers_SetPendingTotpSecret_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockUsers_SetPendingTotpSecret_Call) RunAndReturn(run func(context.Context, string, string) error) *MockUsers_SetPendingTotpSecret_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UpdatePassword provides a mock function with given fields: ctx, userId, hashedPassword
+func (_m *MockUsers) UpdatePassword(ctx context.Context, userId string, hashedPassword string) error {
+	ret := _m.Called(ctx, userId, hashedPassword)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdatePassword")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, userId, hashedPassword)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockUsers_UpdatePassword_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UpdatePassword'
+type MockUsers_UpdatePassword_Call struct {
+	*mock.Call
+}
+
+// UpdatePassword is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+//   - hashedPassword string
+func (_e *MockUsers_Expecter) UpdatePassword(ctx interface{}, userId interface{}, hashedPassword interface{}) *MockUsers_UpdatePassword_Call {
+	return &MockUsers_UpdatePassword_Call{Call: _e.mock.On("UpdatePassword", ctx, userId, hashedPassword)}
+}
+
+func (_c *MockUsers_UpdatePassword_Call) Run(run func(ctx context.Context, userId string, hashedPassword string)) *MockUsers_UpdatePassword_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *MockUsers_UpdatePassword_Call) Return(_a0 error) *MockUsers_UpdatePassword_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockUsers_UpdatePassword_Call) RunAndReturn(run func(context.Context, string, string) error) *MockUsers_UpdatePassword_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UseTotpStep provides a mock function with given fields: ctx, userId, step
+func (_m *MockUsers) UseTotpStep(ctx context.Context, userId string, step int64) (bool, error) {
+	ret := _m.Called(ctx, userId, step)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UseTotpStep")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64) (bool, error)); ok {
+		return rf(ctx, userId, step)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64) bool); ok {
+		r0 = rf(ctx, userId, step)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, int64) error); ok {
+		r1 = rf(ctx, userId, step)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockUsers_UseTotpStep_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UseTotpStep'
+type MockUsers_UseTotpStep_Call struct {
+	*mock.Call
+}
+
+// UseTotpStep is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+//   - step int64
+func (_e *MockUsers_Expecter) UseTotpStep(ctx interface{}, userId interface{}, step interface{}) *MockUsers_UseTotpStep_Call {
+	return &MockUsers_UseTotpStep_Call{Call: _e.mock.On("UseTotpStep", ctx, userId, step)}
+}
+
+func (_c *MockUsers_UseTotpStep_Call) Run(run func(ctx context.Context, userId string, step int64)) *MockUsers_UseTotpStep_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(int64))
+	})
+	return _c
+}
+
+func (_c *MockUsers_UseTotpStep_Call) Return(_a0 bool, _a1 error) *MockUsers_UseTotpStep_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockUsers_UseTotpStep_Call) RunAndReturn(run func(context.Context, string, int64) (bool, error)) *MockUsers_UseTotpStep_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // NewMockUsers creates a new instance of MockUsers. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewMockUsers(t interface {
@@ -0,0 +1,129 @@
+package authenticator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"shortener/pkg/domain"
+	"shortener/pkg/egress"
+	"shortener/pkg/models/reminders"
+	"shortener/pkg/responses"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// GetNotificationPreferences returns how the user is reminded about their
+// links expiring. Users who haven't set them get the defaults.
+func (a *Authentitor) GetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got notification preferences request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	tmp := log.With().Str("user_id", userId).Logger()
+	log = &tmp
+
+	p, err := a.preferences.Preferences(context.TODO(), userId)
+	if errors.Is(err, reminders.ErrNoPreferences) {
+		p = &domain.NotificationPreferences{
+			Enabled:      true,
+			Channel:      domain.ChannelEmail,
+			ReminderDays: a.defaultReminderDays,
+		}
+		err = nil
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't get notification preferences")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't get notification preferences",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	pkg, _ := json.Marshal(p)
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+}
+
+func (a *Authentitor) SetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got notification preferences update request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	tmp := log.With().Str("user_id", userId).Logger()
+	log = &tmp
+
+	d := json.NewDecoder(r.Body)
+	var form notificationPreferencesRequest
+	if err := d.Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't parse notification preferences request")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "Couldn't parse notification preferences request",
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(pkg)
+		return
+	}
+
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid notification preferences form")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid notification preferences form",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(pkg)
+		return
+	}
+
+	if form.WebhookUrl != "" {
+		// reminders are refused to connect to such hosts anyway
+		if err := egress.CheckUrl(r.Context(), form.WebhookUrl); err != nil {
+			log.Info().Err(err).Msg("webhook url isn't public")
+
+			pkg, _ := json.Marshal(&responses.Server{
+				Message: "webhook url must be public",
+			})
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(pkg)
+			return
+		}
+	}
+
+	p := &domain.NotificationPreferences{
+		Enabled:      form.Enabled,
+		Channel:      domain.NotificationChannel(form.Channel),
+		WebhookUrl:   form.WebhookUrl,
+		ReminderDays: form.ReminderDays,
+	}
+	if err := a.preferences.SetPreferences(context.TODO(), userId, p); err != nil {
+		log.Error().Err(err).Msg("couldn't save notification preferences")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't save notification preferences",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	pkg, _ := json.Marshal(p)
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+	log.Info().
+		Bool("enabled", p.Enabled).
+		Str("channel", string(p.Channel)).
+		Msg("updated notification preferences")
+}
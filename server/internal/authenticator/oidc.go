@@ -0,0 +1,461 @@
+package authenticator
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"shortener/pkg/models/audit"
+	"shortener/pkg/models/users"
+	"shortener/pkg/responses"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+
+	pbblackbox "shortener/proto/blackbox"
+)
+
+const (
+	oidcFlowCookie    = "oidc_flow"
+	oidcFlowTTL       = 10 * time.Minute
+	oidcIdpTimeout    = 10 * time.Second
+	oidcFlowSeparator = "."
+)
+
+// OidcConfig describes an OpenID Connect provider users can sign in with
+type OidcConfig struct {
+	// used in URLs and to tell identities of different providers apart
+	Name         string
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	// the callback URL registered at the provider
+	RedirectUrl string
+	// openid, email and profile are requested if empty
+	Scopes []string
+}
+
+type OidcProvider struct {
+	name     string
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOidcProvider fetches the discovery document of the issuer
+func NewOidcProvider(ctx context.Context, conf OidcConfig) (*OidcProvider, error) {
+	if conf.Name == "" {
+		return nil, fmt.Errorf("no provider name provided")
+	}
+	if conf.ClientId == "" {
+		return nil, fmt.Errorf("no client id provided for %s", conf.Name)
+	}
+	if conf.RedirectUrl == "" {
+		return nil, fmt.Errorf("no redirect url provided for %s", conf.Name)
+	}
+
+	provider, err := oidc.NewProvider(ctx, conf.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't discover %s: %w", conf.Name, err)
+	}
+
+	scopes := conf.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+
+	return &OidcProvider{
+		name: conf.Name,
+		oauth: oauth2.Config{
+			ClientID:     conf.ClientId,
+			ClientSecret: conf.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  conf.RedirectUrl,
+			Scopes:       scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: conf.ClientId}),
+	}, nil
+}
+
+// oidcFlow is the state of an authorization in progress. It's kept in a
+// cookie between the redirect to the provider and the callback.
+type oidcFlow struct {
+	state    string
+	nonce    string
+	verifier string
+}
+
+func (f oidcFlow) encode() string {
+	return strings.Join(
+		[]string{f.state, f.nonce, f.verifier},
+		oidcFlowSeparator,
+	)
+}
+
+func decodeOidcFlow(s string) (oidcFlow, bool) {
+	parts := strings.Split(s, oidcFlowSeparator)
+	if len(parts) != 3 {
+		return oidcFlow{}, false
+	}
+	return oidcFlow{state: parts[0], nonce: parts[1], verifier: parts[2]}, true
+}
+
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+var errUnverifiedEmail = errors.New("email isn't verified by the provider")
+
+func (a *Authentitor) oidcProvider(
+	w http.ResponseWriter,
+	r *http.Request,
+) (*OidcProvider, bool) {
+	p, ok := a.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "unknown provider",
+		})
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(pkg)
+	}
+	return p, ok
+}
+
+func oidcFlowCookiePath(p *OidcProvider) string {
+	return "/oidc/" + p.name
+}
+
+// OidcLogin redirects the user to the provider
+func (a *Authentitor) OidcLogin(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got oidc login request")
+	p, ok := a.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	var flow oidcFlow
+	var err error
+	if flow.state, err = newRandomToken(); err == nil {
+		flow.nonce, err = newRandomToken()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't generate oidc state")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't start login",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+	flow.verifier = oauth2.GenerateVerifier()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    flow.encode(),
+		Path:     oidcFlowCookiePath(p),
+		MaxAge:   int(oidcFlowTTL.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		// the callback is a cross-site navigation from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	log.Info().Str("provider", p.name).Msg("redirecting to oidc provider")
+	http.Redirect(
+		w,
+		r,
+		p.oauth.AuthCodeURL(
+			flow.state,
+			oauth2.S256ChallengeOption(flow.verifier),
+			oidc.Nonce(flow.nonce),
+		),
+		http.StatusFound,
+	)
+}
+
+// OidcCallback finishes authorization started by OidcLogin
+func (a *Authentitor) OidcCallback(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got oidc callback")
+	p, ok := a.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	tmp := log.With().Str("provider", p.name).Logger()
+	log = &tmp
+
+	// the flow can't be continued anyway, so the cookie is dropped right away
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Path:     oidcFlowCookiePath(p),
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		log.Info().Str("error", e).Msg("provider denied authorization")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "provider denied authorization",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(pkg)
+		return
+	}
+
+	var flow oidcFlow
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err == nil {
+		flow, ok = decodeOidcFlow(cookie.Value)
+	}
+	if err != nil || !ok || subtle.ConstantTimeCompare(
+		[]byte(flow.state),
+		[]byte(query.Get("state")),
+	) != 1 {
+		log.Info().Msg("oidc state mismatch")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid login state",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(pkg)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), oidcIdpTimeout)
+	defer cancel()
+
+	log.Info().Msg("exchanging authorization code")
+	token, err := p.oauth.Exchange(
+		ctx,
+		query.Get("code"),
+		oauth2.VerifierOption(flow.verifier),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't exchange authorization code")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't exchange authorization code",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(pkg)
+		return
+	}
+
+	rawIdToken, _ := token.Extra("id_token").(string)
+	idToken, err := p.verifier.Verify(ctx, rawIdToken)
+	if err == nil && subtle.ConstantTimeCompare(
+		[]byte(idToken.Nonce),
+		[]byte(flow.nonce),
+	) != 1 {
+		err = errors.New("nonce mismatch")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("invalid id token")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid id token",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(pkg)
+		return
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		log.Error().Err(err).Msg("couldn't parse id token claims")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid id token",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(pkg)
+		return
+	}
+
+	tmp = log.With().Str("subject", idToken.Subject).Logger()
+	log = &tmp
+
+	userId, err := a.resolveIdentity(
+		ctx,
+		log,
+		clientIp(r),
+		p.name,
+		idToken.Subject,
+		claims,
+	)
+	if err != nil {
+		if errors.Is(err, errUnverifiedEmail) {
+			log.Info().Msg("provider hasn't verified email")
+
+			pkg, _ := json.Marshal(&responses.Server{
+				Message: "email isn't verified by the provider",
+			})
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(pkg)
+			return
+		}
+
+		log.Error().Err(err).Msg("couldn't resolve identity")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't sign in",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	tmp = log.With().Str("user_id", userId).Logger()
+	log = &tmp
+
+	totpInfo, err := a.users.GetTotp(context.TODO(), userId)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't get two-factor settings")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't check credentials",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	req := &pbblackbox.IssueTokenReq{
+		UserId: userId,
+	}
+	if totpInfo.Enabled {
+		req.Scope = pbblackbox.Scope_SCOPE_MFA_PENDING
+	}
+
+	log.Info().Msg("Issuing JWT")
+	signedToken, err := a.blackboxClient.IssueToken(context.TODO(), req)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't sign token on oidc login")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't issue user token",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	if totpInfo.Enabled {
+		// the landing page is expected to ask for the code and to call
+		// /login/mfa itself. The token is passed in the fragment, which
+		// browsers don't send to servers nor leak in the Referer header
+		landing, err := url.Parse(a.oidcLanding)
+		if err != nil {
+			log.Error().Err(err).Msg("invalid oidc landing url")
+
+			pkg, _ := json.Marshal(&responses.Server{
+				Message: "couldn't sign in",
+			})
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(pkg)
+			return
+		}
+		landing.Fragment = "mfa_token=" + signedToken.GetToken()
+
+		log.Info().Msg("second factor required after oidc login")
+		http.Redirect(w, r, landing.String(), http.StatusFound)
+		return
+	}
+
+	setSessionCookies(w, signedToken.GetToken())
+	http.Redirect(w, r, a.oidcLanding, http.StatusFound)
+
+	log.Info().Msg("successful oidc login")
+}
+
+// resolveIdentity finds the user the identity belongs to. Identities are
+// linked to existing accounts by email, but only if the provider has verified
+// it: otherwise anyone could take over an account by registering at the
+// provider with somebody else's email.
+func (a *Authentitor) resolveIdentity(
+	ctx context.Context,
+	log *zerolog.Logger,
+	ip string,
+	provider string,
+	subject string,
+	claims oidcClaims,
+) (string, error) {
+	userId, err := a.users.GetIdByIdentity(ctx, provider, subject)
+	if err == nil || !errors.Is(err, users.ErrNotFound) {
+		return userId, err
+	}
+
+	if !claims.EmailVerified || claims.Email == "" {
+		return "", errUnverifiedEmail
+	}
+
+	userId, err = a.users.GetIdByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		log.Info().Str("user_id", userId).Msg("linking identity by email")
+		if err := a.users.LinkIdentity(ctx, userId, provider, subject); err != nil {
+			return "", err
+		}
+
+		err = a.audit.Record(
+			ctx,
+			audit.EventIdentityLinked,
+			claims.Email,
+			ip,
+			fmt.Sprintf("%s identity %s", provider, subject),
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("couldn't record identity linking in audit log")
+		}
+		return userId, nil
+	case !errors.Is(err, users.ErrNotFound):
+		return "", err
+	}
+
+	// the account gets a random password: it can only be used after going
+	// through password recovery
+	password, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(password), hashCost)
+	if err != nil {
+		return "", err
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.Email
+	}
+
+	user := &responses.Authenticator{
+		Id:             uuid.New().String(),
+		Name:           name,
+		Email:          claims.Email,
+		HashedPassword: string(hashedPwd),
+	}
+	log.Info().Str("user_id", user.Id).Msg("creating user for new identity")
+	if err := a.users.CreateWithIdentity(ctx, user, provider, subject); err != nil {
+		return "", err
+	}
+	return user.Id, nil
+}
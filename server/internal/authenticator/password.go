@@ -0,0 +1,389 @@
+package authenticator
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"shortener/pkg/models/users"
+	"shortener/pkg/responses"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"golang.org/x/crypto/bcrypt"
+
+	pbblackbox "shortener/proto/blackbox"
+)
+
+const (
+	resetTokenTTL    = time.Hour
+	resetMailTimeout = 30 * time.Second
+)
+
+func newRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (a *Authentitor) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got password recovery request")
+	d := json.NewDecoder(r.Body)
+	var form forgotPasswordRequest
+	if err := d.Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't parse password recovery request")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "Couldn't parse password recovery request",
+		})
+
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(pkg)
+		return
+	}
+
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid password recovery form")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid password recovery form",
+		})
+
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(pkg)
+		return
+	}
+
+	// the email is sent in background and the response doesn't depend on
+	// whether the account exists: otherwise this endpoint could be used
+	// to find out who is registered
+	mailLog := log.With().Str("email", form.Email).Logger()
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		a.sendResetToken(&mailLog, form.Email)
+	}()
+
+	pkg, _ := json.Marshal(&responses.Server{
+		Message: "if the account exists, a reset token has been sent to its email",
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+}
+
+func (a *Authentitor) sendResetToken(log *zerolog.Logger, email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), resetMailTimeout)
+	defer cancel()
+
+	userId, err := a.users.GetIdByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, users.ErrNotFound) {
+			log.Info().Msg("password recovery requested for unknown email")
+		} else {
+			log.Error().Err(err).Msg("couldn't find user by email")
+		}
+		return
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't generate reset token")
+		return
+	}
+
+	if err := a.users.CreateResetToken(ctx, userId, token, resetTokenTTL); err != nil {
+		log.Error().Err(err).Msg("couldn't save reset token")
+		return
+	}
+
+	body := fmt.Sprintf(
+		"Someone has requested a password reset for your account.\n\n"+
+			"Use the following token to set a new password: %s\n\n",
+		token,
+	)
+	if a.resetLink != "" {
+		body += fmt.Sprintf(
+			"Or just follow the link: %s?token=%s\n\n",
+			a.resetLink,
+			url.QueryEscape(token),
+		)
+	}
+	body += fmt.Sprintf(
+		"The token expires in %s. If it wasn't you, ignore this email.\n",
+		resetTokenTTL,
+	)
+
+	if err := a.mailer.Send(ctx, email, "Password reset", body); err != nil {
+		log.Error().Err(err).Msg("couldn't send reset token")
+		return
+	}
+	log.Info().Str("user_id", userId).Msg("sent reset token")
+}
+
+func (a *Authentitor) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got password reset request")
+	d := json.NewDecoder(r.Body)
+	var form resetPasswordRequest
+	if err := d.Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't parse password reset request")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "Couldn't parse password reset request",
+		})
+
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(pkg)
+		return
+	}
+
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid password reset form")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid password reset form",
+		})
+
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(pkg)
+		return
+	}
+
+	hashedPwd, ok := a.hashPassword(w, log, form.Password)
+	if !ok {
+		return
+	}
+
+	// the token is consumed with the password stored, so that it can be used
+	// again if the password couldn't be
+	log.Info().Msg("resetting password")
+	userId, err := a.users.ResetPassword(context.TODO(), form.Token, hashedPwd)
+	if err != nil {
+		if errors.Is(err, users.ErrInvalidResetToken) {
+			log.Info().Msg("got invalid reset token")
+
+			pkg, _ := json.Marshal(&responses.Server{
+				Message: "invalid or expired reset token",
+			})
+
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(pkg)
+			return
+		}
+
+		log.Error().Err(err).Msg("couldn't reset password")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't reset password",
+		})
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	tmp := log.With().Str("user_id", userId).Logger()
+	log = &tmp
+
+	if !a.revokeTokens(w, log, userId) {
+		return
+	}
+
+	pkg, _ := json.Marshal(&responses.Server{
+		Message: "success",
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+	log.Info().Msg("password has been reset")
+}
+
+func (a *Authentitor) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got password change request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	tmp := log.With().Str("user_id", userId).Logger()
+	log = &tmp
+
+	d := json.NewDecoder(r.Body)
+	var form changePasswordRequest
+	if err := d.Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't parse password change request")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "Couldn't parse password change request",
+		})
+
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(pkg)
+		return
+	}
+
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid password change form")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid password change form",
+		})
+
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(pkg)
+		return
+	}
+
+	log.Info().Msg("checking old password")
+	err := a.users.CheckPassword(context.TODO(), userId, form.OldPassword)
+	if err != nil {
+		if errors.Is(err, users.ErrWrongCredentials) {
+			log.Info().Msg("wrong old password")
+
+			pkg, _ := json.Marshal(&responses.Server{
+				Message: "wrong password",
+			})
+
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(pkg)
+			return
+		}
+
+		log.Error().Err(err).Msg("couldn't check old password")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't check password",
+		})
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	if !a.setPassword(w, r, userId, form.NewPassword) {
+		return
+	}
+
+	// every token including the current one has just been revoked, so the
+	// user gets a fresh one in order to stay logged in
+	log.Info().Msg("issuing JWT")
+	signedToken, err := a.blackboxClient.IssueToken(
+		context.TODO(),
+		&pbblackbox.IssueTokenReq{
+			UserId: userId,
+		},
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't issue token after password change")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "password has been changed, but couldn't issue new JWT",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	setSessionCookies(w, signedToken.GetToken())
+	w.WriteHeader(http.StatusOK)
+
+	pkg, _ := json.Marshal(&responses.Server{
+		Message: "success",
+	})
+	w.Write(pkg)
+	log.Info().Msg("password has been changed")
+}
+
+// setPassword hashes and stores the new password and revokes every token
+// issued to the user. On failure it writes an error response and returns
+// false.
+func (a *Authentitor) setPassword(
+	w http.ResponseWriter,
+	r *http.Request,
+	userId string,
+	password string,
+) bool {
+	log := hlog.FromRequest(r).With().Str("user_id", userId).Logger()
+
+	hashedPwd, ok := a.hashPassword(w, &log, password)
+	if !ok {
+		return false
+	}
+
+	log.Info().Msg("updating password")
+	err := a.users.UpdatePassword(context.TODO(), userId, hashedPwd)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't update password")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't update password",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return false
+	}
+
+	return a.revokeTokens(w, &log, userId)
+}
+
+// hashPassword returns the hash the password is stored as. On failure it
+// writes an error response and returns false.
+func (a *Authentitor) hashPassword(
+	w http.ResponseWriter,
+	log *zerolog.Logger,
+	password string,
+) (string, bool) {
+	log.Info().Msg("generating password hash")
+	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(password), hashCost)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't hash password")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't update password",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return "", false
+	}
+	return string(hashedPwd), true
+}
+
+// revokeTokens revokes every token issued to the user once the password
+// has been changed. On failure it writes an error response and returns
+// false.
+func (a *Authentitor) revokeTokens(
+	w http.ResponseWriter,
+	log *zerolog.Logger,
+	userId string,
+) bool {
+	log.Info().Msg("revoking tokens")
+	_, err := a.blackboxClient.RevokeTokens(
+		context.TODO(),
+		&pbblackbox.RevokeTokensReq{
+			UserId: userId,
+		},
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't revoke tokens")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "password has been changed, but couldn't terminate existing sessions",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return false
+	}
+
+	return true
+}
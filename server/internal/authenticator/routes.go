@@ -0,0 +1,159 @@
+package authenticator
+
+import (
+	"net/http"
+	"shortener/pkg/middleware"
+
+	"github.com/justinas/alice"
+)
+
+// Mount registers the routes of the service on the mux. Requests are handled
+// with the chain, e.g. middleware.RequestTracing, and allowed from the CORS
+// origin.
+func (a *Authentitor) Mount(mux *http.ServeMux, c alice.Chain, cors middleware.Cors) {
+	mux.Handle("OPTIONS /signup", cors.Preflight(""))
+	mux.Handle("OPTIONS /login", c.Then(cors.Preflight("")))
+	for _, route := range []string{
+		"OPTIONS /password/forgot",
+		"OPTIONS /password/reset",
+		"OPTIONS /password/change",
+		"OPTIONS /login/mfa",
+		"OPTIONS /mfa/enroll",
+		"OPTIONS /mfa/confirm",
+		"OPTIONS /mfa/disable",
+		"OPTIONS /workspaces",
+		"OPTIONS /workspaces/switch",
+		"OPTIONS /workspaces/invites/accept",
+		"OPTIONS /workspaces/{workspace_id}/members",
+		"OPTIONS /workspaces/{workspace_id}/invites",
+		"OPTIONS /workspaces/{workspace_id}/members/{user_id}",
+	} {
+		mux.Handle(route, c.Then(cors.Preflight("GET, POST, PUT, DELETE")))
+	}
+	mux.Handle(
+		"GET /oidc/{provider}/login",
+		c.ThenFunc(a.OidcLogin),
+	)
+	mux.Handle(
+		"GET /oidc/{provider}/callback",
+		c.ThenFunc(a.OidcCallback),
+	)
+	mux.Handle(
+		"POST /signup",
+		c.Append(cors.Headers).ThenFunc(a.Register),
+	)
+	mux.Handle(
+		"POST /login",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.Login),
+	)
+	mux.Handle(
+		"POST /password/forgot",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.ForgotPassword),
+	)
+	mux.Handle(
+		"POST /password/reset",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.ResetPassword),
+	)
+	mux.Handle(
+		"POST /password/change",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.ChangePassword),
+	)
+	mux.Handle(
+		"POST /login/mfa",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.LoginMfa),
+	)
+	mux.Handle(
+		"POST /mfa/enroll",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.EnrollMfa),
+	)
+	mux.Handle(
+		"POST /mfa/confirm",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.ConfirmMfa),
+	)
+	mux.Handle(
+		"POST /mfa/disable",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.DisableMfa),
+	)
+	mux.Handle(
+		"POST /workspaces",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.CreateWorkspace),
+	)
+	mux.Handle(
+		"GET /workspaces",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.ListWorkspaces),
+	)
+	mux.Handle(
+		"POST /workspaces/switch",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.SwitchWorkspace),
+	)
+	mux.Handle(
+		"POST /workspaces/invites/accept",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.AcceptInvite),
+	)
+	mux.Handle(
+		"GET /workspaces/{workspace_id}/members",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.ListMembers),
+	)
+	mux.Handle(
+		"POST /workspaces/{workspace_id}/invites",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.InviteMember),
+	)
+	mux.Handle(
+		"PUT /workspaces/{workspace_id}/members/{user_id}",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.UpdateMember),
+	)
+	mux.Handle(
+		"DELETE /workspaces/{workspace_id}/members/{user_id}",
+		c.
+			Append(cors.Headers).
+			ThenFunc(a.RemoveMember),
+	)
+	if a.preferences != nil {
+		mux.Handle(
+			"OPTIONS /notifications/preferences",
+			c.Then(cors.Preflight("GET, PUT")),
+		)
+		mux.Handle(
+			"GET /notifications/preferences",
+			c.
+				Append(cors.Headers).
+				ThenFunc(a.GetNotificationPreferences),
+		)
+		mux.Handle(
+			"PUT /notifications/preferences",
+			c.
+				Append(cors.Headers).
+				ThenFunc(a.SetNotificationPreferences),
+		)
+	}
+}
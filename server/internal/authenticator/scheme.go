@@ -14,3 +14,56 @@ type registerRequest struct {
 	Email           string `json:"email"            validate:"required,email"`
 	Password        string `json:"password"         validate:"required,gte=8,lt=64"`
 }
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"    validate:"required"`
+	Password string `json:"password" validate:"required,gte=8,lt=64"`
+}
+
+type changePasswordRequest struct {
+	OldPassword string `json:"old_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,gte=8,lt=64"`
+}
+
+type loginMfaRequest struct {
+	MfaToken     string `json:"mfa_token"     validate:"required"`
+	Code         string `json:"code"          validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code"`
+}
+
+type totpCodeRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type createWorkspaceRequest struct {
+	Name string `json:"name" validate:"required,gt=0,lt=300"`
+}
+
+type switchWorkspaceRequest struct {
+	// empty for the personal workspace
+	WorkspaceId string `json:"workspace_id" validate:"omitempty,uuid"`
+}
+
+type inviteMemberRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role"  validate:"required,oneof=owner admin editor viewer"`
+}
+
+type acceptInviteRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type updateMemberRequest struct {
+	Role string `json:"role" validate:"required,oneof=owner admin editor viewer"`
+}
+
+type notificationPreferencesRequest struct {
+	Enabled      bool   `json:"enabled"`
+	Channel      string `json:"channel"       validate:"required,oneof=email webhook"`
+	WebhookUrl   string `json:"webhook_url"   validate:"required_if=Channel webhook,omitempty,http_url,lte=300"`
+	ReminderDays []int  `json:"reminder_days" validate:"required,lte=5,unique,dive,gte=1,lte=365"`
+}
@@ -0,0 +1,769 @@
+package authenticator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"shortener/pkg/domain"
+	"shortener/pkg/models/workspaces"
+	"shortener/pkg/responses"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+
+	pbblackbox "shortener/proto/blackbox"
+)
+
+const (
+	inviteTokenTTL    = 7 * 24 * time.Hour
+	inviteMailTimeout = 30 * time.Second
+)
+
+// pathUuid extracts an id from the URL path. On failure it writes an error
+// response and returns false.
+func pathUuid(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+	id := r.PathValue(name)
+	if _, err := uuid.Parse(id); err != nil {
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid " + name,
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(pkg)
+		return "", false
+	}
+	return id, true
+}
+
+// requireRole checks that the user is a member of the workspace with at least
+// the required role. Membership is checked against the database rather than
+// the JWT, so that changes take effect immediately. On failure it writes an
+// error response and returns false.
+func (a *Authentitor) requireRole(
+	w http.ResponseWriter,
+	log *zerolog.Logger,
+	workspaceId string,
+	userId string,
+	required domain.Role,
+) (domain.Role, bool) {
+	role, err := a.workspaces.Role(context.TODO(), workspaceId, userId)
+	if err != nil {
+		if errors.Is(err, workspaces.ErrNotMember) {
+			log.Info().Msg("user isn't a member of the workspace")
+
+			pkg, _ := json.Marshal(&responses.Server{
+				Message: "not a member of the workspace",
+			})
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(pkg)
+			return "", false
+		}
+
+		log.Error().Err(err).Msg("couldn't get role in workspace")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't check role in workspace",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return "", false
+	}
+
+	if !role.Allows(required) {
+		log.Info().
+			Str("role", string(role)).
+			Str("required", string(required)).
+			Msg("insufficient role in workspace")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "insufficient role in workspace",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(pkg)
+		return "", false
+	}
+
+	return role, true
+}
+
+// issueWorkspaceToken sends a fresh session token carrying the workspace
+// claims. On failure it writes an error response and returns false.
+func (a *Authentitor) issueWorkspaceToken(
+	w http.ResponseWriter,
+	log *zerolog.Logger,
+	userId string,
+	workspaceId string,
+	role domain.Role,
+) bool {
+	log.Info().Msg("issuing JWT")
+	signedToken, err := a.blackboxClient.IssueToken(
+		context.TODO(),
+		&pbblackbox.IssueTokenReq{
+			UserId:      userId,
+			WorkspaceId: workspaceId,
+			Role:        string(role),
+		},
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't issue workspace token")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't issue user token",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return false
+	}
+
+	setSessionCookies(w, signedToken.GetToken())
+	return true
+}
+
+// membershipChanged revokes tokens of the member, since they may carry a
+// role the member no longer has. If members change their own membership, they
+// get a new token for their personal workspace in order to stay logged in. On
+// failure it writes an error response and returns false.
+func (a *Authentitor) membershipChanged(
+	w http.ResponseWriter,
+	log *zerolog.Logger,
+	userId string,
+	memberId string,
+) bool {
+	log.Info().Str("member_id", memberId).Msg("revoking tokens of member")
+	_, err := a.blackboxClient.RevokeTokens(
+		context.TODO(),
+		&pbblackbox.RevokeTokensReq{
+			UserId: memberId,
+		},
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't revoke tokens of member")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "membership has been changed, but couldn't terminate sessions of the member",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return false
+	}
+
+	if memberId != userId {
+		return true
+	}
+	return a.issueWorkspaceToken(w, log, userId, "", "")
+}
+
+func (a *Authentitor) CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got workspace creation request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	tmp := log.With().Str("user_id", userId).Logger()
+	log = &tmp
+
+	d := json.NewDecoder(r.Body)
+	var form createWorkspaceRequest
+	if err := d.Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't parse workspace creation request")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "Couldn't parse workspace creation request",
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(pkg)
+		return
+	}
+
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid workspace creation form")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid workspace creation form",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(pkg)
+		return
+	}
+
+	log.Info().Msg("creating workspace")
+	workspaceId, err := a.workspaces.Create(context.TODO(), userId, form.Name)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't create workspace")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't create workspace",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	pkg, _ := json.Marshal(&domain.Workspace{
+		Id:   workspaceId,
+		Name: form.Name,
+		Role: domain.RoleOwner,
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+	log.Info().Str("workspace_id", workspaceId).Msg("workspace has been created")
+}
+
+func (a *Authentitor) ListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got workspaces listing request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := a.workspaces.List(context.TODO(), userId)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't list workspaces")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't list workspaces",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	pkg, _ := json.Marshal(&list)
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+}
+
+// SwitchWorkspace reissues the session token with the workspace and the role
+// of the user in it
+func (a *Authentitor) SwitchWorkspace(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got workspace switching request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	tmp := log.With().Str("user_id", userId).Logger()
+	log = &tmp
+
+	d := json.NewDecoder(r.Body)
+	var form switchWorkspaceRequest
+	if err := d.Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't parse workspace switching request")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "Couldn't parse workspace switching request",
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(pkg)
+		return
+	}
+
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid workspace switching form")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid workspace switching form",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(pkg)
+		return
+	}
+
+	var role domain.Role
+	if form.WorkspaceId != "" {
+		tmp := log.With().Str("workspace_id", form.WorkspaceId).Logger()
+		log = &tmp
+
+		role, ok = a.requireRole(w, log, form.WorkspaceId, userId, domain.RoleViewer)
+		if !ok {
+			return
+		}
+	}
+
+	if !a.issueWorkspaceToken(w, log, userId, form.WorkspaceId, role) {
+		return
+	}
+
+	pkg, _ := json.Marshal(&responses.Server{
+		Message: "success",
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+	log.Info().Msg("switched workspace")
+}
+
+func (a *Authentitor) ListMembers(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got members listing request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+	workspaceId, ok := pathUuid(w, r, "workspace_id")
+	if !ok {
+		return
+	}
+
+	tmp := log.With().
+		Str("user_id", userId).
+		Str("workspace_id", workspaceId).
+		Logger()
+	log = &tmp
+
+	if _, ok := a.requireRole(w, log, workspaceId, userId, domain.RoleViewer); !ok {
+		return
+	}
+
+	members, err := a.workspaces.Members(context.TODO(), workspaceId)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't list members")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't list members",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	pkg, _ := json.Marshal(&members)
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+}
+
+func (a *Authentitor) InviteMember(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got invitation request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+	workspaceId, ok := pathUuid(w, r, "workspace_id")
+	if !ok {
+		return
+	}
+
+	tmp := log.With().
+		Str("user_id", userId).
+		Str("workspace_id", workspaceId).
+		Logger()
+	log = &tmp
+
+	d := json.NewDecoder(r.Body)
+	var form inviteMemberRequest
+	if err := d.Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't parse invitation request")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "Couldn't parse invitation request",
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(pkg)
+		return
+	}
+
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid invitation form")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid invitation form",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(pkg)
+		return
+	}
+
+	role, ok := a.requireRole(w, log, workspaceId, userId, domain.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	// nobody can grant more than they have
+	if !role.Allows(domain.Role(form.Role)) {
+		log.Info().Str("invited_role", form.Role).Msg("role can't be granted")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "insufficient role in workspace",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(pkg)
+		return
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't generate invite token")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't invite member",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	err = a.workspaces.CreateInvite(
+		context.TODO(),
+		workspaceId,
+		form.Email,
+		domain.Role(form.Role),
+		userId,
+		token,
+		inviteTokenTTL,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't save invite")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't invite member",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	mailLog := log.With().Str("email", form.Email).Logger()
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		a.sendInvite(&mailLog, form.Email, form.Role, token)
+	}()
+
+	pkg, _ := json.Marshal(&responses.Server{
+		Message: "success",
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+	log.Info().Msg("member has been invited")
+}
+
+func (a *Authentitor) sendInvite(
+	log *zerolog.Logger,
+	email string,
+	role string,
+	token string,
+) {
+	ctx, cancel := context.WithTimeout(context.Background(), inviteMailTimeout)
+	defer cancel()
+
+	body := fmt.Sprintf(
+		"You have been invited to join a workspace as %s.\n\n"+
+			"Use the following token to accept the invitation: %s\n\n",
+		role,
+		token,
+	)
+	if a.inviteLink != "" {
+		body += fmt.Sprintf(
+			"Or just follow the link: %s?token=%s\n\n",
+			a.inviteLink,
+			url.QueryEscape(token),
+		)
+	}
+	body += fmt.Sprintf("The invitation expires in %s.\n", inviteTokenTTL)
+
+	if err := a.mailer.Send(ctx, email, "Workspace invitation", body); err != nil {
+		log.Error().Err(err).Msg("couldn't send invite")
+		return
+	}
+	log.Info().Msg("sent invite")
+}
+
+func (a *Authentitor) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got invitation acceptance request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	tmp := log.With().Str("user_id", userId).Logger()
+	log = &tmp
+
+	d := json.NewDecoder(r.Body)
+	var form acceptInviteRequest
+	if err := d.Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't parse invitation acceptance request")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "Couldn't parse invitation acceptance request",
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(pkg)
+		return
+	}
+
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid invitation acceptance form")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid invitation acceptance form",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(pkg)
+		return
+	}
+
+	email, err := a.users.GetEmail(context.TODO(), userId)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't get email of the user")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't accept invite",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	workspaceId, role, err := a.workspaces.AcceptInvite(
+		context.TODO(),
+		form.Token,
+		userId,
+		email,
+	)
+	if err != nil {
+		if errors.Is(err, workspaces.ErrInvalidInvite) {
+			log.Info().Msg("got invalid invite")
+
+			pkg, _ := json.Marshal(&responses.Server{
+				Message: "invalid or expired invite",
+			})
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(pkg)
+			return
+		}
+
+		log.Error().Err(err).Msg("couldn't accept invite")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't accept invite",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+		return
+	}
+
+	pkg, _ := json.Marshal(&domain.Workspace{
+		Id:   workspaceId,
+		Role: role,
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+	log.Info().Str("workspace_id", workspaceId).Msg("invite has been accepted")
+}
+
+func (a *Authentitor) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got member update request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+	workspaceId, ok := pathUuid(w, r, "workspace_id")
+	if !ok {
+		return
+	}
+	memberId, ok := pathUuid(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	tmp := log.With().
+		Str("user_id", userId).
+		Str("workspace_id", workspaceId).
+		Str("member_id", memberId).
+		Logger()
+	log = &tmp
+
+	d := json.NewDecoder(r.Body)
+	var form updateMemberRequest
+	if err := d.Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't parse member update request")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "Couldn't parse member update request",
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(pkg)
+		return
+	}
+
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid member update form")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "invalid member update form",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(pkg)
+		return
+	}
+
+	role, ok := a.requireRole(w, log, workspaceId, userId, domain.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	if !a.canManage(w, log, role, workspaceId, memberId) {
+		return
+	}
+	if !role.Allows(domain.Role(form.Role)) {
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "insufficient role in workspace",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(pkg)
+		return
+	}
+
+	log.Info().Str("role", form.Role).Msg("changing role of member")
+	err := a.workspaces.SetRole(
+		context.TODO(),
+		workspaceId,
+		memberId,
+		domain.Role(form.Role),
+	)
+	if err != nil {
+		writeMembershipError(w, log, err)
+		return
+	}
+
+	if !a.membershipChanged(w, log, userId, memberId) {
+		return
+	}
+
+	pkg, _ := json.Marshal(&responses.Server{
+		Message: "success",
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+}
+
+// RemoveMember excludes a member from the workspace. Any member can leave a
+// workspace on their own.
+func (a *Authentitor) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r)
+
+	log.Info().Msg("got member removal request")
+	userId, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+	workspaceId, ok := pathUuid(w, r, "workspace_id")
+	if !ok {
+		return
+	}
+	memberId, ok := pathUuid(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	tmp := log.With().
+		Str("user_id", userId).
+		Str("workspace_id", workspaceId).
+		Str("member_id", memberId).
+		Logger()
+	log = &tmp
+
+	if memberId != userId {
+		role, ok := a.requireRole(w, log, workspaceId, userId, domain.RoleAdmin)
+		if !ok {
+			return
+		}
+		if !a.canManage(w, log, role, workspaceId, memberId) {
+			return
+		}
+	}
+
+	log.Info().Msg("removing member")
+	err := a.workspaces.RemoveMember(context.TODO(), workspaceId, memberId)
+	if err != nil {
+		writeMembershipError(w, log, err)
+		return
+	}
+
+	if !a.membershipChanged(w, log, userId, memberId) {
+		return
+	}
+
+	pkg, _ := json.Marshal(&responses.Server{
+		Message: "success",
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(pkg)
+}
+
+// canManage checks that a member with the role can change membership of
+// another member: only owners can manage owners. On failure it writes an
+// error response and returns false.
+func (a *Authentitor) canManage(
+	w http.ResponseWriter,
+	log *zerolog.Logger,
+	role domain.Role,
+	workspaceId string,
+	memberId string,
+) bool {
+	memberRole, err := a.workspaces.Role(context.TODO(), workspaceId, memberId)
+	if err != nil {
+		writeMembershipError(w, log, err)
+		return false
+	}
+
+	if !role.Allows(memberRole) {
+		log.Info().
+			Str("member_role", string(memberRole)).
+			Msg("member can't be managed with current role")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "insufficient role in workspace",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(pkg)
+		return false
+	}
+	return true
+}
+
+func writeMembershipError(w http.ResponseWriter, log *zerolog.Logger, err error) {
+	switch {
+	case errors.Is(err, workspaces.ErrNotMember):
+		log.Info().Msg("no such member")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "no such member",
+		})
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(pkg)
+	case errors.Is(err, workspaces.ErrLastOwner):
+		log.Info().Msg("attempt to leave workspace without owners")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "workspace must have at least one owner",
+		})
+		w.WriteHeader(http.StatusConflict)
+		w.Write(pkg)
+	default:
+		log.Error().Err(err).Msg("couldn't change membership")
+
+		pkg, _ := json.Marshal(&responses.Server{
+			Message: "couldn't change membership",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(pkg)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"shortener/proto/blackbox"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/rs/zerolog"
@@ -12,11 +13,20 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+// lifetime of tokens issued for any scope other than the session one
+const scopedTokenTTL = 5 * time.Minute
+
+type Generations interface {
+	Generation(ctx context.Context, userId string) (int64, error)
+	Bump(ctx context.Context, userId string) error
+}
+
 type BlackboxServiceImpl struct {
 	blackbox.UnimplementedBlackboxServiceServer
 
-	logger *zerolog.Logger
-	secret string
+	logger      *zerolog.Logger
+	secret      string
+	generations Generations
 }
 
 type serviceOption func(*BlackboxServiceImpl) error
@@ -28,6 +38,13 @@ func WithSecret(secret string) serviceOption {
 	}
 }
 
+func WithGenerations(g Generations) serviceOption {
+	return func(s *BlackboxServiceImpl) error {
+		s.generations = g
+		return nil
+	}
+}
+
 func New(
 	opts ...serviceOption,
 ) (*BlackboxServiceImpl, error) {
@@ -39,6 +56,9 @@ func New(
 	if s.secret == "" {
 		return nil, fmt.Errorf("no secret provided")
 	}
+	if s.generations == nil {
+		return nil, fmt.Errorf("no token generations store provided")
+	}
 
 	return s, nil
 }
@@ -58,13 +78,42 @@ func (s *BlackboxServiceImpl) IssueToken(
 		)
 	}
 
+	if r.GetWorkspaceId() != "" && r.GetRole() == "" {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"Role not provided for workspace",
+		)
+	}
+
 	log.Println("issuing JWT for", r.GetUserId())
 
-	token := jwt.NewWithClaims(
-		jwt.SigningMethodHS256,
-		jwt.MapClaims{
-			"sub": r.GetUserId(),
-		})
+	gen, err := s.generations.Generation(ctx, r.GetUserId())
+	if err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			"couldn't get token generation: %v",
+			err,
+		)
+	}
+
+	claims := jwt.MapClaims{
+		"sub": r.GetUserId(),
+	}
+	// tokens of users that have never revoked anything carry no generation
+	if gen > 0 {
+		claims["gen"] = gen
+	}
+	if r.GetWorkspaceId() != "" {
+		claims["wid"] = r.GetWorkspaceId()
+		claims["role"] = r.GetRole()
+	}
+	// session tokens carry no scope for compatibility with the ones issued
+	// before scopes were introduced
+	if r.GetScope() != blackbox.Scope_SCOPE_SESSION {
+		claims["scp"] = r.GetScope().String()
+		claims["exp"] = jwt.NewNumericDate(time.Now().Add(scopedTokenTTL))
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	signedToken, err := token.SignedString([]byte(s.secret))
 	if err != nil {
@@ -113,8 +162,74 @@ func (s *BlackboxServiceImpl) ValidateToken(
 		)
 	}
 
+	var tokenGen int64
+	var workspaceId, role string
+	tokenScope := blackbox.Scope_SCOPE_SESSION.String()
+	if claims, ok := parsedToken.Claims.(jwt.MapClaims); ok {
+		if gen, ok := claims["gen"].(float64); ok {
+			tokenGen = int64(gen)
+		}
+		if scp, ok := claims["scp"].(string); ok {
+			tokenScope = scp
+		}
+		workspaceId, _ = claims["wid"].(string)
+		role, _ = claims["role"].(string)
+	}
+
+	if tokenScope != r.GetScope().String() {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			"token has been issued for another scope",
+		)
+	}
+
+	gen, err := s.generations.Generation(ctx, sub)
+	if err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			"couldn't get token generation: %v",
+			err,
+		)
+	}
+	if tokenGen != gen {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			"token has been revoked",
+		)
+	}
+
 	res := &blackbox.ValidateTokenRsp{
-		UserId: sub,
+		UserId:      sub,
+		WorkspaceId: workspaceId,
+		Role:        role,
 	}
 	return res, nil
 }
+
+func (s *BlackboxServiceImpl) RevokeTokens(
+	ctx context.Context,
+	r *blackbox.RevokeTokensReq,
+) (*blackbox.RevokeTokensRsp, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, status.Errorf(codes.DeadlineExceeded, "deadline exceeded")
+	}
+
+	if r.GetUserId() == "" {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"UserId not provided",
+		)
+	}
+
+	log.Println("revoking JWTs of", r.GetUserId())
+
+	if err := s.generations.Bump(ctx, r.GetUserId()); err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			"couldn't revoke tokens: %v",
+			err,
+		)
+	}
+
+	return &blackbox.RevokeTokensRsp{}, nil
+}
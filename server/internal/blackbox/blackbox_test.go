@@ -9,6 +9,7 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
@@ -28,7 +29,12 @@ func init() {
 	lis = bufconn.Listen(bufSize)
 	s := grpc.NewServer()
 
-	service, err := New(WithSecret(secret))
+	generations := &MockGenerations{}
+	generations.EXPECT().
+		Generation(mock.Anything, mock.AnythingOfType("string")).
+		Return(0, nil)
+
+	service, err := New(WithSecret(secret), WithGenerations(generations))
 	if err != nil {
 		panic(err)
 	}
@@ -167,3 +173,160 @@ func TestValidateTokenFailInvalidToken(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, codes.InvalidArgument, pberr.Code())
 }
+
+func TestValidateTokenFailRevoked(t *testing.T) {
+	generations := NewMockGenerations(t)
+	generations.EXPECT().
+		Generation(context.Background(), "id").
+		Return(1, nil)
+
+	service, err := New(WithSecret(secret), WithGenerations(generations))
+	assert.Nil(t, err)
+
+	token := jwt.NewWithClaims(
+		jwt.SigningMethodHS256,
+		jwt.MapClaims{
+			"sub": "id",
+		})
+	signedToken, err := token.SignedString([]byte(secret))
+	assert.Nil(t, err)
+
+	res, err := service.ValidateToken(
+		context.Background(),
+		&blackbox.ValidateTokenReq{
+			Token: signedToken,
+		},
+	)
+	assert.Nil(t, res)
+
+	pberr, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, pberr.Code())
+}
+
+func TestIssueTokenAfterRevocation(t *testing.T) {
+	generations := NewMockGenerations(t)
+	generations.EXPECT().
+		Bump(context.Background(), "id").
+		Return(nil)
+	generations.EXPECT().
+		Generation(context.Background(), "id").
+		Return(1, nil)
+
+	service, err := New(WithSecret(secret), WithGenerations(generations))
+	assert.Nil(t, err)
+
+	_, err = service.RevokeTokens(
+		context.Background(),
+		&blackbox.RevokeTokensReq{UserId: "id"},
+	)
+	assert.Nil(t, err)
+
+	issued, err := service.IssueToken(
+		context.Background(),
+		&blackbox.IssueTokenReq{UserId: "id"},
+	)
+	assert.Nil(t, err)
+
+	res, err := service.ValidateToken(
+		context.Background(),
+		&blackbox.ValidateTokenReq{
+			Token: issued.GetToken(),
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, "id", res.GetUserId())
+}
+
+func TestValidateTokenFailWrongScope(t *testing.T) {
+	generations := NewMockGenerations(t)
+	generations.EXPECT().
+		Generation(context.Background(), "id").
+		Return(0, nil)
+
+	service, err := New(WithSecret(secret), WithGenerations(generations))
+	assert.Nil(t, err)
+
+	issued, err := service.IssueToken(
+		context.Background(),
+		&blackbox.IssueTokenReq{
+			UserId: "id",
+			Scope:  blackbox.Scope_SCOPE_MFA_PENDING,
+		},
+	)
+	assert.Nil(t, err)
+
+	res, err := service.ValidateToken(
+		context.Background(),
+		&blackbox.ValidateTokenReq{
+			Token: issued.GetToken(),
+		},
+	)
+	assert.Nil(t, res)
+
+	pberr, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, pberr.Code())
+
+	res, err = service.ValidateToken(
+		context.Background(),
+		&blackbox.ValidateTokenReq{
+			Token: issued.GetToken(),
+			Scope: blackbox.Scope_SCOPE_MFA_PENDING,
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, "id", res.GetUserId())
+}
+
+func TestWorkspaceClaims(t *testing.T) {
+	generations := NewMockGenerations(t)
+	generations.EXPECT().
+		Generation(context.Background(), "id").
+		Return(0, nil)
+
+	service, err := New(WithSecret(secret), WithGenerations(generations))
+	assert.Nil(t, err)
+
+	issued, err := service.IssueToken(
+		context.Background(),
+		&blackbox.IssueTokenReq{
+			UserId:      "id",
+			WorkspaceId: "workspace",
+			Role:        "editor",
+		},
+	)
+	assert.Nil(t, err)
+
+	res, err := service.ValidateToken(
+		context.Background(),
+		&blackbox.ValidateTokenReq{
+			Token: issued.GetToken(),
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, "id", res.GetUserId())
+	assert.Equal(t, "workspace", res.GetWorkspaceId())
+	assert.Equal(t, "editor", res.GetRole())
+}
+
+func TestIssueTokenFailWorkspaceWithoutRole(t *testing.T) {
+	service, err := New(
+		WithSecret(secret),
+		WithGenerations(NewMockGenerations(t)),
+	)
+	assert.Nil(t, err)
+
+	res, err := service.IssueToken(
+		context.Background(),
+		&blackbox.IssueTokenReq{
+			UserId:      "id",
+			WorkspaceId: "workspace",
+		},
+	)
+	assert.Nil(t, res)
+
+	pberr, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.FailedPrecondition, pberr.Code())
+}
@@ -0,0 +1,337 @@
+// Package reaper archives expired links. Expired links are moved out of
+// Urls in bounded batches, so that the table and its indexes only hold live
+// links. Short urls of archived links stay taken, and may optionally be
+// released for new links after a cool-down.
+package reaper
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/bus"
+	"shortener/pkg/events"
+	eventsv1 "shortener/proto/events/v1"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+)
+
+var (
+	archivedLinks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "reaper_archived_links_total",
+		Help: "Expired links moved to the archive.",
+	})
+	releasedShortUrls = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "reaper_released_short_urls_total",
+		Help: "Short urls of archived links released for new links.",
+	})
+	expiredLinks = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "reaper_expired_links",
+		Help: "Expired links waiting to be archived at the end of the last pass.",
+	})
+	releasableShortUrls = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "reaper_releasable_short_urls",
+		Help: "Held short urls past the cool-down at the end of the last pass.",
+	})
+	passes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reaper_passes_total",
+		Help: "Passes of the reaper by result: success or failure.",
+	}, []string{"result"})
+	lastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "reaper_last_success_timestamp_seconds",
+		Help: "Time the last successful pass has ended at.",
+	})
+	unpublishedExpirations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "reaper_unpublished_expirations_total",
+		Help: "Archived links whose expiration events couldn't be published.",
+	})
+)
+
+type Urls interface {
+	ArchiveExpired(ctx context.Context, before time.Time, limit int) ([]string, error)
+	CountExpired(ctx context.Context, before time.Time) (int64, error)
+	ReleaseShortUrls(ctx context.Context, archivedBefore time.Time, limit int) (int64, error)
+	CountReleasable(ctx context.Context, archivedBefore time.Time) (int64, error)
+}
+
+type Reaper struct {
+	urls Urls
+	// expirations are published to the topic if set
+	publisher bus.Publisher
+	topic     string
+
+	interval     time.Duration
+	batchSize    int
+	grace        time.Duration
+	releaseAfter time.Duration
+	dryRun       bool
+
+	log *zerolog.Logger
+	now func() time.Time
+}
+
+const (
+	DefaultInterval  = time.Minute
+	DefaultBatchSize = 1000
+)
+
+type reaperOption func(r *Reaper) error
+
+func WithUrls(u Urls) reaperOption {
+	return func(r *Reaper) error {
+		r.urls = u
+		return nil
+	}
+}
+
+// WithInterval sets the time between passes
+func WithInterval(d time.Duration) reaperOption {
+	return func(r *Reaper) error {
+		if d <= 0 {
+			return errors.New("interval must be positive")
+		}
+		r.interval = d
+		return nil
+	}
+}
+
+// WithBatchSize limits links moved in one transaction
+func WithBatchSize(n int) reaperOption {
+	return func(r *Reaper) error {
+		if n < 1 {
+			return errors.New("batch size must be positive")
+		}
+		r.batchSize = n
+		return nil
+	}
+}
+
+// WithGrace keeps links in place for some time after their expiration
+func WithGrace(d time.Duration) reaperOption {
+	return func(r *Reaper) error {
+		if d < 0 {
+			return errors.New("grace must not be negative")
+		}
+		r.grace = d
+		return nil
+	}
+}
+
+// WithReleaseAfter releases short urls of links archived for the time.
+// Zero, the default, never releases them.
+func WithReleaseAfter(d time.Duration) reaperOption {
+	return func(r *Reaper) error {
+		if d < 0 {
+			return errors.New("release cool-down must not be negative")
+		}
+		r.releaseAfter = d
+		return nil
+	}
+}
+
+// WithDryRun only counts and logs what would be archived and released
+func WithDryRun(dryRun bool) reaperOption {
+	return func(r *Reaper) error {
+		r.dryRun = dryRun
+		return nil
+	}
+}
+
+// WithPublisher publishes LinkExpired events of archived links to the
+// topic. A nil publisher publishes nothing.
+func WithPublisher(p bus.Publisher, topic string) reaperOption {
+	return func(r *Reaper) error {
+		if topic == "" {
+			return errors.New("no expirations topic provided")
+		}
+		r.publisher = p
+		r.topic = topic
+		return nil
+	}
+}
+
+func WithLogger(l *zerolog.Logger) reaperOption {
+	return func(r *Reaper) error {
+		r.log = l
+		return nil
+	}
+}
+
+func New(opts ...reaperOption) (*Reaper, error) {
+	r := &Reaper{
+		interval:  DefaultInterval,
+		batchSize: DefaultBatchSize,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.urls == nil {
+		return nil, errors.New("no urls model provided")
+	}
+	if r.log == nil {
+		return nil, errors.New("no logger provided")
+	}
+	return r, nil
+}
+
+// Result of a pass. In dry-run mode it holds what would have been done.
+type Result struct {
+	Archived int64
+	Released int64
+}
+
+// Run makes passes until the context is cancelled
+func (r *Reaper) Run(ctx context.Context) {
+	for {
+		if _, err := r.Pass(ctx); err != nil && ctx.Err() == nil {
+			r.log.Error().Err(err).Msg("reaper pass failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// Pass archives the links expired by now in batches, then releases the
+// short urls past the cool-down. Batches already written are kept if the
+// pass fails or is cancelled.
+func (r *Reaper) Pass(ctx context.Context) (Result, error) {
+	res, err := r.pass(ctx)
+	if err != nil {
+		passes.WithLabelValues("failure").Inc()
+		return res, err
+	}
+	passes.WithLabelValues("success").Inc()
+	lastSuccess.SetToCurrentTime()
+	return res, nil
+}
+
+func (r *Reaper) pass(ctx context.Context) (Result, error) {
+	var res Result
+	now := r.now()
+	expiredBefore := now.Add(-r.grace)
+	archivedBefore := now.Add(-r.releaseAfter)
+
+	if r.dryRun {
+		n, err := r.urls.CountExpired(ctx, expiredBefore)
+		if err != nil {
+			return res, err
+		}
+		res.Archived = n
+		expiredLinks.Set(float64(n))
+
+		if r.releaseAfter > 0 {
+			n, err := r.urls.CountReleasable(ctx, archivedBefore)
+			if err != nil {
+				return res, err
+			}
+			res.Released = n
+			releasableShortUrls.Set(float64(n))
+		}
+		r.log.Info().
+			Int64("archivable", res.Archived).
+			Int64("releasable", res.Released).
+			Msg("dry run: nothing has been changed")
+		return res, nil
+	}
+
+	for ctx.Err() == nil {
+		shortUrls, err := r.urls.ArchiveExpired(ctx, expiredBefore, r.batchSize)
+		if err != nil {
+			return res, err
+		}
+		res.Archived += int64(len(shortUrls))
+		archivedLinks.Add(float64(len(shortUrls)))
+		r.publishExpired(ctx, shortUrls, now)
+		if len(shortUrls) < r.batchSize {
+			break
+		}
+	}
+
+	for r.releaseAfter > 0 && ctx.Err() == nil {
+		n, err := r.urls.ReleaseShortUrls(ctx, archivedBefore, r.batchSize)
+		if err != nil {
+			return res, err
+		}
+		res.Released += n
+		releasedShortUrls.Add(float64(n))
+		if n < int64(r.batchSize) {
+			break
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	// links that have expired while the pass has been running are left for
+	// the next one
+	n, err := r.urls.CountExpired(ctx, r.now().Add(-r.grace))
+	if err != nil {
+		return res, err
+	}
+	expiredLinks.Set(float64(n))
+	if r.releaseAfter > 0 {
+		n, err := r.urls.CountReleasable(ctx, archivedBefore)
+		if err != nil {
+			return res, err
+		}
+		releasableShortUrls.Set(float64(n))
+	}
+
+	if res.Archived > 0 || res.Released > 0 {
+		r.log.Info().
+			Int64("archived", res.Archived).
+			Int64("released", res.Released).
+			Msg("reaped expired links")
+	}
+	return res, nil
+}
+
+// publishExpired publishes expirations of the archived links. The links
+// stay archived if that fails, their events are lost then.
+func (r *Reaper) publishExpired(ctx context.Context, shortUrls []string, at time.Time) {
+	if r.publisher == nil || len(shortUrls) == 0 {
+		return
+	}
+	mm := make([]*bus.Message, 0, len(shortUrls))
+	for _, shortUrl := range shortUrls {
+		value, contentType, err := events.Encode(&eventsv1.LinkExpired{
+			IdempotencyKey: uuid.New().String(),
+			ShortUrl:       shortUrl,
+			ArchivedAt:     timestamppb.New(at),
+		})
+		if err != nil {
+			r.log.Error().Err(err).Msg("couldn't encode expiration")
+			unpublishedExpirations.Inc()
+			continue
+		}
+		mm = append(mm, &bus.Message{
+			Topic:   r.topic,
+			Key:     []byte(shortUrl),
+			Value:   value,
+			Headers: map[string]string{events.HeaderContentType: contentType},
+		})
+	}
+
+	err := r.publisher.Publish(ctx, mm...)
+	if err == nil {
+		return
+	}
+	var perr bus.PublishErrors
+	if errors.As(err, &perr) {
+		unpublishedExpirations.Add(float64(len(perr)))
+	} else {
+		unpublishedExpirations.Add(float64(len(mm)))
+	}
+	r.log.Error().Err(err).Msg("couldn't publish expirations")
+}
@@ -0,0 +1,129 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/bus"
+	"shortener/pkg/domain"
+	"shortener/pkg/events"
+	"testing"
+	"time"
+
+	bus_mocks "shortener/mocks/shortener/pkg/bus"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func newReaper(t *testing.T, u Urls, opts ...reaperOption) *Reaper {
+	log := zerolog.Nop()
+	opts = append([]reaperOption{WithUrls(u), WithLogger(&log)}, opts...)
+	r, err := New(opts...)
+	assert.Nil(t, err)
+	r.now = func() time.Time { return now }
+	return r
+}
+
+func TestPassArchivesUntilShortBatch(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().ArchiveExpired(context.TODO(), now, 2).Return([]string{"a", "b"}, nil).Once()
+	u.EXPECT().ArchiveExpired(context.TODO(), now, 2).Return([]string{"c"}, nil).Once()
+	u.EXPECT().CountExpired(context.TODO(), now).Return(0, nil)
+
+	r := newReaper(t, u, WithBatchSize(2))
+	res, err := r.Pass(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Archived: 3}, res)
+}
+
+func TestPassPublishesExpirations(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().ArchiveExpired(context.TODO(), now, 2).Return([]string{"a", "b"}, nil).Once()
+	u.EXPECT().ArchiveExpired(context.TODO(), now, 2).Return(nil, nil).Once()
+	u.EXPECT().CountExpired(context.TODO(), now).Return(0, nil)
+
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().
+		Publish(context.TODO(), mock.Anything, mock.Anything).
+		RunAndReturn(func(_ context.Context, mm ...*bus.Message) error {
+			for i, code := range []string{"a", "b"} {
+				e, err := events.DecodeLinkEvent(mm[i])
+				assert.Nil(t, err)
+				assert.Equal(t, "link-events", mm[i].Topic)
+				assert.Equal(t, domain.EventLinkExpired, e.Type)
+				assert.Equal(t, code, e.ShortUrl)
+				assert.True(t, e.OccurredAt.Equal(now))
+			}
+			return errors.New("bus is down")
+		}).
+		Once()
+
+	// links stay archived when their events can't be published
+	r := newReaper(t, u, WithBatchSize(2), WithPublisher(p, "link-events"))
+	res, err := r.Pass(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Archived: 2}, res)
+}
+
+func TestPassHonoursGraceAndReleases(t *testing.T) {
+	u := NewMockUrls(t)
+	expiredBefore := now.Add(-time.Hour)
+	archivedBefore := now.Add(-24 * time.Hour)
+	u.EXPECT().ArchiveExpired(context.TODO(), expiredBefore, 10).Return(nil, nil)
+	u.EXPECT().ReleaseShortUrls(context.TODO(), archivedBefore, 10).Return(10, nil).Once()
+	u.EXPECT().ReleaseShortUrls(context.TODO(), archivedBefore, 10).Return(4, nil).Once()
+	u.EXPECT().CountExpired(context.TODO(), expiredBefore).Return(0, nil)
+	u.EXPECT().CountReleasable(context.TODO(), archivedBefore).Return(0, nil)
+
+	r := newReaper(
+		t,
+		u,
+		WithBatchSize(10),
+		WithGrace(time.Hour),
+		WithReleaseAfter(24*time.Hour),
+	)
+	res, err := r.Pass(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Released: 14}, res)
+}
+
+func TestPassDryRunOnlyCounts(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().CountExpired(context.TODO(), now).Return(7, nil)
+	u.EXPECT().CountReleasable(context.TODO(), now.Add(-time.Hour)).Return(3, nil)
+
+	r := newReaper(t, u, WithDryRun(true), WithReleaseAfter(time.Hour))
+	res, err := r.Pass(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Archived: 7, Released: 3}, res)
+}
+
+func TestPassKeepsArchivedOnError(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().ArchiveExpired(context.TODO(), now, 1).Return([]string{"a"}, nil).Once()
+	u.EXPECT().ArchiveExpired(context.TODO(), now, 1).Return(nil, errors.New("boom")).Once()
+
+	r := newReaper(t, u, WithBatchSize(1))
+	res, err := r.Pass(context.TODO())
+	assert.NotNil(t, err)
+	assert.Equal(t, Result{Archived: 1}, res)
+}
+
+func TestNewValidatesOptions(t *testing.T) {
+	log := zerolog.Nop()
+	u := NewMockUrls(t)
+
+	_, err := New(WithLogger(&log))
+	assert.NotNil(t, err)
+	_, err = New(WithUrls(u))
+	assert.NotNil(t, err)
+	_, err = New(WithUrls(u), WithLogger(&log), WithBatchSize(0))
+	assert.NotNil(t, err)
+	_, err = New(WithUrls(u), WithLogger(&log), WithInterval(0))
+	assert.NotNil(t, err)
+	_, err = New(WithUrls(u), WithLogger(&log), WithReleaseAfter(-time.Second))
+	assert.NotNil(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"shortener/pkg/domain"
 	"shortener/pkg/models/urls"
 	"strings"
 
@@ -14,8 +15,22 @@ type Urls interface {
 	GetLongUrl(ctx context.Context, shortUrl string) (string, error)
 }
 
+// AutoRenewer renews links with auto-renewal when they are clicked shortly
+// before expiring, see renewal.AutoRenewer. It must not block.
+type AutoRenewer interface {
+	Clicked(shortUrl string)
+}
+
+// ClickReporter publishes clicks on links, see clicks.Reporter. It must not
+// block.
+type ClickReporter interface {
+	Clicked(shortUrl string)
+}
+
 type Redirector struct {
-	urls Urls
+	urls        Urls
+	autoRenewer AutoRenewer
+	clicks      ClickReporter
 }
 
 type redirectorOption func(r *Redirector) error
@@ -27,6 +42,22 @@ func WithUrlsModel(u Urls) redirectorOption {
 	}
 }
 
+// WithAutoRenewer reports clicks for auto-renewal
+func WithAutoRenewer(a AutoRenewer) redirectorOption {
+	return func(r *Redirector) error {
+		r.autoRenewer = a
+		return nil
+	}
+}
+
+// WithClickReporter reports clicks to webhooks of their links
+func WithClickReporter(c ClickReporter) redirectorOption {
+	return func(r *Redirector) error {
+		r.clicks = c
+		return nil
+	}
+}
+
 func New(opts ...redirectorOption) (*Redirector, error) {
 	r := new(Redirector)
 	for _, opt := range opts {
@@ -45,7 +76,7 @@ func (re *Redirector) Redirect(w http.ResponseWriter, r *http.Request) {
 	log.Info().Msg("got redirection request")
 
 	shortUrl := strings.TrimLeft(r.URL.Path, "/")
-	if len(shortUrl) != 5 {
+	if !domain.ValidShortUrl(shortUrl) {
 		http.NotFound(w, r)
 		return
 	}
@@ -53,7 +84,16 @@ func (re *Redirector) Redirect(w http.ResponseWriter, r *http.Request) {
 	log.Info().Msg("querying database for long url")
 	longUrl, err := re.urls.GetLongUrl(context.TODO(), shortUrl)
 	if err == nil {
-		http.Redirect(w, r, longUrl, http.StatusMovedPermanently)
+		if re.autoRenewer != nil {
+			re.autoRenewer.Clicked(shortUrl)
+		}
+		if re.clicks != nil {
+			re.clicks.Clicked(shortUrl)
+		}
+		// browsers keep permanent redirects, and their clicks wouldn't
+		// reach auto-renewal and webhooks
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, longUrl, http.StatusFound)
 		return
 	}
 	if !errors.Is(err, urls.ErrNotFound) {
@@ -26,12 +26,14 @@ func TestRedirectionOk(t *testing.T) {
 	r.Redirect(recorder, req)
 	rsp := recorder.Result()
 
-	assert.Equal(t, rsp.StatusCode, http.StatusMovedPermanently)
+	// redirects aren't cached, so that every click reaches the redirector
+	assert.Equal(t, http.StatusFound, rsp.StatusCode)
+	assert.Equal(t, "no-store", rsp.Header.Get("Cache-Control"))
 }
 
 func TestRedirectionUrlTooLong(t *testing.T) {
 	u := NewMockUrls(t)
-	shortUrl := "1234567"
+	shortUrl := "123456789012345678901234567890123"
 
 	r, err := New(WithUrlsModel(u))
 	assert.Nil(t, err)
@@ -64,3 +66,22 @@ func TestRedirectionUrlNotFound(t *testing.T) {
 
 	assert.Equal(t, rsp.StatusCode, http.StatusNotFound)
 }
+
+func TestRedirectionReportsClick(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().GetLongUrl(context.TODO(), "12345").Return("long_url", nil)
+	u.EXPECT().GetLongUrl(context.TODO(), "other").Return("", urls.ErrNotFound)
+	a := NewMockAutoRenewer(t)
+	a.EXPECT().Clicked("12345").Return().Once()
+	c := NewMockClickReporter(t)
+	c.EXPECT().Clicked("12345").Return().Once()
+
+	r, err := New(WithUrlsModel(u), WithAutoRenewer(a), WithClickReporter(c))
+	assert.Nil(t, err)
+
+	for _, shortUrl := range []string{"12345", "other"} {
+		req, err := http.NewRequest("GET", "/"+shortUrl, nil)
+		assert.Nil(t, err)
+		r.Redirect(httptest.NewRecorder(), req)
+	}
+}
@@ -0,0 +1,16 @@
+package redirector
+
+import (
+	"net/http"
+	"shortener/pkg/middleware"
+
+	"github.com/justinas/alice"
+)
+
+// Mount registers the routes of the service on the mux. Requests are handled
+// with the chain, e.g. middleware.RequestTracing. On a shared mux GET requests
+// that no other route matches are taken for short links. Redirects aren't
+// requested by scripts, so cors isn't used.
+func (re *Redirector) Mount(mux *http.ServeMux, c alice.Chain, _ middleware.Cors) {
+	mux.Handle("GET /", c.ThenFunc(re.Redirect))
+}
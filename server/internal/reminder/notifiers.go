@@ -0,0 +1,114 @@
+package reminder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"shortener/pkg/egress"
+	"strings"
+	"time"
+)
+
+type Mailer interface {
+	Send(ctx context.Context, to string, subject string, body string) error
+}
+
+// MailNotifier sends digests by email, e.g. with mailer.Log to only log them
+type MailNotifier struct {
+	mailer         Mailer
+	redirectorHost string
+}
+
+func NewMailNotifier(m Mailer, redirectorHost string) (*MailNotifier, error) {
+	if m == nil {
+		return nil, errors.New("no mailer provided")
+	}
+	if redirectorHost == "" {
+		return nil, errors.New("no redirector host provided")
+	}
+	return &MailNotifier{mailer: m, redirectorHost: redirectorHost}, nil
+}
+
+func (n *MailNotifier) Notify(ctx context.Context, d *Digest) error {
+	var body strings.Builder
+	if d.Name != "" {
+		fmt.Fprintf(&body, "Hi, %s!\n\n", d.Name)
+	}
+	body.WriteString("The following links expire soon:\n\n")
+	for _, l := range d.Links {
+		fmt.Fprintf(
+			&body,
+			"%s/%s -> %s, expires on %s\n",
+			n.redirectorHost,
+			l.ShortUrl,
+			l.LongUrl,
+			l.ExpirationDate.UTC().Format(time.DateTime+" MST"),
+		)
+	}
+	body.WriteString("\nRenew them to keep them working.\n")
+
+	subject := fmt.Sprintf("%d of your links expire soon", len(d.Links))
+	if len(d.Links) == 1 {
+		subject = "Your link expires soon"
+	}
+	return n.mailer.Send(ctx, d.Email, subject, body.String())
+}
+
+// WebhookNotifier posts digests as JSON to the webhook url of the user.
+// Only public hosts are posted to, see egress.NewClient.
+type WebhookNotifier struct {
+	client *http.Client
+}
+
+func NewWebhookNotifier(timeout time.Duration) *WebhookNotifier {
+	return &WebhookNotifier{client: egress.NewClient(timeout)}
+}
+
+type webhookLink struct {
+	ShortUrl       string    `json:"short_url"`
+	LongUrl        string    `json:"long_url"`
+	ExpirationDate time.Time `json:"expiration_date"`
+	Days           int       `json:"days"`
+}
+
+type webhookDigest struct {
+	UserId string        `json:"user_id"`
+	Links  []webhookLink `json:"links"`
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, d *Digest) error {
+	if d.WebhookUrl == "" {
+		return errors.New("user has no webhook url")
+	}
+	payload := webhookDigest{UserId: d.UserId}
+	for _, l := range d.Links {
+		payload.Links = append(payload.Links, webhookLink{
+			ShortUrl:       l.ShortUrl,
+			LongUrl:        l.LongUrl,
+			ExpirationDate: l.ExpirationDate,
+			Days:           l.Days,
+		})
+	}
+	body, err := json.Marshal(&payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", d.WebhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", rsp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+// Package reminder warns users about their links expiring soon. Links due
+// for a reminder are collected into a digest per user, which is sent over
+// the channel the user has chosen.
+package reminder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"shortener/pkg/domain"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+)
+
+var (
+	remindedLinks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reminder_links_total",
+		Help: "Expiring links users have been reminded about, by channel and result: sent or failed.",
+	}, []string{"channel", "result"})
+	digests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reminder_digests_total",
+		Help: "Digests of expiring links, by channel and result: sent or failed.",
+	}, []string{"channel", "result"})
+	passes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reminder_passes_total",
+		Help: "Passes of the reminder scheduler by result: success or failure.",
+	}, []string{"result"})
+)
+
+type Reminders interface {
+	Due(
+		ctx context.Context,
+		now time.Time,
+		defaultDays []int,
+		limit int,
+	) ([]*domain.Reminder, error)
+	Claim(ctx context.Context, rr []*domain.Reminder) ([]*domain.Reminder, error)
+	Release(ctx context.Context, rr []*domain.Reminder) error
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Digest lists links of a user expiring soon
+type Digest struct {
+	UserId     string
+	Email      string
+	Name       string
+	WebhookUrl string
+	Links      []*domain.Reminder
+}
+
+// Notifier sends digests over a channel, e.g. MailNotifier
+type Notifier interface {
+	Notify(ctx context.Context, d *Digest) error
+}
+
+type Scheduler struct {
+	reminders Reminders
+	notifiers map[domain.NotificationChannel]Notifier
+
+	interval    time.Duration
+	batchSize   int
+	defaultDays []int
+
+	log *zerolog.Logger
+	now func() time.Time
+}
+
+const (
+	DefaultInterval  = 5 * time.Minute
+	DefaultBatchSize = 1000
+)
+
+type schedulerOption func(s *Scheduler) error
+
+func WithReminders(r Reminders) schedulerOption {
+	return func(s *Scheduler) error {
+		s.reminders = r
+		return nil
+	}
+}
+
+// WithNotifier sends digests of users who have chosen the channel with n
+func WithNotifier(channel domain.NotificationChannel, n Notifier) schedulerOption {
+	return func(s *Scheduler) error {
+		if n == nil {
+			return fmt.Errorf("no notifier provided for %s", channel)
+		}
+		s.notifiers[channel] = n
+		return nil
+	}
+}
+
+func WithInterval(d time.Duration) schedulerOption {
+	return func(s *Scheduler) error {
+		if d <= 0 {
+			return errors.New("interval must be positive")
+		}
+		s.interval = d
+		return nil
+	}
+}
+
+// WithBatchSize limits reminders claimed at once
+func WithBatchSize(n int) schedulerOption {
+	return func(s *Scheduler) error {
+		if n < 1 {
+			return errors.New("batch size must be positive")
+		}
+		s.batchSize = n
+		return nil
+	}
+}
+
+// WithDefaultDays sets days before expiration users without preferences are
+// reminded at
+func WithDefaultDays(days []int) schedulerOption {
+	return func(s *Scheduler) error {
+		for _, d := range days {
+			if d < 1 {
+				return errors.New("reminder days must be positive")
+			}
+		}
+		s.defaultDays = days
+		return nil
+	}
+}
+
+func WithLogger(l *zerolog.Logger) schedulerOption {
+	return func(s *Scheduler) error {
+		s.log = l
+		return nil
+	}
+}
+
+func New(opts ...schedulerOption) (*Scheduler, error) {
+	s := &Scheduler{
+		notifiers:   map[domain.NotificationChannel]Notifier{},
+		interval:    DefaultInterval,
+		batchSize:   DefaultBatchSize,
+		defaultDays: []int{7, 1},
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.reminders == nil {
+		return nil, errors.New("no reminders model provided")
+	}
+	if len(s.notifiers) == 0 {
+		return nil, errors.New("no notifiers provided")
+	}
+	if s.log == nil {
+		return nil, errors.New("no logger provided")
+	}
+	return s, nil
+}
+
+// Result of a pass
+type Result struct {
+	// links reminded about
+	Sent int
+	// links which digests couldn't be sent. They are due again.
+	Failed int
+}
+
+// Run makes passes until the context is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		if _, err := s.Pass(ctx); err != nil && ctx.Err() == nil {
+			s.log.Error().Err(err).Msg("reminder pass failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// Pass sends digests of the links due for a reminder in batches. It stops
+// early if some digests couldn't be sent, they are retried by the next one.
+func (s *Scheduler) Pass(ctx context.Context) (Result, error) {
+	res, err := s.pass(ctx)
+	if err != nil {
+		passes.WithLabelValues("failure").Inc()
+		return res, err
+	}
+	passes.WithLabelValues("success").Inc()
+	if res.Sent > 0 || res.Failed > 0 {
+		s.log.Info().
+			Int("sent", res.Sent).
+			Int("failed", res.Failed).
+			Msg("reminded about expiring links")
+	}
+	return res, nil
+}
+
+func (s *Scheduler) pass(ctx context.Context) (Result, error) {
+	var res Result
+	now := s.now()
+	if _, err := s.reminders.Prune(ctx, now); err != nil {
+		return res, fmt.Errorf("couldn't prune reminders: %w", err)
+	}
+
+	for ctx.Err() == nil {
+		due, err := s.reminders.Due(ctx, now, s.defaultDays, s.batchSize)
+		if err != nil {
+			return res, err
+		}
+		if len(due) == 0 {
+			break
+		}
+		claimed, err := s.reminders.Claim(ctx, due)
+		if err != nil {
+			return res, err
+		}
+
+		released := false
+		for _, d := range digestsOf(claimed) {
+			err := s.notify(ctx, d)
+			switch {
+			case errors.Is(err, errNoNotifier):
+				res.Failed += len(d.Links)
+			case err != nil:
+				res.Failed += len(d.Links)
+				released = true
+			default:
+				res.Sent += len(d.Links)
+			}
+		}
+		// released reminders would be due again right away
+		if released || len(due) < s.batchSize {
+			break
+		}
+	}
+	return res, ctx.Err()
+}
+
+var errNoNotifier = errors.New("no notifier for the channel")
+
+// notify sends the digest. Reminders are released if it couldn't be sent,
+// unless there is no notifier for the channel.
+func (s *Scheduler) notify(ctx context.Context, d *Digest) error {
+	channel := d.Links[0].Channel
+	log := s.log.With().
+		Str("user_id", d.UserId).
+		Str("channel", string(channel)).
+		Logger()
+
+	n, ok := s.notifiers[channel]
+	if !ok {
+		// retrying won't help, the reminders stay claimed
+		log.Error().Msg("no notifier for the channel")
+		remindedLinks.WithLabelValues(string(channel), "failed").Add(float64(len(d.Links)))
+		digests.WithLabelValues(string(channel), "failed").Inc()
+		return errNoNotifier
+	}
+
+	err := n.Notify(ctx, d)
+	if err != nil {
+		log.Error().Err(err).Int("links", len(d.Links)).Msg("couldn't send digest")
+		remindedLinks.WithLabelValues(string(channel), "failed").Add(float64(len(d.Links)))
+		digests.WithLabelValues(string(channel), "failed").Inc()
+		if err := s.reminders.Release(context.WithoutCancel(ctx), d.Links); err != nil {
+			log.Error().Err(err).Msg("couldn't release reminders")
+		}
+		return err
+	}
+	remindedLinks.WithLabelValues(string(channel), "sent").Add(float64(len(d.Links)))
+	digests.WithLabelValues(string(channel), "sent").Inc()
+	return nil
+}
+
+// digestsOf groups reminders ordered by user into digests
+func digestsOf(rr []*domain.Reminder) []*Digest {
+	var res []*Digest
+	for _, r := range rr {
+		if len(res) == 0 || res[len(res)-1].UserId != r.UserId {
+			res = append(res, &Digest{
+				UserId:     r.UserId,
+				Email:      r.Email,
+				Name:       r.Name,
+				WebhookUrl: r.WebhookUrl,
+			})
+		}
+		d := res[len(res)-1]
+		d.Links = append(d.Links, r)
+	}
+	return res
+}
@@ -0,0 +1,285 @@
+package reminder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"shortener/pkg/domain"
+	"shortener/pkg/egress"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func reminder(code, userId string, channel domain.NotificationChannel) *domain.Reminder {
+	return &domain.Reminder{
+		ShortUrl:       code,
+		LongUrl:        "https://example.com/" + code,
+		ExpirationDate: now.Add(24 * time.Hour),
+		Days:           1,
+		UserId:         userId,
+		Email:          userId + "@example.com",
+		Channel:        channel,
+	}
+}
+
+func newScheduler(t *testing.T, r Reminders, opts ...schedulerOption) *Scheduler {
+	log := zerolog.Nop()
+	s, err := New(append([]schedulerOption{
+		WithReminders(r),
+		WithLogger(&log),
+	}, opts...)...)
+	assert.Nil(t, err)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestPassSendsDigestPerUser(t *testing.T) {
+	due := []*domain.Reminder{
+		reminder("aaaaa", "first", domain.ChannelEmail),
+		reminder("bbbbb", "first", domain.ChannelEmail),
+		reminder("ccccc", "second", domain.ChannelWebhook),
+	}
+	r := NewMockReminders(t)
+	r.EXPECT().Prune(mock.Anything, now).Return(0, nil)
+	r.EXPECT().Due(mock.Anything, now, []int{7, 1}, 10).Return(due, nil)
+	r.EXPECT().Claim(mock.Anything, due).Return(due, nil)
+
+	email := NewMockNotifier(t)
+	email.EXPECT().
+		Notify(mock.Anything, mock.MatchedBy(func(d *Digest) bool {
+			return d.UserId == "first" && len(d.Links) == 2
+		})).
+		Return(nil)
+	webhook := NewMockNotifier(t)
+	webhook.EXPECT().
+		Notify(mock.Anything, mock.MatchedBy(func(d *Digest) bool {
+			return d.UserId == "second" && len(d.Links) == 1
+		})).
+		Return(nil)
+
+	s := newScheduler(
+		t,
+		r,
+		WithNotifier(domain.ChannelEmail, email),
+		WithNotifier(domain.ChannelWebhook, webhook),
+		WithBatchSize(10),
+	)
+	res, err := s.Pass(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Sent: 3}, res)
+}
+
+func TestPassSkipsClaimedReminders(t *testing.T) {
+	due := []*domain.Reminder{
+		reminder("aaaaa", "first", domain.ChannelEmail),
+		reminder("bbbbb", "first", domain.ChannelEmail),
+	}
+	r := NewMockReminders(t)
+	r.EXPECT().Prune(mock.Anything, now).Return(0, nil)
+	r.EXPECT().Due(mock.Anything, now, []int{7, 1}, 10).Return(due, nil)
+	r.EXPECT().Claim(mock.Anything, due).Return(due[1:], nil)
+
+	email := NewMockNotifier(t)
+	email.EXPECT().
+		Notify(mock.Anything, mock.MatchedBy(func(d *Digest) bool {
+			return len(d.Links) == 1 && d.Links[0].ShortUrl == "bbbbb"
+		})).
+		Return(nil)
+
+	s := newScheduler(t, r, WithNotifier(domain.ChannelEmail, email), WithBatchSize(10))
+	res, err := s.Pass(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Sent: 1}, res)
+}
+
+func TestPassGoesOnWithFullBatches(t *testing.T) {
+	first := []*domain.Reminder{reminder("aaaaa", "first", domain.ChannelEmail)}
+	second := []*domain.Reminder{}
+	r := NewMockReminders(t)
+	r.EXPECT().Prune(mock.Anything, now).Return(0, nil)
+	r.EXPECT().Due(mock.Anything, now, []int{7, 1}, 1).Return(first, nil).Once()
+	r.EXPECT().Due(mock.Anything, now, []int{7, 1}, 1).Return(second, nil).Once()
+	r.EXPECT().Claim(mock.Anything, first).Return(first, nil)
+
+	email := NewMockNotifier(t)
+	email.EXPECT().Notify(mock.Anything, mock.Anything).Return(nil)
+
+	s := newScheduler(t, r, WithNotifier(domain.ChannelEmail, email), WithBatchSize(1))
+	res, err := s.Pass(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Sent: 1}, res)
+}
+
+func TestPassReleasesRemindersNotSent(t *testing.T) {
+	due := []*domain.Reminder{reminder("aaaaa", "first", domain.ChannelEmail)}
+	r := NewMockReminders(t)
+	r.EXPECT().Prune(mock.Anything, now).Return(0, nil)
+	// a full batch, but the pass stops after the failure
+	r.EXPECT().Due(mock.Anything, now, []int{7, 1}, 1).Return(due, nil).Once()
+	r.EXPECT().Claim(mock.Anything, due).Return(due, nil)
+	r.EXPECT().Release(mock.Anything, due).Return(nil)
+
+	email := NewMockNotifier(t)
+	email.EXPECT().Notify(mock.Anything, mock.Anything).Return(errors.New("smtp is down"))
+
+	s := newScheduler(t, r, WithNotifier(domain.ChannelEmail, email), WithBatchSize(1))
+	res, err := s.Pass(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Failed: 1}, res)
+}
+
+func TestPassKeepsRemindersWithoutNotifier(t *testing.T) {
+	due := []*domain.Reminder{reminder("aaaaa", "first", domain.ChannelWebhook)}
+	r := NewMockReminders(t)
+	r.EXPECT().Prune(mock.Anything, now).Return(0, nil)
+	r.EXPECT().Due(mock.Anything, now, []int{7, 1}, 10).Return(due, nil)
+	r.EXPECT().Claim(mock.Anything, due).Return(due, nil)
+
+	s := newScheduler(
+		t,
+		r,
+		WithNotifier(domain.ChannelEmail, NewMockNotifier(t)),
+		WithBatchSize(10),
+	)
+	res, err := s.Pass(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Failed: 1}, res)
+}
+
+func TestDigestsOf(t *testing.T) {
+	dd := digestsOf([]*domain.Reminder{
+		reminder("aaaaa", "first", domain.ChannelEmail),
+		reminder("bbbbb", "second", domain.ChannelEmail),
+		reminder("ccccc", "second", domain.ChannelEmail),
+	})
+	assert.Len(t, dd, 2)
+	assert.Equal(t, "first@example.com", dd[0].Email)
+	assert.Len(t, dd[0].Links, 1)
+	assert.Equal(t, "second", dd[1].UserId)
+	assert.Len(t, dd[1].Links, 2)
+}
+
+func TestNewValidatesOptions(t *testing.T) {
+	log := zerolog.Nop()
+	r := NewMockReminders(t)
+	n := NewMockNotifier(t)
+
+	_, err := New(WithNotifier(domain.ChannelEmail, n), WithLogger(&log))
+	assert.NotNil(t, err)
+	_, err = New(WithReminders(r), WithLogger(&log))
+	assert.NotNil(t, err)
+	_, err = New(WithReminders(r), WithNotifier(domain.ChannelEmail, n))
+	assert.NotNil(t, err)
+	_, err = New(
+		WithReminders(r),
+		WithNotifier(domain.ChannelEmail, n),
+		WithLogger(&log),
+		WithDefaultDays([]int{0}),
+	)
+	assert.NotNil(t, err)
+	_, err = New(
+		WithReminders(r),
+		WithNotifier(domain.ChannelEmail, n),
+		WithLogger(&log),
+		WithBatchSize(0),
+	)
+	assert.NotNil(t, err)
+}
+
+type sentMail struct {
+	to, subject, body string
+}
+
+type mailerFunc func(ctx context.Context, to, subject, body string) error
+
+func (f mailerFunc) Send(ctx context.Context, to, subject, body string) error {
+	return f(ctx, to, subject, body)
+}
+
+func TestMailNotifier(t *testing.T) {
+	var sent sentMail
+	n, err := NewMailNotifier(mailerFunc(func(_ context.Context, to, subject, body string) error {
+		sent = sentMail{to, subject, body}
+		return nil
+	}), "localhost:8083")
+	assert.Nil(t, err)
+
+	err = n.Notify(context.TODO(), &Digest{
+		UserId: "first",
+		Email:  "first@example.com",
+		Links: []*domain.Reminder{
+			reminder("aaaaa", "first", domain.ChannelEmail),
+			reminder("bbbbb", "first", domain.ChannelEmail),
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "first@example.com", sent.to)
+	assert.Equal(t, "2 of your links expire soon", sent.subject)
+	assert.True(t, strings.Contains(sent.body, "localhost:8083/aaaaa -> https://example.com/aaaaa"))
+	assert.True(t, strings.Contains(sent.body, "localhost:8083/bbbbb"))
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got webhookDigest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	n := NewWebhookNotifier(time.Second)
+	// the test server listens on the loopback
+	n.client.Transport = server.Client().Transport
+	err := n.Notify(context.TODO(), &Digest{
+		UserId:     "first",
+		WebhookUrl: server.URL,
+		Links:      []*domain.Reminder{reminder("aaaaa", "first", domain.ChannelWebhook)},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "first", got.UserId)
+	assert.Len(t, got.Links, 1)
+	assert.Equal(t, "aaaaa", got.Links[0].ShortUrl)
+	assert.Equal(t, 1, got.Links[0].Days)
+}
+
+func TestWebhookNotifierFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	n := NewWebhookNotifier(time.Second)
+	// the test server listens on the loopback
+	n.client.Transport = server.Client().Transport
+	err := n.Notify(context.TODO(), &Digest{
+		UserId:     "first",
+		WebhookUrl: server.URL,
+		Links:      []*domain.Reminder{reminder("aaaaa", "first", domain.ChannelWebhook)},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestWebhookNotifierRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("digest has been posted to the loopback")
+	}))
+	defer server.Close()
+
+	n := NewWebhookNotifier(time.Second)
+	err := n.Notify(context.TODO(), &Digest{
+		UserId:     "first",
+		WebhookUrl: server.URL,
+		Links:      []*domain.Reminder{reminder("aaaaa", "first", domain.ChannelWebhook)},
+	})
+	assert.ErrorIs(t, err, egress.ErrNotPublic)
+}
@@ -0,0 +1,642 @@
+package shortener
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"shortener/pkg/bus"
+	"shortener/pkg/domain"
+	"shortener/pkg/models/bulk"
+	"shortener/pkg/responses"
+	"shortener/proto/blackbox"
+	eventsv1 "shortener/proto/events/v1"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	bulkRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shortener_bulk_requests_total",
+		Help: "Bulk uploads by mode: created in the request, or queued as jobs.",
+	}, []string{"mode"})
+	bulkLinks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shortener_bulk_links_total",
+		Help: "Rows of bulk uploads by result: created or failed.",
+	}, []string{"result"})
+)
+
+// BulkJobs keeps jobs of bulk uploads, see bulk.Model
+type BulkJobs interface {
+	Create(ctx context.Context, j *domain.BulkJob) error
+	Job(ctx context.Context, id string) (*domain.BulkJob, error)
+}
+
+const (
+	DefaultBulkSyncLimit = 1000
+	DefaultBulkMaxRows   = 50000
+	// rows are checked, allocated and published in batches of the size
+	bulkBatchSize = 1000
+	// bodies of uploads are limited to the size per row
+	bulkRowBytes = 4 << 10
+	// rounds of generating short urls for a batch before it fails
+	allocationRounds = 10
+)
+
+// WithBulkJobs creates links of bulk uploads of more rows than the sync limit
+// with jobs, see Importer, and serves the routes of the jobs. Without it
+// every upload is created in its request.
+func WithBulkJobs(j BulkJobs, syncLimit int) shortenerOption {
+	return func(s *Shortener) error {
+		if syncLimit < 0 {
+			return errors.New("bulk sync limit must not be negative")
+		}
+		s.bulkJobs = j
+		s.bulkSyncLimit = syncLimit
+		return nil
+	}
+}
+
+// WithBulkMaxRows limits the rows of a bulk upload, DefaultBulkMaxRows is
+// used otherwise
+func WithBulkMaxRows(n int) shortenerOption {
+	return func(s *Shortener) error {
+		if n < 1 {
+			return errors.New("bulk max rows must be positive")
+		}
+		s.bulkMaxRows = n
+		return nil
+	}
+}
+
+// bulkReport is the result of a bulk upload created in its request
+type bulkReport struct {
+	Results   []domain.BulkResult `json:"results"`
+	Succeeded int                 `json:"succeeded"`
+	Failed    int                 `json:"failed"`
+}
+
+// ShortenBulk creates links of the rows of a CSV or JSON upload for the user,
+// or for the workspace for sessions in one. Every row is validated and
+// reported on its own, invalid rows don't stop the others from being
+// created. Uploads of more rows than the sync limit are queued as jobs.
+func (s *Shortener) ShortenBulk(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r).With().Logger()
+	tokenInfo, ok := s.authenticate(w, r, &log)
+	if !ok {
+		return
+	}
+	userId := tokenInfo.GetUserId()
+	workspaceId := tokenInfo.GetWorkspaceId()
+	log = log.With().
+		Str("user_id", userId).
+		Str("workspace_id", workspaceId).
+		Logger()
+
+	if workspaceId != "" &&
+		!domain.Role(tokenInfo.GetRole()).Allows(domain.RoleEditor) {
+		log.Info().
+			Str("role", tokenInfo.GetRole()).
+			Msg("role doesn't allow creating links in workspace")
+
+		res, _ := json.Marshal(&responses.Server{
+			Message: "insufficient role in workspace",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(res)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(s.bulkMaxRows)*bulkRowBytes)
+	links, err := decodeBulk(r)
+	if err != nil {
+		log.Info().Err(err).Msg("couldn't decode bulk upload")
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			res, _ := json.Marshal(&responses.Server{
+				Message: "bulk upload is too large",
+			})
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write(res)
+			return
+		}
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't process bulk upload: " + err.Error(),
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(res)
+		return
+	}
+	if len(links) == 0 || len(links) > s.bulkMaxRows {
+		log.Info().Int("rows", len(links)).Msg("bulk upload has too few or too many rows")
+		res, _ := json.Marshal(&responses.Server{
+			Message: fmt.Sprintf("bulk upload must have from 1 to %d rows", s.bulkMaxRows),
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(res)
+		return
+	}
+	log = log.With().Int("rows", len(links)).Logger()
+
+	if s.bulkJobs != nil && len(links) > s.bulkSyncLimit {
+		s.queueBulk(w, r, &log, links, userId, workspaceId)
+		return
+	}
+
+	report := bulkReport{Results: make([]domain.BulkResult, 0, len(links))}
+	err = s.createLinks(
+		r.Context(),
+		&log,
+		links,
+		validateBulk(links),
+		0,
+		userId,
+		workspaceId,
+		uuid.New(),
+		func(results []domain.BulkResult) error {
+			for _, res := range results {
+				if res.Error != "" {
+					report.Failed++
+				} else {
+					report.Succeeded++
+				}
+			}
+			report.Results = append(report.Results, results...)
+			return nil
+		},
+	)
+	if err != nil && len(report.Results) == 0 {
+		log.Error().Err(err).Msg("couldn't create links of bulk upload")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't create links. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
+	}
+	if err != nil {
+		// links of the batches created so far are reported, so that they
+		// aren't uploaded again
+		log.Error().Err(err).
+			Int("processed", len(report.Results)).
+			Msg("couldn't create all links of bulk upload")
+		for row := len(report.Results); row < len(links); row++ {
+			report.Results = append(report.Results, domain.BulkResult{
+				Row:   row + 1,
+				Error: "couldn't create link. try again later",
+			})
+			report.Failed++
+		}
+	}
+	bulkRequests.WithLabelValues("sync").Inc()
+	log.Info().
+		Int("succeeded", report.Succeeded).
+		Int("failed", report.Failed).
+		Msg("created links of bulk upload")
+
+	res, _ := json.Marshal(&report)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func (s *Shortener) queueBulk(
+	w http.ResponseWriter,
+	r *http.Request,
+	log *zerolog.Logger,
+	links []domain.BulkLink,
+	userId string,
+	workspaceId string,
+) {
+	j := &domain.BulkJob{
+		UserId:      userId,
+		WorkspaceId: workspaceId,
+		Links:       links,
+	}
+	if err := s.bulkJobs.Create(r.Context(), j); err != nil {
+		log.Error().Err(err).Msg("couldn't create bulk job")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't create links. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
+	}
+	bulkRequests.WithLabelValues("job").Inc()
+	log.Info().Str("bulk_id", j.Id).Msg("queued bulk job")
+
+	res, _ := json.Marshal(j)
+	w.Header().Set("Location", "/bulk/"+j.Id)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(res)
+}
+
+// BulkJob returns the progress of the bulk job, and the results of its rows
+// once it's finished
+func (s *Shortener) BulkJob(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r).
+		With().
+		Str("bulk_id", r.PathValue("id")).
+		Logger()
+	tokenInfo, ok := s.authenticate(w, r, &log)
+	if !ok {
+		return
+	}
+	j, ok := s.ownedBulkJob(w, r, &log, tokenInfo)
+	if !ok {
+		return
+	}
+
+	res, _ := json.Marshal(j)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// ownedBulkJob returns the bulk job of the path if it belongs to the user,
+// or to the workspace the session is in. Jobs of others and expired ones are
+// reported as missing.
+func (s *Shortener) ownedBulkJob(
+	w http.ResponseWriter,
+	r *http.Request,
+	log *zerolog.Logger,
+	tokenInfo *blackbox.ValidateTokenRsp,
+) (*domain.BulkJob, bool) {
+	var j *domain.BulkJob
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		err = bulk.ErrNotFound
+	} else {
+		j, err = s.bulkJobs.Job(r.Context(), id.String())
+	}
+	if err != nil && !errors.Is(err, bulk.ErrNotFound) {
+		log.Error().Err(err).Msg("couldn't get bulk job")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't get bulk job. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return nil, false
+	}
+	if err != nil || !domain.Owns(tokenInfo, j.UserId, j.WorkspaceId) {
+		log.Info().Msg("bulk job not found")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "bulk job not found",
+		})
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(res)
+		return nil, false
+	}
+	return j, true
+}
+
+// decodeBulk reads rows of a text/csv body, or of a JSON array otherwise
+func decodeBulk(r *http.Request) ([]domain.BulkLink, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		return decodeBulkCsv(r.Body)
+	}
+
+	var links []domain.BulkLink
+	if err := json.NewDecoder(r.Body).Decode(&links); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// decodeBulkCsv reads rows of a CSV upload. Its header names the columns:
+// url, and optionally alias, expiration, title and tags. Tags are separated
+// by commas or spaces.
+func decodeBulkCsv(body io.Reader) ([]domain.BulkLink, error) {
+	cr := csv.NewReader(body)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, len(header))
+	hasUrl := false
+	for i, name := range header {
+		// spreadsheets often start files with a byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		switch name {
+		case "url":
+			hasUrl = true
+		case "alias", "expiration", "title", "tags":
+		default:
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[i] = name
+	}
+	if !hasUrl {
+		return nil, errors.New("no url column")
+	}
+
+	var links []domain.BulkLink
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return links, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var l domain.BulkLink
+		for i, v := range record {
+			switch columns[i] {
+			case "url":
+				l.Url = v
+			case "alias":
+				l.Alias = v
+			case "expiration":
+				if v == "" {
+					break
+				}
+				if l.Expiration, err = strconv.Atoi(v); err != nil {
+					// fails validation of the row rather than the upload
+					l.Expiration = -1
+				}
+			case "title":
+				l.Title = v
+			case "tags":
+				l.Tags = strings.FieldsFunc(v, func(r rune) bool {
+					return r == ',' || unicode.IsSpace(r)
+				})
+			}
+		}
+		links = append(links, l)
+	}
+}
+
+// validateBulk returns the reasons rows of the upload are invalid, empty for
+// valid ones. Aliases repeated in the upload are only valid in their first
+// row.
+func validateBulk(links []domain.BulkLink) []string {
+	invalid := make([]string, len(links))
+	aliases := make(map[string]struct{})
+	for i := range links {
+		if err := validate.Struct(bulkLinkReq(links[i])); err != nil {
+			invalid[i] = rowError(err)
+			continue
+		}
+		if alias := links[i].Alias; alias != "" {
+			if _, ok := aliases[alias]; ok {
+				invalid[i] = "alias is repeated in the upload"
+				continue
+			}
+			aliases[alias] = struct{}{}
+		}
+	}
+	return invalid
+}
+
+// rowError names the first invalid field of the row, e.g. "invalid url"
+func rowError(err error) string {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) || len(errs) == 0 {
+		return "invalid row"
+	}
+	// elements of tags are named like Tags[0]
+	field, _, _ := strings.Cut(errs[0].StructField(), "[")
+	return "invalid " + strings.ToLower(field)
+}
+
+// createLinks creates links of the rows after the first ones in batches, and
+// reports results of every batch. invalid are the reasons of validateBulk.
+// Idempotency keys of the links are derived from keys and their rows, so
+// that rows created again after an interruption don't duplicate links.
+// Rows that can't be created are reported as failed, only errors of the
+// context and of report are returned. Batches that have been published are
+// always reported, the context is only checked before the next one.
+func (s *Shortener) createLinks(
+	ctx context.Context,
+	log *zerolog.Logger,
+	links []domain.BulkLink,
+	invalid []string,
+	first int,
+	userId string,
+	workspaceId string,
+	keys uuid.UUID,
+	report func(results []domain.BulkResult) error,
+) error {
+	for from := first; from < len(links); from += bulkBatchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		to := min(from+bulkBatchSize, len(links))
+		results := s.createBatch(
+			ctx,
+			log,
+			links[from:to],
+			invalid[from:to],
+			from,
+			userId,
+			workspaceId,
+			keys,
+		)
+		if err := report(results); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bulkKey returns the idempotency key of the link of the row
+func bulkKey(keys uuid.UUID, row int) string {
+	return uuid.NewSHA1(keys, []byte(strconv.Itoa(row))).String()
+}
+
+// createBatch checks aliases of the rows, allocates short urls for the rest
+// and publishes links of the valid rows at once. Rows are numbered after
+// offset.
+func (s *Shortener) createBatch(
+	ctx context.Context,
+	log *zerolog.Logger,
+	links []domain.BulkLink,
+	invalid []string,
+	offset int,
+	userId string,
+	workspaceId string,
+	keys uuid.UUID,
+) []domain.BulkResult {
+	results := make([]domain.BulkResult, len(links))
+	var aliases []string
+	reserved := make(map[string]struct{})
+	generated := 0
+	for i := range links {
+		results[i] = domain.BulkResult{Row: offset + i + 1, Error: invalid[i]}
+		if invalid[i] != "" {
+			continue
+		}
+		if alias := links[i].Alias; alias != "" {
+			aliases = append(aliases, alias)
+			reserved[alias] = struct{}{}
+		} else {
+			generated++
+		}
+	}
+
+	taken := make(map[string]struct{})
+	if len(aliases) > 0 {
+		takenAliases, err := s.urls.TakenShortUrls(ctx, aliases)
+		if err != nil {
+			log.Error().Err(err).Int("offset", offset).Msg("couldn't check aliases of bulk upload")
+			return failRows(results, "couldn't create link. try again later")
+		}
+		for _, alias := range takenAliases {
+			taken[alias] = struct{}{}
+		}
+	}
+	codes, err := s.allocateShortUrls(ctx, generated, reserved)
+	if err != nil {
+		log.Error().Err(err).Int("offset", offset).Msg("couldn't allocate short urls of bulk upload")
+		return failRows(results, "couldn't create link. try again later")
+	}
+
+	ee := make([]proto.Message, 0, len(links))
+	// rows of the events, by their index
+	rows := make([]int, 0, len(links))
+	now := time.Now()
+	for i, l := range links {
+		if results[i].Error != "" {
+			continue
+		}
+		shortUrl := l.Alias
+		if shortUrl == "" {
+			shortUrl, codes = codes[0], codes[1:]
+		} else if _, ok := taken[shortUrl]; ok {
+			results[i].Error = "alias is taken"
+			continue
+		}
+		expiration := l.Expiration
+		if expiration == 0 {
+			expiration = 30
+		}
+
+		ee = append(ee, &eventsv1.LinkCreated{
+			IdempotencyKey: bulkKey(keys, results[i].Row),
+			ShortUrl:       shortUrl,
+			LongUrl:        l.Url,
+			UserId:         userId,
+			ExpirationDate: timestamppb.New(now.
+				Add(time.Hour * 24 * time.Duration(expiration))),
+			WorkspaceId: workspaceId,
+			Title:       l.Title,
+			Tags:        l.Tags,
+		})
+		rows = append(rows, i)
+		results[i].ShortUrl = s.redirectorHost + "/" + shortUrl
+	}
+
+	if len(ee) > 0 {
+		err = s.publishAll(ctx, s.topic, ee)
+		var perr bus.PublishErrors
+		if errors.As(err, &perr) {
+			log.Error().Err(err).Int("offset", offset).Msg("couldn't publish some links of bulk upload")
+			for j := range perr {
+				results[rows[j]].ShortUrl = ""
+				results[rows[j]].Error = "couldn't create link. try again later"
+			}
+		} else if err != nil {
+			log.Error().Err(err).Int("offset", offset).Msg("couldn't publish links of bulk upload")
+			for _, i := range rows {
+				results[i].ShortUrl = ""
+			}
+			failRows(results, "couldn't create link. try again later")
+		}
+	}
+
+	for _, res := range results {
+		if res.Error != "" {
+			bulkLinks.WithLabelValues("failed").Inc()
+		} else {
+			bulkLinks.WithLabelValues("created").Inc()
+		}
+	}
+	return results
+}
+
+// failRows fails the rows that haven't failed yet for the reason
+func failRows(results []domain.BulkResult, reason string) []domain.BulkResult {
+	for i := range results {
+		if results[i].Error == "" {
+			results[i].Error = reason
+		}
+	}
+	return results
+}
+
+// allocateShortUrls generates n short urls that aren't taken, nor reserved
+// for aliases of the batch. Candidates are checked at once, and only taken
+// ones are generated again.
+func (s *Shortener) allocateShortUrls(
+	ctx context.Context,
+	n int,
+	reserved map[string]struct{},
+) ([]string, error) {
+	codes := make([]string, 0, n)
+	seen := make(map[string]struct{}, n)
+	// error of the last round, if it has failed
+	var lastErr error
+	for round := 0; len(codes) < n; round++ {
+		if round == allocationRounds && lastErr != nil {
+			return nil, lastErr
+		}
+		if round == allocationRounds {
+			return nil, errors.New("short urls keep colliding")
+		}
+
+		candidates := make([]string, 0, n-len(codes))
+		for len(candidates) < n-len(codes) {
+			c := generateShortUrl(5)
+			if _, ok := seen[c]; ok {
+				continue
+			}
+			if _, ok := reserved[c]; ok || !domain.ValidShortUrl(c) {
+				continue
+			}
+			seen[c] = struct{}{}
+			candidates = append(candidates, c)
+		}
+
+		taken, err := s.urls.TakenShortUrls(ctx, candidates)
+		lastErr = err
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			generationRetries.WithLabelValues("error").Inc()
+			continue
+		}
+		takenSet := make(map[string]struct{}, len(taken))
+		for _, c := range taken {
+			takenSet[c] = struct{}{}
+		}
+		for _, c := range candidates {
+			if _, ok := takenSet[c]; ok {
+				generationRetries.WithLabelValues("collision").Inc()
+				continue
+			}
+			codes = append(codes, c)
+		}
+	}
+	return codes, nil
+}
@@ -0,0 +1,445 @@
+package shortener
+
+import (
+	context "context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"shortener/pkg/bus"
+	"shortener/pkg/domain"
+	"shortener/proto/blackbox"
+	eventsv1 "shortener/proto/events/v1"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/proto"
+
+	bus_mocks "shortener/mocks/shortener/pkg/bus"
+)
+
+const bulkId = "6f1c2d3e-4b5a-4c7d-8e9f-0a1b2c3d4e5f"
+
+func newBulkRequest(t *testing.T, contentType string, body string) *http.Request {
+	req := newAuthenticatedRequest(t, "POST", "/links/bulk", body)
+	req.Header.Set("Content-Type", contentType)
+	return req
+}
+
+// generatedCandidates matches short urls checked while they are allocated
+func generatedCandidates(n int) any {
+	return mock.MatchedBy(func(candidates []string) bool {
+		if len(candidates) != n {
+			return false
+		}
+		for _, c := range candidates {
+			if len(c) != 5 {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func decodeCreated(t *testing.T, mm []*bus.Message) []*eventsv1.LinkCreated {
+	ee := make([]*eventsv1.LinkCreated, len(mm))
+	for i, m := range mm {
+		ee[i] = new(eventsv1.LinkCreated)
+		assert.Nil(t, proto.Unmarshal(m.Value, ee[i]))
+		assert.Equal(t, "topic", m.Topic)
+	}
+	return ee
+}
+
+func TestShortenBulkReportsEveryRow(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().
+		TakenShortUrls(mock.Anything, []string{"promo", "taken"}).
+		Return([]string{"taken"}, nil).
+		Once()
+	u.EXPECT().
+		TakenShortUrls(mock.Anything, generatedCandidates(1)).
+		Return(nil, nil).
+		Once()
+
+	var published []*eventsv1.LinkCreated
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().
+		Publish(mock.Anything, mock.Anything, mock.Anything).
+		Run(func(_ context.Context, mm ...*bus.Message) {
+			published = decodeCreated(t, mm)
+		}).
+		Return(nil).
+		Once()
+
+	s := newTestShortener(
+		t,
+		u,
+		p,
+		&blackbox.ValidateTokenRsp{
+			UserId:      "id",
+			WorkspaceId: "workspace",
+			Role:        string(domain.RoleEditor),
+		},
+		WithBulkMaxRows(10),
+	)
+	body, _ := json.Marshal([]domain.BulkLink{
+		{Url: "https://example.com/a", Expiration: 90, Tags: []string{"sale"}},
+		{Url: "https://example.com/b", Alias: "promo"},
+		{Url: "https://example.com/c", Alias: "taken"},
+		{Url: "not a url"},
+		{Url: "https://example.com/d", Alias: "promo"},
+		{Url: "https://example.com/e", Expiration: 45},
+		{Url: "https://example.com/f", Tags: []string{"Not A Tag"}},
+		{Url: "https://example.com/g", Alias: "links"},
+	})
+	recorder := httptest.NewRecorder()
+	s.ShortenBulk(recorder, newBulkRequest(t, "application/json", string(body)))
+
+	assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	var report bulkReport
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&report))
+	assert.Equal(t, 2, report.Succeeded)
+	assert.Equal(t, 6, report.Failed)
+	assert.Len(t, report.Results, 8)
+
+	assert.Equal(t, 1, report.Results[0].Row)
+	assert.True(t, strings.HasPrefix(report.Results[0].ShortUrl, "host/"))
+	assert.Equal(t, domain.BulkResult{Row: 2, ShortUrl: "host/promo"}, report.Results[1])
+	assert.Equal(t, domain.BulkResult{Row: 3, Error: "alias is taken"}, report.Results[2])
+	assert.Equal(t, domain.BulkResult{Row: 4, Error: "invalid url"}, report.Results[3])
+	assert.Equal(t, domain.BulkResult{Row: 5, Error: "alias is repeated in the upload"}, report.Results[4])
+	assert.Equal(t, domain.BulkResult{Row: 6, Error: "invalid expiration"}, report.Results[5])
+	assert.Equal(t, domain.BulkResult{Row: 7, Error: "invalid tags"}, report.Results[6])
+	assert.Equal(t, domain.BulkResult{Row: 8, Error: "invalid alias"}, report.Results[7])
+
+	assert.Len(t, published, 2)
+	assert.Equal(t, "host/"+published[0].ShortUrl, report.Results[0].ShortUrl)
+	assert.Equal(t, "workspace", published[0].WorkspaceId)
+	assert.Equal(t, []string{"sale"}, published[0].Tags)
+	assert.WithinDuration(
+		t,
+		time.Now().Add(90*24*time.Hour),
+		published[0].ExpirationDate.AsTime(),
+		time.Minute,
+	)
+	assert.Equal(t, "promo", published[1].ShortUrl)
+	assert.WithinDuration(
+		t,
+		time.Now().Add(30*24*time.Hour),
+		published[1].ExpirationDate.AsTime(),
+		time.Minute,
+	)
+}
+
+func TestShortenBulkCsv(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().
+		TakenShortUrls(mock.Anything, generatedCandidates(2)).
+		Return(nil, nil).
+		Once()
+
+	var published []*eventsv1.LinkCreated
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().
+		Publish(mock.Anything, mock.Anything, mock.Anything).
+		Run(func(_ context.Context, mm ...*bus.Message) {
+			published = decodeCreated(t, mm)
+		}).
+		Return(nil).
+		Once()
+
+	s := newTestShortener(t, u, p, &blackbox.ValidateTokenRsp{UserId: "id"}, WithBulkMaxRows(3))
+	recorder := httptest.NewRecorder()
+	s.ShortenBulk(recorder, newBulkRequest(
+		t,
+		"text/csv; charset=utf-8",
+		"\ufeffURL,Title,Expiration,Tags\n"+
+			"https://example.com/a,Spring sale,365,\"sale, spring\"\n"+
+			"https://example.com/b,,,\n"+
+			"https://example.com/c,,soon,\n",
+	))
+
+	assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	var report bulkReport
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&report))
+	assert.Equal(t, 2, report.Succeeded)
+	assert.Equal(t, domain.BulkResult{Row: 3, Error: "invalid expiration"}, report.Results[2])
+
+	assert.Len(t, published, 2)
+	assert.Equal(t, "Spring sale", published[0].Title)
+	assert.Equal(t, []string{"sale", "spring"}, published[0].Tags)
+	assert.Equal(t, "https://example.com/b", published[1].LongUrl)
+}
+
+func TestShortenBulkRejectsUploads(t *testing.T) {
+	for _, data := range []struct {
+		Name        string
+		ContentType string
+		Body        string
+		Status      int
+	}{
+		{
+			Name:        "empty",
+			ContentType: "application/json",
+			Body:        "[]",
+			Status:      http.StatusBadRequest,
+		},
+		{
+			Name:        "too many rows",
+			ContentType: "text/csv",
+			Body:        "url\nhttps://a.com\nhttps://b.com\nhttps://c.com\nhttps://d.com\n",
+			Status:      http.StatusBadRequest,
+		},
+		{
+			Name:        "unknown column",
+			ContentType: "text/csv",
+			Body:        "url,owner\nhttps://a.com,me\n",
+			Status:      http.StatusUnprocessableEntity,
+		},
+		{
+			Name:        "no url column",
+			ContentType: "text/csv",
+			Body:        "title\nhome\n",
+			Status:      http.StatusUnprocessableEntity,
+		},
+		{
+			Name:        "not an array",
+			ContentType: "application/json",
+			Body:        `{"url": "https://a.com"}`,
+			Status:      http.StatusUnprocessableEntity,
+		},
+	} {
+		t.Run(data.Name, func(t *testing.T) {
+			s := newTestShortener(
+				t,
+				NewMockUrls(t),
+				bus_mocks.NewMockPublisher(t),
+				&blackbox.ValidateTokenRsp{UserId: "id"},
+				WithBulkMaxRows(3),
+			)
+			recorder := httptest.NewRecorder()
+			s.ShortenBulk(recorder, newBulkRequest(t, data.ContentType, data.Body))
+
+			assert.Equal(t, data.Status, recorder.Result().StatusCode)
+		})
+	}
+}
+
+func TestShortenBulkWorkspaceViewerForbidden(t *testing.T) {
+	s := newTestShortener(
+		t,
+		NewMockUrls(t),
+		bus_mocks.NewMockPublisher(t),
+		&blackbox.ValidateTokenRsp{
+			UserId:      "id",
+			WorkspaceId: "workspace",
+			Role:        string(domain.RoleViewer),
+		},
+		WithBulkMaxRows(3),
+	)
+	recorder := httptest.NewRecorder()
+	s.ShortenBulk(recorder, newBulkRequest(t, "application/json", `[{"url": "https://a.com"}]`))
+
+	assert.Equal(t, http.StatusForbidden, recorder.Result().StatusCode)
+}
+
+func TestShortenBulkPublishErrors(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().
+		TakenShortUrls(mock.Anything, generatedCandidates(2)).
+		Return(nil, nil).
+		Once()
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().
+		Publish(mock.Anything, mock.Anything, mock.Anything).
+		Return(bus.PublishErrors{1: errors.New("message too large")}).
+		Once()
+
+	s := newTestShortener(t, u, p, &blackbox.ValidateTokenRsp{UserId: "id"}, WithBulkMaxRows(3))
+	recorder := httptest.NewRecorder()
+	s.ShortenBulk(recorder, newBulkRequest(
+		t,
+		"application/json",
+		`[{"url": "https://a.com"}, {"url": "https://b.com"}]`,
+	))
+
+	assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	var report bulkReport
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&report))
+	assert.Equal(t, 1, report.Succeeded)
+	assert.Equal(t, domain.BulkResult{
+		Row:   2,
+		Error: "couldn't create link. try again later",
+	}, report.Results[1])
+}
+
+func TestShortenBulkQueuesLargeUploads(t *testing.T) {
+	j := NewMockBulkJobs(t)
+	j.EXPECT().
+		Create(mock.Anything, mock.MatchedBy(func(job *domain.BulkJob) bool {
+			return job.UserId == "id" && len(job.Links) == 3
+		})).
+		Run(func(_ context.Context, job *domain.BulkJob) {
+			job.Id = bulkId
+			job.Status = domain.BulkPending
+			job.Total = len(job.Links)
+		}).
+		Return(nil)
+
+	s := newTestShortener(
+		t,
+		NewMockUrls(t),
+		bus_mocks.NewMockPublisher(t),
+		&blackbox.ValidateTokenRsp{UserId: "id"},
+		WithBulkMaxRows(3),
+		WithBulkJobs(j, 2),
+	)
+	recorder := httptest.NewRecorder()
+	s.ShortenBulk(recorder, newBulkRequest(
+		t,
+		"text/csv",
+		"url\nhttps://a.com\nhttps://b.com\nhttps://c.com\n",
+	))
+
+	rsp := recorder.Result()
+	assert.Equal(t, http.StatusAccepted, rsp.StatusCode)
+	assert.Equal(t, "/bulk/"+bulkId, rsp.Header.Get("Location"))
+	var job domain.BulkJob
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&job))
+	assert.Equal(t, domain.BulkPending, job.Status)
+	assert.Equal(t, 3, job.Total)
+}
+
+func TestBulkJobOfOthersNotFound(t *testing.T) {
+	j := NewMockBulkJobs(t)
+	j.EXPECT().Job(mock.Anything, bulkId).Return(&domain.BulkJob{
+		Id:     bulkId,
+		UserId: "other",
+		Status: domain.BulkRunning,
+	}, nil)
+
+	s := newTestShortener(
+		t,
+		NewMockUrls(t),
+		bus_mocks.NewMockPublisher(t),
+		&blackbox.ValidateTokenRsp{UserId: "id"},
+		WithBulkMaxRows(3),
+		WithBulkJobs(j, 2),
+	)
+	req := newAuthenticatedRequest(t, "GET", "/bulk/"+bulkId, nil)
+	req.SetPathValue("id", bulkId)
+	recorder := httptest.NewRecorder()
+	s.BulkJob(recorder, req)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Result().StatusCode)
+}
+
+func TestImporterResumesJobs(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().
+		TakenShortUrls(mock.Anything, generatedCandidates(1)).
+		Return(nil, nil).
+		Once()
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().
+		Publish(mock.Anything, mock.MatchedBy(func(m *bus.Message) bool {
+			var e eventsv1.LinkCreated
+			err := proto.Unmarshal(m.Value, &e)
+			return err == nil && e.LongUrl == "https://c.com" && e.UserId == "id"
+		})).
+		Return(nil).
+		Once()
+	s := newTestShortener(t, u, p, nil)
+
+	q := NewMockBulkQueue(t)
+	q.EXPECT().Claim(mock.Anything, 2*time.Hour).Return(&domain.BulkJob{
+		Id:     bulkId,
+		UserId: "id",
+		Links: []domain.BulkLink{
+			{Url: "https://a.com", Alias: "promo"},
+			{Url: "https://b.com"},
+			// repeats the alias of a row processed before a restart
+			{Url: "https://d.com", Alias: "promo"},
+			{Url: "https://c.com"},
+		},
+		Total:     4,
+		Processed: 2,
+	}, nil).Once()
+	q.EXPECT().Claim(mock.Anything, 2*time.Hour).Return(nil, nil).Once()
+	q.EXPECT().
+		Progress(mock.Anything, bulkId, mock.MatchedBy(func(results []domain.BulkResult) bool {
+			return len(results) == 2 &&
+				results[0] == domain.BulkResult{Row: 3, Error: "alias is repeated in the upload"} &&
+				results[1].Row == 4 && results[1].Error == ""
+		})).
+		Return(nil)
+	q.EXPECT().Finish(mock.Anything, bulkId, time.Hour).Return(nil)
+	q.EXPECT().Prune(mock.Anything).Return(0, nil)
+
+	log := zerolog.Nop()
+	im, err := NewImporter(
+		WithQueue(q),
+		WithShortener(s),
+		WithJobTimeout(time.Hour),
+		WithRetention(time.Hour),
+		WithImporterLogger(&log),
+	)
+	assert.Nil(t, err)
+	assert.Nil(t, im.Pass(context.Background()))
+}
+
+func TestImporterStoresProgressOfCancelledJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	keys := uuid.NewSHA1(uuid.NameSpaceURL, []byte("/bulk/"+bulkId))
+
+	u := NewMockUrls(t)
+	u.EXPECT().
+		TakenShortUrls(mock.Anything, generatedCandidates(1)).
+		Return(nil, nil).
+		Once()
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().
+		Publish(mock.Anything, mock.MatchedBy(func(m *bus.Message) bool {
+			var e eventsv1.LinkCreated
+			err := proto.Unmarshal(m.Value, &e)
+			// keys of resumed jobs are the same, so links aren't duplicated
+			return err == nil && e.IdempotencyKey == bulkKey(keys, 1)
+		})).
+		Run(func(context.Context, ...*bus.Message) { cancel() }).
+		Return(nil).
+		Once()
+	s := newTestShortener(t, u, p, nil)
+
+	q := NewMockBulkQueue(t)
+	q.EXPECT().Claim(mock.Anything, 2*time.Hour).Return(&domain.BulkJob{
+		Id:     bulkId,
+		UserId: "id",
+		Links:  []domain.BulkLink{{Url: "https://a.com"}},
+		Total:  1,
+	}, nil).Once()
+	q.EXPECT().
+		Progress(mock.Anything, bulkId, mock.MatchedBy(func(results []domain.BulkResult) bool {
+			return len(results) == 1 && results[0].Error == ""
+		})).
+		Run(func(ctx context.Context, _ string, _ []domain.BulkResult) {
+			assert.Nil(t, ctx.Err())
+		}).
+		Return(nil)
+
+	log := zerolog.Nop()
+	im, err := NewImporter(
+		WithQueue(q),
+		WithShortener(s),
+		WithJobTimeout(time.Hour),
+		WithImporterLogger(&log),
+	)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, im.Pass(ctx), context.Canceled)
+}
@@ -0,0 +1,78 @@
+package shortener
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"shortener/pkg/models/urls"
+	"shortener/pkg/responses"
+	eventsv1 "shortener/proto/events/v1"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/hlog"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Delete removes a link, its short url can't be used anymore. Links are
+// deleted by the same users that may renew them. The deletion is published
+// to the events topic, if set.
+func (s *Shortener) Delete(w http.ResponseWriter, r *http.Request) {
+	shortUrl := r.PathValue("code")
+	log := hlog.FromRequest(r).
+		With().
+		Str("short_url", shortUrl).
+		Logger()
+
+	tokenInfo, ok := s.authenticate(w, r, &log)
+	if !ok {
+		return
+	}
+	log = log.With().
+		Str("user_id", tokenInfo.GetUserId()).
+		Str("workspace_id", tokenInfo.GetWorkspaceId()).
+		Logger()
+
+	l, ok := s.editableLink(w, r, &log, tokenInfo, shortUrl)
+	if !ok {
+		return
+	}
+
+	err := s.urls.Delete(r.Context(), shortUrl)
+	if errors.Is(err, urls.ErrNotFound) {
+		log.Info().Msg("link has already been deleted")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "link not found",
+		})
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(res)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't delete link")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't delete link. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
+	}
+	log.Info().Msg("deleted link")
+
+	if s.eventsTopic != "" {
+		e := &eventsv1.LinkDeleted{
+			IdempotencyKey: uuid.New().String(),
+			ShortUrl:       shortUrl,
+			LongUrl:        l.LongUrl,
+			UserId:         l.UserId,
+			WorkspaceId:    l.WorkspaceId,
+			DeletedAt:      timestamppb.New(time.Now()),
+		}
+		// the link is gone either way, only webhooks miss the deletion
+		if err := s.publish(r.Context(), s.eventsTopic, e); err != nil {
+			log.Error().Err(err).Msg("couldn't publish deletion")
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,6 +1,18 @@
 package shortener
 
-import "math/rand"
+import (
+	"context"
+	"math/rand"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+)
+
+var generationRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "shortener_code_generation_retries_total",
+	Help: "Short codes generated again by reason: collision or error.",
+}, []string{"reason"})
 
 // excludes zero, uppercase 'O', uppercase 'I', and lowercase 'l'
 var readerFriendlyCharset = []byte(
@@ -14,3 +26,22 @@ func generateShortUrl(length int) string {
 	}
 	return string(b)
 }
+
+// uniqueShortUrl generates short urls until one isn't taken
+func (s *Shortener) uniqueShortUrl(log *zerolog.Logger) string {
+	for {
+		shortUrl := generateShortUrl(5)
+		exists, err := s.urls.CheckExistence(context.TODO(), shortUrl)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Msg("couldn't check existence of short url in database. will reattempt database request")
+			generationRetries.WithLabelValues("error").Inc()
+			continue
+		}
+		if !exists {
+			return shortUrl
+		}
+		generationRetries.WithLabelValues("collision").Inc()
+	}
+}
@@ -0,0 +1,215 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/domain"
+	"shortener/pkg/jobqueue"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+)
+
+var (
+	bulkJobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shortener_bulk_jobs_total",
+		Help: "Finished bulk jobs by result: done or failed.",
+	}, []string{"result"})
+	bulkJobDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "shortener_bulk_job_duration_seconds",
+		Help:    "Time bulk jobs have been running for.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+)
+
+// BulkQueue runs bulk jobs and stores their progress, see bulk.Model
+type BulkQueue interface {
+	Claim(ctx context.Context, lease time.Duration) (*domain.BulkJob, error)
+	Progress(ctx context.Context, id string, results []domain.BulkResult) error
+	Finish(ctx context.Context, id string, ttl time.Duration) error
+	Fail(ctx context.Context, id string, reason string, ttl time.Duration) error
+	Prune(ctx context.Context) (int64, error)
+}
+
+// Importer runs bulk jobs queued by ShortenBulk one at a time, and removes
+// them once they expire. The progress of a job is stored after every batch
+// of rows, so that a job interrupted by a restart is resumed after them.
+type Importer struct {
+	queue     BulkQueue
+	shortener *Shortener
+	runner    *jobqueue.Runner[domain.BulkJob]
+
+	pollInterval time.Duration
+	timeout      time.Duration
+	retention    time.Duration
+
+	log *zerolog.Logger
+}
+
+// progressTimeout limits the time the progress of a published batch is
+// stored for once the job has been cancelled
+const progressTimeout = 10 * time.Second
+
+type importerOption func(im *Importer) error
+
+func WithQueue(q BulkQueue) importerOption {
+	return func(im *Importer) error {
+		im.queue = q
+		return nil
+	}
+}
+
+// WithShortener creates links of the jobs like the shortener does for
+// uploads created in their requests
+func WithShortener(s *Shortener) importerOption {
+	return func(im *Importer) error {
+		im.shortener = s
+		return nil
+	}
+}
+
+// WithPollInterval sets the time between looks for pending jobs
+func WithPollInterval(d time.Duration) importerOption {
+	return func(im *Importer) error {
+		if d <= 0 {
+			return errors.New("poll interval must be positive")
+		}
+		im.pollInterval = d
+		return nil
+	}
+}
+
+// WithJobTimeout fails jobs running for longer than the timeout
+func WithJobTimeout(d time.Duration) importerOption {
+	return func(im *Importer) error {
+		if d <= 0 {
+			return errors.New("job timeout must be positive")
+		}
+		im.timeout = d
+		return nil
+	}
+}
+
+// WithRetention keeps finished jobs and their results for the time
+func WithRetention(d time.Duration) importerOption {
+	return func(im *Importer) error {
+		if d <= 0 {
+			return errors.New("retention must be positive")
+		}
+		im.retention = d
+		return nil
+	}
+}
+
+func WithImporterLogger(l *zerolog.Logger) importerOption {
+	return func(im *Importer) error {
+		im.log = l
+		return nil
+	}
+}
+
+func NewImporter(opts ...importerOption) (*Importer, error) {
+	im := &Importer{
+		pollInterval: jobqueue.DefaultPollInterval,
+		timeout:      jobqueue.DefaultTimeout,
+		retention:    jobqueue.DefaultRetention,
+	}
+	for _, opt := range opts {
+		if err := opt(im); err != nil {
+			return nil, err
+		}
+	}
+	if im.queue == nil {
+		return nil, errors.New("no bulk queue provided")
+	}
+	if im.shortener == nil {
+		return nil, errors.New("no shortener provided")
+	}
+	if im.log == nil {
+		return nil, errors.New("no logger provided")
+	}
+	im.runner = &jobqueue.Runner[domain.BulkJob]{
+		Queue:        im.queue,
+		Handle:       im.run,
+		Timeout:      im.timeout,
+		PollInterval: im.pollInterval,
+		Kind:         "bulk",
+		Log:          im.log,
+	}
+	return im, nil
+}
+
+// Run makes passes until the context is cancelled. Jobs interrupted by the
+// cancellation are resumed by some instance once their leases run out.
+func (im *Importer) Run(ctx context.Context) {
+	im.runner.Run(ctx)
+}
+
+// Pass runs pending jobs until there are none left, then removes expired
+// ones
+func (im *Importer) Pass(ctx context.Context) error {
+	return im.runner.Pass(ctx)
+}
+
+// run creates links of the rows of the job that haven't been processed yet,
+// and marks the job as done or failed. Errors are only returned if the job
+// couldn't be marked.
+func (im *Importer) run(ctx context.Context, j *domain.BulkJob) error {
+	log := im.log.With().
+		Str("bulk_id", j.Id).
+		Str("user_id", j.UserId).
+		Str("workspace_id", j.WorkspaceId).
+		Int("rows", j.Total).
+		Int("processed", j.Processed).
+		Logger()
+	log.Info().Msg("running bulk job")
+	start := time.Now()
+
+	jobCtx, cancel := context.WithTimeout(ctx, im.timeout)
+	defer cancel()
+	// rows are validated again as a whole, so that aliases repeated in rows
+	// processed before a restart are still caught. Keys are derived from the
+	// job, so that rows published before their progress was stored don't
+	// duplicate links once the job is resumed.
+	err := im.shortener.createLinks(
+		jobCtx,
+		&log,
+		j.Links,
+		validateBulk(j.Links),
+		j.Processed,
+		j.UserId,
+		j.WorkspaceId,
+		uuid.NewSHA1(uuid.NameSpaceURL, []byte("/bulk/"+j.Id)),
+		func(results []domain.BulkResult) error {
+			// links of the batch have been published, so its progress is
+			// stored even if the job has been cancelled meanwhile
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(jobCtx), progressTimeout)
+			defer cancel()
+			return im.queue.Progress(ctx, j.Id, results)
+		},
+	)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	bulkJobDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		log.Error().Err(err).Msg("bulk job failed")
+		reason := "couldn't create links"
+		if errors.Is(err, context.DeadlineExceeded) {
+			reason = "bulk upload has timed out"
+		}
+		bulkJobs.WithLabelValues("failed").Inc()
+		return im.queue.Fail(ctx, j.Id, reason, im.retention)
+	}
+
+	if err := im.queue.Finish(ctx, j.Id, im.retention); err != nil {
+		return err
+	}
+	bulkJobs.WithLabelValues("done").Inc()
+	log.Info().Msg("bulk job is done")
+	return nil
+}
@@ -4,6 +4,8 @@ package shortener
 
 import (
 	context "context"
+	domain "shortener/pkg/domain"
+	time "time"
 
 	mock "github.com/stretchr/testify/mock"
 )
@@ -78,6 +80,281 @@ func (_c *MockUrls_CheckExistence_Call) RunAndReturn(run func(context.Context, s
 	return _c
 }
 
+// Delete provides a mock function with given fields: ctx, shortUrl
+func (_m *MockUrls) Delete(ctx context.Context, shortUrl string) error {
+	ret := _m.Called(ctx, shortUrl)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Delete")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, shortUrl)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockUrls_Delete_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Delete'
+type MockUrls_Delete_Call struct {
+	*mock.Call
+}
+
+// Delete is a helper method to define mock.On call
+//   - ctx context.Context
+//   - shortUrl string
+func (_e *MockUrls_Expecter) Delete(ctx interface{}, shortUrl interface{}) *MockUrls_Delete_Call {
+	return &MockUrls_Delete_Call{Call: _e.mock.On("Delete", ctx, shortUrl)}
+}
+
+func (_c *MockUrls_Delete_Call) Run(run func(ctx context.Context, shortUrl string)) *MockUrls_Delete_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockUrls_Delete_Call) Return(_a0 error) *MockUrls_Delete_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockUrls_Delete_Call) RunAndReturn(run func(context.Context, string) error) *MockUrls_Delete_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Link provides a mock function with given fields: ctx, shortUrl
+func (_m *MockUrls) Link(ctx context.Context, shortUrl string) (*domain.Link, error) {
+	ret := _m.Called(ctx, shortUrl)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Link")
+	}
+
+	var r0 *domain.Link
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*domain.Link, error)); ok {
+		return rf(ctx, shortUrl)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) *domain.Link); ok {
+		r0 = rf(ctx, shortUrl)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*domain.Link)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, shortUrl)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockUrls_Link_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Link'
+type MockUrls_Link_Call struct {
+	*mock.Call
+}
+
+// Link is a helper method to define mock.On call
+//   - ctx context.Context
+//   - shortUrl string
+func (_e *MockUrls_Expecter) Link(ctx interface{}, shortUrl interface{}) *MockUrls_Link_Call {
+	return &MockUrls_Link_Call{Call: _e.mock.On("Link", ctx, shortUrl)}
+}
+
+func (_c *MockUrls_Link_Call) Run(run func(ctx context.Context, shortUrl string)) *MockUrls_Link_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockUrls_Link_Call) Return(_a0 *domain.Link, _a1 error) *MockUrls_Link_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockUrls_Link_Call) RunAndReturn(run func(context.Context, string) (*domain.Link, error)) *MockUrls_Link_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// MoveToWorkspace provides a mock function with given fields: ctx, userId, workspaceId, shortUrls
+func (_m *MockUrls) MoveToWorkspace(ctx context.Context, userId string, workspaceId string, shortUrls []string) ([]string, error) {
+	ret := _m.Called(ctx, userId, workspaceId, shortUrls)
+
+	if len(ret) == 0 {
+		panic("no return value specified for MoveToWorkspace")
+	}
+
+	var r0 []string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, []string) ([]string, error)); ok {
+		return rf(ctx, userId, workspaceId, shortUrls)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, []string) []string); ok {
+		r0 = rf(ctx, userId, workspaceId, shortUrls)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, []string) error); ok {
+		r1 = rf(ctx, userId, workspaceId, shortUrls)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockUrls_MoveToWorkspace_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'MoveToWorkspace'
+type MockUrls_MoveToWorkspace_Call struct {
+	*mock.Call
+}
+
+// MoveToWorkspace is a helper method to define mock.On call
+//   - ctx context.Context
+//   - userId string
+//   - workspaceId string
+//   - shortUrls []string
+func (_e *MockUrls_Expecter) MoveToWorkspace(ctx interface{}, userId interface{}, workspaceId interface{}, shortUrls interface{}) *MockUrls_MoveToWorkspace_Call {
+	return &MockUrls_MoveToWorkspace_Call{Call: _e.mock.On("MoveToWorkspace", ctx, userId, workspaceId, shortUrls)}
+}
+
+func (_c *MockUrls_MoveToWorkspace_Call) Run(run func(ctx context.Context, userId string, workspaceId string, shortUrls []string)) *MockUrls_MoveToWorkspace_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string), args[3].([]string))
+	})
+	return _c
+}
+
+func (_c *MockUrls_MoveToWorkspace_Call) Return(_a0 []string, _a1 error) *MockUrls_MoveToWorkspace_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockUrls_MoveToWorkspace_Call) RunAndReturn(run func(context.Context, string, string, []string) ([]string, error)) *MockUrls_MoveToWorkspace_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Renew provides a mock function with given fields: ctx, shortUrl, expiration, autoRenew
+func (_m *MockUrls) Renew(ctx context.Context, shortUrl string, expiration time.Time, autoRenew bool) error {
+	ret := _m.Called(ctx, shortUrl, expiration, autoRenew)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Renew")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, bool) error); ok {
+		r0 = rf(ctx, shortUrl, expiration, autoRenew)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockUrls_Renew_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Renew'
+type MockUrls_Renew_Call struct {
+	*mock.Call
+}
+
+// Renew is a helper method to define mock.On call
+//   - ctx context.Context
+//   - shortUrl string
+//   - expiration time.Time
+//   - autoRenew bool
+func (_e *MockUrls_Expecter) Renew(ctx interface{}, shortUrl interface{}, expiration interface{}, autoRenew interface{}) *MockUrls_Renew_Call {
+	return &MockUrls_Renew_Call{Call: _e.mock.On("Renew", ctx, shortUrl, expiration, autoRenew)}
+}
+
+func (_c *MockUrls_Renew_Call) Run(run func(ctx context.Context, shortUrl string, expiration time.Time, autoRenew bool)) *MockUrls_Renew_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(time.Time), args[3].(bool))
+	})
+	return _c
+}
+
+func (_c *MockUrls_Renew_Call) Return(_a0 error) *MockUrls_Renew_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockUrls_Renew_Call) RunAndReturn(run func(context.Context, string, time.Time, bool) error) *MockUrls_Renew_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// TakenShortUrls provides a mock function with given fields: ctx, shortUrls
+func (_m *MockUrls) TakenShortUrls(ctx context.Context, shortUrls []string) ([]string, error) {
+	ret := _m.Called(ctx, shortUrls)
+
+	if len(ret) == 0 {
+		panic("no return value specified for TakenShortUrls")
+	}
+
+	var r0 []string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []string) ([]string, error)); ok {
+		return rf(ctx, shortUrls)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []string) []string); ok {
+		r0 = rf(ctx, shortUrls)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, shortUrls)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockUrls_TakenShortUrls_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'TakenShortUrls'
+type MockUrls_TakenShortUrls_Call struct {
+	*mock.Call
+}
+
+// TakenShortUrls is a helper method to define mock.On call
+//   - ctx context.Context
+//   - shortUrls []string
+func (_e *MockUrls_Expecter) TakenShortUrls(ctx interface{}, shortUrls interface{}) *MockUrls_TakenShortUrls_Call {
+	return &MockUrls_TakenShortUrls_Call{Call: _e.mock.On("TakenShortUrls", ctx, shortUrls)}
+}
+
+func (_c *MockUrls_TakenShortUrls_Call) Run(run func(ctx context.Context, shortUrls []string)) *MockUrls_TakenShortUrls_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].([]string))
+	})
+	return _c
+}
+
+func (_c *MockUrls_TakenShortUrls_Call) Return(_a0 []string, _a1 error) *MockUrls_TakenShortUrls_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockUrls_TakenShortUrls_Call) RunAndReturn(run func(context.Context, []string) ([]string, error)) *MockUrls_TakenShortUrls_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // NewMockUrls creates a new instance of MockUrls. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewMockUrls(t interface {
@@ -0,0 +1,94 @@
+package shortener
+
+import (
+	"encoding/json"
+	"net/http"
+	"shortener/pkg/domain"
+	"shortener/pkg/responses"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// MoveLinks moves personal links of the user into the active workspace, so
+// that links created before joining it are shared with its members too.
+// Editors move their own links only, links of others and links already in
+// workspaces are left as they are and omitted from the response.
+func (s *Shortener) MoveLinks(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r).With().Logger()
+	tokenInfo, ok := s.authenticate(w, r, &log)
+	if !ok {
+		return
+	}
+	log = log.With().
+		Str("user_id", tokenInfo.GetUserId()).
+		Str("workspace_id", tokenInfo.GetWorkspaceId()).
+		Logger()
+
+	if tokenInfo.GetWorkspaceId() == "" {
+		log.Info().Msg("no active workspace to move links into")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "switch to the workspace to move links into",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(res)
+		return
+	}
+	if !domain.Role(tokenInfo.GetRole()).Allows(domain.RoleEditor) {
+		log.Info().
+			Str("role", tokenInfo.GetRole()).
+			Msg("role doesn't allow moving links into workspace")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "insufficient role in workspace",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(res)
+		return
+	}
+
+	var form moveLinksReq
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't decode body of move request")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't process move form",
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(res)
+		return
+	}
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid move form")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "invalid move form",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(res)
+		return
+	}
+
+	moved, err := s.urls.MoveToWorkspace(
+		r.Context(),
+		tokenInfo.GetUserId(),
+		tokenInfo.GetWorkspaceId(),
+		form.ShortUrls,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't move links into workspace")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't move links. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
+	}
+	if moved == nil {
+		moved = []string{}
+	}
+	log.Info().
+		Int("requested", len(form.ShortUrls)).
+		Int("moved", len(moved)).
+		Msg("moved links into workspace")
+
+	res, _ := json.Marshal(&responses.MovedLinks{Moved: moved})
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
@@ -0,0 +1,110 @@
+package shortener
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"shortener/pkg/domain"
+	"shortener/pkg/responses"
+	"shortener/proto/blackbox"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	bus_mocks "shortener/mocks/shortener/pkg/bus"
+)
+
+var workspaceEditor = &blackbox.ValidateTokenRsp{
+	UserId:      "id",
+	WorkspaceId: "workspace",
+	Role:        string(domain.RoleEditor),
+}
+
+func TestMoveLinks(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().
+		MoveToWorkspace(mock.Anything, "id", "workspace", []string{"abcde", "fghij"}).
+		Return([]string{"abcde"}, nil)
+
+	s := newTestShortener(t, u, bus_mocks.NewMockPublisher(t), workspaceEditor)
+	recorder := httptest.NewRecorder()
+	s.MoveLinks(recorder, newAuthenticatedRequest(t, "POST", "/links/move", map[string]any{
+		"short_urls": []string{"abcde", "fghij"},
+	}))
+
+	rsp := recorder.Result()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	var moved responses.MovedLinks
+	assert.Nil(t, json.NewDecoder(rsp.Body).Decode(&moved))
+	// fghij isn't a personal link of the user
+	assert.Equal(t, []string{"abcde"}, moved.Moved)
+}
+
+func TestMoveLinksFailure(t *testing.T) {
+	for _, data := range []struct {
+		Name      string
+		Body      any
+		TokenInfo *blackbox.ValidateTokenRsp
+		MoveErr   error
+		Status    int
+	}{
+		{
+			Name:      "no active workspace",
+			Body:      map[string]any{"short_urls": []string{"abcde"}},
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id"},
+			Status:    http.StatusBadRequest,
+		},
+		{
+			Name: "workspace viewer",
+			Body: map[string]any{"short_urls": []string{"abcde"}},
+			TokenInfo: &blackbox.ValidateTokenRsp{
+				UserId:      "id",
+				WorkspaceId: "workspace",
+				Role:        string(domain.RoleViewer),
+			},
+			Status: http.StatusForbidden,
+		},
+		{
+			Name:      "bad json",
+			Body:      "{",
+			TokenInfo: workspaceEditor,
+			Status:    http.StatusUnprocessableEntity,
+		},
+		{
+			Name:      "no links",
+			Body:      map[string]any{"short_urls": []string{}},
+			TokenInfo: workspaceEditor,
+			Status:    http.StatusBadRequest,
+		},
+		{
+			Name:      "invalid short url",
+			Body:      map[string]any{"short_urls": []string{"not a code"}},
+			TokenInfo: workspaceEditor,
+			Status:    http.StatusBadRequest,
+		},
+		{
+			Name:      "storage error",
+			Body:      map[string]any{"short_urls": []string{"abcde"}},
+			TokenInfo: workspaceEditor,
+			MoveErr:   errors.New("connection refused"),
+			Status:    http.StatusInternalServerError,
+		},
+	} {
+		t.Run(data.Name, func(t *testing.T) {
+			u := NewMockUrls(t)
+			if data.MoveErr != nil {
+				u.EXPECT().
+					MoveToWorkspace(mock.Anything, "id", "workspace", []string{"abcde"}).
+					Return(nil, data.MoveErr)
+			}
+
+			s := newTestShortener(t, u, bus_mocks.NewMockPublisher(t), data.TokenInfo)
+			recorder := httptest.NewRecorder()
+			s.MoveLinks(recorder, newAuthenticatedRequest(t, "POST", "/links/move", data.Body))
+
+			assert.Equal(t, data.Status, recorder.Result().StatusCode)
+		})
+	}
+}
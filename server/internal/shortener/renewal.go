@@ -0,0 +1,148 @@
+package shortener
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"shortener/pkg/domain"
+	"shortener/pkg/models/urls"
+	"shortener/pkg/responses"
+	"shortener/proto/blackbox"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+)
+
+// Renew extends the expiration date of a link by the period of the form,
+// within the max lifetime of the plan of the link, and sets its
+// auto-renewal. Personal links are renewed by their owners, links of a
+// workspace by its editors from the workspace.
+func (s *Shortener) Renew(w http.ResponseWriter, r *http.Request) {
+	shortUrl := r.PathValue("code")
+	log := hlog.FromRequest(r).
+		With().
+		Str("short_url", shortUrl).
+		Logger()
+
+	tokenInfo, ok := s.authenticate(w, r, &log)
+	if !ok {
+		return
+	}
+	log = log.With().
+		Str("user_id", tokenInfo.GetUserId()).
+		Str("workspace_id", tokenInfo.GetWorkspaceId()).
+		Logger()
+
+	var form renewReq
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't decode body of renewal request")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't process renewal form",
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(res)
+		return
+	}
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid renewal form")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "invalid renewal form",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(res)
+		return
+	}
+
+	l, ok := s.editableLink(w, r, &log, tokenInfo, shortUrl)
+	if !ok {
+		return
+	}
+
+	expiration, err := s.renewal.Extend(
+		l,
+		time.Hour*24*time.Duration(form.Expiration),
+		time.Now(),
+	)
+	if err != nil {
+		log.Info().Err(err).Str("plan", string(l.Plan)).Msg("couldn't extend link")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "link has reached the max lifetime of its plan",
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(res)
+		return
+	}
+	autoRenew := l.AutoRenew
+	if form.AutoRenew != nil {
+		autoRenew = *form.AutoRenew
+	}
+
+	if err := s.urls.Renew(r.Context(), shortUrl, expiration, autoRenew); err != nil {
+		log.Error().Err(err).Msg("couldn't renew link")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't renew link. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
+	}
+	log.Info().
+		Time("expiration_date", expiration).
+		Bool("auto_renew", autoRenew).
+		Msg("renewed link")
+
+	res, _ := json.Marshal(&responses.Renewal{
+		ShortUrl:       shortUrl,
+		ExpirationDate: expiration,
+		AutoRenew:      autoRenew,
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// editableLink returns the link if the session may change it: personal
+// links are changed by their owners, links of a workspace by its editors
+// from the workspace. The response has been written otherwise.
+func (s *Shortener) editableLink(
+	w http.ResponseWriter,
+	r *http.Request,
+	log *zerolog.Logger,
+	tokenInfo *blackbox.ValidateTokenRsp,
+	shortUrl string,
+) (*domain.Link, bool) {
+	l, err := s.urls.Link(r.Context(), shortUrl)
+	if err != nil && !errors.Is(err, urls.ErrNotFound) {
+		log.Error().Err(err).Msg("couldn't get link")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't get link. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return nil, false
+	}
+	// links of others are reported as missing, so that their codes can't be
+	// probed
+	if err != nil || !domain.Owns(tokenInfo, l.UserId, l.WorkspaceId) {
+		log.Info().Msg("link not found")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "link not found",
+		})
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(res)
+		return nil, false
+	}
+	if l.WorkspaceId != "" &&
+		!domain.Role(tokenInfo.GetRole()).Allows(domain.RoleEditor) {
+		log.Info().
+			Str("role", tokenInfo.GetRole()).
+			Msg("role doesn't allow changing links in workspace")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "insufficient role in workspace",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(res)
+		return nil, false
+	}
+	return l, true
+}
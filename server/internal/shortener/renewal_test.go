@@ -0,0 +1,211 @@
+package shortener
+
+import (
+	context "context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"shortener/pkg/domain"
+	"shortener/pkg/models/urls"
+	"shortener/pkg/renewal"
+	"shortener/pkg/responses"
+	"shortener/proto/blackbox"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	bus_mocks "shortener/mocks/shortener/pkg/bus"
+)
+
+func newRenewalRequest(t *testing.T, code string, form renewReq) *http.Request {
+	req := newAuthenticatedRequest(t, "POST", "/links/"+code+"/renew", &form)
+	req.SetPathValue("code", code)
+	return req
+}
+
+// renewalPolicy lets links of the free plan live for 100 days
+var renewalPolicy = renewal.Policy{
+	MaxLifetime: map[domain.Plan]time.Duration{
+		domain.PlanFree: 100 * 24 * time.Hour,
+	},
+	AutoRenewWithin: 24 * time.Hour,
+	AutoRenewBy:     24 * time.Hour,
+}
+
+func TestRenewal(t *testing.T) {
+	u := NewMockUrls(t)
+	expiration := time.Now().Add(24 * time.Hour)
+	u.EXPECT().Link(mock.Anything, "abcde").Return(&domain.Link{
+		ShortUrl:       "abcde",
+		UserId:         "id",
+		ExpirationDate: expiration,
+		Plan:           domain.PlanFree,
+	}, nil)
+	u.EXPECT().
+		Renew(mock.Anything, "abcde", expiration.Add(30*24*time.Hour), true).
+		Return(nil)
+
+	s := newTestShortener(
+		t,
+		u,
+		bus_mocks.NewMockPublisher(t),
+		&blackbox.ValidateTokenRsp{UserId: "id"},
+		WithRenewalPolicy(renewalPolicy),
+	)
+	autoRenew := true
+	recorder := httptest.NewRecorder()
+	s.Renew(recorder, newRenewalRequest(t, "abcde", renewReq{
+		Expiration: 30,
+		AutoRenew:  &autoRenew,
+	}))
+
+	assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	var rsp responses.Renewal
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&rsp))
+	assert.True(t, rsp.AutoRenew)
+	assert.WithinDuration(t, expiration.Add(30*24*time.Hour), rsp.ExpirationDate, 0)
+}
+
+func TestRenewalCutDownToMaxLifetime(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().Link(mock.Anything, "abcde").Return(&domain.Link{
+		ShortUrl:       "abcde",
+		UserId:         "id",
+		ExpirationDate: time.Now().Add(90 * 24 * time.Hour),
+		AutoRenew:      true,
+		Plan:           domain.PlanFree,
+	}, nil)
+	var renewed time.Time
+	u.EXPECT().
+		Renew(mock.Anything, "abcde", mock.Anything, true).
+		Run(func(_ context.Context, _ string, expiration time.Time, _ bool) {
+			renewed = expiration
+		}).
+		Return(nil)
+
+	s := newTestShortener(
+		t,
+		u,
+		bus_mocks.NewMockPublisher(t),
+		&blackbox.ValidateTokenRsp{UserId: "id"},
+		WithRenewalPolicy(renewalPolicy),
+	)
+	recorder := httptest.NewRecorder()
+	s.Renew(recorder, newRenewalRequest(t, "abcde", renewReq{Expiration: 365}))
+
+	assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	assert.WithinDuration(t, time.Now().Add(100*24*time.Hour), renewed, time.Minute)
+}
+
+func TestRenewalAtMaxLifetime(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().Link(mock.Anything, "abcde").Return(&domain.Link{
+		ShortUrl:       "abcde",
+		UserId:         "id",
+		ExpirationDate: time.Now().Add(200 * 24 * time.Hour),
+		Plan:           domain.PlanFree,
+	}, nil)
+
+	s := newTestShortener(
+		t,
+		u,
+		bus_mocks.NewMockPublisher(t),
+		&blackbox.ValidateTokenRsp{UserId: "id"},
+		WithRenewalPolicy(renewalPolicy),
+	)
+	recorder := httptest.NewRecorder()
+	s.Renew(recorder, newRenewalRequest(t, "abcde", renewReq{Expiration: 30}))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Result().StatusCode)
+}
+
+func TestRenewalNotFound(t *testing.T) {
+	for _, data := range []struct {
+		Name      string
+		Link      *domain.Link
+		Err       error
+		TokenInfo *blackbox.ValidateTokenRsp
+	}{
+		{
+			Name:      "missing link",
+			Err:       urls.ErrNotFound,
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id"},
+		},
+		{
+			Name:      "link of another user",
+			Link:      &domain.Link{ShortUrl: "abcde", UserId: "other"},
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id"},
+		},
+		{
+			Name:      "personal link from a workspace",
+			Link:      &domain.Link{ShortUrl: "abcde", UserId: "id"},
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id", WorkspaceId: "workspace"},
+		},
+		{
+			Name: "link of another workspace",
+			Link: &domain.Link{ShortUrl: "abcde", UserId: "id", WorkspaceId: "other"},
+			TokenInfo: &blackbox.ValidateTokenRsp{
+				UserId:      "id",
+				WorkspaceId: "workspace",
+				Role:        string(domain.RoleOwner),
+			},
+		},
+	} {
+		t.Run(data.Name, func(t *testing.T) {
+			u := NewMockUrls(t)
+			u.EXPECT().Link(mock.Anything, "abcde").Return(data.Link, data.Err)
+
+			s := newTestShortener(
+				t,
+				u,
+				bus_mocks.NewMockPublisher(t),
+				data.TokenInfo,
+				WithRenewalPolicy(renewalPolicy),
+			)
+			recorder := httptest.NewRecorder()
+			s.Renew(recorder, newRenewalRequest(t, "abcde", renewReq{Expiration: 30}))
+
+			assert.Equal(t, http.StatusNotFound, recorder.Result().StatusCode)
+		})
+	}
+}
+
+func TestRenewalWorkspaceViewerForbidden(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().Link(mock.Anything, "abcde").Return(&domain.Link{
+		ShortUrl:       "abcde",
+		UserId:         "other",
+		WorkspaceId:    "workspace",
+		ExpirationDate: time.Now().Add(24 * time.Hour),
+	}, nil)
+
+	s := newTestShortener(
+		t,
+		u,
+		bus_mocks.NewMockPublisher(t),
+		&blackbox.ValidateTokenRsp{
+			UserId:      "id",
+			WorkspaceId: "workspace",
+			Role:        string(domain.RoleViewer),
+		},
+		WithRenewalPolicy(renewalPolicy),
+	)
+	recorder := httptest.NewRecorder()
+	s.Renew(recorder, newRenewalRequest(t, "abcde", renewReq{Expiration: 30}))
+
+	assert.Equal(t, http.StatusForbidden, recorder.Result().StatusCode)
+}
+
+func TestRenewalRequiresAuthentication(t *testing.T) {
+	s := newTestShortener(t, NewMockUrls(t), bus_mocks.NewMockPublisher(t), nil)
+
+	req, err := http.NewRequest("POST", "/links/abcde/renew", nil)
+	assert.Nil(t, err)
+	req.SetPathValue("code", "abcde")
+	recorder := httptest.NewRecorder()
+	s.Renew(recorder, req)
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Result().StatusCode)
+}
@@ -0,0 +1,70 @@
+package shortener
+
+import (
+	"net/http"
+	"shortener/pkg/middleware"
+
+	"github.com/justinas/alice"
+)
+
+// Mount registers the routes of the service on the mux. Requests are handled
+// with the chain, e.g. middleware.RequestTracing, and allowed from the CORS
+// origin.
+func (s *Shortener) Mount(mux *http.ServeMux, c alice.Chain, cors middleware.Cors) {
+	mux.Handle("OPTIONS /create_short_url", cors.Preflight(""))
+	mux.Handle(
+		"POST /create_short_url",
+		c.Append(cors.Headers).ThenFunc(s.ShortenUrl),
+	)
+	mux.Handle("OPTIONS /links/{code}/renew", cors.Preflight(""))
+	mux.Handle(
+		"POST /links/{code}/renew",
+		c.Append(cors.Headers).ThenFunc(s.Renew),
+	)
+	mux.Handle("OPTIONS /links/bulk", cors.Preflight(""))
+	mux.Handle(
+		"POST /links/bulk",
+		c.Append(cors.Headers).ThenFunc(s.ShortenBulk),
+	)
+	mux.Handle("OPTIONS /links/move", cors.Preflight(""))
+	mux.Handle(
+		"POST /links/move",
+		c.Append(cors.Headers).ThenFunc(s.MoveLinks),
+	)
+	mux.Handle("OPTIONS /links/{code}", cors.Preflight("DELETE"))
+	mux.Handle(
+		"DELETE /links/{code}",
+		c.Append(cors.Headers).ThenFunc(s.Delete),
+	)
+
+	if s.bulkJobs != nil {
+		mux.Handle("OPTIONS /bulk/{id}", cors.Preflight(""))
+		mux.Handle(
+			"GET /bulk/{id}",
+			c.Append(cors.Headers).ThenFunc(s.BulkJob),
+		)
+	}
+
+	if s.webhooks == nil {
+		return
+	}
+	mux.Handle("OPTIONS /webhooks", cors.Preflight("GET, POST"))
+	mux.Handle(
+		"POST /webhooks",
+		c.Append(cors.Headers).ThenFunc(s.CreateWebhook),
+	)
+	mux.Handle(
+		"GET /webhooks",
+		c.Append(cors.Headers).ThenFunc(s.ListWebhooks),
+	)
+	mux.Handle("OPTIONS /webhooks/{id}", cors.Preflight("DELETE"))
+	mux.Handle(
+		"DELETE /webhooks/{id}",
+		c.Append(cors.Headers).ThenFunc(s.DeleteWebhook),
+	)
+	mux.Handle("OPTIONS /webhooks/{id}/deliveries", cors.Preflight(""))
+	mux.Handle(
+		"GET /webhooks/{id}/deliveries",
+		c.Append(cors.Headers).ThenFunc(s.WebhookDeliveries),
+	)
+}
@@ -1,14 +1,58 @@
 package shortener
 
-import "github.com/go-playground/validator/v10"
+import (
+	"shortener/pkg/domain"
 
-var validate = validator.New(validator.WithRequiredStructEnabled())
+	"github.com/go-playground/validator/v10"
+)
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterValidation("tag", func(fl validator.FieldLevel) bool {
+		return domain.ValidTag(fl.Field().String())
+	})
+	v.RegisterValidation("alias", func(fl validator.FieldLevel) bool {
+		return domain.ValidShortUrl(fl.Field().String())
+	})
+	return v
+}
 
 type noAuthShortenReq struct {
 	Url string `json:"url" validate:"required,url"`
 }
 
 type authShortenReq struct {
-	Url        string `json:"url"        validate:"required,url"`
-	Expiration int    `json:"expiration" validate:"required,oneof=30 90 365"`
+	Url        string   `json:"url"             validate:"required,url"`
+	Expiration int      `json:"expiration"      validate:"required,oneof=30 90 365"`
+	Title      string   `json:"title,omitempty" validate:"lte=300"`
+	Tags       []string `json:"tags,omitempty"  validate:"lte=20,unique,dive,tag"`
+}
+
+// bulkLinkReq validates a row of a bulk upload, see domain.BulkLink
+type bulkLinkReq struct {
+	Url        string   `json:"url"                  validate:"required,url"`
+	Alias      string   `json:"alias,omitempty"      validate:"omitempty,alias"`
+	Expiration int      `json:"expiration,omitempty" validate:"omitempty,oneof=30 90 365"`
+	Title      string   `json:"title,omitempty"      validate:"lte=300"`
+	Tags       []string `json:"tags,omitempty"       validate:"lte=20,unique,dive,tag"`
+}
+
+// renewReq extends the link by Expiration days. AutoRenew is kept as is if
+// omitted.
+type renewReq struct {
+	Expiration int   `json:"expiration" validate:"required,oneof=30 90 365"`
+	AutoRenew  *bool `json:"auto_renew"`
+}
+
+// moveLinksReq lists personal links to move into the workspace
+type moveLinksReq struct {
+	ShortUrls []string `json:"short_urls" validate:"required,gt=0,lte=100,unique,dive,alias"`
+}
+
+// webhookReq subscribes the url to the event types
+type webhookReq struct {
+	Url        string             `json:"url"         validate:"required,http_url,lte=300"`
+	EventTypes []domain.EventType `json:"event_types" validate:"required,gt=0,unique,dive,oneof=link.created link.clicked link.expired link.deleted"`
 }
@@ -5,27 +5,73 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"shortener/pkg/bus"
+	"shortener/pkg/domain"
+	"shortener/pkg/events"
+	"shortener/pkg/renewal"
 	"shortener/pkg/responses"
 	"shortener/proto/blackbox"
+	eventsv1 "shortener/proto/events/v1"
 	"time"
 
-	"github.com/IBM/sarama"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type Urls interface {
 	CheckExistence(ctx context.Context, shortUrl string) (bool, error)
+	Link(ctx context.Context, shortUrl string) (*domain.Link, error)
+	Renew(ctx context.Context, shortUrl string, expiration time.Time, autoRenew bool) error
+	Delete(ctx context.Context, shortUrl string) error
+	// MoveToWorkspace moves personal links of the user into the workspace
+	// and returns the moved ones
+	MoveToWorkspace(
+		ctx context.Context,
+		userId string,
+		workspaceId string,
+		shortUrls []string,
+	) ([]string, error)
+	// TakenShortUrls returns those of the short urls that links have, or
+	// that archived links still hold
+	TakenShortUrls(ctx context.Context, shortUrls []string) ([]string, error)
+}
+
+// Outbox durably stores events until they are published
+type Outbox interface {
+	Add(
+		ctx context.Context,
+		topic string,
+		key []byte,
+		payload []byte,
+		headers map[string]string,
+	) error
+	AddBatch(ctx context.Context, mm []*bus.Message) error
 }
 
 type Shortener struct {
 	urls           Urls
 	blackboxClient blackbox.BlackboxServiceClient
 	redirectorHost string
+	renewal        renewal.Policy
+
+	publisher bus.Publisher
+	outbox    Outbox
+	topic     string
+	// deletions of links are published to it if set
+	eventsTopic string
 
-	producer sarama.AsyncProducer
-	topic    string
+	webhooks Webhooks
+
+	// bulk uploads of more rows than the sync limit are created by jobs if
+	// they are set, see Importer
+	bulkJobs      BulkJobs
+	bulkSyncLimit int
+	bulkMaxRows   int
 }
 
 type shortenerOption func(s *Shortener) error
@@ -44,14 +90,32 @@ func WithUrlsModel(u Urls) shortenerOption {
 	}
 }
 
-func WithKafkaProducer(p sarama.AsyncProducer, topic string) shortenerOption {
+func WithPublisher(p bus.Publisher, topic string) shortenerOption {
 	return func(s *Shortener) error {
-		s.producer = p
+		s.publisher = p
 		s.topic = topic
 		return nil
 	}
 }
 
+// WithOutbox makes links be written to the outbox rather than straight to
+// the bus
+func WithOutbox(o Outbox, topic string) shortenerOption {
+	return func(s *Shortener) error {
+		s.outbox = o
+		s.topic = topic
+		return nil
+	}
+}
+
+// WithEventsTopic publishes deletions of links to the topic, for webhooks
+func WithEventsTopic(topic string) shortenerOption {
+	return func(s *Shortener) error {
+		s.eventsTopic = topic
+		return nil
+	}
+}
+
 func WithRedirectorHost(host string) shortenerOption {
 	return func(s *Shortener) error {
 		s.redirectorHost = host
@@ -59,8 +123,20 @@ func WithRedirectorHost(host string) shortenerOption {
 	}
 }
 
+// WithRenewalPolicy limits renewals of links, renewal.DefaultPolicy is used
+// otherwise
+func WithRenewalPolicy(p renewal.Policy) shortenerOption {
+	return func(s *Shortener) error {
+		s.renewal = p
+		return nil
+	}
+}
+
 func New(opts ...shortenerOption) (*Shortener, error) {
-	s := new(Shortener)
+	s := &Shortener{
+		renewal:     renewal.DefaultPolicy(),
+		bulkMaxRows: DefaultBulkMaxRows,
+	}
 	for _, opt := range opts {
 		if err := opt(s); err != nil {
 			return nil, err
@@ -75,8 +151,8 @@ func New(opts ...shortenerOption) (*Shortener, error) {
 		return nil, errors.New("no blackbox client provided")
 	}
 
-	if s.producer == nil {
-		return nil, errors.New("no kafka producer provided")
+	if s.publisher == nil && s.outbox == nil {
+		return nil, errors.New("no publisher or outbox provided")
 	}
 
 	if s.redirectorHost == "" {
@@ -86,6 +162,53 @@ func New(opts ...shortenerOption) (*Shortener, error) {
 	return s, nil
 }
 
+// publish sends the event to the topic, e.g. links to the Storage service.
+// An asynchronous publisher reports delivery errors on its own.
+func (s *Shortener) publish(ctx context.Context, topic string, e proto.Message) error {
+	m, contentType, err := events.Encode(e)
+	if err != nil {
+		return err
+	}
+
+	if s.outbox != nil {
+		return s.outbox.Add(
+			ctx,
+			topic,
+			nil,
+			m,
+			map[string]string{events.HeaderContentType: contentType},
+		)
+	}
+
+	return s.publisher.Publish(ctx, &bus.Message{
+		Topic:   topic,
+		Value:   m,
+		Headers: map[string]string{events.HeaderContentType: contentType},
+	})
+}
+
+// publishAll sends the events to the topic at once. Errors of some of the
+// events are bus.PublishErrors, indexed like the events.
+func (s *Shortener) publishAll(ctx context.Context, topic string, ee []proto.Message) error {
+	mm := make([]*bus.Message, len(ee))
+	for i, e := range ee {
+		m, contentType, err := events.Encode(e)
+		if err != nil {
+			return err
+		}
+		mm[i] = &bus.Message{
+			Topic:   topic,
+			Value:   m,
+			Headers: map[string]string{events.HeaderContentType: contentType},
+		}
+	}
+
+	if s.outbox != nil {
+		return s.outbox.AddBatch(ctx, mm)
+	}
+	return s.publisher.Publish(ctx, mm...)
+}
+
 func (s *Shortener) ShortenUrl(w http.ResponseWriter, r *http.Request) {
 	log := hlog.FromRequest(r)
 
@@ -115,10 +238,26 @@ func (s *Shortener) ShortenUrl(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Info().Msg("got request with JWT. validating")
+	tokenInfo, ok := s.validateToken(w, log, JWTCookie.Value)
+	if !ok {
+		return
+	}
+
+	log.Info().Str("user_id", tokenInfo.GetUserId()).Msg("got valid token")
+	s.shortenAuth(tokenInfo, w, r)
+}
+
+// validateToken validates the session token with the Blackbox service. The
+// response is written if the token isn't valid.
+func (s *Shortener) validateToken(
+	w http.ResponseWriter,
+	log *zerolog.Logger,
+	token string,
+) (*blackbox.ValidateTokenRsp, bool) {
 	tokenInfo, err := s.blackboxClient.ValidateToken(
 		context.TODO(),
 		&blackbox.ValidateTokenReq{
-			Token: JWTCookie.Value,
+			Token: token,
 		},
 	)
 	if err != nil {
@@ -130,7 +269,7 @@ func (s *Shortener) ShortenUrl(w http.ResponseWriter, r *http.Request) {
 			})
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write(res)
-			return
+			return nil, false
 		}
 
 		switch s.Code() {
@@ -155,19 +294,57 @@ func (s *Shortener) ShortenUrl(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write(res)
 		}
-		return
+		return nil, false
 	}
+	return tokenInfo, true
+}
 
-	log.Info().Str("user_id", tokenInfo.GetUserId()).Msg("got valid token")
-	s.shortenAuth(tokenInfo.GetUserId(), w, r)
+// authenticate validates the JWT cookie of the request. The response has
+// been written if it fails.
+func (s *Shortener) authenticate(
+	w http.ResponseWriter,
+	r *http.Request,
+	log *zerolog.Logger,
+) (*blackbox.ValidateTokenRsp, bool) {
+	JWTCookie, err := r.Cookie("JWT")
+	if err != nil {
+		log.Info().Err(err).Msg("no JWT cookie found")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "authentication required",
+		})
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(res)
+		return nil, false
+	}
+	return s.validateToken(w, log, JWTCookie.Value)
 }
 
 func (s *Shortener) shortenAuth(
-	userId string,
+	tokenInfo *blackbox.ValidateTokenRsp,
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	log := hlog.FromRequest(r).With().Str("user_id", userId).Logger()
+	userId := tokenInfo.GetUserId()
+	workspaceId := tokenInfo.GetWorkspaceId()
+	log := hlog.FromRequest(r).
+		With().
+		Str("user_id", userId).
+		Str("workspace_id", workspaceId).
+		Logger()
+
+	if workspaceId != "" &&
+		!domain.Role(tokenInfo.GetRole()).Allows(domain.RoleEditor) {
+		log.Info().
+			Str("role", tokenInfo.GetRole()).
+			Msg("role doesn't allow creating links in workspace")
+
+		res, _ := json.Marshal(&responses.Server{
+			Message: "insufficient role in workspace",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(res)
+		return
+	}
 
 	log.Info().Msg("decoding request body")
 	d := json.NewDecoder(r.Body)
@@ -196,33 +373,29 @@ func (s *Shortener) shortenAuth(
 	}
 	log.Info().Msg("got valid shortening form")
 
-	var shortUrl string
-	for {
-		shortUrl = generateShortUrl(5)
-		exists, err := s.urls.CheckExistence(context.TODO(), shortUrl)
-		if err != nil {
-			log.Error().
-				Err(err).
-				Msg("couldn't check existence of short url in database. will reattempt database request")
-			continue
-		}
-		if !exists {
-			break
-		}
-	}
+	shortUrl := s.uniqueShortUrl(&log)
 
-	m, _ := json.Marshal(&responses.Shortener{
-		From:     userId,
-		ShortUrl: shortUrl,
-		LongUrl:  form.Url,
-		ExpirationDate: time.Now().
-			Add(time.Hour * 24 * time.Duration(form.Expiration)),
-	})
+	e := &eventsv1.LinkCreated{
+		IdempotencyKey: uuid.New().String(),
+		ShortUrl:       shortUrl,
+		LongUrl:        form.Url,
+		UserId:         userId,
+		ExpirationDate: timestamppb.New(time.Now().
+			Add(time.Hour * 24 * time.Duration(form.Expiration))),
+		WorkspaceId: workspaceId,
+		Title:       form.Title,
+		Tags:        form.Tags,
+	}
 
 	log.Info().Msg("sending short url to storage service")
-	s.producer.Input() <- &sarama.ProducerMessage{
-		Topic: s.topic,
-		Value: sarama.ByteEncoder(m),
+	if err := s.publish(r.Context(), s.topic, e); err != nil {
+		log.Error().Err(err).Msg("couldn't publish short url")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't save short url. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
 	}
 
 	res, _ := json.Marshal(&responses.Server{
@@ -263,34 +436,25 @@ func (s *Shortener) shortenNoAuth(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Info().Msg("got valid shortening form")
 
-	var shortUrl string
-	for {
-		shortUrl = generateShortUrl(5)
-		exists, err := s.urls.CheckExistence(context.TODO(), shortUrl)
-		if err != nil {
-			log.Error().
-				Err(err).
-				Msg("couldn't check existence of short url in database. will reattempt database request")
-			continue
-		}
-		if !exists {
-			break
-		}
-	}
+	shortUrl := s.uniqueShortUrl(log)
 
-	// uuid of an anonymous user
-	const dummyUUID = "db092ed4-306a-4d4f-be5f-fd2f1487edbe"
-	m, _ := json.Marshal(&responses.Shortener{
-		From:           dummyUUID,
+	e := &eventsv1.LinkCreated{
+		IdempotencyKey: uuid.New().String(),
 		ShortUrl:       shortUrl,
 		LongUrl:        form.Url,
-		ExpirationDate: time.Now().Add(time.Hour * 24 * 30),
-	})
+		UserId:         domain.AnonymousUserId,
+		ExpirationDate: timestamppb.New(time.Now().Add(time.Hour * 24 * 30)),
+	}
 
 	log.Info().Msg("sending short url to storage service")
-	s.producer.Input() <- &sarama.ProducerMessage{
-		Topic: s.topic,
-		Value: sarama.ByteEncoder(m),
+	if err := s.publish(r.Context(), s.topic, e); err != nil {
+		log.Error().Err(err).Msg("couldn't publish short url")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't save short url. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
 	}
 
 	res, _ := json.Marshal(&responses.Server{
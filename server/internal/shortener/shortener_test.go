@@ -4,33 +4,79 @@ import (
 	"bytes"
 	context "context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"shortener/pkg/bus"
+	"shortener/pkg/domain"
+	"shortener/pkg/events"
 	"shortener/proto/blackbox"
+	eventsv1 "shortener/proto/events/v1"
+	"strings"
 	"testing"
-	"time"
 
-	"github.com/IBM/sarama"
-	"github.com/IBM/sarama/mocks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
+	bus_mocks "shortener/mocks/shortener/pkg/bus"
 	pbblackbox_mocks "shortener/mocks/shortener/proto/blackbox"
 )
 
+// newTestShortener returns the shortener over the mocks, which
+// authenticates requests of newAuthenticatedRequest as tokenInfo unless it's
+// nil. opts are applied after the common ones.
+func newTestShortener(
+	t *testing.T,
+	u Urls,
+	p bus.Publisher,
+	tokenInfo *blackbox.ValidateTokenRsp,
+	opts ...shortenerOption,
+) *Shortener {
+	c := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	if tokenInfo != nil {
+		c.EXPECT().ValidateToken(context.TODO(), &blackbox.ValidateTokenReq{
+			Token: "token",
+		}).Return(tokenInfo, nil)
+	}
+
+	s, err := New(append([]shortenerOption{
+		WithPublisher(p, "topic"),
+		WithUrlsModel(u),
+		WithRedirectorHost("host"),
+		WithBlackboxClient(c),
+	}, opts...)...)
+	assert.Nil(t, err)
+	return s
+}
+
+// newAuthenticatedRequest returns the request with the JWT cookie. Bodies
+// other than strings are marshalled to JSON.
+func newAuthenticatedRequest(t *testing.T, method, path string, body any) *http.Request {
+	var b []byte
+	switch body := body.(type) {
+	case nil:
+	case string:
+		b = []byte(body)
+	default:
+		b, _ = json.Marshal(body)
+	}
+	req, err := http.NewRequest(method, path, bytes.NewReader(b))
+	assert.Nil(t, err)
+	req.AddCookie(&http.Cookie{Name: "JWT", Value: "token"})
+	return req
+}
+
 func TestShorteningNoAuth(t *testing.T) {
 	u := NewMockUrls(t)
 	c := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
-	conf := sarama.NewConfig()
-	conf.Producer.RequiredAcks = sarama.WaitForAll
-	conf.Producer.Flush.Frequency = 500 * time.Millisecond
-	conf.Producer.Return.Errors = false
-	p := mocks.NewAsyncProducer(t, conf).ExpectInputAndSucceed()
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().Publish(mock.Anything, mock.Anything).Return(nil).Once()
 
 	shortener, err := New(
-		WithKafkaProducer(p, "topic"),
+		WithPublisher(p, "topic"),
 		WithUrlsModel(u),
 		WithRedirectorHost("host"),
 		WithBlackboxClient(c),
@@ -79,14 +125,11 @@ func TestShorteningAuth(t *testing.T) {
 		t.Run(data.Name, func(t *testing.T) {
 			u := NewMockUrls(t)
 			c := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
-			conf := sarama.NewConfig()
-			conf.Producer.RequiredAcks = sarama.WaitForAll
-			conf.Producer.Flush.Frequency = 500 * time.Millisecond
-			conf.Producer.Return.Errors = false
-			p := mocks.NewAsyncProducer(t, conf).ExpectInputAndSucceed()
+			p := bus_mocks.NewMockPublisher(t)
+			p.EXPECT().Publish(mock.Anything, mock.Anything).Return(nil).Once()
 
 			shortener, err := New(
-				WithKafkaProducer(p, "topic"),
+				WithPublisher(p, "topic"),
 				WithUrlsModel(u),
 				WithRedirectorHost("host"),
 				WithBlackboxClient(c),
@@ -139,17 +182,31 @@ func TestShorteningAuth(t *testing.T) {
 	}
 }
 
+func TestShortenFormTags(t *testing.T) {
+	form := func(title string, tags ...string) *authShortenReq {
+		return &authShortenReq{
+			Url:        "https://example.com",
+			Expiration: 30,
+			Title:      title,
+			Tags:       tags,
+		}
+	}
+	assert.Nil(t, validate.Struct(form("")))
+	assert.Nil(t, validate.Struct(form("Docs", "docs", "release-2024", "q_3")))
+	assert.NotNil(t, validate.Struct(form("", "Docs")))
+	assert.NotNil(t, validate.Struct(form("", "two words")))
+	assert.NotNil(t, validate.Struct(form("", "docs", "docs")))
+	assert.NotNil(t, validate.Struct(form("", strings.Repeat("a", 33))))
+	assert.NotNil(t, validate.Struct(form(strings.Repeat("a", 301))))
+}
+
 func TestShorteningAuthUnexpectedExpiration(t *testing.T) {
 	u := NewMockUrls(t)
 	c := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
-	conf := sarama.NewConfig()
-	conf.Producer.RequiredAcks = sarama.WaitForAll
-	conf.Producer.Flush.Frequency = 500 * time.Millisecond
-	conf.Producer.Return.Errors = false
-	p := mocks.NewAsyncProducer(t, conf)
+	p := bus_mocks.NewMockPublisher(t)
 
 	shortener, err := New(
-		WithKafkaProducer(p, "topic"),
+		WithPublisher(p, "topic"),
 		WithUrlsModel(u),
 		WithRedirectorHost("host"),
 		WithBlackboxClient(c),
@@ -200,14 +257,10 @@ func TestShorteningAuthUnexpectedExpiration(t *testing.T) {
 func TestShorteningAuthBrokenToken(t *testing.T) {
 	u := NewMockUrls(t)
 	c := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
-	conf := sarama.NewConfig()
-	conf.Producer.RequiredAcks = sarama.WaitForAll
-	conf.Producer.Flush.Frequency = 500 * time.Millisecond
-	conf.Producer.Return.Errors = false
-	p := mocks.NewAsyncProducer(t, conf)
+	p := bus_mocks.NewMockPublisher(t)
 
 	shortener, err := New(
-		WithKafkaProducer(p, "topic"),
+		WithPublisher(p, "topic"),
 		WithUrlsModel(u),
 		WithRedirectorHost("host"),
 		WithBlackboxClient(c),
@@ -255,3 +308,163 @@ func TestShorteningAuthBrokenToken(t *testing.T) {
 
 	assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
 }
+
+func TestShorteningWorkspaceViewerForbidden(t *testing.T) {
+	c := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	p := bus_mocks.NewMockPublisher(t)
+
+	shortener, err := New(
+		WithPublisher(p, "topic"),
+		WithUrlsModel(NewMockUrls(t)),
+		WithRedirectorHost("host"),
+		WithBlackboxClient(c),
+	)
+	assert.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+
+	marshalledBody, _ := json.Marshal(&authShortenReq{
+		Url:        "localhost:8080/longlink",
+		Expiration: 90,
+	})
+
+	req, err := http.NewRequest(
+		"POST",
+		"/create_short_url",
+		bytes.NewReader(marshalledBody),
+	)
+	assert.Nil(t, err)
+	req.AddCookie(&http.Cookie{Name: "JWT", Value: "token"})
+
+	c.EXPECT().ValidateToken(context.TODO(), &blackbox.ValidateTokenReq{
+		Token: "token",
+	}).Return(&blackbox.ValidateTokenRsp{
+		UserId:      "id",
+		WorkspaceId: "workspace",
+		Role:        string(domain.RoleViewer),
+	}, nil)
+
+	shortener.ShortenUrl(recorder, req)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Result().StatusCode)
+}
+
+func TestShorteningWorkspaceEditor(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().
+		CheckExistence(context.TODO(), mock.AnythingOfType("string")).
+		Return(false, nil)
+
+	c := pbblackbox_mocks.NewMockBlackboxServiceClient(t)
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().
+		Publish(mock.Anything, mock.MatchedBy(func(m *bus.Message) bool {
+			var e eventsv1.LinkCreated
+			if err := proto.Unmarshal(m.Value, &e); err != nil {
+				return false
+			}
+			return m.Topic == "topic" && e.WorkspaceId == "workspace"
+		})).
+		Return(nil).
+		Once()
+
+	shortener, err := New(
+		WithPublisher(p, "topic"),
+		WithUrlsModel(u),
+		WithRedirectorHost("host"),
+		WithBlackboxClient(c),
+	)
+	assert.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+
+	marshalledBody, _ := json.Marshal(&authShortenReq{
+		Url:        "localhost:8080/longlink",
+		Expiration: 90,
+	})
+
+	req, err := http.NewRequest(
+		"POST",
+		"/create_short_url",
+		bytes.NewReader(marshalledBody),
+	)
+	assert.Nil(t, err)
+	req.AddCookie(&http.Cookie{Name: "JWT", Value: "token"})
+
+	c.EXPECT().ValidateToken(context.TODO(), &blackbox.ValidateTokenReq{
+		Token: "token",
+	}).Return(&blackbox.ValidateTokenRsp{
+		UserId:      "id",
+		WorkspaceId: "workspace",
+		Role:        string(domain.RoleEditor),
+	}, nil)
+
+	shortener.ShortenUrl(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+}
+
+func TestShorteningOutbox(t *testing.T) {
+	for _, data := range []struct {
+		Name   string
+		Err    error
+		Status int
+	}{
+		{
+			Name:   "stored",
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "outbox failure",
+			Err:    fmt.Errorf("connection refused"),
+			Status: http.StatusInternalServerError,
+		},
+	} {
+		t.Run(data.Name, func(t *testing.T) {
+			u := NewMockUrls(t)
+			u.EXPECT().
+				CheckExistence(context.TODO(), mock.AnythingOfType("string")).
+				Return(false, nil)
+
+			o := NewMockOutbox(t)
+			o.EXPECT().
+				Add(
+					mock.Anything,
+					"topic",
+					[]byte(nil),
+					mock.MatchedBy(func(payload []byte) bool {
+						var msg eventsv1.LinkCreated
+						err := proto.Unmarshal(payload, &msg)
+						return err == nil && msg.LongUrl == "localhost:8080/longlink"
+					}),
+					map[string]string{
+						events.HeaderContentType: events.ContentType(&eventsv1.LinkCreated{}),
+					},
+				).
+				Return(data.Err)
+
+			shortener, err := New(
+				WithOutbox(o, "topic"),
+				WithUrlsModel(u),
+				WithRedirectorHost("host"),
+				WithBlackboxClient(pbblackbox_mocks.NewMockBlackboxServiceClient(t)),
+			)
+			assert.Nil(t, err)
+
+			marshalledBody, _ := json.Marshal(&noAuthShortenReq{
+				Url: "localhost:8080/longlink",
+			})
+			req, err := http.NewRequest(
+				"POST",
+				"/create_short_url",
+				bytes.NewReader(marshalledBody),
+			)
+			assert.Nil(t, err)
+
+			recorder := httptest.NewRecorder()
+			shortener.ShortenUrl(recorder, req)
+
+			assert.Equal(t, data.Status, recorder.Result().StatusCode)
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package shortener
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"shortener/pkg/domain"
+	"shortener/pkg/egress"
+	"shortener/pkg/models/webhooks"
+	"shortener/pkg/responses"
+	"shortener/proto/blackbox"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+)
+
+// Webhooks keeps webhook subscriptions and their delivery logs, see
+// webhooks.Model
+type Webhooks interface {
+	CreateSubscription(ctx context.Context, sub *domain.WebhookSubscription) error
+	Subscriptions(
+		ctx context.Context,
+		userId string,
+		workspaceId string,
+	) ([]*domain.WebhookSubscription, error)
+	Subscription(ctx context.Context, id string) (*domain.WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, id string) error
+	Deliveries(
+		ctx context.Context,
+		subscriptionId string,
+		limit int,
+	) ([]*domain.WebhookDelivery, error)
+}
+
+// WithWebhooks serves the webhook subscription routes
+func WithWebhooks(wh Webhooks) shortenerOption {
+	return func(s *Shortener) error {
+		s.webhooks = wh
+		return nil
+	}
+}
+
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 200
+)
+
+// CreateWebhook subscribes the url to events of links of the user, or of
+// the workspace for sessions in one. Only workspace admins manage webhooks
+// of workspaces, and only urls of public hosts are accepted. The secret
+// deliveries are signed with is only returned here.
+func (s *Shortener) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r).With().Logger()
+	tokenInfo, ok := s.webhookAdmin(w, r, &log)
+	if !ok {
+		return
+	}
+
+	var form webhookReq
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		log.Error().Err(err).Msg("couldn't decode body of webhook request")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't process webhook form",
+		})
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(res)
+		return
+	}
+	if err := validate.Struct(&form); err != nil {
+		log.Error().Err(err).Msg("invalid webhook form")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "invalid webhook form",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(res)
+		return
+	}
+	// deliveries are refused to connect to such hosts anyway
+	if err := egress.CheckUrl(r.Context(), form.Url); err != nil {
+		log.Info().Err(err).Msg("webhook url isn't public")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "webhook url must be public",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(res)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Error().Err(err).Msg("couldn't generate webhook secret")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't create webhook. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
+	}
+	sub := &domain.WebhookSubscription{
+		UserId:      tokenInfo.GetUserId(),
+		WorkspaceId: tokenInfo.GetWorkspaceId(),
+		Url:         form.Url,
+		Secret:      hex.EncodeToString(secret),
+		EventTypes:  form.EventTypes,
+	}
+	if err := s.webhooks.CreateSubscription(r.Context(), sub); err != nil {
+		log.Error().Err(err).Msg("couldn't create webhook")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't create webhook. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
+	}
+	log.Info().Str("webhook_id", sub.Id).Msg("created webhook")
+
+	res, _ := json.Marshal(sub)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+// ListWebhooks returns webhooks of the user, or of the workspace for
+// sessions in one
+func (s *Shortener) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r).With().Logger()
+	tokenInfo, ok := s.authenticate(w, r, &log)
+	if !ok {
+		return
+	}
+
+	subs, err := s.webhooks.Subscriptions(
+		r.Context(),
+		tokenInfo.GetUserId(),
+		tokenInfo.GetWorkspaceId(),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't get webhooks")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't get webhooks. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
+	}
+	if subs == nil {
+		subs = []*domain.WebhookSubscription{}
+	}
+
+	res, _ := json.Marshal(subs)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// DeleteWebhook unsubscribes the webhook, pending deliveries are dropped
+func (s *Shortener) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r).
+		With().
+		Str("webhook_id", r.PathValue("id")).
+		Logger()
+	tokenInfo, ok := s.webhookAdmin(w, r, &log)
+	if !ok {
+		return
+	}
+	sub, ok := s.ownedWebhook(w, r, &log, tokenInfo)
+	if !ok {
+		return
+	}
+
+	err := s.webhooks.DeleteSubscription(r.Context(), sub.Id)
+	if err != nil && !errors.Is(err, webhooks.ErrNotFound) {
+		log.Error().Err(err).Msg("couldn't delete webhook")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't delete webhook. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
+	}
+	log.Info().Msg("deleted webhook")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveries returns the latest deliveries of the webhook, up to the
+// limit query parameter
+func (s *Shortener) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r).
+		With().
+		Str("webhook_id", r.PathValue("id")).
+		Logger()
+	tokenInfo, ok := s.authenticate(w, r, &log)
+	if !ok {
+		return
+	}
+
+	limit := defaultDeliveriesLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxDeliveriesLimit {
+			log.Info().Str("limit", l).Msg("invalid deliveries limit")
+			res, _ := json.Marshal(&responses.Server{
+				Message: "limit must be from 1 to " + strconv.Itoa(maxDeliveriesLimit),
+			})
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(res)
+			return
+		}
+		limit = n
+	}
+
+	sub, ok := s.ownedWebhook(w, r, &log, tokenInfo)
+	if !ok {
+		return
+	}
+	dd, err := s.webhooks.Deliveries(r.Context(), sub.Id, limit)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't get deliveries")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't get deliveries. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
+	}
+	if dd == nil {
+		dd = []*domain.WebhookDelivery{}
+	}
+
+	res, _ := json.Marshal(dd)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// webhookAdmin authenticates the request and checks that the session may
+// manage webhooks: anyone may manage their own ones, only admins the ones
+// of a workspace
+func (s *Shortener) webhookAdmin(
+	w http.ResponseWriter,
+	r *http.Request,
+	log *zerolog.Logger,
+) (*blackbox.ValidateTokenRsp, bool) {
+	tokenInfo, ok := s.authenticate(w, r, log)
+	if !ok {
+		return nil, false
+	}
+	if tokenInfo.GetWorkspaceId() != "" &&
+		!domain.Role(tokenInfo.GetRole()).Allows(domain.RoleAdmin) {
+		log.Info().
+			Str("role", tokenInfo.GetRole()).
+			Msg("role doesn't allow managing webhooks of workspace")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "insufficient role in workspace",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(res)
+		return nil, false
+	}
+	return tokenInfo, true
+}
+
+// ownedWebhook returns the webhook of the path if it belongs to the user,
+// or to the workspace the session is in. Webhooks of others are reported
+// as missing.
+func (s *Shortener) ownedWebhook(
+	w http.ResponseWriter,
+	r *http.Request,
+	log *zerolog.Logger,
+	tokenInfo *blackbox.ValidateTokenRsp,
+) (*domain.WebhookSubscription, bool) {
+	var sub *domain.WebhookSubscription
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		err = webhooks.ErrNotFound
+	} else {
+		sub, err = s.webhooks.Subscription(r.Context(), id.String())
+	}
+	if err != nil && !errors.Is(err, webhooks.ErrNotFound) {
+		log.Error().Err(err).Msg("couldn't get webhook")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't get webhook. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return nil, false
+	}
+	if err != nil || !domain.Owns(tokenInfo, sub.UserId, sub.WorkspaceId) {
+		log.Info().Msg("webhook not found")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "webhook not found",
+		})
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(res)
+		return nil, false
+	}
+	return sub, true
+}
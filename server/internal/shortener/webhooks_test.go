@@ -0,0 +1,333 @@
+package shortener
+
+import (
+	context "context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"shortener/pkg/bus"
+	"shortener/pkg/domain"
+	"shortener/pkg/events"
+	"shortener/pkg/models/urls"
+	"shortener/proto/blackbox"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	bus_mocks "shortener/mocks/shortener/pkg/bus"
+)
+
+const webhookId = "0b9a7c52-5d7e-4c1f-9a0e-3f7f5d0c8e11"
+
+func TestDeletePublishesDeletion(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().Link(mock.Anything, "abcde").Return(&domain.Link{
+		ShortUrl:    "abcde",
+		LongUrl:     "https://example.com",
+		UserId:      "id",
+		WorkspaceId: "workspace",
+	}, nil)
+	u.EXPECT().Delete(mock.Anything, "abcde").Return(nil)
+
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().
+		Publish(mock.Anything, mock.MatchedBy(func(mes *bus.Message) bool {
+			e, err := events.DecodeLinkEvent(mes)
+			return err == nil &&
+				mes.Topic == "link-events" &&
+				e.Type == domain.EventLinkDeleted &&
+				e.ShortUrl == "abcde" &&
+				e.LongUrl == "https://example.com" &&
+				e.WorkspaceId == "workspace"
+		})).
+		Return(nil)
+
+	s := newTestShortener(
+		t,
+		u,
+		p,
+		&blackbox.ValidateTokenRsp{
+			UserId:      "id",
+			WorkspaceId: "workspace",
+			Role:        string(domain.RoleEditor),
+		},
+		WithEventsTopic("link-events"),
+		WithWebhooks(NewMockWebhooks(t)),
+	)
+	req := newAuthenticatedRequest(t, "DELETE", "/links/abcde", nil)
+	req.SetPathValue("code", "abcde")
+	recorder := httptest.NewRecorder()
+	s.Delete(recorder, req)
+
+	assert.Equal(t, http.StatusNoContent, recorder.Result().StatusCode)
+}
+
+func TestDeleteChecksOwnership(t *testing.T) {
+	for _, data := range []struct {
+		Name      string
+		Link      *domain.Link
+		Err       error
+		TokenInfo *blackbox.ValidateTokenRsp
+		Status    int
+	}{
+		{
+			Name:      "missing link",
+			Err:       urls.ErrNotFound,
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id"},
+			Status:    http.StatusNotFound,
+		},
+		{
+			Name:      "link of another user",
+			Link:      &domain.Link{ShortUrl: "abcde", UserId: "other"},
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id"},
+			Status:    http.StatusNotFound,
+		},
+		{
+			Name: "workspace viewer",
+			Link: &domain.Link{ShortUrl: "abcde", UserId: "other", WorkspaceId: "workspace"},
+			TokenInfo: &blackbox.ValidateTokenRsp{
+				UserId:      "id",
+				WorkspaceId: "workspace",
+				Role:        string(domain.RoleViewer),
+			},
+			Status: http.StatusForbidden,
+		},
+	} {
+		t.Run(data.Name, func(t *testing.T) {
+			u := NewMockUrls(t)
+			u.EXPECT().Link(mock.Anything, "abcde").Return(data.Link, data.Err)
+
+			s := newTestShortener(
+				t,
+				u,
+				bus_mocks.NewMockPublisher(t),
+				data.TokenInfo,
+				WithEventsTopic("link-events"),
+				WithWebhooks(NewMockWebhooks(t)),
+			)
+			req := newAuthenticatedRequest(t, "DELETE", "/links/abcde", nil)
+			req.SetPathValue("code", "abcde")
+			recorder := httptest.NewRecorder()
+			s.Delete(recorder, req)
+
+			assert.Equal(t, data.Status, recorder.Result().StatusCode)
+		})
+	}
+}
+
+func TestCreateWebhookReturnsSecret(t *testing.T) {
+	wh := NewMockWebhooks(t)
+	wh.EXPECT().
+		CreateSubscription(mock.Anything, mock.MatchedBy(func(sub *domain.WebhookSubscription) bool {
+			return sub.UserId == "id" &&
+				sub.WorkspaceId == "workspace" &&
+				sub.Url == "https://example.com/hook" &&
+				len(sub.Secret) == 64 &&
+				len(sub.EventTypes) == 2
+		})).
+		Run(func(_ context.Context, sub *domain.WebhookSubscription) {
+			sub.Id = webhookId
+		}).
+		Return(nil)
+
+	s := newTestShortener(
+		t,
+		NewMockUrls(t),
+		bus_mocks.NewMockPublisher(t),
+		&blackbox.ValidateTokenRsp{
+			UserId:      "id",
+			WorkspaceId: "workspace",
+			Role:        string(domain.RoleAdmin),
+		},
+		WithEventsTopic("link-events"),
+		WithWebhooks(wh),
+	)
+	recorder := httptest.NewRecorder()
+	s.CreateWebhook(recorder, newAuthenticatedRequest(t, "POST", "/webhooks", map[string]any{
+		"url":         "https://example.com/hook",
+		"event_types": []string{"link.created", "link.clicked"},
+	}))
+
+	assert.Equal(t, http.StatusCreated, recorder.Result().StatusCode)
+	var sub domain.WebhookSubscription
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&sub))
+	assert.Equal(t, webhookId, sub.Id)
+	assert.Len(t, sub.Secret, 64)
+}
+
+func TestCreateWebhookValidation(t *testing.T) {
+	for _, data := range []struct {
+		Name      string
+		Form      map[string]any
+		TokenInfo *blackbox.ValidateTokenRsp
+		Status    int
+	}{
+		{
+			Name: "unknown event type",
+			Form: map[string]any{
+				"url":         "https://example.com/hook",
+				"event_types": []string{"link.renamed"},
+			},
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id"},
+			Status:    http.StatusBadRequest,
+		},
+		{
+			Name:      "no event types",
+			Form:      map[string]any{"url": "https://example.com/hook"},
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id"},
+			Status:    http.StatusBadRequest,
+		},
+		{
+			Name: "not an url",
+			Form: map[string]any{
+				"url":         "example",
+				"event_types": []string{"link.created"},
+			},
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id"},
+			Status:    http.StatusBadRequest,
+		},
+		{
+			Name: "loopback",
+			Form: map[string]any{
+				"url":         "http://127.0.0.1:9100/metrics",
+				"event_types": []string{"link.created"},
+			},
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id"},
+			Status:    http.StatusBadRequest,
+		},
+		{
+			Name: "metadata endpoint",
+			Form: map[string]any{
+				"url":         "http://169.254.169.254/latest/meta-data",
+				"event_types": []string{"link.created"},
+			},
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id"},
+			Status:    http.StatusBadRequest,
+		},
+		{
+			Name: "internal service",
+			Form: map[string]any{
+				"url":         "http://blackbox:8080/hook",
+				"event_types": []string{"link.created"},
+			},
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id"},
+			Status:    http.StatusBadRequest,
+		},
+		{
+			Name: "workspace editor",
+			Form: map[string]any{
+				"url":         "https://example.com/hook",
+				"event_types": []string{"link.created"},
+			},
+			TokenInfo: &blackbox.ValidateTokenRsp{
+				UserId:      "id",
+				WorkspaceId: "workspace",
+				Role:        string(domain.RoleEditor),
+			},
+			Status: http.StatusForbidden,
+		},
+	} {
+		t.Run(data.Name, func(t *testing.T) {
+			s := newTestShortener(
+				t,
+				NewMockUrls(t),
+				bus_mocks.NewMockPublisher(t),
+				data.TokenInfo,
+				WithEventsTopic("link-events"),
+				WithWebhooks(NewMockWebhooks(t)),
+			)
+			recorder := httptest.NewRecorder()
+			s.CreateWebhook(recorder, newAuthenticatedRequest(t, "POST", "/webhooks", data.Form))
+
+			assert.Equal(t, data.Status, recorder.Result().StatusCode)
+		})
+	}
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	wh := NewMockWebhooks(t)
+	wh.EXPECT().Subscription(mock.Anything, webhookId).Return(&domain.WebhookSubscription{
+		Id:     webhookId,
+		UserId: "id",
+	}, nil)
+	wh.EXPECT().Deliveries(mock.Anything, webhookId, 10).Return([]*domain.WebhookDelivery{{
+		Id:     "delivery",
+		Status: domain.DeliveryDead,
+		Secret: "secret",
+	}}, nil)
+
+	s := newTestShortener(
+		t,
+		NewMockUrls(t),
+		bus_mocks.NewMockPublisher(t),
+		&blackbox.ValidateTokenRsp{
+			UserId: "id",
+		},
+		WithEventsTopic("link-events"),
+		WithWebhooks(wh),
+	)
+	req := newAuthenticatedRequest(t, "GET", "/webhooks/"+webhookId+"/deliveries?limit=10", nil)
+	req.SetPathValue("id", webhookId)
+	recorder := httptest.NewRecorder()
+	s.WebhookDeliveries(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	assert.NotContains(t, recorder.Body.String(), "secret")
+	var dd []*domain.WebhookDelivery
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&dd))
+	assert.Equal(t, domain.DeliveryDead, dd[0].Status)
+}
+
+func TestWebhooksOfOthersNotFound(t *testing.T) {
+	for _, data := range []struct {
+		Name      string
+		Id        string
+		Sub       *domain.WebhookSubscription
+		TokenInfo *blackbox.ValidateTokenRsp
+	}{
+		{
+			Name:      "invalid id",
+			Id:        "abc",
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id"},
+		},
+		{
+			Name:      "webhook of another user",
+			Id:        webhookId,
+			Sub:       &domain.WebhookSubscription{Id: webhookId, UserId: "other"},
+			TokenInfo: &blackbox.ValidateTokenRsp{UserId: "id"},
+		},
+		{
+			Name: "webhook of another workspace",
+			Id:   webhookId,
+			Sub:  &domain.WebhookSubscription{Id: webhookId, UserId: "id", WorkspaceId: "other"},
+			TokenInfo: &blackbox.ValidateTokenRsp{
+				UserId:      "id",
+				WorkspaceId: "workspace",
+				Role:        string(domain.RoleOwner),
+			},
+		},
+	} {
+		t.Run(data.Name, func(t *testing.T) {
+			wh := NewMockWebhooks(t)
+			if data.Sub != nil {
+				wh.EXPECT().Subscription(mock.Anything, data.Id).Return(data.Sub, nil)
+			}
+
+			s := newTestShortener(
+				t,
+				NewMockUrls(t),
+				bus_mocks.NewMockPublisher(t),
+				data.TokenInfo,
+				WithEventsTopic("link-events"),
+				WithWebhooks(wh),
+			)
+			req := newAuthenticatedRequest(t, "DELETE", "/webhooks/"+data.Id, nil)
+			req.SetPathValue("id", data.Id)
+			recorder := httptest.NewRecorder()
+			s.DeleteWebhook(recorder, req)
+
+			assert.Equal(t, http.StatusNotFound, recorder.Result().StatusCode)
+		})
+	}
+}
@@ -23,8 +23,23 @@ func (_m *MockUsers) EXPECT() *MockUsers_Expecter {
 }
 
 // Insert provides a mock function with given fields: ctx, rr
-func (_m *MockUsers) Insert(ctx context.Context, rr []*responses.Authenticator) {
-	_m.Called(ctx, rr)
+func (_m *MockUsers) Insert(ctx context.Context, rr []*responses.Authenticator) []error {
+	ret := _m.Called(ctx, rr)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Insert")
+	}
+
+	var r0 []error
+	if rf, ok := ret.Get(0).(func(context.Context, []*responses.Authenticator) []error); ok {
+		r0 = rf(ctx, rr)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]error)
+		}
+	}
+
+	return r0
 }
 
 // MockUsers_Insert_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Insert'
@@ -46,12 +61,12 @@ func (_c *MockUsers_Insert_Call) Run(run func(ctx context.Context, rr []*respons
 	return _c
 }
 
-func (_c *MockUsers_Insert_Call) Return() *MockUsers_Insert_Call {
-	_c.Call.Return()
+func (_c *MockUsers_Insert_Call) Return(_a0 []error) *MockUsers_Insert_Call {
+	_c.Call.Return(_a0)
 	return _c
 }
 
-func (_c *MockUsers_Insert_Call) RunAndReturn(run func(context.Context, []*responses.Authenticator)) *MockUsers_Insert_Call {
+func (_c *MockUsers_Insert_Call) RunAndReturn(run func(context.Context, []*responses.Authenticator) []error) *MockUsers_Insert_Call {
 	_c.Call.Return(run)
 	return _c
 }
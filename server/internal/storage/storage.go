@@ -3,20 +3,120 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"shortener/pkg/bus"
+	"shortener/pkg/dlq"
+	"shortener/pkg/domain"
+	"shortener/pkg/events"
 	"shortener/pkg/responses"
+	"shortener/pkg/tracing"
 	"time"
 
-	"github.com/IBM/sarama"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("shortener/internal/storage")
+
+var (
+	batchSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_batch_size",
+		Help:    "Messages in written batches by topic.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 7),
+	}, []string{"topic"})
+	batchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_batch_duration_seconds",
+		Help:    "Time taken to write a batch, retries included.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"topic"})
+)
+
+// Urls and Users return an error for every row that couldn't be inserted, at
+// the same index
 type Urls interface {
-	Insert(ctx context.Context, rr []*responses.Shortener)
+	Insert(ctx context.Context, rr []*responses.Shortener) []error
 }
 
 type Users interface {
-	Insert(ctx context.Context, rr []*responses.Authenticator)
+	Insert(ctx context.Context, rr []*responses.Authenticator) []error
+}
+
+// Clicks counts clicks of link events, other events are skipped
+type Clicks interface {
+	CountClicks(ctx context.Context, ee []*domain.LinkEvent) []error
+}
+
+// RetryPolicy limits how transient insertion failures are retried
+type RetryPolicy struct {
+	// total number of insertion attempts of a row
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:  5,
+	BaseDelay: 100 * time.Millisecond,
+	MaxDelay:  5 * time.Second,
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d > p.MaxDelay || d <= 0 {
+		return p.MaxDelay
+	}
+	return d
+}
+
+// BatchPolicy limits batches of messages. A batch is written as soon as any
+// of the limits is hit. Messages aren't consumed while a batch is written,
+// so the limits also bound memory taken by a partition.
+type BatchPolicy struct {
+	// number of messages
+	MaxSize int
+	// total size of message values. A message that alone exceeds the limit
+	// is written in a batch of its own
+	MaxBytes int
+	// time since the first message of the batch has been received
+	MaxLatency time.Duration
+}
+
+var DefaultUrlsBatchPolicy = BatchPolicy{
+	MaxSize:    1000,
+	MaxBytes:   1 << 20,
+	MaxLatency: 300 * time.Millisecond,
+}
+
+var DefaultUsersBatchPolicy = BatchPolicy{
+	MaxSize:    500,
+	MaxBytes:   1 << 20,
+	MaxLatency: 500 * time.Millisecond,
+}
+
+// DefaultClicksBatchPolicy favors larger batches, counters don't need to be
+// up to date
+var DefaultClicksBatchPolicy = BatchPolicy{
+	MaxSize:    5000,
+	MaxBytes:   1 << 20,
+	MaxLatency: time.Second,
+}
+
+// DefaultShutdownTimeout limits writing of the current batch once the
+// handler has been stopped
+const DefaultShutdownTimeout = 10 * time.Second
+
+func (p BatchPolicy) validate() error {
+	if p.MaxSize < 1 || p.MaxBytes < 1 || p.MaxLatency <= 0 {
+		return fmt.Errorf("batch limits must be positive")
+	}
+	return nil
 }
 
 type GroupHandler struct {
@@ -24,9 +124,26 @@ type GroupHandler struct {
 
 	urlsTopic  string
 	usersTopic string
+	// empty if clicks aren't counted
+	eventsTopic string
+
+	users  Users
+	urls   Urls
+	clicks Clicks
 
-	users Users
-	urls  Urls
+	retry RetryPolicy
+
+	urlsBatch   BatchPolicy
+	usersBatch  BatchPolicy
+	clicksBatch BatchPolicy
+
+	shutdownTimeout time.Duration
+
+	deadLetters     bus.Publisher
+	deadLetterTopic string
+
+	results     bus.Publisher
+	resultTopic string
 
 	log *zerolog.Logger
 }
@@ -68,6 +185,86 @@ func WithUsersTopic(t string) groupHandlerOption {
 	}
 }
 
+// WithClicks counts clicks of the link events topic
+func WithClicks(c Clicks, eventsTopic string) groupHandlerOption {
+	return func(h *GroupHandler) error {
+		h.clicks = c
+		h.eventsTopic = eventsTopic
+		return nil
+	}
+}
+
+func WithRetryPolicy(p RetryPolicy) groupHandlerOption {
+	return func(h *GroupHandler) error {
+		if p.Attempts < 1 {
+			return fmt.Errorf("at least one attempt is required")
+		}
+		h.retry = p
+		return nil
+	}
+}
+
+func WithUrlsBatchPolicy(p BatchPolicy) groupHandlerOption {
+	return func(h *GroupHandler) error {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		h.urlsBatch = p
+		return nil
+	}
+}
+
+func WithUsersBatchPolicy(p BatchPolicy) groupHandlerOption {
+	return func(h *GroupHandler) error {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		h.usersBatch = p
+		return nil
+	}
+}
+
+func WithClicksBatchPolicy(p BatchPolicy) groupHandlerOption {
+	return func(h *GroupHandler) error {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		h.clicksBatch = p
+		return nil
+	}
+}
+
+// WithShutdownTimeout limits writing of the current batch of a claim once
+// the handler context is cancelled
+func WithShutdownTimeout(d time.Duration) groupHandlerOption {
+	return func(h *GroupHandler) error {
+		if d <= 0 {
+			return fmt.Errorf("shutdown timeout must be positive")
+		}
+		h.shutdownTimeout = d
+		return nil
+	}
+}
+
+// WithDeadLetterQueue sets the topic messages that couldn't be stored are
+// sent to
+func WithDeadLetterQueue(p bus.Publisher, topic string) groupHandlerOption {
+	return func(h *GroupHandler) error {
+		h.deadLetters = p
+		h.deadLetterTopic = topic
+		return nil
+	}
+}
+
+// WithResultTopic sets the topic conflicts are reported to
+func WithResultTopic(p bus.Publisher, topic string) groupHandlerOption {
+	return func(h *GroupHandler) error {
+		h.results = p
+		h.resultTopic = topic
+		return nil
+	}
+}
+
 func WithLogger(l *zerolog.Logger) groupHandlerOption {
 	return func(h *GroupHandler) error {
 		h.log = l
@@ -76,7 +273,13 @@ func WithLogger(l *zerolog.Logger) groupHandlerOption {
 }
 
 func New(opts ...groupHandlerOption) (*GroupHandler, error) {
-	g := new(GroupHandler)
+	g := &GroupHandler{
+		retry:           DefaultRetryPolicy,
+		urlsBatch:       DefaultUrlsBatchPolicy,
+		usersBatch:      DefaultUsersBatchPolicy,
+		clicksBatch:     DefaultClicksBatchPolicy,
+		shutdownTimeout: DefaultShutdownTimeout,
+	}
 	for _, opt := range opts {
 		if err := opt(g); err != nil {
 			return nil, err
@@ -97,137 +300,363 @@ func New(opts ...groupHandlerOption) (*GroupHandler, error) {
 	if g.users == nil {
 		return nil, fmt.Errorf("no users model provided")
 	}
+	if (g.clicks == nil) != (g.eventsTopic == "") {
+		return nil, fmt.Errorf("clicks model and events topic must be provided together")
+	}
 	if g.log == nil {
 		return nil, fmt.Errorf("no logger provided")
 	}
+	if g.deadLetters == nil {
+		return nil, fmt.Errorf("no dead-letter publisher provided")
+	}
+	if g.deadLetterTopic == "" {
+		return nil, fmt.Errorf("no dead-letter topic provided")
+	}
+	if g.results == nil {
+		return nil, fmt.Errorf("no result publisher provided")
+	}
+	if g.resultTopic == "" {
+		return nil, fmt.Errorf("no result topic provided")
+	}
 
 	return g, nil
 }
 
-func (h *GroupHandler) Setup(sess sarama.ConsumerGroupSession) error {
-	h.log.Info().
-		Str("member_id", sess.MemberID()).
-		Str("claims", fmt.Sprintf("%v", sess.Claims())).
-		Msg("began consumption")
-	return nil
-}
-
-func (h *GroupHandler) Cleanup(sess sarama.ConsumerGroupSession) error {
-	h.log.Info().
-		Str("member_id", sess.MemberID()).
-		Str("claims", fmt.Sprintf("%v", sess.Claims())).
-		Msg("began cleanup")
-	sess.Commit()
-	return nil
+// Topics returns the topics the handler consumes
+func (h *GroupHandler) Topics() []string {
+	if h.eventsTopic != "" {
+		return []string{h.urlsTopic, h.usersTopic, h.eventsTopic}
+	}
+	return []string{h.urlsTopic, h.usersTopic}
 }
 
-func (h *GroupHandler) ConsumeClaim(
-	sess sarama.ConsumerGroupSession,
-	claim sarama.ConsumerGroupClaim,
-) error {
+func (h *GroupHandler) Consume(claim bus.Claim) error {
 	if claim.Topic() == h.usersTopic {
-		return h.handleUsers(sess, claim)
+		return h.handleUsers(claim)
 	} else if claim.Topic() == h.urlsTopic {
-		return h.handleUrls(sess, claim)
+		return h.handleUrls(claim)
+	} else if h.eventsTopic != "" && claim.Topic() == h.eventsTopic {
+		return h.handleClicks(claim)
 	} else {
 		return fmt.Errorf("unknown topic: %s", claim.Topic())
 	}
 }
 
-func (h *GroupHandler) handleUrls(
-	sess sarama.ConsumerGroupSession,
-	claim sarama.ConsumerGroupClaim,
+func (h *GroupHandler) handleUrls(claim bus.Claim) error {
+	h.log.Info().Msg("waiting for url messages")
+	return consume(h, claim, h.urlsBatch, events.DecodeLink, h.urls.Insert)
+}
+
+func (h *GroupHandler) handleUsers(claim bus.Claim) error {
+	h.log.Info().Msg("waiting for new users messages")
+	return consume(h, claim, h.usersBatch, events.DecodeUser, h.users.Insert)
+}
+
+func (h *GroupHandler) handleClicks(claim bus.Claim) error {
+	h.log.Info().Msg("waiting for link events messages")
+	return consume(h, claim, h.clicksBatch, events.DecodeLinkEvent, h.clicks.CountClicks)
+}
+
+// consume collects messages of the claim into batches limited by the policy
+// and writes them with insert. When the handler is stopped or the claim is
+// revoked, the current batch is written and marked before returning, so
+// that its offsets are committed with the claim.
+func consume[T any, PT interface {
+	*T
+	idempotent
+}](
+	h *GroupHandler,
+	claim bus.Claim,
+	policy BatchPolicy,
+	decode func(mes *bus.Message) (PT, error),
+	insert func(ctx context.Context, rr []PT) []error,
 ) error {
-	ticker := time.NewTicker(time.Millisecond * 300)
-	defer ticker.Stop()
+	log := h.log.With().
+		Str("topic", claim.Topic()).
+		Int32("partition", claim.Partition()).
+		Logger()
+
+	messageBatch := make([]*bus.Message, 0, policy.MaxSize)
+	batchBytes := 0
+	// nil while the batch is empty
+	var deadline <-chan time.Time
+
+	flush := func(ctx context.Context, reason string) error {
+		log.Info().
+			Int("batch_size", len(messageBatch)).
+			Int("batch_bytes", batchBytes).
+			Str("reason", reason).
+			Msg("processing messages batch")
+		start := time.Now()
+		ctx, span := startBatchSpan(ctx, claim, messageBatch)
+		err := processBatch(ctx, h, messageBatch, decode, insert)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		if err != nil {
+			return err
+		}
+		batchSize.WithLabelValues(claim.Topic()).Observe(float64(len(messageBatch)))
+		batchDuration.WithLabelValues(claim.Topic()).Observe(time.Since(start).Seconds())
+		for _, mes := range messageBatch {
+			claim.Ack(mes)
+		}
+		log.Info().Msg("marked message batch as processed")
+
+		clear(messageBatch)
+		messageBatch = messageBatch[:0]
+		batchBytes = 0
+		deadline = nil
+		return nil
+	}
+
+	// the last batch is written even though the handler has been stopped,
+	// but not for longer than the shutdown timeout
+	flushLast := func(reason string) error {
+		if len(messageBatch) == 0 {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(
+			context.WithoutCancel(h.ctx),
+			h.shutdownTimeout,
+		)
+		defer cancel()
+		return flush(ctx, reason)
+	}
 
-	h.log.Info().Msg("waiting for url messages")
-	var messageBatch []*sarama.ConsumerMessage
 	for {
 		select {
 		case <-h.ctx.Done():
-			h.log.Info().Msg("got cancellation signal in urls handler")
-			return nil
+			log.Info().Msg("got cancellation signal")
+			return flushLast("shutdown")
 		case mes, isOpen := <-claim.Messages():
 			if !isOpen {
-				h.log.Info().Msg("url messages channel had been closed")
-				return nil
+				log.Info().Msg("messages channel had been closed")
+				return flushLast("revocation")
 			}
-			h.log.Info().Msg("got url message")
-			messageBatch = append(messageBatch, mes)
-		case <-ticker.C:
+			log.Debug().Int64("offset", mes.Offset).Msg("got message")
+
 			if len(messageBatch) == 0 {
-				continue
+				deadline = time.After(policy.MaxLatency)
 			}
-			h.log.Info().
-				Int("batch_size", len(messageBatch)).
-				Msg("processing url messages batch")
-			var rr []*responses.Shortener
-			for _, mes := range messageBatch {
-				var rsp responses.Shortener
-				if err := json.Unmarshal(mes.Value, &rsp); err != nil {
-					h.log.Error().Err(err).Msg("couldn't unmarshal url message")
-					continue
-				}
-				rr = append(rr, &rsp)
+			messageBatch = append(messageBatch, mes)
+			batchBytes += len(mes.Value)
+
+			var err error
+			if len(messageBatch) >= policy.MaxSize {
+				err = flush(h.ctx, "size")
+			} else if batchBytes >= policy.MaxBytes {
+				err = flush(h.ctx, "bytes")
+			}
+			if err != nil {
+				return err
 			}
-			h.log.Info().Msg("began inserting message batch into database")
-			h.urls.Insert(context.TODO(), rr)
-			for _, mes := range messageBatch {
-				sess.MarkMessage(mes, "")
+		case <-deadline:
+			if err := flush(h.ctx, "latency"); err != nil {
+				return err
 			}
-			h.log.Info().Msg("marked message batch as processed")
-			messageBatch = messageBatch[:0]
 		}
 	}
 }
 
-func (h *GroupHandler) handleUsers(
-	sess sarama.ConsumerGroupSession,
-	claim sarama.ConsumerGroupClaim,
-) error {
-	ticker := time.NewTicker(time.Millisecond * 500)
-	defer ticker.Stop()
+// startBatchSpan starts a consumer span of the batch linked to the traces
+// of its messages, which usually belong to different requests
+func startBatchSpan(
+	ctx context.Context,
+	claim bus.Claim,
+	messages []*bus.Message,
+) (context.Context, trace.Span) {
+	links := make([]trace.Link, 0, len(messages))
+	for _, mes := range messages {
+		sc := trace.SpanContextFromContext(tracing.Extract(context.Background(), mes))
+		if sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	return tracer.Start(
+		ctx,
+		claim.Topic()+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", claim.Topic()),
+			attribute.Int("messaging.destination.partition.id", int(claim.Partition())),
+			attribute.Int("messaging.batch.message_count", len(messages)),
+		),
+	)
+}
 
-	h.log.Info().Msg("waiting for new users messages")
-	var messageBatch []*sarama.ConsumerMessage
-	for {
-		select {
-		case <-h.ctx.Done():
-			h.log.Info().Msg("got cancellation signal in new users handler")
-			return nil
-		case mes, isOpen := <-claim.Messages():
-			if !isOpen {
-				h.log.Info().Msg("users messages channel had been closed")
-				return nil
+// errStopped is returned when processing is interrupted by cancellation of
+// the handler context. Messages must not be marked in that case.
+var errStopped = errors.New("processing has been stopped")
+
+type idempotent interface {
+	GetIdempotencyKey() string
+}
+
+// processBatch returns only when every message of the batch has been either
+// stored, reported as a conflict or sent to the dead-letter topic, so that
+// the batch can be marked. Messages that can't be decoded are dead-lettered
+// right away, transient insertion failures are retried according to the
+// retry policy.
+func processBatch[T any, PT interface {
+	*T
+	idempotent
+}](
+	ctx context.Context,
+	h *GroupHandler,
+	messages []*bus.Message,
+	decode func(mes *bus.Message) (PT, error),
+	insert func(ctx context.Context, rr []PT) []error,
+) error {
+	pending := make([]*bus.Message, 0, len(messages))
+	rows := make([]PT, 0, len(messages))
+	for _, mes := range messages {
+		row, err := decode(mes)
+		if err != nil {
+			h.log.Error().
+				Err(err).
+				Str("topic", mes.Topic).
+				Int64("offset", mes.Offset).
+				Msg("couldn't decode message")
+			if err := h.deadLetter(ctx, mes, err, 0); err != nil {
+				return err
 			}
-			h.log.Info().Msg("got new users message")
-			messageBatch = append(messageBatch, mes)
-		case <-ticker.C:
-			if len(messageBatch) == 0 {
+			continue
+		}
+		pending = append(pending, mes)
+		rows = append(rows, row)
+	}
+
+	h.log.Info().Msg("began inserting message batch into database")
+	for attempt := 1; len(rows) > 0; attempt++ {
+		// an insertion isn't interrupted on cancellation, so that a stored
+		// batch can be marked
+		errs := insert(context.WithoutCancel(ctx), rows)
+
+		var retryMessages []*bus.Message
+		var retryRows []PT
+		for i, err := range errs {
+			if err == nil {
 				continue
 			}
-			h.log.Info().
-				Int("batch_size", len(messageBatch)).
-				Msg("processing new users messages batch")
-			var rr []*responses.Authenticator
-			for _, mes := range messageBatch {
-				var rsp responses.Authenticator
-				if err := json.Unmarshal(mes.Value, &rsp); err != nil {
-					h.log.Error().
-						Err(err).
-						Msg("couldn't unmarshal new user message")
-					continue
+
+			log := h.log.With().
+				Err(err).
+				Str("topic", pending[i].Topic).
+				Int64("offset", pending[i].Offset).
+				Int("attempt", attempt).
+				Logger()
+			if errors.Is(err, domain.ErrConflict) {
+				log.Warn().Msg("row conflicts with stored data")
+				err := h.reportConflict(ctx, pending[i], rows[i].GetIdempotencyKey(), err)
+				if err != nil {
+					return err
 				}
-				rr = append(rr, &rsp)
+				continue
 			}
-			h.log.Info().Msg("began inserting message batch into database")
-			h.users.Insert(context.TODO(), rr)
-			for _, mes := range messageBatch {
-				sess.MarkMessage(mes, "")
+			if isTransient(err) && attempt < h.retry.Attempts {
+				log.Warn().Msg("transient insertion failure. will retry")
+				retryMessages = append(retryMessages, pending[i])
+				retryRows = append(retryRows, rows[i])
+				continue
+			}
+
+			log.Error().Msg("couldn't insert row")
+			if err := h.deadLetter(ctx, pending[i], err, attempt); err != nil {
+				return err
 			}
-			h.log.Info().Msg("marked message batch as processed")
-			messageBatch = messageBatch[:0]
 		}
+
+		pending, rows = retryMessages, retryRows
+		if len(rows) == 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return errStopped
+		case <-time.After(h.retry.delay(attempt)):
+		}
+	}
+	return nil
+}
+
+func (h *GroupHandler) deadLetter(
+	ctx context.Context,
+	mes *bus.Message,
+	cause error,
+	attempts int,
+) error {
+	err := h.deadLetters.Publish(
+		ctx,
+		dlq.Message(h.deadLetterTopic, mes, cause, attempts),
+	)
+	if err != nil {
+		// the message can't be marked: otherwise it would be lost
+		return fmt.Errorf(
+			"couldn't send message %s/%d/%d to dead-letter topic: %w",
+			mes.Topic,
+			mes.Partition,
+			mes.Offset,
+			err,
+		)
+	}
+
+	h.log.Warn().
+		Str("topic", mes.Topic).
+		Int64("offset", mes.Offset).
+		Msg("sent message to dead-letter topic")
+	return nil
+}
+
+func (h *GroupHandler) reportConflict(
+	ctx context.Context,
+	mes *bus.Message,
+	idempotencyKey string,
+	cause error,
+) error {
+	res, _ := json.Marshal(&responses.StorageResult{
+		IdempotencyKey: idempotencyKey,
+		Topic:          mes.Topic,
+		Status:         responses.StorageStatusConflict,
+		Error:          cause.Error(),
+	})
+	err := h.results.Publish(ctx, &bus.Message{
+		Topic: h.resultTopic,
+		Key:   []byte(idempotencyKey),
+		Value: res,
+	})
+	if err != nil {
+		return fmt.Errorf(
+			"couldn't report conflict of message %s/%d/%d: %w",
+			mes.Topic,
+			mes.Partition,
+			mes.Offset,
+			err,
+		)
+	}
+	return nil
+}
+
+// isTransient tells whether the insertion may succeed if retried. Errors
+// reported by postgres are transient only for connection problems, lack of
+// resources, serialization failures and deadlocks. Any other error, e.g. a
+// network one, is considered transient.
+func isTransient(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return true
+	}
+
+	switch pgErr.Code[:2] {
+	case "08", // connection exception
+		"40", // transaction rollback
+		"53", // insufficient resources
+		"57": // operator intervention, e.g. shutdown
+		return true
 	}
+	return false
 }
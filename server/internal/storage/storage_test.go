@@ -3,15 +3,30 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	bus_mocks "shortener/mocks/shortener/pkg/bus"
+	"shortener/pkg/bus"
+	"shortener/pkg/bus/kafka"
+	"shortener/pkg/bus/memory"
+	"shortener/pkg/dlq"
+	"shortener/pkg/domain"
+	"shortener/pkg/events"
 	responses "shortener/pkg/responses"
+	eventsv1 "shortener/proto/events/v1"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var urlData, _ = json.Marshal(&responses.Shortener{
@@ -84,7 +99,7 @@ func TestSotrageSuccess(t *testing.T) {
 
 	urlsModel := NewMockUrls(t)
 	urlsModel.EXPECT().
-		Insert(context.TODO(), mock.MatchedBy(func(rr []*responses.Shortener) bool {
+		Insert(mock.Anything, mock.MatchedBy(func(rr []*responses.Shortener) bool {
 			if len(rr) != 1 {
 				return false
 			}
@@ -96,11 +111,11 @@ func TestSotrageSuccess(t *testing.T) {
 			return false
 		})).
 		Once().
-		Return()
+		Return([]error{nil})
 
 	usersModel := NewMockUsers(t)
 	usersModel.EXPECT().
-		Insert(context.TODO(), mock.MatchedBy(func(rr []*responses.Authenticator) bool {
+		Insert(mock.Anything, mock.MatchedBy(func(rr []*responses.Authenticator) bool {
 			if len(rr) != 1 {
 				return false
 			}
@@ -112,7 +127,7 @@ func TestSotrageSuccess(t *testing.T) {
 			return false
 		})).
 		Once().
-		Return()
+		Return([]error{nil})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -124,6 +139,8 @@ func TestSotrageSuccess(t *testing.T) {
 		WithUsersModel(usersModel),
 		WithUrlsTopic("urls"),
 		WithUsersTopic("users"),
+		WithDeadLetterQueue(bus_mocks.NewMockPublisher(t), "dlq"),
+		WithResultTopic(bus_mocks.NewMockPublisher(t), "results"),
 	)
 	assert.Nil(t, err)
 
@@ -139,12 +156,482 @@ func TestSotrageSuccess(t *testing.T) {
 	assert.Nil(t, err)
 	defer g.Close()
 
+	err = kafka.NewSubscriber(g, &log.Logger).Subscribe(ctx, h.Topics(), h)
+	assert.Nil(t, err)
+}
+
+func TestStorageMemoryBus(t *testing.T) {
+	b, err := memory.New(memory.WithPartitions(2))
+	assert.Nil(t, err)
+
+	link, contentType, err := events.Encode(&eventsv1.LinkCreated{
+		ShortUrl: "short",
+		LongUrl:  "long",
+		UserId:   "from",
+	})
+	assert.Nil(t, err)
+	var messages []*bus.Message
+	for i := 0; i < 3; i++ {
+		messages = append(messages, &bus.Message{
+			Topic:   "urls",
+			Key:     []byte{byte(i)},
+			Value:   link,
+			Headers: map[string]string{events.HeaderContentType: contentType},
+		})
+	}
+	// legacy json
+	messages = append(messages, &bus.Message{Topic: "users", Value: userData})
+	assert.Nil(t, b.Publish(context.Background(), messages...))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	inserted := 0
+	done := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		inserted++
+		if inserted == len(messages) {
+			cancel()
+		}
+	}
+
+	urlsModel := NewMockUrls(t)
+	urlsModel.EXPECT().
+		Insert(mock.Anything, mock.Anything).
+		RunAndReturn(func(_ context.Context, rr []*responses.Shortener) []error {
+			for _, r := range rr {
+				assert.Equal(t, "short", r.ShortUrl)
+				done()
+			}
+			return make([]error, len(rr))
+		})
+	usersModel := NewMockUsers(t)
+	usersModel.EXPECT().
+		Insert(mock.Anything, mock.Anything).
+		RunAndReturn(func(_ context.Context, rr []*responses.Authenticator) []error {
+			for _, r := range rr {
+				assert.Equal(t, "mail", r.Email)
+				done()
+			}
+			return make([]error, len(rr))
+		})
+
+	h, err := New(
+		WithLogger(&log.Logger),
+		WithContext(ctx),
+		WithUrlsModel(urlsModel),
+		WithUsersModel(usersModel),
+		WithUrlsTopic("urls"),
+		WithUsersTopic("users"),
+		WithDeadLetterQueue(b, "dlq"),
+		WithResultTopic(b, "results"),
+		WithUrlsBatchPolicy(BatchPolicy{
+			MaxSize:    100,
+			MaxBytes:   1 << 20,
+			MaxLatency: 10 * time.Millisecond,
+		}),
+	)
+	assert.Nil(t, err)
+
+	assert.Nil(t, b.Subscribe(ctx, h.Topics(), h))
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	assert.Equal(t, len(messages), inserted)
+}
+
+func TestStorageCountsClicks(t *testing.T) {
+	b, err := memory.New()
+	assert.Nil(t, err)
+
+	var messages []*bus.Message
+	for _, e := range []proto.Message{
+		&eventsv1.LinkClicked{IdempotencyKey: "1", ShortUrl: "short", ClickedAt: timestamppb.Now()},
+		&eventsv1.LinkExpired{IdempotencyKey: "2", ShortUrl: "old", ArchivedAt: timestamppb.Now()},
+		&eventsv1.LinkClicked{IdempotencyKey: "3", ShortUrl: "short", ClickedAt: timestamppb.Now()},
+	} {
+		value, contentType, err := events.Encode(e)
+		assert.Nil(t, err)
+		messages = append(messages, &bus.Message{
+			Topic:   "link-events",
+			Value:   value,
+			Headers: map[string]string{events.HeaderContentType: contentType},
+		})
+	}
+	assert.Nil(t, b.Publish(context.Background(), messages...))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var counted []string
+	clicks := NewMockClicks(t)
+	clicks.EXPECT().
+		CountClicks(mock.Anything, mock.Anything).
+		RunAndReturn(func(_ context.Context, ee []*domain.LinkEvent) []error {
+			for _, e := range ee {
+				counted = append(counted, e.Id)
+			}
+			if len(counted) == len(messages) {
+				cancel()
+			}
+			return make([]error, len(ee))
+		})
+
+	h, err := New(
+		WithLogger(&log.Logger),
+		WithContext(ctx),
+		WithUrlsModel(NewMockUrls(t)),
+		WithUsersModel(NewMockUsers(t)),
+		WithUrlsTopic("urls"),
+		WithUsersTopic("users"),
+		WithClicks(clicks, "link-events"),
+		WithClicksBatchPolicy(BatchPolicy{
+			MaxSize:    100,
+			MaxBytes:   1 << 20,
+			MaxLatency: 10 * time.Millisecond,
+		}),
+		WithDeadLetterQueue(b, "dlq"),
+		WithResultTopic(b, "results"),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"urls", "users", "link-events"}, h.Topics())
+
+	assert.Nil(t, b.Subscribe(ctx, h.Topics(), h))
+	assert.Equal(t, []string{"1", "2", "3"}, counted)
+}
+
+func newBatchHandler(t *testing.T, p bus.Publisher) *GroupHandler {
+	h, err := New(
+		WithLogger(&log.Logger),
+		WithContext(context.Background()),
+		WithUrlsModel(NewMockUrls(t)),
+		WithUsersModel(NewMockUsers(t)),
+		WithUrlsTopic("urls"),
+		WithUsersTopic("users"),
+		WithDeadLetterQueue(p, "dlq"),
+		WithResultTopic(p, "results"),
+		WithRetryPolicy(RetryPolicy{
+			Attempts:  3,
+			BaseDelay: time.Millisecond,
+			MaxDelay:  time.Millisecond,
+		}),
+	)
+	assert.Nil(t, err)
+	return h
+}
+
+// dlqEntry matches the dead-letter message of the urls message
+func dlqEntry(offset int64, attempts int) any {
+	return mock.MatchedBy(func(m *bus.Message) bool {
+		e := dlq.Parse(m)
+		return m.Topic == "dlq" &&
+			e.OriginalTopic == "urls" &&
+			e.OriginalOffset == offset &&
+			e.Attempts == attempts &&
+			e.Error != ""
+	})
+}
+
+func TestProcessBatchPoisonMessage(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().Publish(mock.Anything, dlqEntry(0, 0)).Return(nil).Once()
+	h := newBatchHandler(t, p)
+
+	messages := []*bus.Message{
+		{Topic: "urls", Offset: 0, Value: []byte("not json")},
+		{Topic: "urls", Offset: 1, Value: urlData},
+	}
+	var inserted []*responses.Shortener
+	err := processBatch(h.ctx, h, messages, events.DecodeLink, func(
+		_ context.Context,
+		rr []*responses.Shortener,
+	) []error {
+		inserted = append(inserted, rr...)
+		return make([]error, len(rr))
+	})
+	assert.Nil(t, err)
+	assert.Len(t, inserted, 1)
+}
+
+func TestProcessBatchRetriesTransientErrors(t *testing.T) {
+	h := newBatchHandler(t, bus_mocks.NewMockPublisher(t))
+
+	messages := []*bus.Message{
+		{Topic: "urls", Offset: 0, Value: urlData},
+		{Topic: "urls", Offset: 1, Value: urlData},
+	}
+	var calls [][]*responses.Shortener
+	err := processBatch(h.ctx, h, messages, events.DecodeLink, func(
+		_ context.Context,
+		rr []*responses.Shortener,
+	) []error {
+		calls = append(calls, rr)
+		if len(calls) == 1 {
+			return []error{nil, &pgconn.PgError{Code: "40P01"}}
+		}
+		return make([]error, len(rr))
+	})
+	assert.Nil(t, err)
+	if assert.Len(t, calls, 2) {
+		assert.Len(t, calls[0], 2)
+		// only the failed row is retried
+		assert.Len(t, calls[1], 1)
+	}
+}
+
+func TestProcessBatchDeadLettersFailedRows(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+	// unique violation isn't retried
+	p.EXPECT().Publish(mock.Anything, dlqEntry(0, 1)).Return(nil).Once()
+	// retries are exhausted
+	p.EXPECT().Publish(mock.Anything, dlqEntry(1, 3)).Return(nil).Once()
+	h := newBatchHandler(t, p)
+
+	messages := []*bus.Message{
+		{Topic: "urls", Offset: 0, Value: urlData},
+		{Topic: "urls", Offset: 1, Value: urlData},
+	}
+	calls := 0
+	err := processBatch(h.ctx, h, messages, events.DecodeLink, func(
+		_ context.Context,
+		rr []*responses.Shortener,
+	) []error {
+		calls++
+		if calls == 1 {
+			return []error{
+				&pgconn.PgError{Code: "23505"},
+				errors.New("connection reset"),
+			}
+		}
+		return []error{errors.New("connection reset")}
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestProcessBatchDeadLetterFailure(t *testing.T) {
+	errUnavailable := errors.New("broker is unavailable")
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().Publish(mock.Anything, mock.Anything).Return(errUnavailable).Once()
+	h := newBatchHandler(t, p)
+
+	messages := []*bus.Message{
+		{Topic: "urls", Offset: 0, Value: []byte("not json")},
+	}
+	err := processBatch(h.ctx, h, messages, events.DecodeLink, func(
+		_ context.Context,
+		rr []*responses.Shortener,
+	) []error {
+		return make([]error, len(rr))
+	})
+	// the batch mustn't be marked
+	assert.ErrorIs(t, err, errUnavailable)
+}
+
+func TestProcessBatchReportsConflicts(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().
+		Publish(mock.Anything, mock.MatchedBy(func(m *bus.Message) bool {
+			var res responses.StorageResult
+			if err := json.Unmarshal(m.Value, &res); err != nil {
+				return false
+			}
+			return m.Topic == "results" &&
+				string(m.Key) == "key" &&
+				res.IdempotencyKey == "key" &&
+				res.Topic == "urls" &&
+				res.Status == responses.StorageStatusConflict
+		})).
+		Return(nil).
+		Once()
+	h := newBatchHandler(t, p)
+
+	conflicting, _ := json.Marshal(&responses.Shortener{
+		ShortUrl:       "short",
+		IdempotencyKey: "key",
+	})
+	messages := []*bus.Message{
+		{Topic: "urls", Offset: 0, Value: conflicting},
+	}
+	calls := 0
+	err := processBatch(h.ctx, h, messages, events.DecodeLink, func(
+		_ context.Context,
+		rr []*responses.Shortener,
+	) []error {
+		calls++
+		return []error{fmt.Errorf("%w: taken", domain.ErrConflict)}
+	})
+	assert.Nil(t, err)
+	// conflicts aren't retried
+	assert.Equal(t, 1, calls)
+}
+
+type fakeClaim struct {
+	messages chan *bus.Message
+	acked    chan int64
+}
+
+func (c *fakeClaim) Topic() string {
+	return "urls"
+}
+
+func (c *fakeClaim) Partition() int32 {
+	return 0
+}
+
+func (c *fakeClaim) Messages() <-chan *bus.Message {
+	return c.messages
+}
+
+func (c *fakeClaim) Ack(m *bus.Message) {
+	c.acked <- m.Offset
+}
+
+// runConsume consumes the messages with the policy and returns sizes of the
+// written batches
+func runConsume(
+	t *testing.T,
+	policy BatchPolicy,
+	messages []*bus.Message,
+) []int {
+	h := newBatchHandler(t, bus_mocks.NewMockPublisher(t))
+	claim := &fakeClaim{
+		messages: make(chan *bus.Message),
+		acked:    make(chan int64, len(messages)),
+	}
+
+	var batches []int
+	done := make(chan error)
 	go func() {
-		<-ctx.Done()
-		g.Close()
+		done <- consume(h, claim, policy, events.DecodeLink, func(
+			_ context.Context,
+			rr []*responses.Shortener,
+		) []error {
+			batches = append(batches, len(rr))
+			return make([]error, len(rr))
+		})
 	}()
 
-	err = g.Consume(context.Background(), []string{"urls", "users"}, h)
-	log.Printf("%v", err)
-	assert.Nil(t, err)
+	for _, mes := range messages {
+		claim.messages <- mes
+	}
+	for range messages {
+		select {
+		case <-claim.acked:
+		case <-time.After(time.Second):
+			t.Fatal("messages haven't been acknowledged")
+		}
+	}
+	close(claim.messages)
+	assert.Nil(t, <-done)
+	return batches
+}
+
+func urlMessages(n int) []*bus.Message {
+	res := make([]*bus.Message, n)
+	for i := range res {
+		res[i] = &bus.Message{
+			Topic:  "urls",
+			Offset: int64(i),
+			Value:  urlData,
+		}
+	}
+	return res
+}
+func TestConsumeFlushesOnSize(t *testing.T) {
+	batches := runConsume(t, BatchPolicy{
+		MaxSize:    2,
+		MaxBytes:   1 << 20,
+		MaxLatency: time.Hour,
+	}, urlMessages(4))
+	assert.Equal(t, []int{2, 2}, batches)
+}
+
+func TestConsumeFlushesOnBytes(t *testing.T) {
+	batches := runConsume(t, BatchPolicy{
+		MaxSize:    100,
+		MaxBytes:   2*len(urlData) - 1,
+		MaxLatency: time.Hour,
+	}, urlMessages(4))
+	assert.Equal(t, []int{2, 2}, batches)
+
+	// a message exceeding the limit is written alone
+	batches = runConsume(t, BatchPolicy{
+		MaxSize:    100,
+		MaxBytes:   1,
+		MaxLatency: time.Hour,
+	}, urlMessages(2))
+	assert.Equal(t, []int{1, 1}, batches)
+}
+
+func TestConsumeFlushesOnLatency(t *testing.T) {
+	batches := runConsume(t, BatchPolicy{
+		MaxSize:    100,
+		MaxBytes:   1 << 20,
+		MaxLatency: 10 * time.Millisecond,
+	}, urlMessages(1))
+	assert.Equal(t, []int{1}, batches)
+}
+
+// consumeUntil consumes the messages with a batch policy that doesn't flush
+// on its own, then calls stop and returns sizes of the written batches
+func consumeUntil(
+	t *testing.T,
+	messages []*bus.Message,
+	stop func(claim *fakeClaim, cancel context.CancelFunc),
+) []int {
+	h := newBatchHandler(t, bus_mocks.NewMockPublisher(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.ctx = ctx
+
+	claim := &fakeClaim{
+		messages: make(chan *bus.Message),
+		acked:    make(chan int64, len(messages)),
+	}
+
+	var batches []int
+	done := make(chan error)
+	go func() {
+		done <- consume(h, claim, BatchPolicy{
+			MaxSize:    100,
+			MaxBytes:   1 << 20,
+			MaxLatency: time.Hour,
+		}, events.DecodeLink, func(
+			ctx context.Context,
+			rr []*responses.Shortener,
+		) []error {
+			// the insertion isn't interrupted by the stopped handler
+			assert.Nil(t, ctx.Err())
+			batches = append(batches, len(rr))
+			return make([]error, len(rr))
+		})
+	}()
+
+	for _, mes := range messages {
+		claim.messages <- mes
+	}
+	stop(claim, cancel)
+	assert.Nil(t, <-done)
+	assert.Len(t, claim.acked, len(messages))
+	return batches
+}
+
+func TestConsumeFlushesOnShutdown(t *testing.T) {
+	batches := consumeUntil(t, urlMessages(3), func(_ *fakeClaim, cancel context.CancelFunc) {
+		cancel()
+	})
+	assert.Equal(t, []int{3}, batches)
+}
+
+func TestConsumeFlushesOnRevocation(t *testing.T) {
+	batches := consumeUntil(t, urlMessages(2), func(claim *fakeClaim, _ context.CancelFunc) {
+		close(claim.messages)
+	})
+	assert.Equal(t, []int{2}, batches)
+}
+
+func TestInvalidBatchPolicy(t *testing.T) {
+	_, err := New(WithUrlsBatchPolicy(BatchPolicy{MaxSize: 1, MaxBytes: 1}))
+	assert.NotNil(t, err)
 }
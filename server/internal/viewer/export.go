@@ -0,0 +1,316 @@
+package viewer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"shortener/pkg/domain"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	// links read from the history at a time, only one page is kept in
+	// memory while exporting
+	exportPageSize = 1000
+	// parquet files are written in row groups of this many links
+	exportRowGroupSize = 16 * exportPageSize
+)
+
+// exportRow is an exported link. Click aggregates are nil unless they are
+// exported.
+type exportRow struct {
+	ShortUrl       string    `json:"short_url"`
+	LongUrl        string    `json:"long_url"`
+	Title          string    `json:"title"`
+	Tags           []string  `json:"tags"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpirationDate time.Time `json:"expiration_date"`
+	Disabled       bool      `json:"disabled"`
+	*exportClicks
+}
+
+type exportClicks struct {
+	Clicks    int64 `json:"clicks"`
+	Clicks7d  int64 `json:"clicks_7d"`
+	Clicks30d int64 `json:"clicks_30d"`
+	// UTC date, null if the link hasn't been clicked since clicks are
+	// counted by day
+	LastClickedOn *string `json:"last_clicked_on"`
+}
+
+const dateLayout = time.DateOnly
+
+// exportLinks writes every link of the query in pages, and returns the
+// number of links written. The limit and the cursor of the query are
+// overwritten. Short urls are prefixed with the host.
+func exportLinks(
+	ctx context.Context,
+	urls Urls,
+	q *domain.HistoryQuery,
+	clicks bool,
+	host string,
+	lw linksWriter,
+) (int64, error) {
+	var written int64
+	q.Limit = exportPageSize
+	q.After = nil
+	for {
+		links, err := urls.History(ctx, q)
+		if err != nil {
+			return written, err
+		}
+
+		var stats map[string]*domain.ClickStats
+		if clicks && len(links) > 0 {
+			shortUrls := make([]string, len(links))
+			for i, l := range links {
+				shortUrls[i] = l.ShortUrl
+			}
+			stats, err = urls.ClickStats(ctx, shortUrls)
+			if err != nil {
+				return written, err
+			}
+		}
+
+		rows := make([]*exportRow, len(links))
+		for i, l := range links {
+			rows[i] = &exportRow{
+				ShortUrl:       host + "/" + l.ShortUrl,
+				LongUrl:        l.LongUrl,
+				Title:          l.Title,
+				Tags:           l.Tags,
+				CreatedAt:      l.CreatedAt.UTC(),
+				ExpirationDate: l.ExpirationDate.UTC(),
+				Disabled:       l.Disabled,
+			}
+			if rows[i].Tags == nil {
+				rows[i].Tags = []string{}
+			}
+			if clicks {
+				rows[i].exportClicks = newExportClicks(stats[l.ShortUrl])
+			}
+		}
+		if err := lw.Write(rows); err != nil {
+			return written, err
+		}
+		written += int64(len(rows))
+
+		if len(links) < q.Limit {
+			return written, nil
+		}
+		q.After = links[len(links)-1].Cursor(q.Sort)
+	}
+}
+
+func newExportClicks(s *domain.ClickStats) *exportClicks {
+	c := new(exportClicks)
+	if s == nil {
+		return c
+	}
+	c.Clicks = s.Total
+	c.Clicks7d = s.Last7Days
+	c.Clicks30d = s.Last30Days
+	if s.LastClickedOn != nil {
+		d := s.LastClickedOn.UTC().Format(dateLayout)
+		c.LastClickedOn = &d
+	}
+	return c
+}
+
+// linksWriter encodes exported links into a file of its format
+type linksWriter interface {
+	Write(rows []*exportRow) error
+	// Close writes the end of the file, it doesn't close the underlying
+	// writer
+	Close() error
+}
+
+func newLinksWriter(w io.Writer, format domain.ExportFormat, clicks bool) linksWriter {
+	switch format {
+	case domain.ExportNDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}
+	case domain.ExportParquet:
+		if clicks {
+			return newParquetWriter(w, newParquetClickedLink)
+		}
+		return newParquetWriter(w, newParquetLink)
+	default:
+		return &csvWriter{w: csv.NewWriter(w), clicks: clicks}
+	}
+}
+
+// exportContentType returns the media type and the file extension of the
+// format
+func exportContentType(format domain.ExportFormat) (string, string) {
+	switch format {
+	case domain.ExportNDJSON:
+		return "application/x-ndjson", "ndjson"
+	case domain.ExportParquet:
+		return "application/vnd.apache.parquet", "parquet"
+	default:
+		return "text/csv; charset=utf-8", "csv"
+	}
+}
+
+var (
+	csvHeader       = []string{"short_url", "long_url", "title", "tags", "created_at", "expiration_date", "disabled"}
+	csvClicksHeader = []string{"clicks", "clicks_7d", "clicks_30d", "last_clicked_on"}
+)
+
+// csvWriter writes a header first. Tags are joined with commas, links
+// that haven't been clicked have an empty last_clicked_on.
+type csvWriter struct {
+	w             *csv.Writer
+	clicks        bool
+	headerWritten bool
+}
+
+func (c *csvWriter) Write(rows []*exportRow) error {
+	if !c.headerWritten {
+		if err := c.writeHeader(); err != nil {
+			return err
+		}
+	}
+	for _, r := range rows {
+		record := []string{
+			r.ShortUrl,
+			r.LongUrl,
+			r.Title,
+			strings.Join(r.Tags, ","),
+			r.CreatedAt.Format(time.RFC3339),
+			r.ExpirationDate.Format(time.RFC3339),
+			strconv.FormatBool(r.Disabled),
+		}
+		if r.exportClicks != nil {
+			lastClickedOn := ""
+			if r.LastClickedOn != nil {
+				lastClickedOn = *r.LastClickedOn
+			}
+			record = append(
+				record,
+				strconv.FormatInt(r.Clicks, 10),
+				strconv.FormatInt(r.Clicks7d, 10),
+				strconv.FormatInt(r.Clicks30d, 10),
+				lastClickedOn,
+			)
+		}
+		if err := c.w.Write(record); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) writeHeader() error {
+	c.headerWritten = true
+	header := csvHeader
+	if c.clicks {
+		header = append(append([]string{}, csvHeader...), csvClicksHeader...)
+	}
+	return c.w.Write(header)
+}
+
+// Close writes the header of empty exports
+func (c *csvWriter) Close() error {
+	if !c.headerWritten {
+		if err := c.writeHeader(); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(rows []*exportRow) error {
+	for _, r := range rows {
+		if err := n.enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+type parquetLink struct {
+	ShortUrl       string    `parquet:"short_url"`
+	LongUrl        string    `parquet:"long_url"`
+	Title          string    `parquet:"title,optional"`
+	Tags           []string  `parquet:"tags,list"`
+	CreatedAt      time.Time `parquet:"created_at,timestamp(millisecond)"`
+	ExpirationDate time.Time `parquet:"expiration_date,timestamp(millisecond)"`
+	Disabled       bool      `parquet:"disabled"`
+}
+
+func newParquetLink(r *exportRow) parquetLink {
+	return parquetLink{
+		ShortUrl:       r.ShortUrl,
+		LongUrl:        r.LongUrl,
+		Title:          r.Title,
+		Tags:           r.Tags,
+		CreatedAt:      r.CreatedAt,
+		ExpirationDate: r.ExpirationDate,
+		Disabled:       r.Disabled,
+	}
+}
+
+type parquetClickedLink struct {
+	parquetLink
+	Clicks    int64 `parquet:"clicks"`
+	Clicks7d  int64 `parquet:"clicks_7d"`
+	Clicks30d int64 `parquet:"clicks_30d"`
+	// days since the epoch, zeros are written as nulls
+	LastClickedOn int32 `parquet:"last_clicked_on,optional,date"`
+}
+
+func newParquetClickedLink(r *exportRow) parquetClickedLink {
+	l := parquetClickedLink{parquetLink: newParquetLink(r)}
+	if r.exportClicks == nil {
+		return l
+	}
+	l.Clicks = r.Clicks
+	l.Clicks7d = r.Clicks7d
+	l.Clicks30d = r.Clicks30d
+	if r.LastClickedOn != nil {
+		d, _ := time.Parse(dateLayout, *r.LastClickedOn)
+		l.LastClickedOn = int32(d.Unix() / int64(24*time.Hour/time.Second))
+	}
+	return l
+}
+
+type parquetWriter[T any] struct {
+	w    *parquet.GenericWriter[T]
+	conv func(r *exportRow) T
+}
+
+func newParquetWriter[T any](w io.Writer, conv func(r *exportRow) T) *parquetWriter[T] {
+	return &parquetWriter[T]{
+		w:    parquet.NewGenericWriter[T](w, parquet.MaxRowsPerRowGroup(exportRowGroupSize)),
+		conv: conv,
+	}
+}
+
+func (p *parquetWriter[T]) Write(rows []*exportRow) error {
+	links := make([]T, len(rows))
+	for i, r := range rows {
+		links[i] = p.conv(r)
+	}
+	_, err := p.w.Write(links)
+	return err
+}
+
+func (p *parquetWriter[T]) Close() error {
+	return p.w.Close()
+}
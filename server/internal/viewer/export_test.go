@@ -0,0 +1,418 @@
+package viewer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"shortener/pkg/domain"
+	"shortener/pkg/jobqueue"
+	"shortener/pkg/middleware"
+	"shortener/pkg/models/exports"
+	"shortener/proto/blackbox"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/justinas/alice"
+	"github.com/parquet-go/parquet-go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	pbblackbox_mock "shortener/mocks/shortener/proto/blackbox"
+)
+
+func exportViewer(t *testing.T, u Urls, tokenInfo *blackbox.ValidateTokenRsp, opts ...viewerOption) *Viewer {
+	c := pbblackbox_mock.NewMockBlackboxServiceClient(t)
+	c.EXPECT().
+		ValidateToken(context.TODO(), mock.AnythingOfType("*blackbox.ValidateTokenReq")).
+		Return(tokenInfo, nil)
+	v, err := New(append([]viewerOption{
+		WithUrls(u),
+		WithBlackboxClient(c),
+		WithRedirectorHost("host"),
+	}, opts...)...)
+	assert.Nil(t, err)
+	return v
+}
+
+func exportRequest(t *testing.T, target string) *http.Request {
+	r, err := http.NewRequest("GET", target, nil)
+	assert.Nil(t, err)
+	r.AddCookie(&http.Cookie{Name: "JWT", Value: "token"})
+	return r
+}
+
+func TestExportStreamsCSVInPages(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	lastClick := time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC)
+	firstPage := make([]*domain.UrlInfo, exportPageSize)
+	for i := range firstPage {
+		firstPage[i] = &domain.UrlInfo{
+			ShortUrl:       fmt.Sprintf("c%04d", i),
+			LongUrl:        "https://example.com",
+			CreatedAt:      created,
+			ExpirationDate: created.Add(time.Hour),
+		}
+	}
+
+	u := NewMockUrls(t)
+	u.EXPECT().
+		History(mock.Anything, mock.MatchedBy(func(q *domain.HistoryQuery) bool {
+			return q.After == nil
+		})).
+		RunAndReturn(func(_ context.Context, q *domain.HistoryQuery) ([]*domain.UrlInfo, error) {
+			assert.Equal(t, "id", q.UserId)
+			assert.Equal(t, exportPageSize, q.Limit)
+			assert.Equal(t, "docs", q.Tag)
+			return firstPage, nil
+		}).
+		Once()
+	u.EXPECT().
+		History(mock.Anything, mock.MatchedBy(func(q *domain.HistoryQuery) bool {
+			return q.After != nil
+		})).
+		RunAndReturn(func(_ context.Context, q *domain.HistoryQuery) ([]*domain.UrlInfo, error) {
+			assert.Equal(t, fmt.Sprintf("c%04d", exportPageSize-1), q.After.ShortUrl)
+			return []*domain.UrlInfo{{
+				ShortUrl:       "last1",
+				LongUrl:        "https://example.com/a,b",
+				Title:          "Release notes",
+				Tags:           []string{"docs", "release"},
+				CreatedAt:      created,
+				ExpirationDate: created.Add(time.Hour),
+			}}, nil
+		}).
+		Once()
+	u.EXPECT().
+		ClickStats(mock.Anything, mock.Anything).
+		RunAndReturn(func(_ context.Context, shortUrls []string) (map[string]*domain.ClickStats, error) {
+			if len(shortUrls) != 1 {
+				return nil, nil
+			}
+			return map[string]*domain.ClickStats{
+				"last1": {Total: 12, Last7Days: 2, Last30Days: 5, LastClickedOn: &lastClick},
+			}, nil
+		}).
+		Times(2)
+
+	v := exportViewer(t, u, &blackbox.ValidateTokenRsp{UserId: "id"})
+	recorder := httptest.NewRecorder()
+	v.HandleExport(recorder, exportRequest(t, "/export?tag=docs&clicks=true"))
+
+	rsp := recorder.Result()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "text/csv; charset=utf-8", rsp.Header.Get("Content-Type"))
+	assert.Contains(t, rsp.Header.Get("Content-Disposition"), "links.csv")
+
+	records, err := csv.NewReader(rsp.Body).ReadAll()
+	assert.Nil(t, err)
+	assert.Len(t, records, exportPageSize+2)
+	assert.Equal(t, append(append([]string{}, csvHeader...), csvClicksHeader...), records[0])
+	assert.Equal(t, []string{
+		"host/c0000", "https://example.com", "", "", "2024-05-01T12:00:00Z",
+		"2024-05-01T13:00:00Z", "false", "0", "0", "0", "",
+	}, records[1])
+	assert.Equal(t, []string{
+		"host/last1", "https://example.com/a,b", "Release notes", "docs,release",
+		"2024-05-01T12:00:00Z", "2024-05-01T13:00:00Z", "false", "12", "2", "5", "2024-05-20",
+	}, records[len(records)-1])
+}
+
+func TestExportStreamsNDJSON(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().
+		History(mock.Anything, mock.Anything).
+		Return([]*domain.UrlInfo{
+			{ShortUrl: "aaaaa", LongUrl: "https://a.example.com"},
+			{ShortUrl: "bbbbb", LongUrl: "https://b.example.com", Tags: []string{"docs"}},
+		}, nil).
+		Once()
+
+	v := exportViewer(t, u, &blackbox.ValidateTokenRsp{UserId: "id"})
+	recorder := httptest.NewRecorder()
+	v.HandleExport(recorder, exportRequest(t, "/export?format=ndjson"))
+
+	rsp := recorder.Result()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", rsp.Header.Get("Content-Type"))
+
+	var lines []map[string]any
+	scanner := bufio.NewScanner(rsp.Body)
+	for scanner.Scan() {
+		var line map[string]any
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	assert.Len(t, lines, 2)
+	assert.Equal(t, "host/aaaaa", lines[0]["short_url"])
+	assert.Equal(t, []any{}, lines[0]["tags"])
+	assert.Equal(t, []any{"docs"}, lines[1]["tags"])
+	// click aggregates weren't asked for
+	assert.NotContains(t, lines[0], "clicks")
+}
+
+func TestExportWritesParquet(t *testing.T) {
+	lastClick := time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC)
+	u := NewMockUrls(t)
+	u.EXPECT().
+		History(mock.Anything, mock.Anything).
+		Return([]*domain.UrlInfo{
+			{ShortUrl: "aaaaa", LongUrl: "https://a.example.com", Title: "A"},
+			{ShortUrl: "bbbbb", LongUrl: "https://b.example.com"},
+		}, nil).
+		Once()
+	u.EXPECT().
+		ClickStats(mock.Anything, []string{"aaaaa", "bbbbb"}).
+		Return(map[string]*domain.ClickStats{
+			"aaaaa": {Total: 3, Last7Days: 3, Last30Days: 3, LastClickedOn: &lastClick},
+		}, nil).
+		Once()
+
+	v := exportViewer(t, u, &blackbox.ValidateTokenRsp{UserId: "id"})
+	recorder := httptest.NewRecorder()
+	v.HandleExport(recorder, exportRequest(t, "/export?format=parquet&clicks=1"))
+	assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+
+	body := recorder.Body.Bytes()
+	rows, err := parquet.Read[parquetClickedLink](bytes.NewReader(body), int64(len(body)))
+	assert.Nil(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "host/aaaaa", rows[0].ShortUrl)
+	assert.Equal(t, "A", rows[0].Title)
+	assert.Equal(t, int64(3), rows[0].Clicks)
+	assert.Equal(t, int32(lastClick.Unix()/86400), rows[0].LastClickedOn)
+	assert.Equal(t, int64(0), rows[1].Clicks)
+	assert.Equal(t, int32(0), rows[1].LastClickedOn)
+}
+
+func TestExportInvalidQuery(t *testing.T) {
+	for _, query := range []string{
+		"format=xlsx",
+		"clicks=maybe",
+		"limit=10",
+		"cursor=abc",
+		"status=archived",
+	} {
+		t.Run(query, func(t *testing.T) {
+			v := exportViewer(t, NewMockUrls(t), &blackbox.ValidateTokenRsp{UserId: "id"})
+			recorder := httptest.NewRecorder()
+			v.HandleExport(recorder, exportRequest(t, "/export?"+query))
+			assert.Equal(t, http.StatusBadRequest, recorder.Result().StatusCode)
+		})
+	}
+}
+
+func TestExportQueuesLargeExports(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().
+		CountHistory(mock.Anything, mock.MatchedBy(func(q *domain.HistoryQuery) bool {
+			return q.WorkspaceId == "workspace"
+		})).
+		Return(101, nil)
+	e := NewMockExports(t)
+	e.EXPECT().
+		Create(mock.Anything, mock.Anything).
+		RunAndReturn(func(_ context.Context, j *domain.ExportJob) error {
+			assert.Equal(t, "id", j.UserId)
+			assert.Equal(t, "workspace", j.WorkspaceId)
+			assert.Equal(t, domain.ExportNDJSON, j.Format)
+			assert.True(t, j.Clicks)
+			assert.Equal(t, domain.SortExpiration, j.Query.Sort)
+			assert.Equal(t, "docs", j.Query.Tag)
+			j.Id = "0b5fd13c-4f4f-4b8e-9a31-36bd5e4c2d9a"
+			j.Status = domain.ExportPending
+			return nil
+		})
+
+	v := exportViewer(t, u, &blackbox.ValidateTokenRsp{
+		UserId:      "id",
+		WorkspaceId: "workspace",
+		Role:        string(domain.RoleViewer),
+	}, WithExports(e, 100))
+	recorder := httptest.NewRecorder()
+	v.HandleExport(recorder, exportRequest(t, "/export?format=ndjson&clicks=true&sort=expiration&tag=docs"))
+
+	rsp := recorder.Result()
+	assert.Equal(t, http.StatusAccepted, rsp.StatusCode)
+	assert.Equal(t, "/exports/0b5fd13c-4f4f-4b8e-9a31-36bd5e4c2d9a", rsp.Header.Get("Location"))
+	var j domain.ExportJob
+	assert.Nil(t, json.NewDecoder(rsp.Body).Decode(&j))
+	assert.Equal(t, domain.ExportPending, j.Status)
+	assert.Empty(t, j.DownloadUrl)
+}
+
+func TestExportStreamsSmallExportsWithJobs(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().CountHistory(mock.Anything, mock.Anything).Return(100, nil)
+	u.EXPECT().History(mock.Anything, mock.Anything).Return(nil, nil).Once()
+
+	v := exportViewer(t, u, &blackbox.ValidateTokenRsp{UserId: "id"}, WithExports(NewMockExports(t), 100))
+	recorder := httptest.NewRecorder()
+	v.HandleExport(recorder, exportRequest(t, "/export"))
+
+	assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	assert.Equal(t, strings.Join(csvHeader, ",")+"\n", recorder.Body.String())
+}
+
+func TestExportJobs(t *testing.T) {
+	const id = "0b5fd13c-4f4f-4b8e-9a31-36bd5e4c2d9a"
+	done := &domain.ExportJob{
+		Id:     id,
+		UserId: "id",
+		Format: domain.ExportCSV,
+		Status: domain.ExportDone,
+		Rows:   2,
+		Size:   10,
+		Chunks: 2,
+	}
+
+	for _, tc := range []struct {
+		name   string
+		target string
+		job    *domain.ExportJob
+		err    error
+		status int
+		body   string
+	}{
+		{"status", "/exports/" + id, done, nil, http.StatusOK, ""},
+		{"download", "/exports/" + id + "/download", done, nil, http.StatusOK, "short,long"},
+		{
+			"pending download",
+			"/exports/" + id + "/download",
+			&domain.ExportJob{Id: id, UserId: "id", Status: domain.ExportRunning},
+			nil,
+			http.StatusConflict,
+			"",
+		},
+		{
+			"another user",
+			"/exports/" + id,
+			&domain.ExportJob{Id: id, UserId: "other", Status: domain.ExportDone},
+			nil,
+			http.StatusNotFound,
+			"",
+		},
+		{
+			"workspace job",
+			"/exports/" + id,
+			&domain.ExportJob{Id: id, UserId: "id", WorkspaceId: "workspace"},
+			nil,
+			http.StatusNotFound,
+			"",
+		},
+		{"expired", "/exports/" + id, nil, exports.ErrNotFound, http.StatusNotFound, ""},
+		{"invalid id", "/exports/nope", nil, nil, http.StatusNotFound, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := NewMockExports(t)
+			if tc.job != nil || tc.err != nil {
+				e.EXPECT().Job(mock.Anything, id).Return(tc.job, tc.err)
+			}
+			if tc.body != "" {
+				e.EXPECT().Chunk(mock.Anything, id, 0).Return([]byte("short,"), nil)
+				e.EXPECT().Chunk(mock.Anything, id, 1).Return([]byte("long"), nil)
+			}
+			v := exportViewer(t, NewMockUrls(t), &blackbox.ValidateTokenRsp{UserId: "id"}, WithExports(e, 100))
+			mux := http.NewServeMux()
+			v.Mount(mux, alice.New(), middleware.Cors{Origin: "origin"})
+
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, exportRequest(t, tc.target))
+			assert.Equal(t, tc.status, recorder.Result().StatusCode)
+			if tc.body != "" {
+				assert.Equal(t, tc.body, recorder.Body.String())
+			}
+			if tc.name == "status" {
+				var j domain.ExportJob
+				assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&j))
+				assert.Equal(t, "/exports/"+id+"/download", j.DownloadUrl)
+			}
+		})
+	}
+}
+
+func TestExporterRunsJobs(t *testing.T) {
+	job := &domain.ExportJob{
+		Id:     "job",
+		Format: domain.ExportNDJSON,
+		Query:  domain.HistoryQuery{UserId: "id", Sort: domain.SortCreated, Desc: true},
+	}
+	u := NewMockUrls(t)
+	u.EXPECT().
+		History(mock.Anything, mock.Anything).
+		Return([]*domain.UrlInfo{
+			{ShortUrl: "aaaaa", LongUrl: "https://a.example.com"},
+			{ShortUrl: "bbbbb", LongUrl: "https://b.example.com"},
+		}, nil).
+		Once()
+
+	var file []byte
+	var chunks int
+	jobs := NewMockJobs(t)
+	jobs.EXPECT().Claim(mock.Anything, 2*jobqueue.DefaultTimeout).Return(job, nil).Once()
+	jobs.EXPECT().Claim(mock.Anything, 2*jobqueue.DefaultTimeout).Return(nil, nil).Once()
+	jobs.EXPECT().
+		WriteChunk(mock.Anything, "job", mock.Anything, mock.Anything).
+		RunAndReturn(func(_ context.Context, _ string, seq int, data []byte) error {
+			assert.Equal(t, chunks, seq)
+			assert.LessOrEqual(t, len(data), 64)
+			chunks++
+			file = append(file, data...)
+			return nil
+		})
+	jobs.EXPECT().
+		Finish(mock.Anything, "job", int64(2), mock.Anything, mock.Anything, jobqueue.DefaultRetention).
+		RunAndReturn(func(_ context.Context, _ string, _ int64, size int64, n int, _ time.Duration) error {
+			assert.Equal(t, int64(len(file)), size)
+			assert.Equal(t, chunks, n)
+			return nil
+		})
+	jobs.EXPECT().Prune(mock.Anything).Return(0, nil)
+
+	log := zerolog.Nop()
+	e, err := NewExporter(
+		WithJobs(jobs),
+		WithExporterUrls(u),
+		WithExporterRedirectorHost("host"),
+		WithExporterLogger(&log),
+	)
+	assert.Nil(t, err)
+	e.chunkSize = 64
+
+	assert.Nil(t, e.Pass(context.Background()))
+	assert.Greater(t, chunks, 1)
+	assert.Equal(t, 2, strings.Count(string(file), "\n"))
+	assert.Contains(t, string(file), `"short_url":"host/bbbbb"`)
+}
+
+func TestExporterFailsJobs(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().History(mock.Anything, mock.Anything).Return(nil, errors.New("db is down"))
+
+	jobs := NewMockJobs(t)
+	jobs.EXPECT().
+		Claim(mock.Anything, mock.Anything).
+		Return(&domain.ExportJob{Id: "job", Format: domain.ExportCSV}, nil).
+		Once()
+	jobs.EXPECT().Claim(mock.Anything, mock.Anything).Return(nil, nil).Once()
+	jobs.EXPECT().Fail(mock.Anything, "job", "couldn't export links", time.Hour).Return(nil)
+	jobs.EXPECT().Prune(mock.Anything).Return(1, nil)
+
+	log := zerolog.Nop()
+	e, err := NewExporter(
+		WithJobs(jobs),
+		WithExporterUrls(u),
+		WithExporterRedirectorHost("host"),
+		WithRetention(time.Hour),
+		WithExporterLogger(&log),
+	)
+	assert.Nil(t, err)
+	assert.Nil(t, e.Pass(context.Background()))
+}
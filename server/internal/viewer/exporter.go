@@ -0,0 +1,279 @@
+package viewer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"shortener/pkg/domain"
+	"shortener/pkg/jobqueue"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+)
+
+var (
+	exportJobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "viewer_export_jobs_total",
+		Help: "Finished export jobs by result: done or failed.",
+	}, []string{"result"})
+	exportJobDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "viewer_export_job_duration_seconds",
+		Help:    "Time export jobs have been running for.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+	exportedLinks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "viewer_export_job_links_total",
+		Help: "Links written to files of export jobs that are done.",
+	})
+)
+
+// Jobs runs export jobs and stores their files, see exports.Model
+type Jobs interface {
+	Claim(ctx context.Context, lease time.Duration) (*domain.ExportJob, error)
+	WriteChunk(ctx context.Context, id string, seq int, data []byte) error
+	Finish(
+		ctx context.Context,
+		id string,
+		rows int64,
+		size int64,
+		chunks int,
+		ttl time.Duration,
+	) error
+	Fail(ctx context.Context, id string, reason string, ttl time.Duration) error
+	Prune(ctx context.Context) (int64, error)
+}
+
+// Exporter runs export jobs queued by HandleExport one at a time, and
+// removes them with their files once they expire. Files are stored in
+// chunks as they are written, so that only a chunk is kept in memory.
+type Exporter struct {
+	jobs           Jobs
+	urls           Urls
+	redirectorHost string
+	runner         *jobqueue.Runner[domain.ExportJob]
+
+	pollInterval time.Duration
+	timeout      time.Duration
+	retention    time.Duration
+	chunkSize    int
+
+	log *zerolog.Logger
+}
+
+const defaultChunkSize = 1 << 20
+
+type exporterOption func(e *Exporter) error
+
+func WithJobs(j Jobs) exporterOption {
+	return func(e *Exporter) error {
+		e.jobs = j
+		return nil
+	}
+}
+
+func WithExporterUrls(u Urls) exporterOption {
+	return func(e *Exporter) error {
+		e.urls = u
+		return nil
+	}
+}
+
+// WithExporterRedirectorHost prefixes exported short urls with the host
+func WithExporterRedirectorHost(host string) exporterOption {
+	return func(e *Exporter) error {
+		e.redirectorHost = host
+		return nil
+	}
+}
+
+// WithPollInterval sets the time between looks for pending jobs
+func WithPollInterval(d time.Duration) exporterOption {
+	return func(e *Exporter) error {
+		if d <= 0 {
+			return errors.New("poll interval must be positive")
+		}
+		e.pollInterval = d
+		return nil
+	}
+}
+
+// WithJobTimeout fails jobs running for longer than the timeout
+func WithJobTimeout(d time.Duration) exporterOption {
+	return func(e *Exporter) error {
+		if d <= 0 {
+			return errors.New("job timeout must be positive")
+		}
+		e.timeout = d
+		return nil
+	}
+}
+
+// WithRetention keeps finished jobs and their files for the time
+func WithRetention(d time.Duration) exporterOption {
+	return func(e *Exporter) error {
+		if d <= 0 {
+			return errors.New("retention must be positive")
+		}
+		e.retention = d
+		return nil
+	}
+}
+
+func WithExporterLogger(l *zerolog.Logger) exporterOption {
+	return func(e *Exporter) error {
+		e.log = l
+		return nil
+	}
+}
+
+func NewExporter(opts ...exporterOption) (*Exporter, error) {
+	e := &Exporter{
+		pollInterval: jobqueue.DefaultPollInterval,
+		timeout:      jobqueue.DefaultTimeout,
+		retention:    jobqueue.DefaultRetention,
+		chunkSize:    defaultChunkSize,
+	}
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+	if e.jobs == nil {
+		return nil, errors.New("no jobs model provided")
+	}
+	if e.urls == nil {
+		return nil, errors.New("no urls model provided")
+	}
+	if e.redirectorHost == "" {
+		return nil, errors.New("no redirector host provided")
+	}
+	if e.log == nil {
+		return nil, errors.New("no logger provided")
+	}
+	e.runner = &jobqueue.Runner[domain.ExportJob]{
+		Queue:        e.jobs,
+		Handle:       e.run,
+		Timeout:      e.timeout,
+		PollInterval: e.pollInterval,
+		Kind:         "export",
+		Log:          e.log,
+	}
+	return e, nil
+}
+
+// Run makes passes until the context is cancelled. Jobs interrupted by the
+// cancellation are started again by some instance once their leases run
+// out.
+func (e *Exporter) Run(ctx context.Context) {
+	e.runner.Run(ctx)
+}
+
+// Pass runs pending jobs until there are none left, then removes expired
+// ones
+func (e *Exporter) Pass(ctx context.Context) error {
+	return e.runner.Pass(ctx)
+}
+
+// run exports links of the job into its file, and marks the job as done or
+// failed. Errors are only returned if the job couldn't be marked.
+func (e *Exporter) run(ctx context.Context, j *domain.ExportJob) error {
+	log := e.log.With().
+		Str("export_id", j.Id).
+		Str("format", string(j.Format)).
+		Logger()
+	log.Info().Msg("running export job")
+	start := time.Now()
+
+	jobCtx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	cw := &chunkWriter{
+		ctx:  jobCtx,
+		jobs: e.jobs,
+		id:   j.Id,
+		size: e.chunkSize,
+	}
+	lw := newLinksWriter(cw, j.Format, j.Clicks)
+	q := j.Query
+	rows, err := exportLinks(jobCtx, e.urls, &q, j.Clicks, e.redirectorHost, lw)
+	if err == nil {
+		err = lw.Close()
+	}
+	if err == nil {
+		err = cw.Close()
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	exportJobDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		log.Error().Err(err).Int64("rows", rows).Msg("export job failed")
+		reason := "couldn't export links"
+		if errors.Is(err, context.DeadlineExceeded) {
+			reason = "export has timed out"
+		}
+		exportJobs.WithLabelValues("failed").Inc()
+		return e.jobs.Fail(ctx, j.Id, reason, e.retention)
+	}
+
+	if err := e.jobs.Finish(ctx, j.Id, rows, cw.written, cw.seq, e.retention); err != nil {
+		return err
+	}
+	exportJobs.WithLabelValues("done").Inc()
+	exportedLinks.Add(float64(rows))
+	log.Info().
+		Int64("rows", rows).
+		Int64("size", cw.written).
+		Msg("export job is done")
+	return nil
+}
+
+// chunkWriter stores what's written to it in chunks of the size. Close
+// stores the rest, files always have at least one chunk.
+type chunkWriter struct {
+	ctx  context.Context
+	jobs Jobs
+	id   string
+	size int
+
+	buf     bytes.Buffer
+	seq     int
+	written int64
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		free := c.size - c.buf.Len()
+		if free > len(p) {
+			free = len(p)
+		}
+		c.buf.Write(p[:free])
+		p = p[free:]
+		if c.buf.Len() == c.size {
+			if err := c.flush(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (c *chunkWriter) flush() error {
+	if err := c.jobs.WriteChunk(c.ctx, c.id, c.seq, c.buf.Bytes()); err != nil {
+		return err
+	}
+	c.seq++
+	c.written += int64(c.buf.Len())
+	c.buf.Reset()
+	return nil
+}
+
+func (c *chunkWriter) Close() error {
+	if c.buf.Len() == 0 && c.seq > 0 {
+		return nil
+	}
+	return c.flush()
+}
@@ -0,0 +1,286 @@
+package viewer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"shortener/pkg/domain"
+	"shortener/pkg/models/exports"
+	"shortener/pkg/responses"
+	"shortener/proto/blackbox"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+)
+
+var exportRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "viewer_export_requests_total",
+	Help: "Export requests by mode: streamed, or queued as jobs.",
+}, []string{"mode"})
+
+// Exports keeps export jobs and their files, see exports.Model
+type Exports interface {
+	Create(ctx context.Context, j *domain.ExportJob) error
+	Job(ctx context.Context, id string) (*domain.ExportJob, error)
+	Chunk(ctx context.Context, id string, seq int) ([]byte, error)
+}
+
+// WithExports runs exports of more links than the sync limit as jobs, see
+// Exporter, and serves the routes of the jobs. Without it every export is
+// streamed.
+func WithExports(e Exports, syncLimit int64) viewerOption {
+	return func(v *Viewer) error {
+		if syncLimit < 0 {
+			return errors.New("export sync limit must not be negative")
+		}
+		v.exports = e
+		v.syncLimit = syncLimit
+		return nil
+	}
+}
+
+// HandleExport exports links of the user, or of the workspace for sessions
+// in one, that match the history filters. Small exports are streamed in
+// the response, larger ones are queued as jobs whose files are downloaded
+// once they are done.
+func (v *Viewer) HandleExport(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r).With().Logger()
+	tokenInfo, ok := v.authenticate(w, r, &log)
+	if !ok {
+		return
+	}
+
+	q, format, clicks, err := exportQuery(r.URL.Query())
+	if err != nil {
+		log.Info().Err(err).Msg("invalid export query")
+		res, _ := json.Marshal(&responses.Server{
+			Message: err.Error(),
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(res)
+		return
+	}
+	q.UserId = tokenInfo.GetUserId()
+	q.WorkspaceId = tokenInfo.GetWorkspaceId()
+	log = log.With().
+		Str("user_id", q.UserId).
+		Str("workspace_id", q.WorkspaceId).
+		Str("format", string(format)).
+		Bool("clicks", clicks).
+		Logger()
+
+	if v.exports != nil {
+		n, err := v.urls.CountHistory(r.Context(), q)
+		if err != nil {
+			log.Error().Err(err).Msg("couldn't count exported links")
+			res, _ := json.Marshal(&responses.Server{
+				Message: "couldn't export links. try again later",
+			})
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(res)
+			return
+		}
+		if n > v.syncLimit {
+			v.queueExport(w, r, &log, q, format, clicks)
+			return
+		}
+	}
+
+	contentType, ext := exportContentType(format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="links.`+ext+`"`)
+	w.WriteHeader(http.StatusOK)
+	exportRequests.WithLabelValues("stream").Inc()
+
+	lw := &flushingWriter{
+		linksWriter: newLinksWriter(w, format, clicks),
+		rc:          http.NewResponseController(w),
+	}
+	n, err := exportLinks(r.Context(), v.urls, q, clicks, v.redirectorHost, lw)
+	if err == nil {
+		err = lw.Close()
+	}
+	if err != nil {
+		// the status has been sent, the client only notices the error if
+		// the response is cut short
+		log.Error().Err(err).Int64("rows", n).Msg("couldn't stream export")
+		panic(http.ErrAbortHandler)
+	}
+	log.Info().Int64("rows", n).Msg("streamed export")
+}
+
+// flushingWriter sends every page of links to the client as soon as it's
+// encoded
+type flushingWriter struct {
+	linksWriter
+	rc *http.ResponseController
+}
+
+func (f *flushingWriter) Write(rows []*exportRow) error {
+	if err := f.linksWriter.Write(rows); err != nil {
+		return err
+	}
+	if err := f.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+func (v *Viewer) queueExport(
+	w http.ResponseWriter,
+	r *http.Request,
+	log *zerolog.Logger,
+	q *domain.HistoryQuery,
+	format domain.ExportFormat,
+	clicks bool,
+) {
+	j := &domain.ExportJob{
+		UserId:      q.UserId,
+		WorkspaceId: q.WorkspaceId,
+		Format:      format,
+		Clicks:      clicks,
+		Query:       *q,
+	}
+	if err := v.exports.Create(r.Context(), j); err != nil {
+		log.Error().Err(err).Msg("couldn't create export job")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't export links. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
+	}
+	exportRequests.WithLabelValues("job").Inc()
+	log.Info().Str("export_id", j.Id).Msg("queued export job")
+
+	res, _ := json.Marshal(j)
+	w.Header().Set("Location", "/exports/"+j.Id)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(res)
+}
+
+// HandleExportJob returns the status of the export job. Jobs that are done
+// have the url their files are downloaded from.
+func (v *Viewer) HandleExportJob(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r).
+		With().
+		Str("export_id", r.PathValue("id")).
+		Logger()
+	tokenInfo, ok := v.authenticate(w, r, &log)
+	if !ok {
+		return
+	}
+	j, ok := v.ownedExport(w, r, &log, tokenInfo)
+	if !ok {
+		return
+	}
+
+	if j.Status == domain.ExportDone {
+		j.DownloadUrl = "/exports/" + j.Id + "/download"
+	}
+	res, _ := json.Marshal(j)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// HandleExportDownload streams the file of the export job once it's done
+func (v *Viewer) HandleExportDownload(w http.ResponseWriter, r *http.Request) {
+	log := hlog.FromRequest(r).
+		With().
+		Str("export_id", r.PathValue("id")).
+		Logger()
+	tokenInfo, ok := v.authenticate(w, r, &log)
+	if !ok {
+		return
+	}
+	j, ok := v.ownedExport(w, r, &log, tokenInfo)
+	if !ok {
+		return
+	}
+	if j.Status != domain.ExportDone {
+		log.Info().Str("status", string(j.Status)).Msg("export isn't done")
+		res, _ := json.Marshal(&responses.Server{
+			Message: fmt.Sprintf("export is %s", j.Status),
+		})
+		w.WriteHeader(http.StatusConflict)
+		w.Write(res)
+		return
+	}
+
+	// the first chunk is read before the status is sent, so that the
+	// client gets an error if the file can't be read at all
+	data, err := v.exports.Chunk(r.Context(), j.Id, 0)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't get export file")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't get export file. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
+	}
+
+	contentType, ext := exportContentType(j.Format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="links-`+j.Id+`.`+ext+`"`)
+	w.Header().Set("Content-Length", strconv.FormatInt(j.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	for seq := 1; ; seq++ {
+		if _, err := w.Write(data); err != nil {
+			log.Info().Err(err).Msg("client has stopped downloading export")
+			return
+		}
+		if seq == j.Chunks {
+			break
+		}
+		data, err = v.exports.Chunk(r.Context(), j.Id, seq)
+		if err != nil {
+			log.Error().Err(err).Int("chunk", seq).Msg("couldn't get chunk of export file")
+			panic(http.ErrAbortHandler)
+		}
+	}
+	log.Info().Msg("downloaded export")
+}
+
+// ownedExport returns the export job of the path if it belongs to the
+// user, or to the workspace the session is in. Jobs of others and expired
+// ones are reported as missing.
+func (v *Viewer) ownedExport(
+	w http.ResponseWriter,
+	r *http.Request,
+	log *zerolog.Logger,
+	tokenInfo *blackbox.ValidateTokenRsp,
+) (*domain.ExportJob, bool) {
+	var j *domain.ExportJob
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		err = exports.ErrNotFound
+	} else {
+		j, err = v.exports.Job(r.Context(), id.String())
+	}
+	if err != nil && !errors.Is(err, exports.ErrNotFound) {
+		log.Error().Err(err).Msg("couldn't get export")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't get export. try again later",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return nil, false
+	}
+	if err != nil || !domain.Owns(tokenInfo, j.UserId, j.WorkspaceId) {
+		log.Info().Msg("export not found")
+		res, _ := json.Marshal(&responses.Server{
+			Message: "export not found",
+		})
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(res)
+		return nil, false
+	}
+	return j, true
+}
@@ -22,9 +22,125 @@ func (_m *MockUrls) EXPECT() *MockUrls_Expecter {
 	return &MockUrls_Expecter{mock: &_m.Mock}
 }
 
-// History provides a mock function with given fields: ctx, userId
-func (_m *MockUrls) History(ctx context.Context, userId string) ([]*domain.UrlInfo, error) {
-	ret := _m.Called(ctx, userId)
+// ClickStats provides a mock function with given fields: ctx, shortUrls
+func (_m *MockUrls) ClickStats(ctx context.Context, shortUrls []string) (map[string]*domain.ClickStats, error) {
+	ret := _m.Called(ctx, shortUrls)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ClickStats")
+	}
+
+	var r0 map[string]*domain.ClickStats
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []string) (map[string]*domain.ClickStats, error)); ok {
+		return rf(ctx, shortUrls)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []string) map[string]*domain.ClickStats); ok {
+		r0 = rf(ctx, shortUrls)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]*domain.ClickStats)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, shortUrls)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockUrls_ClickStats_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ClickStats'
+type MockUrls_ClickStats_Call struct {
+	*mock.Call
+}
+
+// ClickStats is a helper method to define mock.On call
+//   - ctx context.Context
+//   - shortUrls []string
+func (_e *MockUrls_Expecter) ClickStats(ctx interface{}, shortUrls interface{}) *MockUrls_ClickStats_Call {
+	return &MockUrls_ClickStats_Call{Call: _e.mock.On("ClickStats", ctx, shortUrls)}
+}
+
+func (_c *MockUrls_ClickStats_Call) Run(run func(ctx context.Context, shortUrls []string)) *MockUrls_ClickStats_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].([]string))
+	})
+	return _c
+}
+
+func (_c *MockUrls_ClickStats_Call) Return(_a0 map[string]*domain.ClickStats, _a1 error) *MockUrls_ClickStats_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockUrls_ClickStats_Call) RunAndReturn(run func(context.Context, []string) (map[string]*domain.ClickStats, error)) *MockUrls_ClickStats_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// CountHistory provides a mock function with given fields: ctx, q
+func (_m *MockUrls) CountHistory(ctx context.Context, q *domain.HistoryQuery) (int64, error) {
+	ret := _m.Called(ctx, q)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CountHistory")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *domain.HistoryQuery) (int64, error)); ok {
+		return rf(ctx, q)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *domain.HistoryQuery) int64); ok {
+		r0 = rf(ctx, q)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *domain.HistoryQuery) error); ok {
+		r1 = rf(ctx, q)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockUrls_CountHistory_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CountHistory'
+type MockUrls_CountHistory_Call struct {
+	*mock.Call
+}
+
+// CountHistory is a helper method to define mock.On call
+//   - ctx context.Context
+//   - q *domain.HistoryQuery
+func (_e *MockUrls_Expecter) CountHistory(ctx interface{}, q interface{}) *MockUrls_CountHistory_Call {
+	return &MockUrls_CountHistory_Call{Call: _e.mock.On("CountHistory", ctx, q)}
+}
+
+func (_c *MockUrls_CountHistory_Call) Run(run func(ctx context.Context, q *domain.HistoryQuery)) *MockUrls_CountHistory_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(*domain.HistoryQuery))
+	})
+	return _c
+}
+
+func (_c *MockUrls_CountHistory_Call) Return(_a0 int64, _a1 error) *MockUrls_CountHistory_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockUrls_CountHistory_Call) RunAndReturn(run func(context.Context, *domain.HistoryQuery) (int64, error)) *MockUrls_CountHistory_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// History provides a mock function with given fields: ctx, q
+func (_m *MockUrls) History(ctx context.Context, q *domain.HistoryQuery) ([]*domain.UrlInfo, error) {
+	ret := _m.Called(ctx, q)
 
 	if len(ret) == 0 {
 		panic("no return value specified for History")
@@ -32,19 +148,19 @@ func (_m *MockUrls) History(ctx context.Context, userId string) ([]*domain.UrlIn
 
 	var r0 []*domain.UrlInfo
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) ([]*domain.UrlInfo, error)); ok {
-		return rf(ctx, userId)
+	if rf, ok := ret.Get(0).(func(context.Context, *domain.HistoryQuery) ([]*domain.UrlInfo, error)); ok {
+		return rf(ctx, q)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string) []*domain.UrlInfo); ok {
-		r0 = rf(ctx, userId)
+	if rf, ok := ret.Get(0).(func(context.Context, *domain.HistoryQuery) []*domain.UrlInfo); ok {
+		r0 = rf(ctx, q)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*domain.UrlInfo)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
-		r1 = rf(ctx, userId)
+	if rf, ok := ret.Get(1).(func(context.Context, *domain.HistoryQuery) error); ok {
+		r1 = rf(ctx, q)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -59,14 +175,14 @@ type MockUrls_History_Call struct {
 
 // History is a helper method to define mock.On call
 //   - ctx context.Context
-//   - userId string
-func (_e *MockUrls_Expecter) History(ctx interface{}, userId interface{}) *MockUrls_History_Call {
-	return &MockUrls_History_Call{Call: _e.mock.On("History", ctx, userId)}
+//   - q *domain.HistoryQuery
+func (_e *MockUrls_Expecter) History(ctx interface{}, q interface{}) *MockUrls_History_Call {
+	return &MockUrls_History_Call{Call: _e.mock.On("History", ctx, q)}
 }
 
-func (_c *MockUrls_History_Call) Run(run func(ctx context.Context, userId string)) *MockUrls_History_Call {
+func (_c *MockUrls_History_Call) Run(run func(ctx context.Context, q *domain.HistoryQuery)) *MockUrls_History_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context), args[1].(string))
+		run(args[0].(context.Context), args[1].(*domain.HistoryQuery))
 	})
 	return _c
 }
@@ -76,7 +192,7 @@ func (_c *MockUrls_History_Call) Return(_a0 []*domain.UrlInfo, _a1 error) *MockU
 	return _c
 }
 
-func (_c *MockUrls_History_Call) RunAndReturn(run func(context.Context, string) ([]*domain.UrlInfo, error)) *MockUrls_History_Call {
+func (_c *MockUrls_History_Call) RunAndReturn(run func(context.Context, *domain.HistoryQuery) ([]*domain.UrlInfo, error)) *MockUrls_History_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -0,0 +1,157 @@
+package viewer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"shortener/pkg/domain"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+	maxSearchLength     = 200
+)
+
+// cursor is encoded into the opaque cursor parameter. It remembers the order
+// it has been made for, so that it isn't applied to another one.
+type cursor struct {
+	Sort  domain.HistorySort    `json:"sort"`
+	Desc  bool                  `json:"desc"`
+	After *domain.HistoryCursor `json:"after"`
+}
+
+func encodeCursor(c *cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	if c.After == nil || c.After.ShortUrl == "" {
+		return nil, errors.New("cursor has no position")
+	}
+	return &c, nil
+}
+
+// historyQuery parses query parameters of a history request. Errors are
+// meant for the client. Links are sorted by creation, newest first, and
+// only active ones are listed by default.
+func historyQuery(values url.Values) (*domain.HistoryQuery, error) {
+	q := &domain.HistoryQuery{
+		Sort:   domain.SortCreated,
+		Desc:   true,
+		Limit:  defaultHistoryLimit,
+		Status: domain.StatusActive,
+	}
+
+	switch sort := domain.HistorySort(values.Get("sort")); sort {
+	case "":
+	case domain.SortCreated, domain.SortExpiration, domain.SortClicks:
+		q.Sort = sort
+	default:
+		return nil, errors.New("sort must be one of created, expiration or clicks")
+	}
+	switch values.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Desc = false
+	default:
+		return nil, errors.New("order must be asc or desc")
+	}
+	if l := values.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxHistoryLimit {
+			return nil, errors.New("limit must be from 1 to " + strconv.Itoa(maxHistoryLimit))
+		}
+		q.Limit = n
+	}
+	if s := values.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		if c.Sort != q.Sort || c.Desc != q.Desc {
+			return nil, errors.New("cursor has been made for another order")
+		}
+		q.After = c.After
+	}
+
+	switch status := domain.LinkStatus(values.Get("status")); status {
+	case "":
+	case domain.StatusActive, domain.StatusExpired, domain.StatusDisabled:
+		q.Status = status
+	default:
+		return nil, errors.New("status must be one of active, expired or disabled")
+	}
+	q.Domain = values.Get("domain")
+	if tag := values.Get("tag"); tag != "" {
+		if !domain.ValidTag(tag) {
+			return nil, errors.New("invalid tag")
+		}
+		q.Tag = tag
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"created_after", &q.CreatedAfter},
+		{"created_before", &q.CreatedBefore},
+	} {
+		v := values.Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, errors.New(p.name + " must be an RFC 3339 time")
+		}
+		// creation times are stored in UTC
+		*p.t = t.UTC()
+	}
+	q.Search = values.Get("q")
+	if len(q.Search) > maxSearchLength {
+		return nil, errors.New("q must be at most " + strconv.Itoa(maxSearchLength) + " bytes long")
+	}
+	return q, nil
+}
+
+// exportQuery parses query parameters of an export request: the format,
+// whether click aggregates are exported, and the order and filters of the
+// history. Exports are CSV files without click aggregates by default.
+func exportQuery(values url.Values) (*domain.HistoryQuery, domain.ExportFormat, bool, error) {
+	if values.Has("cursor") || values.Has("limit") {
+		return nil, "", false, errors.New("exports include every link, cursor and limit aren't supported")
+	}
+	q, err := historyQuery(values)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	format := domain.ExportCSV
+	switch f := domain.ExportFormat(values.Get("format")); f {
+	case "":
+	case domain.ExportCSV, domain.ExportNDJSON, domain.ExportParquet:
+		format = f
+	default:
+		return nil, "", false, errors.New("format must be one of csv, ndjson or parquet")
+	}
+	var clicks bool
+	if c := values.Get("clicks"); c != "" {
+		clicks, err = strconv.ParseBool(c)
+		if err != nil {
+			return nil, "", false, errors.New("clicks must be true or false")
+		}
+	}
+	return q, format, clicks, nil
+}
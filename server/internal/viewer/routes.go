@@ -0,0 +1,37 @@
+package viewer
+
+import (
+	"net/http"
+	"shortener/pkg/middleware"
+
+	"github.com/justinas/alice"
+)
+
+// Mount registers the routes of the service on the mux. Requests are handled
+// with the chain, e.g. middleware.RequestTracing, and allowed from the CORS
+// origin.
+func (v *Viewer) Mount(mux *http.ServeMux, c alice.Chain, cors middleware.Cors) {
+	mux.Handle("OPTIONS /history", cors.Preflight(""))
+	mux.Handle(
+		"GET /history",
+		c.Append(cors.Headers).ThenFunc(v.HandleHistory),
+	)
+	mux.Handle("OPTIONS /export", cors.Preflight(""))
+	mux.Handle(
+		"GET /export",
+		c.Append(cors.Headers).ThenFunc(v.HandleExport),
+	)
+	if v.exports == nil {
+		return
+	}
+	mux.Handle("OPTIONS /exports/{id}", cors.Preflight(""))
+	mux.Handle(
+		"GET /exports/{id}",
+		c.Append(cors.Headers).ThenFunc(v.HandleExportJob),
+	)
+	mux.Handle("OPTIONS /exports/{id}/download", cors.Preflight(""))
+	mux.Handle(
+		"GET /exports/{id}/download",
+		c.Append(cors.Headers).ThenFunc(v.HandleExportDownload),
+	)
+}
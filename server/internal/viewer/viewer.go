@@ -9,6 +9,7 @@ import (
 	"shortener/pkg/responses"
 	"shortener/proto/blackbox"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -16,14 +17,22 @@ import (
 	pbblackbox "shortener/proto/blackbox"
 )
 
+// Urls returns at most Limit links of the history query. Exports count
+// the links of queries and aggregate their clicks, see urls.Model.
 type Urls interface {
-	History(ctx context.Context, userId string) ([]*domain.UrlInfo, error)
+	History(ctx context.Context, q *domain.HistoryQuery) ([]*domain.UrlInfo, error)
+	CountHistory(ctx context.Context, q *domain.HistoryQuery) (int64, error)
+	ClickStats(ctx context.Context, shortUrls []string) (map[string]*domain.ClickStats, error)
 }
 
 type Viewer struct {
 	redirectorHost string
 	urls           Urls
 	blackboxClient pbblackbox.BlackboxServiceClient
+
+	// exports of more links than syncLimit run as jobs if set
+	exports   Exports
+	syncLimit int64
 }
 
 type viewerOption func(*Viewer) error
@@ -73,6 +82,68 @@ func (v *Viewer) HandleHistory(w http.ResponseWriter, r *http.Request) {
 	log := hlog.FromRequest(r)
 
 	log.Info().Msg("got new history request")
+	tokenInfo, ok := v.authenticate(w, r, log)
+	if !ok {
+		return
+	}
+
+	q, err := historyQuery(r.URL.Query())
+	if err != nil {
+		log.Info().Err(err).Msg("invalid history query")
+		res, _ := json.Marshal(&responses.Server{
+			Message: err.Error(),
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(res)
+		return
+	}
+	q.UserId = tokenInfo.GetUserId()
+	q.WorkspaceId = tokenInfo.GetWorkspaceId()
+
+	log.Info().Msg("getting shortening history")
+	// the extra link tells whether there is a next page
+	limit := q.Limit
+	q.Limit++
+	history, err := v.urls.History(r.Context(), q)
+	if err != nil {
+		log.Error().Err(err).Msg("couldn't get history")
+
+		res, _ := json.Marshal(&responses.Server{
+			Message: "couldn't get history",
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(res)
+		return
+	}
+
+	page := responses.History{Links: history}
+	if len(history) > limit {
+		page.Links = history[:limit]
+		page.NextCursor = encodeCursor(&cursor{
+			Sort:  q.Sort,
+			Desc:  q.Desc,
+			After: history[limit-1].Cursor(q.Sort),
+		})
+	}
+	if page.Links == nil {
+		page.Links = []*domain.UrlInfo{}
+	}
+	for _, l := range page.Links {
+		l.ShortUrl = v.redirectorHost + "/" + l.ShortUrl
+	}
+
+	res, _ := json.Marshal(&page)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// authenticate validates the JWT cookie of the request and checks that the
+// session may view links of its workspace. Errors are written to the client.
+func (v *Viewer) authenticate(
+	w http.ResponseWriter,
+	r *http.Request,
+	log *zerolog.Logger,
+) (*blackbox.ValidateTokenRsp, bool) {
 	JWTCookie, err := r.Cookie("JWT")
 	gotJWT := true
 	if err != nil {
@@ -88,18 +159,18 @@ func (v *Viewer) HandleHistory(w http.ResponseWriter, r *http.Request) {
 			})
 			w.WriteHeader(http.StatusUnprocessableEntity)
 			w.Write(res)
-			return
+			return nil, false
 		}
 	}
 
 	if !gotJWT {
-		log.Info().Msg("unauthenticated user tried to get shortening history")
+		log.Info().Msg("unauthenticated user tried to view links")
 		res, _ := json.Marshal(&responses.Server{
 			Message: "no JWT cookie provided",
 		})
 		w.WriteHeader(http.StatusPreconditionFailed)
 		w.Write(res)
-		return
+		return nil, false
 	}
 
 	log.Info().Msg("validating JWT")
@@ -118,7 +189,7 @@ func (v *Viewer) HandleHistory(w http.ResponseWriter, r *http.Request) {
 			})
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write(res)
-			return
+			return nil, false
 		}
 
 		switch s.Code() {
@@ -143,27 +214,23 @@ func (v *Viewer) HandleHistory(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write(res)
 		}
-		return
+		return nil, false
 	}
 
-	log.Info().Msg("getting shortening history")
-	history, err := v.urls.History(context.TODO(), tokenInfo.GetUserId())
-	if err != nil {
-		log.Error().Err(err).Msg("couldn't get history")
+	workspaceId := tokenInfo.GetWorkspaceId()
+	if workspaceId != "" &&
+		!domain.Role(tokenInfo.GetRole()).Allows(domain.RoleViewer) {
+		log.Info().
+			Str("workspace_id", workspaceId).
+			Str("role", tokenInfo.GetRole()).
+			Msg("role doesn't allow viewing workspace links")
 
 		res, _ := json.Marshal(&responses.Server{
-			Message: "couldn't get history",
+			Message: "insufficient role in workspace",
 		})
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusForbidden)
 		w.Write(res)
-		return
+		return nil, false
 	}
-
-	for i := range history {
-		history[i].ShortUrl = v.redirectorHost + "/" + history[i].ShortUrl
-	}
-
-	res, _ := json.Marshal(&history)
-	w.WriteHeader(http.StatusOK)
-	w.Write(res)
+	return tokenInfo, true
 }
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"shortener/pkg/domain"
+	"shortener/pkg/responses"
 	"shortener/proto/blackbox"
 	"testing"
 	"time"
@@ -79,7 +81,13 @@ func TestViewerNoCookie(t *testing.T) {
 func TestViewerSuccess(t *testing.T) {
 	u := NewMockUrls(t)
 	exDate := time.Now()
-	u.EXPECT().History(context.TODO(), "id").Return([]*domain.UrlInfo{
+	u.EXPECT().History(mock.Anything, &domain.HistoryQuery{
+		UserId: "id",
+		Sort:   domain.SortCreated,
+		Desc:   true,
+		Limit:  defaultHistoryLimit + 1,
+		Status: domain.StatusActive,
+	}).Return([]*domain.UrlInfo{
 		{
 			ShortUrl:       "short",
 			LongUrl:        "long",
@@ -120,10 +128,186 @@ func TestViewerSuccess(t *testing.T) {
 	rsp := recorder.Result()
 	assert.Equal(t, http.StatusOK, rsp.StatusCode)
 
-	var res []*domain.UrlInfo
+	var res responses.History
 
 	err = json.NewDecoder(rsp.Body).Decode(&res)
 	assert.Nil(t, err)
 
-	assert.Equal(t, len(res), 1)
+	assert.Equal(t, len(res.Links), 1)
+	assert.Equal(t, "host/short", res.Links[0].ShortUrl)
+	assert.Empty(t, res.NextCursor)
+}
+
+func TestViewerWorkspaceHistory(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().
+		History(mock.Anything, mock.MatchedBy(func(q *domain.HistoryQuery) bool {
+			return q.UserId == "id" && q.WorkspaceId == "workspace"
+		})).
+		Return([]*domain.UrlInfo{{ShortUrl: "short", LongUrl: "long"}}, nil)
+
+	c := pbblackbox_mock.NewMockBlackboxServiceClient(t)
+	c.EXPECT().
+		ValidateToken(context.TODO(), mock.AnythingOfType("*blackbox.ValidateTokenReq")).
+		Return(&blackbox.ValidateTokenRsp{
+			UserId:      "id",
+			WorkspaceId: "workspace",
+			Role:        string(domain.RoleViewer),
+		}, nil)
+	v, err := New(
+		WithUrls(u),
+		WithBlackboxClient(c),
+		WithRedirectorHost("host"),
+	)
+	assert.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "/", nil)
+	assert.Nil(t, err)
+	r.AddCookie(&http.Cookie{Name: "JWT", Value: "token"})
+
+	v.HandleHistory(recorder, r)
+	assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+}
+
+func TestViewerWorkspaceUnknownRole(t *testing.T) {
+	c := pbblackbox_mock.NewMockBlackboxServiceClient(t)
+	c.EXPECT().
+		ValidateToken(context.TODO(), mock.AnythingOfType("*blackbox.ValidateTokenReq")).
+		Return(&blackbox.ValidateTokenRsp{
+			UserId:      "id",
+			WorkspaceId: "workspace",
+			Role:        "guest",
+		}, nil)
+	v, err := New(
+		WithUrls(NewMockUrls(t)),
+		WithBlackboxClient(c),
+		WithRedirectorHost("host"),
+	)
+	assert.Nil(t, err)
+
+	recorder := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "/", nil)
+	assert.Nil(t, err)
+	r.AddCookie(&http.Cookie{Name: "JWT", Value: "token"})
+
+	v.HandleHistory(recorder, r)
+	assert.Equal(t, http.StatusForbidden, recorder.Result().StatusCode)
+}
+
+func TestViewerHistoryPages(t *testing.T) {
+	created := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	u := NewMockUrls(t)
+	u.EXPECT().
+		History(mock.Anything, mock.MatchedBy(func(q *domain.HistoryQuery) bool {
+			return q.After == nil
+		})).
+		RunAndReturn(func(_ context.Context, q *domain.HistoryQuery) ([]*domain.UrlInfo, error) {
+			assert.Equal(t, domain.SortClicks, q.Sort)
+			assert.False(t, q.Desc)
+			assert.Equal(t, 3, q.Limit)
+			assert.Equal(t, domain.StatusExpired, q.Status)
+			assert.Equal(t, "docs", q.Tag)
+			assert.Equal(t, "example.com", q.Domain)
+			assert.Equal(t, "release notes", q.Search)
+			assert.Equal(t, created, q.CreatedAfter)
+			return []*domain.UrlInfo{
+				{ShortUrl: "aaaaa", Clicks: 1},
+				{ShortUrl: "bbbbb", Clicks: 2},
+				{ShortUrl: "ccccc", Clicks: 2},
+			}, nil
+		}).
+		Once()
+	u.EXPECT().
+		History(mock.Anything, mock.MatchedBy(func(q *domain.HistoryQuery) bool {
+			return q.After != nil
+		})).
+		RunAndReturn(func(_ context.Context, q *domain.HistoryQuery) ([]*domain.UrlInfo, error) {
+			assert.Equal(t, &domain.HistoryCursor{Clicks: 2, ShortUrl: "bbbbb"}, q.After)
+			return []*domain.UrlInfo{{ShortUrl: "ccccc", Clicks: 2}}, nil
+		}).
+		Once()
+
+	c := pbblackbox_mock.NewMockBlackboxServiceClient(t)
+	c.EXPECT().
+		ValidateToken(context.TODO(), mock.AnythingOfType("*blackbox.ValidateTokenReq")).
+		Return(&blackbox.ValidateTokenRsp{UserId: "id"}, nil)
+	v, err := New(
+		WithUrls(u),
+		WithBlackboxClient(c),
+		WithRedirectorHost("host"),
+	)
+	assert.Nil(t, err)
+
+	get := func(query url.Values) *responses.History {
+		recorder := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/history?"+query.Encode(), nil)
+		assert.Nil(t, err)
+		r.AddCookie(&http.Cookie{Name: "JWT", Value: "token"})
+
+		v.HandleHistory(recorder, r)
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+		var res responses.History
+		assert.Nil(t, json.NewDecoder(recorder.Result().Body).Decode(&res))
+		return &res
+	}
+
+	query := url.Values{
+		"sort":          {"clicks"},
+		"order":         {"asc"},
+		"limit":         {"2"},
+		"status":        {"expired"},
+		"tag":           {"docs"},
+		"domain":        {"example.com"},
+		"q":             {"release notes"},
+		"created_after": {"2024-05-01T03:00:00+03:00"},
+	}
+	first := get(query)
+	assert.Len(t, first.Links, 2)
+	assert.NotEmpty(t, first.NextCursor)
+
+	query.Set("cursor", first.NextCursor)
+	second := get(query)
+	assert.Len(t, second.Links, 1)
+	assert.Empty(t, second.NextCursor)
+}
+
+func TestViewerInvalidHistoryQuery(t *testing.T) {
+	sorted := encodeCursor(&cursor{
+		Sort:  domain.SortCreated,
+		Desc:  true,
+		After: &domain.HistoryCursor{ShortUrl: "aaaaa"},
+	})
+	for _, query := range []string{
+		"sort=title",
+		"order=up",
+		"limit=0",
+		"limit=201",
+		"status=archived",
+		"tag=Not%20a%20tag",
+		"created_before=yesterday",
+		"cursor=garbage",
+		"sort=clicks&cursor=" + sorted,
+	} {
+		t.Run(query, func(t *testing.T) {
+			c := pbblackbox_mock.NewMockBlackboxServiceClient(t)
+			c.EXPECT().
+				ValidateToken(context.TODO(), mock.AnythingOfType("*blackbox.ValidateTokenReq")).
+				Return(&blackbox.ValidateTokenRsp{UserId: "id"}, nil)
+			v, err := New(
+				WithUrls(NewMockUrls(t)),
+				WithBlackboxClient(c),
+				WithRedirectorHost("host"),
+			)
+			assert.Nil(t, err)
+
+			recorder := httptest.NewRecorder()
+			r, err := http.NewRequest("GET", "/history?"+query, nil)
+			assert.Nil(t, err)
+			r.AddCookie(&http.Cookie{Name: "JWT", Value: "token"})
+
+			v.HandleHistory(recorder, r)
+			assert.Equal(t, http.StatusBadRequest, recorder.Result().StatusCode)
+		})
+	}
 }
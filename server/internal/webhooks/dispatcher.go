@@ -0,0 +1,352 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"shortener/pkg/domain"
+	"shortener/pkg/egress"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+)
+
+var (
+	attempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhooks_delivery_attempts_total",
+		Help: "Attempts to post deliveries by result: delivered, failed or dead.",
+	}, []string{"result"})
+	attemptDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "webhooks_delivery_attempt_duration_seconds",
+		Help:    "Time taken by webhooks to respond.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+// Headers of deliveries. Receivers verify the signature with Verify.
+const (
+	HeaderDeliveryId = "X-Webhook-Id"
+	HeaderEvent      = "X-Webhook-Event"
+	HeaderTimestamp  = "X-Webhook-Timestamp"
+	HeaderSignature  = "X-Webhook-Signature"
+)
+
+// Sign returns the signature of the payload sent at the unix timestamp:
+// "sha256=" and hex of HMAC-SHA256 of "<timestamp>.<payload>" keyed with
+// the secret of the subscription
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether the signature of the payload is valid
+func Verify(secret string, timestamp int64, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, payload)), []byte(signature))
+}
+
+// Deliveries leases due deliveries and records their attempts, see
+// webhooks.Model
+type Deliveries interface {
+	Due(ctx context.Context, lease time.Duration, limit int) ([]*domain.WebhookDelivery, error)
+	Record(ctx context.Context, d *domain.WebhookDelivery, retryIn time.Duration) error
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+// RetryPolicy limits attempts of a delivery. Attempts are delayed
+// exponentially, the delivery is dead once they run out.
+type RetryPolicy struct {
+	// total number of attempts of a delivery
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:  8,
+	BaseDelay: 10 * time.Second,
+	MaxDelay:  time.Hour,
+}
+
+// delay before the attempt following the given one
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d > p.MaxDelay || d <= 0 {
+		return p.MaxDelay
+	}
+	return d
+}
+
+type Dispatcher struct {
+	deliveries Deliveries
+	// connects to public addresses only, see egress.NewClient
+	client *http.Client
+
+	retry       RetryPolicy
+	batchSize   int
+	concurrency int
+	// how often deliveries are polled for when none are due
+	pollInterval time.Duration
+	// how long delivered and dead deliveries are kept in the log
+	retention time.Duration
+
+	log *zerolog.Logger
+	now func() time.Time
+}
+
+const (
+	DefaultBatchSize    = 100
+	DefaultConcurrency  = 8
+	DefaultPollInterval = time.Second
+	DefaultTimeout      = 10 * time.Second
+	DefaultRetention    = 7 * 24 * time.Hour
+)
+
+type dispatcherOption func(d *Dispatcher) error
+
+func WithDeliveries(dd Deliveries) dispatcherOption {
+	return func(d *Dispatcher) error {
+		d.deliveries = dd
+		return nil
+	}
+}
+
+// WithTimeout limits how long a webhook may take to respond
+func WithTimeout(t time.Duration) dispatcherOption {
+	return func(d *Dispatcher) error {
+		if t <= 0 {
+			return errors.New("timeout must be positive")
+		}
+		d.client.Timeout = t
+		return nil
+	}
+}
+
+func WithRetryPolicy(p RetryPolicy) dispatcherOption {
+	return func(d *Dispatcher) error {
+		if p.Attempts < 1 || p.BaseDelay <= 0 || p.MaxDelay < p.BaseDelay {
+			return errors.New("invalid retry policy")
+		}
+		d.retry = p
+		return nil
+	}
+}
+
+// WithBatchSize limits deliveries leased at once
+func WithBatchSize(n int) dispatcherOption {
+	return func(d *Dispatcher) error {
+		if n < 1 {
+			return errors.New("batch size must be positive")
+		}
+		d.batchSize = n
+		return nil
+	}
+}
+
+// WithConcurrency limits deliveries posted at once
+func WithConcurrency(n int) dispatcherOption {
+	return func(d *Dispatcher) error {
+		if n < 1 {
+			return errors.New("concurrency must be positive")
+		}
+		d.concurrency = n
+		return nil
+	}
+}
+
+func WithPollInterval(i time.Duration) dispatcherOption {
+	return func(d *Dispatcher) error {
+		if i <= 0 {
+			return errors.New("poll interval must be positive")
+		}
+		d.pollInterval = i
+		return nil
+	}
+}
+
+// WithRetention sets how long delivered and dead deliveries are kept in
+// the log
+func WithRetention(r time.Duration) dispatcherOption {
+	return func(d *Dispatcher) error {
+		if r <= 0 {
+			return errors.New("retention must be positive")
+		}
+		d.retention = r
+		return nil
+	}
+}
+
+func WithDispatcherLogger(l *zerolog.Logger) dispatcherOption {
+	return func(d *Dispatcher) error {
+		d.log = l
+		return nil
+	}
+}
+
+func NewDispatcher(opts ...dispatcherOption) (*Dispatcher, error) {
+	d := &Dispatcher{
+		client:       egress.NewClient(DefaultTimeout),
+		retry:        DefaultRetryPolicy,
+		batchSize:    DefaultBatchSize,
+		concurrency:  DefaultConcurrency,
+		pollInterval: DefaultPollInterval,
+		retention:    DefaultRetention,
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, err
+		}
+	}
+	if d.deliveries == nil {
+		return nil, errors.New("no deliveries model provided")
+	}
+	if d.log == nil {
+		return nil, errors.New("no logger provided")
+	}
+	return d, nil
+}
+
+// Run posts due deliveries until the context is cancelled. Deliveries being
+// posted then are finished.
+func (d *Dispatcher) Run(ctx context.Context) {
+	pruned := time.Time{}
+	for {
+		if d.now().Sub(pruned) >= time.Hour {
+			n, err := d.deliveries.Prune(ctx, d.now().Add(-d.retention))
+			if err == nil {
+				pruned = d.now()
+				d.log.Info().Int64("pruned", n).Msg("pruned delivery log")
+			} else if ctx.Err() == nil {
+				d.log.Error().Err(err).Msg("couldn't prune delivery log")
+			}
+		}
+
+		n, err := d.Pass(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.log.Error().Err(err).Msg("couldn't dispatch deliveries")
+		}
+		// a full batch means more deliveries may be due right away
+		if err == nil && n == d.batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.pollInterval):
+		}
+	}
+}
+
+// Pass posts a batch of due deliveries and returns its size
+func (d *Dispatcher) Pass(ctx context.Context) (int, error) {
+	// a leased delivery isn't due again before its attempt is over
+	lease := 2*d.client.Timeout + time.Minute
+	due, err := d.deliveries.Due(ctx, lease, d.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	// attempts aren't cut short on shutdown, they are bounded by the timeout
+	ctx = context.WithoutCancel(ctx)
+	sem := make(chan struct{}, d.concurrency)
+	var wg sync.WaitGroup
+	for _, delivery := range due {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			d.attempt(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+	return len(due), nil
+}
+
+// attempt posts the delivery and records the outcome
+func (d *Dispatcher) attempt(ctx context.Context, delivery *domain.WebhookDelivery) {
+	log := d.log.With().
+		Str("delivery_id", delivery.Id).
+		Str("subscription_id", delivery.SubscriptionId).
+		Str("event_type", string(delivery.EventType)).
+		Logger()
+
+	start := time.Now()
+	status, err := d.post(ctx, delivery)
+	attemptDuration.Observe(time.Since(start).Seconds())
+
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	delivery.LastError = ""
+	var retryIn time.Duration
+	switch {
+	case err == nil:
+		delivery.Status = domain.DeliveryDelivered
+		attempts.WithLabelValues("delivered").Inc()
+		log.Info().Int("status", status).Msg("delivered event")
+	case delivery.Attempts >= d.retry.Attempts:
+		delivery.Status = domain.DeliveryDead
+		delivery.LastError = err.Error()
+		attempts.WithLabelValues("dead").Inc()
+		log.Warn().Err(err).Int("attempts", delivery.Attempts).Msg("gave up on delivery")
+	default:
+		delivery.Status = domain.DeliveryPending
+		delivery.LastError = err.Error()
+		retryIn = d.retry.delay(delivery.Attempts)
+		attempts.WithLabelValues("failed").Inc()
+		log.Info().Err(err).Dur("retry_in", retryIn).Msg("couldn't deliver event")
+	}
+
+	if err := d.deliveries.Record(ctx, delivery, retryIn); err != nil {
+		// the delivery is attempted again once its lease is over
+		log.Error().Err(err).Msg("couldn't record delivery attempt")
+	}
+}
+
+// post sends the payload signed with the secret of the subscription. It
+// returns the response status, if there has been a response.
+func (d *Dispatcher) post(ctx context.Context, delivery *domain.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		delivery.Url,
+		bytes.NewReader(delivery.Payload),
+	)
+	if err != nil {
+		return 0, err
+	}
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDeliveryId, delivery.Id)
+	req.Header.Set(HeaderEvent, string(delivery.EventType))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	rsp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+	// lets the connection be reused
+	io.Copy(io.Discard, io.LimitReader(rsp.Body, 1<<16))
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return rsp.StatusCode, fmt.Errorf("webhook responded with %s", rsp.Status)
+	}
+	return rsp.StatusCode, nil
+}
@@ -0,0 +1,211 @@
+// Package webhooks posts events of links to webhooks of their owners. The
+// Consumer turns events of the urls and the link events topics into
+// deliveries, and the Dispatcher posts them, so that slow webhooks hold up
+// neither the bus nor the services that publish the events.
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"shortener/pkg/bus"
+	"shortener/pkg/domain"
+	"shortener/pkg/events"
+	"shortener/pkg/models/webhooks"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+)
+
+var (
+	consumedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhooks_consumed_events_total",
+		Help: "Events of links consumed by type and result: enqueued, unsubscribed or skipped.",
+	}, []string{"type", "result"})
+	enqueuedDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhooks_enqueued_deliveries_total",
+		Help: "Deliveries enqueued for subscriptions by event type.",
+	}, []string{"type"})
+)
+
+// Subscriptions finds subscriptions to events and stores their deliveries,
+// see webhooks.Model
+type Subscriptions interface {
+	Link(ctx context.Context, shortUrl string) (*domain.Link, error)
+	Matching(
+		ctx context.Context,
+		userId string,
+		workspaceId string,
+		t domain.EventType,
+	) ([]*domain.WebhookSubscription, error)
+	Enqueue(ctx context.Context, dd []*domain.WebhookDelivery) error
+}
+
+type Consumer struct {
+	ctx           context.Context
+	subscriptions Subscriptions
+
+	urlsTopic   string
+	eventsTopic string
+
+	log *zerolog.Logger
+}
+
+type consumerOption func(c *Consumer) error
+
+func WithContext(ctx context.Context) consumerOption {
+	return func(c *Consumer) error {
+		c.ctx = ctx
+		return nil
+	}
+}
+
+func WithSubscriptions(s Subscriptions) consumerOption {
+	return func(c *Consumer) error {
+		c.subscriptions = s
+		return nil
+	}
+}
+
+// WithTopics sets the topics links are created in and other events of them
+// are published to
+func WithTopics(urls string, events string) consumerOption {
+	return func(c *Consumer) error {
+		c.urlsTopic = urls
+		c.eventsTopic = events
+		return nil
+	}
+}
+
+func WithConsumerLogger(l *zerolog.Logger) consumerOption {
+	return func(c *Consumer) error {
+		c.log = l
+		return nil
+	}
+}
+
+func NewConsumer(opts ...consumerOption) (*Consumer, error) {
+	c := &Consumer{ctx: context.Background()}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.subscriptions == nil {
+		return nil, errors.New("no subscriptions model provided")
+	}
+	if c.urlsTopic == "" || c.eventsTopic == "" {
+		return nil, errors.New("no topics provided")
+	}
+	if c.log == nil {
+		return nil, errors.New("no logger provided")
+	}
+	return c, nil
+}
+
+func (c *Consumer) Topics() []string {
+	return []string{c.urlsTopic, c.eventsTopic}
+}
+
+// Consume enqueues deliveries of every event of the claim. Events that
+// can't be decoded or belong to links that are gone are skipped. Other
+// errors end the claim, so that the events are delivered again: deliveries
+// that have already been enqueued aren't duplicated.
+func (c *Consumer) Consume(claim bus.Claim) error {
+	if claim.Topic() != c.urlsTopic && claim.Topic() != c.eventsTopic {
+		return fmt.Errorf("unknown topic: %s", claim.Topic())
+	}
+	log := c.log.With().
+		Str("topic", claim.Topic()).
+		Int32("partition", claim.Partition()).
+		Logger()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case mes, isOpen := <-claim.Messages():
+			if !isOpen {
+				return nil
+			}
+			if err := c.handle(c.ctx, &log, mes); err != nil {
+				return err
+			}
+			claim.Ack(mes)
+		}
+	}
+}
+
+func (c *Consumer) handle(
+	ctx context.Context,
+	log *zerolog.Logger,
+	mes *bus.Message,
+) error {
+	e, err := events.DecodeLinkEvent(mes)
+	if err != nil {
+		log.Error().Err(err).Int64("offset", mes.Offset).Msg("couldn't decode event, skipping")
+		consumedEvents.WithLabelValues("unknown", "skipped").Inc()
+		return nil
+	}
+	if e.Id == "" {
+		// JSON links produced before idempotency keys are told by position
+		e.Id = fmt.Sprintf("%s-%d-%d", mes.Topic, mes.Partition, mes.Offset)
+	}
+
+	if e.UserId == "" {
+		l, err := c.subscriptions.Link(ctx, e.ShortUrl)
+		if errors.Is(err, webhooks.ErrLinkNotFound) {
+			log.Info().Str("short_url", e.ShortUrl).Msg("link of event is gone, skipping")
+			consumedEvents.WithLabelValues(string(e.Type), "skipped").Inc()
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't get link of event: %w", err)
+		}
+		e.LongUrl = l.LongUrl
+		e.UserId = l.UserId
+		e.WorkspaceId = l.WorkspaceId
+		expiration := l.ExpirationDate
+		e.ExpirationDate = &expiration
+	}
+	if e.UserId == domain.AnonymousUserId {
+		consumedEvents.WithLabelValues(string(e.Type), "unsubscribed").Inc()
+		return nil
+	}
+
+	ss, err := c.subscriptions.Matching(ctx, e.UserId, e.WorkspaceId, e.Type)
+	if err != nil {
+		return fmt.Errorf("couldn't find subscriptions: %w", err)
+	}
+	if len(ss) == 0 {
+		consumedEvents.WithLabelValues(string(e.Type), "unsubscribed").Inc()
+		return nil
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	dd := make([]*domain.WebhookDelivery, len(ss))
+	for i, s := range ss {
+		dd[i] = &domain.WebhookDelivery{
+			SubscriptionId: s.Id,
+			EventId:        e.Id,
+			EventType:      e.Type,
+			Payload:        payload,
+		}
+	}
+	if err := c.subscriptions.Enqueue(ctx, dd); err != nil {
+		return fmt.Errorf("couldn't enqueue deliveries: %w", err)
+	}
+	consumedEvents.WithLabelValues(string(e.Type), "enqueued").Inc()
+	enqueuedDeliveries.WithLabelValues(string(e.Type)).Add(float64(len(dd)))
+	log.Debug().
+		Str("event_id", e.Id).
+		Str("type", string(e.Type)).
+		Int("deliveries", len(dd)).
+		Msg("enqueued deliveries")
+	return nil
+}
@@ -0,0 +1,355 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"shortener/pkg/bus"
+	"shortener/pkg/domain"
+	"shortener/pkg/egress"
+	"shortener/pkg/events"
+	"shortener/pkg/models/webhooks"
+	eventsv1 "shortener/proto/events/v1"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+type fakeClaim struct {
+	topic    string
+	messages chan *bus.Message
+	acked    []int64
+}
+
+func (c *fakeClaim) Topic() string {
+	return c.topic
+}
+
+func (c *fakeClaim) Partition() int32 {
+	return 0
+}
+
+func (c *fakeClaim) Messages() <-chan *bus.Message {
+	return c.messages
+}
+
+func (c *fakeClaim) Ack(m *bus.Message) {
+	c.acked = append(c.acked, m.Offset)
+}
+
+func newConsumer(t *testing.T, s Subscriptions) *Consumer {
+	log := zerolog.Nop()
+	c, err := NewConsumer(
+		WithSubscriptions(s),
+		WithTopics("urls", "link-events"),
+		WithConsumerLogger(&log),
+	)
+	assert.Nil(t, err)
+	return c
+}
+
+// consume feeds the messages to the consumer and returns its error
+func consume(c *Consumer, claim *fakeClaim, mm ...*bus.Message) error {
+	claim.messages = make(chan *bus.Message, len(mm))
+	for _, mes := range mm {
+		claim.messages <- mes
+	}
+	close(claim.messages)
+	return c.Consume(claim)
+}
+
+func clicked(t *testing.T, offset int64, code string) *bus.Message {
+	value, ct, err := events.Encode(&eventsv1.LinkClicked{
+		IdempotencyKey: "click-" + code,
+		ShortUrl:       code,
+		ClickedAt:      timestamppb.New(now),
+	})
+	assert.Nil(t, err)
+	return &bus.Message{
+		Topic:   "link-events",
+		Value:   value,
+		Headers: map[string]string{events.HeaderContentType: ct},
+		Offset:  offset,
+	}
+}
+
+func TestConsumeEnqueuesDeliveries(t *testing.T) {
+	s := NewMockSubscriptions(t)
+	s.EXPECT().Link(mock.Anything, "aaaaa").Return(&domain.Link{
+		ShortUrl:       "aaaaa",
+		LongUrl:        "https://example.com",
+		UserId:         "user",
+		WorkspaceId:    "workspace",
+		ExpirationDate: now,
+	}, nil)
+	s.EXPECT().
+		Matching(mock.Anything, "user", "workspace", domain.EventLinkClicked).
+		Return([]*domain.WebhookSubscription{{Id: "first"}, {Id: "second"}}, nil)
+	s.EXPECT().
+		Enqueue(mock.Anything, mock.MatchedBy(func(dd []*domain.WebhookDelivery) bool {
+			if len(dd) != 2 || dd[0].SubscriptionId != "first" || dd[1].SubscriptionId != "second" {
+				return false
+			}
+			var e domain.LinkEvent
+			if err := json.Unmarshal(dd[0].Payload, &e); err != nil {
+				return false
+			}
+			return e.Id == "click-aaaaa" &&
+				dd[0].EventId == "click-aaaaa" &&
+				e.Type == domain.EventLinkClicked &&
+				e.LongUrl == "https://example.com" &&
+				e.OccurredAt.Equal(now)
+		})).
+		Return(nil)
+
+	claim := &fakeClaim{topic: "link-events"}
+	assert.Nil(t, consume(newConsumer(t, s), claim, clicked(t, 7, "aaaaa")))
+	assert.Equal(t, []int64{7}, claim.acked)
+}
+
+func TestConsumeSkipsLinksThatAreGone(t *testing.T) {
+	s := NewMockSubscriptions(t)
+	s.EXPECT().Link(mock.Anything, "aaaaa").Return(nil, webhooks.ErrLinkNotFound)
+	s.EXPECT().Link(mock.Anything, "bbbbb").Return(&domain.Link{
+		UserId: domain.AnonymousUserId,
+	}, nil)
+
+	claim := &fakeClaim{topic: "link-events"}
+	bad := &bus.Message{Topic: "link-events", Value: []byte("{"), Offset: 3}
+	err := consume(newConsumer(t, s), claim, clicked(t, 1, "aaaaa"), clicked(t, 2, "bbbbb"), bad)
+	assert.Nil(t, err)
+	// neither the missing link nor the anonymous one nor the undecodable
+	// event hold up the claim
+	assert.Equal(t, []int64{1, 2, 3}, claim.acked)
+}
+
+func TestConsumeRedeliversOnErrors(t *testing.T) {
+	s := NewMockSubscriptions(t)
+	s.EXPECT().Link(mock.Anything, "aaaaa").Return(&domain.Link{UserId: "user"}, nil)
+	s.EXPECT().
+		Matching(mock.Anything, "user", "", domain.EventLinkClicked).
+		Return(nil, errors.New("db is down"))
+
+	claim := &fakeClaim{topic: "link-events"}
+	err := consume(newConsumer(t, s), claim, clicked(t, 1, "aaaaa"))
+	assert.NotNil(t, err)
+	assert.Empty(t, claim.acked)
+}
+
+func TestConsumeCreatedLinks(t *testing.T) {
+	value, ct, err := events.Encode(&eventsv1.LinkCreated{
+		IdempotencyKey: "key",
+		ShortUrl:       "aaaaa",
+		LongUrl:        "https://example.com",
+		UserId:         "user",
+		ExpirationDate: timestamppb.New(now),
+	})
+	assert.Nil(t, err)
+
+	s := NewMockSubscriptions(t)
+	// the owner is in the event, the link isn't looked up
+	s.EXPECT().
+		Matching(mock.Anything, "user", "", domain.EventLinkCreated).
+		Return(nil, nil)
+
+	claim := &fakeClaim{topic: "urls"}
+	err = consume(newConsumer(t, s), claim, &bus.Message{
+		Topic:   "urls",
+		Value:   value,
+		Headers: map[string]string{events.HeaderContentType: ct},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, claim.acked, 1)
+}
+
+func newDispatcher(t *testing.T, d Deliveries, opts ...dispatcherOption) *Dispatcher {
+	log := zerolog.Nop()
+	dispatcher, err := NewDispatcher(append([]dispatcherOption{
+		WithDeliveries(d),
+		WithDispatcherLogger(&log),
+	}, opts...)...)
+	assert.Nil(t, err)
+	dispatcher.now = func() time.Time { return now }
+	return dispatcher
+}
+
+// reach lets the dispatcher connect to the test server, which listens on
+// the loopback
+func reach(d *Dispatcher, srv *httptest.Server) *Dispatcher {
+	d.client.Transport = srv.Client().Transport
+	return d
+}
+
+func TestPassPostsSignedDeliveries(t *testing.T) {
+	payload := []byte(`{"id":"event"}`)
+	received := make(chan *http.Request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, payload, body)
+		timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		assert.Nil(t, err)
+		assert.True(t, Verify("secret", timestamp, body, r.Header.Get(HeaderSignature)))
+		received <- r
+	}))
+	defer srv.Close()
+
+	delivery := &domain.WebhookDelivery{
+		Id:        "delivery",
+		EventType: domain.EventLinkCreated,
+		Payload:   payload,
+		Url:       srv.URL,
+		Secret:    "secret",
+	}
+	d := NewMockDeliveries(t)
+	d.EXPECT().Due(mock.Anything, mock.Anything, DefaultBatchSize).Return([]*domain.WebhookDelivery{delivery}, nil)
+	d.EXPECT().
+		Record(mock.Anything, mock.MatchedBy(func(d *domain.WebhookDelivery) bool {
+			return d.Status == domain.DeliveryDelivered &&
+				d.Attempts == 1 &&
+				d.ResponseStatus == http.StatusOK
+		}), time.Duration(0)).
+		Return(nil)
+
+	n, err := reach(newDispatcher(t, d), srv).Pass(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	r := <-received
+	assert.Equal(t, "delivery", r.Header.Get(HeaderDeliveryId))
+	assert.Equal(t, "link.created", r.Header.Get(HeaderEvent))
+	assert.Equal(t, strconv.FormatInt(now.Unix(), 10), r.Header.Get(HeaderTimestamp))
+}
+
+func TestPassRetriesWithBackoffAndGivesUp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	retried := &domain.WebhookDelivery{Id: "retried", Url: srv.URL, Attempts: 2}
+	dead := &domain.WebhookDelivery{Id: "dead", Url: srv.URL, Attempts: 3}
+	d := NewMockDeliveries(t)
+	d.EXPECT().Due(mock.Anything, mock.Anything, DefaultBatchSize).
+		Return([]*domain.WebhookDelivery{retried, dead}, nil)
+	d.EXPECT().
+		Record(mock.Anything, mock.MatchedBy(func(d *domain.WebhookDelivery) bool {
+			return d.Id == "retried" &&
+				d.Status == domain.DeliveryPending &&
+				d.Attempts == 3 &&
+				d.ResponseStatus == http.StatusServiceUnavailable &&
+				d.LastError != ""
+		}), 4*time.Second).
+		Return(nil)
+	d.EXPECT().
+		Record(mock.Anything, mock.MatchedBy(func(d *domain.WebhookDelivery) bool {
+			return d.Id == "dead" && d.Status == domain.DeliveryDead && d.Attempts == 4
+		}), time.Duration(0)).
+		Return(nil)
+
+	dispatcher := newDispatcher(t, d, WithRetryPolicy(RetryPolicy{
+		Attempts:  4,
+		BaseDelay: time.Second,
+		MaxDelay:  time.Minute,
+	}))
+	_, err := reach(dispatcher, srv).Pass(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestPassRefusesInternalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("delivery has been posted to the loopback")
+	}))
+	defer srv.Close()
+
+	delivery := &domain.WebhookDelivery{Id: "internal", Url: srv.URL}
+	d := NewMockDeliveries(t)
+	d.EXPECT().Due(mock.Anything, mock.Anything, DefaultBatchSize).
+		Return([]*domain.WebhookDelivery{delivery}, nil)
+	d.EXPECT().
+		Record(mock.Anything, mock.MatchedBy(func(d *domain.WebhookDelivery) bool {
+			return d.Status == domain.DeliveryPending &&
+				d.ResponseStatus == 0 &&
+				strings.Contains(d.LastError, egress.ErrNotPublic.Error())
+		}), mock.Anything).
+		Return(nil)
+
+	_, err := newDispatcher(t, d).Pass(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestPassDoesntFollowRedirects(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("redirect has been followed")
+	}))
+	defer internal.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+
+	delivery := &domain.WebhookDelivery{Id: "redirected", Url: srv.URL}
+	d := NewMockDeliveries(t)
+	d.EXPECT().Due(mock.Anything, mock.Anything, DefaultBatchSize).
+		Return([]*domain.WebhookDelivery{delivery}, nil)
+	d.EXPECT().
+		Record(mock.Anything, mock.MatchedBy(func(d *domain.WebhookDelivery) bool {
+			return d.Status == domain.DeliveryPending &&
+				d.ResponseStatus == http.StatusTemporaryRedirect
+		}), mock.Anything).
+		Return(nil)
+
+	_, err := reach(newDispatcher(t, d), srv).Pass(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestRunDoesntLogPruningOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d := NewMockDeliveries(t)
+	d.EXPECT().Prune(mock.Anything, now.Add(-DefaultRetention)).Return(0, context.Canceled)
+	d.EXPECT().Due(mock.Anything, mock.Anything, DefaultBatchSize).Return(nil, context.Canceled)
+
+	var logs bytes.Buffer
+	log := zerolog.New(&logs)
+	dispatcher := newDispatcher(t, d, WithDispatcherLogger(&log))
+	dispatcher.Run(ctx)
+	assert.Empty(t, logs.String())
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{Attempts: 100, BaseDelay: time.Second, MaxDelay: time.Minute}
+	assert.Equal(t, time.Second, p.delay(1))
+	assert.Equal(t, 8*time.Second, p.delay(4))
+	assert.Equal(t, time.Minute, p.delay(7))
+	// doesn't overflow
+	assert.Equal(t, time.Minute, p.delay(80))
+}
+
+func TestNewDispatcherValidatesOptions(t *testing.T) {
+	log := zerolog.Nop()
+	_, err := NewDispatcher(WithDispatcherLogger(&log))
+	assert.NotNil(t, err)
+
+	d := NewMockDeliveries(t)
+	_, err = NewDispatcher(WithDeliveries(d), WithDispatcherLogger(&log), WithRetryPolicy(RetryPolicy{
+		Attempts:  1,
+		BaseDelay: time.Minute,
+		MaxDelay:  time.Second,
+	}))
+	assert.NotNil(t, err)
+
+	_, err = NewConsumer(WithSubscriptions(NewMockSubscriptions(t)), WithConsumerLogger(&log))
+	assert.NotNil(t, err)
+}
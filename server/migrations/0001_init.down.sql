@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS Outbox;
+DROP TABLE IF EXISTS Identities;
+DROP TABLE IF EXISTS RecoveryCodes;
+DROP TABLE IF EXISTS AuditLog;
+DROP TABLE IF EXISTS PasswordResets;
+DROP TABLE IF EXISTS Urls;
+DROP TABLE IF EXISTS WorkspaceInvites;
+DROP TABLE IF EXISTS WorkspaceMembers;
+DROP TABLE IF EXISTS Workspaces;
+DROP TABLE IF EXISTS Users;
@@ -0,0 +1,148 @@
+-- the schema that used to be created by init.sql. Statements are idempotent,
+-- so that databases initialized with any version of it are migrated too:
+-- tables that already exist get the columns added to them since the first
+-- version
+CREATE TABLE IF NOT EXISTS Users (
+    Id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    Name VarChar(300) NOT NULL,
+    Email VarChar(80)  NOT NULL,
+    HashedPassword CHAR(60) NOT NULL,
+    TotpSecret VarChar(64),
+    TotpEnabled Boolean NOT NULL DEFAULT false,
+    -- the last accepted TOTP time step. Used to reject replayed codes
+    TotpLastStep Bigint NOT NULL DEFAULT 0,
+    -- id of the registration message the user has been stored from. Tells
+    -- redelivered messages from genuine conflicts
+    IdempotencyKey uuid UNIQUE
+)
+;
+
+ALTER TABLE Users
+    ADD COLUMN IF NOT EXISTS TotpSecret VarChar(64),
+    ADD COLUMN IF NOT EXISTS TotpEnabled Boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS TotpLastStep Bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS IdempotencyKey uuid UNIQUE
+;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_unique_emails ON Users(Email) INCLUDE(HashedPassword)
+;
+
+--- this uuid is reserved for anonymous users
+INSERT INTO Users(Id, Name, Email, HashedPassword) 
+    VALUES ('db092ed4-306a-4d4f-be5f-fd2f1487edbe', 'dummy value', 'dumy value', 'dummy value')
+    ON CONFLICT DO NOTHING
+;
+
+CREATE TABLE IF NOT EXISTS Workspaces (
+    Id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    Name VarChar(300) NOT NULL,
+    CreatedAt Timestamp NOT NULL DEFAULT now()
+)
+;
+
+CREATE TABLE IF NOT EXISTS WorkspaceMembers (
+    WorkspaceId uuid NOT NULL references Workspaces(Id) ON DELETE CASCADE,
+    UserId uuid NOT NULL references Users(Id) ON DELETE CASCADE,
+    -- owner, admin, editor or viewer
+    Role VarChar(16) NOT NULL,
+    JoinedAt Timestamp NOT NULL DEFAULT now(),
+    PRIMARY KEY (WorkspaceId, UserId)
+)
+;
+
+CREATE INDEX IF NOT EXISTS workspace_members_user_ids ON WorkspaceMembers(UserId)
+;
+
+CREATE TABLE IF NOT EXISTS WorkspaceInvites (
+    TokenHash CHAR(64) PRIMARY KEY,
+    WorkspaceId uuid NOT NULL references Workspaces(Id) ON DELETE CASCADE,
+    Email VarChar(80) NOT NULL,
+    Role VarChar(16) NOT NULL,
+    InvitedBy uuid NOT NULL references Users(Id) ON DELETE CASCADE,
+    ExpiresAt Timestamp NOT NULL,
+    AcceptedAt Timestamp
+)
+;
+
+CREATE TABLE IF NOT EXISTS Urls (
+	ShortUrl VarChar(5) PRIMARY KEY,
+	LongUrl VarChar(300) NOT NULL,
+	-- the user that has created the link
+	UserId uuid NOT NULL references Users(Id),
+	-- links created in a workspace belong to it rather than to the user
+	WorkspaceId uuid references Workspaces(Id),
+	ExpirationDate Timestamp NOT NULL DEFAULT now() + interval '30' day,
+	-- id of the message the link has been stored from. Tells redelivered
+	-- messages from genuine conflicts
+	IdempotencyKey uuid UNIQUE
+)
+;
+
+ALTER TABLE Urls
+    ADD COLUMN IF NOT EXISTS WorkspaceId uuid references Workspaces(Id),
+    ADD COLUMN IF NOT EXISTS IdempotencyKey uuid UNIQUE
+;
+
+CREATE INDEX IF NOT EXISTS urls_workspace_ids ON Urls(WorkspaceId)
+;
+
+CREATE TABLE IF NOT EXISTS PasswordResets (
+    TokenHash CHAR(64) PRIMARY KEY,
+    UserId uuid NOT NULL references Users(Id) ON DELETE CASCADE,
+    ExpiresAt Timestamp NOT NULL,
+    UsedAt Timestamp
+)
+;
+
+CREATE INDEX IF NOT EXISTS password_resets_user_ids ON PasswordResets(UserId)
+;
+
+CREATE TABLE IF NOT EXISTS AuditLog (
+    Id bigserial PRIMARY KEY,
+    Event VarChar(64) NOT NULL,
+    Email VarChar(80) NOT NULL,
+    Ip VarChar(45) NOT NULL,
+    Details Text NOT NULL DEFAULT '',
+    CreatedAt Timestamp NOT NULL DEFAULT now()
+)
+;
+
+CREATE TABLE IF NOT EXISTS RecoveryCodes (
+    UserId uuid NOT NULL references Users(Id) ON DELETE CASCADE,
+    CodeHash CHAR(64) NOT NULL,
+    UsedAt Timestamp,
+    PRIMARY KEY (UserId, CodeHash)
+)
+;
+
+CREATE TABLE IF NOT EXISTS Identities (
+    Provider VarChar(64) NOT NULL,
+    -- the "sub" claim of the provider's ID token
+    Subject VarChar(255) NOT NULL,
+    UserId uuid NOT NULL references Users(Id) ON DELETE CASCADE,
+    CreatedAt Timestamp NOT NULL DEFAULT now(),
+    PRIMARY KEY (Provider, Subject)
+)
+;
+
+CREATE INDEX IF NOT EXISTS identities_user_id ON Identities(UserId)
+;
+
+-- events of the shortener and authenticator services waiting for publishing
+-- to Kafka, see OUTBOX_ENABLED
+CREATE TABLE IF NOT EXISTS Outbox (
+    Id Bigserial PRIMARY KEY,
+    Topic VarChar(255) NOT NULL,
+    Key Bytea,
+    Payload Bytea NOT NULL,
+    Headers Jsonb,
+    CreatedAt Timestamp NOT NULL DEFAULT now(),
+    Attempts Int NOT NULL DEFAULT 0,
+    LastError Text,
+    NextAttemptAt Timestamp NOT NULL DEFAULT now(),
+    DeliveredAt Timestamp
+)
+;
+
+CREATE INDEX IF NOT EXISTS outbox_pending ON Outbox(NextAttemptAt) WHERE DeliveredAt IS NULL
+;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS urls_expiration_dates;
+DROP TABLE IF EXISTS UrlsArchive;
@@ -0,0 +1,25 @@
+-- links moved out of Urls after their expiration, see cmd/reaper
+CREATE TABLE UrlsArchive (
+    Id Bigserial PRIMARY KEY,
+    ShortUrl VarChar(5) NOT NULL,
+    LongUrl VarChar(300) NOT NULL,
+    UserId uuid NOT NULL,
+    WorkspaceId uuid,
+    ExpirationDate Timestamp NOT NULL,
+    ArchivedAt Timestamp NOT NULL DEFAULT now(),
+    -- the short url may be given to a new link since then
+    ReleasedAt Timestamp
+)
+;
+
+-- short urls of archived links that can't be reused yet
+CREATE UNIQUE INDEX urls_archive_held_short_urls ON UrlsArchive(ShortUrl)
+    WHERE ReleasedAt IS NULL
+;
+
+CREATE INDEX urls_archive_archived_at ON UrlsArchive(ArchivedAt)
+    WHERE ReleasedAt IS NULL
+;
+
+CREATE INDEX urls_expiration_dates ON Urls(ExpirationDate)
+;
@@ -0,0 +1,3 @@
+ALTER TABLE Urls DROP COLUMN IF EXISTS AutoRenew;
+ALTER TABLE Workspaces DROP COLUMN IF EXISTS Plan;
+ALTER TABLE Users DROP COLUMN IF EXISTS Plan;
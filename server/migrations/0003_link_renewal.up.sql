@@ -0,0 +1,10 @@
+-- plans limit how long links may live, see RENEWAL_* settings
+ALTER TABLE Users ADD COLUMN Plan VarChar(16) NOT NULL DEFAULT 'free'
+;
+
+ALTER TABLE Workspaces ADD COLUMN Plan VarChar(16) NOT NULL DEFAULT 'free'
+;
+
+-- the link is renewed when it's clicked shortly before expiring
+ALTER TABLE Urls ADD COLUMN AutoRenew Boolean NOT NULL DEFAULT false
+;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ExpiryReminders;
+DROP TABLE IF EXISTS NotificationPreferences;
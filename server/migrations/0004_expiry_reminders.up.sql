@@ -0,0 +1,26 @@
+-- users without a row are emailed at the default days, see REMINDERS_DAYS
+CREATE TABLE NotificationPreferences (
+    UserId uuid PRIMARY KEY references Users(Id) ON DELETE CASCADE,
+    Enabled Boolean NOT NULL DEFAULT true,
+    -- email or webhook
+    Channel VarChar(16) NOT NULL DEFAULT 'email',
+    WebhookUrl VarChar(300),
+    -- days before expiration links are reminded about at
+    ReminderDays Int[] NOT NULL,
+    UpdatedAt Timestamp NOT NULL DEFAULT now()
+)
+;
+
+-- reminders that have been sent. A renewed link has another expiration
+-- date, so it's reminded about again
+CREATE TABLE ExpiryReminders (
+    ShortUrl VarChar(5) NOT NULL,
+    ExpirationDate Timestamp NOT NULL,
+    Days Int NOT NULL,
+    SentAt Timestamp NOT NULL DEFAULT now(),
+    PRIMARY KEY (ShortUrl, ExpirationDate, Days)
+)
+;
+
+CREATE INDEX expiry_reminders_expiration_dates ON ExpiryReminders(ExpirationDate)
+;
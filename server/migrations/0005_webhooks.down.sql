@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS WebhookDeliveries;
+DROP TABLE IF EXISTS WebhookSubscriptions;
@@ -0,0 +1,49 @@
+-- webhooks of users and workspaces, see cmd/webhooks. Subscriptions of a
+-- workspace have WorkspaceId set, UserId is the user that has created them
+CREATE TABLE WebhookSubscriptions (
+    Id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    UserId uuid NOT NULL references Users(Id) ON DELETE CASCADE,
+    WorkspaceId uuid references Workspaces(Id) ON DELETE CASCADE,
+    Url VarChar(300) NOT NULL,
+    -- signs deliveries with HMAC-SHA256
+    Secret VarChar(64) NOT NULL,
+    -- link.created, link.clicked, link.expired or link.deleted
+    EventTypes VarChar(32)[] NOT NULL,
+    CreatedAt Timestamp NOT NULL DEFAULT now()
+)
+;
+
+CREATE INDEX webhook_subscriptions_workspace_ids ON WebhookSubscriptions(WorkspaceId)
+    WHERE WorkspaceId IS NOT NULL
+;
+
+CREATE INDEX webhook_subscriptions_user_ids ON WebhookSubscriptions(UserId)
+    WHERE WorkspaceId IS NULL
+;
+
+-- events to be posted to subscriptions, and the log of the attempts
+CREATE TABLE WebhookDeliveries (
+    Id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    SubscriptionId uuid NOT NULL references WebhookSubscriptions(Id) ON DELETE CASCADE,
+    -- id of the event. Redelivered events aren't posted twice
+    EventId VarChar(64) NOT NULL,
+    EventType VarChar(32) NOT NULL,
+    Payload jsonb NOT NULL,
+    -- pending, delivered or dead
+    Status VarChar(16) NOT NULL DEFAULT 'pending',
+    Attempts Int NOT NULL DEFAULT 0,
+    ResponseStatus Int,
+    LastError Text,
+    NextAttemptAt Timestamp,
+    CreatedAt Timestamp NOT NULL DEFAULT now(),
+    DeliveredAt Timestamp,
+    UNIQUE (SubscriptionId, EventId)
+)
+;
+
+CREATE INDEX webhook_deliveries_due ON WebhookDeliveries(NextAttemptAt)
+    WHERE Status = 'pending'
+;
+
+CREATE INDEX webhook_deliveries_log ON WebhookDeliveries(SubscriptionId, CreatedAt DESC)
+;
@@ -0,0 +1,16 @@
+DROP INDEX IF EXISTS urls_search;
+DROP INDEX IF EXISTS urls_tags;
+DROP INDEX IF EXISTS urls_domains;
+DROP INDEX IF EXISTS urls_workspace_history_clicks;
+DROP INDEX IF EXISTS urls_workspace_history_expiration;
+DROP INDEX IF EXISTS urls_workspace_history_created;
+DROP INDEX IF EXISTS urls_user_history_clicks;
+DROP INDEX IF EXISTS urls_user_history_expiration;
+DROP INDEX IF EXISTS urls_user_history_created;
+ALTER TABLE Urls DROP COLUMN IF EXISTS SearchVector;
+ALTER TABLE Urls DROP COLUMN IF EXISTS Domain;
+ALTER TABLE Urls DROP COLUMN IF EXISTS Clicks;
+ALTER TABLE Urls DROP COLUMN IF EXISTS Disabled;
+ALTER TABLE Urls DROP COLUMN IF EXISTS Tags;
+ALTER TABLE Urls DROP COLUMN IF EXISTS Title;
+ALTER TABLE Urls DROP COLUMN IF EXISTS CreatedAt;
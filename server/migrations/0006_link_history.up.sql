@@ -0,0 +1,66 @@
+-- links stored before this migration get its time as their creation time
+ALTER TABLE Urls ADD COLUMN CreatedAt Timestamp NOT NULL DEFAULT now()
+;
+
+ALTER TABLE Urls ADD COLUMN Title VarChar(300)
+;
+
+ALTER TABLE Urls ADD COLUMN Tags VarChar(32)[] NOT NULL DEFAULT '{}'
+;
+
+-- flag operators set in the database. The history is filtered by it,
+-- redirects don't check it
+ALTER TABLE Urls ADD COLUMN Disabled Boolean NOT NULL DEFAULT false
+;
+
+-- counted by the Storage service from the link events topic
+ALTER TABLE Urls ADD COLUMN Clicks Bigint NOT NULL DEFAULT 0
+;
+
+-- host of the long url, for filtering the history by domain
+ALTER TABLE Urls ADD COLUMN Domain VarChar(300) GENERATED ALWAYS AS (
+    lower(substring(LongUrl from '^[[:alpha:]][[:alnum:]+.-]*://(?:[^/?#@]*@)?([^/?#:]+)'))
+) STORED
+;
+
+-- words of the title and of the long url. Urls are split on punctuation, so
+-- that parts of hosts and paths are found on their own
+ALTER TABLE Urls ADD COLUMN SearchVector tsvector GENERATED ALWAYS AS (
+    to_tsvector('simple', coalesce(Title, '') || ' ' || regexp_replace(LongUrl, '[^[:alnum:]]+', ' ', 'g'))
+) STORED
+;
+
+-- keyset pagination of the history by every sort, for personal links and
+-- for links of workspaces
+CREATE INDEX urls_user_history_created ON Urls(UserId, CreatedAt, ShortUrl)
+    WHERE WorkspaceId IS NULL
+;
+
+CREATE INDEX urls_user_history_expiration ON Urls(UserId, ExpirationDate, ShortUrl)
+    WHERE WorkspaceId IS NULL
+;
+
+CREATE INDEX urls_user_history_clicks ON Urls(UserId, Clicks, ShortUrl)
+    WHERE WorkspaceId IS NULL
+;
+
+CREATE INDEX urls_workspace_history_created ON Urls(WorkspaceId, CreatedAt, ShortUrl)
+    WHERE WorkspaceId IS NOT NULL
+;
+
+CREATE INDEX urls_workspace_history_expiration ON Urls(WorkspaceId, ExpirationDate, ShortUrl)
+    WHERE WorkspaceId IS NOT NULL
+;
+
+CREATE INDEX urls_workspace_history_clicks ON Urls(WorkspaceId, Clicks, ShortUrl)
+    WHERE WorkspaceId IS NOT NULL
+;
+
+CREATE INDEX urls_domains ON Urls(Domain)
+;
+
+CREATE INDEX urls_tags ON Urls USING gin(Tags)
+;
+
+CREATE INDEX urls_search ON Urls USING gin(SearchVector)
+;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS ExportChunks;
+DROP TABLE IF EXISTS ExportJobs;
+DROP TABLE IF EXISTS LinkClicks;
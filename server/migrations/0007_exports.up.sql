@@ -0,0 +1,53 @@
+-- clicks of links by day, counted by the Storage service along with
+-- Urls.Clicks. Days are UTC dates
+CREATE TABLE LinkClicks (
+    ShortUrl VarChar(5) NOT NULL references Urls(ShortUrl) ON DELETE CASCADE,
+    Day Date NOT NULL,
+    Clicks Bigint NOT NULL,
+    PRIMARY KEY (ShortUrl, Day)
+)
+;
+
+-- exports of links too large to be streamed in a request, see viewer
+CREATE TABLE ExportJobs (
+    Id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    -- the user that has requested the export. Exports of a workspace have
+    -- WorkspaceId set
+    UserId uuid NOT NULL references Users(Id) ON DELETE CASCADE,
+    WorkspaceId uuid references Workspaces(Id) ON DELETE CASCADE,
+    -- csv, ndjson or parquet
+    Format VarChar(16) NOT NULL,
+    -- export click aggregates of the links too
+    Clicks Boolean NOT NULL,
+    -- filters and sort of the history
+    Query jsonb NOT NULL,
+    -- pending, running, done or failed
+    Status VarChar(16) NOT NULL DEFAULT 'pending',
+    Error Text,
+    Rows Bigint NOT NULL DEFAULT 0,
+    Size Bigint NOT NULL DEFAULT 0,
+    Chunks Int NOT NULL DEFAULT 0,
+    CreatedAt Timestamp NOT NULL DEFAULT now(),
+    StartedAt Timestamp,
+    FinishedAt Timestamp,
+    -- the job and its file are deleted then
+    ExpiresAt Timestamp
+)
+;
+
+CREATE INDEX export_jobs_queue ON ExportJobs(CreatedAt)
+    WHERE Status IN ('pending', 'running')
+;
+
+CREATE INDEX export_jobs_expires_at ON ExportJobs(ExpiresAt)
+;
+
+-- files of finished exports, split into chunks that are written and read
+-- one by one
+CREATE TABLE ExportChunks (
+    JobId uuid NOT NULL references ExportJobs(Id) ON DELETE CASCADE,
+    Seq Int NOT NULL,
+    Data Bytea NOT NULL,
+    PRIMARY KEY (JobId, Seq)
+)
+;
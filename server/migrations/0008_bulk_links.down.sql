@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS BulkJobs;
+
+-- aliases don't fit into the column anymore
+DELETE FROM LinkClicks WHERE length(ShortUrl) > 5;
+DELETE FROM ExpiryReminders WHERE length(ShortUrl) > 5;
+DELETE FROM UrlsArchive WHERE length(ShortUrl) > 5;
+DELETE FROM Urls WHERE length(ShortUrl) > 5;
+
+ALTER TABLE LinkClicks ALTER COLUMN ShortUrl TYPE VarChar(5);
+ALTER TABLE ExpiryReminders ALTER COLUMN ShortUrl TYPE VarChar(5);
+ALTER TABLE UrlsArchive ALTER COLUMN ShortUrl TYPE VarChar(5);
+ALTER TABLE Urls ALTER COLUMN ShortUrl TYPE VarChar(5);
@@ -0,0 +1,42 @@
+-- short urls may be aliases chosen by users, see domain.ValidShortUrl
+ALTER TABLE Urls ALTER COLUMN ShortUrl TYPE VarChar(32)
+;
+ALTER TABLE UrlsArchive ALTER COLUMN ShortUrl TYPE VarChar(32)
+;
+ALTER TABLE ExpiryReminders ALTER COLUMN ShortUrl TYPE VarChar(32)
+;
+ALTER TABLE LinkClicks ALTER COLUMN ShortUrl TYPE VarChar(32)
+;
+
+-- uploads of links too large to be created in a request, see shortener
+CREATE TABLE BulkJobs (
+    Id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    -- the user that has uploaded the links. Links of a workspace have
+    -- WorkspaceId set
+    UserId uuid NOT NULL references Users(Id) ON DELETE CASCADE,
+    WorkspaceId uuid references Workspaces(Id) ON DELETE CASCADE,
+    -- rows of the upload, dropped once the job is finished
+    Links jsonb,
+    -- pending, running, done or failed
+    Status VarChar(16) NOT NULL DEFAULT 'pending',
+    Error Text,
+    Total Int NOT NULL,
+    Processed Int NOT NULL DEFAULT 0,
+    Succeeded Int NOT NULL DEFAULT 0,
+    Failed Int NOT NULL DEFAULT 0,
+    -- results of processed rows, in the order of the rows
+    Results jsonb NOT NULL DEFAULT '[]',
+    CreatedAt Timestamp NOT NULL DEFAULT now(),
+    StartedAt Timestamp,
+    FinishedAt Timestamp,
+    -- the job is deleted then
+    ExpiresAt Timestamp
+)
+;
+
+CREATE INDEX bulk_jobs_queue ON BulkJobs(CreatedAt)
+    WHERE Status IN ('pending', 'running')
+;
+
+CREATE INDEX bulk_jobs_expires_at ON BulkJobs(ExpiresAt)
+;
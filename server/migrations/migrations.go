@@ -0,0 +1,9 @@
+// Package migrations embeds the schema migrations of the Postgres database.
+// Files are named <version>_<name>.up.sql and <version>_<name>.down.sql,
+// see cmd/migrate.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -98,6 +98,80 @@ func (_c *MockBlackboxServiceClient_IssueToken_Call) RunAndReturn(run func(conte
 	return _c
 }
 
+// RevokeTokens provides a mock function with given fields: ctx, in, opts
+func (_m *MockBlackboxServiceClient) RevokeTokens(ctx context.Context, in *blackbox.RevokeTokensReq, opts ...grpc.CallOption) (*blackbox.RevokeTokensRsp, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RevokeTokens")
+	}
+
+	var r0 *blackbox.RevokeTokensRsp
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *blackbox.RevokeTokensReq, ...grpc.CallOption) (*blackbox.RevokeTokensRsp, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *blackbox.RevokeTokensReq, ...grpc.CallOption) *blackbox.RevokeTokensRsp); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*blackbox.RevokeTokensRsp)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *blackbox.RevokeTokensReq, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockBlackboxServiceClient_RevokeTokens_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RevokeTokens'
+type MockBlackboxServiceClient_RevokeTokens_Call struct {
+	*mock.Call
+}
+
+// RevokeTokens is a helper method to define mock.On call
+//   - ctx context.Context
+//   - in *blackbox.RevokeTokensReq
+//   - opts ...grpc.CallOption
+func (_e *MockBlackboxServiceClient_Expecter) RevokeTokens(ctx interface{}, in interface{}, opts ...interface{}) *MockBlackboxServiceClient_RevokeTokens_Call {
+	return &MockBlackboxServiceClient_RevokeTokens_Call{Call: _e.mock.On("RevokeTokens",
+		append([]interface{}{ctx, in}, opts...)...)}
+}
+
+func (_c *MockBlackboxServiceClient_RevokeTokens_Call) Run(run func(ctx context.Context, in *blackbox.RevokeTokensReq, opts ...grpc.CallOption)) *MockBlackboxServiceClient_RevokeTokens_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		variadicArgs := make([]grpc.CallOption, len(args)-2)
+		for i, a := range args[2:] {
+			if a != nil {
+				variadicArgs[i] = a.(grpc.CallOption)
+			}
+		}
+		run(args[0].(context.Context), args[1].(*blackbox.RevokeTokensReq), variadicArgs...)
+	})
+	return _c
+}
+
+func (_c *MockBlackboxServiceClient_RevokeTokens_Call) Return(_a0 *blackbox.RevokeTokensRsp, _a1 error) *MockBlackboxServiceClient_RevokeTokens_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockBlackboxServiceClient_RevokeTokens_Call) RunAndReturn(run func(context.Context, *blackbox.RevokeTokensReq, ...grpc.CallOption) (*blackbox.RevokeTokensRsp, error)) *MockBlackboxServiceClient_RevokeTokens_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // ValidateToken provides a mock function with given fields: ctx, in, opts
 func (_m *MockBlackboxServiceClient) ValidateToken(ctx context.Context, in *blackbox.ValidateTokenReq, opts ...grpc.CallOption) (*blackbox.ValidateTokenRsp, error) {
 	_va := make([]interface{}, len(opts))
@@ -0,0 +1,98 @@
+// Package bus abstracts the message broker the services exchange events
+// over. Implementations are in the kafka, memory and nats subpackages.
+//
+// Topics are split into partitions. Messages of a partition are delivered in
+// the order they have been published, and a consumer acknowledges them the
+// way Kafka commits offsets: acknowledging a message acknowledges the
+// earlier ones too. Unacknowledged messages are delivered again, so
+// delivery is at-least-once.
+package bus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+
+	// set on consumed messages
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+}
+
+// Header returns value of the message header. Header names are case
+// insensitive.
+func (m *Message) Header(key string) string {
+	if v, ok := m.Headers[key]; ok {
+		return v
+	}
+	for k, v := range m.Headers {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+type Publisher interface {
+	// Publish returns once the bus has accepted the messages. When only some
+	// of them have been rejected, the error is PublishErrors. Asynchronous
+	// publishers return before messages are delivered and report delivery
+	// failures on their own.
+	Publish(ctx context.Context, mm ...*Message) error
+}
+
+// PublishErrors are errors of the messages that haven't been published, by
+// their index in the Publish call
+type PublishErrors map[int]error
+
+func (e PublishErrors) Error() string {
+	indexes := make([]int, 0, len(e))
+	for i := range e {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	parts := make([]string, len(indexes))
+	for j, i := range indexes {
+		parts[j] = fmt.Sprintf("message %d: %v", i, e[i])
+	}
+	return fmt.Sprintf(
+		"couldn't publish %d messages: %s",
+		len(e),
+		strings.Join(parts, "; "),
+	)
+}
+
+// Claim is a partition of a topic assigned to the handler
+type Claim interface {
+	Topic() string
+	Partition() int32
+	// Messages is closed when the claim is revoked, e.g. on rebalancing or
+	// when the subscription stops
+	Messages() <-chan *Message
+	// Ack marks the message and the earlier messages of the claim as
+	// processed
+	Ack(m *Message)
+}
+
+type Handler interface {
+	// Consume processes messages of the claim until its channel is closed.
+	// Claims are consumed concurrently. An error ends the claims of the
+	// subscription, and unacknowledged messages are delivered again.
+	Consume(c Claim) error
+}
+
+type Subscriber interface {
+	// Subscribe passes claims of the topics to the handler until the context
+	// is cancelled
+	Subscribe(ctx context.Context, topics []string, h Handler) error
+}
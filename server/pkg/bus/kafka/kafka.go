@@ -0,0 +1,311 @@
+// Package kafka implements the bus over Kafka with sarama
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"shortener/pkg/bus"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+)
+
+var (
+	produced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_produced_messages_total",
+		Help: "Messages sent to Kafka by topic and result: success or failure.",
+	}, []string{"topic", "result"})
+	consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kafka_consumer_lag",
+		Help: "Messages of the partition that haven't been received yet.",
+	}, []string{"topic", "partition"})
+)
+
+func ToProducerMessage(m *bus.Message) *sarama.ProducerMessage {
+	msg := &sarama.ProducerMessage{
+		Topic: m.Topic,
+		Value: sarama.ByteEncoder(m.Value),
+	}
+	if m.Key != nil {
+		msg.Key = sarama.ByteEncoder(m.Key)
+	}
+
+	keys := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{
+			Key:   []byte(k),
+			Value: []byte(m.Headers[k]),
+		})
+	}
+	return msg
+}
+
+func FromConsumerMessage(msg *sarama.ConsumerMessage) *bus.Message {
+	m := &bus.Message{
+		Topic:     msg.Topic,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+	}
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+		if m.Headers == nil {
+			m.Headers = make(map[string]string, len(msg.Headers))
+		}
+		m.Headers[string(h.Key)] = string(h.Value)
+	}
+	return m
+}
+
+// Publisher waits for Kafka to acknowledge the messages
+type Publisher struct {
+	producer sarama.SyncProducer
+}
+
+func NewPublisher(p sarama.SyncProducer) *Publisher {
+	return &Publisher{producer: p}
+}
+
+func (p *Publisher) Publish(_ context.Context, mm ...*bus.Message) error {
+	messages := make([]*sarama.ProducerMessage, len(mm))
+	for i, m := range mm {
+		messages[i] = ToProducerMessage(m)
+		messages[i].Metadata = i
+	}
+
+	err := p.producer.SendMessages(messages)
+	if err == nil {
+		for _, m := range messages {
+			produced.WithLabelValues(m.Topic, "success").Inc()
+		}
+		return nil
+	}
+
+	var perrs sarama.ProducerErrors
+	if !errors.As(err, &perrs) {
+		for _, m := range messages {
+			produced.WithLabelValues(m.Topic, "failure").Inc()
+		}
+		return err
+	}
+	errs := bus.PublishErrors{}
+	for _, perr := range perrs {
+		errs[perr.Msg.Metadata.(int)] = perr.Err
+	}
+	for i, m := range messages {
+		result := "success"
+		if errs[i] != nil {
+			result = "failure"
+		}
+		produced.WithLabelValues(m.Topic, result).Inc()
+	}
+	return errs
+}
+
+// AsyncPublisher hands messages to the producer and returns without waiting
+// for Kafka. Delivery failures are logged: the messages are lost.
+type AsyncPublisher struct {
+	producer sarama.AsyncProducer
+
+	sent      atomic.Int64
+	published atomic.Int64
+	failed    atomic.Int64
+
+	// closed once delivery reports of all the messages have been received
+	reported chan struct{}
+}
+
+// NewAsyncPublisher drains delivery reports of the producer, which must
+// return both successes and errors
+func NewAsyncPublisher(p sarama.AsyncProducer, log *zerolog.Logger) *AsyncPublisher {
+	a := &AsyncPublisher{producer: p, reported: make(chan struct{})}
+	var reports sync.WaitGroup
+	reports.Add(2)
+	go func() {
+		defer reports.Done()
+		for msg := range p.Successes() {
+			a.published.Add(1)
+			produced.WithLabelValues(msg.Topic, "success").Inc()
+		}
+	}()
+	go func() {
+		defer reports.Done()
+		for perr := range p.Errors() {
+			a.failed.Add(1)
+			produced.WithLabelValues(perr.Msg.Topic, "failure").Inc()
+			log.Error().
+				Err(perr.Err).
+				Str("topic", perr.Msg.Topic).
+				Int64("failed_total", a.Failed()).
+				Msg("couldn't publish message. it has been lost")
+		}
+	}()
+	go func() {
+		reports.Wait()
+		close(a.reported)
+	}()
+	return a
+}
+
+func (a *AsyncPublisher) Publish(ctx context.Context, mm ...*bus.Message) error {
+	for _, m := range mm {
+		// counted before the report may arrive
+		a.sent.Add(1)
+		select {
+		case a.producer.Input() <- ToProducerMessage(m):
+		case <-ctx.Done():
+			a.sent.Add(-1)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Close flushes buffered messages and waits for their delivery reports
+// until ctx is done. Messages must not be published after Close. The
+// producer is closed too.
+func (a *AsyncPublisher) Close(ctx context.Context) error {
+	a.producer.AsyncClose()
+	select {
+	case <-a.reported:
+	case <-ctx.Done():
+		return fmt.Errorf(
+			"%d messages haven't been confirmed: %w",
+			a.Pending(),
+			ctx.Err(),
+		)
+	}
+	return nil
+}
+
+func (a *AsyncPublisher) Published() int64 {
+	return a.published.Load()
+}
+
+func (a *AsyncPublisher) Failed() int64 {
+	return a.failed.Load()
+}
+
+// Pending returns the number of messages without a delivery report
+func (a *AsyncPublisher) Pending() int64 {
+	return a.sent.Load() - a.published.Load() - a.failed.Load()
+}
+
+// Subscriber consumes topics as a member of the consumer group. Acknowledged
+// offsets are committed when a claim ends, so auto commit should be
+// disabled.
+type Subscriber struct {
+	group sarama.ConsumerGroup
+	log   *zerolog.Logger
+}
+
+func NewSubscriber(g sarama.ConsumerGroup, log *zerolog.Logger) *Subscriber {
+	return &Subscriber{group: g, log: log}
+}
+
+// Subscribe rejoins the group after every rebalance until the context is
+// cancelled or the group is closed
+func (s *Subscriber) Subscribe(
+	ctx context.Context,
+	topics []string,
+	h bus.Handler,
+) error {
+	gh := &groupHandler{handler: h, log: s.log}
+	for {
+		err := s.group.Consume(ctx, topics, gh)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type groupHandler struct {
+	handler bus.Handler
+	log     *zerolog.Logger
+}
+
+func (h *groupHandler) Setup(sess sarama.ConsumerGroupSession) error {
+	h.log.Info().
+		Str("member_id", sess.MemberID()).
+		Str("claims", fmt.Sprintf("%v", sess.Claims())).
+		Msg("began consumption")
+	return nil
+}
+
+func (h *groupHandler) Cleanup(sess sarama.ConsumerGroupSession) error {
+	h.log.Info().
+		Str("member_id", sess.MemberID()).
+		Str("claims", fmt.Sprintf("%v", sess.Claims())).
+		Msg("began cleanup")
+	sess.Commit()
+	return nil
+}
+
+func (h *groupHandler) ConsumeClaim(
+	sess sarama.ConsumerGroupSession,
+	claim sarama.ConsumerGroupClaim,
+) error {
+	c := &groupClaim{
+		sess:      sess,
+		topic:     claim.Topic(),
+		partition: claim.Partition(),
+		messages:  make(chan *bus.Message),
+	}
+	lag := consumerLag.WithLabelValues(
+		claim.Topic(),
+		strconv.Itoa(int(claim.Partition())),
+	)
+	go func() {
+		defer close(c.messages)
+		for msg := range claim.Messages() {
+			lag.Set(float64(claim.HighWaterMarkOffset() - msg.Offset - 1))
+			select {
+			case c.messages <- FromConsumerMessage(msg):
+			case <-sess.Context().Done():
+				return
+			}
+		}
+	}()
+	return h.handler.Consume(c)
+}
+
+type groupClaim struct {
+	sess      sarama.ConsumerGroupSession
+	topic     string
+	partition int32
+	messages  chan *bus.Message
+}
+
+func (c *groupClaim) Topic() string {
+	return c.topic
+}
+
+func (c *groupClaim) Partition() int32 {
+	return c.partition
+}
+
+func (c *groupClaim) Messages() <-chan *bus.Message {
+	return c.messages
+}
+
+func (c *groupClaim) Ack(m *bus.Message) {
+	c.sess.MarkOffset(c.topic, c.partition, m.Offset+1, "")
+}
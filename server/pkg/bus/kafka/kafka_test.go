@@ -0,0 +1,73 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"shortener/pkg/bus"
+)
+
+func asyncConfig() *sarama.Config {
+	conf := mocks.NewTestConfig()
+	conf.Producer.Return.Successes = true
+	conf.Producer.Return.Errors = true
+	return conf
+}
+
+func TestAsyncPublisherCloseWaitsForReports(t *testing.T) {
+	p := mocks.NewAsyncProducer(t, asyncConfig())
+	p.ExpectInputAndSucceed()
+	p.ExpectInputAndSucceed()
+	p.ExpectInputAndFail(errors.New("broker is down"))
+
+	log := zerolog.Nop()
+	a := NewAsyncPublisher(p, &log)
+	err := a.Publish(
+		context.Background(),
+		&bus.Message{Topic: "urls", Value: []byte("1")},
+		&bus.Message{Topic: "urls", Value: []byte("2")},
+		&bus.Message{Topic: "urls", Value: []byte("3")},
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, a.Close(ctx))
+	require.Equal(t, int64(2), a.Published())
+	require.Equal(t, int64(1), a.Failed())
+	require.Zero(t, a.Pending())
+}
+
+// stuckProducer never reports delivery of the messages
+type stuckProducer struct {
+	sarama.AsyncProducer
+	input chan *sarama.ProducerMessage
+}
+
+func (p *stuckProducer) Input() chan<- *sarama.ProducerMessage { return p.input }
+
+func (p *stuckProducer) Successes() <-chan *sarama.ProducerMessage { return nil }
+
+func (p *stuckProducer) Errors() <-chan *sarama.ProducerError { return nil }
+
+func (p *stuckProducer) AsyncClose() {}
+
+func TestAsyncPublisherCloseTimeout(t *testing.T) {
+	p := &stuckProducer{input: make(chan *sarama.ProducerMessage, 1)}
+	log := zerolog.Nop()
+	a := NewAsyncPublisher(p, &log)
+	require.NoError(t, a.Publish(context.Background(), &bus.Message{Topic: "urls"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := a.Close(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "1 messages haven't been confirmed")
+}
@@ -0,0 +1,285 @@
+// Package memory implements the bus in process, for tests and single-node
+// deployments. Messages aren't persisted: unacknowledged messages are lost
+// when the process exits.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"shortener/pkg/bus"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("bus is closed")
+
+// Bus is both the publisher and the subscriber. A topic can have one
+// subscription at a time, which acts as a consumer group of one member.
+type Bus struct {
+	mu         sync.Mutex
+	partitions int
+	topics     map[string]*topic
+	closed     bool
+
+	// delay before claims are restarted after a handler error
+	restartDelay time.Duration
+}
+
+type busOption func(b *Bus) error
+
+// WithPartitions sets the number of partitions of every topic. Messages
+// with the same key go to the same partition.
+func WithPartitions(n int) busOption {
+	return func(b *Bus) error {
+		if n < 1 {
+			return errors.New("number of partitions must be positive")
+		}
+		b.partitions = n
+		return nil
+	}
+}
+
+func New(opts ...busOption) (*Bus, error) {
+	b := &Bus{
+		partitions:   1,
+		topics:       map[string]*topic{},
+		restartDelay: time.Second,
+	}
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+type topic struct {
+	partitions []*partition
+	subscribed bool
+	// round robin counter for messages without a key
+	next int
+}
+
+// partition keeps messages from the first unacknowledged one
+type partition struct {
+	mu       sync.Mutex
+	messages []*bus.Message
+	// offset of messages[0], i.e. the committed offset
+	first int64
+	// offset of the next published message
+	end int64
+	// closed and replaced when a message is appended
+	appended chan struct{}
+}
+
+func (b *Bus) topic(name string) *topic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &topic{partitions: make([]*partition, b.partitions)}
+		for i := range t.partitions {
+			t.partitions[i] = &partition{appended: make(chan struct{})}
+		}
+		b.topics[name] = t
+	}
+	return t
+}
+
+func (b *Bus) Publish(_ context.Context, mm ...*bus.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+
+	for _, m := range mm {
+		t := b.topic(m.Topic)
+		var i int
+		if m.Key != nil {
+			h := fnv.New32a()
+			h.Write(m.Key)
+			i = int(h.Sum32() % uint32(len(t.partitions)))
+		} else {
+			i = t.next % len(t.partitions)
+			t.next++
+		}
+
+		p := t.partitions[i]
+		p.mu.Lock()
+		stored := *m
+		stored.Partition = int32(i)
+		stored.Offset = p.end
+		stored.Timestamp = time.Now()
+		p.messages = append(p.messages, &stored)
+		p.end++
+		close(p.appended)
+		p.appended = make(chan struct{})
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+// Close makes further publishing fail. Subscriptions aren't affected.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+}
+
+// Subscribe consumes every partition of the topics in a claim of its own.
+// When a handler returns, all claims are restarted from the committed
+// offsets. The error of the last run is returned once the context is
+// cancelled.
+func (b *Bus) Subscribe(
+	ctx context.Context,
+	topics []string,
+	h bus.Handler,
+) error {
+	b.mu.Lock()
+	for _, name := range topics {
+		if b.topic(name).subscribed {
+			b.mu.Unlock()
+			return fmt.Errorf("topic %s is already subscribed", name)
+		}
+	}
+	var claims []*claim
+	for _, name := range topics {
+		t := b.topic(name)
+		t.subscribed = true
+		for i, p := range t.partitions {
+			claims = append(claims, &claim{
+				topic:     name,
+				partition: int32(i),
+				p:         p,
+			})
+		}
+	}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		for _, name := range topics {
+			b.topic(name).subscribed = false
+		}
+		b.mu.Unlock()
+	}()
+
+	for {
+		err := b.session(ctx, claims, h)
+		if ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(b.restartDelay):
+		}
+	}
+}
+
+// session runs the claims until the context is cancelled or any of the
+// handlers returns
+func (b *Bus) session(ctx context.Context, claims []*claim, h bus.Handler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(claims))
+	var wg sync.WaitGroup
+	for _, c := range claims {
+		c.messages = make(chan *bus.Message)
+		wg.Add(2)
+		go func(c *claim) {
+			defer wg.Done()
+			c.feed(ctx)
+		}(c)
+		go func(c *claim) {
+			defer wg.Done()
+			// the session ends as soon as any claim does
+			defer cancel()
+			errs <- h.Consume(c)
+		}(c)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type claim struct {
+	topic     string
+	partition int32
+	p         *partition
+	messages  chan *bus.Message
+}
+
+// feed sends messages from the committed offset to the claim until the
+// context is cancelled
+func (c *claim) feed(ctx context.Context) {
+	defer close(c.messages)
+
+	c.p.mu.Lock()
+	next := c.p.first
+	c.p.mu.Unlock()
+
+	for {
+		c.p.mu.Lock()
+		if next < c.p.first {
+			next = c.p.first
+		}
+		if next < c.p.end {
+			m := c.p.messages[next-c.p.first]
+			c.p.mu.Unlock()
+
+			select {
+			case c.messages <- m:
+				next++
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		appended := c.p.appended
+		c.p.mu.Unlock()
+
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *claim) Topic() string {
+	return c.topic
+}
+
+func (c *claim) Partition() int32 {
+	return c.partition
+}
+
+func (c *claim) Messages() <-chan *bus.Message {
+	return c.messages
+}
+
+// Ack drops the message and the earlier ones from the partition
+func (c *claim) Ack(m *bus.Message) {
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+
+	n := m.Offset + 1 - c.p.first
+	if n <= 0 {
+		return
+	}
+	if n > int64(len(c.p.messages)) {
+		n = int64(len(c.p.messages))
+	}
+	clear(c.p.messages[:n])
+	c.p.messages = c.p.messages[n:]
+	c.p.first += n
+}
@@ -0,0 +1,193 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/bus"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type handlerFunc func(c bus.Claim) error
+
+func (f handlerFunc) Consume(c bus.Claim) error {
+	return f(c)
+}
+
+// subscribe runs the subscription until the test ends
+func subscribe(t *testing.T, b *Bus, topics []string, h bus.Handler) <-chan error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Subscribe(ctx, topics, h)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return done
+}
+
+func receive(t *testing.T, ch <-chan *bus.Message) *bus.Message {
+	select {
+	case m := <-ch:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("no message has been received")
+		return nil
+	}
+}
+
+func TestPartitionsKeepOrder(t *testing.T) {
+	b, err := New(WithPartitions(4))
+	require.NoError(t, err)
+
+	var mm []*bus.Message
+	for i := 0; i < 10; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			mm = append(mm, &bus.Message{
+				Topic: "links",
+				Key:   []byte(key),
+				Value: []byte{byte(i)},
+			})
+		}
+	}
+	require.NoError(t, b.Publish(context.Background(), mm...))
+
+	received := make(chan *bus.Message)
+	subscribe(t, b, []string{"links"}, handlerFunc(func(c bus.Claim) error {
+		for m := range c.Messages() {
+			assert.Equal(t, c.Partition(), m.Partition)
+			received <- m
+		}
+		return nil
+	}))
+
+	values := map[string][]byte{}
+	partitions := map[string]int32{}
+	for range mm {
+		m := receive(t, received)
+		key := string(m.Key)
+		if p, ok := partitions[key]; ok {
+			assert.Equal(t, p, m.Partition, "messages of a key must share a partition")
+		}
+		partitions[key] = m.Partition
+		values[key] = append(values[key], m.Value...)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		assert.Equal(t, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, values[key])
+	}
+}
+
+func TestAckRemovesMessages(t *testing.T) {
+	b, err := New()
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, b.Publish(context.Background(), &bus.Message{
+			Topic: "links",
+			Value: []byte{byte(i)},
+		}))
+	}
+
+	acked := make(chan struct{})
+	subscribe(t, b, []string{"links"}, handlerFunc(func(c bus.Claim) error {
+		<-c.Messages()
+		m := <-c.Messages()
+		c.Ack(m)
+		close(acked)
+		for range c.Messages() {
+		}
+		return nil
+	}))
+	<-acked
+
+	p := b.topics["links"].partitions[0]
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	assert.Equal(t, int64(2), p.first)
+	require.Len(t, p.messages, 1)
+	assert.Equal(t, []byte{2}, p.messages[0].Value)
+}
+
+func TestRedeliveryAfterHandlerError(t *testing.T) {
+	b, err := New()
+	require.NoError(t, err)
+	b.restartDelay = time.Millisecond
+	require.NoError(t, b.Publish(
+		context.Background(),
+		&bus.Message{Topic: "links", Value: []byte("first")},
+		&bus.Message{Topic: "links", Value: []byte("second")},
+	))
+
+	var mu sync.Mutex
+	runs := 0
+	received := make(chan *bus.Message)
+	subscribe(t, b, []string{"links"}, handlerFunc(func(c bus.Claim) error {
+		mu.Lock()
+		runs++
+		run := runs
+		mu.Unlock()
+
+		m := <-c.Messages()
+		received <- m
+		if run == 1 {
+			// the first message is acknowledged, the second one isn't
+			c.Ack(m)
+			received <- <-c.Messages()
+			return errors.New("couldn't process message")
+		}
+		for range c.Messages() {
+		}
+		return nil
+	}))
+
+	assert.Equal(t, "first", string(receive(t, received).Value))
+	assert.Equal(t, "second", string(receive(t, received).Value))
+	redelivered := receive(t, received)
+	assert.Equal(t, "second", string(redelivered.Value))
+	assert.Equal(t, int64(1), redelivered.Offset)
+}
+
+func TestSubscribeReturnsOnCancel(t *testing.T) {
+	b, err := New()
+	require.NoError(t, err)
+	b.restartDelay = time.Millisecond
+
+	handlerErr := errors.New("couldn't process message")
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Subscribe(ctx, []string{"links"}, handlerFunc(func(c bus.Claim) error {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			for range c.Messages() {
+			}
+			return handlerErr
+		}))
+	}()
+
+	<-started
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, handlerErr)
+	case <-time.After(time.Second):
+		t.Fatal("Subscribe hasn't returned")
+	}
+
+	// the topic can be subscribed again
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, b.Subscribe(ctx, []string{"links"}, handlerFunc(func(c bus.Claim) error {
+		for range c.Messages() {
+		}
+		return nil
+	})))
+}
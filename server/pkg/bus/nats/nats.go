@@ -0,0 +1,440 @@
+// Package nats implements the bus over NATS JetStream. Topics are subjects
+// of one stream. JetStream has no partitions: every topic is a single claim
+// with partition 0, and its offsets are stream sequence numbers. Subscribers
+// of the same durable name share the messages of a topic.
+package nats
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/bus"
+	"strings"
+	"sync"
+	"time"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog"
+)
+
+// HeaderKey carries the message key, which NATS messages don't have
+const HeaderKey = "Bus-Key"
+
+// Connect connects to the server and makes sure the stream exists. Every
+// service must pass the same topics: they replace subjects of the stream.
+func Connect(
+	ctx context.Context,
+	url string,
+	stream string,
+	topics []string,
+) (*natsgo.Conn, jetstream.JetStream, error) {
+	nc, err := natsgo.Connect(url)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, nil, err
+	}
+
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     stream,
+		Subjects: topics,
+		Storage:  jetstream.FileStorage,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, nil, err
+	}
+	return nc, js, nil
+}
+
+func toMsg(m *bus.Message) *natsgo.Msg {
+	msg := natsgo.NewMsg(m.Topic)
+	msg.Data = m.Value
+	for k, v := range m.Headers {
+		msg.Header.Set(k, v)
+	}
+	if m.Key != nil {
+		msg.Header.Set(HeaderKey, string(m.Key))
+	}
+	return msg
+}
+
+func fromMsg(msg jetstream.Msg) (*bus.Message, error) {
+	md, err := msg.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &bus.Message{
+		Topic:     msg.Subject(),
+		Value:     msg.Data(),
+		Offset:    int64(md.Sequence.Stream),
+		Timestamp: md.Timestamp,
+	}
+	for k, vv := range msg.Headers() {
+		if len(vv) == 0 {
+			continue
+		}
+		if k == HeaderKey {
+			m.Key = []byte(vv[0])
+			continue
+		}
+		if m.Headers == nil {
+			m.Headers = make(map[string]string, len(msg.Headers()))
+		}
+		m.Headers[k] = vv[0]
+	}
+	return m, nil
+}
+
+// Publisher waits for the stream to acknowledge the messages
+type Publisher struct {
+	js jetstream.JetStream
+}
+
+func NewPublisher(js jetstream.JetStream) *Publisher {
+	return &Publisher{js: js}
+}
+
+func (p *Publisher) Publish(ctx context.Context, mm ...*bus.Message) error {
+	futures := make([]jetstream.PubAckFuture, len(mm))
+	errs := bus.PublishErrors{}
+	for i, m := range mm {
+		f, err := p.js.PublishMsgAsync(toMsg(m))
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		futures[i] = f
+	}
+
+	for i, f := range futures {
+		if f == nil {
+			continue
+		}
+		select {
+		case <-f.Ok():
+		case err := <-f.Err():
+			errs[i] = err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+type Subscriber struct {
+	js      jetstream.JetStream
+	stream  string
+	durable string
+
+	ackWait       time.Duration
+	maxAckPending int
+	restartDelay  time.Duration
+
+	log *zerolog.Logger
+}
+
+type subscriberOption func(s *Subscriber) error
+
+func WithJetStream(js jetstream.JetStream) subscriberOption {
+	return func(s *Subscriber) error {
+		s.js = js
+		return nil
+	}
+}
+
+func WithStream(name string) subscriberOption {
+	return func(s *Subscriber) error {
+		s.stream = name
+		return nil
+	}
+}
+
+// WithDurable sets the name consumers of the topics are created with, the
+// counterpart of a Kafka consumer group
+func WithDurable(name string) subscriberOption {
+	return func(s *Subscriber) error {
+		s.durable = name
+		return nil
+	}
+}
+
+// WithAckWait sets for how long a delivered message may stay unacknowledged
+// before it's delivered again. It must be longer than a batch takes to be
+// processed.
+func WithAckWait(d time.Duration) subscriberOption {
+	return func(s *Subscriber) error {
+		if d <= 0 {
+			return errors.New("ack wait must be positive")
+		}
+		s.ackWait = d
+		return nil
+	}
+}
+
+// WithMaxAckPending limits unacknowledged messages of a topic. It must not
+// be less than the largest batch of the handler.
+func WithMaxAckPending(n int) subscriberOption {
+	return func(s *Subscriber) error {
+		if n < 1 {
+			return errors.New("max ack pending must be positive")
+		}
+		s.maxAckPending = n
+		return nil
+	}
+}
+
+func WithLogger(l *zerolog.Logger) subscriberOption {
+	return func(s *Subscriber) error {
+		s.log = l
+		return nil
+	}
+}
+
+func NewSubscriber(opts ...subscriberOption) (*Subscriber, error) {
+	s := &Subscriber{
+		ackWait:       time.Minute,
+		maxAckPending: 10000,
+		restartDelay:  time.Second,
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.js == nil {
+		return nil, errors.New("no jetstream provided")
+	}
+	if s.stream == "" {
+		return nil, errors.New("no stream provided")
+	}
+	if s.durable == "" {
+		return nil, errors.New("no durable name provided")
+	}
+	if s.log == nil {
+		return nil, errors.New("no logger provided")
+	}
+	return s, nil
+}
+
+// Subscribe creates a durable consumer per topic and consumes them until the
+// context is cancelled. When a handler returns, all claims are restarted and
+// their unacknowledged messages are delivered again. Errors of consumers are
+// returned right away, the error of the last run of the handlers once the
+// context is cancelled.
+func (s *Subscriber) Subscribe(
+	ctx context.Context,
+	topics []string,
+	h bus.Handler,
+) error {
+	consumers := make([]jetstream.Consumer, len(topics))
+	for i, topic := range topics {
+		c, err := s.js.CreateOrUpdateConsumer(ctx, s.stream, jetstream.ConsumerConfig{
+			// durable names can't contain dots
+			Durable:       s.durable + "-" + strings.ReplaceAll(topic, ".", "_"),
+			FilterSubject: topic,
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			AckWait:       s.ackWait,
+			MaxAckPending: s.maxAckPending,
+			DeliverPolicy: jetstream.DeliverAllPolicy,
+		})
+		if err != nil {
+			return err
+		}
+		consumers[i] = c
+	}
+
+	for {
+		claims, err := s.claims(topics, consumers)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		err = s.session(ctx, claims, h)
+		if ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(s.restartDelay):
+		}
+	}
+}
+
+// claims starts pulling messages of the consumers
+func (s *Subscriber) claims(
+	topics []string,
+	consumers []jetstream.Consumer,
+) ([]*claim, error) {
+	claims := make([]*claim, len(consumers))
+	for i, c := range consumers {
+		iter, err := c.Messages(jetstream.PullMaxMessages(s.maxAckPending))
+		if err != nil {
+			for _, c := range claims[:i] {
+				c.iter.Stop()
+			}
+			return nil, err
+		}
+		claims[i] = &claim{
+			topic:    topics[i],
+			iter:     iter,
+			messages: make(chan *bus.Message),
+			log:      s.log,
+		}
+	}
+	return claims, nil
+}
+
+// session runs the claims until the context is cancelled or any of the
+// handlers returns, and returns the error of the handlers
+func (s *Subscriber) session(ctx context.Context, claims []*claim, h bus.Handler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	topics := make([]string, len(claims))
+	for i, c := range claims {
+		topics[i] = c.topic
+	}
+	s.log.Info().Strs("topics", topics).Msg("began consumption")
+
+	errs := make(chan error, len(claims))
+	var wg sync.WaitGroup
+	for _, c := range claims {
+		wg.Add(2)
+		go func(c *claim) {
+			defer wg.Done()
+			c.feed(ctx)
+		}(c)
+		go func(c *claim) {
+			defer wg.Done()
+			defer cancel()
+			errs <- h.Consume(c)
+		}(c)
+	}
+	go func() {
+		<-ctx.Done()
+		for _, c := range claims {
+			c.iter.Stop()
+		}
+	}()
+	wg.Wait()
+	close(errs)
+
+	for _, c := range claims {
+		c.release()
+	}
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type pendingMsg struct {
+	offset int64
+	msg    jetstream.Msg
+}
+
+type claim struct {
+	topic    string
+	iter     jetstream.MessagesContext
+	messages chan *bus.Message
+
+	mu sync.Mutex
+	// delivered messages that haven't been acknowledged, in order of
+	// delivery. Redelivered messages may be out of order of offsets.
+	pending []pendingMsg
+
+	log *zerolog.Logger
+}
+
+func (c *claim) feed(ctx context.Context) {
+	defer close(c.messages)
+	for {
+		msg, err := c.iter.Next()
+		if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+			return
+		}
+		if err != nil {
+			c.log.Error().Err(err).Str("topic", c.topic).Msg("couldn't get message")
+			continue
+		}
+
+		m, err := fromMsg(msg)
+		if err != nil {
+			c.log.Error().Err(err).Str("topic", c.topic).Msg("invalid message")
+			continue
+		}
+		c.mu.Lock()
+		c.pending = append(c.pending, pendingMsg{offset: m.Offset, msg: msg})
+		c.mu.Unlock()
+
+		select {
+		case c.messages <- m:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *claim) Topic() string {
+	return c.topic
+}
+
+func (c *claim) Partition() int32 {
+	return 0
+}
+
+func (c *claim) Messages() <-chan *bus.Message {
+	return c.messages
+}
+
+// Ack acknowledges the message and the messages delivered before it
+func (c *claim) Ack(m *bus.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for i, p := range c.pending {
+		if p.offset == m.Offset {
+			n = i + 1
+			break
+		}
+	}
+	for _, p := range c.pending[:n] {
+		if err := p.msg.Ack(); err != nil {
+			c.log.Error().
+				Err(err).
+				Str("topic", c.topic).
+				Int64("offset", p.offset).
+				Msg("couldn't acknowledge message")
+		}
+	}
+	clear(c.pending[:n])
+	c.pending = c.pending[n:]
+}
+
+// release makes messages left unacknowledged be delivered again right away
+// rather than after the ack wait
+func (c *claim) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range c.pending {
+		p.msg.Nak()
+	}
+	c.pending = nil
+}
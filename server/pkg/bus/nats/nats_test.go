@@ -0,0 +1,138 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/bus"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type handlerFunc func(c bus.Claim) error
+
+func (f handlerFunc) Consume(c bus.Claim) error {
+	return f(c)
+}
+
+// newSubscriber runs an embedded server with JetStream, and returns the
+// publisher and the subscriber of the links topic
+func newSubscriber(t *testing.T) (*Publisher, *Subscriber) {
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+	srv.Start()
+	t.Cleanup(srv.Shutdown)
+	require.True(t, srv.ReadyForConnections(5*time.Second))
+
+	nc, js, err := Connect(context.Background(), srv.ClientURL(), "EVENTS", []string{"links"})
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+
+	log := zerolog.Nop()
+	s, err := NewSubscriber(
+		WithJetStream(js),
+		WithStream("EVENTS"),
+		WithDurable("storage"),
+		WithLogger(&log),
+	)
+	require.NoError(t, err)
+	s.restartDelay = time.Millisecond
+	return NewPublisher(js), s
+}
+
+func receive(t *testing.T, ch <-chan *bus.Message) *bus.Message {
+	select {
+	case m := <-ch:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message has been received")
+		return nil
+	}
+}
+
+func TestClaimAcksEarlierMessages(t *testing.T) {
+	p, s := newSubscriber(t)
+	ctx := context.Background()
+	for _, v := range []string{"first", "second", "third"} {
+		require.NoError(t, p.Publish(ctx, &bus.Message{
+			Topic:   "links",
+			Key:     []byte("key"),
+			Value:   []byte(v),
+			Headers: map[string]string{"Trace": v},
+		}))
+	}
+
+	firstErr := errors.New("couldn't process message")
+	lastErr := errors.New("couldn't stop")
+	runs := 0
+	received := make(chan *bus.Message)
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Subscribe(ctx, []string{"links"}, handlerFunc(func(c bus.Claim) error {
+			runs++
+			assert.Equal(t, "links", c.Topic())
+			assert.Equal(t, int32(0), c.Partition())
+			if runs == 1 {
+				<-c.Messages()
+				m := <-c.Messages()
+				// acknowledges the first message too
+				c.Ack(m)
+				received <- m
+				received <- <-c.Messages()
+				return firstErr
+			}
+			for m := range c.Messages() {
+				received <- m
+			}
+			return lastErr
+		}))
+	}()
+
+	m := receive(t, received)
+	assert.Equal(t, "second", string(m.Value))
+	assert.Equal(t, []byte("key"), m.Key)
+	assert.Equal(t, "second", m.Header("Trace"))
+	assert.Equal(t, int64(2), m.Offset)
+	assert.Equal(t, "third", string(receive(t, received).Value))
+
+	// only the unacknowledged message is delivered again
+	redelivered := receive(t, received)
+	assert.Equal(t, "third", string(redelivered.Value))
+	assert.Equal(t, int64(3), redelivered.Offset)
+	select {
+	case m := <-received:
+		t.Fatalf("acknowledged message %q has been delivered again", m.Value)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, lastErr)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe hasn't returned")
+	}
+}
+
+func TestSubscribeReturnsConsumerErrors(t *testing.T) {
+	_, s := newSubscriber(t)
+	s.stream = "MISSING"
+
+	err := s.Subscribe(context.Background(), []string{"links"}, handlerFunc(func(c bus.Claim) error {
+		t.Fatal("no claims are expected")
+		return nil
+	}))
+	assert.Error(t, err)
+}
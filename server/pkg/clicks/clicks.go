@@ -0,0 +1,216 @@
+// Package clicks publishes clicks on links to the link events topic. Clicks
+// are published in batches in the background, so that redirects wait for
+// neither the bus nor its consumers.
+package clicks
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/bus"
+	"shortener/pkg/events"
+	eventsv1 "shortener/proto/events/v1"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	published = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "clicks_published_total",
+		Help: "Clicks published to the link events topic.",
+	})
+	failed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "clicks_failed_total",
+		Help: "Clicks that couldn't be published.",
+	})
+	dropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "clicks_dropped_total",
+		Help: "Clicks not published because the queue has been full.",
+	})
+)
+
+type click struct {
+	shortUrl string
+	at       time.Time
+}
+
+// Reporter publishes clicks in batches. Clicks are dropped rather than
+// delaying redirects when the bus falls behind.
+type Reporter struct {
+	publisher     bus.Publisher
+	topic         string
+	batchSize     int
+	flushInterval time.Duration
+	// limits publishing of the last batch on shutdown
+	flushTimeout time.Duration
+	log          *zerolog.Logger
+
+	clicks chan click
+	now    func() time.Time
+}
+
+const (
+	DefaultBatchSize     = 100
+	DefaultFlushInterval = time.Second
+	// clicks waiting to be published, the ones above it are dropped
+	queueSize = 4096
+)
+
+type reporterOption func(r *Reporter) error
+
+func WithPublisher(p bus.Publisher, topic string) reporterOption {
+	return func(r *Reporter) error {
+		r.publisher = p
+		r.topic = topic
+		return nil
+	}
+}
+
+// WithBatching sets how many clicks are published at once and how long a
+// click may wait for its batch to fill up
+func WithBatching(size int, interval time.Duration) reporterOption {
+	return func(r *Reporter) error {
+		if size < 1 || interval <= 0 {
+			return errors.New("batch size and flush interval must be positive")
+		}
+		r.batchSize = size
+		r.flushInterval = interval
+		return nil
+	}
+}
+
+// WithFlushTimeout limits how long clicks are published for on shutdown
+func WithFlushTimeout(t time.Duration) reporterOption {
+	return func(r *Reporter) error {
+		r.flushTimeout = t
+		return nil
+	}
+}
+
+func WithLogger(l *zerolog.Logger) reporterOption {
+	return func(r *Reporter) error {
+		r.log = l
+		return nil
+	}
+}
+
+func New(opts ...reporterOption) (*Reporter, error) {
+	r := &Reporter{
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+		flushTimeout:  5 * time.Second,
+		clicks:        make(chan click, queueSize),
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.publisher == nil || r.topic == "" {
+		return nil, errors.New("no publisher provided")
+	}
+	if r.log == nil {
+		return nil, errors.New("no logger provided")
+	}
+	return r, nil
+}
+
+// Clicked queues the click without blocking
+func (r *Reporter) Clicked(shortUrl string) {
+	select {
+	case r.clicks <- click{shortUrl: shortUrl, at: r.now()}:
+	default:
+		dropped.Inc()
+	}
+}
+
+// Run publishes the clicks until the context is cancelled. Queued clicks
+// are published then.
+func (r *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]click, 0, r.batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			r.drain(batch)
+			return
+		case c := <-r.clicks:
+			batch = append(batch, c)
+			if len(batch) < r.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		r.publish(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+// drain publishes the batch along with the queued clicks
+func (r *Reporter) drain(batch []click) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.flushTimeout)
+	defer cancel()
+	for {
+		select {
+		case c := <-r.clicks:
+			batch = append(batch, c)
+			if len(batch) < r.batchSize {
+				continue
+			}
+			r.publish(ctx, batch)
+			batch = batch[:0]
+		default:
+			if len(batch) > 0 {
+				r.publish(ctx, batch)
+			}
+			return
+		}
+	}
+}
+
+func (r *Reporter) publish(ctx context.Context, batch []click) {
+	mm := make([]*bus.Message, 0, len(batch))
+	for _, c := range batch {
+		value, contentType, err := events.Encode(&eventsv1.LinkClicked{
+			IdempotencyKey: uuid.New().String(),
+			ShortUrl:       c.shortUrl,
+			ClickedAt:      timestamppb.New(c.at),
+		})
+		if err != nil {
+			r.log.Error().Err(err).Msg("couldn't encode click")
+			failed.Inc()
+			continue
+		}
+		mm = append(mm, &bus.Message{
+			Topic: r.topic,
+			// clicks on a link stay in order
+			Key:     []byte(c.shortUrl),
+			Value:   value,
+			Headers: map[string]string{events.HeaderContentType: contentType},
+		})
+	}
+
+	err := r.publisher.Publish(ctx, mm...)
+	var perr bus.PublishErrors
+	switch {
+	case err == nil:
+		published.Add(float64(len(mm)))
+	case errors.As(err, &perr):
+		published.Add(float64(len(mm) - len(perr)))
+		failed.Add(float64(len(perr)))
+		r.log.Error().Err(err).Msg("couldn't publish some clicks")
+	default:
+		failed.Add(float64(len(mm)))
+		r.log.Error().Err(err).Int("clicks", len(mm)).Msg("couldn't publish clicks")
+	}
+}
@@ -0,0 +1,86 @@
+package clicks
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/bus"
+	"shortener/pkg/events"
+	"testing"
+	"time"
+
+	bus_mocks "shortener/mocks/shortener/pkg/bus"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newReporter(t *testing.T, p bus.Publisher, opts ...reporterOption) *Reporter {
+	log := zerolog.Nop()
+	r, err := New(append([]reporterOption{
+		WithPublisher(p, "link-events"),
+		WithLogger(&log),
+	}, opts...)...)
+	assert.Nil(t, err)
+	return r
+}
+
+func TestRunPublishesBatchesAndFlushesOnExit(t *testing.T) {
+	var batches [][]string
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().Publish(mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(func(_ context.Context, mm ...*bus.Message) error {
+			var codes []string
+			for _, mes := range mm {
+				e, err := events.DecodeLinkEvent(mes)
+				assert.Nil(t, err)
+				assert.Equal(t, "link-events", mes.Topic)
+				assert.Equal(t, e.ShortUrl, string(mes.Key))
+				assert.NotEmpty(t, e.Id)
+				codes = append(codes, e.ShortUrl)
+			}
+			batches = append(batches, codes)
+			return nil
+		})
+	p.EXPECT().Publish(mock.Anything, mock.Anything).
+		RunAndReturn(func(_ context.Context, mm ...*bus.Message) error {
+			e, err := events.DecodeLinkEvent(mm[0])
+			assert.Nil(t, err)
+			batches = append(batches, []string{e.ShortUrl})
+			return nil
+		})
+
+	// nothing is published by the ticker before the context is cancelled
+	r := newReporter(t, p, WithBatching(2, time.Hour))
+	for _, code := range []string{"aaaaa", "bbbbb", "ccccc"} {
+		r.Clicked(code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return len(r.clicks) == 0 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, [][]string{{"aaaaa", "bbbbb"}, {"ccccc"}}, batches)
+}
+
+func TestClickedDoesNotBlock(t *testing.T) {
+	r := newReporter(t, bus_mocks.NewMockPublisher(t))
+	for range queueSize + 10 {
+		r.Clicked("aaaaa")
+	}
+	assert.Len(t, r.clicks, queueSize)
+}
+
+func TestPublishFailuresAreNotRetried(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().Publish(mock.Anything, mock.Anything).Return(errors.New("bus is down")).Once()
+
+	r := newReporter(t, p)
+	r.publish(context.Background(), []click{{shortUrl: "aaaaa", at: time.Now()}})
+}
@@ -0,0 +1,486 @@
+// Package config loads settings of the services. Values are taken from the
+// defaults, then from a YAML file given with -config or CONFIG_FILE, then
+// from environment variables and at last from command line flags, each
+// overriding the previous ones. Every setting has a flag named after its
+// path in the file, e.g. -redis.addr, and most have an environment variable
+// named in the env tag.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"shortener/pkg/domain"
+	"shortener/pkg/middleware"
+	"shortener/pkg/renewal"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	HTTP      HTTP      `yaml:"http"`
+	GRPC      GRPC      `yaml:"grpc"`
+	Admin     Admin     `yaml:"admin"`
+	Tracing   Tracing   `yaml:"tracing"`
+	Shutdown  Shutdown  `yaml:"shutdown"`
+	Cors      Cors      `yaml:"cors"`
+	Postgres  Postgres  `yaml:"postgres"`
+	Redis     Redis     `yaml:"redis"`
+	Blackbox  Blackbox  `yaml:"blackbox"`
+	Bus       Bus       `yaml:"bus"`
+	Kafka     Kafka     `yaml:"kafka"`
+	Nats      Nats      `yaml:"nats"`
+	Topics    Topics    `yaml:"topics"`
+	Outbox    Outbox    `yaml:"outbox"`
+	Storage   Storage   `yaml:"storage"`
+	Reaper    Reaper    `yaml:"reaper"`
+	SMTP      SMTP      `yaml:"smtp"`
+	Links     Links     `yaml:"links"`
+	Renewal   Renewal   `yaml:"renewal"`
+	Reminders Reminders `yaml:"reminders"`
+	Webhooks  Webhooks  `yaml:"webhooks"`
+	Exports   Exports   `yaml:"exports"`
+	Bulk      Bulk      `yaml:"bulk"`
+	Oidc      Oidc      `yaml:"oidc"`
+	AllInOne  AllInOne  `yaml:"allinone"`
+}
+
+type HTTP struct {
+	Addr         string        `yaml:"addr"          env:"HTTP_ADDR"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"  env:"HTTP_READ_TIMEOUT"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"  env:"HTTP_IDLE_TIMEOUT"`
+}
+
+type GRPC struct {
+	Addr string `yaml:"addr" env:"GRPC_ADDR"`
+}
+
+// Admin is the address metrics are served on, apart from the public routes
+type Admin struct {
+	Addr string `yaml:"addr" env:"ADMIN_ADDR"`
+}
+
+type Tracing struct {
+	// none, stdout or otlp
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER"`
+	// host:port of the OTLP gRPC receiver
+	Endpoint string `yaml:"endpoint" env:"TRACING_ENDPOINT"`
+}
+
+type Shutdown struct {
+	// time readiness probes fail before the server stops accepting
+	// requests, so that load balancers stop sending them
+	DrainDelay time.Duration `yaml:"drain_delay" env:"SHUTDOWN_DRAIN_DELAY"`
+	// time every stage of the shutdown may take: finishing in-flight
+	// requests, flushing producers and the current storage batches
+	Timeout time.Duration `yaml:"timeout" env:"SHUTDOWN_TIMEOUT"`
+}
+
+type Cors struct {
+	// the only origin browsers may send credentialed requests from
+	Origin string `yaml:"origin" env:"CORS_ORIGIN"`
+}
+
+type Postgres struct {
+	DSN string `yaml:"dsn" env:"POSTGRES_DSN" secret:"url"`
+}
+
+type Redis struct {
+	Addr string `yaml:"addr" env:"REDIS_ADDR"`
+}
+
+type Blackbox struct {
+	Addr   string `yaml:"addr"   env:"BLACKBOX_ADDR"`
+	Secret string `yaml:"secret" env:"BLACKBOX_SECRET" secret:"true"`
+}
+
+type Bus struct {
+	// kafka or nats
+	Kind string `yaml:"kind" env:"BUS"`
+}
+
+type Kafka struct {
+	Brokers []string `yaml:"brokers" env:"KAFKA_BROKERS"`
+	// how often asynchronous producers send accumulated messages
+	FlushFrequency time.Duration `yaml:"flush_frequency" env:"KAFKA_FLUSH_FREQUENCY"`
+	ConsumerGroup  string        `yaml:"consumer_group"  env:"KAFKA_CONSUMER_GROUP"`
+}
+
+type Nats struct {
+	URL     string `yaml:"url"     env:"NATS_URL"`
+	Stream  string `yaml:"stream"  env:"NATS_STREAM"`
+	Durable string `yaml:"durable" env:"NATS_DURABLE"`
+}
+
+type Topics struct {
+	Urls    string `yaml:"urls"    env:"KAFKA_URLS_TOPIC"`
+	Users   string `yaml:"users"   env:"KAFKA_USERS_TOPIC"`
+	DLQ     string `yaml:"dlq"     env:"KAFKA_DLQ_TOPIC"`
+	Results string `yaml:"results" env:"KAFKA_RESULTS_TOPIC"`
+	// clicks, expirations and deletions of links, see cmd/webhooks
+	Events string `yaml:"events" env:"KAFKA_EVENTS_TOPIC"`
+}
+
+// All returns every topic, e.g. for the subjects of the NATS stream
+func (t Topics) All() []string {
+	return []string{t.Urls, t.Users, t.DLQ, t.Results, t.Events}
+}
+
+type Outbox struct {
+	Enabled bool `yaml:"enabled" env:"OUTBOX_ENABLED"`
+}
+
+// Batch overrides limits of a default storage batch policy. Zero values keep
+// the defaults.
+type Batch struct {
+	MaxSize    int           `yaml:"max_size"    env:"MAX_SIZE"`
+	MaxBytes   int           `yaml:"max_bytes"   env:"MAX_BYTES"`
+	MaxLatency time.Duration `yaml:"max_latency" env:"MAX_LATENCY"`
+}
+
+type Storage struct {
+	// zero keeps the default of the models
+	CopyThreshold int   `yaml:"copy_threshold" env:"STORAGE_COPY_THRESHOLD"`
+	UrlsBatch     Batch `yaml:"urls_batch"     env:"STORAGE_URLS_BATCH_"`
+	UsersBatch    Batch `yaml:"users_batch"    env:"STORAGE_USERS_BATCH_"`
+	ClicksBatch   Batch `yaml:"clicks_batch"   env:"STORAGE_CLICKS_BATCH_"`
+	// apply pending schema migrations on startup, see cmd/migrate
+	Migrate bool `yaml:"migrate" env:"STORAGE_MIGRATE"`
+}
+
+// Reaper configures cmd/reaper, which archives expired links
+type Reaper struct {
+	Interval  time.Duration `yaml:"interval"   env:"REAPER_INTERVAL"`
+	BatchSize int           `yaml:"batch_size" env:"REAPER_BATCH_SIZE"`
+	// links stay in place for the time after their expiration
+	Grace time.Duration `yaml:"grace" env:"REAPER_GRACE"`
+	// short urls of archived links are released after the time, zero never
+	// releases them
+	ReleaseAfter time.Duration `yaml:"release_after" env:"REAPER_RELEASE_AFTER"`
+	DryRun       bool          `yaml:"dry_run"       env:"REAPER_DRY_RUN"`
+	// make a single pass and exit, e.g. from cron
+	Once bool `yaml:"once" env:"REAPER_ONCE"`
+}
+
+// Reminders configures cmd/reminder, which warns users about their links
+// expiring soon
+type Reminders struct {
+	Interval  time.Duration `yaml:"interval"   env:"REMINDERS_INTERVAL"`
+	BatchSize int           `yaml:"batch_size" env:"REMINDERS_BATCH_SIZE"`
+	// days before expiration users are reminded at, unless they have chosen
+	// other ones
+	Days           []int         `yaml:"days"            env:"REMINDERS_DAYS"`
+	WebhookTimeout time.Duration `yaml:"webhook_timeout" env:"REMINDERS_WEBHOOK_TIMEOUT"`
+}
+
+// Webhooks configures cmd/webhooks, which posts events of links to webhooks
+// users subscribe to
+type Webhooks struct {
+	// attempts of a delivery before it's dead. They are delayed by BaseDelay
+	// doubled after every attempt, up to MaxDelay
+	MaxAttempts int           `yaml:"max_attempts" env:"WEBHOOKS_MAX_ATTEMPTS"`
+	BaseDelay   time.Duration `yaml:"base_delay"   env:"WEBHOOKS_BASE_DELAY"`
+	MaxDelay    time.Duration `yaml:"max_delay"    env:"WEBHOOKS_MAX_DELAY"`
+	Timeout     time.Duration `yaml:"timeout"      env:"WEBHOOKS_TIMEOUT"`
+	BatchSize   int           `yaml:"batch_size"   env:"WEBHOOKS_BATCH_SIZE"`
+	// how often due deliveries are looked for
+	PollInterval time.Duration `yaml:"poll_interval" env:"WEBHOOKS_POLL_INTERVAL"`
+	// deliveries posted at once
+	Concurrency int `yaml:"concurrency" env:"WEBHOOKS_CONCURRENCY"`
+	// how long finished deliveries stay in the delivery log
+	Retention time.Duration `yaml:"retention" env:"WEBHOOKS_RETENTION"`
+}
+
+// Exports configures exports of links by cmd/viewer
+type Exports struct {
+	// exports of more links run as jobs, smaller ones are streamed
+	SyncLimit    int64         `yaml:"sync_limit"    env:"EXPORTS_SYNC_LIMIT"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"EXPORTS_POLL_INTERVAL"`
+	// jobs running for longer fail
+	JobTimeout time.Duration `yaml:"job_timeout" env:"EXPORTS_JOB_TIMEOUT"`
+	// how long finished jobs and their files are kept
+	Retention time.Duration `yaml:"retention" env:"EXPORTS_RETENTION"`
+}
+
+// Bulk configures bulk uploads of links to cmd/shortener
+type Bulk struct {
+	// uploads of more rows are created by jobs, smaller ones in the request
+	SyncLimit    int           `yaml:"sync_limit"    env:"BULK_SYNC_LIMIT"`
+	MaxRows      int           `yaml:"max_rows"      env:"BULK_MAX_ROWS"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"BULK_POLL_INTERVAL"`
+	// jobs running for longer fail
+	JobTimeout time.Duration `yaml:"job_timeout" env:"BULK_JOB_TIMEOUT"`
+	// how long finished jobs and their results are kept
+	Retention time.Duration `yaml:"retention" env:"BULK_RETENTION"`
+}
+
+// SMTP isn't used if Addr is empty: emails are only logged then
+type SMTP struct {
+	Addr     string `yaml:"addr"     env:"SMTP_ADDR"`
+	From     string `yaml:"from"     env:"SMTP_FROM"`
+	Username string `yaml:"username" env:"SMTP_USERNAME"`
+	Password string `yaml:"password" env:"SMTP_PASSWORD" secret:"true"`
+}
+
+type Links struct {
+	RedirectorHost  string `yaml:"redirector_host"  env:"REDIRECTOR_HOST"`
+	PasswordReset   string `yaml:"password_reset"   env:"PASSWORD_RESET_LINK"`
+	WorkspaceInvite string `yaml:"workspace_invite" env:"WORKSPACE_INVITE_LINK"`
+}
+
+// Renewal limits how far links may be renewed, by plan, and configures
+// their auto-renewal
+type Renewal struct {
+	FreeMaxLifetime time.Duration `yaml:"free_max_lifetime" env:"RENEWAL_FREE_MAX_LIFETIME"`
+	ProMaxLifetime  time.Duration `yaml:"pro_max_lifetime"  env:"RENEWAL_PRO_MAX_LIFETIME"`
+	// links with auto-renewal are renewed by AutoRenewBy when they are
+	// clicked less than AutoRenewWithin before expiring
+	AutoRenewWithin time.Duration `yaml:"auto_renew_within" env:"RENEWAL_AUTO_RENEW_WITHIN"`
+	AutoRenewBy     time.Duration `yaml:"auto_renew_by"     env:"RENEWAL_AUTO_RENEW_BY"`
+	// a link clicked over and over is checked at most once in the time
+	CheckEvery time.Duration `yaml:"check_every" env:"RENEWAL_CHECK_EVERY"`
+}
+
+func (r Renewal) Policy() renewal.Policy {
+	return renewal.Policy{
+		MaxLifetime: map[domain.Plan]time.Duration{
+			domain.PlanFree: r.FreeMaxLifetime,
+			domain.PlanPro:  r.ProMaxLifetime,
+		},
+		AutoRenewWithin: r.AutoRenewWithin,
+		AutoRenewBy:     r.AutoRenewBy,
+	}
+}
+
+type Oidc struct {
+	// names of the providers to enable, each must have a client
+	Providers       []string `yaml:"providers"         env:"OIDC_PROVIDERS"`
+	RedirectBaseUrl string   `yaml:"redirect_base_url" env:"OIDC_REDIRECT_BASE_URL"`
+	Landing         string   `yaml:"landing"           env:"OIDC_LANDING_URL"`
+	// by provider name. The environment overrides them with
+	// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET and
+	// OIDC_<NAME>_SCOPES variables.
+	Clients map[string]OidcClient `yaml:"clients"`
+}
+
+type OidcClient struct {
+	Issuer       string   `yaml:"issuer"`
+	ClientId     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret" secret:"true"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// AllInOne configures cmd/allinone. Services without an address of their own
+// are served on HTTP.Addr.
+type AllInOne struct {
+	SqlitePath        string `yaml:"sqlite_path"        env:"SQLITE_PATH"`
+	AuthenticatorAddr string `yaml:"authenticator_addr" env:"AUTHENTICATOR_ADDR"`
+	ShortenerAddr     string `yaml:"shortener_addr"     env:"SHORTENER_ADDR"`
+	ViewerAddr        string `yaml:"viewer_addr"        env:"VIEWER_ADDR"`
+	RedirectorAddr    string `yaml:"redirector_addr"    env:"REDIRECTOR_ADDR"`
+}
+
+// Default returns the settings of the docker compose setup. Binaries may
+// change them before loading.
+func Default() *Config {
+	return &Config{
+		HTTP: HTTP{
+			Addr:         ":8080",
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			IdleTimeout:  time.Minute,
+		},
+		GRPC:     GRPC{Addr: ":8080"},
+		Admin:    Admin{Addr: ":9090"},
+		Tracing:  Tracing{Exporter: "none", Endpoint: "jaeger:4317"},
+		Shutdown: Shutdown{DrainDelay: 2 * time.Second, Timeout: 10 * time.Second},
+		Cors:     Cors{Origin: middleware.DefaultCorsOrigin},
+		Redis:    Redis{Addr: "redis:6379"},
+		Blackbox: Blackbox{Addr: "blackbox:8080"},
+		Bus:      Bus{Kind: "kafka"},
+		Kafka: Kafka{
+			Brokers:        []string{"kafka:19092"},
+			FlushFrequency: 500 * time.Millisecond,
+			ConsumerGroup:  "storage",
+		},
+		Nats: Nats{
+			URL:     "nats://nats:4222",
+			Stream:  "shortener",
+			Durable: "storage",
+		},
+		Topics: Topics{
+			Urls:    "urls",
+			Users:   "users",
+			DLQ:     "storage-dlq",
+			Results: "storage-results",
+			Events:  "link-events",
+		},
+		Reaper: Reaper{Interval: time.Minute, BatchSize: 1000},
+		Reminders: Reminders{
+			Interval:       5 * time.Minute,
+			BatchSize:      1000,
+			Days:           []int{7, 1},
+			WebhookTimeout: 10 * time.Second,
+		},
+		Webhooks: Webhooks{
+			MaxAttempts:  8,
+			BaseDelay:    10 * time.Second,
+			MaxDelay:     time.Hour,
+			Timeout:      10 * time.Second,
+			BatchSize:    100,
+			PollInterval: time.Second,
+			Concurrency:  8,
+			Retention:    7 * 24 * time.Hour,
+		},
+		Exports: Exports{
+			SyncLimit:    10000,
+			PollInterval: 5 * time.Second,
+			JobTimeout:   30 * time.Minute,
+			Retention:    24 * time.Hour,
+		},
+		Bulk: Bulk{
+			SyncLimit:    1000,
+			MaxRows:      50000,
+			PollInterval: 5 * time.Second,
+			JobTimeout:   30 * time.Minute,
+			Retention:    24 * time.Hour,
+		},
+		Renewal: Renewal{
+			FreeMaxLifetime: 365 * 24 * time.Hour,
+			ProMaxLifetime:  5 * 365 * 24 * time.Hour,
+			AutoRenewWithin: 7 * 24 * time.Hour,
+			AutoRenewBy:     30 * 24 * time.Hour,
+			CheckEvery:      time.Hour,
+		},
+		Oidc: Oidc{
+			RedirectBaseUrl: "http://localhost:8080",
+			Landing:         "http://localhost:8001/",
+		},
+		AllInOne: AllInOne{SqlitePath: "shortener.db"},
+	}
+}
+
+// Validate checks the settings all binaries share. Settings only some of
+// them need are checked with Require.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.HTTP.ReadTimeout <= 0 || c.HTTP.WriteTimeout <= 0 || c.HTTP.IdleTimeout <= 0 {
+		errs = append(errs, errors.New("http timeouts must be positive"))
+	}
+	if c.Admin.Addr == "" {
+		errs = append(errs, errors.New("admin address must be set"))
+	}
+	if c.Shutdown.DrainDelay < 0 {
+		errs = append(errs, errors.New("shutdown drain delay must not be negative"))
+	}
+	if c.Shutdown.Timeout <= 0 {
+		errs = append(errs, errors.New("shutdown timeout must be positive"))
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if c.Tracing.Endpoint == "" {
+			errs = append(errs, errors.New("tracing endpoint must be set"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown tracing exporter %q, must be none, stdout or otlp", c.Tracing.Exporter))
+	}
+	if c.Cors.Origin == "" {
+		errs = append(errs, errors.New("cors origin must be set"))
+	}
+	switch c.Bus.Kind {
+	case "kafka":
+		if len(c.Kafka.Brokers) == 0 {
+			errs = append(errs, errors.New("kafka brokers must be set"))
+		}
+		if c.Kafka.FlushFrequency <= 0 {
+			errs = append(errs, errors.New("kafka flush frequency must be positive"))
+		}
+	case "nats":
+		if c.Nats.URL == "" || c.Nats.Stream == "" {
+			errs = append(errs, errors.New("nats url and stream must be set"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown bus %q, must be kafka or nats", c.Bus.Kind))
+	}
+	if c.Storage.CopyThreshold < 0 {
+		errs = append(errs, errors.New("storage copy threshold must not be negative"))
+	}
+	for _, b := range []Batch{c.Storage.UrlsBatch, c.Storage.UsersBatch, c.Storage.ClicksBatch} {
+		if b.MaxSize < 0 || b.MaxBytes < 0 || b.MaxLatency < 0 {
+			errs = append(errs, errors.New("storage batch limits must not be negative"))
+			break
+		}
+	}
+	if c.Reaper.Interval <= 0 || c.Reaper.BatchSize < 1 {
+		errs = append(errs, errors.New("reaper interval and batch size must be positive"))
+	}
+	if c.Reaper.Grace < 0 || c.Reaper.ReleaseAfter < 0 {
+		errs = append(errs, errors.New("reaper grace and release cool-down must not be negative"))
+	}
+	if c.Renewal.FreeMaxLifetime <= 0 || c.Renewal.ProMaxLifetime <= 0 {
+		errs = append(errs, errors.New("renewal max lifetimes must be positive"))
+	}
+	if c.Renewal.AutoRenewWithin <= 0 || c.Renewal.AutoRenewBy <= 0 || c.Renewal.CheckEvery <= 0 {
+		errs = append(errs, errors.New("auto-renewal window, period and check interval must be positive"))
+	}
+	if c.Reminders.Interval <= 0 || c.Reminders.BatchSize < 1 || c.Reminders.WebhookTimeout <= 0 {
+		errs = append(errs, errors.New("reminders interval, batch size and webhook timeout must be positive"))
+	}
+	for _, d := range c.Reminders.Days {
+		if d < 1 {
+			errs = append(errs, errors.New("reminder days must be positive"))
+			break
+		}
+	}
+	w := c.Webhooks
+	if w.MaxAttempts < 1 || w.BaseDelay <= 0 || w.Timeout <= 0 || w.BatchSize < 1 ||
+		w.PollInterval <= 0 || w.Concurrency < 1 || w.Retention <= 0 {
+		errs = append(errs, errors.New("webhooks attempts, delays, timeout, batch size, poll interval, concurrency and retention must be positive"))
+	}
+	if w.MaxDelay < w.BaseDelay {
+		errs = append(errs, errors.New("webhooks max delay must not be less than the base delay"))
+	}
+	if c.Exports.SyncLimit < 0 {
+		errs = append(errs, errors.New("exports sync limit must not be negative"))
+	}
+	if c.Exports.PollInterval <= 0 || c.Exports.JobTimeout <= 0 || c.Exports.Retention <= 0 {
+		errs = append(errs, errors.New("exports poll interval, job timeout and retention must be positive"))
+	}
+	if c.Bulk.SyncLimit < 0 {
+		errs = append(errs, errors.New("bulk sync limit must not be negative"))
+	}
+	if c.Bulk.MaxRows < 1 || c.Bulk.PollInterval <= 0 || c.Bulk.JobTimeout <= 0 || c.Bulk.Retention <= 0 {
+		errs = append(errs, errors.New("bulk max rows, poll interval, job timeout and retention must be positive"))
+	}
+	for _, name := range c.Oidc.Providers {
+		client := c.Oidc.Clients[name]
+		if client.Issuer == "" || client.ClientId == "" {
+			errs = append(errs, fmt.Errorf("oidc provider %s has no issuer or client id", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Require returns an error listing settings which are empty, by their path,
+// e.g. "postgres.dsn"
+func (c *Config) Require(paths ...string) error {
+	var missing []string
+	for _, path := range paths {
+		s, ok := find(c, path)
+		if !ok {
+			return fmt.Errorf("unknown setting %s", path)
+		}
+		if s.value.IsZero() {
+			name := path
+			if s.env != "" {
+				name += " (" + s.env + ")"
+			}
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required settings: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+http:
+  addr: ":9000"
+  write_timeout: 3s
+redis:
+  addr: file:6379
+kafka:
+  brokers: [a:9092, b:9092]
+storage:
+  urls_batch:
+    max_size: 10
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("REDIS_ADDR", "env:6379")
+	t.Setenv("BLACKBOX_ADDR", "env:8080")
+	t.Setenv("STORAGE_URLS_BATCH_MAX_LATENCY", "2s")
+	t.Setenv("OUTBOX_ENABLED", "true")
+	t.Setenv("REMINDERS_DAYS", "14, 3")
+
+	c := Default()
+	err := Load(c, []string{"-blackbox.addr", "flag:8080", "-outbox.enabled=false"})
+	require.NoError(t, err)
+
+	require.Equal(t, ":9000", c.HTTP.Addr)
+	require.Equal(t, 3*time.Second, c.HTTP.WriteTimeout)
+	// defaults are kept if nothing overrides them
+	require.Equal(t, 5*time.Second, c.HTTP.ReadTimeout)
+	require.Equal(t, []string{"a:9092", "b:9092"}, c.Kafka.Brokers)
+	require.Equal(t, "env:6379", c.Redis.Addr)
+	require.Equal(t, "flag:8080", c.Blackbox.Addr)
+	require.False(t, c.Outbox.Enabled)
+	require.Equal(t, Batch{MaxSize: 10, MaxLatency: 2 * time.Second}, c.Storage.UrlsBatch)
+	require.Equal(t, []int{14, 3}, c.Reminders.Days)
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Run("unknown file key", func(t *testing.T) {
+		path := writeFile(t, "redis:\n  adr: x\n")
+		require.Error(t, Load(Default(), []string{"-config", path}))
+	})
+	t.Run("invalid env", func(t *testing.T) {
+		t.Setenv("HTTP_READ_TIMEOUT", "five seconds")
+		require.ErrorContains(t, Load(Default(), nil), "HTTP_READ_TIMEOUT")
+	})
+	t.Run("invalid value", func(t *testing.T) {
+		require.ErrorContains(t, Load(Default(), []string{"-bus.kind", "rabbitmq"}), "unknown bus")
+	})
+	t.Run("oidc provider without client", func(t *testing.T) {
+		t.Setenv("OIDC_PROVIDERS", "google")
+		require.ErrorContains(t, Load(Default(), nil), "google")
+	})
+}
+
+func TestOidcEnv(t *testing.T) {
+	path := writeFile(t, `
+oidc:
+  clients:
+    google:
+      issuer: https://accounts.google.com
+      client_id: file-id
+`)
+	t.Setenv("OIDC_PROVIDERS", "google")
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "env-id")
+	t.Setenv("OIDC_GOOGLE_SCOPES", "email, profile")
+
+	c := Default()
+	require.NoError(t, Load(c, []string{"-config", path}))
+	require.Equal(t, OidcClient{
+		Issuer:   "https://accounts.google.com",
+		ClientId: "env-id",
+		Scopes:   []string{"email", "profile"},
+	}, c.Oidc.Clients["google"])
+}
+
+func TestRequire(t *testing.T) {
+	c := Default()
+	require.NoError(t, c.Require("redis.addr"))
+	require.EqualError(
+		t,
+		c.Require("postgres.dsn", "blackbox.secret"),
+		"missing required settings: postgres.dsn (POSTGRES_DSN), blackbox.secret (BLACKBOX_SECRET)",
+	)
+}
+
+func TestLogRedactsSecrets(t *testing.T) {
+	c := Default()
+	c.Postgres.DSN = "postgres://server:pwd@db:5432/shortener"
+	c.Blackbox.Secret = "some secret key"
+	c.Oidc.Clients = map[string]OidcClient{"google": {ClientSecret: "client secret"}}
+
+	var buf bytes.Buffer
+	log := zerolog.New(&buf)
+	c.Log(&log)
+
+	out := buf.String()
+	require.NotContains(t, out, "pwd")
+	require.NotContains(t, out, "some secret key")
+	require.NotContains(t, out, "client secret")
+	require.Contains(t, out, `"postgres.dsn":"postgres://server:xxxxx@db:5432/shortener"`)
+	require.Contains(t, out, `"redis.addr":"redis:6379"`)
+}
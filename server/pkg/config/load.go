@@ -0,0 +1,288 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// Load overrides settings of c with the file, the environment and the
+// command line arguments, and validates them
+func Load(c *Config, args []string) error {
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML file with settings (env CONFIG_FILE)")
+	ss := settings(c)
+	flags := make([]*flagValue, len(ss))
+	for i, s := range ss {
+		usage := "see the config file"
+		if s.env != "" {
+			usage = "env " + s.env
+		}
+		flags[i] = &flagValue{def: display(s), bool: s.value.Kind() == reflect.Bool}
+		fs.Var(flags[i], s.path, usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *file != "" {
+		if err := readFile(c, *file); err != nil {
+			return fmt.Errorf("couldn't read %s: %w", *file, err)
+		}
+	}
+
+	for _, s := range ss {
+		if s.env == "" {
+			continue
+		}
+		if v := os.Getenv(s.env); v != "" {
+			if err := set(s.value, v); err != nil {
+				return fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+
+	for i, s := range ss {
+		if flags[i].raw == nil {
+			continue
+		}
+		if err := set(s.value, *flags[i].raw); err != nil {
+			return fmt.Errorf("invalid -%s: %w", s.path, err)
+		}
+	}
+
+	oidcEnv(c)
+	return c.Validate()
+}
+
+// flagValue keeps the argument until the file and the environment are loaded
+type flagValue struct {
+	def  string
+	bool bool
+	raw  *string
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+func (f *flagValue) Set(s string) error {
+	f.raw = &s
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.bool
+}
+
+func readFile(c *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// oidcEnv overrides clients of the enabled providers with OIDC_<NAME>_*
+// variables
+func oidcEnv(c *Config) {
+	for _, name := range c.Oidc.Providers {
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		client := c.Oidc.Clients[name]
+		if v := os.Getenv(prefix + "ISSUER"); v != "" {
+			client.Issuer = v
+		}
+		if v := os.Getenv(prefix + "CLIENT_ID"); v != "" {
+			client.ClientId = v
+		}
+		if v := os.Getenv(prefix + "CLIENT_SECRET"); v != "" {
+			client.ClientSecret = v
+		}
+		if v := os.Getenv(prefix + "SCOPES"); v != "" {
+			client.Scopes = split(v)
+		}
+		if c.Oidc.Clients == nil {
+			c.Oidc.Clients = make(map[string]OidcClient)
+		}
+		c.Oidc.Clients[name] = client
+	}
+}
+
+// Log prints the effective settings with secrets redacted
+func (c *Config) Log(log *zerolog.Logger) {
+	e := log.Info()
+	for _, s := range settings(c) {
+		e = e.Str(s.path, display(s))
+	}
+
+	names := make([]string, 0, len(c.Oidc.Clients))
+	for name := range c.Oidc.Clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// map values aren't addressable
+		client := c.Oidc.Clients[name]
+		var ss []setting
+		walk(reflect.ValueOf(&client).Elem(), "oidc.clients."+name, "", &ss)
+		for _, s := range ss {
+			e = e.Str(s.path, display(s))
+		}
+	}
+	e.Msg("loaded config")
+}
+
+type setting struct {
+	// e.g. http.addr
+	path   string
+	env    string
+	secret string
+	value  reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func settings(c *Config) []setting {
+	var ss []setting
+	walk(reflect.ValueOf(c).Elem(), "", "", &ss)
+	return ss
+}
+
+// walk collects the settings of the struct. The env tag of a nested struct
+// is the prefix of variables of its fields. Maps are skipped.
+func walk(v reflect.Value, path string, envPrefix string, ss *[]setting) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if path != "" {
+			name = path + "." + name
+		}
+		env := f.Tag.Get("env")
+
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			walk(v.Field(i), name, envPrefix+env, ss)
+		case f.Type.Kind() == reflect.Map:
+		default:
+			if env != "" {
+				env = envPrefix + env
+			}
+			*ss = append(*ss, setting{
+				path:   name,
+				env:    env,
+				secret: f.Tag.Get("secret"),
+				value:  v.Field(i),
+			})
+		}
+	}
+}
+
+func find(c *Config, path string) (setting, bool) {
+	for _, s := range settings(c) {
+		if s.path == path {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+func set(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		v.Set(reflect.ValueOf(split(s)))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Int:
+		var ns []int
+		for _, part := range split(s) {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return err
+			}
+			ns = append(ns, n)
+		}
+		v.Set(reflect.ValueOf(ns))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// split parses comma separated lists
+func split(s string) []string {
+	var res []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			res = append(res, part)
+		}
+	}
+	return res
+}
+
+func display(s setting) string {
+	if s.value.IsZero() {
+		return ""
+	}
+	switch s.secret {
+	case "true":
+		return "<redacted>"
+	case "url":
+		u, err := url.Parse(s.value.String())
+		if err != nil || u.Scheme == "" {
+			return "<redacted>"
+		}
+		return u.Redacted()
+	}
+	if ns, ok := s.value.Interface().([]int); ok {
+		parts := make([]string, len(ns))
+		for i, n := range ns {
+			parts[i] = strconv.Itoa(n)
+		}
+		return strings.Join(parts, ",")
+	}
+	if s.value.Kind() == reflect.Slice {
+		return strings.Join(s.value.Interface().([]string), ",")
+	}
+	return fmt.Sprint(s.value.Interface())
+}
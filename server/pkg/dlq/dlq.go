@@ -0,0 +1,111 @@
+// Package dlq describes messages of the dead-letter topic: messages that
+// couldn't be processed, along with the reason and their original location.
+package dlq
+
+import (
+	"shortener/pkg/bus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderTopic     = "dlq-original-topic"
+	HeaderPartition = "dlq-original-partition"
+	HeaderOffset    = "dlq-original-offset"
+	HeaderError     = "dlq-error"
+	HeaderAttempts  = "dlq-attempts"
+	HeaderFailedAt  = "dlq-failed-at"
+
+	headerPrefix = "dlq-"
+)
+
+// Message wraps the failed message for sending to the dead-letter topic.
+// Original headers are kept.
+func Message(
+	topic string,
+	failed *bus.Message,
+	cause error,
+	attempts int,
+) *bus.Message {
+	headers := make(map[string]string, len(failed.Headers)+6)
+	for k, v := range failed.Headers {
+		headers[k] = v
+	}
+	headers[HeaderTopic] = failed.Topic
+	headers[HeaderPartition] = strconv.FormatInt(int64(failed.Partition), 10)
+	headers[HeaderOffset] = strconv.FormatInt(failed.Offset, 10)
+	headers[HeaderError] = cause.Error()
+	headers[HeaderAttempts] = strconv.Itoa(attempts)
+	headers[HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339)
+
+	return &bus.Message{
+		Topic:   topic,
+		Key:     failed.Key,
+		Value:   failed.Value,
+		Headers: headers,
+	}
+}
+
+// Entry is a message read from the dead-letter topic
+type Entry struct {
+	// location in the dead-letter topic
+	Partition int32 `json:"partition"`
+	Offset    int64 `json:"offset"`
+
+	OriginalTopic     string    `json:"original_topic"`
+	OriginalPartition int32     `json:"original_partition"`
+	OriginalOffset    int64     `json:"original_offset"`
+	Error             string    `json:"error"`
+	Attempts          int       `json:"attempts"`
+	FailedAt          time.Time `json:"failed_at"`
+
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value"`
+	// headers of the original message
+	Headers map[string]string `json:"-"`
+}
+
+func Parse(msg *bus.Message) *Entry {
+	e := &Entry{
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+	}
+	for k, value := range msg.Headers {
+		switch strings.ToLower(k) {
+		case HeaderTopic:
+			e.OriginalTopic = value
+		case HeaderPartition:
+			p, _ := strconv.ParseInt(value, 10, 32)
+			e.OriginalPartition = int32(p)
+		case HeaderOffset:
+			e.OriginalOffset, _ = strconv.ParseInt(value, 10, 64)
+		case HeaderError:
+			e.Error = value
+		case HeaderAttempts:
+			e.Attempts, _ = strconv.Atoi(value)
+		case HeaderFailedAt:
+			e.FailedAt, _ = time.Parse(time.RFC3339, value)
+		default:
+			if !strings.HasPrefix(strings.ToLower(k), headerPrefix) {
+				if e.Headers == nil {
+					e.Headers = map[string]string{}
+				}
+				e.Headers[k] = value
+			}
+		}
+	}
+	return e
+}
+
+// Replay builds the message to be sent back to the original topic
+func (e *Entry) Replay() *bus.Message {
+	return &bus.Message{
+		Topic:   e.OriginalTopic,
+		Key:     e.Key,
+		Value:   e.Value,
+		Headers: e.Headers,
+	}
+}
@@ -0,0 +1,51 @@
+package domain
+
+import "time"
+
+// BulkLink is a row of a bulk upload of links. Zero values are replaced by
+// defaults: a generated short url and an expiration in 30 days.
+type BulkLink struct {
+	Url   string `json:"url"`
+	Alias string `json:"alias,omitempty"`
+	// days the link is kept for
+	Expiration int      `json:"expiration,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+}
+
+// BulkResult is the outcome of a row of a bulk upload: the short url of the
+// created link or the reason it hasn't been created
+type BulkResult struct {
+	// rows are numbered from 1, not counting the header of CSV uploads
+	Row      int    `json:"row"`
+	ShortUrl string `json:"short_url,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type BulkStatus string
+
+const (
+	BulkPending BulkStatus = "pending"
+	BulkRunning BulkStatus = "running"
+	BulkDone    BulkStatus = "done"
+	BulkFailed  BulkStatus = "failed"
+)
+
+// BulkJob creates links of a bulk upload too large to be created in a
+// request. Results are only returned once the job is finished.
+type BulkJob struct {
+	Id          string       `json:"id"`
+	UserId      string       `json:"-"`
+	WorkspaceId string       `json:"-"`
+	Links       []BulkLink   `json:"-"`
+	Status      BulkStatus   `json:"status"`
+	Error       string       `json:"error,omitempty"`
+	Total       int          `json:"total"`
+	Processed   int          `json:"processed"`
+	Succeeded   int          `json:"succeeded"`
+	Failed      int          `json:"failed"`
+	Results     []BulkResult `json:"results,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	FinishedAt  *time.Time   `json:"finished_at,omitempty"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+}
@@ -0,0 +1,7 @@
+package domain
+
+import "errors"
+
+// ErrConflict is returned when data can't be stored because it conflicts
+// with data stored earlier, e.g. the short url or the email is taken
+var ErrConflict = errors.New("conflicts with existing data")
@@ -0,0 +1,54 @@
+package domain
+
+import "time"
+
+type ExportFormat string
+
+const (
+	ExportCSV     ExportFormat = "csv"
+	ExportNDJSON  ExportFormat = "ndjson"
+	ExportParquet ExportFormat = "parquet"
+)
+
+type ExportStatus string
+
+const (
+	ExportPending ExportStatus = "pending"
+	ExportRunning ExportStatus = "running"
+	ExportDone    ExportStatus = "done"
+	ExportFailed  ExportStatus = "failed"
+)
+
+// ExportJob exports links of the history query into a file that's
+// downloaded once the job is done
+type ExportJob struct {
+	Id          string       `json:"id"`
+	UserId      string       `json:"-"`
+	WorkspaceId string       `json:"-"`
+	Format      ExportFormat `json:"format"`
+	// click aggregates of the links are exported too
+	Clicks bool         `json:"clicks"`
+	Query  HistoryQuery `json:"-"`
+	Status ExportStatus `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Rows   int64        `json:"rows"`
+	// size of the file in bytes
+	Size int64 `json:"size"`
+	// number of chunks the file is stored in
+	Chunks     int        `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	// set once the job is done
+	DownloadUrl string `json:"download_url,omitempty"`
+}
+
+// ClickStats are click aggregates of a link
+type ClickStats struct {
+	Total      int64
+	Last7Days  int64
+	Last30Days int64
+	// UTC date of the last click, nil if the link hasn't been clicked since
+	// clicks are counted by day
+	LastClickedOn *time.Time
+}
@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"regexp"
+	"time"
+)
+
+// tags are short lowercase labels, so that filters match them exactly
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ValidTag reports whether the tag may be put on a link
+func ValidTag(tag string) bool {
+	return tagPattern.MatchString(tag)
+}
+
+// HistorySort is the order links of the history are listed in
+type HistorySort string
+
+const (
+	SortCreated    HistorySort = "created"
+	SortExpiration HistorySort = "expiration"
+	SortClicks     HistorySort = "clicks"
+)
+
+// LinkStatus filters links of the history
+type LinkStatus string
+
+const (
+	// links that haven't expired and haven't been disabled
+	StatusActive LinkStatus = "active"
+	// links that have expired and haven't been archived yet
+	StatusExpired  LinkStatus = "expired"
+	StatusDisabled LinkStatus = "disabled"
+)
+
+// HistoryQuery selects a page of links of the workspace, or of personal
+// links of the user if WorkspaceId is empty. Zero values of the filters
+// match every link. Exports keep the sort and the filters of their queries
+// as JSON.
+type HistoryQuery struct {
+	UserId      string `json:"-"`
+	WorkspaceId string `json:"-"`
+
+	Sort HistorySort `json:"sort"`
+	Desc bool        `json:"desc,omitempty"`
+	// the last link of the previous page, nil for the first page
+	After *HistoryCursor `json:"-"`
+	Limit int            `json:"-"`
+
+	Status LinkStatus `json:"status,omitempty"`
+	// host of the long url
+	Domain        string    `json:"domain,omitempty"`
+	Tag           string    `json:"tag,omitempty"`
+	CreatedAfter  time.Time `json:"created_after,omitempty"`
+	CreatedBefore time.Time `json:"created_before,omitempty"`
+	// words looked up in long urls and titles
+	Search string `json:"search,omitempty"`
+}
+
+// HistoryCursor is the position of a link in the history. Only the value
+// of the sort the cursor has been made for is set.
+type HistoryCursor struct {
+	CreatedAt      time.Time `json:"c,omitempty"`
+	ExpirationDate time.Time `json:"e,omitempty"`
+	Clicks         int64     `json:"n,omitempty"`
+	ShortUrl       string    `json:"s"`
+}
+
+// Cursor returns the position of the link in the history sorted by sort
+func (u *UrlInfo) Cursor(sort HistorySort) *HistoryCursor {
+	c := &HistoryCursor{ShortUrl: u.ShortUrl}
+	switch sort {
+	case SortExpiration:
+		c.ExpirationDate = u.ExpirationDate
+	case SortClicks:
+		c.Clicks = u.Clicks
+	default:
+		c.CreatedAt = u.CreatedAt
+	}
+	return c
+}
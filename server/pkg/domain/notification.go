@@ -0,0 +1,39 @@
+package domain
+
+import "time"
+
+// AnonymousUserId owns links created without signing in
+const AnonymousUserId = "db092ed4-306a-4d4f-be5f-fd2f1487edbe"
+
+// NotificationChannel is how users are notified
+type NotificationChannel string
+
+const (
+	ChannelEmail   NotificationChannel = "email"
+	ChannelWebhook NotificationChannel = "webhook"
+)
+
+// NotificationPreferences of a user. Users who haven't set them are emailed
+// at the default reminder days.
+type NotificationPreferences struct {
+	Enabled bool                `json:"enabled"`
+	Channel NotificationChannel `json:"channel"`
+	// where webhook notifications are posted to
+	WebhookUrl string `json:"webhook_url,omitempty"`
+	// days before expiration links are reminded about at
+	ReminderDays []int `json:"reminder_days"`
+}
+
+// Reminder about a link expiring within Days, addressed to its owner
+type Reminder struct {
+	ShortUrl       string
+	LongUrl        string
+	ExpirationDate time.Time
+	Days           int
+
+	UserId     string
+	Email      string
+	Name       string
+	Channel    NotificationChannel
+	WebhookUrl string
+}
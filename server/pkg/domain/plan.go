@@ -0,0 +1,10 @@
+package domain
+
+// Plan of a user or a workspace. Plans limit how long links may live, links
+// of a workspace are limited by the plan of the workspace.
+type Plan string
+
+const (
+	PlanFree Plan = "free"
+	PlanPro  Plan = "pro"
+)
@@ -1,9 +1,68 @@
 package domain
 
-import "time"
+import (
+	"regexp"
+	"time"
+)
+
+// short urls are generated 5 characters long, aliases users choose may be
+// longer
+var shortUrlPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,32}$`)
+
+// first segments of the routes of the services, which would shadow links on
+// a shared mux, see cmd/allinone
+var reservedShortUrls = map[string]struct{}{
+	"bulk":             {},
+	"create_short_url": {},
+	"export":           {},
+	"exports":          {},
+	"healthz":          {},
+	"history":          {},
+	"jwks":             {},
+	"links":            {},
+	"login":            {},
+	"metrics":          {},
+	"mfa":              {},
+	"notifications":    {},
+	"oidc":             {},
+	"password":         {},
+	"readyz":           {},
+	"signup":           {},
+	"token":            {},
+	"webhooks":         {},
+	"workspaces":       {},
+}
+
+// ValidShortUrl reports whether the short url may be taken by a link
+func ValidShortUrl(shortUrl string) bool {
+	if _, ok := reservedShortUrls[shortUrl]; ok {
+		return false
+	}
+	return shortUrlPattern.MatchString(shortUrl)
+}
 
 type UrlInfo struct {
 	ShortUrl       string    `json:"short_url"`
 	LongUrl        string    `json:"long_url"`
 	ExpirationDate time.Time `json:"expiration_date"`
+	CreatedAt      time.Time `json:"created_at"`
+	Title          string    `json:"title,omitempty"`
+	Tags           []string  `json:"tags"`
+	// only filters the history, disabled links still redirect
+	Disabled bool  `json:"disabled"`
+	Clicks   int64 `json:"clicks"`
+}
+
+// Link is a stored link with the settings of its renewal
+type Link struct {
+	ShortUrl string
+	LongUrl  string
+	UserId   string
+	// empty for links outside of workspaces
+	WorkspaceId    string
+	ExpirationDate time.Time
+	// the link is renewed when it's clicked shortly before expiring
+	AutoRenew bool
+	// plan of the workspace or of the user the link belongs to
+	Plan Plan
 }
@@ -0,0 +1,99 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// EventType is a kind of events of links webhooks are subscribed to
+type EventType string
+
+const (
+	EventLinkCreated EventType = "link.created"
+	EventLinkClicked EventType = "link.clicked"
+	EventLinkExpired EventType = "link.expired"
+	EventLinkDeleted EventType = "link.deleted"
+)
+
+// EventTypes are all the event types in the order they are documented
+var EventTypes = []EventType{
+	EventLinkCreated,
+	EventLinkClicked,
+	EventLinkExpired,
+	EventLinkDeleted,
+}
+
+// LinkEvent is what happened to a link. It's the payload of webhook
+// deliveries.
+type LinkEvent struct {
+	// unique id of the event. Receivers tell redeliveries with it
+	Id       string    `json:"id"`
+	Type     EventType `json:"type"`
+	ShortUrl string    `json:"short_url"`
+	LongUrl  string    `json:"long_url,omitempty"`
+	UserId   string    `json:"user_id"`
+	// empty for links outside of workspaces
+	WorkspaceId    string     `json:"workspace_id,omitempty"`
+	ExpirationDate *time.Time `json:"expiration_date,omitempty"`
+	OccurredAt     time.Time  `json:"occurred_at"`
+}
+
+// GetIdempotencyKey returns the id of the event
+func (e *LinkEvent) GetIdempotencyKey() string {
+	return e.Id
+}
+
+// WebhookSubscription posts events of the given types to the url. It
+// belongs to a workspace, or to the user if WorkspaceId is empty.
+type WebhookSubscription struct {
+	Id          string `json:"id"`
+	UserId      string `json:"user_id"`
+	WorkspaceId string `json:"workspace_id,omitempty"`
+	Url         string `json:"url"`
+	// signs deliveries. It's only shown when the subscription is created
+	Secret     string      `json:"secret,omitempty"`
+	EventTypes []EventType `json:"event_types"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// Subscribed reports whether the subscription wants events of the type
+func (s *WebhookSubscription) Subscribed(t EventType) bool {
+	for _, et := range s.EventTypes {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
+
+type DeliveryStatus string
+
+const (
+	// the delivery hasn't succeeded yet and will be attempted again
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// attempts have run out, the delivery is given up on
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+// WebhookDelivery is an event to be posted to a subscription, along with
+// the outcome of its last attempt
+type WebhookDelivery struct {
+	Id             string          `json:"id"`
+	SubscriptionId string          `json:"subscription_id"`
+	EventId        string          `json:"event_id"`
+	EventType      EventType       `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         DeliveryStatus  `json:"status"`
+	Attempts       int             `json:"attempts"`
+	// status code of the last response, zero if there hasn't been one
+	ResponseStatus int        `json:"response_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+
+	// where the delivery is posted to, set on deliveries being dispatched
+	Url    string `json:"-"`
+	Secret string `json:"-"`
+}
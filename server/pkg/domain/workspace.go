@@ -0,0 +1,64 @@
+package domain
+
+// Role of a workspace member. Every role is allowed everything the roles
+// below it are: viewers can see links of the workspace, editors can also
+// create and change them, admins can also manage members, owners can also
+// manage other owners.
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+	RoleOwner  Role = "owner"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+	RoleOwner:  4,
+}
+
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Allows reports whether the role grants at least the permissions of the
+// required one. Unknown roles allow nothing.
+func (r Role) Allows(required Role) bool {
+	return r.Valid() && required.Valid() && roleRanks[r] >= roleRanks[required]
+}
+
+// Session is who a request is made by, e.g. blackbox.ValidateTokenRsp.
+// Sessions are in the workspace the user has switched to, if any.
+type Session interface {
+	GetUserId() string
+	GetWorkspaceId() string
+}
+
+// Owns reports whether what the user has created in the workspace, empty
+// for personal things, belongs to the session. Things of a workspace
+// belong to sessions in it, personal ones to sessions of their users
+// outside of workspaces.
+func Owns(s Session, userId string, workspaceId string) bool {
+	if s.GetWorkspaceId() != "" {
+		return workspaceId == s.GetWorkspaceId()
+	}
+	return workspaceId == "" && userId == s.GetUserId()
+}
+
+type Workspace struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// role of the user the workspace has been listed for
+	Role Role `json:"role"`
+}
+
+type Member struct {
+	UserId string `json:"user_id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+	Role   Role   `json:"role"`
+}
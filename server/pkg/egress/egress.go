@@ -0,0 +1,113 @@
+// Package egress sends requests to urls chosen by users, e.g. webhooks.
+// They must reach neither other services nor the admin ports or metadata
+// endpoints of the internal network, so requests only connect to public
+// addresses and don't follow redirects.
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ErrNotPublic = errors.New("address isn't public")
+
+var (
+	// addresses of this network
+	thisNetwork = netip.MustParsePrefix("0.0.0.0/8")
+	// addresses shared by carrier-grade NATs
+	sharedNetwork = netip.MustParsePrefix("100.64.0.0/10")
+)
+
+// Public reports whether the address may be connected to: loopback,
+// private, link-local, unspecified and multicast addresses may not
+func Public(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() &&
+		!addr.IsLoopback() &&
+		!addr.IsPrivate() &&
+		!addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() &&
+		!addr.IsMulticast() &&
+		!addr.IsUnspecified() &&
+		!thisNetwork.Contains(addr) &&
+		!sharedNetwork.Contains(addr)
+}
+
+// control refuses connections to addresses that aren't public. It runs
+// after names are resolved, so names can't point at internal hosts either.
+func control(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !Public(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrNotPublic, addrPort.Addr())
+	}
+	return nil
+}
+
+// NewClient returns the client that only connects to public addresses.
+// Redirects aren't followed, they are returned as responses.
+func NewClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// proxies would connect to addresses that aren't checked
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   control,
+	}).DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// CheckUrl returns ErrNotPublic if the host of the url is an address that
+// isn't public, a name of the internal network, e.g. localhost or a single
+// label like blackbox, or a name resolving to such addresses. Names that
+// don't resolve are accepted: clients of NewClient check them again on
+// every connection.
+func CheckUrl(ctx context.Context, rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !Public(addr) {
+			return fmt.Errorf("%w: %s", ErrNotPublic, addr)
+		}
+		return nil
+	}
+
+	name := strings.TrimSuffix(strings.ToLower(host), ".")
+	if !strings.Contains(name, ".") ||
+		strings.HasSuffix(name, ".localhost") ||
+		strings.HasSuffix(name, ".local") ||
+		strings.HasSuffix(name, ".internal") {
+		return fmt.Errorf("%w: %s", ErrNotPublic, host)
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", name)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if !Public(addr) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrNotPublic, host, addr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package egress
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublic(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.215.14":        true,
+		"2606:2800:21f::1":     true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"fd00::1":              false,
+		"169.254.169.254":      false,
+		"fe80::1":              false,
+		"0.0.0.0":              false,
+		"0.1.2.3":              false,
+		"::":                   false,
+		"100.64.0.1":           false,
+		"224.0.0.1":            false,
+		"::ffff:127.0.0.1":     false,
+		"::ffff:93.184.215.14": true,
+	} {
+		assert.Equal(t, public, Public(netip.MustParseAddr(addr)), addr)
+	}
+}
+
+func TestCheckUrl(t *testing.T) {
+	for rawUrl, public := range map[string]bool{
+		"https://93.184.215.14/hook":              true,
+		"http://127.0.0.1:8080/hook":              false,
+		"http://[::1]/hook":                       false,
+		"http://169.254.169.254/latest/meta-data": false,
+		"http://10.0.0.5/hook":                    false,
+		"http://localhost:8080/hook":              false,
+		"http://api.localhost/hook":               false,
+		"http://blackbox:8080/hook":               false,
+		"http://metadata.google.internal/":        false,
+		"http://printer.local/":                   false,
+	} {
+		err := CheckUrl(context.Background(), rawUrl)
+		if public {
+			assert.NoError(t, err, rawUrl)
+		} else {
+			assert.ErrorIs(t, err, ErrNotPublic, rawUrl)
+		}
+	}
+}
+
+func TestClientRefusesInternalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the test server listening on the loopback has been reached")
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(0).Post(srv.URL, "application/json", nil)
+	assert.ErrorIs(t, err, ErrNotPublic)
+}
+
+func TestClientReturnsRedirects(t *testing.T) {
+	redirected := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the redirect has been followed")
+	}))
+	defer redirected.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, redirected.URL, http.StatusFound)
+	}))
+	defer srv.Close()
+
+	c := NewClient(0)
+	// the test servers listen on the loopback
+	c.Transport = srv.Client().Transport
+	rsp, err := c.Post(srv.URL, "application/json", nil)
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, http.StatusFound, rsp.StatusCode)
+}
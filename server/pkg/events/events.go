@@ -0,0 +1,158 @@
+// Package events encodes and decodes messages of the urls, users and link
+// events topics.
+// Messages are protobuf events of proto/events. Messages without the
+// content type header are JSON produced before the migration to protobuf.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"shortener/pkg/bus"
+	"shortener/pkg/domain"
+	"shortener/pkg/responses"
+	eventsv1 "shortener/proto/events/v1"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	HeaderContentType = "content-type"
+
+	ContentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+// ContentType of the protobuf event
+func ContentType(m proto.Message) string {
+	return contentTypeProtobuf + "; messageType=" +
+		string(m.ProtoReflect().Descriptor().FullName())
+}
+
+// Encode marshals the event and returns its content type
+func Encode(m proto.Message) ([]byte, string, error) {
+	value, err := proto.Marshal(m)
+	if err != nil {
+		return nil, "", err
+	}
+	return value, ContentType(m), nil
+}
+
+// DecodeLink decodes a message of the urls topic of any supported version
+func DecodeLink(mes *bus.Message) (*responses.Shortener, error) {
+	switch ct := mes.Header(HeaderContentType); ct {
+	case "", ContentTypeJSON:
+		var r responses.Shortener
+		if err := json.Unmarshal(mes.Value, &r); err != nil {
+			return nil, err
+		}
+		return &r, nil
+	case ContentType(&eventsv1.LinkCreated{}):
+		var e eventsv1.LinkCreated
+		if err := proto.Unmarshal(mes.Value, &e); err != nil {
+			return nil, err
+		}
+		return &responses.Shortener{
+			From:           e.UserId,
+			ShortUrl:       e.ShortUrl,
+			LongUrl:        e.LongUrl,
+			ExpirationDate: e.ExpirationDate.AsTime(),
+			WorkspaceId:    e.WorkspaceId,
+			IdempotencyKey: e.IdempotencyKey,
+			Title:          e.Title,
+			Tags:           e.Tags,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", ct)
+	}
+}
+
+// DecodeUser decodes a message of the users topic of any supported version
+func DecodeUser(mes *bus.Message) (*responses.Authenticator, error) {
+	switch ct := mes.Header(HeaderContentType); ct {
+	case "", ContentTypeJSON:
+		var r responses.Authenticator
+		if err := json.Unmarshal(mes.Value, &r); err != nil {
+			return nil, err
+		}
+		return &r, nil
+	case ContentType(&eventsv1.UserRegistered{}):
+		var e eventsv1.UserRegistered
+		if err := proto.Unmarshal(mes.Value, &e); err != nil {
+			return nil, err
+		}
+		return &responses.Authenticator{
+			Id:             e.Id,
+			Name:           e.Name,
+			Email:          e.Email,
+			HashedPassword: e.HashedPassword,
+			IdempotencyKey: e.IdempotencyKey,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", ct)
+	}
+}
+
+// DecodeLinkEvent decodes a message of the urls or the link events topic.
+// Owners of clicked and expired links aren't known to their producers, so
+// UserId of these events is empty.
+func DecodeLinkEvent(mes *bus.Message) (*domain.LinkEvent, error) {
+	switch ct := mes.Header(HeaderContentType); ct {
+	case ContentType(&eventsv1.LinkClicked{}):
+		var e eventsv1.LinkClicked
+		if err := proto.Unmarshal(mes.Value, &e); err != nil {
+			return nil, err
+		}
+		return &domain.LinkEvent{
+			Id:         e.IdempotencyKey,
+			Type:       domain.EventLinkClicked,
+			ShortUrl:   e.ShortUrl,
+			OccurredAt: e.ClickedAt.AsTime(),
+		}, nil
+	case ContentType(&eventsv1.LinkExpired{}):
+		var e eventsv1.LinkExpired
+		if err := proto.Unmarshal(mes.Value, &e); err != nil {
+			return nil, err
+		}
+		return &domain.LinkEvent{
+			Id:         e.IdempotencyKey,
+			Type:       domain.EventLinkExpired,
+			ShortUrl:   e.ShortUrl,
+			OccurredAt: e.ArchivedAt.AsTime(),
+		}, nil
+	case ContentType(&eventsv1.LinkDeleted{}):
+		var e eventsv1.LinkDeleted
+		if err := proto.Unmarshal(mes.Value, &e); err != nil {
+			return nil, err
+		}
+		return &domain.LinkEvent{
+			Id:          e.IdempotencyKey,
+			Type:        domain.EventLinkDeleted,
+			ShortUrl:    e.ShortUrl,
+			LongUrl:     e.LongUrl,
+			UserId:      e.UserId,
+			WorkspaceId: e.WorkspaceId,
+			OccurredAt:  e.DeletedAt.AsTime(),
+		}, nil
+	}
+
+	link, err := DecodeLink(mes)
+	if err != nil {
+		return nil, err
+	}
+	occurredAt := mes.Timestamp
+	if occurredAt.IsZero() {
+		occurredAt = time.Now()
+	}
+	expiration := link.ExpirationDate
+	return &domain.LinkEvent{
+		Id:             link.IdempotencyKey,
+		Type:           domain.EventLinkCreated,
+		ShortUrl:       link.ShortUrl,
+		LongUrl:        link.LongUrl,
+		UserId:         link.From,
+		WorkspaceId:    link.WorkspaceId,
+		ExpirationDate: &expiration,
+		OccurredAt:     occurredAt,
+	}, nil
+}
@@ -0,0 +1,302 @@
+package events
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"shortener/pkg/bus"
+	"shortener/pkg/domain"
+	"shortener/pkg/responses"
+	eventsv1 "shortener/proto/events/v1"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var update = flag.Bool("update", false, "update the schema snapshot and golden messages")
+
+type fieldSchema struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	Cardinality string `json:"cardinality"`
+	// full name of message and enum types
+	Type string `json:"type,omitempty"`
+}
+
+// messages by full name, fields by number
+type schema map[string]map[protoreflect.FieldNumber]fieldSchema
+
+func describe(fd protoreflect.FileDescriptor) schema {
+	res := schema{}
+	messages := fd.Messages()
+	for i := 0; i < messages.Len(); i++ {
+		m := messages.Get(i)
+		fields := map[protoreflect.FieldNumber]fieldSchema{}
+		for j := 0; j < m.Fields().Len(); j++ {
+			f := m.Fields().Get(j)
+			s := fieldSchema{
+				Name:        string(f.Name()),
+				Kind:        f.Kind().String(),
+				Cardinality: f.Cardinality().String(),
+			}
+			if f.Message() != nil {
+				s.Type = string(f.Message().FullName())
+			}
+			if f.Enum() != nil {
+				s.Type = string(f.Enum().FullName())
+			}
+			fields[f.Number()] = s
+		}
+		res[string(m.FullName())] = fields
+	}
+	return res
+}
+
+// TestSchemaCompatibility fails when a released field of the events schema
+// is removed without being reserved, or its name or type is changed. Run
+// with -update after adding fields or messages.
+func TestSchemaCompatibility(t *testing.T) {
+	fd := eventsv1.File_proto_events_v1_events_proto
+	current := describe(fd)
+	path := filepath.Join("testdata", "events_v1_schema.json")
+
+	if *update {
+		data, _ := json.MarshalIndent(current, "", "  ")
+		assert.Nil(t, os.WriteFile(path, append(data, '\n'), 0o644))
+	}
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	var released schema
+	assert.Nil(t, json.Unmarshal(data, &released))
+
+	for name, fields := range released {
+		m := fd.Messages().ByName(protoreflect.FullName(name).Name())
+		if !assert.NotNil(t, m, "message %s has been removed", name) {
+			continue
+		}
+
+		for number, old := range fields {
+			f, ok := current[name][number]
+			if !ok {
+				assert.True(
+					t,
+					m.ReservedRanges().Has(number) &&
+						m.ReservedNames().Has(protoreflect.Name(old.Name)),
+					"field %s.%s (%d) has been removed but not reserved",
+					name,
+					old.Name,
+					number,
+				)
+				continue
+			}
+			assert.Equal(
+				t,
+				old,
+				f,
+				"field %s.%s (%d) has been changed",
+				name,
+				old.Name,
+				number,
+			)
+		}
+	}
+
+	for name, fields := range current {
+		for number, f := range fields {
+			if _, ok := released[name][number]; !ok {
+				t.Errorf(
+					"field %s.%s (%d) isn't in the snapshot. run the test with -update",
+					name,
+					f.Name,
+					number,
+				)
+			}
+		}
+	}
+}
+
+var expiration = time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+var linkV1 = &eventsv1.LinkCreated{
+	IdempotencyKey: "0b6f4a50-5d0e-4f5e-9f55-7b1c1c1e2c3d",
+	ShortUrl:       "abcde",
+	LongUrl:        "https://example.com",
+	UserId:         "db092ed4-306a-4d4f-be5f-fd2f1487edbe",
+	ExpirationDate: timestamppb.New(expiration),
+	WorkspaceId:    "5a0c8a4e-0d1f-4b8e-8d0b-3a1d8f1f2e4b",
+}
+
+var expectedLink = &responses.Shortener{
+	From:           "db092ed4-306a-4d4f-be5f-fd2f1487edbe",
+	ShortUrl:       "abcde",
+	LongUrl:        "https://example.com",
+	ExpirationDate: expiration,
+	WorkspaceId:    "5a0c8a4e-0d1f-4b8e-8d0b-3a1d8f1f2e4b",
+	IdempotencyKey: "0b6f4a50-5d0e-4f5e-9f55-7b1c1c1e2c3d",
+}
+
+func message(contentType string, value []byte) *bus.Message {
+	mes := &bus.Message{Value: value}
+	if contentType != "" {
+		mes.Headers = map[string]string{HeaderContentType: contentType}
+	}
+	return mes
+}
+
+// TestDecodeGoldenLink makes sure links encoded by released producers can
+// still be decoded
+func TestDecodeGoldenLink(t *testing.T) {
+	path := filepath.Join("testdata", "link_created_v1.bin")
+	if *update {
+		data, err := proto.Marshal(linkV1)
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(path, data, 0o644))
+	}
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+
+	link, err := DecodeLink(message(ContentType(&eventsv1.LinkCreated{}), data))
+	assert.Nil(t, err)
+	assert.Equal(t, expectedLink, link)
+}
+
+func TestDecodeLink(t *testing.T) {
+	encoded, contentType, err := Encode(linkV1)
+	assert.Nil(t, err)
+	assert.Equal(
+		t,
+		"application/x-protobuf; messageType=events.v1.LinkCreated",
+		contentType,
+	)
+
+	legacy, _ := json.Marshal(expectedLink)
+
+	for _, data := range []struct {
+		Name        string
+		ContentType string
+		Value       []byte
+	}{
+		{"protobuf", contentType, encoded},
+		{"json", ContentTypeJSON, legacy},
+		{"json without header", "", legacy},
+	} {
+		t.Run(data.Name, func(t *testing.T) {
+			link, err := DecodeLink(message(data.ContentType, data.Value))
+			assert.Nil(t, err)
+			assert.Equal(t, expectedLink.ShortUrl, link.ShortUrl)
+			assert.Equal(t, expectedLink.IdempotencyKey, link.IdempotencyKey)
+			assert.True(t, expectedLink.ExpirationDate.Equal(link.ExpirationDate))
+		})
+	}
+
+	_, err = DecodeLink(message("text/plain", []byte("abcde")))
+	assert.NotNil(t, err)
+
+	// a user event in the urls topic
+	_, err = DecodeLink(message(ContentType(&eventsv1.UserRegistered{}), encoded))
+	assert.NotNil(t, err)
+}
+
+func TestDecodeUser(t *testing.T) {
+	e := &eventsv1.UserRegistered{
+		IdempotencyKey: "key",
+		Id:             "id",
+		Name:           "name",
+		Email:          "mail",
+		HashedPassword: "hash",
+	}
+	encoded, contentType, err := Encode(e)
+	assert.Nil(t, err)
+
+	user, err := DecodeUser(message(contentType, encoded))
+	assert.Nil(t, err)
+	assert.Equal(t, &responses.Authenticator{
+		Id:             "id",
+		Name:           "name",
+		Email:          "mail",
+		HashedPassword: "hash",
+		IdempotencyKey: "key",
+	}, user)
+
+	legacy, _ := json.Marshal(user)
+	user, err = DecodeUser(message("", legacy))
+	assert.Nil(t, err)
+	assert.Equal(t, "mail", user.Email)
+}
+
+func TestDecodeLinkEvent(t *testing.T) {
+	clickedAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, data := range []struct {
+		Name     string
+		Event    proto.Message
+		Expected *domain.LinkEvent
+	}{
+		{
+			Name:  "created",
+			Event: linkV1,
+			Expected: &domain.LinkEvent{
+				Id:             linkV1.IdempotencyKey,
+				Type:           domain.EventLinkCreated,
+				ShortUrl:       "abcde",
+				LongUrl:        "https://example.com",
+				UserId:         linkV1.UserId,
+				WorkspaceId:    linkV1.WorkspaceId,
+				ExpirationDate: &expiration,
+				OccurredAt:     clickedAt,
+			},
+		},
+		{
+			Name: "clicked",
+			Event: &eventsv1.LinkClicked{
+				IdempotencyKey: "key",
+				ShortUrl:       "abcde",
+				ClickedAt:      timestamppb.New(clickedAt),
+			},
+			Expected: &domain.LinkEvent{
+				Id:         "key",
+				Type:       domain.EventLinkClicked,
+				ShortUrl:   "abcde",
+				OccurredAt: clickedAt,
+			},
+		},
+		{
+			Name: "deleted",
+			Event: &eventsv1.LinkDeleted{
+				IdempotencyKey: "key",
+				ShortUrl:       "abcde",
+				LongUrl:        "https://example.com",
+				UserId:         "user",
+				DeletedAt:      timestamppb.New(clickedAt),
+			},
+			Expected: &domain.LinkEvent{
+				Id:         "key",
+				Type:       domain.EventLinkDeleted,
+				ShortUrl:   "abcde",
+				LongUrl:    "https://example.com",
+				UserId:     "user",
+				OccurredAt: clickedAt,
+			},
+		},
+	} {
+		t.Run(data.Name, func(t *testing.T) {
+			encoded, contentType, err := Encode(data.Event)
+			assert.Nil(t, err)
+			mes := message(contentType, encoded)
+			mes.Timestamp = clickedAt
+
+			e, err := DecodeLinkEvent(mes)
+			assert.Nil(t, err)
+			assert.Equal(t, data.Expected, e)
+		})
+	}
+
+	_, err := DecodeLinkEvent(message("text/plain", []byte("abcde")))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,215 @@
+// Package health serves liveness and readiness probes. A service is live as
+// long as it answers. It's ready when every dependency it has answers its
+// check, and it isn't draining before shutdown.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"shortener/pkg/responses"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Check returns an error if the dependency can't be used
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type Health struct {
+	checks  []namedCheck
+	timeout time.Duration
+
+	draining atomic.Bool
+}
+
+const DefaultTimeout = 2 * time.Second
+
+type healthOption func(h *Health) error
+
+// WithCheck adds a dependency checked on readiness probes, e.g. "postgres"
+func WithCheck(name string, c Check) healthOption {
+	return func(h *Health) error {
+		if c == nil {
+			return fmt.Errorf("no check of %s provided", name)
+		}
+		h.checks = append(h.checks, namedCheck{name: name, check: c})
+		return nil
+	}
+}
+
+// WithTimeout limits time of every check
+func WithTimeout(d time.Duration) healthOption {
+	return func(h *Health) error {
+		if d <= 0 {
+			return errors.New("timeout must be positive")
+		}
+		h.timeout = d
+		return nil
+	}
+}
+
+func New(opts ...healthOption) (*Health, error) {
+	h := &Health{timeout: DefaultTimeout}
+	for _, opt := range opts {
+		if err := opt(h); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Drain makes readiness probes fail, so that load balancers stop sending
+// requests before the server shuts down
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+// Mount registers GET /healthz and GET /readyz on the mux
+func (h *Health) Mount(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", h.Live)
+	mux.HandleFunc("GET /readyz", h.Ready)
+}
+
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	write(w, http.StatusOK, &responses.Health{Status: responses.HealthStatusOk})
+}
+
+// Ready reports the result of every check
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		write(w, http.StatusServiceUnavailable, &responses.Health{
+			Status: responses.HealthStatusDraining,
+		})
+		return
+	}
+
+	res := h.run(r.Context())
+	status := http.StatusOK
+	if res.Status != responses.HealthStatusOk {
+		status = http.StatusServiceUnavailable
+	}
+	write(w, status, res)
+}
+
+// Check returns an error if any dependency fails its check, e.g. to report
+// readiness over another protocol
+func (h *Health) Check(ctx context.Context) error {
+	res := h.run(ctx)
+	if res.Status == responses.HealthStatusOk {
+		return nil
+	}
+	var errs []error
+	for _, c := range h.checks {
+		if msg := res.Checks[c.name]; msg != responses.HealthStatusOk {
+			errs = append(errs, fmt.Errorf("%s: %s", c.name, msg))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run runs the checks concurrently and collects the result of each
+func (h *Health) run(ctx context.Context) *responses.Health {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	results := make([]chan error, len(h.checks))
+	for i, c := range h.checks {
+		results[i] = make(chan error, 1)
+		go func() {
+			results[i] <- c.check(ctx)
+		}()
+	}
+
+	res := &responses.Health{
+		Status: responses.HealthStatusOk,
+		Checks: make(map[string]string, len(h.checks)),
+	}
+	for i, c := range h.checks {
+		var err error
+		select {
+		case err = <-results[i]:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			res.Status = responses.HealthStatusUnavailable
+			res.Checks[c.name] = err.Error()
+		} else {
+			res.Checks[c.name] = responses.HealthStatusOk
+		}
+	}
+	return res
+}
+
+func write(w http.ResponseWriter, status int, res *responses.Health) {
+	pkg, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(pkg)
+}
+
+// Pinger is a model backed by a database pool
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+func Ping(p Pinger) Check {
+	return p.Ping
+}
+
+func Redis(rdb *redis.Client) Check {
+	return func(ctx context.Context) error {
+		return rdb.Ping(ctx).Err()
+	}
+}
+
+// Kafka refreshes metadata of the cluster, which needs a live broker
+func Kafka(client sarama.Client) Check {
+	return func(context.Context) error {
+		if err := client.RefreshMetadata(); err != nil {
+			return err
+		}
+		if len(client.Brokers()) == 0 {
+			return errors.New("no brokers available")
+		}
+		return nil
+	}
+}
+
+func Nats(nc *nats.Conn) Check {
+	return func(context.Context) error {
+		if !nc.IsConnected() {
+			return fmt.Errorf("not connected: %s", nc.Status())
+		}
+		return nil
+	}
+}
+
+// Grpc asks the standard health service of the server whether the service
+// is serving
+func Grpc(conn grpc.ClientConnInterface, service string) Check {
+	client := healthpb.NewHealthClient(conn)
+	return func(ctx context.Context) error {
+		res, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return err
+		}
+		if res.Status != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("%s is %s", service, res.Status)
+		}
+		return nil
+	}
+}
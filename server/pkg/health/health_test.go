@@ -0,0 +1,99 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"shortener/pkg/responses"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func ok(context.Context) error { return nil }
+
+func probe(t *testing.T, h *Health, path string) (int, responses.Health) {
+	t.Helper()
+	mux := http.NewServeMux()
+	h.Mount(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var res responses.Health
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	return w.Code, res
+}
+
+func TestReady(t *testing.T) {
+	h, err := New(WithCheck("postgres", ok), WithCheck("redis", ok))
+	require.NoError(t, err)
+
+	code, res := probe(t, h, "/readyz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, responses.HealthStatusOk, res.Status)
+	require.Equal(t, map[string]string{
+		"postgres": responses.HealthStatusOk,
+		"redis":    responses.HealthStatusOk,
+	}, res.Checks)
+	require.NoError(t, h.Check(context.Background()))
+}
+
+func TestReadyFailingCheck(t *testing.T) {
+	h, err := New(
+		WithCheck("postgres", ok),
+		WithCheck("redis", func(context.Context) error {
+			return errors.New("connection refused")
+		}),
+	)
+	require.NoError(t, err)
+
+	code, res := probe(t, h, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, responses.HealthStatusUnavailable, res.Status)
+	require.Equal(t, responses.HealthStatusOk, res.Checks["postgres"])
+	require.Equal(t, "connection refused", res.Checks["redis"])
+	require.ErrorContains(t, h.Check(context.Background()), "redis: connection refused")
+
+	// liveness doesn't depend on the checks
+	code, _ = probe(t, h, "/healthz")
+	require.Equal(t, http.StatusOK, code)
+}
+
+func TestReadyTimeout(t *testing.T) {
+	h, err := New(
+		WithTimeout(10*time.Millisecond),
+		WithCheck("kafka", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+	)
+	require.NoError(t, err)
+
+	code, res := probe(t, h, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, context.DeadlineExceeded.Error(), res.Checks["kafka"])
+}
+
+func TestDrain(t *testing.T) {
+	h, err := New(WithCheck("postgres", ok))
+	require.NoError(t, err)
+	h.Drain()
+
+	code, res := probe(t, h, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, responses.HealthStatusDraining, res.Status)
+
+	code, _ = probe(t, h, "/healthz")
+	require.Equal(t, http.StatusOK, code)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(WithCheck("bus", nil))
+	require.Error(t, err)
+	_, err = New(WithTimeout(0))
+	require.Error(t, err)
+}
@@ -0,0 +1,86 @@
+// Package jobqueue runs jobs queued in the database, e.g. exports and bulk
+// uploads. Jobs are claimed with leases, so that a job is run by one
+// instance at a time, and jobs of instances that have stopped are started
+// again once their leases run out.
+package jobqueue
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	DefaultPollInterval = 5 * time.Second
+	DefaultTimeout      = 30 * time.Minute
+	DefaultRetention    = 24 * time.Hour
+)
+
+// Queue claims pending jobs and removes expired ones. Claim returns nil
+// once there are no pending jobs.
+type Queue[J any] interface {
+	Claim(ctx context.Context, lease time.Duration) (*J, error)
+	Prune(ctx context.Context) (int64, error)
+}
+
+// Runner runs jobs of the queue one at a time
+type Runner[J any] struct {
+	Queue Queue[J]
+	// Handle runs the job for no longer than Timeout and marks it as done
+	// or failed. Errors are only returned if the job couldn't be marked.
+	Handle       func(ctx context.Context, j *J) error
+	Timeout      time.Duration
+	PollInterval time.Duration
+	// Kind names the jobs in logs, e.g. "export"
+	Kind string
+	Log  *zerolog.Logger
+}
+
+// Run makes passes until the context is cancelled. Jobs interrupted by the
+// cancellation are left running, and are started again by some instance
+// once their leases run out.
+func (r *Runner[J]) Run(ctx context.Context) {
+	for {
+		if err := r.Pass(ctx); err != nil && ctx.Err() == nil {
+			r.Log.Error().Err(err).Str("kind", r.Kind).Msg("couldn't run jobs")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.PollInterval):
+		}
+	}
+}
+
+// Pass runs pending jobs until there are none left, then removes expired
+// ones
+func (r *Runner[J]) Pass(ctx context.Context) error {
+	for ctx.Err() == nil {
+		// the lease outlasts the timeout, so that jobs aren't started again
+		// while they are still being failed
+		j, err := r.Queue.Claim(ctx, 2*r.Timeout)
+		if err != nil {
+			return err
+		}
+		if j == nil {
+			break
+		}
+		if err := r.Handle(ctx, j); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	n, err := r.Queue.Prune(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		r.Log.Info().Str("kind", r.Kind).Int64("jobs", n).Msg("removed expired jobs")
+	}
+	return nil
+}
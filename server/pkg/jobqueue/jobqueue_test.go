@@ -0,0 +1,113 @@
+package jobqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+type job struct {
+	id string
+}
+
+type fakeQueue struct {
+	pending []*job
+	leases  []time.Duration
+	pruned  int
+	err     error
+}
+
+func (q *fakeQueue) Claim(_ context.Context, lease time.Duration) (*job, error) {
+	q.leases = append(q.leases, lease)
+	if q.err != nil {
+		return nil, q.err
+	}
+	if len(q.pending) == 0 {
+		return nil, nil
+	}
+	j := q.pending[0]
+	q.pending = q.pending[1:]
+	return j, nil
+}
+
+func (q *fakeQueue) Prune(context.Context) (int64, error) {
+	q.pruned++
+	return 1, nil
+}
+
+func newRunner(q *fakeQueue, handle func(ctx context.Context, j *job) error) *Runner[job] {
+	log := zerolog.Nop()
+	return &Runner[job]{
+		Queue:        q,
+		Handle:       handle,
+		Timeout:      time.Minute,
+		PollInterval: time.Millisecond,
+		Kind:         "test",
+		Log:          &log,
+	}
+}
+
+func TestPassRunsPendingJobsThenPrunes(t *testing.T) {
+	q := &fakeQueue{pending: []*job{{id: "first"}, {id: "second"}}}
+	var ran []string
+	r := newRunner(q, func(_ context.Context, j *job) error {
+		ran = append(ran, j.id)
+		return nil
+	})
+
+	assert.Nil(t, r.Pass(context.Background()))
+	assert.Equal(t, []string{"first", "second"}, ran)
+	// leases outlast the timeout
+	assert.Equal(t, []time.Duration{2 * time.Minute, 2 * time.Minute, 2 * time.Minute}, q.leases)
+	assert.Equal(t, 1, q.pruned)
+}
+
+func TestPassStopsOnErrors(t *testing.T) {
+	markErr := errors.New("couldn't mark job")
+	q := &fakeQueue{pending: []*job{{id: "first"}, {id: "second"}}}
+	r := newRunner(q, func(context.Context, *job) error {
+		return markErr
+	})
+	assert.ErrorIs(t, r.Pass(context.Background()), markErr)
+	assert.Len(t, q.pending, 1)
+	assert.Zero(t, q.pruned)
+
+	claimErr := errors.New("couldn't claim job")
+	q = &fakeQueue{err: claimErr}
+	assert.ErrorIs(t, newRunner(q, nil).Pass(context.Background()), claimErr)
+	assert.Zero(t, q.pruned)
+}
+
+func TestPassStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &fakeQueue{pending: []*job{{id: "first"}, {id: "second"}}}
+	r := newRunner(q, func(context.Context, *job) error {
+		cancel()
+		return nil
+	})
+
+	assert.ErrorIs(t, r.Pass(ctx), context.Canceled)
+	// the second job is left for some instance to claim
+	assert.Len(t, q.pending, 1)
+	assert.Zero(t, q.pruned)
+}
+
+func TestRunReturnsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &fakeQueue{}
+	done := make(chan struct{})
+	go func() {
+		newRunner(q, nil).Run(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run hasn't returned")
+	}
+}
@@ -0,0 +1,113 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+type SMTP struct {
+	addr     string
+	from     string
+	username string
+	password string
+	auth     smtp.Auth
+}
+
+type smtpOption func(*SMTP) error
+
+func WithServer(addr string) smtpOption {
+	return func(s *SMTP) error {
+		s.addr = addr
+		return nil
+	}
+}
+
+func WithSender(from string) smtpOption {
+	return func(s *SMTP) error {
+		s.from = from
+		return nil
+	}
+}
+
+func WithCredentials(username, password string) smtpOption {
+	return func(s *SMTP) error {
+		s.username = username
+		s.password = password
+		return nil
+	}
+}
+
+func NewSMTP(opts ...smtpOption) (*SMTP, error) {
+	s := new(SMTP)
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.addr == "" {
+		return nil, errors.New("no smtp server address provided")
+	}
+	if s.from == "" {
+		return nil, errors.New("no sender address provided")
+	}
+	if s.username != "" {
+		host, _, err := net.SplitHostPort(s.addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid smtp server address: %w", err)
+		}
+		s.auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+	return s, nil
+}
+
+func (s *SMTP) Send(
+	ctx context.Context,
+	to string,
+	subject string,
+	body string,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(body)
+
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{to}, []byte(msg.String()))
+}
+
+// Log doesn't send anything and only writes emails to the log. Meant for
+// local development and tests.
+type Log struct {
+	log *zerolog.Logger
+}
+
+func NewLog(log *zerolog.Logger) *Log {
+	return &Log{log: log}
+}
+
+func (l *Log) Send(
+	ctx context.Context,
+	to string,
+	subject string,
+	body string,
+) error {
+	l.log.Info().
+		Str("to", to).
+		Str("subject", subject).
+		Str("body", body).
+		Msg("sending email")
+	return nil
+}
@@ -0,0 +1,230 @@
+// Package metrics exposes Prometheus metrics of the services. Metrics are
+// registered in the default registry, packages that own them declare them
+// with promauto. They are served on a separate admin address, so that they
+// aren't reachable through the public routes.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of HTTP requests by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	grpcServerHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_handled_total",
+		Help: "RPCs completed on the server by method and status code.",
+	}, []string{"method", "code"})
+	grpcServerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_handling_seconds",
+		Help:    "Latency of RPCs handled by the server.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+	grpcClientHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_handled_total",
+		Help: "RPCs completed by the client by method and status code.",
+	}, []string{"method", "code"})
+	grpcClientDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_client_handling_seconds",
+		Help:    "Latency of RPCs made by the client.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+// Instrument counts requests handled by the mux and measures their latency.
+// Requests are labeled with the pattern of the matched route rather than
+// the path, which would make a series per short link.
+func Instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		mux.ServeHTTP(rec, r)
+
+		httpDuration.WithLabelValues(r.Method, route).
+			Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(rec.status)).
+			Inc()
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func UnaryServerInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	start := time.Now()
+	res, err := handler(ctx, req)
+	grpcServerDuration.WithLabelValues(info.FullMethod).
+		Observe(time.Since(start).Seconds())
+	grpcServerHandled.WithLabelValues(info.FullMethod, status.Code(err).String()).
+		Inc()
+	return res, err
+}
+
+func UnaryClientInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply any,
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	grpcClientDuration.WithLabelValues(method).
+		Observe(time.Since(start).Seconds())
+	grpcClientHandled.WithLabelValues(method, status.Code(err).String()).
+		Inc()
+	return err
+}
+
+// Pool is a model backed by a pgx pool
+type Pool interface {
+	Stat() *pgxpool.Stat
+}
+
+// RegisterPool exports statistics of the pool labeled with its name, e.g.
+// the name of the model. It panics if the name is taken.
+func RegisterPool(name string, p Pool) {
+	prometheus.MustRegister(&poolCollector{name: name, pool: p})
+}
+
+var (
+	poolAcquired = prometheus.NewDesc(
+		"pgxpool_acquired_conns",
+		"Connections currently acquired from the pool.",
+		[]string{"pool"}, nil,
+	)
+	poolIdle = prometheus.NewDesc(
+		"pgxpool_idle_conns",
+		"Idle connections of the pool.",
+		[]string{"pool"}, nil,
+	)
+	poolTotal = prometheus.NewDesc(
+		"pgxpool_total_conns",
+		"All connections of the pool.",
+		[]string{"pool"}, nil,
+	)
+	poolMax = prometheus.NewDesc(
+		"pgxpool_max_conns",
+		"Maximum size of the pool.",
+		[]string{"pool"}, nil,
+	)
+	poolAcquires = prometheus.NewDesc(
+		"pgxpool_acquires_total",
+		"Successful acquires of connections.",
+		[]string{"pool"}, nil,
+	)
+	poolEmptyAcquires = prometheus.NewDesc(
+		"pgxpool_empty_acquires_total",
+		"Acquires that had to wait for a connection.",
+		[]string{"pool"}, nil,
+	)
+	poolAcquireDuration = prometheus.NewDesc(
+		"pgxpool_acquire_duration_seconds_total",
+		"Total time spent acquiring connections.",
+		[]string{"pool"}, nil,
+	)
+)
+
+type poolCollector struct {
+	name string
+	pool Pool
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquired
+	ch <- poolIdle
+	ch <- poolTotal
+	ch <- poolMax
+	ch <- poolAcquires
+	ch <- poolEmptyAcquires
+	ch <- poolAcquireDuration
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquired, prometheus.GaugeValue, float64(s.AcquiredConns()), c.name)
+	ch <- prometheus.MustNewConstMetric(poolIdle, prometheus.GaugeValue, float64(s.IdleConns()), c.name)
+	ch <- prometheus.MustNewConstMetric(poolTotal, prometheus.GaugeValue, float64(s.TotalConns()), c.name)
+	ch <- prometheus.MustNewConstMetric(poolMax, prometheus.GaugeValue, float64(s.MaxConns()), c.name)
+	ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(s.AcquireCount()), c.name)
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, float64(s.EmptyAcquireCount()), c.name)
+	ch <- prometheus.MustNewConstMetric(poolAcquireDuration, prometheus.CounterValue, s.AcquireDuration().Seconds(), c.name)
+}
+
+// Serve serves /metrics on the admin address until the context is
+// cancelled. Other routes may be added with mounts, e.g. health probes of a
+// service without a public HTTP server.
+func Serve(
+	ctx context.Context,
+	addr string,
+	log *zerolog.Logger,
+	mounts ...func(mux *http.ServeMux),
+) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	for _, mount := range mounts {
+		mount(mux)
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Info().Str("addr", addr).Msg("serving metrics")
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error().Err(err).Str("addr", addr).Msg("admin server failed")
+	}
+}
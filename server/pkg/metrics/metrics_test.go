@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestInstrument(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /links/{code}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("POST /links", func(w http.ResponseWriter, r *http.Request) {})
+	h := Instrument(mux)
+
+	for _, path := range []string{"/links/abc", "/links/def"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/links", nil))
+
+	require.Equal(t, 2.0, testutil.ToFloat64(
+		httpRequests.WithLabelValues("GET", "GET /links/{code}", "404"),
+	))
+	require.Equal(t, 1.0, testutil.ToFloat64(
+		httpRequests.WithLabelValues("POST", "POST /links", "200"),
+	))
+}
+
+func TestUnaryInterceptors(t *testing.T) {
+	const method = "/blackbox.BlackboxService/Test"
+
+	_, err := UnaryServerInterceptor(
+		context.Background(),
+		nil,
+		&grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req any) (any, error) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		},
+	)
+	require.Error(t, err)
+	require.Equal(t, 1.0, testutil.ToFloat64(
+		grpcServerHandled.WithLabelValues(method, "Unauthenticated"),
+	))
+
+	err = UnaryClientInterceptor(
+		context.Background(),
+		method,
+		nil,
+		nil,
+		nil,
+		func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+			return nil
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 1.0, testutil.ToFloat64(
+		grpcClientHandled.WithLabelValues(method, "OK"),
+	))
+}
@@ -7,16 +7,44 @@ import (
 	"github.com/justinas/alice"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func CorsHeaders(next http.Handler) http.Handler {
+// DefaultCorsOrigin is where the frontend is served from by docker compose
+const DefaultCorsOrigin = "http://localhost:8001"
+
+// Cors allows credentialed cross-origin requests from the origin
+type Cors struct {
+	Origin string
+}
+
+func (c Cors) Headers(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Access-Control-Allow-Origin", "http://localhost:8001")
+		w.Header().Add("Access-Control-Allow-Origin", c.Origin)
 		w.Header().Add("Access-Control-Allow-Credentials", "true")
 		next.ServeHTTP(w, r)
 	})
 }
 
+// Preflight answers preflight requests. Methods are announced only if
+// given, e.g. "GET, POST, PUT, DELETE".
+func (c Cors) Preflight(methods string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Access-Control-Allow-Origin", c.Origin)
+		w.Header().Add("Access-Control-Allow-Credentials", "true")
+		w.Header().Add("Access-Control-Allow-Headers", "Content-Type")
+		if methods != "" {
+			w.Header().Add("Access-Control-Allow-Methods", methods)
+		}
+	}
+}
+
+// CorsHeaders allows requests from DefaultCorsOrigin
+func CorsHeaders(next http.Handler) http.Handler {
+	return Cors{Origin: DefaultCorsOrigin}.Headers(next)
+}
+
 func RequestTracing(
 	log *zerolog.Logger,
 ) alice.Chain {
@@ -33,5 +61,23 @@ func RequestTracing(
 		hlog.RemoteAddrHandler("ip"),
 		hlog.RequestHandler("url_and_method"),
 		hlog.RequestIDHandler("request_id", "Request-Id"),
+		traceIds,
 	)
 }
+
+// traceIds adds the trace id to the log and the request id to the span of
+// the request, so that one can be found by the other
+func traceIds(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		if id, ok := hlog.IDFromRequest(r); ok {
+			span.SetAttributes(attribute.String("request.id", id.String()))
+		}
+		if sc := span.SpanContext(); sc.IsValid() {
+			hlog.FromRequest(r).UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("trace_id", sc.TraceID().String())
+			})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,364 @@
+// Package migrate applies versioned schema migrations to Postgres. Applied
+// versions are recorded in the SchemaMigrations table. Every migration runs
+// in a transaction of its own, and runs of several processes are serialized
+// with an advisory lock, so that services may migrate on startup.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+)
+
+// lockKey identifies the advisory lock held while migrating
+const lockKey int64 = 0x6d696772617465 // "migrate"
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+var (
+	fileName      = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+	migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+// Load reads migrations from the files of fsys. Every version must have
+// both an up and a down migration.
+func Load(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		parts := fileName.FindStringSubmatch(e.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", e.Name())
+		}
+		version, _ := strconv.ParseInt(parts[1], 10, 64)
+		if version < 1 {
+			return nil, fmt.Errorf("invalid version of %s", e.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		}
+		if m.Name != parts[2] {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", m.Name, parts[2], version)
+		}
+
+		sql, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if parts[3] == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Create writes up and down files of a migration with the version following
+// the latest one in dir and returns their paths
+func Create(dir string, name string) (string, string, error) {
+	if !migrationName.MatchString(name) {
+		return "", "", errors.New("name may only contain lowercase letters, digits and underscores")
+	}
+	migrations, err := Load(os.DirFS(dir))
+	if err != nil {
+		return "", "", err
+	}
+	version := int64(1)
+	if len(migrations) > 0 {
+		version = migrations[len(migrations)-1].Version + 1
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%04d_%s", version, name))
+	up, down := base+".up.sql", base+".down.sql"
+	for _, path := range []string{up, down} {
+		header := fmt.Sprintf("-- %s\n", filepath.Base(path))
+		if err := os.WriteFile(path, []byte(header), 0o644); err != nil {
+			return "", "", err
+		}
+	}
+	return up, down, nil
+}
+
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []*Migration
+	log        *zerolog.Logger
+}
+
+type migratorOption func(m *Migrator) error
+
+func WithPool(ctx context.Context, dsn string) migratorOption {
+	return func(m *Migrator) error {
+		pool, err := pgxpool.New(ctx, dsn)
+		if err != nil {
+			return err
+		}
+		if err := pool.Ping(ctx); err != nil {
+			pool.Close()
+			return err
+		}
+		m.pool = pool
+		return nil
+	}
+}
+
+// WithMigrations loads the migrations from fsys, e.g. migrations.FS
+func WithMigrations(fsys fs.FS) migratorOption {
+	return func(m *Migrator) error {
+		migrations, err := Load(fsys)
+		if err != nil {
+			return err
+		}
+		m.migrations = migrations
+		return nil
+	}
+}
+
+func WithLogger(l *zerolog.Logger) migratorOption {
+	return func(m *Migrator) error {
+		m.log = l
+		return nil
+	}
+}
+
+func New(opts ...migratorOption) (*Migrator, error) {
+	m := new(Migrator)
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.pool == nil {
+		return nil, errors.New("no connection pool provided")
+	}
+	if m.migrations == nil {
+		return nil, errors.New("no migrations provided")
+	}
+	if m.log == nil {
+		return nil, errors.New("no logger provided")
+	}
+	return m, nil
+}
+
+func (m *Migrator) Close() {
+	m.pool.Close()
+}
+
+// Status of a migration. AppliedAt is nil for pending migrations. Applied
+// versions unknown to the binary have no SQL.
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Up applies the pending migrations and returns how many have been applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	n := 0
+	err := m.locked(ctx, func(conn *pgxpool.Conn, applied map[int64]Status) error {
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			start := time.Now()
+			err := m.apply(ctx, conn, mig.Up, func(tx pgx.Tx) error {
+				_, err := tx.Exec(
+					ctx,
+					`INSERT INTO SchemaMigrations(Version, Name) VALUES ($1, $2)`,
+					mig.Version,
+					mig.Name,
+				)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("couldn't apply %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			m.log.Info().
+				Int64("version", mig.Version).
+				Str("name", mig.Name).
+				Dur("duration", time.Since(start)).
+				Msg("applied migration")
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Down reverts the latest steps applied migrations and returns how many
+// have been reverted
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	byVersion := make(map[int64]*Migration, len(m.migrations))
+	for _, mig := range m.migrations {
+		byVersion[mig.Version] = mig
+	}
+
+	n := 0
+	err := m.locked(ctx, func(conn *pgxpool.Conn, applied map[int64]Status) error {
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, v := range versions {
+			if n == steps {
+				break
+			}
+			mig, ok := byVersion[v]
+			if !ok {
+				return fmt.Errorf("migration %d is unknown to this binary", v)
+			}
+			err := m.apply(ctx, conn, mig.Down, func(tx pgx.Tx) error {
+				_, err := tx.Exec(ctx, `DELETE FROM SchemaMigrations WHERE Version = $1`, v)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("couldn't revert %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			m.log.Info().
+				Int64("version", mig.Version).
+				Str("name", mig.Name).
+				Msg("reverted migration")
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Status returns the known and the applied migrations ordered by version
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var res []Status
+	err := m.locked(ctx, func(_ *pgxpool.Conn, applied map[int64]Status) error {
+		for _, mig := range m.migrations {
+			s := Status{Version: mig.Version, Name: mig.Name}
+			if a, ok := applied[mig.Version]; ok {
+				s.AppliedAt = a.AppliedAt
+				delete(applied, mig.Version)
+			}
+			res = append(res, s)
+		}
+		for _, a := range applied {
+			res = append(res, a)
+		}
+		return nil
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, err
+}
+
+// locked calls fn holding the advisory lock on the connection fn has to
+// migrate over, with the applied migrations
+func (m *Migrator) locked(
+	ctx context.Context,
+	fn func(conn *pgxpool.Conn, applied map[int64]Status) error,
+) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	m.log.Debug().Msg("waiting for migration lock")
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("couldn't acquire migration lock: %w", err)
+	}
+	defer func() {
+		// the lock would be released with the session otherwise
+		_, err := conn.Exec(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, lockKey)
+		if err != nil {
+			conn.Conn().Close(context.WithoutCancel(ctx))
+		}
+	}()
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS SchemaMigrations (
+			Version Bigint PRIMARY KEY,
+			Name VarChar(255) NOT NULL,
+			AppliedAt Timestamp NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		return fmt.Errorf("couldn't create migrations table: %w", err)
+	}
+
+	rows, err := conn.Query(ctx, `SELECT Version, Name, AppliedAt FROM SchemaMigrations`)
+	if err != nil {
+		return err
+	}
+	applied := map[int64]Status{}
+	for rows.Next() {
+		var s Status
+		var at time.Time
+		if err := rows.Scan(&s.Version, &s.Name, &at); err != nil {
+			return err
+		}
+		s.AppliedAt = &at
+		applied[s.Version] = s
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+// apply runs the SQL and records the change in one transaction
+func (m *Migrator) apply(
+	ctx context.Context,
+	conn *pgxpool.Conn,
+	sql string,
+	record func(tx pgx.Tx) error,
+) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
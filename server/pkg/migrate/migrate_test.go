@@ -0,0 +1,167 @@
+package migrate
+
+import (
+	"context"
+	_ "embed"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"shortener/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(fstest.MapFS{
+		"0002_expiry.up.sql":   {Data: []byte("ALTER TABLE Urls ADD x Int;")},
+		"0002_expiry.down.sql": {Data: []byte("ALTER TABLE Urls DROP x;")},
+		"0001_init.up.sql":     {Data: []byte("CREATE TABLE Urls();")},
+		"0001_init.down.sql":   {Data: []byte("DROP TABLE Urls;")},
+		"migrations.go":        {Data: []byte("package migrations")},
+	})
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	require.Equal(t, &Migration{
+		Version: 1,
+		Name:    "init",
+		Up:      "CREATE TABLE Urls();",
+		Down:    "DROP TABLE Urls;",
+	}, migrations[0])
+	require.Equal(t, int64(2), migrations[1].Version)
+	require.Equal(t, "expiry", migrations[1].Name)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"no down": {
+			"0001_init.up.sql": {Data: []byte("SELECT 1;")},
+		},
+		"shared version": {
+			"0001_init.up.sql":    {Data: []byte("SELECT 1;")},
+			"0001_init.down.sql":  {Data: []byte("SELECT 1;")},
+			"0001_other.up.sql":   {Data: []byte("SELECT 1;")},
+			"0001_other.down.sql": {Data: []byte("SELECT 1;")},
+		},
+		"invalid name": {
+			"init.up.sql": {Data: []byte("SELECT 1;")},
+		},
+		"zero version": {
+			"0000_init.up.sql":   {Data: []byte("SELECT 1;")},
+			"0000_init.down.sql": {Data: []byte("SELECT 1;")},
+		},
+	} {
+		_, err := Load(fsys)
+		require.Error(t, err, name)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	up, down, err := Create(dir, "init")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "0001_init.up.sql"), up)
+	require.Equal(t, filepath.Join(dir, "0001_init.down.sql"), down)
+	sql, err := os.ReadFile(up)
+	require.NoError(t, err)
+	require.Equal(t, "-- 0001_init.up.sql\n", string(sql))
+
+	up, _, err = Create(dir, "add_clicks")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "0002_add_clicks.up.sql"), up)
+
+	_, _, err = Create(dir, "Add Clicks")
+	require.Error(t, err)
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	mm, err := Load(migrations.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, mm)
+	for i, m := range mm {
+		require.Equal(t, int64(i+1), m.Version, "versions must be consecutive")
+	}
+}
+
+// baselineInit is the init.sql databases used to be initialized with before
+// there were migrations
+//
+//go:embed testdata/baseline_init.sql
+var baselineInit string
+
+// withSearchPath returns the dsn of connections that use the schema
+func withSearchPath(t *testing.T, dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	require.NoError(t, err)
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func TestUpFromBaseline(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN isn't set")
+	}
+	ctx := context.Background()
+
+	conn, err := pgx.Connect(ctx, dsn)
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, `DROP SCHEMA IF EXISTS migrate_baseline CASCADE; CREATE SCHEMA migrate_baseline`)
+	require.NoError(t, err)
+	defer conn.Exec(ctx, `DROP SCHEMA migrate_baseline CASCADE`)
+
+	dsn = withSearchPath(t, dsn, "migrate_baseline")
+	baseline, err := pgx.Connect(ctx, dsn)
+	require.NoError(t, err)
+	_, err = baseline.Exec(ctx, baselineInit)
+	require.NoError(t, err)
+	_, err = baseline.Exec(
+		ctx,
+		`INSERT INTO Urls(ShortUrl, LongUrl, UserId)
+		 VALUES ('abcde', 'https://example.com', 'db092ed4-306a-4d4f-be5f-fd2f1487edbe')`,
+	)
+	require.NoError(t, err)
+	baseline.Close(ctx)
+
+	log := zerolog.Nop()
+	m, err := New(WithPool(ctx, dsn), WithMigrations(migrations.FS), WithLogger(&log))
+	require.NoError(t, err)
+	defer m.Close()
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+
+	rows, err := conn.Query(
+		ctx,
+		`SELECT lower(table_name) || '.' || lower(column_name)
+		 FROM information_schema.columns
+		 WHERE table_schema = 'migrate_baseline' AND table_name IN ('users', 'urls')`,
+	)
+	require.NoError(t, err)
+	columns, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	require.NoError(t, err)
+	require.Subset(t, columns, []string{
+		"users.totpsecret",
+		"users.totpenabled",
+		"users.totplaststep",
+		"users.idempotencykey",
+		"urls.workspaceid",
+		"urls.idempotencykey",
+	})
+
+	var longUrl string
+	err = conn.QueryRow(ctx, `SELECT LongUrl FROM migrate_baseline.Urls WHERE ShortUrl = 'abcde'`).
+		Scan(&longUrl)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com", longUrl)
+}
@@ -0,0 +1,162 @@
+package attempts
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Policy describes when and for how long logins get locked. Once the number
+// of failures reaches the limit, each subsequent failure doubles the lockout
+// duration, starting from BaseLockout and up to MaxLockout.
+type Policy struct {
+	MaxFailures      int64
+	MaxFailuresPerIp int64
+	BaseLockout      time.Duration
+	MaxLockout       time.Duration
+	// failures older than Window are forgotten
+	Window time.Duration
+}
+
+var DefaultPolicy = Policy{
+	MaxFailures:      5,
+	MaxFailuresPerIp: 50,
+	BaseLockout:      time.Minute,
+	MaxLockout:       time.Hour,
+	Window:           24 * time.Hour,
+}
+
+// Model counts failed login attempts per email and per ip address
+type Model struct {
+	rdb    *redis.Client
+	policy Policy
+}
+
+type attemptsOption func(m *Model) error
+
+func WithRedis(rdb *redis.Client) attemptsOption {
+	return func(m *Model) error {
+		m.rdb = rdb
+		return nil
+	}
+}
+
+func WithPolicy(p Policy) attemptsOption {
+	return func(m *Model) error {
+		if p.MaxFailures <= 0 || p.MaxFailuresPerIp <= 0 {
+			return errors.New("failure limits must be positive")
+		}
+		if p.BaseLockout <= 0 || p.MaxLockout < p.BaseLockout {
+			return errors.New("invalid lockout durations")
+		}
+		if p.Window <= 0 {
+			return errors.New("window must be positive")
+		}
+		m.policy = p
+		return nil
+	}
+}
+
+func New(opts ...attemptsOption) (*Model, error) {
+	m := &Model{policy: DefaultPolicy}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.rdb == nil {
+		return nil, errors.New("no redis client provided")
+	}
+	return m, nil
+}
+
+func emailKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func failuresKey(subject string) string {
+	return "login_failures:" + subject
+}
+
+func lockKey(subject string) string {
+	return "login_lock:" + subject
+}
+
+// LockedFor returns for how long login attempts for the email from the ip
+// address must be rejected. Zero means that they are allowed.
+func (m *Model) LockedFor(
+	ctx context.Context,
+	email string,
+	ip string,
+) (time.Duration, error) {
+	pipe := m.rdb.Pipeline()
+	emailTTL := pipe.PTTL(ctx, lockKey(emailKey(email)))
+	ipTTL := pipe.PTTL(ctx, lockKey(ipKey(ip)))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	// PTTL returns negative values for missing keys
+	return max(emailTTL.Val(), ipTTL.Val(), 0), nil
+}
+
+// Fail registers a failed login attempt. If it triggers a lockout, the
+// lockout duration is returned.
+func (m *Model) Fail(
+	ctx context.Context,
+	email string,
+	ip string,
+) (time.Duration, error) {
+	pipe := m.rdb.TxPipeline()
+	emailFailures := pipe.Incr(ctx, failuresKey(emailKey(email)))
+	pipe.Expire(ctx, failuresKey(emailKey(email)), m.policy.Window)
+	ipFailures := pipe.Incr(ctx, failuresKey(ipKey(ip)))
+	pipe.Expire(ctx, failuresKey(ipKey(ip)), m.policy.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	emailLockout := m.lockout(emailFailures.Val(), m.policy.MaxFailures)
+	ipLockout := m.lockout(ipFailures.Val(), m.policy.MaxFailuresPerIp)
+
+	pipe = m.rdb.Pipeline()
+	if emailLockout > 0 {
+		pipe.Set(ctx, lockKey(emailKey(email)), 1, emailLockout)
+	}
+	if ipLockout > 0 {
+		pipe.Set(ctx, lockKey(ipKey(ip)), 1, ipLockout)
+	}
+	if pipe.Len() > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	return max(emailLockout, ipLockout), nil
+}
+
+func (m *Model) lockout(failures int64, limit int64) time.Duration {
+	if failures < limit {
+		return 0
+	}
+
+	d := m.policy.BaseLockout
+	for i := limit; i < failures && d < m.policy.MaxLockout; i++ {
+		d *= 2
+	}
+	return min(d, m.policy.MaxLockout)
+}
+
+// Succeed forgets failed attempts for the email. Failures from the ip
+// address are kept: otherwise an attacker could reset them by logging into
+// their own account.
+func (m *Model) Succeed(ctx context.Context, email string) error {
+	return m.rdb.Del(ctx, failuresKey(emailKey(email))).Err()
+}
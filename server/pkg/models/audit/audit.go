@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/tracing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	EventLoginLockout   = "login_lockout"
+	EventIdentityLinked = "identity_linked"
+)
+
+// Model is an append-only log of security related events
+type Model struct {
+	pool *pgxpool.Pool
+}
+
+type auditOption func(m *Model) error
+
+func WithPool(ctx context.Context, dsn string) auditOption {
+	return func(m *Model) error {
+		conf, err := tracing.PoolConfig(dsn)
+		if err != nil {
+			return err
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, conf)
+		if err != nil {
+			return err
+		}
+
+		if err := pool.Ping(ctx); err != nil {
+			return err
+		}
+
+		m.pool = pool
+		return nil
+	}
+}
+
+func New(opts ...auditOption) (*Model, error) {
+	m := new(Model)
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.pool == nil {
+		return nil, errors.New("no connection pool provided")
+	}
+	return m, nil
+}
+
+func (m *Model) Record(
+	ctx context.Context,
+	event string,
+	email string,
+	ip string,
+	details string,
+) error {
+	_, err := m.pool.Exec(
+		ctx,
+		`INSERT INTO AuditLog(Event, Email, Ip, Details) VALUES ($1, $2, $3, $4)`,
+		event,
+		email,
+		ip,
+		details,
+	)
+	return err
+}
+
+// Ping checks that the database is reachable
+func (m *Model) Ping(ctx context.Context) error {
+	return m.pool.Ping(ctx)
+}
+
+// Stat returns statistics of the connection pool
+func (m *Model) Stat() *pgxpool.Stat {
+	return m.pool.Stat()
+}
+
+func (m *Model) Close() {
+	m.pool.Close()
+}
@@ -0,0 +1,244 @@
+// Package bulk keeps jobs that create links of large bulk uploads
+package bulk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"shortener/pkg/domain"
+	"shortener/pkg/tracing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Model struct {
+	pool *pgxpool.Pool
+}
+
+type bulkOption func(m *Model) error
+
+func WithPool(ctx context.Context, dsn string) bulkOption {
+	return func(m *Model) error {
+		conf, err := tracing.PoolConfig(dsn)
+		if err != nil {
+			return err
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, conf)
+		if err != nil {
+			return err
+		}
+
+		if err := pool.Ping(ctx); err != nil {
+			return err
+		}
+
+		m.pool = pool
+		return nil
+	}
+}
+
+func New(opts ...bulkOption) (*Model, error) {
+	m := new(Model)
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.pool == nil {
+		return nil, errors.New("no connection pool provided")
+	}
+	return m, nil
+}
+
+var ErrNotFound = errors.New("bulk job not found")
+
+// Create stores the pending job with its links, and sets its id, status,
+// total and creation time
+func (m *Model) Create(ctx context.Context, j *domain.BulkJob) error {
+	links, err := json.Marshal(j.Links)
+	if err != nil {
+		return err
+	}
+	j.Status = domain.BulkPending
+	j.Total = len(j.Links)
+	return m.pool.QueryRow(
+		ctx,
+		`INSERT INTO BulkJobs(UserId, WorkspaceId, Links, Total)
+		 VALUES ($1, NULLIF($2, '')::uuid, $3, $4)
+		 RETURNING Id::text, CreatedAt`,
+		j.UserId,
+		j.WorkspaceId,
+		links,
+		j.Total,
+	).Scan(&j.Id, &j.CreatedAt)
+}
+
+const jobColumns = `Id::text, UserId::text, COALESCE(WorkspaceId::text, ''), Status,
+	COALESCE(Error, ''), Total, Processed, Succeeded, Failed, CreatedAt, FinishedAt,
+	ExpiresAt`
+
+func scanJob(row pgx.Row, dest ...any) (*domain.BulkJob, error) {
+	var j domain.BulkJob
+	var status string
+	err := row.Scan(append([]any{
+		&j.Id,
+		&j.UserId,
+		&j.WorkspaceId,
+		&status,
+		&j.Error,
+		&j.Total,
+		&j.Processed,
+		&j.Succeeded,
+		&j.Failed,
+		&j.CreatedAt,
+		&j.FinishedAt,
+		&j.ExpiresAt,
+	}, dest...)...)
+	if err != nil {
+		return nil, err
+	}
+	j.Status = domain.BulkStatus(status)
+	return &j, nil
+}
+
+// Job returns the job without its links, or ErrNotFound if it doesn't exist
+// or has expired. Results are only returned once the job is finished.
+func (m *Model) Job(ctx context.Context, id string) (*domain.BulkJob, error) {
+	var results []byte
+	j, err := scanJob(m.pool.QueryRow(
+		ctx,
+		`SELECT `+jobColumns+`,
+		     CASE WHEN Status IN ('done', 'failed') THEN Results END
+		 FROM BulkJobs
+		 WHERE Id = $1 AND (ExpiresAt IS NULL OR ExpiresAt > now())`,
+		id,
+	), &results)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if results != nil {
+		if err := json.Unmarshal(results, &j.Results); err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// Claim starts the oldest pending job and returns it with its links, or nil
+// if there are none. Jobs started longer than the lease ago are considered
+// abandoned by their instances and are resumed after their processed rows.
+func (m *Model) Claim(ctx context.Context, lease time.Duration) (*domain.BulkJob, error) {
+	var links []byte
+	j, err := scanJob(m.pool.QueryRow(
+		ctx,
+		`WITH next AS (
+		     SELECT Id AS NextId FROM BulkJobs
+		     WHERE Status = 'pending'
+		         OR (Status = 'running' AND StartedAt <= now() - make_interval(secs => $1))
+		     ORDER BY CreatedAt
+		     LIMIT 1
+		     FOR UPDATE SKIP LOCKED
+		 )
+		 UPDATE BulkJobs SET Status = 'running', StartedAt = now()
+		 FROM next
+		 WHERE Id = next.NextId
+		 RETURNING `+jobColumns+`, Links`,
+		lease.Seconds(),
+	), &links)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(links, &j.Links); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Progress appends results of the rows processed next and counts them
+func (m *Model) Progress(ctx context.Context, id string, results []domain.BulkResult) error {
+	var failed int
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	_, err = m.pool.Exec(
+		ctx,
+		`UPDATE BulkJobs
+		 SET Processed = Processed + $2, Succeeded = Succeeded + $3, Failed = Failed + $4,
+		     Results = Results || $5::jsonb
+		 WHERE Id = $1`,
+		id,
+		len(results),
+		len(results)-failed,
+		failed,
+		data,
+	)
+	return err
+}
+
+// Finish marks the job as done and drops its links. The job expires after
+// the ttl.
+func (m *Model) Finish(ctx context.Context, id string, ttl time.Duration) error {
+	_, err := m.pool.Exec(
+		ctx,
+		`UPDATE BulkJobs
+		 SET Status = 'done', Links = NULL, FinishedAt = now(),
+		     ExpiresAt = now() + make_interval(secs => $2)
+		 WHERE Id = $1`,
+		id,
+		ttl.Seconds(),
+	)
+	return err
+}
+
+// Fail marks the job as failed and drops its links. Links of the rows
+// processed so far are kept. The job expires after the ttl.
+func (m *Model) Fail(ctx context.Context, id string, reason string, ttl time.Duration) error {
+	_, err := m.pool.Exec(
+		ctx,
+		`UPDATE BulkJobs
+		 SET Status = 'failed', Error = $2, Links = NULL, FinishedAt = now(),
+		     ExpiresAt = now() + make_interval(secs => $3)
+		 WHERE Id = $1`,
+		id,
+		reason,
+		ttl.Seconds(),
+	)
+	return err
+}
+
+// Prune removes expired jobs
+func (m *Model) Prune(ctx context.Context) (int64, error) {
+	tag, err := m.pool.Exec(ctx, `DELETE FROM BulkJobs WHERE ExpiresAt <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Ping checks that the database is reachable
+func (m *Model) Ping(ctx context.Context) error {
+	return m.pool.Ping(ctx)
+}
+
+// Stat returns statistics of the connection pool
+func (m *Model) Stat() *pgxpool.Stat {
+	return m.pool.Stat()
+}
+
+func (m *Model) Close() {
+	m.pool.Close()
+}
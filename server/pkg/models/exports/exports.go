@@ -0,0 +1,260 @@
+// Package exports keeps export jobs of links and the files they produce
+package exports
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"shortener/pkg/domain"
+	"shortener/pkg/tracing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Model struct {
+	pool *pgxpool.Pool
+}
+
+type exportsOption func(m *Model) error
+
+func WithPool(ctx context.Context, dsn string) exportsOption {
+	return func(m *Model) error {
+		conf, err := tracing.PoolConfig(dsn)
+		if err != nil {
+			return err
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, conf)
+		if err != nil {
+			return err
+		}
+
+		if err := pool.Ping(ctx); err != nil {
+			return err
+		}
+
+		m.pool = pool
+		return nil
+	}
+}
+
+func New(opts ...exportsOption) (*Model, error) {
+	m := new(Model)
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.pool == nil {
+		return nil, errors.New("no connection pool provided")
+	}
+	return m, nil
+}
+
+var ErrNotFound = errors.New("export not found")
+
+// Create stores the pending job and sets its id, status and creation time
+func (m *Model) Create(ctx context.Context, j *domain.ExportJob) error {
+	query, err := json.Marshal(&j.Query)
+	if err != nil {
+		return err
+	}
+	j.Status = domain.ExportPending
+	return m.pool.QueryRow(
+		ctx,
+		`INSERT INTO ExportJobs(UserId, WorkspaceId, Format, Clicks, Query)
+		 VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5)
+		 RETURNING Id::text, CreatedAt`,
+		j.UserId,
+		j.WorkspaceId,
+		string(j.Format),
+		j.Clicks,
+		query,
+	).Scan(&j.Id, &j.CreatedAt)
+}
+
+const jobColumns = `Id::text, UserId::text, COALESCE(WorkspaceId::text, ''), Format,
+	Clicks, Query, Status, COALESCE(Error, ''), Rows, Size, Chunks, CreatedAt,
+	FinishedAt, ExpiresAt`
+
+func scanJob(row pgx.Row) (*domain.ExportJob, error) {
+	var j domain.ExportJob
+	var format, status string
+	var query []byte
+	err := row.Scan(
+		&j.Id,
+		&j.UserId,
+		&j.WorkspaceId,
+		&format,
+		&j.Clicks,
+		&query,
+		&status,
+		&j.Error,
+		&j.Rows,
+		&j.Size,
+		&j.Chunks,
+		&j.CreatedAt,
+		&j.FinishedAt,
+		&j.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	j.Format = domain.ExportFormat(format)
+	j.Status = domain.ExportStatus(status)
+	if err := json.Unmarshal(query, &j.Query); err != nil {
+		return nil, err
+	}
+	j.Query.UserId = j.UserId
+	j.Query.WorkspaceId = j.WorkspaceId
+	return &j, nil
+}
+
+// Job returns the job, or ErrNotFound if it doesn't exist or has expired
+func (m *Model) Job(ctx context.Context, id string) (*domain.ExportJob, error) {
+	j, err := scanJob(m.pool.QueryRow(
+		ctx,
+		`SELECT `+jobColumns+` FROM ExportJobs
+		 WHERE Id = $1 AND (ExpiresAt IS NULL OR ExpiresAt > now())`,
+		id,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return j, err
+}
+
+// Claim starts the oldest pending job and returns it, or nil if there are
+// none. Jobs started longer than the lease ago are considered abandoned by
+// their instances and are started again, from scratch.
+func (m *Model) Claim(ctx context.Context, lease time.Duration) (*domain.ExportJob, error) {
+	var j *domain.ExportJob
+	err := pgx.BeginFunc(ctx, m.pool, func(tx pgx.Tx) error {
+		var err error
+		j, err = scanJob(tx.QueryRow(
+			ctx,
+			`WITH next AS (
+			     SELECT Id AS NextId FROM ExportJobs
+			     WHERE Status = 'pending'
+			         OR (Status = 'running' AND StartedAt <= now() - make_interval(secs => $1))
+			     ORDER BY CreatedAt
+			     LIMIT 1
+			     FOR UPDATE SKIP LOCKED
+			 )
+			 UPDATE ExportJobs SET Status = 'running', StartedAt = now()
+			 FROM next
+			 WHERE Id = next.NextId
+			 RETURNING `+jobColumns,
+			lease.Seconds(),
+		))
+		if errors.Is(err, pgx.ErrNoRows) {
+			j = nil
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// chunks of an abandoned attempt
+		_, err = tx.Exec(ctx, `DELETE FROM ExportChunks WHERE JobId = $1`, j.Id)
+		return err
+	})
+	return j, err
+}
+
+// WriteChunk stores the chunk of the file of the job. Chunks are numbered
+// from zero.
+func (m *Model) WriteChunk(ctx context.Context, id string, seq int, data []byte) error {
+	_, err := m.pool.Exec(
+		ctx,
+		`INSERT INTO ExportChunks(JobId, Seq, Data) VALUES ($1, $2, $3)`,
+		id,
+		seq,
+		data,
+	)
+	return err
+}
+
+// Chunk returns the chunk of the file of the job
+func (m *Model) Chunk(ctx context.Context, id string, seq int) ([]byte, error) {
+	var data []byte
+	err := m.pool.QueryRow(
+		ctx,
+		`SELECT Data FROM ExportChunks WHERE JobId = $1 AND Seq = $2`,
+		id,
+		seq,
+	).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Finish marks the job as done. The job and its file expire after the ttl.
+func (m *Model) Finish(
+	ctx context.Context,
+	id string,
+	rows int64,
+	size int64,
+	chunks int,
+	ttl time.Duration,
+) error {
+	_, err := m.pool.Exec(
+		ctx,
+		`UPDATE ExportJobs
+		 SET Status = 'done', Rows = $2, Size = $3, Chunks = $4, FinishedAt = now(),
+		     ExpiresAt = now() + make_interval(secs => $5)
+		 WHERE Id = $1`,
+		id,
+		rows,
+		size,
+		chunks,
+		ttl.Seconds(),
+	)
+	return err
+}
+
+// Fail marks the job as failed and drops the chunks written so far. The job
+// expires after the ttl.
+func (m *Model) Fail(ctx context.Context, id string, reason string, ttl time.Duration) error {
+	return pgx.BeginFunc(ctx, m.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(
+			ctx,
+			`UPDATE ExportJobs
+			 SET Status = 'failed', Error = $2, FinishedAt = now(),
+			     ExpiresAt = now() + make_interval(secs => $3)
+			 WHERE Id = $1`,
+			id,
+			reason,
+			ttl.Seconds(),
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `DELETE FROM ExportChunks WHERE JobId = $1`, id)
+		return err
+	})
+}
+
+// Prune removes expired jobs along with their files
+func (m *Model) Prune(ctx context.Context) (int64, error) {
+	tag, err := m.pool.Exec(ctx, `DELETE FROM ExportJobs WHERE ExpiresAt <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Ping checks that the database is reachable
+func (m *Model) Ping(ctx context.Context) error {
+	return m.pool.Ping(ctx)
+}
+
+// Stat returns statistics of the connection pool
+func (m *Model) Stat() *pgxpool.Stat {
+	return m.pool.Stat()
+}
+
+func (m *Model) Close() {
+	m.pool.Close()
+}
@@ -0,0 +1,53 @@
+// Package pgbatch helps to write batches of independent rows.
+package pgbatch
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Exec runs the batch and returns the result of every statement, at the same
+// index.
+//
+// A batch is run in a single implicit transaction, so a failed statement
+// rolls back the others too. In that case the statements are rerun one by
+// one to find out which of them actually fail.
+func Exec(
+	ctx context.Context,
+	pool *pgxpool.Pool,
+	batch *pgx.Batch,
+) ([]pgconn.CommandTag, []error) {
+	tags := make([]pgconn.CommandTag, batch.Len())
+	errs := make([]error, batch.Len())
+
+	res := pool.SendBatch(ctx, batch)
+	failed := false
+	for i := range tags {
+		if tags[i], errs[i] = res.Exec(); errs[i] != nil {
+			failed = true
+			break
+		}
+	}
+	if err := res.Close(); err == nil && !failed {
+		return tags, errs
+	}
+
+	for i, q := range batch.QueuedQueries {
+		tags[i], errs[i] = pool.Exec(ctx, q.SQL, q.Arguments...)
+	}
+	return tags, errs
+}
+
+// UUID converts the string for copying into a uuid column. Empty strings
+// become NULL.
+func UUID(s string) (pgtype.UUID, error) {
+	var id pgtype.UUID
+	if s == "" {
+		return id, nil
+	}
+	return id, id.Scan(s)
+}
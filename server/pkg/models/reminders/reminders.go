@@ -0,0 +1,274 @@
+// Package reminders keeps notification preferences of users and the
+// reminders about expiring links sent to them
+package reminders
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/domain"
+	"shortener/pkg/tracing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Model struct {
+	pool *pgxpool.Pool
+}
+
+type remindersOption func(m *Model) error
+
+func WithPool(ctx context.Context, dsn string) remindersOption {
+	return func(m *Model) error {
+		conf, err := tracing.PoolConfig(dsn)
+		if err != nil {
+			return err
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, conf)
+		if err != nil {
+			return err
+		}
+
+		if err := pool.Ping(ctx); err != nil {
+			return err
+		}
+
+		m.pool = pool
+		return nil
+	}
+}
+
+func New(opts ...remindersOption) (*Model, error) {
+	m := new(Model)
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.pool == nil {
+		return nil, errors.New("no connection pool provided")
+	}
+	return m, nil
+}
+
+// ErrNoPreferences is returned for users who haven't set their preferences
+var ErrNoPreferences = errors.New("user has no notification preferences")
+
+func (m *Model) Preferences(
+	ctx context.Context,
+	userId string,
+) (*domain.NotificationPreferences, error) {
+	var p domain.NotificationPreferences
+	var channel, webhookUrl string
+	err := m.pool.QueryRow(
+		ctx,
+		`SELECT Enabled, Channel, COALESCE(WebhookUrl, ''), ReminderDays
+		 FROM NotificationPreferences WHERE UserId = $1`,
+		userId,
+	).Scan(&p.Enabled, &channel, &webhookUrl, &p.ReminderDays)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoPreferences
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.Channel = domain.NotificationChannel(channel)
+	p.WebhookUrl = webhookUrl
+	return &p, nil
+}
+
+func (m *Model) SetPreferences(
+	ctx context.Context,
+	userId string,
+	p *domain.NotificationPreferences,
+) error {
+	_, err := m.pool.Exec(
+		ctx,
+		`INSERT INTO NotificationPreferences(UserId, Enabled, Channel, WebhookUrl, ReminderDays)
+		 VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		 ON CONFLICT (UserId) DO UPDATE SET
+		     Enabled = EXCLUDED.Enabled,
+		     Channel = EXCLUDED.Channel,
+		     WebhookUrl = EXCLUDED.WebhookUrl,
+		     ReminderDays = EXCLUDED.ReminderDays,
+		     UpdatedAt = now()`,
+		userId,
+		p.Enabled,
+		string(p.Channel),
+		p.WebhookUrl,
+		p.ReminderDays,
+	)
+	return err
+}
+
+// Due returns at most limit reminders that haven't been sent yet, ordered
+// by user. A link is due at the least of the reminder days of its owner it
+// expires within, defaultDays are used for users without preferences.
+// Anonymous links aren't reminded about.
+func (m *Model) Due(
+	ctx context.Context,
+	now time.Time,
+	defaultDays []int,
+	limit int,
+) ([]*domain.Reminder, error) {
+	rows, err := m.pool.Query(
+		ctx,
+		`WITH due AS (
+		     SELECT DISTINCT ON (Urls.ShortUrl)
+		            Urls.ShortUrl, Urls.LongUrl, Urls.ExpirationDate, d.Days,
+		            Urls.UserId, Users.Email, Users.Name,
+		            COALESCE(p.Channel, 'email') AS Channel,
+		            COALESCE(p.WebhookUrl, '') AS WebhookUrl
+		     FROM Urls
+		     JOIN Users ON Users.Id = Urls.UserId
+		     LEFT JOIN NotificationPreferences p ON p.UserId = Urls.UserId
+		     CROSS JOIN LATERAL unnest(COALESCE(p.ReminderDays, $2::int[])) AS d(Days)
+		     WHERE COALESCE(p.Enabled, true)
+		       AND Urls.UserId <> $4
+		       AND Urls.ExpirationDate > $1
+		       AND Urls.ExpirationDate <= $1 + make_interval(days => d.Days)
+		     ORDER BY Urls.ShortUrl, d.Days
+		 )
+		 SELECT ShortUrl, LongUrl, ExpirationDate, Days,
+		        UserId::text, Email, Name, Channel, WebhookUrl
+		 FROM due
+		 WHERE NOT EXISTS (
+		     SELECT 1 FROM ExpiryReminders r
+		     WHERE r.ShortUrl = due.ShortUrl
+		       AND r.ExpirationDate = due.ExpirationDate
+		       AND r.Days = due.Days
+		 )
+		 ORDER BY UserId, ExpirationDate
+		 LIMIT $3`,
+		now,
+		defaultDays,
+		limit,
+		domain.AnonymousUserId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*domain.Reminder, error) {
+		var r domain.Reminder
+		var channel string
+		err := row.Scan(
+			&r.ShortUrl,
+			&r.LongUrl,
+			&r.ExpirationDate,
+			&r.Days,
+			&r.UserId,
+			&r.Email,
+			&r.Name,
+			&channel,
+			&r.WebhookUrl,
+		)
+		r.Channel = domain.NotificationChannel(channel)
+		return &r, err
+	})
+}
+
+// Claim records the reminders as sent and returns the ones that haven't
+// been claimed before, e.g. by another instance. Claimed reminders aren't
+// returned by Due anymore, so that every one is sent at most once.
+func (m *Model) Claim(
+	ctx context.Context,
+	rr []*domain.Reminder,
+) ([]*domain.Reminder, error) {
+	codes, dates, days := columns(rr)
+	rows, err := m.pool.Query(
+		ctx,
+		`INSERT INTO ExpiryReminders(ShortUrl, ExpirationDate, Days)
+		 SELECT * FROM unnest($1::text[], $2::timestamp[], $3::int[])
+		 ON CONFLICT DO NOTHING
+		 RETURNING ShortUrl, Days`,
+		codes,
+		dates,
+		days,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		shortUrl string
+		days     int
+	}
+	claimed := map[key]bool{}
+	for rows.Next() {
+		var k key
+		if err := rows.Scan(&k.shortUrl, &k.days); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		claimed[k] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var res []*domain.Reminder
+	for _, r := range rr {
+		if claimed[key{r.ShortUrl, r.Days}] {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+// Release forgets claimed reminders which couldn't be sent, so that they
+// are due again
+func (m *Model) Release(ctx context.Context, rr []*domain.Reminder) error {
+	codes, dates, days := columns(rr)
+	_, err := m.pool.Exec(
+		ctx,
+		`DELETE FROM ExpiryReminders
+		 WHERE (ShortUrl, ExpirationDate, Days) IN (
+		     SELECT * FROM unnest($1::text[], $2::timestamp[], $3::int[])
+		 )`,
+		codes,
+		dates,
+		days,
+	)
+	return err
+}
+
+// Prune removes reminders about links that have expired before the moment.
+// They can't be due anymore.
+func (m *Model) Prune(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := m.pool.Exec(
+		ctx,
+		`DELETE FROM ExpiryReminders WHERE ExpirationDate < $1`,
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func columns(rr []*domain.Reminder) ([]string, []time.Time, []int) {
+	codes := make([]string, len(rr))
+	dates := make([]time.Time, len(rr))
+	days := make([]int, len(rr))
+	for i, r := range rr {
+		codes[i] = r.ShortUrl
+		dates[i] = r.ExpirationDate
+		days[i] = r.Days
+	}
+	return codes, dates, days
+}
+
+// Ping checks that the database is reachable
+func (m *Model) Ping(ctx context.Context) error {
+	return m.pool.Ping(ctx)
+}
+
+// Stat returns statistics of the connection pool
+func (m *Model) Stat() *pgxpool.Stat {
+	return m.pool.Stat()
+}
+
+func (m *Model) Close() {
+	m.pool.Close()
+}
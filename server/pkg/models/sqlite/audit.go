@@ -0,0 +1,35 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// AuditLog is an append-only log of security related events
+type AuditLog struct {
+	db *sql.DB
+}
+
+func NewAuditLog(d *DB) *AuditLog {
+	return &AuditLog{db: d.db}
+}
+
+func (m *AuditLog) Record(
+	ctx context.Context,
+	event string,
+	email string,
+	ip string,
+	details string,
+) error {
+	_, err := m.db.ExecContext(
+		ctx,
+		`INSERT INTO AuditLog(Event, Email, Ip, Details, CreatedAt) VALUES (?, ?, ?, ?, ?)`,
+		event,
+		email,
+		ip,
+		details,
+		millis(time.Now()),
+	)
+	return err
+}
@@ -0,0 +1,121 @@
+-- SQLite counterpart of the Postgres migrations. Ids are lowercase uuid
+-- strings, times are unix milliseconds.
+
+CREATE TABLE IF NOT EXISTS Users (
+    Id Text PRIMARY KEY,
+    Name Text NOT NULL,
+    Email Text NOT NULL UNIQUE,
+    HashedPassword Text NOT NULL,
+    TotpSecret Text,
+    TotpEnabled Integer NOT NULL DEFAULT 0,
+    TotpLastStep Integer NOT NULL DEFAULT 0,
+    IdempotencyKey Text UNIQUE
+);
+
+-- this uuid is reserved for anonymous users
+INSERT OR IGNORE INTO Users(Id, Name, Email, HashedPassword)
+    VALUES ('db092ed4-306a-4d4f-be5f-fd2f1487edbe', 'dummy value', 'dumy value', 'dummy value');
+
+CREATE TABLE IF NOT EXISTS Workspaces (
+    Id Text PRIMARY KEY,
+    Name Text NOT NULL,
+    CreatedAt Integer NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS WorkspaceMembers (
+    WorkspaceId Text NOT NULL REFERENCES Workspaces(Id) ON DELETE CASCADE,
+    UserId Text NOT NULL REFERENCES Users(Id) ON DELETE CASCADE,
+    Role Text NOT NULL,
+    JoinedAt Integer NOT NULL,
+    PRIMARY KEY (WorkspaceId, UserId)
+);
+
+CREATE INDEX IF NOT EXISTS workspace_members_user_ids ON WorkspaceMembers(UserId);
+
+CREATE TABLE IF NOT EXISTS WorkspaceInvites (
+    TokenHash Text PRIMARY KEY,
+    WorkspaceId Text NOT NULL REFERENCES Workspaces(Id) ON DELETE CASCADE,
+    Email Text NOT NULL,
+    Role Text NOT NULL,
+    InvitedBy Text NOT NULL REFERENCES Users(Id) ON DELETE CASCADE,
+    ExpiresAt Integer NOT NULL,
+    AcceptedAt Integer
+);
+
+CREATE TABLE IF NOT EXISTS Urls (
+    ShortUrl Text PRIMARY KEY,
+    LongUrl Text NOT NULL,
+    UserId Text NOT NULL REFERENCES Users(Id),
+    WorkspaceId Text REFERENCES Workspaces(Id),
+    ExpirationDate Integer NOT NULL,
+    IdempotencyKey Text UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS urls_user_ids ON Urls(UserId);
+
+CREATE INDEX IF NOT EXISTS urls_workspace_ids ON Urls(WorkspaceId);
+
+-- links renewed when they are clicked shortly before expiring. A table of
+-- its own, so that databases created before renewals get it too
+CREATE TABLE IF NOT EXISTS AutoRenewals (
+    ShortUrl Text PRIMARY KEY REFERENCES Urls(ShortUrl) ON DELETE CASCADE
+);
+
+-- what the history of links is sorted and filtered by. Links stored before
+-- the table has been added have no details, they are listed as created at
+-- the epoch. Clicks aren't counted in the all-in-one mode.
+CREATE TABLE IF NOT EXISTS UrlDetails (
+    ShortUrl Text PRIMARY KEY REFERENCES Urls(ShortUrl) ON DELETE CASCADE,
+    CreatedAt Integer NOT NULL,
+    Title Text NOT NULL DEFAULT '',
+    -- host of the long url
+    Domain Text NOT NULL DEFAULT '',
+    -- only filters the history
+    Disabled Integer NOT NULL DEFAULT 0,
+    Clicks Integer NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS url_details_created_at ON UrlDetails(CreatedAt);
+
+CREATE TABLE IF NOT EXISTS UrlTags (
+    ShortUrl Text NOT NULL REFERENCES Urls(ShortUrl) ON DELETE CASCADE,
+    Tag Text NOT NULL,
+    PRIMARY KEY (ShortUrl, Tag)
+);
+
+CREATE INDEX IF NOT EXISTS url_tags_tags ON UrlTags(Tag);
+
+CREATE TABLE IF NOT EXISTS PasswordResets (
+    TokenHash Text PRIMARY KEY,
+    UserId Text NOT NULL REFERENCES Users(Id) ON DELETE CASCADE,
+    ExpiresAt Integer NOT NULL,
+    UsedAt Integer
+);
+
+CREATE INDEX IF NOT EXISTS password_resets_user_ids ON PasswordResets(UserId);
+
+CREATE TABLE IF NOT EXISTS AuditLog (
+    Id Integer PRIMARY KEY AUTOINCREMENT,
+    Event Text NOT NULL,
+    Email Text NOT NULL,
+    Ip Text NOT NULL,
+    Details Text NOT NULL DEFAULT '',
+    CreatedAt Integer NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS RecoveryCodes (
+    UserId Text NOT NULL REFERENCES Users(Id) ON DELETE CASCADE,
+    CodeHash Text NOT NULL,
+    UsedAt Integer,
+    PRIMARY KEY (UserId, CodeHash)
+);
+
+CREATE TABLE IF NOT EXISTS Identities (
+    Provider Text NOT NULL,
+    Subject Text NOT NULL,
+    UserId Text NOT NULL REFERENCES Users(Id) ON DELETE CASCADE,
+    CreatedAt Integer NOT NULL,
+    PRIMARY KEY (Provider, Subject)
+);
+
+CREATE INDEX IF NOT EXISTS identities_user_id ON Identities(UserId);
@@ -0,0 +1,76 @@
+// Package sqlite implements the models of the services over an embedded
+// SQLite database, for the all-in-one mode. Models return the errors of their
+// postgres counterparts, so the services handle them the same way.
+package sqlite
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	_ "embed"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+//go:embed schema.sql
+var schema string
+
+type DB struct {
+	db *sql.DB
+}
+
+// Open opens the database file, or an in-memory database if path is
+// ":memory:", and creates the tables that don't exist yet
+func Open(ctx context.Context, path string) (*DB, error) {
+	db, err := sql.Open(
+		"sqlite",
+		path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)",
+	)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer anyway. A single connection also keeps an
+	// in-memory database alive.
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+// Ping checks that the database file can be used
+func (d *DB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.UnixMilli(ms)
+}
+
+func hash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// nullable stores empty strings as NULL
+func nullable(s string) any {
+	if s == "" {
+		return nil
+	}
+	return strings.ToLower(s)
+}
@@ -0,0 +1,392 @@
+package sqlite
+
+import (
+	"context"
+	"shortener/pkg/domain"
+	"shortener/pkg/models/urls"
+	"shortener/pkg/models/users"
+	"shortener/pkg/models/workspaces"
+	"shortener/pkg/responses"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// uuid of the anonymous user, see schema.sql
+const anonymousId = "db092ed4-306a-4d4f-be5f-fd2f1487edbe"
+
+func open(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open(context.Background(), ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestUrls(t *testing.T) {
+	ctx := context.Background()
+	u := NewUrls(open(t))
+
+	link := &responses.Shortener{
+		From:           anonymousId,
+		ShortUrl:       "abcde",
+		LongUrl:        "https://example.com",
+		ExpirationDate: time.Now().Add(time.Hour),
+		IdempotencyKey: uuid.NewString(),
+	}
+	expired := &responses.Shortener{
+		From:           anonymousId,
+		ShortUrl:       "old00",
+		LongUrl:        "https://example.com/old",
+		ExpirationDate: time.Now().Add(-time.Hour),
+		IdempotencyKey: uuid.NewString(),
+	}
+	require.Equal(t, []error{nil, nil}, u.Insert(ctx, []*responses.Shortener{link, expired}))
+
+	// a redelivered message is skipped, another link with the same code conflicts
+	taken := *link
+	taken.IdempotencyKey = uuid.NewString()
+	errs := u.Insert(ctx, []*responses.Shortener{link, &taken})
+	require.NoError(t, errs[0])
+	require.ErrorIs(t, errs[1], domain.ErrConflict)
+
+	exists, err := u.CheckExistence(ctx, "abcde")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	takenUrls, err := u.TakenShortUrls(ctx, []string{"free0", "abcde", "old00"})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"abcde", "old00"}, takenUrls)
+
+	long, err := u.GetLongUrl(ctx, "abcde")
+	require.NoError(t, err)
+	require.Equal(t, link.LongUrl, long)
+
+	_, err = u.GetLongUrl(ctx, "old00")
+	require.ErrorIs(t, err, urls.ErrNotFound)
+
+	history, err := u.History(ctx, &domain.HistoryQuery{
+		UserId: anonymousId,
+		Sort:   domain.SortCreated,
+		Status: domain.StatusActive,
+		Limit:  10,
+	})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, "abcde", history[0].ShortUrl)
+	require.WithinDuration(t, link.ExpirationDate, history[0].ExpirationDate, time.Millisecond)
+}
+
+func TestUrlsHistory(t *testing.T) {
+	ctx := context.Background()
+	u := NewUrls(open(t))
+
+	rr := []*responses.Shortener{
+		{ShortUrl: "aaaaa", LongUrl: "https://example.com/release-notes", Title: "Changelog", Tags: []string{"docs"}},
+		{ShortUrl: "bbbbb", LongUrl: "https://Blog.example.org/post", Tags: []string{"blog", "docs"}},
+		{ShortUrl: "ccccc", LongUrl: "https://example.com/pricing", Title: "Release plans"},
+		{ShortUrl: "ddddd", LongUrl: "https://example.com/old", ExpirationDate: time.Now().Add(-time.Hour)},
+	}
+	for _, r := range rr {
+		r.From = anonymousId
+		if r.ExpirationDate.IsZero() {
+			r.ExpirationDate = time.Now().Add(time.Hour)
+		}
+	}
+	require.Equal(t, make([]error, len(rr)), u.Insert(ctx, rr))
+
+	codes := func(q domain.HistoryQuery) []string {
+		t.Helper()
+		q.UserId = anonymousId
+		if q.Sort == "" {
+			q.Sort = domain.SortCreated
+		}
+		if q.Limit == 0 {
+			q.Limit = 10
+		}
+		links, err := u.History(ctx, &q)
+		require.NoError(t, err)
+		res := []string{}
+		for _, l := range links {
+			res = append(res, l.ShortUrl)
+		}
+		return res
+	}
+
+	require.Equal(t, []string{"aaaaa", "bbbbb", "ccccc", "ddddd"}, codes(domain.HistoryQuery{}))
+	require.Equal(t, []string{"ccccc", "bbbbb", "aaaaa"}, codes(domain.HistoryQuery{
+		Status: domain.StatusActive,
+		Desc:   true,
+	}))
+	require.Equal(t, []string{"ddddd"}, codes(domain.HistoryQuery{Status: domain.StatusExpired}))
+	require.Equal(t, []string{"bbbbb"}, codes(domain.HistoryQuery{Domain: "blog.example.org"}))
+	require.Equal(t, []string{"aaaaa", "bbbbb"}, codes(domain.HistoryQuery{Tag: "docs"}))
+	require.Equal(t, []string{"aaaaa", "ccccc"}, codes(domain.HistoryQuery{Search: "release"}))
+	require.Equal(t, []string{"aaaaa"}, codes(domain.HistoryQuery{Search: "release notes"}))
+	require.Empty(t, codes(domain.HistoryQuery{CreatedBefore: time.Now().Add(-time.Hour)}))
+
+	// pages follow each other by the sort value and then by the short url
+	page := codes(domain.HistoryQuery{Sort: domain.SortExpiration, Limit: 2})
+	require.Equal(t, []string{"ddddd", "aaaaa"}, page)
+	links, err := u.History(ctx, &domain.HistoryQuery{
+		UserId: anonymousId,
+		Sort:   domain.SortExpiration,
+		Limit:  1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"aaaaa", "bbbbb"}, codes(domain.HistoryQuery{
+		Sort:  domain.SortExpiration,
+		Limit: 2,
+		After: links[0].Cursor(domain.SortExpiration),
+	}))
+
+	links, err = u.History(ctx, &domain.HistoryQuery{
+		UserId: anonymousId,
+		Sort:   domain.SortCreated,
+		Tag:    "blog",
+		Limit:  1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"blog", "docs"}, links[0].Tags)
+	require.Equal(t, int64(0), links[0].Clicks)
+	require.False(t, links[0].Disabled)
+	require.WithinDuration(t, time.Now(), links[0].CreatedAt, time.Minute)
+}
+
+func TestDisabledUrlsOnlyFilterHistory(t *testing.T) {
+	ctx := context.Background()
+	db := open(t)
+	u := NewUrls(db)
+
+	link := &responses.Shortener{
+		From:           anonymousId,
+		ShortUrl:       "off00",
+		LongUrl:        "https://example.com/off",
+		ExpirationDate: time.Now().Add(time.Hour),
+	}
+	require.Equal(t, []error{nil}, u.Insert(ctx, []*responses.Shortener{link}))
+	_, err := db.db.ExecContext(ctx, `UPDATE UrlDetails SET Disabled = 1 WHERE ShortUrl = 'off00'`)
+	require.NoError(t, err)
+
+	long, err := u.GetLongUrl(ctx, "off00")
+	require.NoError(t, err)
+	require.Equal(t, link.LongUrl, long)
+
+	for status, n := range map[domain.LinkStatus]int{
+		domain.StatusActive:   0,
+		domain.StatusDisabled: 1,
+	} {
+		links, err := u.History(ctx, &domain.HistoryQuery{
+			UserId: anonymousId,
+			Sort:   domain.SortCreated,
+			Status: status,
+			Limit:  10,
+		})
+		require.NoError(t, err)
+		require.Len(t, links, n, status)
+	}
+}
+
+func TestRenewUrls(t *testing.T) {
+	ctx := context.Background()
+	u := NewUrls(open(t))
+
+	expired := &responses.Shortener{
+		From:           anonymousId,
+		ShortUrl:       "old00",
+		LongUrl:        "https://example.com/old",
+		ExpirationDate: time.Now().Add(-time.Hour),
+	}
+	require.Equal(t, []error{nil}, u.Insert(ctx, []*responses.Shortener{expired}))
+
+	l, err := u.Link(ctx, "old00")
+	require.NoError(t, err)
+	require.Equal(t, domain.PlanFree, l.Plan)
+	require.False(t, l.AutoRenew)
+
+	renewed := time.Now().Add(24 * time.Hour)
+	require.NoError(t, u.Renew(ctx, "old00", renewed, true))
+	l, err = u.Link(ctx, "old00")
+	require.NoError(t, err)
+	require.True(t, l.AutoRenew)
+	require.WithinDuration(t, renewed, l.ExpirationDate, time.Millisecond)
+
+	long, err := u.GetLongUrl(ctx, "old00")
+	require.NoError(t, err)
+	require.Equal(t, expired.LongUrl, long)
+
+	require.NoError(t, u.Renew(ctx, "old00", renewed, false))
+	l, err = u.Link(ctx, "old00")
+	require.NoError(t, err)
+	require.False(t, l.AutoRenew)
+
+	require.ErrorIs(t, u.Renew(ctx, "none0", renewed, false), urls.ErrNotFound)
+	_, err = u.Link(ctx, "none0")
+	require.ErrorIs(t, err, urls.ErrNotFound)
+}
+
+func TestDeleteUrls(t *testing.T) {
+	ctx := context.Background()
+	u := NewUrls(open(t))
+
+	link := &responses.Shortener{
+		From:           anonymousId,
+		ShortUrl:       "del00",
+		LongUrl:        "https://example.com/del",
+		ExpirationDate: time.Now().Add(time.Hour),
+	}
+	require.Equal(t, []error{nil}, u.Insert(ctx, []*responses.Shortener{link}))
+	require.NoError(t, u.Renew(ctx, "del00", link.ExpirationDate, true))
+
+	require.NoError(t, u.Delete(ctx, "del00"))
+	_, err := u.GetLongUrl(ctx, "del00")
+	require.ErrorIs(t, err, urls.ErrNotFound)
+	require.ErrorIs(t, u.Delete(ctx, "del00"), urls.ErrNotFound)
+
+	// the short url is free again
+	require.Equal(t, []error{nil}, u.Insert(ctx, []*responses.Shortener{link}))
+}
+
+func TestMoveUrlsToWorkspace(t *testing.T) {
+	ctx := context.Background()
+	db := open(t)
+	u := NewUrls(db)
+	workspaceId, err := NewWorkspaces(db).Create(ctx, anonymousId, "workspace")
+	require.NoError(t, err)
+	other := &responses.Authenticator{
+		Id:             uuid.NewString(),
+		Name:           "other",
+		Email:          "other@example.com",
+		HashedPassword: "hash",
+	}
+	require.Equal(t, []error{nil}, NewUsers(db).Insert(ctx, []*responses.Authenticator{other}))
+
+	link := func(code, userId, workspaceId string) *responses.Shortener {
+		return &responses.Shortener{
+			From:           userId,
+			ShortUrl:       code,
+			LongUrl:        "https://example.com/" + code,
+			ExpirationDate: time.Now().Add(time.Hour),
+			WorkspaceId:    workspaceId,
+		}
+	}
+	require.Equal(t, []error{nil, nil, nil, nil}, u.Insert(ctx, []*responses.Shortener{
+		link("mine0", anonymousId, ""),
+		link("mine1", anonymousId, ""),
+		link("other", other.Id, ""),
+		link("shrd0", anonymousId, workspaceId),
+	}))
+
+	moved, err := u.MoveToWorkspace(
+		ctx,
+		anonymousId,
+		workspaceId,
+		[]string{"mine0", "other", "shrd0", "none0"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{"mine0"}, moved)
+
+	for code, want := range map[string]string{
+		"mine0": workspaceId,
+		"mine1": "",
+		"other": "",
+		"shrd0": workspaceId,
+	} {
+		l, err := u.Link(ctx, code)
+		require.NoError(t, err)
+		require.Equal(t, want, l.WorkspaceId, code)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	ctx := context.Background()
+	u := NewUsers(open(t))
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	user := &responses.Authenticator{
+		Id:             uuid.NewString(),
+		Name:           "name",
+		Email:          "user@example.com",
+		HashedPassword: string(hashed),
+		IdempotencyKey: uuid.NewString(),
+	}
+	require.Equal(t, []error{nil}, u.Insert(ctx, []*responses.Authenticator{user}))
+
+	sameEmail := *user
+	sameEmail.Id = uuid.NewString()
+	sameEmail.IdempotencyKey = uuid.NewString()
+	require.ErrorIs(t, u.Insert(ctx, []*responses.Authenticator{&sameEmail})[0], domain.ErrConflict)
+
+	id, err := u.Authenticate(ctx, user.Email, "password")
+	require.NoError(t, err)
+	require.Equal(t, user.Id, id)
+
+	_, err = u.Authenticate(ctx, user.Email, "wrong")
+	require.ErrorIs(t, err, users.ErrWrongCredentials)
+
+	require.NoError(t, u.CreateResetToken(ctx, user.Id, "token", time.Hour))
+	hashed, err = bcrypt.GenerateFromPassword([]byte("new password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	id, err = u.ResetPassword(ctx, "token", string(hashed))
+	require.NoError(t, err)
+	require.Equal(t, user.Id, id)
+	_, err = u.Authenticate(ctx, user.Email, "new password")
+	require.NoError(t, err)
+	_, err = u.ResetPassword(ctx, "token", string(hashed))
+	require.ErrorIs(t, err, users.ErrInvalidResetToken)
+
+	require.NoError(t, u.SetPendingTotpSecret(ctx, user.Id, "secret"))
+	require.NoError(t, u.EnableTotp(ctx, user.Id, []string{"code"}))
+	totp, err := u.GetTotp(ctx, user.Id)
+	require.NoError(t, err)
+	require.Equal(t, users.Totp{Secret: "secret", Enabled: true}, totp)
+	require.ErrorIs(t, u.SetPendingTotpSecret(ctx, user.Id, "other"), users.ErrTotpEnabled)
+
+	used, err := u.ConsumeRecoveryCode(ctx, user.Id, " CODE ")
+	require.NoError(t, err)
+	require.True(t, used)
+	used, err = u.ConsumeRecoveryCode(ctx, user.Id, "code")
+	require.NoError(t, err)
+	require.False(t, used)
+}
+
+func TestWorkspaces(t *testing.T) {
+	ctx := context.Background()
+	db := open(t)
+	m := NewWorkspaces(db)
+
+	member := &responses.Authenticator{
+		Id:             uuid.NewString(),
+		Name:           "member",
+		Email:          "member@example.com",
+		HashedPassword: "hash",
+	}
+	require.Equal(t, []error{nil}, NewUsers(db).Insert(ctx, []*responses.Authenticator{member}))
+
+	id, err := m.Create(ctx, anonymousId, "workspace")
+	require.NoError(t, err)
+
+	require.ErrorIs(t, m.RemoveMember(ctx, id, anonymousId), workspaces.ErrLastOwner)
+
+	require.NoError(t, m.CreateInvite(ctx, id, member.Email, domain.RoleEditor, anonymousId, "invite", time.Hour))
+	_, _, err = m.AcceptInvite(ctx, "invite", member.Id, "other@example.com")
+	require.ErrorIs(t, err, workspaces.ErrInvalidInvite)
+	workspaceId, role, err := m.AcceptInvite(ctx, "invite", member.Id, member.Email)
+	require.NoError(t, err)
+	require.Equal(t, id, workspaceId)
+	require.Equal(t, domain.RoleEditor, role)
+
+	members, err := m.Members(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+
+	require.NoError(t, m.SetRole(ctx, id, member.Id, domain.RoleOwner))
+	require.NoError(t, m.RemoveMember(ctx, id, anonymousId))
+
+	_, err = m.Role(ctx, id, anonymousId)
+	require.ErrorIs(t, err, workspaces.ErrNotMember)
+}
@@ -0,0 +1,487 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"shortener/pkg/domain"
+	"shortener/pkg/models/urls"
+	"shortener/pkg/responses"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type Urls struct {
+	db *sql.DB
+}
+
+func NewUrls(d *DB) *Urls {
+	return &Urls{db: d.db}
+}
+
+func (u *Urls) CheckExistence(ctx context.Context, shortUrl string) (bool, error) {
+	var res bool
+	err := u.db.QueryRowContext(
+		ctx,
+		`SELECT EXISTS(SELECT 1 FROM Urls WHERE ShortUrl = ?)`,
+		shortUrl,
+	).Scan(&res)
+	return res, err
+}
+
+// TakenShortUrls returns the short urls of the list that are taken by links,
+// in no particular order
+func (u *Urls) TakenShortUrls(ctx context.Context, shortUrls []string) ([]string, error) {
+	if len(shortUrls) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(shortUrls))
+	for i, shortUrl := range shortUrls {
+		args[i] = shortUrl
+	}
+	rows, err := u.db.QueryContext(
+		ctx,
+		`SELECT ShortUrl FROM Urls
+		 WHERE ShortUrl IN (?`+strings.Repeat(", ?", len(shortUrls)-1)+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var taken []string
+	for rows.Next() {
+		var shortUrl string
+		if err := rows.Scan(&shortUrl); err != nil {
+			return nil, err
+		}
+		taken = append(taken, shortUrl)
+	}
+	return taken, rows.Err()
+}
+
+func (u *Urls) GetLongUrl(ctx context.Context, shortUrl string) (string, error) {
+	var longUrl string
+	err := u.db.QueryRowContext(
+		ctx,
+		`SELECT LongUrl FROM Urls WHERE ShortUrl = ? AND ? < ExpirationDate`,
+		shortUrl,
+		millis(time.Now()),
+	).Scan(&longUrl)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", urls.ErrNotFound
+	}
+	return longUrl, err
+}
+
+// Link returns the link, expired or not. There are no plans in the
+// all-in-one mode, every link is on the free one.
+func (u *Urls) Link(ctx context.Context, shortUrl string) (*domain.Link, error) {
+	l := domain.Link{Plan: domain.PlanFree}
+	var workspaceId sql.NullString
+	var expiration int64
+	err := u.db.QueryRowContext(
+		ctx,
+		`SELECT Urls.ShortUrl, Urls.LongUrl, Urls.UserId, Urls.WorkspaceId,
+		        Urls.ExpirationDate, AutoRenewals.ShortUrl IS NOT NULL
+		 FROM Urls
+		 LEFT JOIN AutoRenewals ON AutoRenewals.ShortUrl = Urls.ShortUrl
+		 WHERE Urls.ShortUrl = ?`,
+		shortUrl,
+	).Scan(&l.ShortUrl, &l.LongUrl, &l.UserId, &workspaceId, &expiration, &l.AutoRenew)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, urls.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.WorkspaceId = workspaceId.String
+	l.ExpirationDate = fromMillis(expiration)
+	return &l, nil
+}
+
+// Renew sets the expiration date and the auto-renewal of the link
+func (u *Urls) Renew(
+	ctx context.Context,
+	shortUrl string,
+	expiration time.Time,
+	autoRenew bool,
+) error {
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		`UPDATE Urls SET ExpirationDate = ? WHERE ShortUrl = ?`,
+		millis(expiration),
+		shortUrl,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return urls.ErrNotFound
+	}
+
+	query := `DELETE FROM AutoRenewals WHERE ShortUrl = ?`
+	if autoRenew {
+		query = `INSERT OR IGNORE INTO AutoRenewals(ShortUrl) VALUES (?)`
+	}
+	if _, err := tx.ExecContext(ctx, query, shortUrl); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MoveToWorkspace moves the personal links of the user among the short
+// urls into the workspace, and returns the short urls of the moved ones in
+// no particular order
+func (u *Urls) MoveToWorkspace(
+	ctx context.Context,
+	userId string,
+	workspaceId string,
+	shortUrls []string,
+) ([]string, error) {
+	if len(shortUrls) == 0 {
+		return nil, nil
+	}
+	args := []any{workspaceId, userId}
+	for _, shortUrl := range shortUrls {
+		args = append(args, shortUrl)
+	}
+	rows, err := u.db.QueryContext(
+		ctx,
+		`UPDATE Urls SET WorkspaceId = ?
+		 WHERE UserId = ? AND WorkspaceId IS NULL
+		     AND ShortUrl IN (?`+strings.Repeat(", ?", len(shortUrls)-1)+`)
+		 RETURNING ShortUrl`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var moved []string
+	for rows.Next() {
+		var shortUrl string
+		if err := rows.Scan(&shortUrl); err != nil {
+			return nil, err
+		}
+		moved = append(moved, shortUrl)
+	}
+	return moved, rows.Err()
+}
+
+// Delete removes the link along with its auto-renewal and details
+func (u *Urls) Delete(ctx context.Context, shortUrl string) error {
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"AutoRenewals", "UrlDetails", "UrlTags"} {
+		if _, err := tx.ExecContext(
+			ctx,
+			`DELETE FROM `+table+` WHERE ShortUrl = ?`,
+			shortUrl,
+		); err != nil {
+			return err
+		}
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM Urls WHERE ShortUrl = ?`, shortUrl)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return urls.ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// Insert stores the links the way the postgres model does: links that have
+// already been stored from the same message are skipped, links which short
+// url is taken by another one fail with domain.ErrConflict
+func (u *Urls) Insert(ctx context.Context, rr []*responses.Shortener) []error {
+	errs := make([]error, len(rr))
+	for i, r := range rr {
+		inserted, err := u.insert(ctx, r)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if !inserted {
+			errs[i] = u.checkDuplicate(ctx, r)
+		}
+	}
+	return errs
+}
+
+// insert stores the link with its details, unless its short url or its
+// idempotency key is taken
+func (u *Urls) insert(ctx context.Context, r *responses.Shortener) (bool, error) {
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO Urls(ShortUrl, LongUrl, UserId, ExpirationDate, WorkspaceId, IdempotencyKey)
+		 VALUES (?, ?, ?, ?, ?, ?)
+		 ON CONFLICT DO NOTHING`,
+		r.ShortUrl,
+		r.LongUrl,
+		nullable(r.From),
+		millis(r.ExpirationDate),
+		nullable(r.WorkspaceId),
+		nullable(r.IdempotencyKey),
+	)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO UrlDetails(ShortUrl, CreatedAt, Title, Domain) VALUES (?, ?, ?, ?)`,
+		r.ShortUrl,
+		millis(time.Now()),
+		r.Title,
+		host(r.LongUrl),
+	); err != nil {
+		return false, err
+	}
+	for _, tag := range r.Tags {
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT OR IGNORE INTO UrlTags(ShortUrl, Tag) VALUES (?, ?)`,
+			r.ShortUrl,
+			tag,
+		); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// host returns the lowercase host of the url, or an empty string if the url
+// can't be parsed
+func host(longUrl string) string {
+	u, err := url.Parse(longUrl)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func (u *Urls) checkDuplicate(ctx context.Context, r *responses.Shortener) error {
+	var duplicate bool
+	err := u.db.QueryRowContext(
+		ctx,
+		`SELECT EXISTS(SELECT 1 FROM Urls WHERE IdempotencyKey = ?)`,
+		nullable(r.IdempotencyKey),
+	).Scan(&duplicate)
+	if err != nil {
+		return err
+	}
+	if duplicate {
+		return nil
+	}
+	return fmt.Errorf("%w: short url %s is taken", domain.ErrConflict, r.ShortUrl)
+}
+
+// historySorts are the expressions links are sorted by. Links without
+// details are sorted as created at the epoch and never clicked.
+var historySorts = map[domain.HistorySort]string{
+	domain.SortCreated:    "COALESCE(UrlDetails.CreatedAt, 0)",
+	domain.SortExpiration: "Urls.ExpirationDate",
+	domain.SortClicks:     "COALESCE(UrlDetails.Clicks, 0)",
+}
+
+// historyFilters returns conditions of the links the query selects, the
+// cursor aside, and their arguments
+func historyFilters(q *domain.HistoryQuery) ([]string, []any) {
+	var where []string
+	var args []any
+	if q.WorkspaceId != "" {
+		where = append(where, "Urls.WorkspaceId = ?")
+		args = append(args, nullable(q.WorkspaceId))
+	} else {
+		where = append(where, "Urls.UserId = ?", "Urls.WorkspaceId IS NULL")
+		args = append(args, nullable(q.UserId))
+	}
+	now := millis(time.Now())
+	switch q.Status {
+	case domain.StatusActive:
+		where = append(where, "Urls.ExpirationDate > ?", "NOT COALESCE(UrlDetails.Disabled, 0)")
+		args = append(args, now)
+	case domain.StatusExpired:
+		where = append(where, "Urls.ExpirationDate <= ?")
+		args = append(args, now)
+	case domain.StatusDisabled:
+		where = append(where, "COALESCE(UrlDetails.Disabled, 0)")
+	}
+	if q.Domain != "" {
+		where = append(where, "UrlDetails.Domain = ?")
+		args = append(args, strings.ToLower(q.Domain))
+	}
+	if q.Tag != "" {
+		where = append(where, "EXISTS(SELECT 1 FROM UrlTags WHERE UrlTags.ShortUrl = Urls.ShortUrl AND Tag = ?)")
+		args = append(args, q.Tag)
+	}
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "COALESCE(UrlDetails.CreatedAt, 0) >= ?")
+		args = append(args, millis(q.CreatedAfter))
+	}
+	if !q.CreatedBefore.IsZero() {
+		where = append(where, "COALESCE(UrlDetails.CreatedAt, 0) < ?")
+		args = append(args, millis(q.CreatedBefore))
+	}
+	for _, word := range strings.FieldsFunc(q.Search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		where = append(where, "(Urls.LongUrl LIKE ? OR COALESCE(UrlDetails.Title, '') LIKE ?)")
+		args = append(args, "%"+word+"%", "%"+word+"%")
+	}
+	return where, args
+}
+
+// History returns a page of links the way the postgres model does. Words of
+// the search are looked up in long urls and titles as substrings.
+func (u *Urls) History(
+	ctx context.Context,
+	q *domain.HistoryQuery,
+) ([]*domain.UrlInfo, error) {
+	column, ok := historySorts[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+
+	where, args := historyFilters(q)
+	order, cmp := "ASC", ">"
+	if q.Desc {
+		order, cmp = "DESC", "<"
+	}
+	if q.After != nil {
+		where = append(where, "("+column+", Urls.ShortUrl) "+cmp+" (?, ?)")
+		switch q.Sort {
+		case domain.SortExpiration:
+			args = append(args, millis(q.After.ExpirationDate))
+		case domain.SortClicks:
+			args = append(args, q.After.Clicks)
+		default:
+			args = append(args, millis(q.After.CreatedAt))
+		}
+		args = append(args, q.After.ShortUrl)
+	}
+	args = append(args, q.Limit)
+
+	rows, err := u.db.QueryContext(
+		ctx,
+		`SELECT Urls.ShortUrl, Urls.LongUrl, Urls.ExpirationDate,
+		        COALESCE(UrlDetails.CreatedAt, 0), COALESCE(UrlDetails.Title, ''),
+		        COALESCE(UrlDetails.Disabled, 0), COALESCE(UrlDetails.Clicks, 0),
+		        COALESCE((SELECT group_concat(Tag, ',') FROM (
+		            SELECT Tag FROM UrlTags WHERE UrlTags.ShortUrl = Urls.ShortUrl ORDER BY Tag
+		        )), '')
+		 FROM Urls
+		 LEFT JOIN UrlDetails ON UrlDetails.ShortUrl = Urls.ShortUrl
+		 WHERE `+strings.Join(where, " AND ")+`
+		 ORDER BY `+column+" "+order+", Urls.ShortUrl "+order+`
+		 LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*domain.UrlInfo{}
+	for rows.Next() {
+		var record domain.UrlInfo
+		var expiration, createdAt int64
+		var tags string
+		if err := rows.Scan(
+			&record.ShortUrl,
+			&record.LongUrl,
+			&expiration,
+			&createdAt,
+			&record.Title,
+			&record.Disabled,
+			&record.Clicks,
+			&tags,
+		); err != nil {
+			return nil, err
+		}
+		record.ExpirationDate = fromMillis(expiration)
+		record.CreatedAt = fromMillis(createdAt)
+		record.Tags = []string{}
+		if tags != "" {
+			record.Tags = strings.Split(tags, ",")
+		}
+		res = append(res, &record)
+	}
+	return res, rows.Err()
+}
+
+// CountHistory returns the number of links that match the filters of the
+// query
+func (u *Urls) CountHistory(ctx context.Context, q *domain.HistoryQuery) (int64, error) {
+	where, args := historyFilters(q)
+	var n int64
+	err := u.db.QueryRowContext(
+		ctx,
+		`SELECT count(*) FROM Urls
+		 LEFT JOIN UrlDetails ON UrlDetails.ShortUrl = Urls.ShortUrl
+		 WHERE `+strings.Join(where, " AND "),
+		args...,
+	).Scan(&n)
+	return n, err
+}
+
+// ClickStats returns the totals of clicks, which are all zeros: clicks
+// aren't counted in the all-in-one mode
+func (u *Urls) ClickStats(
+	ctx context.Context,
+	shortUrls []string,
+) (map[string]*domain.ClickStats, error) {
+	res := make(map[string]*domain.ClickStats, len(shortUrls))
+	for _, shortUrl := range shortUrls {
+		var s domain.ClickStats
+		err := u.db.QueryRowContext(
+			ctx,
+			`SELECT COALESCE(UrlDetails.Clicks, 0) FROM Urls
+			 LEFT JOIN UrlDetails ON UrlDetails.ShortUrl = Urls.ShortUrl
+			 WHERE Urls.ShortUrl = ?`,
+			shortUrl,
+		).Scan(&s.Total)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res[shortUrl] = &s
+	}
+	return res, nil
+}
@@ -0,0 +1,495 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"shortener/pkg/domain"
+	"shortener/pkg/models/users"
+	"shortener/pkg/responses"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Users struct {
+	db *sql.DB
+}
+
+func NewUsers(d *DB) *Users {
+	return &Users{db: d.db}
+}
+
+// must be the same as the cost passwords are hashed with on registration
+const dummyHashCost = 12
+
+var dummyHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), dummyHashCost)
+	return h
+})
+
+func (u *Users) CheckExistence(ctx context.Context, email string) (bool, error) {
+	var res bool
+	err := u.db.QueryRowContext(
+		ctx,
+		`SELECT EXISTS(SELECT 1 FROM Users WHERE Email = ?)`,
+		email,
+	).Scan(&res)
+	return res, err
+}
+
+// Insert stores the users the way the postgres model does: users that have
+// already been stored from the same message are skipped, users which email
+// or id is taken by another one fail with domain.ErrConflict
+func (u *Users) Insert(ctx context.Context, rr []*responses.Authenticator) []error {
+	errs := make([]error, len(rr))
+	for i, r := range rr {
+		res, err := u.db.ExecContext(
+			ctx,
+			`INSERT INTO Users(Id, Name, Email, HashedPassword, IdempotencyKey)
+			 VALUES (?, ?, ?, ?, ?)
+			 ON CONFLICT DO NOTHING`,
+			nullable(r.Id),
+			r.Name,
+			r.Email,
+			r.HashedPassword,
+			nullable(r.IdempotencyKey),
+		)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			errs[i] = errors.Join(err, u.checkDuplicate(ctx, r))
+		}
+	}
+	return errs
+}
+
+func (u *Users) checkDuplicate(ctx context.Context, r *responses.Authenticator) error {
+	var duplicate bool
+	err := u.db.QueryRowContext(
+		ctx,
+		`SELECT EXISTS(SELECT 1 FROM Users WHERE IdempotencyKey = ?)`,
+		nullable(r.IdempotencyKey),
+	).Scan(&duplicate)
+	if err != nil {
+		return err
+	}
+	if duplicate {
+		return nil
+	}
+	return fmt.Errorf("%w: email %s is taken", domain.ErrConflict, r.Email)
+}
+
+func (u *Users) Authenticate(
+	ctx context.Context,
+	email string,
+	password string,
+) (string, error) {
+	var id, hashedPassword string
+	err := u.db.QueryRowContext(
+		ctx,
+		`SELECT Id, HashedPassword FROM Users WHERE Email = ?`,
+		email,
+	).Scan(&id, &hashedPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		// spend as much time as for an existing user
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return "", users.ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) != nil {
+		return "", users.ErrWrongCredentials
+	}
+	return id, nil
+}
+
+func (u *Users) GetIdByEmail(ctx context.Context, email string) (string, error) {
+	var id string
+	err := u.db.QueryRowContext(ctx, `SELECT Id FROM Users WHERE Email = ?`, email).
+		Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", users.ErrNotFound
+	}
+	return id, err
+}
+
+func (u *Users) CheckPassword(ctx context.Context, userId string, password string) error {
+	var hashedPassword string
+	err := u.db.QueryRowContext(
+		ctx,
+		`SELECT HashedPassword FROM Users WHERE Id = ?`,
+		nullable(userId),
+	).Scan(&hashedPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		return users.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) != nil {
+		return users.ErrWrongCredentials
+	}
+	return nil
+}
+
+// UpdatePassword replaces password hash of the user and invalidates all
+// pending password reset tokens of that user
+func (u *Users) UpdatePassword(
+	ctx context.Context,
+	userId string,
+	hashedPassword string,
+) error {
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := updatePassword(ctx, tx, userId, hashedPassword); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func updatePassword(
+	ctx context.Context,
+	tx *sql.Tx,
+	userId string,
+	hashedPassword string,
+) error {
+	res, err := tx.ExecContext(
+		ctx,
+		`UPDATE Users SET HashedPassword = ? WHERE Id = ?`,
+		hashedPassword,
+		nullable(userId),
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return users.ErrNotFound
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM PasswordResets WHERE UserId = ?`,
+		nullable(userId),
+	)
+	return err
+}
+
+func (u *Users) CreateResetToken(
+	ctx context.Context,
+	userId string,
+	token string,
+	ttl time.Duration,
+) error {
+	_, err := u.db.ExecContext(
+		ctx,
+		`INSERT INTO PasswordResets(TokenHash, UserId, ExpiresAt) VALUES (?, ?, ?)`,
+		hash(token),
+		nullable(userId),
+		millis(time.Now().Add(ttl)),
+	)
+	return err
+}
+
+// ResetPassword consumes the reset token and replaces password hash of its
+// owner in one transaction. Each token can be consumed only once.
+func (u *Users) ResetPassword(
+	ctx context.Context,
+	token string,
+	hashedPassword string,
+) (string, error) {
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := millis(time.Now())
+	var userId string
+	err = tx.QueryRowContext(
+		ctx,
+		`UPDATE PasswordResets SET UsedAt = ?
+		 WHERE TokenHash = ? AND UsedAt IS NULL AND ? < ExpiresAt
+		 RETURNING UserId`,
+		now,
+		hash(token),
+		now,
+	).Scan(&userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", users.ErrInvalidResetToken
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := updatePassword(ctx, tx, userId, hashedPassword); err != nil {
+		return "", err
+	}
+	return userId, tx.Commit()
+}
+
+func (u *Users) GetEmail(ctx context.Context, userId string) (string, error) {
+	var email string
+	err := u.db.QueryRowContext(
+		ctx,
+		`SELECT Email FROM Users WHERE Id = ?`,
+		nullable(userId),
+	).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", users.ErrNotFound
+	}
+	return email, err
+}
+
+func (u *Users) GetTotp(ctx context.Context, userId string) (users.Totp, error) {
+	var secret sql.NullString
+	var res users.Totp
+	err := u.db.QueryRowContext(
+		ctx,
+		`SELECT TotpSecret, TotpEnabled FROM Users WHERE Id = ?`,
+		nullable(userId),
+	).Scan(&secret, &res.Enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return users.Totp{}, users.ErrNotFound
+	}
+	res.Secret = secret.String
+	return res, err
+}
+
+// SetPendingTotpSecret starts (or restarts) enrollment
+func (u *Users) SetPendingTotpSecret(
+	ctx context.Context,
+	userId string,
+	secret string,
+) error {
+	res, err := u.db.ExecContext(
+		ctx,
+		`UPDATE Users SET TotpSecret = ? WHERE Id = ? AND NOT TotpEnabled`,
+		secret,
+		nullable(userId),
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return users.ErrTotpEnabled
+	}
+	return nil
+}
+
+func hashRecoveryCode(code string) string {
+	return hash(strings.ToLower(strings.TrimSpace(code)))
+}
+
+// EnableTotp turns on two-factor authentication with the pending secret and
+// replaces recovery codes of the user
+func (u *Users) EnableTotp(
+	ctx context.Context,
+	userId string,
+	recoveryCodes []string,
+) error {
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		`UPDATE Users SET TotpEnabled = 1
+		 WHERE Id = ? AND TotpSecret IS NOT NULL AND NOT TotpEnabled`,
+		nullable(userId),
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return users.ErrTotpEnabled
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM RecoveryCodes WHERE UserId = ?`,
+		nullable(userId),
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO RecoveryCodes(UserId, CodeHash) VALUES (?, ?)`,
+			nullable(userId),
+			hashRecoveryCode(code),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (u *Users) DisableTotp(ctx context.Context, userId string) error {
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE Users SET TotpSecret = NULL, TotpEnabled = 0 WHERE Id = ?`,
+		nullable(userId),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM RecoveryCodes WHERE UserId = ?`,
+		nullable(userId),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTotpStep returns false if a code for this or a later step has already
+// been used
+func (u *Users) UseTotpStep(ctx context.Context, userId string, step int64) (bool, error) {
+	res, err := u.db.ExecContext(
+		ctx,
+		`UPDATE Users SET TotpLastStep = ? WHERE Id = ? AND TotpLastStep < ?`,
+		step,
+		nullable(userId),
+		step,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ConsumeRecoveryCode returns false if the code doesn't belong to the user
+// or has already been used
+func (u *Users) ConsumeRecoveryCode(
+	ctx context.Context,
+	userId string,
+	code string,
+) (bool, error) {
+	res, err := u.db.ExecContext(
+		ctx,
+		`UPDATE RecoveryCodes SET UsedAt = ?
+		 WHERE UserId = ? AND CodeHash = ? AND UsedAt IS NULL`,
+		millis(time.Now()),
+		nullable(userId),
+		hashRecoveryCode(code),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (u *Users) GetIdByIdentity(
+	ctx context.Context,
+	provider string,
+	subject string,
+) (string, error) {
+	var id string
+	err := u.db.QueryRowContext(
+		ctx,
+		`SELECT UserId FROM Identities WHERE Provider = ? AND Subject = ?`,
+		provider,
+		subject,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", users.ErrNotFound
+	}
+	return id, err
+}
+
+// LinkIdentity is a no-op for an identity that already belongs to the user
+func (u *Users) LinkIdentity(
+	ctx context.Context,
+	userId string,
+	provider string,
+	subject string,
+) error {
+	_, err := u.db.ExecContext(
+		ctx,
+		`INSERT INTO Identities(Provider, Subject, UserId, CreatedAt) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (Provider, Subject) DO NOTHING`,
+		provider,
+		subject,
+		nullable(userId),
+		millis(time.Now()),
+	)
+	return err
+}
+
+// CreateWithIdentity registers a user that signed in with an external
+// identity for the first time
+func (u *Users) CreateWithIdentity(
+	ctx context.Context,
+	user *responses.Authenticator,
+	provider string,
+	subject string,
+) error {
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO Users(Id, Name, Email, HashedPassword) VALUES (?, ?, ?, ?)`,
+		nullable(user.Id),
+		user.Name,
+		user.Email,
+		user.HashedPassword,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO Identities(Provider, Subject, UserId, CreatedAt) VALUES (?, ?, ?, ?)`,
+		provider,
+		subject,
+		nullable(user.Id),
+		millis(time.Now()),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
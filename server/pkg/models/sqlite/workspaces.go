@@ -0,0 +1,302 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"shortener/pkg/domain"
+	"shortener/pkg/models/workspaces"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Workspaces struct {
+	db *sql.DB
+}
+
+func NewWorkspaces(d *DB) *Workspaces {
+	return &Workspaces{db: d.db}
+}
+
+// Create makes a new workspace owned by the user
+func (m *Workspaces) Create(ctx context.Context, ownerId string, name string) (string, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	id := uuid.NewString()
+	now := millis(time.Now())
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO Workspaces(Id, Name, CreatedAt) VALUES (?, ?, ?)`,
+		id,
+		name,
+		now,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO WorkspaceMembers(WorkspaceId, UserId, Role, JoinedAt) VALUES (?, ?, ?, ?)`,
+		id,
+		nullable(ownerId),
+		domain.RoleOwner,
+		now,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return id, tx.Commit()
+}
+
+// List returns workspaces the user is a member of
+func (m *Workspaces) List(ctx context.Context, userId string) ([]*domain.Workspace, error) {
+	rows, err := m.db.QueryContext(
+		ctx,
+		`SELECT w.Id, w.Name, m.Role FROM Workspaces w
+		 JOIN WorkspaceMembers m ON m.WorkspaceId = w.Id
+		 WHERE m.UserId = ?
+		 ORDER BY w.CreatedAt`,
+		nullable(userId),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*domain.Workspace{}
+	for rows.Next() {
+		var w domain.Workspace
+		if err := rows.Scan(&w.Id, &w.Name, &w.Role); err != nil {
+			return nil, err
+		}
+		res = append(res, &w)
+	}
+	return res, rows.Err()
+}
+
+func (m *Workspaces) Role(
+	ctx context.Context,
+	workspaceId string,
+	userId string,
+) (domain.Role, error) {
+	return role(ctx, m.db, workspaceId, userId)
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func role(ctx context.Context, q queryer, workspaceId string, userId string) (domain.Role, error) {
+	var r domain.Role
+	err := q.QueryRowContext(
+		ctx,
+		`SELECT Role FROM WorkspaceMembers WHERE WorkspaceId = ? AND UserId = ?`,
+		nullable(workspaceId),
+		nullable(userId),
+	).Scan(&r)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", workspaces.ErrNotMember
+	}
+	return r, err
+}
+
+func (m *Workspaces) Members(ctx context.Context, workspaceId string) ([]*domain.Member, error) {
+	rows, err := m.db.QueryContext(
+		ctx,
+		`SELECT u.Id, u.Name, u.Email, m.Role FROM WorkspaceMembers m
+		 JOIN Users u ON u.Id = m.UserId
+		 WHERE m.WorkspaceId = ?
+		 ORDER BY m.JoinedAt`,
+		nullable(workspaceId),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*domain.Member{}
+	for rows.Next() {
+		var member domain.Member
+		err := rows.Scan(&member.UserId, &member.Name, &member.Email, &member.Role)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &member)
+	}
+	return res, rows.Err()
+}
+
+// owners returns the number of owners of the workspace. Transactions of the
+// single connection don't interleave, so no locking is needed.
+func owners(ctx context.Context, tx *sql.Tx, workspaceId string) (int, error) {
+	var n int
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT count(*) FROM WorkspaceMembers WHERE WorkspaceId = ? AND Role = ?`,
+		nullable(workspaceId),
+		domain.RoleOwner,
+	).Scan(&n)
+	return n, err
+}
+
+// SetRole changes role of a member. The last owner can't be demoted.
+func (m *Workspaces) SetRole(
+	ctx context.Context,
+	workspaceId string,
+	userId string,
+	r domain.Role,
+) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	n, err := owners(ctx, tx, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	current, err := role(ctx, tx, workspaceId, userId)
+	if err != nil {
+		return err
+	}
+
+	if current == domain.RoleOwner && r != domain.RoleOwner && n == 1 {
+		return workspaces.ErrLastOwner
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE WorkspaceMembers SET Role = ? WHERE WorkspaceId = ? AND UserId = ?`,
+		r,
+		nullable(workspaceId),
+		nullable(userId),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveMember excludes the user from the workspace. The last owner can't be
+// removed.
+func (m *Workspaces) RemoveMember(ctx context.Context, workspaceId string, userId string) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	n, err := owners(ctx, tx, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	var r domain.Role
+	err = tx.QueryRowContext(
+		ctx,
+		`DELETE FROM WorkspaceMembers WHERE WorkspaceId = ? AND UserId = ?
+		 RETURNING Role`,
+		nullable(workspaceId),
+		nullable(userId),
+	).Scan(&r)
+	if errors.Is(err, sql.ErrNoRows) {
+		return workspaces.ErrNotMember
+	}
+	if err != nil {
+		return err
+	}
+
+	if r == domain.RoleOwner && n == 1 {
+		return workspaces.ErrLastOwner
+	}
+
+	return tx.Commit()
+}
+
+func (m *Workspaces) CreateInvite(
+	ctx context.Context,
+	workspaceId string,
+	email string,
+	r domain.Role,
+	invitedBy string,
+	token string,
+	ttl time.Duration,
+) error {
+	_, err := m.db.ExecContext(
+		ctx,
+		`INSERT INTO WorkspaceInvites(TokenHash, WorkspaceId, Email, Role, InvitedBy, ExpiresAt)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		hash(token),
+		nullable(workspaceId),
+		email,
+		r,
+		nullable(invitedBy),
+		millis(time.Now().Add(ttl)),
+	)
+	return err
+}
+
+// AcceptInvite makes the user a member of the workspace the invite has been
+// sent for. Roles of existing members aren't changed.
+func (m *Workspaces) AcceptInvite(
+	ctx context.Context,
+	token string,
+	userId string,
+	email string,
+) (string, domain.Role, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	now := millis(time.Now())
+	var workspaceId string
+	var r domain.Role
+	err = tx.QueryRowContext(
+		ctx,
+		`UPDATE WorkspaceInvites SET AcceptedAt = ?
+		 WHERE TokenHash = ? AND Email = ? AND AcceptedAt IS NULL AND ? < ExpiresAt
+		 RETURNING WorkspaceId, Role`,
+		now,
+		hash(token),
+		email,
+		now,
+	).Scan(&workspaceId, &r)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", workspaces.ErrInvalidInvite
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO WorkspaceMembers(WorkspaceId, UserId, Role, JoinedAt) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (WorkspaceId, UserId) DO NOTHING`,
+		workspaceId,
+		nullable(userId),
+		r,
+		now,
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	r, err = role(ctx, tx, workspaceId, userId)
+	if err != nil {
+		return "", "", err
+	}
+
+	return workspaceId, r, tx.Commit()
+}
@@ -0,0 +1,52 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Model keeps track of token generations: a token is valid only if it was
+// issued within the current generation of its owner.
+type Model struct {
+	rdb *redis.Client
+}
+
+type tokensOption func(m *Model) error
+
+func WithRedis(rdb *redis.Client) tokensOption {
+	return func(m *Model) error {
+		m.rdb = rdb
+		return nil
+	}
+}
+
+func New(opts ...tokensOption) (*Model, error) {
+	m := new(Model)
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.rdb == nil {
+		return nil, errors.New("no redis client provided")
+	}
+	return m, nil
+}
+
+func generationKey(userId string) string {
+	return "token_generation:" + userId
+}
+
+func (m *Model) Generation(ctx context.Context, userId string) (int64, error) {
+	gen, err := m.rdb.Get(ctx, generationKey(userId)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return gen, err
+}
+
+func (m *Model) Bump(ctx context.Context, userId string) error {
+	return m.rdb.Incr(ctx, generationKey(userId)).Err()
+}
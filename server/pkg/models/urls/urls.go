@@ -6,24 +6,44 @@ import (
 	"fmt"
 	"log"
 	"shortener/pkg/domain"
+	"shortener/pkg/models/pgbatch"
 	"shortener/pkg/responses"
+	"shortener/pkg/tracing"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/redis/go-redis/v9"
 )
 
+var cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "urls_cache_requests_total",
+	Help: "Redis requests of the urls model by operation and result: hit, miss or error.",
+}, []string{"operation", "result"})
+
 type Model struct {
 	pool *pgxpool.Pool
 	rdb  *redis.Client
+
+	copyThreshold int
 }
 
+// batches of at least this many links are inserted with COPY
+const DefaultCopyThreshold = 100
+
 type urlsOption func(u *Model) error
 
 func WithPool(ctx context.Context, dsn string) urlsOption {
 	return func(l *Model) error {
-		pool, err := pgxpool.New(ctx, dsn)
+		conf, err := tracing.PoolConfig(dsn)
+		if err != nil {
+			return err
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, conf)
 		if err != nil {
 			return err
 		}
@@ -44,8 +64,22 @@ func WithRedis(rdb *redis.Client) urlsOption {
 	}
 }
 
+// WithCopyThreshold sets the least number of links inserted with COPY rather
+// than with separate INSERT statements
+func WithCopyThreshold(rows int) urlsOption {
+	return func(u *Model) error {
+		if rows < 1 {
+			return errors.New("copy threshold must be positive")
+		}
+		u.copyThreshold = rows
+		return nil
+	}
+}
+
 func New(opts ...urlsOption) (*Model, error) {
-	u := new(Model)
+	u := &Model{
+		copyThreshold: DefaultCopyThreshold,
+	}
 	for _, opt := range opts {
 		if err := opt(u); err != nil {
 			return nil, err
@@ -69,19 +103,41 @@ func (u *Model) CheckExistence(
 
 	err := u.rdb.Get(ctx, shortUrl).Err()
 	if err == nil {
+		cacheRequests.WithLabelValues("get", "hit").Inc()
 		return true, nil
-	} else if !errors.Is(err, redis.Nil) {
+	} else if errors.Is(err, redis.Nil) {
+		cacheRequests.WithLabelValues("get", "miss").Inc()
+	} else {
+		cacheRequests.WithLabelValues("get", "error").Inc()
 		log.Println("couldn't get result from redis. error:", err)
 	}
 
+	// short urls of archived links are held until they are released
 	err = u.pool.QueryRow(
 		ctx,
-		`SELECT EXISTS(SELECT 1 FROM Urls WHERE ShortUrl = $1)`,
+		`SELECT EXISTS(SELECT 1 FROM Urls WHERE ShortUrl = $1)
+		     OR EXISTS(SELECT 1 FROM UrlsArchive WHERE ShortUrl = $1 AND ReleasedAt IS NULL)`,
 		shortUrl,
 	).Scan(&res)
 	return res, err
 }
 
+// TakenShortUrls returns the short urls of the list that are taken by links
+// or held by archived ones, in no particular order
+func (u *Model) TakenShortUrls(ctx context.Context, shortUrls []string) ([]string, error) {
+	rows, err := u.pool.Query(
+		ctx,
+		`SELECT ShortUrl FROM Urls WHERE ShortUrl = ANY($1)
+		 UNION
+		 SELECT ShortUrl FROM UrlsArchive WHERE ShortUrl = ANY($1) AND ReleasedAt IS NULL`,
+		shortUrls,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
 var ErrNotFound = errors.New("url not found")
 
 func (u *Model) GetLongUrl(
@@ -92,18 +148,24 @@ func (u *Model) GetLongUrl(
 	if cacheRes.Err() == nil {
 		longUrl, err := cacheRes.Result()
 		if err == nil {
+			cacheRequests.WithLabelValues("get", "hit").Inc()
 			return longUrl, nil
 		} else {
-			log.Printf("couldn't extract value from redis result. error:", err)
+			cacheRequests.WithLabelValues("get", "error").Inc()
+			log.Println("couldn't extract value from redis result. error:", err)
 		}
-	} else if cacheRes.Err() != redis.Nil {
+	} else if cacheRes.Err() == redis.Nil {
+		cacheRequests.WithLabelValues("get", "miss").Inc()
+	} else {
+		cacheRequests.WithLabelValues("get", "error").Inc()
 		log.Println("couldn't get value by key from redis. error:", cacheRes.Err())
 	}
 
 	var longUrl string
 	err := u.pool.QueryRow(
 		ctx,
-		`SELECT LongUrl from Urls where Urls.ShortUrl = $1 AND now() < Urls.ExpirationDate`,
+		`SELECT LongUrl from Urls
+		 where Urls.ShortUrl = $1 AND now() < Urls.ExpirationDate`,
 		shortUrl,
 	).Scan(&longUrl)
 	if errors.Is(err, pgx.ErrNoRows) {
@@ -113,79 +175,663 @@ func (u *Model) GetLongUrl(
 	return longUrl, err
 }
 
-func (u *Model) Insert(ctx context.Context, rr []*responses.Shortener) {
+// Insert stores the links. The returned slice holds an error for every link
+// that couldn't be stored, at the same index. Links that have already been
+// stored from the same message are skipped, links which short url is taken
+// by another one fail with domain.ErrConflict.
+func (u *Model) Insert(ctx context.Context, rr []*responses.Shortener) []error {
+	var errs []error
+	if len(rr) >= u.copyThreshold {
+		var err error
+		errs, err = u.insertCopy(ctx, rr)
+		if err != nil {
+			// e.g. a link of a deleted user. Separate statements will tell
+			// which links are failing
+			log.Println("couldn't copy links. will insert them one by one. error:", err)
+			errs = nil
+		}
+	}
+	if errs == nil {
+		errs = u.insertBatch(ctx, rr)
+	}
+
+	for i, urlInfo := range rr {
+		if errs[i] != nil {
+			continue
+		}
+
+		err := u.rdb.Set(ctx, urlInfo.ShortUrl, urlInfo.LongUrl, time.Hour*24).Err()
+		if err != nil {
+			cacheRequests.WithLabelValues("set", "error").Inc()
+			log.Println("coulnd't put short url into cache. error:", err)
+		}
+	}
+	return errs
+}
+
+func (u *Model) insertBatch(ctx context.Context, rr []*responses.Shortener) []error {
 	batch := pgx.Batch{}
 
 	for _, urlInfo := range rr {
 		batch.Queue(
-			`INSERT INTO Urls(ShortUrl, LongUrl, UserId, ExpirationDate) VALUES ($1, $2, $3, $4)`,
+			`INSERT INTO Urls(ShortUrl, LongUrl, UserId, ExpirationDate, WorkspaceId, IdempotencyKey, Title, Tags)
+			 VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, NULLIF($6, '')::uuid, NULLIF($7, ''), $8)
+			 ON CONFLICT DO NOTHING`,
 			urlInfo.ShortUrl,
 			urlInfo.LongUrl,
 			urlInfo.From,
 			urlInfo.ExpirationDate,
+			urlInfo.WorkspaceId,
+			urlInfo.IdempotencyKey,
+			urlInfo.Title,
+			tags(urlInfo.Tags),
 		)
 	}
 
-	res := u.pool.SendBatch(ctx, &batch)
-	defer res.Close()
+	tags, errs := pgbatch.Exec(ctx, u.pool, &batch)
+	for i, urlInfo := range rr {
+		if errs[i] == nil && tags[i].RowsAffected() == 0 {
+			errs[i] = u.checkDuplicate(ctx, urlInfo)
+		}
+	}
+	return errs
+}
 
-	for _, urlInfo := range rr {
-		_, err := res.Exec()
-		if err != nil {
-			log.Printf(
-				"error occured during insert of %s (%s). error: %s\n",
-				urlInfo.ShortUrl,
-				urlInfo.LongUrl,
-				err.Error(),
-			)
-		} else {
-			err := u.rdb.Set(ctx, urlInfo.ShortUrl, urlInfo.LongUrl, time.Hour*24).Err()
+// insertCopy copies the links into a staging table and moves them into Urls
+// with a single statement. The returned error means nothing has been stored.
+func (u *Model) insertCopy(
+	ctx context.Context,
+	rr []*responses.Shortener,
+) ([]error, error) {
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(
+		ctx,
+		`CREATE TEMP TABLE urls_staging (LIKE Urls INCLUDING DEFAULTS) ON COMMIT DROP`,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"urls_staging"},
+		[]string{
+			"shorturl",
+			"longurl",
+			"userid",
+			"expirationdate",
+			"workspaceid",
+			"idempotencykey",
+			"title",
+			"tags",
+		},
+		pgx.CopyFromSlice(len(rr), func(i int) ([]any, error) {
+			r := rr[i]
+			userId, err := pgbatch.UUID(r.From)
+			if err != nil {
+				return nil, err
+			}
+			workspaceId, err := pgbatch.UUID(r.WorkspaceId)
 			if err != nil {
-				log.Println("coulnd't put short url into cache. error:", err)
+				return nil, err
 			}
+			key, err := pgbatch.UUID(r.IdempotencyKey)
+			if err != nil {
+				return nil, err
+			}
+			var title *string
+			if r.Title != "" {
+				title = &r.Title
+			}
+			return []any{
+				r.ShortUrl,
+				r.LongUrl,
+				userId,
+				r.ExpirationDate,
+				workspaceId,
+				key,
+				title,
+				tags(r.Tags),
+			}, nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(
+		ctx,
+		`INSERT INTO Urls(ShortUrl, LongUrl, UserId, ExpirationDate, WorkspaceId, IdempotencyKey, Title, Tags)
+		 SELECT ShortUrl, LongUrl, UserId, ExpirationDate, WorkspaceId, IdempotencyKey, Title, Tags
+		 FROM urls_staging
+		 ON CONFLICT DO NOTHING
+		 RETURNING ShortUrl, coalesce(IdempotencyKey::text, '')`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	inserted := map[[2]string]bool{}
+	for rows.Next() {
+		var shortUrl, key string
+		if err := rows.Scan(&shortUrl, &key); err != nil {
+			rows.Close()
+			return nil, err
 		}
+		inserted[[2]string{shortUrl, key}] = true
 	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(rr))
+	for i, r := range rr {
+		id := [2]string{r.ShortUrl, strings.ToLower(r.IdempotencyKey)}
+		if inserted[id] {
+			// the same message may be in the batch twice
+			delete(inserted, id)
+			continue
+		}
+		errs[i] = u.checkDuplicate(ctx, r)
+	}
+	return errs, nil
+}
+
+// tags are never NULL, links without them have an empty array
+func tags(tt []string) []string {
+	if tt == nil {
+		return []string{}
+	}
+	return tt
 }
 
+// checkDuplicate is called for a link that hasn't been inserted because of a
+// conflict. It's a redelivery if a link has been stored from the same message.
+func (u *Model) checkDuplicate(ctx context.Context, r *responses.Shortener) error {
+	var duplicate bool
+	err := u.pool.QueryRow(
+		ctx,
+		`SELECT EXISTS(SELECT 1 FROM Urls WHERE IdempotencyKey = NULLIF($1, '')::uuid)`,
+		r.IdempotencyKey,
+	).Scan(&duplicate)
+	if err != nil {
+		return err
+	}
+	if duplicate {
+		return nil
+	}
+	return fmt.Errorf("%w: short url %s is taken", domain.ErrConflict, r.ShortUrl)
+}
+
+// historySorts are the columns links are sorted by
+var historySorts = map[domain.HistorySort]string{
+	domain.SortCreated:    "CreatedAt",
+	domain.SortExpiration: "ExpirationDate",
+	domain.SortClicks:     "Clicks",
+}
+
+// queryArgs collects arguments of a statement
+type queryArgs []any
+
+// add returns the placeholder of the argument
+func (a *queryArgs) add(v any) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// historyFilters returns conditions of the links the query selects, the
+// cursor aside
+func historyFilters(q *domain.HistoryQuery, args *queryArgs) []string {
+	var where []string
+	if q.WorkspaceId != "" {
+		where = append(where, "WorkspaceId = "+args.add(q.WorkspaceId))
+	} else {
+		where = append(where, "UserId = "+args.add(q.UserId), "WorkspaceId IS NULL")
+	}
+	switch q.Status {
+	case domain.StatusActive:
+		where = append(where, "ExpirationDate > now()", "NOT Disabled")
+	case domain.StatusExpired:
+		where = append(where, "ExpirationDate <= now()")
+	case domain.StatusDisabled:
+		where = append(where, "Disabled")
+	}
+	if q.Domain != "" {
+		where = append(where, "Domain = "+args.add(strings.ToLower(q.Domain)))
+	}
+	if q.Tag != "" {
+		where = append(where, "Tags @> ARRAY["+args.add(q.Tag)+"]::VarChar(32)[]")
+	}
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "CreatedAt >= "+args.add(q.CreatedAfter))
+	}
+	if !q.CreatedBefore.IsZero() {
+		where = append(where, "CreatedAt < "+args.add(q.CreatedBefore))
+	}
+	if q.Search != "" {
+		// the query is split the way long urls are
+		where = append(where, "SearchVector @@ plainto_tsquery('simple', regexp_replace("+
+			args.add(q.Search)+", '[^[:alnum:]]+', ' ', 'g'))")
+	}
+	return where
+}
+
+// History returns a page of links of the workspace, or of personal links of
+// the user if the workspace is empty, that match the filters of the query.
+// Links are sorted by the sort column and then by short urls, and follow the
+// cursor of the query.
 func (u *Model) History(
 	ctx context.Context,
-	userId string,
+	q *domain.HistoryQuery,
 ) ([]*domain.UrlInfo, error) {
+	column, ok := historySorts[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+
+	var args queryArgs
+	where := historyFilters(q, &args)
+	order, cmp := "ASC", ">"
+	if q.Desc {
+		order, cmp = "DESC", "<"
+	}
+	if q.After != nil {
+		var value any
+		switch q.Sort {
+		case domain.SortExpiration:
+			value = q.After.ExpirationDate
+		case domain.SortClicks:
+			value = q.After.Clicks
+		default:
+			value = q.After.CreatedAt
+		}
+		where = append(where, fmt.Sprintf(
+			"(%s, ShortUrl) %s (%s, %s)",
+			column, cmp, args.add(value), args.add(q.After.ShortUrl),
+		))
+	}
+
 	rows, err := u.pool.Query(
 		ctx,
-		"SELECT ShortUrl, LongUrl, ExpirationDate FROM Urls WHERE UserId = $1 AND ExpirationDate > now()",
-		userId,
+		`SELECT ShortUrl, LongUrl, ExpirationDate, CreatedAt, COALESCE(Title, ''),
+		        Tags, Disabled, Clicks
+		 FROM Urls
+		 WHERE `+strings.Join(where, " AND ")+`
+		 ORDER BY `+column+" "+order+", ShortUrl "+order+`
+		 LIMIT `+args.add(q.Limit),
+		args...,
 	)
-	defer rows.Close()
 	if err != nil {
 		return nil, err
 	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*domain.UrlInfo, error) {
+		var l domain.UrlInfo
+		err := row.Scan(
+			&l.ShortUrl,
+			&l.LongUrl,
+			&l.ExpirationDate,
+			&l.CreatedAt,
+			&l.Title,
+			&l.Tags,
+			&l.Disabled,
+			&l.Clicks,
+		)
+		return &l, err
+	})
+}
 
-	res := []*domain.UrlInfo{}
+// CountHistory returns the number of links that match the filters of the
+// query
+func (u *Model) CountHistory(ctx context.Context, q *domain.HistoryQuery) (int64, error) {
+	var args queryArgs
+	where := historyFilters(q, &args)
+	var n int64
+	err := u.pool.QueryRow(
+		ctx,
+		`SELECT count(*) FROM Urls WHERE `+strings.Join(where, " AND "),
+		args...,
+	).Scan(&n)
+	return n, err
+}
+
+// ClickStats returns click aggregates of the links by their short urls.
+// Links that are gone are missing from the result.
+func (u *Model) ClickStats(
+	ctx context.Context,
+	shortUrls []string,
+) (map[string]*domain.ClickStats, error) {
+	rows, err := u.pool.Query(
+		ctx,
+		`SELECT Urls.ShortUrl, Urls.Clicks,
+		        COALESCE(sum(LinkClicks.Clicks) FILTER (WHERE Day > current_date - 7), 0),
+		        COALESCE(sum(LinkClicks.Clicks) FILTER (WHERE Day > current_date - 30), 0),
+		        max(LinkClicks.Day)
+		 FROM Urls
+		 LEFT JOIN LinkClicks ON LinkClicks.ShortUrl = Urls.ShortUrl
+		 WHERE Urls.ShortUrl = ANY($1)
+		 GROUP BY Urls.ShortUrl`,
+		shortUrls,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string]*domain.ClickStats, len(shortUrls))
 	for rows.Next() {
-		if err := rows.Err(); err != nil {
-			log.Printf(
-				"couldn't read row on request from %s. error: %v\n",
-				userId,
-				err,
-			)
-			continue
+		var shortUrl string
+		var s domain.ClickStats
+		if err := rows.Scan(
+			&shortUrl,
+			&s.Total,
+			&s.Last7Days,
+			&s.Last30Days,
+			&s.LastClickedOn,
+		); err != nil {
+			return nil, err
 		}
+		res[shortUrl] = &s
+	}
+	return res, rows.Err()
+}
 
-		var record domain.UrlInfo
+// Link returns the link with the plan it's limited by, expired or not, as
+// long as it hasn't been archived
+func (u *Model) Link(ctx context.Context, shortUrl string) (*domain.Link, error) {
+	var l domain.Link
+	var plan string
+	err := u.pool.QueryRow(
+		ctx,
+		`SELECT Urls.ShortUrl, Urls.LongUrl, Urls.UserId::text,
+		        COALESCE(Urls.WorkspaceId::text, ''), Urls.ExpirationDate,
+		        Urls.AutoRenew, COALESCE(Workspaces.Plan, Users.Plan)
+		 FROM Urls
+		 JOIN Users ON Users.Id = Urls.UserId
+		 LEFT JOIN Workspaces ON Workspaces.Id = Urls.WorkspaceId
+		 WHERE Urls.ShortUrl = $1`,
+		shortUrl,
+	).Scan(
+		&l.ShortUrl,
+		&l.LongUrl,
+		&l.UserId,
+		&l.WorkspaceId,
+		&l.ExpirationDate,
+		&l.AutoRenew,
+		&plan,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.Plan = domain.Plan(plan)
+	return &l, nil
+}
 
-		if err := rows.Scan(&record.ShortUrl, &record.LongUrl, &record.ExpirationDate); err != nil {
-			log.Printf(
-				"couldn't scan from row on request from %s. error: %v\n",
-				userId,
-				err,
-			)
-			continue
+// Renew sets the expiration date and the auto-renewal of the link and puts
+// it back into the cache, where it may have expired from
+func (u *Model) Renew(
+	ctx context.Context,
+	shortUrl string,
+	expiration time.Time,
+	autoRenew bool,
+) error {
+	var longUrl string
+	err := u.pool.QueryRow(
+		ctx,
+		`UPDATE Urls SET ExpirationDate = $2, AutoRenew = $3
+		 WHERE ShortUrl = $1
+		 RETURNING LongUrl`,
+		shortUrl,
+		expiration,
+		autoRenew,
+	).Scan(&longUrl)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// renewals only move expiration dates forward, the link is cached for a
+	// day like a new one, but not after it expires
+	ttl := min(time.Until(expiration), 24*time.Hour)
+	if ttl <= 0 {
+		return nil
+	}
+	if err := u.rdb.Set(ctx, shortUrl, longUrl, ttl).Err(); err != nil {
+		cacheRequests.WithLabelValues("set", "error").Inc()
+		log.Println("couldn't put renewed short url into cache. error:", err)
+	}
+	return nil
+}
+
+// MoveToWorkspace moves the personal links of the user among the short
+// urls into the workspace, and returns the short urls of the moved ones in
+// no particular order. Other links are left as they are.
+func (u *Model) MoveToWorkspace(
+	ctx context.Context,
+	userId string,
+	workspaceId string,
+	shortUrls []string,
+) ([]string, error) {
+	rows, err := u.pool.Query(
+		ctx,
+		`UPDATE Urls SET WorkspaceId = $3
+		 WHERE ShortUrl = ANY($1) AND UserId = $2 AND WorkspaceId IS NULL
+		 RETURNING ShortUrl`,
+		shortUrls,
+		userId,
+		workspaceId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// Delete removes the link and its cache entry. Its short url may be given to
+// a new link right away.
+func (u *Model) Delete(ctx context.Context, shortUrl string) error {
+	tag, err := u.pool.Exec(
+		ctx,
+		`DELETE FROM Urls WHERE ShortUrl = $1`,
+		shortUrl,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	if err := u.rdb.Del(ctx, shortUrl).Err(); err != nil {
+		cacheRequests.WithLabelValues("del", "error").Inc()
+		log.Println("couldn't remove deleted short url from cache. error:", err)
+	}
+	return nil
+}
+
+// CountClicks adds clicks of the events to the counters of their links, in
+// total and by UTC day. Events of other types and clicks of links that are
+// gone are skipped. Clicks of redelivered events are counted again, the
+// counters are approximate.
+func (u *Model) CountClicks(ctx context.Context, ee []*domain.LinkEvent) []error {
+	type day struct {
+		shortUrl string
+		day      time.Time
+	}
+	counts := map[day]int64{}
+	for _, e := range ee {
+		if e.Type == domain.EventLinkClicked {
+			d := e.OccurredAt.UTC().Truncate(24 * time.Hour)
+			counts[day{e.ShortUrl, d}]++
 		}
+	}
+	errs := make([]error, len(ee))
+	if len(counts) == 0 {
+		return errs
+	}
 
-		res = append(res, &record)
+	shortUrls := make([]string, 0, len(counts))
+	days := make([]time.Time, 0, len(counts))
+	clicks := make([]int64, 0, len(counts))
+	for d, n := range counts {
+		shortUrls = append(shortUrls, d.shortUrl)
+		days = append(days, d.day)
+		clicks = append(clicks, n)
 	}
-	return res, nil
+	err := pgx.BeginFunc(ctx, u.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(
+			ctx,
+			`UPDATE Urls SET Clicks = Urls.Clicks + c.n
+			 FROM (
+			     SELECT ShortUrl, sum(n) AS n
+			     FROM unnest($1::text[], $2::bigint[]) AS c(ShortUrl, n)
+			     GROUP BY ShortUrl
+			 ) AS c
+			 WHERE Urls.ShortUrl = c.ShortUrl`,
+			shortUrls,
+			clicks,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO LinkClicks(ShortUrl, Day, Clicks)
+			 SELECT c.ShortUrl, c.Day, c.n
+			 FROM unnest($1::text[], $2::date[], $3::bigint[]) AS c(ShortUrl, Day, n)
+			 JOIN Urls ON Urls.ShortUrl = c.ShortUrl
+			 ON CONFLICT (ShortUrl, Day) DO UPDATE SET Clicks = LinkClicks.Clicks + excluded.Clicks`,
+			shortUrls,
+			days,
+			clicks,
+		)
+		return err
+	})
+	if err != nil {
+		for i, e := range ee {
+			if e.Type == domain.EventLinkClicked {
+				errs[i] = err
+			}
+		}
+	}
+	return errs
+}
+
+// ArchiveExpired moves at most limit links that have expired before the
+// moment to UrlsArchive, oldest first, and removes them from the cache. It
+// returns short urls of the moved links.
+func (u *Model) ArchiveExpired(
+	ctx context.Context,
+	before time.Time,
+	limit int,
+) ([]string, error) {
+	rows, err := u.pool.Query(
+		ctx,
+		`WITH expired AS (
+		     SELECT ShortUrl FROM Urls
+		     WHERE ExpirationDate <= $1
+		     ORDER BY ExpirationDate
+		     LIMIT $2
+		     FOR UPDATE SKIP LOCKED
+		 ), moved AS (
+		     DELETE FROM Urls USING expired
+		     WHERE Urls.ShortUrl = expired.ShortUrl
+		     RETURNING Urls.ShortUrl, Urls.LongUrl, Urls.UserId, Urls.WorkspaceId, Urls.ExpirationDate
+		 )
+		 INSERT INTO UrlsArchive(ShortUrl, LongUrl, UserId, WorkspaceId, ExpirationDate)
+		 SELECT ShortUrl, LongUrl, UserId, WorkspaceId, ExpirationDate FROM moved
+		 RETURNING ShortUrl`,
+		before,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	shortUrls, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(shortUrls) > 0 {
+		if err := u.rdb.Del(ctx, shortUrls...).Err(); err != nil {
+			cacheRequests.WithLabelValues("del", "error").Inc()
+			log.Println("couldn't remove archived short urls from cache. error:", err)
+		}
+	}
+	return shortUrls, nil
+}
+
+// CountExpired returns the number of links that have expired before the
+// moment and haven't been archived yet
+func (u *Model) CountExpired(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	err := u.pool.QueryRow(
+		ctx,
+		`SELECT count(*) FROM Urls WHERE ExpirationDate <= $1`,
+		before,
+	).Scan(&n)
+	return n, err
+}
+
+// ReleaseShortUrls lets at most limit short urls of links archived before
+// the moment be given to new links. It returns the number of released ones.
+func (u *Model) ReleaseShortUrls(
+	ctx context.Context,
+	archivedBefore time.Time,
+	limit int,
+) (int64, error) {
+	tag, err := u.pool.Exec(
+		ctx,
+		`UPDATE UrlsArchive SET ReleasedAt = now()
+		 WHERE Id IN (
+		     SELECT Id FROM UrlsArchive
+		     WHERE ReleasedAt IS NULL AND ArchivedAt <= $1
+		     ORDER BY ArchivedAt
+		     LIMIT $2
+		     FOR UPDATE SKIP LOCKED
+		 )`,
+		archivedBefore,
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// CountReleasable returns the number of held short urls of links archived
+// before the moment
+func (u *Model) CountReleasable(ctx context.Context, archivedBefore time.Time) (int64, error) {
+	var n int64
+	err := u.pool.QueryRow(
+		ctx,
+		`SELECT count(*) FROM UrlsArchive WHERE ReleasedAt IS NULL AND ArchivedAt <= $1`,
+		archivedBefore,
+	).Scan(&n)
+	return n, err
+}
+
+// Ping checks that the database is reachable
+func (u *Model) Ping(ctx context.Context) error {
+	return u.pool.Ping(ctx)
+}
+
+// Stat returns statistics of the connection pool
+func (u *Model) Stat() *pgxpool.Stat {
+	return u.pool.Stat()
 }
 
 func (u *Model) Close() {
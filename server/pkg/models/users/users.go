@@ -2,9 +2,18 @@ package users
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
+	"shortener/pkg/domain"
+	"shortener/pkg/models/pgbatch"
 	"shortener/pkg/responses"
+	"shortener/pkg/tracing"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -13,13 +22,22 @@ import (
 
 type Model struct {
 	pool *pgxpool.Pool
+
+	copyThreshold int
 }
 
+// batches of at least this many users are inserted with COPY
+const DefaultCopyThreshold = 100
+
 type usersOption func(u *Model) error
 
 func WithPool(ctx context.Context, dsn string) usersOption {
 	return func(u *Model) error {
-		pool, err := pgxpool.New(ctx, dsn)
+		conf, err := tracing.PoolConfig(dsn)
+		if err != nil {
+			return err
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, conf)
 		if err != nil {
 			return err
 		}
@@ -33,8 +51,22 @@ func WithPool(ctx context.Context, dsn string) usersOption {
 	}
 }
 
+// WithCopyThreshold sets the least number of users inserted with COPY rather
+// than with separate INSERT statements
+func WithCopyThreshold(rows int) usersOption {
+	return func(u *Model) error {
+		if rows < 1 {
+			return errors.New("copy threshold must be positive")
+		}
+		u.copyThreshold = rows
+		return nil
+	}
+}
+
 func NewUsers(opts ...usersOption) (*Model, error) {
-	u := new(Model)
+	u := &Model{
+		copyThreshold: DefaultCopyThreshold,
+	}
 	for _, opt := range opts {
 		if err := opt(u); err != nil {
 			return nil, err
@@ -50,6 +82,14 @@ var ErrNotFound = errors.New("user not found")
 
 var ErrWrongCredentials = errors.New("wrong credentials")
 
+// must be the same as the cost passwords are hashed with on registration
+const dummyHashCost = 12
+
+var dummyHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), dummyHashCost)
+	return h
+})
+
 func (u *Model) CheckExistence(
 	ctx context.Context,
 	email string,
@@ -63,36 +103,151 @@ func (u *Model) CheckExistence(
 	return res, err
 }
 
+// Insert stores the users. The returned slice holds an error for every user
+// that couldn't be stored, at the same index. Users that have already been
+// stored from the same message are skipped, users which email or id is taken
+// by another one fail with domain.ErrConflict.
 func (u *Model) Insert(
 	ctx context.Context,
 	rr []*responses.Authenticator,
-) {
+) []error {
+	if len(rr) >= u.copyThreshold {
+		errs, err := u.insertCopy(ctx, rr)
+		if err == nil {
+			return errs
+		}
+		// separate statements will tell which users are failing
+		log.Println("couldn't copy users. will insert them one by one. error:", err)
+	}
+	return u.insertBatch(ctx, rr)
+}
+
+func (u *Model) insertBatch(
+	ctx context.Context,
+	rr []*responses.Authenticator,
+) []error {
 	batch := pgx.Batch{}
 
 	for _, r := range rr {
 		batch.Queue(
-			`INSERT INTO Users(Id, Name, Email, HashedPassword) VALUES ($1, $2, $3, $4)`,
+			`INSERT INTO Users(Id, Name, Email, HashedPassword, IdempotencyKey)
+			 VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid)
+			 ON CONFLICT DO NOTHING`,
 			r.Id,
 			r.Name,
 			r.Email,
 			r.HashedPassword,
+			r.IdempotencyKey,
 		)
 	}
 
-	res := u.pool.SendBatch(ctx, &batch)
-	defer res.Close()
+	tags, errs := pgbatch.Exec(ctx, u.pool, &batch)
+	for i, r := range rr {
+		if errs[i] == nil && tags[i].RowsAffected() == 0 {
+			errs[i] = u.checkDuplicate(ctx, r)
+		}
+	}
+	return errs
+}
 
-	for _, urlInfo := range rr {
-		_, err := res.Exec()
-		if err != nil {
-			log.Printf(
-				"error occured during insert of %s (%s). error: %s\n",
-				urlInfo.Id,
-				urlInfo.Email,
-				err.Error(),
-			)
+// insertCopy copies the users into a staging table and moves them into Users
+// with a single statement. The returned error means nothing has been stored.
+func (u *Model) insertCopy(
+	ctx context.Context,
+	rr []*responses.Authenticator,
+) ([]error, error) {
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(
+		ctx,
+		`CREATE TEMP TABLE users_staging (LIKE Users INCLUDING DEFAULTS) ON COMMIT DROP`,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"users_staging"},
+		[]string{"id", "name", "email", "hashedpassword", "idempotencykey"},
+		pgx.CopyFromSlice(len(rr), func(i int) ([]any, error) {
+			r := rr[i]
+			id, err := pgbatch.UUID(r.Id)
+			if err != nil {
+				return nil, err
+			}
+			key, err := pgbatch.UUID(r.IdempotencyKey)
+			if err != nil {
+				return nil, err
+			}
+			return []any{id, r.Name, r.Email, r.HashedPassword, key}, nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(
+		ctx,
+		`INSERT INTO Users(Id, Name, Email, HashedPassword, IdempotencyKey)
+		 SELECT Id, Name, Email, HashedPassword, IdempotencyKey FROM users_staging
+		 ON CONFLICT DO NOTHING
+		 RETURNING Id::text, coalesce(IdempotencyKey::text, '')`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	inserted := map[[2]string]bool{}
+	for rows.Next() {
+		var id, key string
+		if err := rows.Scan(&id, &key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		inserted[[2]string{id, key}] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(rr))
+	for i, r := range rr {
+		id := [2]string{strings.ToLower(r.Id), strings.ToLower(r.IdempotencyKey)}
+		if inserted[id] {
+			// the same message may be in the batch twice
+			delete(inserted, id)
+			continue
 		}
+		errs[i] = u.checkDuplicate(ctx, r)
 	}
+	return errs, nil
+}
+
+// checkDuplicate is called for a user that hasn't been inserted because of a
+// conflict. It's a redelivery if a user has been stored from the same message.
+func (u *Model) checkDuplicate(ctx context.Context, r *responses.Authenticator) error {
+	var duplicate bool
+	err := u.pool.QueryRow(
+		ctx,
+		`SELECT EXISTS(SELECT 1 FROM Users WHERE IdempotencyKey = NULLIF($1, '')::uuid)`,
+		r.IdempotencyKey,
+	).Scan(&duplicate)
+	if err != nil {
+		return err
+	}
+	if duplicate {
+		return nil
+	}
+	return fmt.Errorf("%w: email %s is taken", domain.ErrConflict, r.Email)
 }
 
 func (u *Model) Authenticate(
@@ -106,6 +261,9 @@ func (u *Model) Authenticate(
 		Scan(&id, &dbHashedPassword)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
+			// spend as much time as for an existing user, so that
+			// registered emails can't be found out by response timing
+			bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
 			return "", ErrNotFound
 		} else {
 			return "", err
@@ -119,6 +277,396 @@ func (u *Model) Authenticate(
 	return id, nil
 }
 
+func (u *Model) GetIdByEmail(ctx context.Context, email string) (string, error) {
+	var id string
+	err := u.pool.QueryRow(ctx, `SELECT Id FROM Users WHERE Email = $1`, email).
+		Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return id, err
+}
+
+// CheckPassword returns ErrWrongCredentials if password doesn't belong to
+// the user with a given id
+func (u *Model) CheckPassword(
+	ctx context.Context,
+	userId string,
+	password string,
+) error {
+	var dbHashedPassword []byte
+	err := u.pool.QueryRow(ctx, `SELECT HashedPassword FROM Users WHERE Id = $1`, userId).
+		Scan(&dbHashedPassword)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if bcrypt.CompareHashAndPassword(dbHashedPassword, []byte(password)) != nil {
+		return ErrWrongCredentials
+	}
+	return nil
+}
+
+// UpdatePassword replaces password hash of the user and invalidates all
+// pending password reset tokens of that user
+func (u *Model) UpdatePassword(
+	ctx context.Context,
+	userId string,
+	hashedPassword string,
+) error {
+	return pgx.BeginFunc(ctx, u.pool, func(tx pgx.Tx) error {
+		return updatePassword(ctx, tx, userId, hashedPassword)
+	})
+}
+
+func updatePassword(
+	ctx context.Context,
+	tx pgx.Tx,
+	userId string,
+	hashedPassword string,
+) error {
+	tag, err := tx.Exec(
+		ctx,
+		`UPDATE Users SET HashedPassword = $2 WHERE Id = $1`,
+		userId,
+		hashedPassword,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`DELETE FROM PasswordResets WHERE UserId = $1`,
+		userId,
+	)
+	return err
+}
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// only hashes of reset tokens are stored so that a database leak
+// doesn't let anyone reset passwords
+func hashResetToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func (u *Model) CreateResetToken(
+	ctx context.Context,
+	userId string,
+	token string,
+	ttl time.Duration,
+) error {
+	_, err := u.pool.Exec(
+		ctx,
+		`INSERT INTO PasswordResets(TokenHash, UserId, ExpiresAt) VALUES ($1, $2, $3)`,
+		hashResetToken(token),
+		userId,
+		time.Now().Add(ttl),
+	)
+	return err
+}
+
+// ResetPassword consumes the reset token and replaces password hash of its
+// owner in one transaction, so that the token stays valid if the password
+// couldn't be stored. Returns id of the owner. Each token can be consumed
+// only once.
+func (u *Model) ResetPassword(
+	ctx context.Context,
+	token string,
+	hashedPassword string,
+) (string, error) {
+	var userId string
+	err := pgx.BeginFunc(ctx, u.pool, func(tx pgx.Tx) error {
+		err := tx.QueryRow(
+			ctx,
+			`UPDATE PasswordResets SET UsedAt = now()
+			 WHERE TokenHash = $1 AND UsedAt IS NULL AND now() < ExpiresAt
+			 RETURNING UserId`,
+			hashResetToken(token),
+		).Scan(&userId)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+		return updatePassword(ctx, tx, userId, hashedPassword)
+	})
+	if err != nil {
+		return "", err
+	}
+	return userId, nil
+}
+
+func (u *Model) GetEmail(ctx context.Context, userId string) (string, error) {
+	var email string
+	err := u.pool.QueryRow(ctx, `SELECT Email FROM Users WHERE Id = $1`, userId).
+		Scan(&email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return email, err
+}
+
+type Totp struct {
+	// empty if the user has never started enrollment
+	Secret string
+	// false until the user confirms enrollment with the first code
+	Enabled bool
+}
+
+var ErrTotpEnabled = errors.New("two-factor authentication is already enabled")
+
+func (u *Model) GetTotp(ctx context.Context, userId string) (Totp, error) {
+	var secret *string
+	var res Totp
+	err := u.pool.QueryRow(
+		ctx,
+		`SELECT TotpSecret, TotpEnabled FROM Users WHERE Id = $1`,
+		userId,
+	).Scan(&secret, &res.Enabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Totp{}, ErrNotFound
+	}
+	if secret != nil {
+		res.Secret = *secret
+	}
+	return res, err
+}
+
+// SetPendingTotpSecret starts (or restarts) enrollment. The secret isn't
+// used for authentication until enrollment is confirmed with EnableTotp.
+func (u *Model) SetPendingTotpSecret(
+	ctx context.Context,
+	userId string,
+	secret string,
+) error {
+	tag, err := u.pool.Exec(
+		ctx,
+		`UPDATE Users SET TotpSecret = $2 WHERE Id = $1 AND NOT TotpEnabled`,
+		userId,
+		secret,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTotpEnabled
+	}
+	return nil
+}
+
+func hashRecoveryCode(code string) string {
+	h := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(h[:])
+}
+
+// EnableTotp turns on two-factor authentication with the pending secret and
+// replaces recovery codes of the user
+func (u *Model) EnableTotp(
+	ctx context.Context,
+	userId string,
+	recoveryCodes []string,
+) error {
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(
+		ctx,
+		`UPDATE Users SET TotpEnabled = true
+		 WHERE Id = $1 AND TotpSecret IS NOT NULL AND NOT TotpEnabled`,
+		userId,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTotpEnabled
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM RecoveryCodes WHERE UserId = $1`, userId)
+	if err != nil {
+		return err
+	}
+
+	batch := pgx.Batch{}
+	for _, code := range recoveryCodes {
+		batch.Queue(
+			`INSERT INTO RecoveryCodes(UserId, CodeHash) VALUES ($1, $2)`,
+			userId,
+			hashRecoveryCode(code),
+		)
+	}
+	if err := tx.SendBatch(ctx, &batch).Close(); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (u *Model) DisableTotp(ctx context.Context, userId string) error {
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE Users SET TotpSecret = NULL, TotpEnabled = false WHERE Id = $1`,
+		userId,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM RecoveryCodes WHERE UserId = $1`, userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UseTotpStep remembers that a code for the time step has been accepted.
+// It returns false if a code for this or a later step has already been used.
+func (u *Model) UseTotpStep(
+	ctx context.Context,
+	userId string,
+	step int64,
+) (bool, error) {
+	tag, err := u.pool.Exec(
+		ctx,
+		`UPDATE Users SET TotpLastStep = $2 WHERE Id = $1 AND TotpLastStep < $2`,
+		userId,
+		step,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ConsumeRecoveryCode returns false if the code doesn't belong to the user
+// or has already been used
+func (u *Model) ConsumeRecoveryCode(
+	ctx context.Context,
+	userId string,
+	code string,
+) (bool, error) {
+	tag, err := u.pool.Exec(
+		ctx,
+		`UPDATE RecoveryCodes SET UsedAt = now()
+		 WHERE UserId = $1 AND CodeHash = $2 AND UsedAt IS NULL`,
+		userId,
+		hashRecoveryCode(code),
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetIdByIdentity finds the user an external identity has been linked to
+func (u *Model) GetIdByIdentity(
+	ctx context.Context,
+	provider string,
+	subject string,
+) (string, error) {
+	var id string
+	err := u.pool.QueryRow(
+		ctx,
+		`SELECT UserId FROM Identities WHERE Provider = $1 AND Subject = $2`,
+		provider,
+		subject,
+	).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return id, err
+}
+
+// LinkIdentity lets the user log in with the external identity. Linking an
+// identity that already belongs to the user is a no-op.
+func (u *Model) LinkIdentity(
+	ctx context.Context,
+	userId string,
+	provider string,
+	subject string,
+) error {
+	_, err := u.pool.Exec(
+		ctx,
+		`INSERT INTO Identities(Provider, Subject, UserId) VALUES ($1, $2, $3)
+		 ON CONFLICT (Provider, Subject) DO NOTHING`,
+		provider,
+		subject,
+		userId,
+	)
+	return err
+}
+
+// CreateWithIdentity registers a user that signed in with an external
+// identity for the first time. Unlike regular sign ups, the user is inserted
+// synchronously, because the identity references it.
+func (u *Model) CreateWithIdentity(
+	ctx context.Context,
+	user *responses.Authenticator,
+	provider string,
+	subject string,
+) error {
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO Users(Id, Name, Email, HashedPassword) VALUES ($1, $2, $3, $4)`,
+		user.Id,
+		user.Name,
+		user.Email,
+		user.HashedPassword,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO Identities(Provider, Subject, UserId) VALUES ($1, $2, $3)`,
+		provider,
+		subject,
+		user.Id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Ping checks that the database is reachable
+func (u *Model) Ping(ctx context.Context) error {
+	return u.pool.Ping(ctx)
+}
+
+// Stat returns statistics of the connection pool
+func (u *Model) Stat() *pgxpool.Stat {
+	return u.pool.Stat()
+}
+
 func (u *Model) Close() {
 	u.pool.Close()
 }
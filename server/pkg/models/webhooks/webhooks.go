@@ -0,0 +1,401 @@
+// Package webhooks keeps webhook subscriptions of users and workspaces and
+// the deliveries of link events to them
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/domain"
+	"shortener/pkg/tracing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Model struct {
+	pool *pgxpool.Pool
+}
+
+type webhooksOption func(m *Model) error
+
+func WithPool(ctx context.Context, dsn string) webhooksOption {
+	return func(m *Model) error {
+		conf, err := tracing.PoolConfig(dsn)
+		if err != nil {
+			return err
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, conf)
+		if err != nil {
+			return err
+		}
+
+		if err := pool.Ping(ctx); err != nil {
+			return err
+		}
+
+		m.pool = pool
+		return nil
+	}
+}
+
+func New(opts ...webhooksOption) (*Model, error) {
+	m := new(Model)
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.pool == nil {
+		return nil, errors.New("no connection pool provided")
+	}
+	return m, nil
+}
+
+var (
+	ErrNotFound     = errors.New("webhook subscription not found")
+	ErrLinkNotFound = errors.New("link not found")
+)
+
+// CreateSubscription stores the subscription and sets its id and creation
+// time
+func (m *Model) CreateSubscription(
+	ctx context.Context,
+	s *domain.WebhookSubscription,
+) error {
+	return m.pool.QueryRow(
+		ctx,
+		`INSERT INTO WebhookSubscriptions(UserId, WorkspaceId, Url, Secret, EventTypes)
+		 VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5)
+		 RETURNING Id::text, CreatedAt`,
+		s.UserId,
+		s.WorkspaceId,
+		s.Url,
+		s.Secret,
+		eventTypes(s.EventTypes),
+	).Scan(&s.Id, &s.CreatedAt)
+}
+
+const subscriptionColumns = `Id::text, UserId::text, COALESCE(WorkspaceId::text, ''),
+	Url, EventTypes, CreatedAt`
+
+func scanSubscription(row pgx.Row) (*domain.WebhookSubscription, error) {
+	var s domain.WebhookSubscription
+	var types []string
+	err := row.Scan(&s.Id, &s.UserId, &s.WorkspaceId, &s.Url, &types, &s.CreatedAt)
+	for _, t := range types {
+		s.EventTypes = append(s.EventTypes, domain.EventType(t))
+	}
+	return &s, err
+}
+
+// Subscriptions returns subscriptions of the workspace, or personal
+// subscriptions of the user if workspaceId is empty. Secrets aren't
+// returned.
+func (m *Model) Subscriptions(
+	ctx context.Context,
+	userId string,
+	workspaceId string,
+) ([]*domain.WebhookSubscription, error) {
+	query := `SELECT ` + subscriptionColumns + ` FROM WebhookSubscriptions
+		 WHERE WorkspaceId IS NULL AND UserId = $1
+		 ORDER BY CreatedAt`
+	arg := userId
+	if workspaceId != "" {
+		query = `SELECT ` + subscriptionColumns + ` FROM WebhookSubscriptions
+		 WHERE WorkspaceId = $1
+		 ORDER BY CreatedAt`
+		arg = workspaceId
+	}
+	rows, err := m.pool.Query(ctx, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*domain.WebhookSubscription, error) {
+		return scanSubscription(row)
+	})
+}
+
+// Subscription returns the subscription without its secret
+func (m *Model) Subscription(
+	ctx context.Context,
+	id string,
+) (*domain.WebhookSubscription, error) {
+	s, err := scanSubscription(m.pool.QueryRow(
+		ctx,
+		`SELECT `+subscriptionColumns+` FROM WebhookSubscriptions WHERE Id = $1`,
+		id,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DeleteSubscription removes the subscription along with its deliveries
+func (m *Model) DeleteSubscription(ctx context.Context, id string) error {
+	tag, err := m.pool.Exec(
+		ctx,
+		`DELETE FROM WebhookSubscriptions WHERE Id = $1`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Matching returns subscriptions of the owner of a link to the event type:
+// of the workspace, or personal subscriptions of the user if workspaceId is
+// empty
+func (m *Model) Matching(
+	ctx context.Context,
+	userId string,
+	workspaceId string,
+	t domain.EventType,
+) ([]*domain.WebhookSubscription, error) {
+	rows, err := m.pool.Query(
+		ctx,
+		`SELECT `+subscriptionColumns+` FROM WebhookSubscriptions
+		 WHERE CASE WHEN $2 = '' THEN WorkspaceId IS NULL AND UserId = $1::uuid
+		            ELSE WorkspaceId = NULLIF($2, '')::uuid END
+		   AND $3 = ANY(EventTypes)`,
+		userId,
+		workspaceId,
+		string(t),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*domain.WebhookSubscription, error) {
+		return scanSubscription(row)
+	})
+}
+
+// Link returns the owner of the link, looking into the archive for links
+// that have expired. It returns ErrLinkNotFound if there's no such link.
+func (m *Model) Link(ctx context.Context, shortUrl string) (*domain.Link, error) {
+	var l domain.Link
+	err := m.pool.QueryRow(
+		ctx,
+		`SELECT ShortUrl, LongUrl, UserId::text, COALESCE(WorkspaceId::text, ''),
+		        ExpirationDate
+		 FROM (
+		     SELECT ShortUrl, LongUrl, UserId, WorkspaceId, ExpirationDate,
+		            'infinity'::timestamp AS ArchivedAt
+		     FROM Urls WHERE ShortUrl = $1
+		     UNION ALL
+		     SELECT ShortUrl, LongUrl, UserId, WorkspaceId, ExpirationDate, ArchivedAt
+		     FROM UrlsArchive WHERE ShortUrl = $1
+		 ) links
+		 ORDER BY ArchivedAt DESC
+		 LIMIT 1`,
+		shortUrl,
+	).Scan(&l.ShortUrl, &l.LongUrl, &l.UserId, &l.WorkspaceId, &l.ExpirationDate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// Enqueue stores the deliveries to be attempted right away. Deliveries of
+// an event that has already been enqueued for the subscription are skipped.
+func (m *Model) Enqueue(ctx context.Context, dd []*domain.WebhookDelivery) error {
+	if len(dd) == 0 {
+		return nil
+	}
+	subscriptions := make([]string, len(dd))
+	ids := make([]string, len(dd))
+	types := make([]string, len(dd))
+	payloads := make([]string, len(dd))
+	for i, d := range dd {
+		subscriptions[i] = d.SubscriptionId
+		ids[i] = d.EventId
+		types[i] = string(d.EventType)
+		payloads[i] = string(d.Payload)
+	}
+	_, err := m.pool.Exec(
+		ctx,
+		`INSERT INTO WebhookDeliveries(SubscriptionId, EventId, EventType, Payload, NextAttemptAt)
+		 SELECT s, e, t, p, now()
+		 FROM unnest($1::uuid[], $2::text[], $3::text[], $4::jsonb[]) AS d(s, e, t, p)
+		 ON CONFLICT (SubscriptionId, EventId) DO NOTHING`,
+		subscriptions,
+		ids,
+		types,
+		payloads,
+	)
+	return err
+}
+
+// Due leases at most limit pending deliveries which next attempt is due,
+// along with urls and secrets of their subscriptions. Leased deliveries
+// aren't due for the lease, so that instances don't attempt them at once.
+// If an instance stops before recording an attempt, the delivery is
+// attempted again after the lease.
+func (m *Model) Due(
+	ctx context.Context,
+	lease time.Duration,
+	limit int,
+) ([]*domain.WebhookDelivery, error) {
+	rows, err := m.pool.Query(
+		ctx,
+		`WITH due AS (
+		     SELECT Id FROM WebhookDeliveries
+		     WHERE Status = 'pending' AND NextAttemptAt <= now()
+		     ORDER BY NextAttemptAt
+		     LIMIT $2
+		     FOR UPDATE SKIP LOCKED
+		 )
+		 UPDATE WebhookDeliveries d
+		 SET NextAttemptAt = now() + make_interval(secs => $1)
+		 FROM due, WebhookSubscriptions s
+		 WHERE d.Id = due.Id AND s.Id = d.SubscriptionId
+		 RETURNING d.Id::text, d.SubscriptionId::text, d.EventId, d.EventType,
+		           d.Payload::text, d.Attempts, d.CreatedAt, s.Url, s.Secret`,
+		lease.Seconds(),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*domain.WebhookDelivery, error) {
+		var d domain.WebhookDelivery
+		var eventType, payload string
+		err := row.Scan(
+			&d.Id,
+			&d.SubscriptionId,
+			&d.EventId,
+			&eventType,
+			&payload,
+			&d.Attempts,
+			&d.CreatedAt,
+			&d.Url,
+			&d.Secret,
+		)
+		d.EventType = domain.EventType(eventType)
+		d.Payload = []byte(payload)
+		d.Status = domain.DeliveryPending
+		return &d, err
+	})
+}
+
+// Record saves the outcome of an attempt: Status, Attempts, ResponseStatus
+// and LastError of the delivery. A pending delivery is attempted again after
+// retryIn.
+func (m *Model) Record(
+	ctx context.Context,
+	d *domain.WebhookDelivery,
+	retryIn time.Duration,
+) error {
+	_, err := m.pool.Exec(
+		ctx,
+		`UPDATE WebhookDeliveries SET
+		     Status = $2,
+		     Attempts = $3,
+		     ResponseStatus = NULLIF($4, 0),
+		     LastError = NULLIF($5, ''),
+		     NextAttemptAt = CASE WHEN $2 = 'pending'
+		         THEN now() + make_interval(secs => $6) END,
+		     DeliveredAt = CASE WHEN $2 = 'delivered' THEN now() END
+		 WHERE Id = $1`,
+		d.Id,
+		string(d.Status),
+		d.Attempts,
+		d.ResponseStatus,
+		d.LastError,
+		retryIn.Seconds(),
+	)
+	return err
+}
+
+// Deliveries returns at most limit latest deliveries of the subscription,
+// the delivery log
+func (m *Model) Deliveries(
+	ctx context.Context,
+	subscriptionId string,
+	limit int,
+) ([]*domain.WebhookDelivery, error) {
+	rows, err := m.pool.Query(
+		ctx,
+		`SELECT Id::text, SubscriptionId::text, EventId, EventType, Payload::text,
+		        Status, Attempts, COALESCE(ResponseStatus, 0), COALESCE(LastError, ''),
+		        NextAttemptAt, CreatedAt, DeliveredAt
+		 FROM WebhookDeliveries
+		 WHERE SubscriptionId = $1
+		 ORDER BY CreatedAt DESC
+		 LIMIT $2`,
+		subscriptionId,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*domain.WebhookDelivery, error) {
+		var d domain.WebhookDelivery
+		var eventType, payload, status string
+		err := row.Scan(
+			&d.Id,
+			&d.SubscriptionId,
+			&d.EventId,
+			&eventType,
+			&payload,
+			&status,
+			&d.Attempts,
+			&d.ResponseStatus,
+			&d.LastError,
+			&d.NextAttemptAt,
+			&d.CreatedAt,
+			&d.DeliveredAt,
+		)
+		d.EventType = domain.EventType(eventType)
+		d.Payload = []byte(payload)
+		d.Status = domain.DeliveryStatus(status)
+		return &d, err
+	})
+}
+
+// Prune removes delivered and dead deliveries created before the moment
+func (m *Model) Prune(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := m.pool.Exec(
+		ctx,
+		`DELETE FROM WebhookDeliveries
+		 WHERE Status <> 'pending' AND CreatedAt < $1`,
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func eventTypes(tt []domain.EventType) []string {
+	res := make([]string, len(tt))
+	for i, t := range tt {
+		res[i] = string(t)
+	}
+	return res
+}
+
+// Ping checks that the database is reachable
+func (m *Model) Ping(ctx context.Context) error {
+	return m.pool.Ping(ctx)
+}
+
+// Stat returns statistics of the connection pool
+func (m *Model) Stat() *pgxpool.Stat {
+	return m.pool.Stat()
+}
+
+func (m *Model) Close() {
+	m.pool.Close()
+}
@@ -0,0 +1,375 @@
+package workspaces
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"shortener/pkg/domain"
+	"shortener/pkg/tracing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Model struct {
+	pool *pgxpool.Pool
+}
+
+type workspacesOption func(m *Model) error
+
+func WithPool(ctx context.Context, dsn string) workspacesOption {
+	return func(m *Model) error {
+		conf, err := tracing.PoolConfig(dsn)
+		if err != nil {
+			return err
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, conf)
+		if err != nil {
+			return err
+		}
+
+		if err := pool.Ping(ctx); err != nil {
+			return err
+		}
+
+		m.pool = pool
+		return nil
+	}
+}
+
+func New(opts ...workspacesOption) (*Model, error) {
+	m := new(Model)
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.pool == nil {
+		return nil, errors.New("no connection pool provided")
+	}
+	return m, nil
+}
+
+var ErrNotMember = errors.New("user isn't a member of the workspace")
+
+// ErrLastOwner is returned on attempt to leave a workspace without owners
+var ErrLastOwner = errors.New("workspace must have at least one owner")
+
+var ErrInvalidInvite = errors.New("invalid or expired invite")
+
+// Create makes a new workspace owned by the user
+func (m *Model) Create(
+	ctx context.Context,
+	ownerId string,
+	name string,
+) (string, error) {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var id string
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO Workspaces(Name) VALUES ($1) RETURNING Id`,
+		name,
+	).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO WorkspaceMembers(WorkspaceId, UserId, Role) VALUES ($1, $2, $3)`,
+		id,
+		ownerId,
+		domain.RoleOwner,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return id, tx.Commit(ctx)
+}
+
+// List returns workspaces the user is a member of
+func (m *Model) List(
+	ctx context.Context,
+	userId string,
+) ([]*domain.Workspace, error) {
+	rows, err := m.pool.Query(
+		ctx,
+		`SELECT w.Id, w.Name, m.Role FROM Workspaces w
+		 JOIN WorkspaceMembers m ON m.WorkspaceId = w.Id
+		 WHERE m.UserId = $1
+		 ORDER BY w.CreatedAt`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*domain.Workspace{}
+	for rows.Next() {
+		var w domain.Workspace
+		if err := rows.Scan(&w.Id, &w.Name, &w.Role); err != nil {
+			return nil, err
+		}
+		res = append(res, &w)
+	}
+	return res, rows.Err()
+}
+
+func (m *Model) Role(
+	ctx context.Context,
+	workspaceId string,
+	userId string,
+) (domain.Role, error) {
+	var role domain.Role
+	err := m.pool.QueryRow(
+		ctx,
+		`SELECT Role FROM WorkspaceMembers WHERE WorkspaceId = $1 AND UserId = $2`,
+		workspaceId,
+		userId,
+	).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotMember
+	}
+	return role, err
+}
+
+func (m *Model) Members(
+	ctx context.Context,
+	workspaceId string,
+) ([]*domain.Member, error) {
+	rows, err := m.pool.Query(
+		ctx,
+		`SELECT u.Id, u.Name, u.Email, m.Role FROM WorkspaceMembers m
+		 JOIN Users u ON u.Id = m.UserId
+		 WHERE m.WorkspaceId = $1
+		 ORDER BY m.JoinedAt`,
+		workspaceId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*domain.Member{}
+	for rows.Next() {
+		var member domain.Member
+		err := rows.Scan(&member.UserId, &member.Name, &member.Email, &member.Role)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &member)
+	}
+	return res, rows.Err()
+}
+
+// lockOwners blocks concurrent membership changes of the workspace and
+// returns the number of its owners
+func lockOwners(ctx context.Context, tx pgx.Tx, workspaceId string) (int, error) {
+	_, err := tx.Exec(
+		ctx,
+		`SELECT 1 FROM Workspaces WHERE Id = $1 FOR UPDATE`,
+		workspaceId,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var owners int
+	err = tx.QueryRow(
+		ctx,
+		`SELECT count(*) FROM WorkspaceMembers WHERE WorkspaceId = $1 AND Role = $2`,
+		workspaceId,
+		domain.RoleOwner,
+	).Scan(&owners)
+	return owners, err
+}
+
+// SetRole changes role of a member. The last owner can't be demoted.
+func (m *Model) SetRole(
+	ctx context.Context,
+	workspaceId string,
+	userId string,
+	role domain.Role,
+) error {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	owners, err := lockOwners(ctx, tx, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	var current domain.Role
+	err = tx.QueryRow(
+		ctx,
+		`SELECT Role FROM WorkspaceMembers WHERE WorkspaceId = $1 AND UserId = $2`,
+		workspaceId,
+		userId,
+	).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotMember
+	}
+	if err != nil {
+		return err
+	}
+
+	if current == domain.RoleOwner && role != domain.RoleOwner && owners == 1 {
+		return ErrLastOwner
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE WorkspaceMembers SET Role = $3 WHERE WorkspaceId = $1 AND UserId = $2`,
+		workspaceId,
+		userId,
+		role,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// RemoveMember excludes the user from the workspace. Links created by the
+// user stay in the workspace. The last owner can't be removed.
+func (m *Model) RemoveMember(
+	ctx context.Context,
+	workspaceId string,
+	userId string,
+) error {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	owners, err := lockOwners(ctx, tx, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	var role domain.Role
+	err = tx.QueryRow(
+		ctx,
+		`DELETE FROM WorkspaceMembers WHERE WorkspaceId = $1 AND UserId = $2
+		 RETURNING Role`,
+		workspaceId,
+		userId,
+	).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotMember
+	}
+	if err != nil {
+		return err
+	}
+
+	if role == domain.RoleOwner && owners == 1 {
+		return ErrLastOwner
+	}
+
+	return tx.Commit(ctx)
+}
+
+func hashInviteToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func (m *Model) CreateInvite(
+	ctx context.Context,
+	workspaceId string,
+	email string,
+	role domain.Role,
+	invitedBy string,
+	token string,
+	ttl time.Duration,
+) error {
+	_, err := m.pool.Exec(
+		ctx,
+		`INSERT INTO WorkspaceInvites(TokenHash, WorkspaceId, Email, Role, InvitedBy, ExpiresAt)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		hashInviteToken(token),
+		workspaceId,
+		email,
+		role,
+		invitedBy,
+		time.Now().Add(ttl),
+	)
+	return err
+}
+
+// AcceptInvite makes the user a member of the workspace the invite has been
+// sent for. The invite is valid only for the email it has been sent to.
+// Roles of existing members aren't changed.
+func (m *Model) AcceptInvite(
+	ctx context.Context,
+	token string,
+	userId string,
+	email string,
+) (string, domain.Role, error) {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var workspaceId string
+	var role domain.Role
+	err = tx.QueryRow(
+		ctx,
+		`UPDATE WorkspaceInvites SET AcceptedAt = now()
+		 WHERE TokenHash = $1 AND Email = $2
+		   AND AcceptedAt IS NULL AND now() < ExpiresAt
+		 RETURNING WorkspaceId, Role`,
+		hashInviteToken(token),
+		email,
+	).Scan(&workspaceId, &role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", ErrInvalidInvite
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO WorkspaceMembers(WorkspaceId, UserId, Role) VALUES ($1, $2, $3)
+		 ON CONFLICT (WorkspaceId, UserId) DO UPDATE SET Role = WorkspaceMembers.Role
+		 RETURNING Role`,
+		workspaceId,
+		userId,
+		role,
+	).Scan(&role)
+	if err != nil {
+		return "", "", err
+	}
+
+	return workspaceId, role, tx.Commit(ctx)
+}
+
+// Ping checks that the database is reachable
+func (m *Model) Ping(ctx context.Context) error {
+	return m.pool.Ping(ctx)
+}
+
+// Stat returns statistics of the connection pool
+func (m *Model) Stat() *pgxpool.Stat {
+	return m.pool.Stat()
+}
+
+func (m *Model) Close() {
+	m.pool.Close()
+}
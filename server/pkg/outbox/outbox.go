@@ -0,0 +1,230 @@
+// Package outbox keeps events in Postgres until they are published to the
+// bus. An event written to the outbox survives outages of the broker: a relay publishes it
+// with retries and marks it as delivered.
+package outbox
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"shortener/pkg/bus"
+	"shortener/pkg/tracing"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Model struct {
+	pool *pgxpool.Pool
+}
+
+type outboxOption func(m *Model) error
+
+func WithPool(ctx context.Context, dsn string) outboxOption {
+	return func(m *Model) error {
+		conf, err := tracing.PoolConfig(dsn)
+		if err != nil {
+			return err
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, conf)
+		if err != nil {
+			return err
+		}
+
+		if err := pool.Ping(ctx); err != nil {
+			return err
+		}
+
+		m.pool = pool
+		return nil
+	}
+}
+
+func New(opts ...outboxOption) (*Model, error) {
+	m := new(Model)
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.pool == nil {
+		return nil, errors.New("no connection pool provided")
+	}
+	return m, nil
+}
+
+type Entry struct {
+	Id       int64
+	Topic    string
+	Key      []byte
+	Payload  []byte
+	Headers  map[string]string
+	Attempts int
+}
+
+// Add stores the event for publishing. The event is durable once Add returns.
+// The trace context of ctx is stored in the headers, so that the trace is
+// continued when the event is published.
+func (m *Model) Add(
+	ctx context.Context,
+	topic string,
+	key []byte,
+	payload []byte,
+	headers map[string]string,
+) error {
+	_, err := m.pool.Exec(
+		ctx,
+		`INSERT INTO Outbox(Topic, Key, Payload, Headers) VALUES ($1, $2, $3, $4)`,
+		topic,
+		key,
+		payload,
+		traced(ctx, headers),
+	)
+	return err
+}
+
+// AddBatch stores the events for publishing in a single transaction: either
+// all of them are durable once it returns, or none. Events are published in
+// the order of the slice, with the trace context of ctx like in Add.
+func (m *Model) AddBatch(ctx context.Context, mm []*bus.Message) error {
+	batch := pgx.Batch{}
+	for _, msg := range mm {
+		batch.Queue(
+			`INSERT INTO Outbox(Topic, Key, Payload, Headers) VALUES ($1, $2, $3, $4)`,
+			msg.Topic,
+			msg.Key,
+			msg.Value,
+			traced(ctx, msg.Headers),
+		)
+	}
+	return m.pool.SendBatch(ctx, &batch).Close()
+}
+
+// traced returns a copy of the headers with the trace context of ctx
+func traced(ctx context.Context, headers map[string]string) map[string]string {
+	res := make(map[string]string, len(headers)+2)
+	for k, v := range headers {
+		res[k] = v
+	}
+	tracing.Inject(ctx, res)
+	return res
+}
+
+// Relay leases up to limit pending entries and passes them to publish,
+// which returns errors of the entries that haven't been published, by id.
+// Published entries are marked as delivered, the others are postponed for
+// the time backoff returns for the number of attempts made. The lease is
+// committed before publishing, so that rows aren't locked while the broker
+// is waited for: concurrent relays skip leased entries until the lease runs
+// out, and publish is cancelled by then.
+func (m *Model) Relay(
+	ctx context.Context,
+	limit int,
+	lease time.Duration,
+	publish func(ctx context.Context, ee []*Entry) map[int64]error,
+	backoff func(attempts int) time.Duration,
+) (delivered int, failed int, err error) {
+	rows, err := m.pool.Query(
+		ctx,
+		`UPDATE Outbox SET NextAttemptAt = now() + make_interval(secs => $2)
+		 WHERE Id IN (
+		     SELECT Id FROM Outbox
+		     WHERE DeliveredAt IS NULL AND NextAttemptAt <= now()
+		     ORDER BY Id
+		     LIMIT $1
+		     FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING Id, Topic, Key, Payload, Headers, Attempts`,
+		limit,
+		lease.Seconds(),
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Entry, error) {
+		var e Entry
+		err := row.Scan(&e.Id, &e.Topic, &e.Key, &e.Payload, &e.Headers, &e.Attempts)
+		return &e, err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(entries) == 0 {
+		return 0, 0, nil
+	}
+	// RETURNING doesn't keep the order of the subquery
+	slices.SortFunc(entries, func(a, b *Entry) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+
+	publishCtx, cancel := context.WithTimeout(ctx, lease)
+	errs := publish(publishCtx, entries)
+	cancel()
+
+	var deliveredIds []int64
+	err = pgx.BeginFunc(ctx, m.pool, func(tx pgx.Tx) error {
+		for _, e := range entries {
+			perr, isFailed := errs[e.Id]
+			if !isFailed {
+				deliveredIds = append(deliveredIds, e.Id)
+				continue
+			}
+
+			_, err := tx.Exec(
+				ctx,
+				`UPDATE Outbox
+				 SET Attempts = Attempts + 1, LastError = $2, NextAttemptAt = $3
+				 WHERE Id = $1`,
+				e.Id,
+				perr.Error(),
+				time.Now().Add(backoff(e.Attempts+1)),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(deliveredIds) == 0 {
+			return nil
+		}
+		_, err := tx.Exec(
+			ctx,
+			`UPDATE Outbox
+			 SET Attempts = Attempts + 1, LastError = NULL, DeliveredAt = now()
+			 WHERE Id = ANY($1)`,
+			deliveredIds,
+		)
+		return err
+	})
+	if err != nil {
+		// unmarked entries are published again once the lease runs out
+		return 0, 0, err
+	}
+	return len(deliveredIds), len(errs), nil
+}
+
+// Purge deletes entries delivered more than retention ago
+func (m *Model) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	tag, err := m.pool.Exec(
+		ctx,
+		`DELETE FROM Outbox WHERE DeliveredAt < $1`,
+		time.Now().Add(-retention),
+	)
+	return tag.RowsAffected(), err
+}
+
+// Ping checks that the database is reachable
+func (m *Model) Ping(ctx context.Context) error {
+	return m.pool.Ping(ctx)
+}
+
+// Stat returns statistics of the connection pool
+func (m *Model) Stat() *pgxpool.Stat {
+	return m.pool.Stat()
+}
+
+func (m *Model) Close() {
+	m.pool.Close()
+}
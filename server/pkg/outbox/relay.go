@@ -0,0 +1,244 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/bus"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Stats counts outcomes of publishing
+type Stats struct {
+	published atomic.Int64
+	failed    atomic.Int64
+}
+
+func (s *Stats) Published() int64 {
+	return s.published.Load()
+}
+
+func (s *Stats) Failed() int64 {
+	return s.failed.Load()
+}
+
+// Relay publishes entries of the outbox to the bus. Several relays may run
+// over the same outbox. An entry may be published more than once, e.g. if
+// the relay stops between publishing and marking, so consumers must be
+// idempotent.
+type Relay struct {
+	outbox    *Model
+	publisher bus.Publisher
+
+	interval  time.Duration
+	batchSize int
+	lease     time.Duration
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	retention time.Duration
+
+	stats Stats
+
+	log *zerolog.Logger
+}
+
+type relayOption func(r *Relay) error
+
+func WithOutbox(m *Model) relayOption {
+	return func(r *Relay) error {
+		r.outbox = m
+		return nil
+	}
+}
+
+func WithPublisher(p bus.Publisher) relayOption {
+	return func(r *Relay) error {
+		r.publisher = p
+		return nil
+	}
+}
+
+// WithInterval sets how often the outbox is polled when it's drained
+func WithInterval(d time.Duration) relayOption {
+	return func(r *Relay) error {
+		if d <= 0 {
+			return errors.New("interval must be positive")
+		}
+		r.interval = d
+		return nil
+	}
+}
+
+func WithBatchSize(n int) relayOption {
+	return func(r *Relay) error {
+		if n < 1 {
+			return errors.New("batch size must be positive")
+		}
+		r.batchSize = n
+		return nil
+	}
+}
+
+// WithLease sets for how long a batch may be published. Entries of the batch
+// aren't taken by other relays until then, and are published again after it
+// if they haven't been marked.
+func WithLease(d time.Duration) relayOption {
+	return func(r *Relay) error {
+		if d <= 0 {
+			return errors.New("lease must be positive")
+		}
+		r.lease = d
+		return nil
+	}
+}
+
+// WithBackoff limits delays between attempts to publish an entry. The delay
+// doubles with every failed attempt.
+func WithBackoff(base time.Duration, max time.Duration) relayOption {
+	return func(r *Relay) error {
+		if base <= 0 || max < base {
+			return errors.New("invalid backoff")
+		}
+		r.baseDelay = base
+		r.maxDelay = max
+		return nil
+	}
+}
+
+// WithRetention sets for how long delivered entries are kept
+func WithRetention(d time.Duration) relayOption {
+	return func(r *Relay) error {
+		r.retention = d
+		return nil
+	}
+}
+
+func WithLogger(l *zerolog.Logger) relayOption {
+	return func(r *Relay) error {
+		r.log = l
+		return nil
+	}
+}
+
+func NewRelay(opts ...relayOption) (*Relay, error) {
+	r := &Relay{
+		interval:  500 * time.Millisecond,
+		batchSize: 500,
+		lease:     30 * time.Second,
+		baseDelay: time.Second,
+		maxDelay:  5 * time.Minute,
+		retention: 24 * time.Hour,
+	}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.outbox == nil {
+		return nil, errors.New("no outbox provided")
+	}
+	if r.publisher == nil {
+		return nil, errors.New("no publisher provided")
+	}
+	if r.log == nil {
+		return nil, errors.New("no logger provided")
+	}
+	return r, nil
+}
+
+func (r *Relay) Stats() *Stats {
+	return &r.stats
+}
+
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.baseDelay << (attempts - 1)
+	if d > r.maxDelay || d <= 0 {
+		return r.maxDelay
+	}
+	return d
+}
+
+func (r *Relay) publish(ctx context.Context, entries []*Entry) map[int64]error {
+	messages := make([]*bus.Message, len(entries))
+	for i, e := range entries {
+		messages[i] = &bus.Message{
+			Topic:   e.Topic,
+			Key:     e.Key,
+			Value:   e.Payload,
+			Headers: e.Headers,
+		}
+	}
+
+	err := r.publisher.Publish(ctx, messages...)
+	if err == nil {
+		return nil
+	}
+
+	errs := map[int64]error{}
+	var perrs bus.PublishErrors
+	if !errors.As(err, &perrs) {
+		for _, e := range entries {
+			errs[e.Id] = err
+		}
+		return errs
+	}
+	for i, perr := range perrs {
+		errs[entries[i].Id] = perr
+	}
+	return errs
+}
+
+// Run publishes entries until the context is cancelled. A batch being
+// published when it's cancelled is finished, so that the published entries
+// are marked as delivered and aren't published again after a restart.
+func (r *Relay) Run(ctx context.Context) {
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+
+	for {
+		delivered, failed, err := r.outbox.Relay(
+			context.WithoutCancel(ctx),
+			r.batchSize,
+			r.lease,
+			r.publish,
+			r.backoff,
+		)
+		r.stats.published.Add(int64(delivered))
+		r.stats.failed.Add(int64(failed))
+		if err != nil && ctx.Err() == nil {
+			r.log.Error().Err(err).Msg("couldn't relay outbox entries")
+		}
+		if failed > 0 {
+			r.log.Error().
+				Int("failed", failed).
+				Int64("failed_total", r.stats.Failed()).
+				Msg("couldn't publish outbox entries. will retry")
+		}
+		if delivered > 0 {
+			r.log.Info().
+				Int("delivered", delivered).
+				Msg("published outbox entries")
+		}
+
+		// there may be more pending entries
+		wait := r.interval
+		if err == nil && failed == 0 && delivered == r.batchSize {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-purge.C:
+			n, err := r.outbox.Purge(ctx, r.retention)
+			if err != nil {
+				r.log.Error().Err(err).Msg("couldn't purge delivered outbox entries")
+			} else {
+				r.log.Info().Int64("purged", n).Msg("purged delivered outbox entries")
+			}
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/bus"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	bus_mocks "shortener/mocks/shortener/pkg/bus"
+)
+
+func newRelay(t *testing.T, p bus.Publisher, opts ...relayOption) *Relay {
+	log := zerolog.Nop()
+	r, err := NewRelay(append([]relayOption{
+		WithOutbox(&Model{}),
+		WithPublisher(p),
+		WithLogger(&log),
+	}, opts...)...)
+	require.NoError(t, err)
+	return r
+}
+
+func TestBackoff(t *testing.T) {
+	r := newRelay(
+		t,
+		bus_mocks.NewMockPublisher(t),
+		WithBackoff(time.Second, 5*time.Minute),
+	)
+	for attempts, d := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		9:  256 * time.Second,
+		10: 5 * time.Minute,
+		// the shift overflows
+		100: 5 * time.Minute,
+	} {
+		assert.Equal(t, d, r.backoff(attempts), "attempts: %d", attempts)
+	}
+}
+
+func TestPublishErrorsById(t *testing.T) {
+	entries := []*Entry{
+		{Id: 10, Topic: "links", Payload: []byte("a")},
+		{Id: 11, Topic: "links", Payload: []byte("b")},
+	}
+	tooLarge := errors.New("message too large")
+	unavailable := errors.New("broker is unavailable")
+
+	p := bus_mocks.NewMockPublisher(t)
+	p.EXPECT().
+		Publish(mock.Anything, mock.Anything, mock.Anything).
+		Return(nil).
+		Once()
+	p.EXPECT().
+		Publish(mock.Anything, mock.Anything, mock.Anything).
+		Return(bus.PublishErrors{1: tooLarge}).
+		Once()
+	p.EXPECT().
+		Publish(mock.Anything, mock.Anything, mock.Anything).
+		Return(unavailable).
+		Once()
+	r := newRelay(t, p)
+
+	ctx := context.Background()
+	assert.Empty(t, r.publish(ctx, entries))
+	assert.Equal(t, map[int64]error{11: tooLarge}, r.publish(ctx, entries))
+	assert.Equal(t, map[int64]error{10: unavailable, 11: unavailable}, r.publish(ctx, entries))
+}
+
+func TestNewRelayValidatesOptions(t *testing.T) {
+	p := bus_mocks.NewMockPublisher(t)
+	log := zerolog.Nop()
+
+	_, err := NewRelay(WithPublisher(p), WithLogger(&log))
+	assert.Error(t, err)
+	_, err = NewRelay(WithOutbox(&Model{}), WithPublisher(p), WithLogger(&log), WithLease(0))
+	assert.Error(t, err)
+	_, err = NewRelay(
+		WithOutbox(&Model{}),
+		WithPublisher(p),
+		WithLogger(&log),
+		WithBackoff(time.Minute, time.Second),
+	)
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+// Package renewal extends expiration dates of links within the lifetime
+// their plans allow. Links may be renewed on request, or automatically when
+// they are clicked shortly before expiring.
+package renewal
+
+import (
+	"context"
+	"errors"
+	"shortener/pkg/domain"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+)
+
+var (
+	autoRenewed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "renewal_auto_renewed_links_total",
+		Help: "Links renewed because they have been clicked shortly before expiring.",
+	})
+	droppedClicks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "renewal_dropped_clicks_total",
+		Help: "Clicks not checked for auto-renewal because the queue has been full.",
+	})
+)
+
+var ErrMaxLifetime = errors.New("link has reached the max lifetime of its plan")
+
+type Policy struct {
+	// the furthest expiration date of a link, counted from now, by plan.
+	// Links on other plans are limited as free ones.
+	MaxLifetime map[domain.Plan]time.Duration
+	// links with auto-renewal are renewed by AutoRenewBy when they are
+	// clicked less than AutoRenewWithin before expiring
+	AutoRenewWithin time.Duration
+	AutoRenewBy     time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxLifetime: map[domain.Plan]time.Duration{
+			domain.PlanFree: 365 * 24 * time.Hour,
+			domain.PlanPro:  5 * 365 * 24 * time.Hour,
+		},
+		AutoRenewWithin: 7 * 24 * time.Hour,
+		AutoRenewBy:     30 * 24 * time.Hour,
+	}
+}
+
+func (p Policy) maxLifetime(plan domain.Plan) time.Duration {
+	if d, ok := p.MaxLifetime[plan]; ok {
+		return d
+	}
+	return p.MaxLifetime[domain.PlanFree]
+}
+
+// Extend returns the expiration date of the link renewed by the period.
+// Expired links are renewed from now. The date is cut down to the max
+// lifetime of the plan, ErrMaxLifetime is returned if the link already
+// expires at it.
+func (p Policy) Extend(l *domain.Link, by time.Duration, now time.Time) (time.Time, error) {
+	from := l.ExpirationDate
+	if from.Before(now) {
+		from = now
+	}
+	limit := now.Add(p.maxLifetime(l.Plan))
+	if !from.Before(limit) {
+		return time.Time{}, ErrMaxLifetime
+	}
+	return minTime(from.Add(by), limit), nil
+}
+
+// AutoRenewDue reports whether a click on the link renews it
+func (p Policy) AutoRenewDue(l *domain.Link, now time.Time) bool {
+	return l.AutoRenew &&
+		l.ExpirationDate.After(now) &&
+		l.ExpirationDate.Sub(now) < p.AutoRenewWithin
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+type Urls interface {
+	Link(ctx context.Context, shortUrl string) (*domain.Link, error)
+	Renew(ctx context.Context, shortUrl string, expiration time.Time, autoRenew bool) error
+}
+
+// AutoRenewer renews links with auto-renewal on clicks. Clicks are checked
+// in the background, so that redirects don't wait for the database, and
+// every link is checked at most once in a while.
+type AutoRenewer struct {
+	urls       Urls
+	policy     Policy
+	checkEvery time.Duration
+	log        *zerolog.Logger
+
+	clicks chan string
+	// when links have been checked last. Only used by Run
+	checked map[string]time.Time
+	now     func() time.Time
+}
+
+const (
+	DefaultCheckEvery = time.Hour
+	// clicks waiting for a check, the ones above it are dropped
+	clicksQueueSize = 1024
+)
+
+type autoRenewerOption func(a *AutoRenewer) error
+
+func WithUrls(u Urls) autoRenewerOption {
+	return func(a *AutoRenewer) error {
+		a.urls = u
+		return nil
+	}
+}
+
+func WithPolicy(p Policy) autoRenewerOption {
+	return func(a *AutoRenewer) error {
+		if p.AutoRenewWithin <= 0 || p.AutoRenewBy <= 0 {
+			return errors.New("auto-renewal window and period must be positive")
+		}
+		a.policy = p
+		return nil
+	}
+}
+
+// WithCheckEvery sets how often a link clicked over and over is checked
+func WithCheckEvery(d time.Duration) autoRenewerOption {
+	return func(a *AutoRenewer) error {
+		if d <= 0 {
+			return errors.New("check interval must be positive")
+		}
+		a.checkEvery = d
+		return nil
+	}
+}
+
+func WithLogger(l *zerolog.Logger) autoRenewerOption {
+	return func(a *AutoRenewer) error {
+		a.log = l
+		return nil
+	}
+}
+
+func NewAutoRenewer(opts ...autoRenewerOption) (*AutoRenewer, error) {
+	a := &AutoRenewer{
+		policy:     DefaultPolicy(),
+		checkEvery: DefaultCheckEvery,
+		clicks:     make(chan string, clicksQueueSize),
+		checked:    map[string]time.Time{},
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+	if a.urls == nil {
+		return nil, errors.New("no urls model provided")
+	}
+	if a.log == nil {
+		return nil, errors.New("no logger provided")
+	}
+	return a, nil
+}
+
+// Clicked queues the link for a check without blocking
+func (a *AutoRenewer) Clicked(shortUrl string) {
+	select {
+	case a.clicks <- shortUrl:
+	default:
+		droppedClicks.Inc()
+	}
+}
+
+// Run checks the clicked links until the context is cancelled
+func (a *AutoRenewer) Run(ctx context.Context) {
+	prune := time.NewTicker(a.checkEvery)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-prune.C:
+			for shortUrl, at := range a.checked {
+				if a.now().Sub(at) >= a.checkEvery {
+					delete(a.checked, shortUrl)
+				}
+			}
+		case shortUrl := <-a.clicks:
+			if at, ok := a.checked[shortUrl]; ok && a.now().Sub(at) < a.checkEvery {
+				continue
+			}
+			a.checked[shortUrl] = a.now()
+			if err := a.check(ctx, shortUrl); err != nil && ctx.Err() == nil {
+				a.log.Error().
+					Err(err).
+					Str("short_url", shortUrl).
+					Msg("couldn't auto-renew link")
+			}
+		}
+	}
+}
+
+func (a *AutoRenewer) check(ctx context.Context, shortUrl string) error {
+	l, err := a.urls.Link(ctx, shortUrl)
+	if err != nil {
+		return err
+	}
+	now := a.now()
+	if !a.policy.AutoRenewDue(l, now) {
+		return nil
+	}
+
+	expiration, err := a.policy.Extend(l, a.policy.AutoRenewBy, now)
+	if errors.Is(err, ErrMaxLifetime) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.urls.Renew(ctx, shortUrl, expiration, true); err != nil {
+		return err
+	}
+	autoRenewed.Inc()
+	a.log.Info().
+		Str("short_url", shortUrl).
+		Time("expiration_date", expiration).
+		Msg("auto-renewed link")
+	return nil
+}
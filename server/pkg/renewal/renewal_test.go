@@ -0,0 +1,183 @@
+package renewal
+
+import (
+	"context"
+	"shortener/pkg/domain"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+const day = 24 * time.Hour
+
+func policy() Policy {
+	return Policy{
+		MaxLifetime: map[domain.Plan]time.Duration{
+			domain.PlanFree: 100 * day,
+			domain.PlanPro:  1000 * day,
+		},
+		AutoRenewWithin: 7 * day,
+		AutoRenewBy:     30 * day,
+	}
+}
+
+func TestExtend(t *testing.T) {
+	for _, data := range []struct {
+		Name       string
+		Plan       domain.Plan
+		Expiration time.Time
+		By         time.Duration
+		Expected   time.Time
+		Err        error
+	}{
+		{
+			Name:       "from expiration date",
+			Plan:       domain.PlanFree,
+			Expiration: now.Add(10 * day),
+			By:         30 * day,
+			Expected:   now.Add(40 * day),
+		},
+		{
+			Name:       "expired link from now",
+			Plan:       domain.PlanFree,
+			Expiration: now.Add(-10 * day),
+			By:         30 * day,
+			Expected:   now.Add(30 * day),
+		},
+		{
+			Name:       "cut down to max lifetime",
+			Plan:       domain.PlanFree,
+			Expiration: now.Add(90 * day),
+			By:         30 * day,
+			Expected:   now.Add(100 * day),
+		},
+		{
+			Name:       "pro plan",
+			Plan:       domain.PlanPro,
+			Expiration: now.Add(90 * day),
+			By:         30 * day,
+			Expected:   now.Add(120 * day),
+		},
+		{
+			Name:       "unknown plan limited as free",
+			Plan:       "legacy",
+			Expiration: now.Add(90 * day),
+			By:         30 * day,
+			Expected:   now.Add(100 * day),
+		},
+		{
+			Name:       "at max lifetime",
+			Plan:       domain.PlanFree,
+			Expiration: now.Add(100 * day),
+			By:         30 * day,
+			Err:        ErrMaxLifetime,
+		},
+	} {
+		t.Run(data.Name, func(t *testing.T) {
+			l := &domain.Link{Plan: data.Plan, ExpirationDate: data.Expiration}
+			expiration, err := policy().Extend(l, data.By, now)
+			assert.ErrorIs(t, err, data.Err)
+			assert.Equal(t, data.Expected, expiration)
+		})
+	}
+}
+
+func TestAutoRenewDue(t *testing.T) {
+	p := policy()
+	assert.True(t, p.AutoRenewDue(&domain.Link{AutoRenew: true, ExpirationDate: now.Add(day)}, now))
+	assert.False(t, p.AutoRenewDue(&domain.Link{ExpirationDate: now.Add(day)}, now))
+	assert.False(t, p.AutoRenewDue(&domain.Link{AutoRenew: true, ExpirationDate: now.Add(10 * day)}, now))
+	assert.False(t, p.AutoRenewDue(&domain.Link{AutoRenew: true, ExpirationDate: now.Add(-day)}, now))
+}
+
+func newAutoRenewer(t *testing.T, u Urls) *AutoRenewer {
+	log := zerolog.Nop()
+	a, err := NewAutoRenewer(
+		WithUrls(u),
+		WithPolicy(policy()),
+		WithLogger(&log),
+	)
+	assert.Nil(t, err)
+	a.now = func() time.Time { return now }
+	return a
+}
+
+func TestAutoRenewerRenewsDueLink(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().Link(mock.Anything, "abcde").Return(&domain.Link{
+		ShortUrl:       "abcde",
+		AutoRenew:      true,
+		ExpirationDate: now.Add(day),
+		Plan:           domain.PlanFree,
+	}, nil)
+	u.EXPECT().Renew(mock.Anything, "abcde", now.Add(31*day), true).Return(nil)
+
+	a := newAutoRenewer(t, u)
+	assert.Nil(t, a.check(context.TODO(), "abcde"))
+}
+
+func TestAutoRenewerSkipsLinkNotDue(t *testing.T) {
+	u := NewMockUrls(t)
+	u.EXPECT().Link(mock.Anything, "abcde").Return(&domain.Link{
+		ShortUrl:       "abcde",
+		AutoRenew:      true,
+		ExpirationDate: now.Add(30 * day),
+		Plan:           domain.PlanFree,
+	}, nil)
+
+	a := newAutoRenewer(t, u)
+	assert.Nil(t, a.check(context.TODO(), "abcde"))
+}
+
+func TestAutoRenewerChecksLinkOnce(t *testing.T) {
+	u := NewMockUrls(t)
+	checked := make(chan struct{}, 2)
+	u.EXPECT().Link(mock.Anything, "abcde").
+		Run(func(context.Context, string) { checked <- struct{}{} }).
+		Return(&domain.Link{ShortUrl: "abcde", ExpirationDate: now.Add(30 * day)}, nil).
+		Once()
+	u.EXPECT().Link(mock.Anything, "fghij").
+		Run(func(context.Context, string) { checked <- struct{}{} }).
+		Return(&domain.Link{ShortUrl: "fghij", ExpirationDate: now.Add(30 * day)}, nil).
+		Once()
+
+	a := newAutoRenewer(t, u)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Run(ctx)
+	}()
+
+	a.Clicked("abcde")
+	a.Clicked("abcde")
+	a.Clicked("fghij")
+	for range 2 {
+		select {
+		case <-checked:
+		case <-time.After(time.Second):
+			t.Fatal("clicks haven't been checked")
+		}
+	}
+	cancel()
+	<-done
+}
+
+func TestNewAutoRenewerValidatesOptions(t *testing.T) {
+	log := zerolog.Nop()
+	u := NewMockUrls(t)
+
+	_, err := NewAutoRenewer(WithLogger(&log))
+	assert.NotNil(t, err)
+	_, err = NewAutoRenewer(WithUrls(u))
+	assert.NotNil(t, err)
+	_, err = NewAutoRenewer(WithUrls(u), WithLogger(&log), WithPolicy(Policy{}))
+	assert.NotNil(t, err)
+	_, err = NewAutoRenewer(WithUrls(u), WithLogger(&log), WithCheckEvery(0))
+	assert.NotNil(t, err)
+}
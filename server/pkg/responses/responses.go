@@ -1,6 +1,7 @@
 package responses
 
 import (
+	"shortener/pkg/domain"
 	"time"
 )
 
@@ -13,6 +14,34 @@ type Shortener struct {
 	ShortUrl       string    `json:"short_url"`
 	LongUrl        string    `json:"long_url"`
 	ExpirationDate time.Time `json:"expiration_date"`
+	// empty for links outside of workspaces
+	WorkspaceId string `json:"workspace_id,omitempty"`
+	// unique id of the message. Tells redelivered messages from conflicts
+	IdempotencyKey string   `json:"idempotency_key,omitempty"`
+	Title          string   `json:"title,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+}
+
+func (s *Shortener) GetIdempotencyKey() string {
+	return s.IdempotencyKey
+}
+
+// History is a page of links. NextCursor is empty on the last page.
+type History struct {
+	Links      []*domain.UrlInfo `json:"links"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// Renewal is the state of a link after it has been renewed
+type Renewal struct {
+	ShortUrl       string    `json:"short_url"`
+	ExpirationDate time.Time `json:"expiration_date"`
+	AutoRenew      bool      `json:"auto_renew"`
+}
+
+// MovedLinks lists the links that have been moved into a workspace
+type MovedLinks struct {
+	Moved []string `json:"moved"`
 }
 
 type Authenticator struct {
@@ -20,4 +49,49 @@ type Authenticator struct {
 	Name           string `json:"name"`
 	Email          string `json:"email"`
 	HashedPassword string `json:"hashed_password"`
+	// unique id of the message. Tells redelivered messages from conflicts
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+func (a *Authenticator) GetIdempotencyKey() string {
+	return a.IdempotencyKey
+}
+
+const StorageStatusConflict = "conflict"
+
+// StorageResult is sent by the Storage service to the result topic when a
+// message couldn't be stored because of a conflict with existing data
+type StorageResult struct {
+	IdempotencyKey string `json:"idempotency_key"`
+	// topic the message has been consumed from
+	Topic  string `json:"topic"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+type MfaRequired struct {
+	Message  string `json:"message"`
+	MfaToken string `json:"mfa_token"`
+}
+
+type MfaEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauth_uri"`
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+const (
+	HealthStatusOk          = "ok"
+	HealthStatusUnavailable = "unavailable"
+	HealthStatusDraining    = "draining"
+)
+
+// Health is the result of a readiness probe. Checks hold "ok" or the error
+// of every dependency.
+type Health struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
 }
@@ -0,0 +1,78 @@
+// Package shutdown stops HTTP servers gracefully. Once the service is told
+// to stop, readiness probes fail first, so that load balancers stop sending
+// requests, then the server stops accepting connections and waits for
+// in-flight requests. Producers and pools are closed by the caller after
+// Serve returns, when no request may use them anymore.
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Drainer fails readiness probes of the service
+type Drainer interface {
+	Drain()
+}
+
+// Serve serves until ctx is done and the server is shut down. Requests still
+// in flight after the timeout are interrupted.
+func Serve(
+	ctx context.Context,
+	server *http.Server,
+	d Drainer,
+	drainDelay time.Duration,
+	timeout time.Duration,
+) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	d.Drain()
+	select {
+	case err := <-served:
+		return err
+	case <-time.After(drainDelay):
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+		return fmt.Errorf("in-flight requests haven't finished: %w", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// StopGRPC stops the gRPC server gracefully, waiting for in-flight calls
+// until the timeout. Calls still running after it are cancelled.
+func StopGRPC(s *grpc.Server, timeout time.Duration) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-time.After(timeout):
+		s.Stop()
+		return errors.New("in-flight calls haven't finished in time")
+	}
+}
@@ -0,0 +1,110 @@
+package shutdown
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type drainer struct {
+	drained atomic.Bool
+}
+
+func (d *drainer) Drain() {
+	d.drained.Store(true)
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+// serve starts serving the handler and waits for a request in flight
+func serve(
+	t *testing.T,
+	ctx context.Context,
+	handler http.HandlerFunc,
+	d Drainer,
+	timeout time.Duration,
+) (chan error, chan error) {
+	t.Helper()
+	addr := freeAddr(t)
+	received := make(chan struct{})
+	server := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(received)
+			handler(w, r)
+		}),
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, server, d, 10*time.Millisecond, timeout)
+	}()
+
+	// the server listens in the background
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, time.Second, 5*time.Millisecond)
+
+	requested := make(chan error, 1)
+	go func() {
+		res, err := http.Get("http://" + addr)
+		if err == nil {
+			res.Body.Close()
+		}
+		requested <- err
+	}()
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("request hasn't been received")
+	}
+	return served, requested
+}
+
+func TestServeWaitsForInFlightRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	d := &drainer{}
+	served, requested := serve(t, ctx, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}, d, time.Second)
+
+	cancel()
+	select {
+	case <-served:
+		t.Fatal("server has stopped before the request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.True(t, d.drained.Load())
+
+	close(release)
+	require.NoError(t, <-served)
+	require.NoError(t, <-requested)
+}
+
+func TestServeTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	served, requested := serve(t, ctx, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}, &drainer{}, 10*time.Millisecond)
+
+	cancel()
+	require.ErrorIs(t, <-served, context.DeadlineExceeded)
+	require.Error(t, <-requested)
+}
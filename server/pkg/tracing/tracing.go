@@ -0,0 +1,203 @@
+// Package tracing sets up OpenTelemetry tracing of the services. Spans are
+// exported over OTLP or printed to stdout for local use. Trace context is
+// propagated with W3C headers: over HTTP, in gRPC metadata and in headers of
+// bus messages.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"shortener/pkg/bus"
+
+	"github.com/exaring/otelpgx"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+var tracer = otel.Tracer("shortener/pkg/tracing")
+
+// Setup installs the global tracer provider of the service. Spans are sent
+// to the OTLP gRPC collector at the endpoint, printed to stdout or dropped,
+// depending on the exporter. The returned function flushes pending spans.
+func Setup(
+	ctx context.Context,
+	service string,
+	exporter string,
+	endpoint string,
+) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exp sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		exp, err = otlptracegrpc.New(
+			ctx,
+			otlptracegrpc.WithEndpoint(endpoint),
+			otlptracegrpc.WithInsecure(),
+		)
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(service),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Handler starts a span for every request handled by h. Spans are named
+// after the route of the mux the request matches.
+func Handler(h http.Handler, mux *http.ServeMux) http.Handler {
+	return otelhttp.NewHandler(
+		h,
+		"http",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			if _, route := mux.Handler(r); route != "" {
+				return route
+			}
+			return r.Method
+		}),
+	)
+}
+
+// PoolConfig parses the dsn into a pgx pool config that traces queries
+func PoolConfig(dsn string) (*pgxpool.Config, error) {
+	conf, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	conf.ConnConfig.Tracer = otelpgx.NewTracer()
+	return conf, nil
+}
+
+// Inject writes the trace context of ctx into the headers, e.g. of a bus
+// message
+func Inject(ctx context.Context, headers map[string]string) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(headers))
+}
+
+// Extract returns ctx with the trace context of the message
+func Extract(ctx context.Context, m *bus.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier{m})
+}
+
+// headerCarrier reads headers of a consumed message case insensitively
+type headerCarrier struct {
+	m *bus.Message
+}
+
+func (c headerCarrier) Get(key string) string {
+	return c.m.Header(key)
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	if c.m.Headers == nil {
+		c.m.Headers = make(map[string]string)
+	}
+	c.m.Headers[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.m.Headers))
+	for k := range c.m.Headers {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Publisher starts a producer span for every message and passes its
+// context in the message headers
+type Publisher struct {
+	publisher bus.Publisher
+}
+
+func NewPublisher(p bus.Publisher) *Publisher {
+	return &Publisher{publisher: p}
+}
+
+// Publish continues the trace the message carries already, e.g. one stored
+// in the outbox with the event, or else the trace of ctx
+func (p *Publisher) Publish(ctx context.Context, mm ...*bus.Message) error {
+	spans := make([]trace.Span, len(mm))
+	traced := make([]*bus.Message, len(mm))
+	for i, m := range mm {
+		spanCtx, span := tracer.Start(
+			Extract(ctx, m),
+			m.Topic+" publish",
+			trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(
+				semconv.MessagingDestinationName(m.Topic),
+				attribute.Int("messaging.message.body.size", len(m.Value)),
+			),
+		)
+		spans[i] = span
+
+		// messages belong to the caller
+		c := *m
+		c.Headers = make(map[string]string, len(m.Headers)+2)
+		for k, v := range m.Headers {
+			c.Headers[k] = v
+		}
+		Inject(spanCtx, c.Headers)
+		traced[i] = &c
+	}
+
+	err := p.publisher.Publish(ctx, traced...)
+	for i, span := range spans {
+		if err := messageError(err, i); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+	return err
+}
+
+func messageError(err error, i int) error {
+	if errs, ok := err.(bus.PublishErrors); ok {
+		return errs[i]
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"shortener/pkg/bus"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type recordingPublisher struct {
+	published []*bus.Message
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, mm ...*bus.Message) error {
+	p.published = append(p.published, mm...)
+	return nil
+}
+
+// the package tracer delegates to the first global provider, so it's shared
+// by the tests
+var rec = tracetest.NewSpanRecorder()
+
+func TestMain(m *testing.M) {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	os.Exit(m.Run())
+}
+
+// publishSpan returns the publishing span of the trace
+func publishSpan(t *testing.T, traceId trace.TraceID) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, s := range rec.Ended() {
+		if s.Name() == "urls publish" && s.SpanContext().TraceID() == traceId {
+			return s
+		}
+	}
+	t.Fatal("no publish span of the trace")
+	return nil
+}
+
+func TestPublisherContinuesTrace(t *testing.T) {
+	tr := otel.Tracer("test")
+
+	ctx, request := tr.Start(context.Background(), "request")
+	p := &recordingPublisher{}
+	m := &bus.Message{Topic: "urls", Headers: map[string]string{"content-type": "x"}}
+	require.NoError(t, NewPublisher(p).Publish(ctx, m))
+	request.End()
+
+	// the message of the caller isn't changed
+	require.Len(t, m.Headers, 1)
+
+	require.Len(t, p.published, 1)
+	published := p.published[0]
+	require.Equal(t, "x", published.Header("content-type"))
+
+	consumed := trace.SpanContextFromContext(Extract(context.Background(), published))
+	require.Equal(t, request.SpanContext().TraceID(), consumed.TraceID())
+
+	span := publishSpan(t, request.SpanContext().TraceID())
+	require.Equal(t, request.SpanContext().SpanID(), span.Parent().SpanID())
+	require.Equal(t, span.SpanContext().SpanID(), consumed.SpanID())
+}
+
+func TestPublisherPrefersMessageTrace(t *testing.T) {
+	tr := otel.Tracer("test")
+
+	// e.g. an event of the outbox stored while handling a request
+	requestCtx, request := tr.Start(context.Background(), "request")
+	headers := map[string]string{}
+	Inject(requestCtx, headers)
+	request.End()
+
+	relayCtx, relay := tr.Start(context.Background(), "relay")
+	p := &recordingPublisher{}
+	err := NewPublisher(p).Publish(relayCtx, &bus.Message{Topic: "urls", Headers: headers})
+	require.NoError(t, err)
+	relay.End()
+
+	span := publishSpan(t, request.SpanContext().TraceID())
+	require.Equal(t, request.SpanContext().SpanID(), span.Parent().SpanID())
+}